@@ -31,7 +31,8 @@ var (
 )
 
 const helpMessage = `
-dvid-backup does a cold backup of a local leveldb storage engine.
+dvid-backup does a cold backup of a local leveldb storage engine.  For online backups of
+a running server, use the "backup" command of dvid and rebuild with "dvid restore".
 
 Usage: dvid-backup [options] <database directory> <backup directory>
 
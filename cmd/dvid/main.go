@@ -1,5 +1,5 @@
 // Command-line interface to a remote DVID server.
-// Provides essential commands on top of core http server: init, serve, repair.
+// Provides essential commands on top of core http server: init, serve, repair, restore.
 
 package main
 
@@ -13,6 +13,7 @@ import (
 	"os/signal"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -99,6 +100,14 @@ For storage engines that have repair ability (e.g., basholeveldb):
         The <engine name> refers to the name of the engine: "basholeveldb", "kvautobus", etc.
         The <database path> is the file path to the directory.
 
+To rebuild a server from an online backup made with the "backup" command:
+
+    restore <configuration path> <backup directory> [<backup point ID>]
+
+        Restores the stores in the configuration from the backup point with the highest ID
+        at or below the given ID, or the latest point if none is given.  A point's ID is
+        the highest mutation ID issued when it was taken.  Stores must be empty.
+
 To get help for a remote DVID server:
 
     help server
@@ -212,6 +221,8 @@ func DoCommand(cmd dvid.Command) error {
 		return DoServe(cmd)
 	case "repair":
 		return DoRepair(cmd)
+	case "restore":
+		return DoRestore(cmd)
 	case "about":
 		fmt.Println(server.About())
 	// Send everything else to server via DVID terminal
@@ -243,6 +254,41 @@ func DoRepair(cmd dvid.Command) error {
 	return nil
 }
 
+// DoRestore performs the "restore" command, rebuilding the stores of a server from
+// a backup point.
+func DoRestore(cmd dvid.Command) error {
+	configPath := cmd.Argument(1)
+	dir := cmd.Argument(2)
+	if dir == "" {
+		return fmt.Errorf("restore command must be followed by configuration path and backup directory")
+	}
+	var id uint64
+	if idStr := cmd.Argument(3); idStr != "" {
+		var err error
+		if id, err = strconv.ParseUint(idStr, 10, 64); err != nil {
+			return fmt.Errorf("bad backup point ID %q: %v", idStr, err)
+		}
+	}
+	if err := server.LoadConfig(configPath); err != nil {
+		return fmt.Errorf("error loading configuration file %q: %v", configPath, err)
+	}
+	backend, err := server.GetBackend()
+	if err != nil {
+		return err
+	}
+	if _, err := storage.Initialize(cmd.Settings(), backend); err != nil {
+		return fmt.Errorf("unable to initialize storage: %v", err)
+	}
+	defer storage.Shutdown()
+
+	manifest, err := datastore.Restore(dir, id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d stores from backup point %d taken %s.\n", len(manifest.Stores), manifest.ID, manifest.Created)
+	return nil
+}
+
 // DoServe opens a datastore then creates both web and rpc servers for the datastore
 func DoServe(cmd dvid.Command) error {
 	// Capture ctrl+c and other interrupts.  Then handle graceful shutdown.
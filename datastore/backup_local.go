@@ -0,0 +1,1034 @@
+// +build !clustered,!gcloud
+
+/*
+	This file supports online backups of all stores and point-in-time restores.
+
+	A backup directory holds a series of backup points, each in a subdirectory named by its
+	ID, which is the highest mutation ID issued across repos when the point was taken.  The
+	snapshots of all stores are taken while issuing of mutation IDs is paused, so a point
+	holds the metadata and data as of those mutation IDs.  Each store's snapshot is
+	consistent, although a write in flight during the brief pause may only be captured in
+	some stores.  Key-value stores whose engine cannot provide snapshots are read while live
+	and are marked as inconsistent in the point's manifest.  Log stores, which hold data
+	like labelmap mappings, are append-only, so they are snapshotted by the length of each
+	log.  A backup fails if a store can't be backed up.
+
+	The first point in a backup directory holds every key-value of each store.  Later points
+	are incremental and only hold the key-values that were added, changed, or deleted since
+	the previous point.  Each point also has an index of the keys and value hashes in each
+	store, so the next point is computed by streaming through the index alongside the store
+	snapshot.  For log stores, later points only hold the messages appended since the
+	previous point, and the index holds the length and a hash of the first message of each
+	log, which detects logs that were deleted and rewritten.  A restore applies the points
+	from the first up to the chosen one.
+*/
+
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+const (
+	backupManifestFile = "manifest.json"
+	backupTmpSuffix    = ".tmp"
+
+	// operations in a backup file of changed key-values or log messages
+	backupPut       byte = 1
+	backupDelete    byte = 2
+	backupLogAppend byte = 3
+	backupLogDelete byte = 4
+
+	backupHashSize = 16
+
+	// maximum number of log messages read at a time.
+	backupLogPageSize = 1000
+)
+
+// all keys begin with a prefix byte well below 0xFF.
+var (
+	backupMinKey = storage.Key{0}
+	backupMaxKey = storage.Key{0xFF}
+)
+
+// BackupManifest describes a backup point.
+type BackupManifest struct {
+	ID          uint64               // highest mutation ID issued across repos when taken
+	Parent      uint64               // previous point in the backup directory or 0 if a full backup
+	Created     time.Time            // time at which store snapshots were taken
+	MutationIDs map[dvid.UUID]uint64 // next mutation ID for each repo, keyed by root UUID
+	Stores      []BackupStore
+}
+
+// BackupStore describes the backup of one store in a backup point.
+type BackupStore struct {
+	Alias       storage.Alias
+	Description string
+	Log         bool   `json:",omitempty"` // true for a log store
+	Consistent  bool   // false if the engine has no snapshots and the store was read while live
+	KeyValues   uint64 // number of key-value pairs in the store
+	Puts        uint64 // key-values added or changed since the previous point
+	Logs        uint64 `json:",omitempty"` // number of logs in a log store
+	Messages    uint64 `json:",omitempty"` // log messages appended since the previous point
+	Deletes     uint64 // keys or logs deleted since the previous point
+	Bytes       uint64 // bytes of keys and values or messages added since the previous point
+}
+
+func (s BackupStore) kvsFile() string {
+	if s.Log {
+		return url.PathEscape(string(s.Alias)) + ".logs.gz"
+	}
+	return url.PathEscape(string(s.Alias)) + ".kvs.gz"
+}
+
+func (s BackupStore) indexFile() string {
+	return url.PathEscape(string(s.Alias)) + ".index.gz"
+}
+
+func (m *BackupManifest) store(alias storage.Alias) (BackupStore, bool) {
+	for _, s := range m.Stores {
+		if s.Alias == alias {
+			return s, true
+		}
+	}
+	return BackupStore{}, false
+}
+
+func backupPointDir(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d", id))
+}
+
+// BackupPoints returns the manifests of all backup points in a backup directory in
+// order of increasing ID.
+func BackupPoints(dir string) ([]*BackupManifest, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var points []*BackupManifest
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := strconv.ParseUint(entry.Name(), 10, 64); err != nil {
+			continue // skips partially written points
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name(), backupManifestFile))
+		if err != nil {
+			return nil, fmt.Errorf("bad backup point %s: %v", entry.Name(), err)
+		}
+		manifest := new(BackupManifest)
+		if err := json.Unmarshal(data, manifest); err != nil {
+			return nil, fmt.Errorf("bad manifest for backup point %s: %v", entry.Name(), err)
+		}
+		points = append(points, manifest)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].ID < points[j].ID })
+	return points, nil
+}
+
+// BackupStatus describes the current or last backup of this server.
+type BackupStatus struct {
+	Running       bool
+	Dir           string          `json:",omitempty"`
+	ID            uint64          `json:",omitempty"` // ID of the point being written
+	Started       time.Time       `json:",omitempty"`
+	LastBackup    *BackupManifest `json:",omitempty"`
+	LastError     string          `json:",omitempty"`
+	LastErrorTime time.Time       `json:",omitempty"`
+}
+
+var backups struct {
+	sync.Mutex
+	status BackupStatus
+}
+
+// GetBackupStatus returns the status of the current or last backup.
+func GetBackupStatus() BackupStatus {
+	backups.Lock()
+	defer backups.Unlock()
+	return backups.status
+}
+
+// backupSource is a point-in-time view of a store.
+type backupSource struct {
+	BackupStore
+	snapshot storage.KeyValueSnapshot // nil for a log store
+
+	log     storage.OffsetReadLog
+	logEnds map[string]int64 // size of each log when snapshotted
+}
+
+// liveSnapshot reads a store without snapshot support while it is live.
+type liveSnapshot struct {
+	db storage.OrderedKeyValueGetter
+}
+
+func (s liveSnapshot) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	return s.db.RawRangeQuery(kStart, kEnd, keysOnly, out, cancel)
+}
+
+func (s liveSnapshot) Release() {}
+
+// Backup is an online backup whose store snapshots have been taken but not yet written.
+type Backup struct {
+	dir      string
+	manifest *BackupManifest
+	sources  []backupSource
+}
+
+// NewBackup snapshots all stores for a new point in the backup directory, which is
+// incremental unless full is true or there are no earlier points.  The snapshots are
+// written by calling Write.  Only one backup can be in progress at a time.
+func NewBackup(dir string, full bool) (*Backup, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("no backup directory given")
+	}
+	backups.Lock()
+	defer backups.Unlock()
+	if backups.status.Running {
+		return nil, fmt.Errorf("backup %d to %s is already in progress", backups.status.ID, backups.status.Dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	points, err := BackupPoints(dir)
+	if err != nil {
+		return nil, err
+	}
+	stores, err := storage.AllStores()
+	if err != nil {
+		return nil, err
+	}
+	aliases := make([]string, 0, len(stores))
+	for alias := range stores {
+		aliases = append(aliases, string(alias))
+	}
+	sort.Strings(aliases)
+
+	b := &Backup{
+		dir:      dir,
+		manifest: &BackupManifest{MutationIDs: make(map[dvid.UUID]uint64)},
+	}
+	if len(points) != 0 && !full {
+		b.manifest.Parent = points[len(points)-1].ID
+	}
+
+	// Pause issuing of mutation IDs while snapshotting all stores.
+	unpause := b.pauseMutations()
+	b.manifest.Created = time.Now()
+	for _, aliasStr := range aliases {
+		alias := storage.Alias(aliasStr)
+		src := backupSource{BackupStore: BackupStore{Alias: alias}}
+		switch db := stores[alias].(type) {
+		case storage.KeyValueSnapshotter:
+			if src.snapshot, err = db.NewSnapshot(); err != nil {
+				unpause()
+				b.release()
+				return nil, fmt.Errorf("unable to snapshot store %q: %v", alias, err)
+			}
+			src.Consistent = true
+		case storage.OrderedKeyValueGetter:
+			src.snapshot = liveSnapshot{db}
+		case storage.LogSizer:
+			log, ok := db.(storage.OffsetReadLog)
+			if !ok {
+				unpause()
+				b.release()
+				return nil, fmt.Errorf("log store %q can't be read by offset for backup", alias)
+			}
+			if src.logEnds, err = db.TopicSizes(); err != nil {
+				unpause()
+				b.release()
+				return nil, fmt.Errorf("unable to get log sizes of store %q: %v", alias, err)
+			}
+			src.log = log
+			src.Log = true
+			src.Consistent = true
+		default:
+			unpause()
+			b.release()
+			return nil, fmt.Errorf("store %q (%s) can't be backed up", alias, stores[alias])
+		}
+		src.Description = stores[alias].String()
+		b.sources = append(b.sources, src)
+	}
+	unpause()
+
+	var maxID uint64
+	for _, mutID := range b.manifest.MutationIDs {
+		if mutID > maxID {
+			maxID = mutID
+		}
+	}
+	b.manifest.ID = maxID
+	if len(points) != 0 && points[len(points)-1].ID >= maxID {
+		b.manifest.ID = points[len(points)-1].ID + 1
+	}
+	backups.status = BackupStatus{
+		Running:    true,
+		Dir:        dir,
+		ID:         b.manifest.ID,
+		Started:    time.Now(),
+		LastBackup: backups.status.LastBackup,
+	}
+	return b, nil
+}
+
+// pauseMutations blocks issuing of new mutation IDs in every repo, recording the next
+// mutation ID of each, until the returned function is called.
+func (b *Backup) pauseMutations() func() {
+	manager.repoMutex.RLock()
+	repoMap := make(map[dvid.UUID]*repoT)
+	for _, r := range manager.repos {
+		repoMap[r.uuid] = r
+	}
+	manager.repoMutex.RUnlock()
+	repos := make([]*repoT, 0, len(repoMap))
+	for _, r := range repoMap {
+		repos = append(repos, r)
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].uuid < repos[j].uuid })
+	for _, r := range repos {
+		r.mutMu.Lock()
+		b.manifest.MutationIDs[r.uuid] = r.mutCurID
+	}
+	return func() {
+		for _, r := range repos {
+			r.mutMu.Unlock()
+		}
+	}
+}
+
+func (b *Backup) release() {
+	for _, src := range b.sources {
+		if src.snapshot != nil {
+			src.snapshot.Release()
+		}
+	}
+	b.sources = nil
+}
+
+// ID returns the ID of the backup point.
+func (b *Backup) ID() uint64 {
+	return b.manifest.ID
+}
+
+// Write writes the snapshots of all stores to the backup point and releases them.
+func (b *Backup) Write() (*BackupManifest, error) {
+	manifest, err := b.write()
+	b.release()
+	backups.Lock()
+	backups.status.Running = false
+	if err != nil {
+		backups.status.LastError = err.Error()
+		backups.status.LastErrorTime = time.Now()
+	} else {
+		backups.status.LastBackup = manifest
+	}
+	backups.Unlock()
+	return manifest, err
+}
+
+func (b *Backup) write() (*BackupManifest, error) {
+	t0 := time.Now()
+	pointDir := backupPointDir(b.dir, b.manifest.ID)
+	tmpDir := pointDir + backupTmpSuffix
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, err
+	}
+	var parentDir string
+	var parent *BackupManifest
+	if b.manifest.Parent != 0 {
+		parentDir = backupPointDir(b.dir, b.manifest.Parent)
+		data, err := ioutil.ReadFile(filepath.Join(parentDir, backupManifestFile))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read manifest of previous backup point %d: %v", b.manifest.Parent, err)
+		}
+		parent = new(BackupManifest)
+		if err := json.Unmarshal(data, parent); err != nil {
+			return nil, fmt.Errorf("bad manifest for previous backup point %d: %v", b.manifest.Parent, err)
+		}
+	}
+	for _, src := range b.sources {
+		var parentIndex string
+		if parent != nil {
+			if ps, found := parent.store(src.Alias); found && ps.Log == src.Log {
+				parentIndex = filepath.Join(parentDir, ps.indexFile())
+			}
+		}
+		dvid.Infof("Writing backup of store %q to %s...\n", src.Alias, tmpDir)
+		var stats BackupStore
+		var err error
+		if src.Log {
+			stats, err = backupLogStore(src, parentIndex, tmpDir)
+		} else {
+			stats, err = backupStore(src, parentIndex, tmpDir)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to backup store %q: %v", src.Alias, err)
+		}
+		b.manifest.Stores = append(b.manifest.Stores, stats)
+	}
+	data, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, backupManifestFile), data, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpDir, pointDir); err != nil {
+		return nil, err
+	}
+	dvid.TimeInfof("Wrote backup point %d to %s in %s\n", b.manifest.ID, pointDir, time.Since(t0))
+	return b.manifest, nil
+}
+
+// backupStore writes the changes in a store since the previous point, as given by its
+// index file, and the index of all the store's key-values.
+func backupStore(src backupSource, parentIndex, pointDir string) (stats BackupStore, err error) {
+	stats = src.BackupStore
+	kvsOut, err := createBackupFile(filepath.Join(pointDir, stats.kvsFile()))
+	if err != nil {
+		return
+	}
+	defer kvsOut.close()
+	indexOut, err := createBackupFile(filepath.Join(pointDir, stats.indexFile()))
+	if err != nil {
+		return
+	}
+	defer indexOut.close()
+
+	var prev *backupIndexReader
+	if parentIndex != "" {
+		if prev, err = openBackupIndex(parentIndex); err != nil {
+			return
+		}
+		defer prev.close()
+	}
+
+	ch := make(chan *storage.KeyValue, 1000)
+	cancel := make(chan struct{})
+	queryErr := make(chan error, 1)
+	go func() {
+		queryErr <- src.snapshot.RawRangeQuery(backupMinKey, backupMaxKey, false, ch, cancel)
+		close(ch)
+	}()
+	defer func() {
+		if err != nil {
+			close(cancel)
+		}
+		for range ch {
+		}
+		if qerr := <-queryErr; qerr != nil && err == nil {
+			err = qerr
+		}
+		if err == nil {
+			if err = kvsOut.close(); err == nil {
+				err = indexOut.close()
+			}
+		}
+	}()
+
+	deleted := func(k storage.Key) error {
+		stats.Deletes++
+		return kvsOut.writeDelete(k)
+	}
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		hash := backupHash(kv.V)
+		if err = indexOut.writeIndex(kv.K, hash); err != nil {
+			return
+		}
+		stats.KeyValues++
+		changed := true
+		if prev != nil {
+			if changed, err = prev.advance(kv.K, hash, deleted); err != nil {
+				return
+			}
+		}
+		if changed {
+			if err = kvsOut.writePut(kv.K, kv.V); err != nil {
+				return
+			}
+			stats.Puts++
+			stats.Bytes += uint64(len(kv.K) + len(kv.V))
+		}
+	}
+	if prev != nil {
+		_, err = prev.advance(nil, nil, deleted)
+	}
+	return
+}
+
+// backupLogIndex is the length and hash of the first message of a log in a backup point.
+type backupLogIndex struct {
+	end   int64
+	first []byte
+}
+
+// backupLogStore writes the messages appended to each log in a store since the previous
+// point, as given by its index file, and the index of all the store's logs.
+func backupLogStore(src backupSource, parentIndex, pointDir string) (stats BackupStore, err error) {
+	stats = src.BackupStore
+	prev := make(map[string]backupLogIndex)
+	if parentIndex != "" {
+		if prev, err = readBackupLogIndex(parentIndex); err != nil {
+			return
+		}
+	}
+	logsOut, err := createBackupFile(filepath.Join(pointDir, stats.kvsFile()))
+	if err != nil {
+		return
+	}
+	defer logsOut.close()
+	indexOut, err := createBackupFile(filepath.Join(pointDir, stats.indexFile()))
+	if err != nil {
+		return
+	}
+	defer indexOut.close()
+
+	topics := make([]string, 0, len(src.logEnds))
+	for topic := range src.logEnds {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		end := src.logEnds[topic]
+		var first []byte
+		msgs, _, err := src.log.TopicReadFrom(topic, 0, 1)
+		if err != nil {
+			return stats, err
+		}
+		if len(msgs) != 0 {
+			first = backupLogHash(msgs[0])
+		}
+
+		// Continue from the previous point unless the log was deleted and rewritten.
+		var offset int64
+		if p, found := prev[topic]; found {
+			delete(prev, topic)
+			if p.end <= end && bytes.Equal(p.first, first) {
+				offset = p.end
+			} else {
+				if err = logsOut.writeLogDelete(topic); err != nil {
+					return stats, err
+				}
+				stats.Deletes++
+			}
+		}
+	copyLog:
+		for offset < end {
+			msgs, ends, err := src.log.TopicReadFrom(topic, offset, backupLogPageSize)
+			if err != nil {
+				return stats, err
+			}
+			if len(msgs) == 0 {
+				break
+			}
+			for i, msg := range msgs {
+				if ends[i] > end {
+					break copyLog
+				}
+				if err = logsOut.writeLogAppend(topic, msg); err != nil {
+					return stats, err
+				}
+				offset = ends[i]
+				stats.Messages++
+				stats.Bytes += uint64(len(msg.Data))
+			}
+		}
+		if err = indexOut.writeLogIndex(topic, offset, first); err != nil {
+			return stats, err
+		}
+		stats.Logs++
+	}
+	deleted := make([]string, 0, len(prev))
+	for topic := range prev {
+		deleted = append(deleted, topic)
+	}
+	sort.Strings(deleted)
+	for _, topic := range deleted {
+		if err = logsOut.writeLogDelete(topic); err != nil {
+			return
+		}
+		stats.Deletes++
+	}
+	if err = logsOut.close(); err != nil {
+		return
+	}
+	err = indexOut.close()
+	return
+}
+
+func readBackupLogIndex(path string) (map[string]backupLogIndex, error) {
+	in, err := openBackupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.close()
+	index := make(map[string]backupLogIndex)
+	for {
+		topic, err := in.readBytes()
+		if err == io.EOF {
+			return index, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := binary.ReadUvarint(in.r)
+		if err != nil {
+			return nil, err
+		}
+		first, err := in.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		index[string(topic)] = backupLogIndex{end: int64(end), first: first}
+	}
+}
+
+func backupLogHash(msg storage.LogMessage) []byte {
+	h := fnv.New128a()
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], msg.EntryType)
+	h.Write(buf[:])
+	h.Write(msg.Data)
+	return h.Sum(nil)
+}
+
+func backupHash(v []byte) []byte {
+	h := fnv.New128a()
+	h.Write(v)
+	return h.Sum(nil)
+}
+
+// Restore rebuilds the stores of this server from the backup point with the highest ID
+// not exceeding the given ID, or the last point if id is 0.  Storage must be initialized
+// but not the datastore, and every store in the backup point must be configured and empty.
+func Restore(dir string, id uint64) (*BackupManifest, error) {
+	if manager != nil {
+		return nil, fmt.Errorf("cannot restore a backup while the datastore is running")
+	}
+	points, err := BackupPoints(dir)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint64]*BackupManifest, len(points))
+	var target *BackupManifest
+	for _, point := range points {
+		byID[point.ID] = point
+		if id == 0 || point.ID <= id {
+			target = point
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("no backup point at or before %d in %s", id, dir)
+	}
+
+	// Get the chain of points needed, from the full backup to the target point.
+	chain := []*BackupManifest{target}
+	for point := target; point.Parent != 0; {
+		parent, found := byID[point.Parent]
+		if !found {
+			return nil, fmt.Errorf("backup point %d needs missing previous point %d", point.ID, point.Parent)
+		}
+		chain = append([]*BackupManifest{parent}, chain...)
+		point = parent
+	}
+
+	// A store that was absent from a point was fully written in the next point it appears.
+	start := make(map[storage.Alias]int)
+	for i, point := range chain {
+		for _, s := range point.Stores {
+			if i == 0 {
+				start[s.Alias] = 0
+			} else if ps, found := chain[i-1].store(s.Alias); !found || ps.Log != s.Log {
+				start[s.Alias] = i
+			}
+		}
+	}
+
+	dbs := make(map[storage.Alias]storage.OrderedKeyValueDB, len(target.Stores))
+	logs := make(map[storage.Alias]storage.DeletableLog)
+	for _, s := range target.Stores {
+		store, err := storage.GetStoreByAlias(s.Alias)
+		if err != nil {
+			return nil, err
+		}
+		var empty bool
+		if s.Log {
+			log, ok := store.(storage.DeletableLog)
+			sizer, ok2 := store.(storage.LogSizer)
+			if !ok || !ok2 {
+				return nil, fmt.Errorf("store %q is not a log store that can be restored", s.Alias)
+			}
+			sizes, err := sizer.TopicSizes()
+			if err != nil {
+				return nil, err
+			}
+			empty = len(sizes) == 0
+			logs[s.Alias] = log
+		} else {
+			db, ok := store.(storage.OrderedKeyValueDB)
+			if !ok {
+				return nil, fmt.Errorf("store %q is not an ordered key-value store", s.Alias)
+			}
+			if empty, err = backupStoreEmpty(db); err != nil {
+				return nil, err
+			}
+			dbs[s.Alias] = db
+		}
+		if !empty {
+			return nil, fmt.Errorf("store %q must be empty to restore backup", s.Alias)
+		}
+	}
+	for i, point := range chain {
+		pointDir := backupPointDir(dir, point.ID)
+		for _, s := range point.Stores {
+			if i < start[s.Alias] {
+				continue
+			}
+			path := filepath.Join(pointDir, s.kvsFile())
+			var err error
+			if log, found := logs[s.Alias]; found && s.Log {
+				dvid.Infof("Restoring log store %q from backup point %d...\n", s.Alias, point.ID)
+				err = restoreLogStore(log, path)
+			} else if db, found := dbs[s.Alias]; found && !s.Log {
+				dvid.Infof("Restoring store %q from backup point %d...\n", s.Alias, point.ID)
+				err = restoreStore(db, path)
+			} else {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to restore store %q from backup point %d: %v", s.Alias, point.ID, err)
+			}
+		}
+	}
+	return target, nil
+}
+
+func backupStoreEmpty(db storage.OrderedKeyValueDB) (bool, error) {
+	ch := make(chan *storage.KeyValue, 1)
+	cancel := make(chan struct{})
+	queryErr := make(chan error, 1)
+	go func() {
+		queryErr <- db.RawRangeQuery(backupMinKey, backupMaxKey, true, ch, cancel)
+		close(ch)
+	}()
+	kv := <-ch
+	close(cancel)
+	for range ch {
+	}
+	if err := <-queryErr; err != nil {
+		return false, err
+	}
+	return kv == nil, nil
+}
+
+func restoreStore(db storage.OrderedKeyValueDB, path string) error {
+	in, err := openBackupFile(path)
+	if err != nil {
+		return err
+	}
+	defer in.close()
+	for {
+		op, err := in.r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		k, err := in.readBytes()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case backupPut:
+			v, err := in.readBytes()
+			if err != nil {
+				return err
+			}
+			if err := db.RawPut(k, v); err != nil {
+				return err
+			}
+		case backupDelete:
+			if err := db.RawDelete(k); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("bad operation %d in backup file %s", op, path)
+		}
+	}
+}
+
+func restoreLogStore(log storage.DeletableLog, path string) error {
+	in, err := openBackupFile(path)
+	if err != nil {
+		return err
+	}
+	defer in.close()
+	for {
+		op, err := in.r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		topic, err := in.readBytes()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case backupLogAppend:
+			entryType, err := binary.ReadUvarint(in.r)
+			if err != nil {
+				return err
+			}
+			data, err := in.readBytes()
+			if err != nil {
+				return err
+			}
+			if err := log.TopicAppend(string(topic), storage.LogMessage{EntryType: uint16(entryType), Data: data}); err != nil {
+				return err
+			}
+		case backupLogDelete:
+			if err := log.TopicDelete(string(topic)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("bad operation %d in backup file %s", op, path)
+		}
+	}
+}
+
+// ---- backup file encoding: gzipped records of uvarint-prefixed byte slices ----
+
+type backupFileWriter struct {
+	f      *os.File
+	gz     *gzip.Writer
+	w      *bufio.Writer
+	closed bool
+}
+
+func createBackupFile(path string) (*backupFileWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	return &backupFileWriter{f: f, gz: gz, w: bufio.NewWriter(gz)}, nil
+}
+
+func (out *backupFileWriter) writeBytes(b []byte) error {
+	if err := out.writeUvarint(uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := out.w.Write(b)
+	return err
+}
+
+func (out *backupFileWriter) writePut(k storage.Key, v []byte) error {
+	if err := out.w.WriteByte(backupPut); err != nil {
+		return err
+	}
+	if err := out.writeBytes(k); err != nil {
+		return err
+	}
+	return out.writeBytes(v)
+}
+
+func (out *backupFileWriter) writeDelete(k storage.Key) error {
+	if err := out.w.WriteByte(backupDelete); err != nil {
+		return err
+	}
+	return out.writeBytes(k)
+}
+
+func (out *backupFileWriter) writeIndex(k storage.Key, hash []byte) error {
+	if err := out.writeBytes(k); err != nil {
+		return err
+	}
+	_, err := out.w.Write(hash)
+	return err
+}
+
+func (out *backupFileWriter) writeUvarint(x uint64) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	_, err := out.w.Write(buf[:n])
+	return err
+}
+
+func (out *backupFileWriter) writeLogAppend(topic string, msg storage.LogMessage) error {
+	if err := out.w.WriteByte(backupLogAppend); err != nil {
+		return err
+	}
+	if err := out.writeBytes([]byte(topic)); err != nil {
+		return err
+	}
+	if err := out.writeUvarint(uint64(msg.EntryType)); err != nil {
+		return err
+	}
+	return out.writeBytes(msg.Data)
+}
+
+func (out *backupFileWriter) writeLogDelete(topic string) error {
+	if err := out.w.WriteByte(backupLogDelete); err != nil {
+		return err
+	}
+	return out.writeBytes([]byte(topic))
+}
+
+func (out *backupFileWriter) writeLogIndex(topic string, end int64, first []byte) error {
+	if err := out.writeBytes([]byte(topic)); err != nil {
+		return err
+	}
+	if err := out.writeUvarint(uint64(end)); err != nil {
+		return err
+	}
+	return out.writeBytes(first)
+}
+
+// close flushes and syncs the file.  Subsequent calls do nothing.
+func (out *backupFileWriter) close() error {
+	if out.closed {
+		return nil
+	}
+	out.closed = true
+	err := out.w.Flush()
+	if gzErr := out.gz.Close(); err == nil {
+		err = gzErr
+	}
+	if syncErr := out.f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := out.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+type backupFileReader struct {
+	f  *os.File
+	gz *gzip.Reader
+	r  *bufio.Reader
+}
+
+func openBackupFile(path string) (*backupFileReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &backupFileReader{f: f, gz: gz, r: bufio.NewReader(gz)}, nil
+}
+
+func (in *backupFileReader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(in.r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(in.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (in *backupFileReader) close() {
+	in.gz.Close()
+	in.f.Close()
+}
+
+// backupIndexReader steps through the index of a previous backup point.
+type backupIndexReader struct {
+	*backupFileReader
+	key  storage.Key // current key or nil if index is exhausted
+	hash []byte
+}
+
+func openBackupIndex(path string) (*backupIndexReader, error) {
+	in, err := openBackupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx := &backupIndexReader{backupFileReader: in}
+	if err := idx.next(); err != nil {
+		in.close()
+		return nil, err
+	}
+	return idx, nil
+}
+
+func (idx *backupIndexReader) next() error {
+	k, err := idx.readBytes()
+	if err == io.EOF {
+		idx.key, idx.hash = nil, nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	hash := make([]byte, backupHashSize)
+	if _, err := io.ReadFull(idx.r, hash); err != nil {
+		return err
+	}
+	idx.key, idx.hash = k, hash
+	return nil
+}
+
+// advance moves past all indexed keys up to the given key, calling deleted for each
+// indexed key that precedes it, and returns true if the given key is new or has a changed
+// value.  A nil key advances through the rest of the index.
+func (idx *backupIndexReader) advance(k storage.Key, hash []byte, deleted func(storage.Key) error) (changed bool, err error) {
+	for idx.key != nil && (k == nil || bytes.Compare(idx.key, k) < 0) {
+		if err = deleted(idx.key); err != nil {
+			return
+		}
+		if err = idx.next(); err != nil {
+			return
+		}
+	}
+	if k == nil || idx.key == nil || !bytes.Equal(idx.key, k) {
+		return true, nil
+	}
+	changed = !bytes.Equal(idx.hash, hash)
+	err = idx.next()
+	return
+}
@@ -0,0 +1,285 @@
+// +build !clustered,!gcloud
+
+package datastore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func writeTestBackup(t *testing.T, dir string, full bool) *BackupManifest {
+	b, err := NewBackup(dir, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := b.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := GetBackupStatus(); status.Running || status.LastBackup == nil || status.LastBackup.ID != manifest.ID {
+		t.Errorf("unexpected backup status after backup %d: %+v\n", manifest.ID, status)
+	}
+	return manifest
+}
+
+func backupStoreOfKind(t *testing.T, manifest *BackupManifest, log bool) BackupStore {
+	var stores []BackupStore
+	for _, s := range manifest.Stores {
+		if s.Log == log {
+			stores = append(stores, s)
+		}
+	}
+	if len(stores) != 1 {
+		t.Fatalf("expected one store with log %t in backup point %d, got %v\n", log, manifest.ID, manifest.Stores)
+	}
+	return stores[0]
+}
+
+func checkBackupStore(t *testing.T, manifest *BackupManifest, kvs, puts, deletes uint64) {
+	s := backupStoreOfKind(t, manifest, false)
+	if s.KeyValues != kvs || s.Puts != puts || s.Deletes != deletes {
+		t.Errorf("expected %d key-values, %d puts, %d deletes in backup point %d, got %+v\n",
+			kvs, puts, deletes, manifest.ID, s)
+	}
+}
+
+func checkBackupLogs(t *testing.T, manifest *BackupManifest, logs, msgs, deletes uint64) {
+	s := backupStoreOfKind(t, manifest, true)
+	if s.Logs != logs || s.Messages != msgs || s.Deletes != deletes {
+		t.Errorf("expected %d logs, %d messages, %d deletes in backup point %d, got %+v\n",
+			logs, msgs, deletes, manifest.ID, s)
+	}
+}
+
+type testLog interface {
+	storage.ReadLog
+	storage.DeletableLog
+}
+
+func testLogStore(t *testing.T) testLog {
+	store, err := storage.DefaultLogStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, ok := store.(testLog)
+	if !ok {
+		t.Fatalf("expected readable and deletable default log store, got %s\n", store)
+	}
+	return log
+}
+
+func appendTestLog(t *testing.T, log storage.WriteLog, topic string, data ...string) {
+	for _, d := range data {
+		if err := log.TopicAppend(topic, storage.LogMessage{EntryType: 1, Data: []byte(d)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	dir, err := ioutil.TempDir("", "dvid-backup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	uuid, _ := NewTestRepo()
+	db, err := storage.MetaDataKVStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]storage.Key, 4)
+	for i := range keys {
+		keys[i] = storage.Key{1, 0, 0, 0, byte(i + 1)}
+		if err := db.RawPut(keys[i], []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log := testLogStore(t)
+	appendTestLog(t, log, "a", "a1", "a2")
+	appendTestLog(t, log, "b", "b1")
+	appendTestLog(t, log, "d", "d1")
+	numKVs := func() uint64 {
+		ch := make(chan *storage.KeyValue, 1000)
+		go db.RawRangeQuery(backupMinKey, backupMaxKey, true, ch, nil)
+		var n uint64
+		for kv := range ch {
+			if kv == nil {
+				break
+			}
+			n++
+		}
+		return n
+	}
+
+	// The first backup point is a full backup.
+	first := writeTestBackup(t, dir, false)
+	if first.Parent != 0 {
+		t.Errorf("expected full first backup point, got parent %d\n", first.Parent)
+	}
+	n := numKVs()
+	checkBackupStore(t, first, n, n, 0)
+	checkBackupLogs(t, first, 3, 4, 0)
+	if r, err := manager.repoFromUUID(uuid); err != nil || first.MutationIDs[uuid] != r.getMutationID() {
+		t.Errorf("expected backup of mutation ID for repo %s, got %v: %v\n", uuid, first.MutationIDs, err)
+	}
+
+	// Changes are written to an incremental point.
+	if err := db.RawPut(keys[1], []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RawDelete(keys[2]); err != nil {
+		t.Fatal(err)
+	}
+	newKey := storage.Key{1, 0, 0, 0, 9}
+	if err := db.RawPut(newKey, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	appendTestLog(t, log, "a", "a3")
+	appendTestLog(t, log, "c", "c1")
+	for _, topic := range []string{"b", "d"} {
+		if err := log.TopicDelete(topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendTestLog(t, log, "b", "rewritten b1")
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.newMutationID()
+	second := writeTestBackup(t, dir, false)
+	if second.Parent != first.ID || second.ID <= first.ID {
+		t.Errorf("expected incremental point after %d, got ID %d with parent %d\n", first.ID, second.ID, second.Parent)
+	}
+	checkBackupStore(t, second, n, 2, 1)
+	checkBackupLogs(t, second, 3, 3, 2)
+
+	// Writes after the snapshot shouldn't be in the backup point if snapshots are supported.
+	b, err := NewBackup(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBackup(dir, false); err == nil {
+		t.Errorf("expected error on concurrent backups\n")
+	}
+	if err := db.RawPut(keys[0], []byte("after snapshot")); err != nil {
+		t.Fatal(err)
+	}
+	appendTestLog(t, log, "a", "after snapshot")
+	third, err := b.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.ID != second.ID+1 {
+		t.Errorf("expected backup point without new mutations to have ID %d, got %d\n", second.ID+1, third.ID)
+	}
+	if backupStoreOfKind(t, third, false).Consistent {
+		checkBackupStore(t, third, n, 0, 0)
+	}
+	checkBackupLogs(t, third, 3, 0, 0)
+
+	full := writeTestBackup(t, dir, true)
+	if full.Parent != 0 {
+		t.Errorf("expected full backup point, got parent %d\n", full.Parent)
+	}
+	checkBackupStore(t, full, n, n, 0)
+	checkBackupLogs(t, full, 3, 6, 0)
+
+	points, err := BackupPoints(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 4 || points[0].ID != first.ID || points[3].ID != full.ID {
+		t.Fatalf("expected 4 backup points, got %v\n", points)
+	}
+
+	// Rebuild the server with empty stores from the second backup point.
+	Shutdown()
+	if _, err := Restore(dir, second.ID); err == nil {
+		t.Fatalf("expected error restoring into running datastore\n")
+	}
+	manager = nil
+
+	storage.Shutdown()
+	for alias, engine := range testStore.engines {
+		if err := engine.Delete(testStore.backend.Stores[alias]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := storage.Initialize(dvid.Config{}, testStore.backend); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Restore(dir, third.ID-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ID != second.ID {
+		t.Fatalf("expected restore of backup point %d, got %d\n", second.ID, restored.ID)
+	}
+	if err := Initialize(false, Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(dir, 0); err == nil {
+		t.Errorf("expected error restoring into non-empty stores\n")
+	}
+	if _, err := VersionFromUUID(uuid); err != nil {
+		t.Errorf("expected restored repo %s: %v\n", uuid, err)
+	}
+	if db, err = storage.MetaDataKVStore(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		string(keys[0]): string([]byte{0}),
+		string(keys[1]): "changed",
+		string(keys[3]): string([]byte{3}),
+		string(newKey):  "new",
+	}
+	ch := make(chan *storage.KeyValue, 10)
+	go db.RawRangeQuery(storage.Key{1}, backupMaxKey, false, ch, nil)
+	found := make(map[string]string)
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		found[string(kv.K)] = string(kv.V)
+	}
+	if len(found) != len(expected) {
+		t.Errorf("expected %d restored key-values, got %d: %v\n", len(expected), len(found), found)
+	}
+	for k, v := range expected {
+		if found[k] != v {
+			t.Errorf("expected restored key %v to have value %q, got %q\n", []byte(k), v, found[k])
+		}
+	}
+
+	log = testLogStore(t)
+	expectedLogs := map[string][]string{
+		"a": {"a1", "a2", "a3"},
+		"b": {"rewritten b1"},
+		"c": {"c1"},
+		"d": nil,
+	}
+	for topic, expected := range expectedLogs {
+		msgs, err := log.TopicReadAll(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != len(expected) {
+			t.Errorf("expected %d restored messages in log %q, got %d\n", len(expected), topic, len(msgs))
+			continue
+		}
+		for i, msg := range msgs {
+			if msg.EntryType != 1 || string(msg.Data) != expected[i] {
+				t.Errorf("expected restored message %d in log %q to be %q, got %+v\n", i, topic, expected[i], msg)
+			}
+		}
+	}
+}
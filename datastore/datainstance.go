@@ -273,6 +273,18 @@ type VersionInitializer interface {
 	InitVersion(dvid.UUID, dvid.VersionID) error
 }
 
+// VersionMerger is a data instance that can do a type-specific merge of versions,
+// as requested by a MergeTypeSpecificAuto merge.  The ancestor is the closest common
+// ancestor of the parents, and parents are given in priority order.  MergeConflicts
+// is called for every VersionMerger in the repo before the merged child is created
+// and should return a non-nil, JSON-serializable description if the changes made along
+// the parents since the ancestor cannot be automatically merged.  If no instance has
+// conflicts, MergeVersions is called to apply the merge into the new child version.
+type VersionMerger interface {
+	MergeConflicts(ancestor dvid.VersionID, parents []dvid.VersionID) (conflicts interface{}, err error)
+	MergeVersions(ancestor dvid.VersionID, parents []dvid.VersionID, child dvid.VersionID) error
+}
+
 // DataInitializer is a data instance that needs to be initialized, e.g., start
 // long-lived goroutines that handle data syncs, etc.  Initialization should only
 // constitute supporting data and goroutines and not change the data itself like
@@ -349,6 +361,12 @@ type MutationDumper interface {
 	DumpMutations(versionUUID dvid.UUID, filename string) (comment string, err error)
 }
 
+// PrecomputedExporter is a dataservice that supports the export-precomputed command,
+// which writes a version of the data to a directory in the Neuroglancer precomputed format.
+type PrecomputedExporter interface {
+	ExportPrecomputed(versionUUID dvid.UUID, dirPath string, config dvid.Config) error
+}
+
 // BlockOnUpdating blocks until the given data is not updating from syncs or has events
 // waiting in sync channels.  Primarily used during testing.
 func BlockOnUpdating(uuid dvid.UUID, name dvid.InstanceName) error {
@@ -1104,7 +1122,11 @@ func (d *Data) PutBlob(b []byte) (ref string, err error) {
 	return
 }
 
+// ProduceKafkaMsg sends a mutation message to this data instance's Kafka topic and to
+// any change feed started for this data instance.
 func (d *Data) ProduceKafkaMsg(b []byte) error {
+	publishEvent(d, b)
+
 	// create topic (repo ID + data instance uuid)
 	// NOTE: Kafka server must be configured to allow topic creation from
 	// messages sent to a non-existent topic
@@ -42,6 +42,13 @@ func (d *TestData) ServeHTTP(uuid dvid.UUID, ctx *VersionedCtx, w http.ResponseW
 	return nil
 }
 
+func (d *TestData) GobDecode(b []byte) error {
+	if d.Data == nil {
+		d.Data = new(Data)
+	}
+	return d.Data.GobDecode(b)
+}
+
 func (d *TestData) Help() string {
 	return "no help here!"
 }
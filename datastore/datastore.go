@@ -266,6 +266,40 @@ func GetAncestry(v dvid.VersionID) ([]dvid.VersionID, error) {
 	return manager.getAncestry(v)
 }
 
+// GetCommonAncestor returns the closest version along the ancestry of the first
+// given version that is an ancestor of all the other given versions.
+func GetCommonAncestor(versions []dvid.VersionID) (dvid.VersionID, error) {
+	if manager == nil {
+		return 0, ErrManagerNotInitialized
+	}
+	return manager.getCommonAncestor(versions)
+}
+
+// GetVersionsSince returns the versions along the ancestry of the given version,
+// ordered from the child of the ancestor to the given version, and excluding the
+// given ancestor.  An error is returned if the ancestor is not along the ancestry.
+func GetVersionsSince(ancestor, v dvid.VersionID) ([]dvid.VersionID, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	ancestry, err := manager.getAncestry(v)
+	if err != nil {
+		return nil, err
+	}
+	var versions []dvid.VersionID
+	for _, ancestorV := range ancestry {
+		if ancestorV == ancestor {
+			for i := len(versions)/2 - 1; i >= 0; i-- {
+				opp := len(versions) - 1 - i
+				versions[i], versions[opp] = versions[opp], versions[i]
+			}
+			return versions, nil
+		}
+		versions = append(versions, ancestorV)
+	}
+	return nil, fmt.Errorf("version %d is not along the ancestry of version %d", ancestor, v)
+}
+
 // LockedUUID returns true if a given UUID is locked.
 func LockedUUID(uuid dvid.UUID) (bool, error) {
 	if manager == nil {
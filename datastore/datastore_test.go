@@ -120,3 +120,73 @@ func TestBranching(t *testing.T) {
 		t.Fatalf("Expected other leaf %s from query %q, got %s\n", other2, otherQuery, otherLeaf)
 	}
 }
+
+func TestCommonAncestor(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	root, err := NewRepo("my alias", "my desc", nil, "")
+	if err != nil {
+		t.Fatalf("couldn't create repo: %v\n", err)
+	}
+	if err := Commit(root, "", nil); err != nil {
+		t.Fatalf("couldn't commit node: %v\n", err)
+	}
+	master1, err := NewVersion(root, "master 1", "", nil)
+	if err != nil {
+		t.Fatalf("couldn't create new version: %v\n", err)
+	}
+	if err := Commit(master1, "", nil); err != nil {
+		t.Fatalf("couldn't commit node: %v\n", err)
+	}
+	master2, err := NewVersion(master1, "master 2", "", nil)
+	if err != nil {
+		t.Fatalf("couldn't create new version: %v\n", err)
+	}
+	if err := Commit(master2, "", nil); err != nil {
+		t.Fatalf("couldn't commit node: %v\n", err)
+	}
+	other1, err := NewVersion(master1, "other 1", "other", nil)
+	if err != nil {
+		t.Fatalf("couldn't create new version: %v\n", err)
+	}
+	if err := Commit(other1, "", nil); err != nil {
+		t.Fatalf("couldn't commit node: %v\n", err)
+	}
+
+	var versions []dvid.VersionID
+	for _, uuid := range []dvid.UUID{root, master1, master2, other1} {
+		v, err := VersionFromUUID(uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, v)
+	}
+	rootV, master1V, master2V, other1V := versions[0], versions[1], versions[2], versions[3]
+
+	ancestor, err := GetCommonAncestor([]dvid.VersionID{master2V, other1V})
+	if err != nil {
+		t.Fatalf("couldn't get common ancestor: %v\n", err)
+	}
+	if ancestor != master1V {
+		t.Fatalf("expected common ancestor %d, got %d\n", master1V, ancestor)
+	}
+	ancestor, err = GetCommonAncestor([]dvid.VersionID{master2V, master1V})
+	if err != nil {
+		t.Fatalf("couldn't get common ancestor: %v\n", err)
+	}
+	if ancestor != master1V {
+		t.Fatalf("expected common ancestor %d, got %d\n", master1V, ancestor)
+	}
+
+	since, err := GetVersionsSince(rootV, master2V)
+	if err != nil {
+		t.Fatalf("couldn't get versions since root: %v\n", err)
+	}
+	if !reflect.DeepEqual(since, []dvid.VersionID{master1V, master2V}) {
+		t.Fatalf("expected versions since root to be %v, got %v\n", []dvid.VersionID{master1V, master2V}, since)
+	}
+	if _, err = GetVersionsSince(other1V, master2V); err == nil {
+		t.Fatalf("expected error getting versions since a version on another branch\n")
+	}
+}
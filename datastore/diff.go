@@ -0,0 +1,192 @@
+/*
+	This file supports finding the keys that differ between two versions of a data instance.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// KeyChange describes how the value of a key differs between two versions.
+type KeyChange uint8
+
+const (
+	KeyAdded KeyChange = iota + 1
+	KeyModified
+	KeyDeleted
+)
+
+func (c KeyChange) String() string {
+	switch c {
+	case KeyAdded:
+		return "added"
+	case KeyModified:
+		return "modified"
+	case KeyDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalJSON returns the change as a JSON string.
+func (c KeyChange) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + c.String() + `"`), nil
+}
+
+// UnmarshalJSON sets the change from a JSON string.
+func (c *KeyChange) UnmarshalJSON(b []byte) error {
+	switch string(b) {
+	case `"added"`:
+		*c = KeyAdded
+	case `"modified"`:
+		*c = KeyModified
+	case `"deleted"`:
+		*c = KeyDeleted
+	default:
+		return fmt.Errorf("unknown key change %s", string(b))
+	}
+	return nil
+}
+
+// KeyDiff describes a type-specific key whose value differs from version A to version B.
+// A value is nil if the key has no value in the corresponding version.
+type KeyDiff struct {
+	TKey   storage.TKey
+	Change KeyChange
+	ValueA []byte
+	ValueB []byte
+}
+
+// GetDiffVersion returns the version for a UUID string that will be compared to the
+// given UUID, which must be in the same repo.
+func GetDiffVersion(uuid dvid.UUID, uuidStr string) (dvid.UUID, dvid.VersionID, error) {
+	uuidB, vB, err := MatchingUUID(uuidStr)
+	if err != nil {
+		return dvid.NilUUID, 0, err
+	}
+	rootA, err := GetRepoRoot(uuid)
+	if err != nil {
+		return dvid.NilUUID, 0, err
+	}
+	rootB, err := GetRepoRoot(uuidB)
+	if err != nil {
+		return dvid.NilUUID, 0, err
+	}
+	if rootA != rootB {
+		return dvid.NilUUID, 0, fmt.Errorf("can't diff versions %s and %s in different repos", uuid, uuidB)
+	}
+	return uuidB, vB, nil
+}
+
+// DiffVersions calls the given function, in key order, for each type-specific key within
+// [begTKey, endTKey] whose value differs between versions vA and vB of a data instance.  The
+// value visible in each version is determined by the version ancestry as in a versioned Get.
+func DiffVersions(data dvid.Data, vA, vB dvid.VersionID, begTKey, endTKey storage.TKey, f func(KeyDiff) error) (err error) {
+	store, err := GetOrderedKeyValueDB(data)
+	if err != nil {
+		return err
+	}
+	baseCtx := NewVersionedCtx(data, 0)
+	minKey, err := baseCtx.MinVersionKey(begTKey)
+	if err != nil {
+		return err
+	}
+	maxKey, err := baseCtx.MaxVersionKey(endTKey)
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan *storage.KeyValue, 1000)
+	cancel := make(chan struct{})
+	queryErr := make(chan error, 1)
+	go func() {
+		queryErr <- store.RawRangeQuery(minKey, maxKey, false, ch, cancel)
+		close(ch)
+	}()
+	defer func() {
+		if err != nil {
+			close(cancel)
+		}
+		for range ch {
+		}
+		if qerr := <-queryErr; qerr != nil && err == nil {
+			err = qerr
+		}
+	}()
+
+	var curTK storage.TKey
+	var batch []*storage.KeyValue
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		var tk storage.TKey
+		if tk, err = storage.TKeyFromKey(kv.K); err != nil {
+			return
+		}
+		if len(batch) != 0 && !bytes.Equal(tk, curTK) {
+			if err = diffKeyVersions(baseCtx, curTK, batch, vA, vB, f); err != nil {
+				return
+			}
+			batch = batch[:0]
+		}
+		curTK = tk
+		batch = append(batch, kv)
+	}
+	if len(batch) != 0 {
+		err = diffKeyVersions(baseCtx, curTK, batch, vA, vB, f)
+	}
+	return
+}
+
+// compares the values visible in two versions among the stored versions of a key.
+func diffKeyVersions(ctx *VersionedCtx, tk storage.TKey, kvs []*storage.KeyValue, vA, vB dvid.VersionID, f func(KeyDiff) error) error {
+	kvA, err := matchVersion(ctx, kvs, vA)
+	if err != nil {
+		return err
+	}
+	kvB, err := matchVersion(ctx, kvs, vB)
+	if err != nil {
+		return err
+	}
+	diff := KeyDiff{TKey: tk}
+	switch {
+	case kvA == nil && kvB == nil:
+		return nil
+	case kvA == nil:
+		diff.Change = KeyAdded
+		diff.ValueB = kvB.V
+	case kvB == nil:
+		diff.Change = KeyDeleted
+		diff.ValueA = kvA.V
+	default:
+		if kvA == kvB || bytes.Equal(kvA.V, kvB.V) {
+			return nil
+		}
+		diff.Change = KeyModified
+		diff.ValueA = kvA.V
+		diff.ValueB = kvB.V
+	}
+	return f(diff)
+}
+
+// returns the key-value visible in the given version.  A new version map is used for each
+// match since matching invalidates ancestors within the map.
+func matchVersion(ctx *VersionedCtx, kvs []*storage.KeyValue, v dvid.VersionID) (*storage.KeyValue, error) {
+	kvv := make(kvVersions, len(kvs))
+	for _, kv := range kvs {
+		kvVersion, err := ctx.VersionFromKey(kv.K)
+		if err != nil {
+			return nil, err
+		}
+		kvv[kvVersion] = kvvNode{kv: kv}
+	}
+	kv, _, err := kvv.FindMatch(v)
+	return kv, err
+}
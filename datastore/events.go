@@ -0,0 +1,210 @@
+/*
+	This file supports in-memory change feeds of the mutation messages produced by data
+	instances so clients can follow mutations without a Kafka cluster.
+*/
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+const (
+	// EventFeedBufferSize is the number of recent events retained by each data instance's
+	// change feed so clients can resume from a cursor.
+	EventFeedBufferSize = 10000
+
+	// eventSubscriberBuffer is the number of events that can be queued for a subscriber
+	// before it is considered too slow and dropped.
+	eventSubscriberBuffer = 1000
+)
+
+// MutationEvent is a mutation message sent to a data instance's change feed.  The message
+// is the same JSON sent to Kafka.
+type MutationEvent struct {
+	// Seq is the cursor for this event, which increases with each event published to a feed.
+	// It is set when the event is published.
+	Seq uint64
+
+	// MutationID is the "MutationID" of the message if present.  Several messages can have
+	// the same mutation ID, so it isn't used as a cursor.
+	MutationID uint64
+
+	// Action is the "Action" of the message, e.g., "merge" or "postkv".
+	Action string
+
+	// UUID is the version given by the "UUID" of the message, if any.
+	UUID dvid.UUID
+
+	// Labels are the labels found in the message that can be used for filtering.
+	Labels []uint64
+
+	Msg json.RawMessage
+}
+
+// fields of mutation messages that hold labels or lists of labels.
+var eventLabelFields = []string{
+	"Label", "Labels", "Target", "Split", "NewLabel", "OrigLabel", "CleavedLabel",
+	"Supervoxel", "SplitSupervoxel", "RemainSupervoxel", "CleavedSupervoxels",
+}
+
+// parses the fields of a JSON mutation message needed for a feed event.
+func newMutationEvent(msg []byte) (*MutationEvent, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(msg, &fields); err != nil {
+		return nil, fmt.Errorf("mutation message is not a JSON object: %v", err)
+	}
+	evt := &MutationEvent{Msg: json.RawMessage(msg)}
+	if raw, found := fields["Action"]; found {
+		json.Unmarshal(raw, &evt.Action)
+	}
+	if raw, found := fields["UUID"]; found {
+		var uuid string
+		json.Unmarshal(raw, &uuid)
+		evt.UUID = dvid.UUID(uuid)
+	}
+	if raw, found := fields["MutationID"]; found {
+		json.Unmarshal(raw, &evt.MutationID)
+	}
+	for _, field := range eventLabelFields {
+		raw, found := fields[field]
+		if !found {
+			continue
+		}
+		var label uint64
+		if err := json.Unmarshal(raw, &label); err == nil {
+			evt.Labels = append(evt.Labels, label)
+			continue
+		}
+		var labels []uint64
+		if err := json.Unmarshal(raw, &labels); err == nil {
+			evt.Labels = append(evt.Labels, labels...)
+		}
+	}
+	return evt, nil
+}
+
+// EventSubscription receives events from a change feed.  The channel is closed if the
+// subscriber falls too far behind or the subscription is canceled.
+type EventSubscription struct {
+	Ch <-chan *MutationEvent
+	ch chan *MutationEvent
+}
+
+// EventFeed retains recent mutation events for a data instance and sends new events
+// to subscribers.
+type EventFeed struct {
+	mu      sync.Mutex
+	nextSeq uint64           // sequence number of the next published event
+	events  []*MutationEvent // recent events in order of publication
+	evicted bool             // true if events have been dropped from the buffer
+	subs    map[*EventSubscription]struct{}
+}
+
+var (
+	eventFeedsMu sync.Mutex
+	eventFeeds   = make(map[dvid.UUID]*EventFeed)
+)
+
+// GetEventFeed returns the change feed for the data instance with the given data UUID,
+// starting a feed if none exists.  Events are only retained once a feed has been started.
+func GetEventFeed(dataUUID dvid.UUID) *EventFeed {
+	eventFeedsMu.Lock()
+	defer eventFeedsMu.Unlock()
+	feed, found := eventFeeds[dataUUID]
+	if !found {
+		// Start sequences at the time in microseconds so cursors from a feed before a
+		// restart are below the cursors of the new feed.
+		feed = &EventFeed{
+			nextSeq: uint64(time.Now().UnixNano() / 1000),
+			subs:    make(map[*EventSubscription]struct{}),
+		}
+		eventFeeds[dataUUID] = feed
+	}
+	return feed
+}
+
+// returns the change feed for a data instance or nil if none has been started.
+func getEventFeed(dataUUID dvid.UUID) *EventFeed {
+	eventFeedsMu.Lock()
+	defer eventFeedsMu.Unlock()
+	return eventFeeds[dataUUID]
+}
+
+// publishEvent sends a mutation message to the data instance's change feed if one has
+// been started.
+func publishEvent(d *Data, msg []byte) {
+	feed := getEventFeed(d.DataUUID())
+	if feed == nil {
+		return
+	}
+	evt, err := newMutationEvent(msg)
+	if err != nil {
+		dvid.Errorf("unable to publish event for data %q: %v\n", d.DataName(), err)
+		return
+	}
+	feed.publish(evt)
+}
+
+func (feed *EventFeed) publish(evt *MutationEvent) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	evt.Seq = feed.nextSeq
+	feed.nextSeq++
+	if len(feed.events) >= EventFeedBufferSize {
+		copy(feed.events, feed.events[1:])
+		feed.events[len(feed.events)-1] = evt
+		feed.evicted = true
+	} else {
+		feed.events = append(feed.events, evt)
+	}
+	for sub := range feed.subs {
+		select {
+		case sub.ch <- evt:
+		default:
+			dvid.Infof("dropping slow event feed subscriber after %d queued events\n", eventSubscriberBuffer)
+			delete(feed.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Subscribe returns the retained events after the event with the given cursor along with
+// a subscription for subsequent events.  If a cursor isn't given, no retained events are
+// returned.  An error is returned if events after the cursor may have been dropped from
+// the buffer or the cursor is not from this feed.
+func (feed *EventFeed) Subscribe(cursor uint64, hasCursor bool) ([]*MutationEvent, *EventSubscription, error) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	var backlog []*MutationEvent
+	if hasCursor {
+		if cursor >= feed.nextSeq {
+			return nil, nil, fmt.Errorf("event %d has not been sent by this server", cursor)
+		}
+		if feed.evicted && len(feed.events) > 0 && feed.events[0].Seq > cursor+1 {
+			return nil, nil, fmt.Errorf("events after event %d are no longer retained", cursor)
+		}
+		pos := sort.Search(len(feed.events), func(i int) bool { return feed.events[i].Seq > cursor })
+		backlog = append(backlog, feed.events[pos:]...)
+	}
+	ch := make(chan *MutationEvent, eventSubscriberBuffer)
+	sub := &EventSubscription{Ch: ch, ch: ch}
+	feed.subs[sub] = struct{}{}
+	return backlog, sub, nil
+}
+
+// Unsubscribe cancels a subscription.
+func (feed *EventFeed) Unsubscribe(sub *EventSubscription) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	if _, found := feed.subs[sub]; found {
+		delete(feed.subs, sub)
+		close(sub.ch)
+	}
+}
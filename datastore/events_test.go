@@ -0,0 +1,123 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestNewMutationEvent(t *testing.T) {
+	msg := `{"Action":"merge","UUID":"abc","MutationID":23,"Target":7,"Labels":[8,9]}`
+	evt, err := newMutationEvent([]byte(msg))
+	if err != nil {
+		t.Fatalf("error parsing mutation message: %v\n", err)
+	}
+	if evt.Action != "merge" || evt.UUID != "abc" || evt.MutationID != 23 {
+		t.Errorf("bad parse of mutation event: %v\n", evt)
+	}
+	labels := make(map[uint64]bool)
+	for _, label := range evt.Labels {
+		labels[label] = true
+	}
+	if len(labels) != 3 || !labels[7] || !labels[8] || !labels[9] {
+		t.Errorf("expected labels 7, 8, 9 in event, got %v\n", evt.Labels)
+	}
+	if _, err := newMutationEvent([]byte("[1,2]")); err == nil {
+		t.Errorf("expected error parsing non-object mutation message\n")
+	}
+}
+
+func TestEventFeed(t *testing.T) {
+	feed := GetEventFeed(dvid.NewUUID())
+	var seqs []uint64
+	for i := uint64(1); i <= 5; i++ {
+		evt := &MutationEvent{MutationID: i * 10, Action: fmt.Sprintf("action%d", i)}
+		feed.publish(evt)
+		seqs = append(seqs, evt.Seq)
+	}
+	for i := 1; i < len(seqs); i++ {
+		if seqs[i] != seqs[i-1]+1 {
+			t.Fatalf("expected consecutive event sequence numbers, got %v\n", seqs)
+		}
+	}
+
+	backlog, sub, err := feed.Subscribe(0, false)
+	if err != nil {
+		t.Fatalf("error subscribing without cursor: %v\n", err)
+	}
+	if len(backlog) != 0 {
+		t.Errorf("expected no backlog without cursor, got %d events\n", len(backlog))
+	}
+	feed.publish(&MutationEvent{MutationID: 60, Action: "action6"})
+	evt := <-sub.Ch
+	if evt.MutationID != 60 || evt.Seq != seqs[4]+1 {
+		t.Errorf("expected subscriber to receive mutation 60 after event %d, got %+v\n", seqs[4], evt)
+	}
+	feed.Unsubscribe(sub)
+	if _, ok := <-sub.Ch; ok {
+		t.Errorf("expected channel to be closed after unsubscribe\n")
+	}
+
+	backlog, sub, err = feed.Subscribe(seqs[2], true)
+	if err != nil {
+		t.Fatalf("error subscribing with cursor: %v\n", err)
+	}
+	if len(backlog) != 3 || backlog[0].MutationID != 40 || backlog[2].MutationID != 60 {
+		t.Errorf("bad backlog after cursor %d: %v\n", seqs[2], backlog)
+	}
+	feed.Unsubscribe(sub)
+
+	// Messages with the same mutation ID are separate events.
+	feed.publish(&MutationEvent{MutationID: 70, Action: "merge"})
+	feed.publish(&MutationEvent{MutationID: 70, Action: "merge-complete"})
+	backlog, sub, err = feed.Subscribe(seqs[4]+2, true)
+	if err != nil {
+		t.Fatalf("error subscribing with cursor: %v\n", err)
+	}
+	if len(backlog) != 1 || backlog[0].Action != "merge-complete" {
+		t.Errorf("expected second event of mutation 70 after cursor, got %v\n", backlog)
+	}
+	feed.Unsubscribe(sub)
+
+	// Cursors not yet sent by this feed, e.g., before a restart, are rejected.
+	if _, _, err = feed.Subscribe(seqs[4]+100, true); err == nil {
+		t.Errorf("expected error subscribing with unknown cursor\n")
+	}
+
+	// Once events are evicted, old cursors can no longer be served.
+	for i := 0; i < EventFeedBufferSize; i++ {
+		feed.publish(&MutationEvent{MutationID: uint64(100 + i)})
+	}
+	if _, _, err = feed.Subscribe(seqs[2], true); err == nil {
+		t.Errorf("expected error subscribing with evicted cursor\n")
+	}
+	last := seqs[4] + 3 + EventFeedBufferSize
+	backlog, sub, err = feed.Subscribe(last-2, true)
+	if err != nil {
+		t.Fatalf("error subscribing with retained cursor: %v\n", err)
+	}
+	if len(backlog) != 2 {
+		t.Errorf("expected 2 events after retained cursor, got %d\n", len(backlog))
+	}
+	feed.Unsubscribe(sub)
+}
+
+func TestEventFeedSlowSubscriber(t *testing.T) {
+	feed := GetEventFeed(dvid.NewUUID())
+	_, sub, err := feed.Subscribe(0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= eventSubscriberBuffer; i++ {
+		feed.publish(&MutationEvent{MutationID: uint64(i + 1)})
+	}
+	var received int
+	for range sub.Ch {
+		received++
+	}
+	if received != eventSubscriberBuffer {
+		t.Errorf("expected %d queued events before drop, got %d\n", eventSubscriberBuffer, received)
+	}
+	feed.Unsubscribe(sub) // should be no-op on dropped subscriber
+}
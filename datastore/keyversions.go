@@ -0,0 +1,103 @@
+/*
+	This file supports finding the versions in which keys were written or deleted.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// KeyVersion is a type-specific key's value stored in a particular version.  If Tombstone
+// is true, the key was deleted in that version and Value is nil.
+type KeyVersion struct {
+	TKey      storage.TKey
+	Version   dvid.VersionID
+	Value     []byte
+	Tombstone bool
+}
+
+// GetKeyVersions returns the stored values and tombstones of the type-specific keys within
+// [begTKey, endTKey] for the versions along the ancestry of version v.  The returned values
+// are in key order and, for each key, ordered from the root version to v.
+func GetKeyVersions(data dvid.Data, v dvid.VersionID, begTKey, endTKey storage.TKey) (keyVersions []KeyVersion, err error) {
+	store, err := GetOrderedKeyValueDB(data)
+	if err != nil {
+		return nil, err
+	}
+	ancestry, err := GetAncestry(v)
+	if err != nil {
+		return nil, err
+	}
+	// depth of each ancestor from the root.
+	depth := make(map[dvid.VersionID]int, len(ancestry))
+	for i, ancestor := range ancestry {
+		depth[ancestor] = len(ancestry) - i
+	}
+	ctx := NewVersionedCtx(data, v)
+	minKey, err := ctx.MinVersionKey(begTKey)
+	if err != nil {
+		return nil, err
+	}
+	maxKey, err := ctx.MaxVersionKey(endTKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *storage.KeyValue, 1000)
+	cancel := make(chan struct{})
+	queryErr := make(chan error, 1)
+	go func() {
+		queryErr <- store.RawRangeQuery(minKey, maxKey, false, ch, cancel)
+		close(ch)
+	}()
+	defer func() {
+		if err != nil {
+			close(cancel)
+		}
+		for range ch {
+		}
+		if qerr := <-queryErr; qerr != nil && err == nil {
+			err = qerr
+		}
+		if err != nil {
+			keyVersions = nil
+		}
+	}()
+
+	for kv := range ch {
+		if kv == nil {
+			break
+		}
+		var kvVersion dvid.VersionID
+		if kvVersion, err = ctx.VersionFromKey(kv.K); err != nil {
+			return
+		}
+		if _, found := depth[kvVersion]; !found {
+			continue
+		}
+		var tk storage.TKey
+		if tk, err = storage.TKeyFromKey(kv.K); err != nil {
+			return
+		}
+		keyVersion := KeyVersion{TKey: tk, Version: kvVersion}
+		if kv.K.IsTombstone() {
+			keyVersion.Tombstone = true
+		} else {
+			keyVersion.Value = kv.V
+		}
+		keyVersions = append(keyVersions, keyVersion)
+	}
+
+	sort.SliceStable(keyVersions, func(i, j int) bool {
+		if c := bytes.Compare(keyVersions[i].TKey, keyVersions[j].TKey); c != 0 {
+			return c < 0
+		}
+		return depth[keyVersions[i].Version] < depth[keyVersions[j].Version]
+	})
+	return keyVersions, nil
+}
@@ -0,0 +1,5 @@
+// +build pebble
+
+package datastore
+
+import _ "github.com/janelia-flyem/dvid/storage/pebble"
@@ -103,6 +103,37 @@ func (d *Data) SyncPending() bool {
 	return false
 }
 
+// GetSyncedTo returns the data instances in the repo of the given data that are synced to it,
+// i.e., the downstream instances that have the given data among their SyncedData().
+func GetSyncedTo(d dvid.Data) ([]DataService, error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	r, err := manager.repoFromUUID(d.RootUUID())
+	if err != nil {
+		return nil, err
+	}
+	r.RLock()
+	defer r.RUnlock()
+
+	var synced []DataService
+	for _, data := range r.data {
+		if data.IsDeleted() || data.DataUUID() == d.DataUUID() {
+			continue
+		}
+		syncer, isSyncer := data.(interface {
+			SyncedData() dvid.UUIDSet
+		})
+		if !isSyncer {
+			continue
+		}
+		if _, found := syncer.SyncedData()[d.DataUUID()]; found {
+			synced = append(synced, data)
+		}
+	}
+	return synced, nil
+}
+
 // CommitSyncer want to be notified when a node is committed.
 type CommitSyncer interface {
 	// SyncOnCommit is an asynchronous function that should be called when a node is committed.
@@ -0,0 +1,1069 @@
+// +build !clustered,!gcloud
+
+/*
+	This file contains local server code supporting continuous replication of a repo from
+	a leader DVID server to this follower server, which then acts as a warm read replica.
+
+	The follower polls the leader over RPC.  Each sync merges the leader's repo metadata,
+	i.e., new versions, commits, and data instances, into the local repo and copies the
+	key-value pairs and log messages, e.g., labelmap supervoxel mappings, of each newly
+	committed version.  Since committed versions are immutable, each is copied only once.  Open versions can still change, so the server package brings
+	them up-to-date by replaying the leader's mutation log.  When an open version is later
+	committed, any replayed key-values and logs are replaced by a copy of the leader's.
+
+	Progress is checkpointed in the metadata store so a follower resumes where it left off
+	after a disconnect or restart, even partway through copying a version.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/rpc"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/valyala/gorpc"
+)
+
+var (
+	replicateMessageID rpc.MessageID = "datastore.Replicate"
+
+	// maximum number of key-value pairs or log messages requested from a leader in one call.
+	replicaPageSize = 1000
+)
+
+const (
+	replicaRepoMsg = "datastore.replicaRepo"
+	replicaKVsMsg  = "datastore.replicaKVs"
+	replicaLogMsg  = "datastore.replicaLog"
+)
+
+func init() {
+	rpc.RegisterSessionMaker(replicateMessageID, rpc.NewSessionHandlerFunc(makeReplicaSession))
+
+	d := rpc.Dispatcher()
+	d.AddFunc(replicaRepoMsg, handleReplicaRepo)
+	d.AddFunc(replicaKVsMsg, handleReplicaKVs)
+	d.AddFunc(replicaLogMsg, handleReplicaLog)
+
+	gorpc.RegisterType(&replicaRepoRequest{})
+	gorpc.RegisterType(&replicaKVsRequest{})
+	gorpc.RegisterType(&replicaKVs{})
+	gorpc.RegisterType(&replicaLogRequest{})
+	gorpc.RegisterType(&replicaLog{})
+}
+
+type replicaRepoRequest struct {
+	Session rpc.SessionID
+	UUID    dvid.UUID // any version within the repo
+}
+
+type replicaKVsRequest struct {
+	Session  rpc.SessionID
+	DataUUID dvid.UUID
+	Version  dvid.UUID   // version whose key-values are sent or NilUUID for unversioned key-values
+	After    storage.Key // last key already received or nil to start from beginning
+	Max      int
+}
+
+// replicaKVs is a page of the key-value pairs, including tombstones, written in one
+// version of a data instance.
+type replicaKVs struct {
+	KVs  []storage.KeyValue
+	Done bool // true if there are no more key-values after this page
+}
+
+type replicaLogRequest struct {
+	Session  rpc.SessionID
+	DataUUID dvid.UUID
+	Version  dvid.UUID
+	Offset   int64 // byte offset of the first log message to send
+	Max      int
+}
+
+// replicaLog is a page of the log messages for one version of a data instance.
+type replicaLog struct {
+	Msgs []storage.LogMessage
+	Next int64 // byte offset after the last message in this page
+	Done bool  // true if there are no more log messages after this page
+}
+
+// logReader and logWriter are data instances that may have a log store, e.g., labelmap
+// instances, which log their supervoxel mappings.
+type logReader interface {
+	GetReadLog() storage.ReadLog
+}
+
+type logWriter interface {
+	GetWriteLog() storage.WriteLog
+}
+
+// --- The following is the leader side of replication ----
+
+// replicaSession handles replication requests from a follower.  It holds no state
+// since followers keep track of their own progress.
+type replicaSession struct {
+	sessionID rpc.SessionID
+}
+
+func makeReplicaSession(rpc.MessageID) (rpc.SessionHandler, error) {
+	dvid.Debugf("Creating replication session...\n")
+	return new(replicaSession), nil
+}
+
+func (s *replicaSession) ID() rpc.SessionID {
+	return s.sessionID
+}
+
+func (s *replicaSession) Open(sid rpc.SessionID) error {
+	dvid.Debugf("Replication start, session %d\n", sid)
+	s.sessionID = sid
+	return nil
+}
+
+func (s *replicaSession) Close() error {
+	dvid.Debugf("Replication end, session %d\n", s.sessionID)
+	return nil
+}
+
+func checkReplicaSession(sid rpc.SessionID) error {
+	handler, err := rpc.GetSessionHandler(sid)
+	if err != nil {
+		return err
+	}
+	if _, ok := handler.(*replicaSession); !ok {
+		return fmt.Errorf("handler for session %d is not expected replication type: %v", sid, handler)
+	}
+	return nil
+}
+
+func handleReplicaRepo(m *replicaRepoRequest) ([]byte, error) {
+	if err := checkReplicaSession(m.Session); err != nil {
+		return nil, err
+	}
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	uuid, _, err := MatchingUUID(string(m.UUID))
+	if err != nil {
+		return nil, err
+	}
+	r, err := manager.repoFromUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	dup, err := r.duplicate(r.versionSet(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return dup.GobEncode()
+}
+
+func handleReplicaKVs(m *replicaKVsRequest) (*replicaKVs, error) {
+	if err := checkReplicaSession(m.Session); err != nil {
+		return nil, err
+	}
+	d, err := GetDataByDataUUID(m.DataUUID)
+	if err != nil {
+		return nil, err
+	}
+	var v dvid.VersionID // unversioned key-values are stored with version 0
+	if m.Version != dvid.NilUUID {
+		if v, err = VersionFromUUID(m.Version); err != nil {
+			return nil, err
+		}
+	}
+	if m.Max <= 0 {
+		m.Max = replicaPageSize
+	}
+	return getReplicaKVs(d, v, m.After, m.Max)
+}
+
+func handleReplicaLog(m *replicaLogRequest) (*replicaLog, error) {
+	if err := checkReplicaSession(m.Session); err != nil {
+		return nil, err
+	}
+	d, err := GetDataByDataUUID(m.DataUUID)
+	if err != nil {
+		return nil, err
+	}
+	if m.Max <= 0 {
+		m.Max = replicaPageSize
+	}
+	return getReplicaLog(d, m.Version, m.Offset, m.Max)
+}
+
+// getReplicaLog returns up to max log messages for a version of a data instance, starting
+// at the given byte offset.
+func getReplicaLog(d dvid.Data, uuid dvid.UUID, offset int64, max int) (*replicaLog, error) {
+	var rl storage.ReadLog
+	if lr, ok := d.(logReader); ok {
+		rl = lr.GetReadLog()
+	}
+	if rl == nil {
+		return &replicaLog{Next: offset, Done: true}, nil
+	}
+	orl, ok := rl.(storage.OffsetReadLog)
+	if !ok {
+		return nil, fmt.Errorf("log store %s of data %q can't be read incrementally for replication", rl, d.DataName())
+	}
+	msgs, ends, err := orl.ReadFrom(d.DataUUID(), uuid, offset, max)
+	if err != nil {
+		return nil, err
+	}
+	page := &replicaLog{Msgs: msgs, Next: offset, Done: len(msgs) < max}
+	if len(ends) != 0 {
+		page.Next = ends[len(ends)-1]
+	}
+	return page, nil
+}
+
+// getReplicaKVs returns up to max key-value pairs written in the given version of a data
+// instance, starting after the given key.
+func getReplicaKVs(d dvid.Data, v dvid.VersionID, after storage.Key, max int) (*replicaKVs, error) {
+	page := new(replicaKVs)
+	done, err := rangeVersion(d, v, after, false, func(kv *storage.KeyValue) bool {
+		page.KVs = append(page.KVs, *kv)
+		return len(page.KVs) < max
+	})
+	page.Done = done
+	return page, err
+}
+
+// rangeVersion sends each key-value pair written in the given version of a data instance,
+// starting after the given key, to a function until it returns false.  Returns true if
+// all key-value pairs in the range were sent.
+func rangeVersion(d dvid.Data, v dvid.VersionID, after storage.Key, keysOnly bool, f func(*storage.KeyValue) bool) (done bool, err error) {
+	store, err := GetOrderedKeyValueDB(d)
+	if err != nil {
+		return false, fmt.Errorf("unable to get backing store for data %q: %v", d.DataName(), err)
+	}
+	begKey, endKey := NewVersionedCtx(d, v).KeyRange()
+	if after != nil {
+		begKey = append(append(storage.Key{}, after...), 0)
+	}
+
+	ch := make(chan *storage.KeyValue, 1000)
+	cancel := make(chan struct{})
+	go func() {
+		err = store.RawRangeQuery(begKey, endKey, keysOnly, ch, cancel)
+		close(ch)
+	}()
+	stopped := false
+	for kv := range ch {
+		if stopped {
+			continue
+		}
+		if kv == nil || kv.K == nil {
+			done = true
+			continue
+		}
+		_, kvVersion, _, err := storage.DataKeyToLocalIDs(kv.K)
+		if err != nil || kvVersion != v {
+			continue
+		}
+		if !f(kv) {
+			stopped = true
+			close(cancel)
+		}
+	}
+	if err != nil {
+		return false, fmt.Errorf("range query of data %q version %d: %v", d.DataName(), v, err)
+	}
+	return done, nil
+}
+
+// --- The following is the follower side of replication ----
+
+// replicaSource supplies repo metadata and key-value pairs from a leader.
+type replicaSource interface {
+	getRepo(uuid dvid.UUID) ([]byte, error)
+	getKVs(req *replicaKVsRequest) (*replicaKVs, error)
+	getLog(req *replicaLogRequest) (*replicaLog, error)
+	call(method string, req interface{}) (interface{}, error)
+	close() error
+}
+
+type rpcReplicaSource struct {
+	s rpc.Session
+}
+
+func (src *rpcReplicaSource) getRepo(uuid dvid.UUID) ([]byte, error) {
+	resp, err := src.s.Call()(replicaRepoMsg, replicaRepoRequest{Session: src.s.ID(), UUID: uuid})
+	if err != nil {
+		return nil, err
+	}
+	b, ok := resp.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("received response during replication that wasn't expected repo: %v", resp)
+	}
+	return b, nil
+}
+
+func (src *rpcReplicaSource) getKVs(req *replicaKVsRequest) (*replicaKVs, error) {
+	req.Session = src.s.ID()
+	resp, err := src.s.Call()(replicaKVsMsg, *req)
+	if err != nil {
+		return nil, err
+	}
+	page, ok := resp.(*replicaKVs)
+	if !ok {
+		return nil, fmt.Errorf("received response during replication that wasn't expected key-values: %v", resp)
+	}
+	return page, nil
+}
+
+func (src *rpcReplicaSource) getLog(req *replicaLogRequest) (*replicaLog, error) {
+	req.Session = src.s.ID()
+	resp, err := src.s.Call()(replicaLogMsg, *req)
+	if err != nil {
+		return nil, err
+	}
+	page, ok := resp.(*replicaLog)
+	if !ok {
+		return nil, fmt.Errorf("received response during replication that wasn't expected log messages: %v", resp)
+	}
+	return page, nil
+}
+
+func (src *rpcReplicaSource) call(method string, req interface{}) (interface{}, error) {
+	return src.s.Call()(method, req)
+}
+
+func (src *rpcReplicaSource) close() error {
+	return src.s.Close()
+}
+
+// replicaCheckpoint is the persisted progress of a replica.
+type replicaCheckpoint struct {
+	Leader string
+
+	// committed versions whose key-values have been copied
+	Copied map[dvid.UUID]bool
+
+	// progress through the committed version being copied
+	Cursor replicaCursor
+
+	// byte offset in the leader's mutation log after the entries replayed for open versions
+	Replayed map[dvid.UUID]int64
+}
+
+type replicaCursor struct {
+	Version dvid.UUID
+
+	// Logs are copied before key-values.
+	LogsCopied bool
+	LogData    dvid.UUID // data instances before this data UUID have had their logs copied
+	LogOffset  int64     // byte offset in the leader's log after the last message copied
+
+	Data  dvid.UUID   // data instances before this data UUID have had key-values copied
+	After storage.Key // last key copied for the data instance, in the leader's key space
+}
+
+func loadReplicaCheckpoint(root dvid.UUID) (*replicaCheckpoint, error) {
+	cp := &replicaCheckpoint{
+		Copied:   make(map[dvid.UUID]bool),
+		Replayed: make(map[dvid.UUID]int64),
+	}
+	var ctx storage.MetadataContext
+	value, err := manager.store.Get(ctx, storage.NewTKey(replicaKey, []byte(root)))
+	if err != nil {
+		return nil, fmt.Errorf("bad metadata GET of replica checkpoint: %v", err)
+	}
+	if value == nil {
+		return cp, nil
+	}
+	if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(cp); err != nil {
+		return nil, fmt.Errorf("could not decode replica checkpoint for repo %s: %v", root, err)
+	}
+	if cp.Copied == nil {
+		cp.Copied = make(map[dvid.UUID]bool)
+	}
+	if cp.Replayed == nil {
+		cp.Replayed = make(map[dvid.UUID]int64)
+	}
+	return cp, nil
+}
+
+func (cp *replicaCheckpoint) save(root dvid.UUID) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cp); err != nil {
+		return err
+	}
+	var ctx storage.MetadataContext
+	return manager.store.Put(ctx, storage.NewTKey(replicaKey, []byte(root)), buf.Bytes())
+}
+
+// ReplicaStatus describes the progress of a replica.
+type ReplicaStatus struct {
+	Leader            string
+	UUID              dvid.UUID // requested version of the repo at the leader
+	Root              dvid.UUID `json:",omitempty"`
+	Connected         bool
+	Versions          int
+	CopiedVersions    int
+	OpenVersions      []dvid.UUID
+	Copying           dvid.UUID `json:",omitempty"` // committed version currently being copied
+	KeyValuesCopied   uint64
+	LogMessagesCopied uint64
+	BytesCopied       uint64
+	MutationsReplayed int
+	LastSync          time.Time
+	LastError         string    `json:",omitempty"`
+	LastErrorTime     time.Time `json:",omitempty"`
+}
+
+// Replica keeps a local copy of a repo up-to-date with a leader DVID server.  Sync should
+// be called periodically by a single goroutine.
+type Replica struct {
+	leader string
+	uuid   dvid.UUID
+
+	src  replicaSource // nil if not connected to the leader
+	root dvid.UUID
+	cp   *replicaCheckpoint
+
+	mu     sync.RWMutex // protects status
+	status ReplicaStatus
+}
+
+// NewReplica returns a replica of the repo containing the given UUID at the leader, whose
+// RPC address is given.  No connection is made until the first Sync.
+func NewReplica(leader string, uuid dvid.UUID) *Replica {
+	return &Replica{
+		leader: leader,
+		uuid:   uuid,
+		status: ReplicaStatus{Leader: leader, UUID: uuid},
+	}
+}
+
+// Status returns the current progress of the replica.
+func (rep *Replica) Status() ReplicaStatus {
+	rep.mu.RLock()
+	defer rep.mu.RUnlock()
+	status := rep.status
+	status.OpenVersions = append([]dvid.UUID{}, rep.status.OpenVersions...)
+	return status
+}
+
+func (rep *Replica) updateStatus(f func(*ReplicaStatus)) {
+	rep.mu.Lock()
+	f(&rep.status)
+	rep.mu.Unlock()
+}
+
+// Close ends any session with the leader.
+func (rep *Replica) Close() error {
+	if rep.src == nil {
+		return nil
+	}
+	err := rep.src.close()
+	rep.src = nil
+	rep.updateStatus(func(status *ReplicaStatus) { status.Connected = false })
+	return err
+}
+
+// Call sends a request to a function registered with the leader's RPC dispatcher.
+func (rep *Replica) Call(method string, req interface{}) (interface{}, error) {
+	if rep.src == nil {
+		return nil, fmt.Errorf("replica not connected to leader %s", rep.leader)
+	}
+	return rep.src.call(method, req)
+}
+
+// ReplayOffset returns the byte offset in the leader's mutation log for an open version
+// after the entries that have been replayed on this server.
+func (rep *Replica) ReplayOffset(uuid dvid.UUID) int64 {
+	if rep.cp == nil {
+		return 0
+	}
+	return rep.cp.Replayed[uuid]
+}
+
+// SetReplayOffset checkpoints the byte offset in the leader's mutation log for an open
+// version after the entries replayed, where replayed is the number of entries replayed
+// since the last checkpoint.
+func (rep *Replica) SetReplayOffset(uuid dvid.UUID, offset int64, replayed int) error {
+	if rep.cp == nil {
+		return fmt.Errorf("replica of %s has not been synced", rep.uuid)
+	}
+	rep.cp.Replayed[uuid] = offset
+	rep.updateStatus(func(status *ReplicaStatus) {
+		status.MutationsReplayed += replayed
+	})
+	return rep.cp.save(rep.root)
+}
+
+// Sync brings the local copy of the repo up-to-date with the leader, adding new versions
+// and data instances, and copying the key-values of newly committed versions.  It returns
+// the UUIDs of open versions, which must be brought up-to-date by replaying the leader's
+// mutation log.  After an error, the next Sync reconnects to the leader.
+func (rep *Replica) Sync() (open []dvid.UUID, err error) {
+	if manager == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	if rep.src == nil {
+		src := new(rpcReplicaSource)
+		if src.s, err = rpc.NewSession(rep.leader, replicateMessageID); err != nil {
+			err = fmt.Errorf("unable to connect to leader %s: %v", rep.leader, err)
+			rep.setError(err)
+			return
+		}
+		rep.src = src
+		rep.updateStatus(func(status *ReplicaStatus) { status.Connected = true })
+	}
+	if open, err = rep.sync(); err != nil {
+		rep.setError(err)
+		rep.Close()
+	}
+	return
+}
+
+func (rep *Replica) setError(err error) {
+	dvid.Errorf("Replication of %s from %s: %v\n", rep.uuid, rep.leader, err)
+	rep.updateStatus(func(status *ReplicaStatus) {
+		status.LastError = err.Error()
+		status.LastErrorTime = time.Now()
+	})
+}
+
+func (rep *Replica) sync() ([]dvid.UUID, error) {
+	serialization, err := rep.src.getRepo(rep.uuid)
+	if err != nil {
+		return nil, err
+	}
+	remote := new(repoT)
+	if err := remote.GobDecode(serialization); err != nil {
+		return nil, err
+	}
+	if rep.cp == nil {
+		if rep.cp, err = loadReplicaCheckpoint(remote.uuid); err != nil {
+			return nil, err
+		}
+		rep.root = remote.uuid
+		rep.cp.Leader = rep.leader
+	} else if remote.uuid != rep.root {
+		return nil, fmt.Errorf("leader repo root changed from %s to %s", rep.root, remote.uuid)
+	}
+
+	r, err := mergeReplicaRepo(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unversioned key-values, e.g., repo-wide label counters, are few so they're copied in
+	// full on each sync, after which mutable properties derived from key-values are reloaded.
+	instances := replicaInstances(r)
+	for _, d := range instances {
+		if err := rep.copyUnversioned(d); err != nil {
+			return nil, err
+		}
+	}
+	if err := loadReplicaMutables(r, instances); err != nil {
+		return nil, err
+	}
+
+	// Copy committed versions in order of creation at the leader so parents precede children.
+	nodes := make([]*nodeT, 0, len(remote.dag.nodes))
+	for _, node := range remote.dag.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].version < nodes[j].version })
+	var open []dvid.UUID
+	var copied bool
+	for _, node := range nodes {
+		if !node.locked {
+			open = append(open, node.uuid)
+			continue
+		}
+		if rep.cp.Copied[node.uuid] {
+			continue
+		}
+		v, err := manager.versionFromUUID(node.uuid)
+		if err != nil {
+			return nil, err
+		}
+		if err := rep.copyVersion(r, node.uuid, v); err != nil {
+			return nil, err
+		}
+		copied = true
+	}
+	if copied {
+		if err := loadReplicaMutables(r, instances); err != nil {
+			return nil, err
+		}
+	}
+
+	rep.updateStatus(func(status *ReplicaStatus) {
+		status.Root = rep.root
+		status.Versions = len(nodes)
+		status.CopiedVersions = len(rep.cp.Copied)
+		status.OpenVersions = open
+		status.LastSync = time.Now()
+	})
+	return open, nil
+}
+
+// mergeReplicaRepo merges a repo received from a leader into the local repo with the same
+// root, creating it if necessary.  Versions and data instances keep the leader's UUIDs but
+// are assigned local IDs.  The properties of existing instances are updated from the leader's.
+func mergeReplicaRepo(remote *repoT) (*repoT, error) {
+	r, err := manager.repoFromUUID(remote.uuid)
+	created := err != nil
+	if created {
+		repoID, err := manager.newRepoID()
+		if err != nil {
+			return nil, err
+		}
+		r = &repoT{
+			id:         repoID,
+			uuid:       remote.uuid,
+			created:    remote.created,
+			properties: make(map[string]interface{}),
+			data:       make(map[dvid.InstanceName]DataService),
+			dag: &dagT{
+				root:  remote.uuid,
+				nodes: make(map[dvid.VersionID]*nodeT),
+			},
+		}
+	}
+
+	// Assign local version IDs to new versions.
+	versionMap := make(dvid.VersionMap, len(remote.dag.nodes))
+	for remoteV, rnode := range remote.dag.nodes {
+		v, err := manager.versionFromUUID(rnode.uuid)
+		if err == nil {
+			if other, err := manager.repoFromUUID(rnode.uuid); err == nil && other != r {
+				return nil, fmt.Errorf("version %s at leader is already in local repo %s", rnode.uuid, other.uuid)
+			}
+		} else if v, err = manager.newVersionID(rnode.uuid, true); err != nil {
+			return nil, err
+		}
+		versionMap[remoteV] = v
+	}
+	remapVersions := func(remoteVs []dvid.VersionID) []dvid.VersionID {
+		vs := make([]dvid.VersionID, len(remoteVs))
+		for i, remoteV := range remoteVs {
+			vs[i] = versionMap[remoteV]
+		}
+		return vs
+	}
+
+	r.Lock()
+	r.alias = remote.alias
+	r.description = remote.description
+	r.passcode = remote.passcode
+	r.log = remote.log
+	r.properties = remote.properties
+	r.updated = remote.updated
+	for remoteV, rnode := range remote.dag.nodes {
+		v := versionMap[remoteV]
+		node, found := r.dag.nodes[v]
+		if !found {
+			node = newNode(rnode.uuid, v)
+			r.dag.nodes[v] = node
+		}
+		node.Lock()
+		node.branch = rnode.branch
+		node.note = rnode.note
+		node.log = rnode.log
+		node.locked = rnode.locked
+		node.parents = remapVersions(rnode.parents)
+		node.children = remapVersions(rnode.children)
+		node.created = rnode.created
+		node.updated = rnode.updated
+		node.Unlock()
+	}
+	r.dag.rootV = versionMap[remote.dag.rootV]
+	r.version = r.dag.rootV
+
+	// Match data instances by data UUID, following any renames at the leader.
+	remoteData := make(map[dvid.UUID]DataService, len(remote.data))
+	for _, d := range remote.data {
+		if !d.IsDeleted() {
+			remoteData[d.DataUUID()] = d
+		}
+	}
+	var added []DataService
+	var removed []dvid.InstanceName
+	existing := make(map[DataService]DataService)
+	for name, d := range r.data {
+		rd, found := remoteData[d.DataUUID()]
+		if !found {
+			if !d.IsDeleted() {
+				removed = append(removed, name)
+			}
+			continue
+		}
+		delete(remoteData, d.DataUUID())
+		existing[d] = rd
+		if rd.DataName() != name {
+			delete(r.data, name)
+			d.SetName(rd.DataName())
+			r.data[rd.DataName()] = d
+		}
+	}
+	r.Unlock()
+
+	for _, d := range remoteData {
+		id, err := manager.newInstanceID()
+		if err != nil {
+			return nil, err
+		}
+		d.SetInstanceID(id)
+		if dv, needsUpdate := d.(VersionRemapper); needsUpdate {
+			if err := dv.RemapVersions(versionMap); err != nil {
+				return nil, err
+			}
+		}
+		store, err := storage.GetAssignedStore(d.DataName(), d.RootUUID(), d.Tags(), d.TypeName())
+		if err != nil {
+			return nil, err
+		}
+		d.SetKVStore(store)
+		lstore, err := storage.GetAssignedLog(d.DataName(), d.RootUUID(), d.Tags(), d.TypeName())
+		if err != nil {
+			return nil, err
+		}
+		d.SetLogStore(lstore)
+		r.Lock()
+		r.data[d.DataName()] = d
+		r.Unlock()
+		added = append(added, d)
+		dvid.Infof("Replicating new data instance %q of repo %s\n", d.DataName(), r.uuid)
+	}
+	for _, name := range removed {
+		dvid.Infof("Deleting data instance %q of repo %s, which was deleted at leader\n", name, r.uuid)
+		if err := r.deleteData(name); err != nil {
+			return nil, err
+		}
+	}
+	for d, rd := range existing {
+		copier, ok := d.(PropertyCopier)
+		if !ok {
+			continue
+		}
+		if dv, needsUpdate := rd.(VersionRemapper); needsUpdate {
+			if err := dv.RemapVersions(versionMap); err != nil {
+				return nil, err
+			}
+		}
+		if err := copier.CopyPropertiesFrom(rd, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if created {
+		if err := r.initMutationID(manager.store, manager.mutationIDStart); err != nil {
+			return nil, err
+		}
+	}
+	if err := manager.addRepo(r); err != nil {
+		return nil, err
+	}
+	r.RLock()
+	branchHeads := r.branchHeads()
+	r.RUnlock()
+	manager.branchMutex.Lock()
+	for branch, head := range branchHeads {
+		if branch == "" {
+			branch = "master"
+		}
+		manager.branchToUUID[string(r.uuid)+branch] = head
+	}
+	manager.branchMutex.Unlock()
+
+	// Start any processing of new data instances and their syncs.
+	for _, d := range added {
+		if initializer, ok := d.(DataInitializer); ok {
+			if err := initializer.InitDataHandlers(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, d := range added {
+		syncer, syncable := d.(Syncer)
+		if !syncable {
+			continue
+		}
+		for u := range syncer.SyncedData() {
+			syncedData, err := manager.getDataByDataUUID(u)
+			if err != nil {
+				dvid.Errorf("Skipping sync of replicated data %q with missing data uuid %s\n", d.DataName(), u)
+				continue
+			}
+			subs, err := syncer.GetSyncSubs(syncedData)
+			if err != nil {
+				dvid.Errorf("Skipping bad sync of replicated data %q to data %q: %v\n", d.DataName(), syncedData.DataName(), err)
+				continue
+			}
+			r.addSyncGraph(subs)
+		}
+	}
+	return r, nil
+}
+
+// copyVersion replaces the local key-values of a version with those at the leader,
+// checkpointing after each page of key-values received.
+func (rep *Replica) copyVersion(r *repoT, uuid dvid.UUID, v dvid.VersionID) error {
+	instances := replicaInstances(r)
+
+	rep.updateStatus(func(status *ReplicaStatus) { status.Copying = uuid })
+	defer rep.updateStatus(func(status *ReplicaStatus) { status.Copying = "" })
+
+	cursor := rep.cp.Cursor
+	if cursor.Version != uuid {
+		cursor = replicaCursor{Version: uuid}
+	}
+	if !cursor.LogsCopied {
+		for _, d := range instances {
+			if d.DataUUID() < cursor.LogData {
+				continue
+			}
+			var offset int64
+			if d.DataUUID() == cursor.LogData {
+				offset = cursor.LogOffset
+			}
+			if err := rep.copyLog(d, uuid, offset); err != nil {
+				return err
+			}
+		}
+		cursor = replicaCursor{Version: uuid, LogsCopied: true}
+		rep.cp.Cursor = cursor
+		if err := rep.cp.save(rep.root); err != nil {
+			return err
+		}
+	}
+	for _, d := range instances {
+		if d.DataUUID() < cursor.Data {
+			continue
+		}
+		var after storage.Key
+		if d.DataUUID() == cursor.Data {
+			after = cursor.After
+		}
+		if after == nil {
+			if err := deleteVersionKVs(d, v); err != nil {
+				return err
+			}
+		}
+		for {
+			page, err := rep.src.getKVs(&replicaKVsRequest{
+				DataUUID: d.DataUUID(),
+				Version:  uuid,
+				After:    after,
+				Max:      replicaPageSize,
+			})
+			if err != nil {
+				return err
+			}
+			if len(page.KVs) != 0 {
+				after = append(storage.Key{}, page.KVs[len(page.KVs)-1].K...)
+			}
+			if err := putReplicaKVs(d, v, page.KVs); err != nil {
+				return err
+			}
+			var size uint64
+			for _, kv := range page.KVs {
+				size += uint64(len(kv.K) + len(kv.V))
+			}
+			rep.updateStatus(func(status *ReplicaStatus) {
+				status.KeyValuesCopied += uint64(len(page.KVs))
+				status.BytesCopied += size
+			})
+			rep.cp.Cursor = replicaCursor{Version: uuid, LogsCopied: true, Data: d.DataUUID(), After: after}
+			if err := rep.cp.save(rep.root); err != nil {
+				return err
+			}
+			if page.Done {
+				break
+			}
+		}
+	}
+	rep.cp.Copied[uuid] = true
+	delete(rep.cp.Replayed, uuid)
+	rep.cp.Cursor = replicaCursor{}
+	dvid.Infof("Replicated committed version %s of repo %s from %s\n", uuid, rep.root, rep.leader)
+	return rep.cp.save(rep.root)
+}
+
+// copyUnversioned replaces the local unversioned key-values of a data instance with those
+// at the leader.
+func (rep *Replica) copyUnversioned(d DataService) error {
+	stale := make(map[string]bool)
+	if _, err := rangeVersion(d, 0, nil, true, func(kv *storage.KeyValue) bool {
+		stale[string(kv.K)] = true
+		return true
+	}); err != nil {
+		return err
+	}
+	var after storage.Key
+	for {
+		page, err := rep.src.getKVs(&replicaKVsRequest{
+			DataUUID: d.DataUUID(),
+			Version:  dvid.NilUUID,
+			After:    after,
+			Max:      replicaPageSize,
+		})
+		if err != nil {
+			return err
+		}
+		if len(page.KVs) != 0 {
+			after = append(storage.Key{}, page.KVs[len(page.KVs)-1].K...)
+		}
+		if err := putReplicaKVs(d, 0, page.KVs); err != nil {
+			return err
+		}
+		for _, kv := range page.KVs {
+			delete(stale, string(kv.K))
+		}
+		if page.Done {
+			break
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	store, err := GetKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	for k := range stale {
+		if err := store.RawDelete(storage.Key(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replicaInstances returns the data instances of a replicated repo in order of data UUID.
+func replicaInstances(r *repoT) []DataService {
+	r.RLock()
+	instances := make([]DataService, 0, len(r.data))
+	for _, d := range r.data {
+		if !d.IsDeleted() {
+			instances = append(instances, d)
+		}
+	}
+	r.RUnlock()
+	sort.Slice(instances, func(i, j int) bool { return instances[i].DataUUID() < instances[j].DataUUID() })
+	return instances
+}
+
+// loadReplicaMutables reloads the mutable properties of data instances, e.g., the max
+// labels of a labelmap, after their key-values have been copied from the leader.
+func loadReplicaMutables(r *repoT, instances []DataService) error {
+	for _, d := range instances {
+		if mutator, mutable := d.(InstanceMutator); mutable {
+			if _, err := mutator.LoadMutable(r.version, RepoFormatVersion, RepoFormatVersion); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyLog replaces the local log of a version of a data instance with the leader's,
+// starting at the given byte offset in the leader's log and checkpointing after each page
+// of log messages received.
+func (rep *Replica) copyLog(d DataService, uuid dvid.UUID, offset int64) error {
+	var wl storage.WriteLog
+	if lw, ok := d.(logWriter); ok {
+		wl = lw.GetWriteLog()
+	}
+	if offset == 0 && wl != nil {
+		dl, ok := wl.(storage.DeletableLog)
+		if !ok {
+			return fmt.Errorf("log store %s of data %q can't replace logs for replication", wl, d.DataName())
+		}
+		if err := dl.Delete(d.DataUUID(), uuid); err != nil {
+			return err
+		}
+	}
+	for {
+		page, err := rep.src.getLog(&replicaLogRequest{
+			DataUUID: d.DataUUID(),
+			Version:  uuid,
+			Offset:   offset,
+			Max:      replicaPageSize,
+		})
+		if err != nil {
+			return err
+		}
+		if len(page.Msgs) != 0 && wl == nil {
+			return fmt.Errorf("data %q has no log store for the leader's log messages", d.DataName())
+		}
+		var size uint64
+		for _, msg := range page.Msgs {
+			if err := wl.Append(d.DataUUID(), uuid, msg); err != nil {
+				return err
+			}
+			size += uint64(len(msg.Data))
+		}
+		offset = page.Next
+		rep.updateStatus(func(status *ReplicaStatus) {
+			status.LogMessagesCopied += uint64(len(page.Msgs))
+			status.BytesCopied += size
+		})
+		rep.cp.Cursor = replicaCursor{Version: uuid, LogData: d.DataUUID(), LogOffset: offset}
+		if err := rep.cp.save(rep.root); err != nil {
+			return err
+		}
+		if page.Done {
+			return nil
+		}
+	}
+}
+
+// putReplicaKVs stores key-values received from a leader after converting their keys
+// to the local instance and version IDs.
+func putReplicaKVs(d dvid.Data, v dvid.VersionID, kvs []storage.KeyValue) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+	store, err := GetKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	for i := range kvs {
+		kv := &kvs[i]
+		if err := storage.UpdateDataKey(kv.K, d.InstanceID(), v, 0); err != nil {
+			return fmt.Errorf("unable to update data key %v: %v", kv.K, err)
+		}
+		if err := store.RawPut(kv.K, kv.V); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteVersionKVs deletes the key-values written in a version of a data instance.
+func deleteVersionKVs(d dvid.Data, v dvid.VersionID) error {
+	var keys []storage.Key
+	if _, err := rangeVersion(d, v, nil, true, func(kv *storage.KeyValue) bool {
+		keys = append(keys, kv.K)
+		return true
+	}); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	store, err := GetKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := store.RawDelete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
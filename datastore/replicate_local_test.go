@@ -0,0 +1,376 @@
+// +build !clustered,!gcloud
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datatype/common/proto"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	_ "github.com/janelia-flyem/dvid/storage/filelog"
+)
+
+// testLeader simulates a leader's repo and the key-values written in each version.
+type testLeader struct {
+	repo   *repoT
+	data   *TestData
+	kvs    map[dvid.UUID][]storage.KeyValue // sorted key-values for each version or NilUUID if unversioned
+	logs   map[dvid.UUID][]storage.LogMessage
+	failAt int // fail the nth key-value request for a version if > 0
+	calls  []*replicaKVsRequest
+}
+
+func newTestLeader() *testLeader {
+	compression, _ := dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
+	root := dvid.NewUUID()
+	leader := &testLeader{
+		repo: newRepo(root, 1, 1, ""),
+		data: &TestData{&Data{
+			typename:    "testtype",
+			typeurl:     "foo.bar.baz/testtype",
+			typeversion: "1.0",
+			id:          dvid.InstanceID(100),
+			name:        "replicated",
+			rootUUID:    root,
+			dataUUID:    dvid.NewUUID(),
+			compression: compression,
+			checksum:    dvid.DefaultChecksum,
+		}},
+		kvs:  make(map[dvid.UUID][]storage.KeyValue),
+		logs: make(map[dvid.UUID][]storage.LogMessage),
+	}
+	leader.repo.data[leader.data.name] = leader.data
+	return leader
+}
+
+// addVersion adds an open child version to the leader's DAG.
+func (l *testLeader) addVersion(parent, v dvid.VersionID) dvid.UUID {
+	node := newNode(dvid.NewUUID(), v)
+	node.parents = []dvid.VersionID{parent}
+	l.repo.dag.nodes[parent].children = append(l.repo.dag.nodes[parent].children, v)
+	l.repo.dag.nodes[v] = node
+	return node.uuid
+}
+
+func (l *testLeader) put(v dvid.VersionID, keys ...string) {
+	ctx := NewVersionedCtx(l.data, v)
+	uuid := l.repo.dag.nodes[v].uuid
+	for _, key := range keys {
+		k := ctx.ConstructKey(storage.NewTKey(1, []byte(key)))
+		l.kvs[uuid] = append(l.kvs[uuid], storage.KeyValue{K: k, V: []byte("value " + key)})
+	}
+}
+
+// putUnversioned replaces the unversioned key-values at the leader.
+func (l *testLeader) putUnversioned(keys ...string) {
+	ctx := storage.NewDataContext(l.data, 0)
+	l.kvs[dvid.NilUUID] = nil
+	for _, key := range keys {
+		k := ctx.ConstructKey(storage.NewTKey(2, []byte(key)))
+		l.kvs[dvid.NilUUID] = append(l.kvs[dvid.NilUUID], storage.KeyValue{K: k, V: []byte("value " + key)})
+	}
+}
+
+// merge logs a labelmap merge of supervoxels into a label.
+func (l *testLeader) merge(t *testing.T, v dvid.VersionID, mutID, target uint64, supervoxels ...uint64) {
+	op := proto.MappingOp{Mutid: mutID, Mapped: target, Original: supervoxels}
+	data, err := op.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uuid := l.repo.dag.nodes[v].uuid
+	l.logs[uuid] = append(l.logs[uuid], storage.LogMessage{EntryType: proto.MappingOpType, Data: data})
+}
+
+func (l *testLeader) getRepo(uuid dvid.UUID) ([]byte, error) {
+	return l.repo.GobEncode()
+}
+
+func (l *testLeader) getKVs(req *replicaKVsRequest) (*replicaKVs, error) {
+	if req.Version != dvid.NilUUID {
+		l.calls = append(l.calls, req)
+		if l.failAt > 0 && len(l.calls) == l.failAt {
+			return nil, fmt.Errorf("lost connection to leader")
+		}
+	}
+	page := new(replicaKVs)
+	for _, kv := range l.kvs[req.Version] {
+		if req.After != nil && bytes.Compare(kv.K, req.After) <= 0 {
+			continue
+		}
+		if len(page.KVs) == req.Max {
+			return page, nil
+		}
+		page.KVs = append(page.KVs, storage.KeyValue{K: append(storage.Key{}, kv.K...), V: kv.V})
+	}
+	page.Done = true
+	return page, nil
+}
+
+// getLog pages log messages with an offset that is the index of the message.
+func (l *testLeader) getLog(req *replicaLogRequest) (*replicaLog, error) {
+	msgs := l.logs[req.Version]
+	page := &replicaLog{Next: req.Offset}
+	for i := int(req.Offset); i < len(msgs) && len(page.Msgs) < req.Max; i++ {
+		page.Msgs = append(page.Msgs, msgs[i])
+		page.Next = int64(i + 1)
+	}
+	page.Done = int(page.Next) == len(msgs)
+	return page, nil
+}
+
+func (l *testLeader) call(method string, req interface{}) (interface{}, error) {
+	return nil, fmt.Errorf("unexpected call %q", method)
+}
+
+func (l *testLeader) close() error {
+	return nil
+}
+
+func checkReplicaValues(t *testing.T, uuid dvid.UUID, expected map[string]string) {
+	d, err := GetDataByUUIDName(uuid, "replicated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := VersionFromUUID(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := GetOrderedKeyValueDB(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]string)
+	if _, err := rangeVersion(d, v, nil, false, func(kv *storage.KeyValue) bool {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := tk.ClassBytes(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found[string(key)] = string(kv.V)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected replicated key-values in version %s:\n%v\ngot:\n%v\n", uuid, expected, found)
+	}
+	value, err := store.Get(NewVersionedCtx(d, v), storage.NewTKey(1, []byte("a")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "value a" {
+		t.Errorf("expected versioned read of key a in %s to find root value, got %q\n", uuid, value)
+	}
+}
+
+// checkReplicaUnversioned checks the replicated unversioned key-values.
+func checkReplicaUnversioned(t *testing.T, uuid dvid.UUID, expected map[string]string) {
+	d, err := GetDataByUUIDName(uuid, "replicated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]string)
+	if _, err := rangeVersion(d, 0, nil, false, func(kv *storage.KeyValue) bool {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := tk.ClassBytes(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found[string(key)] = string(kv.V)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected replicated unversioned key-values:\n%v\ngot:\n%v\n", expected, found)
+	}
+}
+
+// checkReplicaMerges checks the replicated log of labelmap merges for a version.
+func checkReplicaMerges(t *testing.T, uuid dvid.UUID, expected map[uint64]uint64) {
+	d, err := GetDataByUUIDName(uuid, "replicated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := d.(logReader).GetReadLog()
+	if rl == nil {
+		t.Fatalf("no log store for replicated data\n")
+	}
+	msgs, err := rl.ReadAll(d.DataUUID(), uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := make(map[uint64]uint64)
+	for _, msg := range msgs {
+		var op proto.MappingOp
+		if err := op.Unmarshal(msg.Data); err != nil {
+			t.Fatal(err)
+		}
+		for _, supervoxel := range op.GetOriginal() {
+			mapping[supervoxel] = op.GetMapped()
+		}
+	}
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("expected replicated merges in version %s:\n%v\ngot:\n%v\n", uuid, expected, mapping)
+	}
+}
+
+func TestReplica(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	replicaPageSize = 2
+	defer func() { replicaPageSize = 1000 }()
+
+	leader := newTestLeader()
+	root := leader.repo.uuid
+	leader.repo.dag.nodes[1].locked = true
+	leader.put(1, "a", "b", "c")
+	leader.putUnversioned("max", "count")
+	leader.merge(t, 1, 1, 10, 11, 12)
+	leader.merge(t, 1, 2, 10, 13)
+	leader.merge(t, 1, 3, 20, 21)
+	child := leader.addVersion(1, 2)
+	leader.put(2, "d")
+
+	rep := NewReplica("leader", root)
+	rep.src = leader
+	open, err := rep.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(open, []dvid.UUID{child}) {
+		t.Errorf("expected open version %s, got %v\n", child, open)
+	}
+	if locked, err := LockedUUID(root); err != nil || !locked {
+		t.Errorf("expected replicated root %s to be committed: %v\n", root, err)
+	}
+	if locked, err := LockedUUID(child); err != nil || locked {
+		t.Errorf("expected replicated child %s to be open: %v\n", child, err)
+	}
+	checkReplicaValues(t, root, map[string]string{"a": "value a", "b": "value b", "c": "value c"})
+	checkReplicaMerges(t, root, map[uint64]uint64{11: 10, 12: 10, 13: 10, 21: 20})
+	checkReplicaUnversioned(t, root, map[string]string{"max": "value max", "count": "value count"})
+	if status := rep.Status(); status.CopiedVersions != 1 || status.KeyValuesCopied != 3 || status.LogMessagesCopied != 3 || status.Versions != 2 {
+		t.Errorf("unexpected replica status after first sync: %+v\n", status)
+	}
+
+	// Check that the key-values of a version can be paged at a leader.
+	d, err := GetDataByUUIDName(root, "replicated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootV, err := VersionFromUUID(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := getReplicaKVs(d, rootV, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.KVs) != 2 || page.Done {
+		t.Fatalf("expected first page of 2 key-values that isn't done, got %d, done %t\n", len(page.KVs), page.Done)
+	}
+	page, err = getReplicaKVs(d, rootV, page.KVs[1].K, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.KVs) != 1 || !page.Done || string(page.KVs[0].V) != "value c" {
+		t.Fatalf("expected last page with key c, got %v, done %t\n", page.KVs, page.Done)
+	}
+
+	// Replayed mutations in the open child should be replaced by the leader's key-values
+	// once the child is committed.
+	childV, err := VersionFromUUID(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := GetOrderedKeyValueDB(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(NewVersionedCtx(d, childV), storage.NewTKey(1, []byte("stale")), []byte("replayed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rep.SetReplayOffset(child, 400, 4); err != nil {
+		t.Fatal(err)
+	}
+	childData, err := GetDataByUUIDName(child, "replicated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := proto.MappingOp{Mutid: 4, Mapped: 30, Original: []uint64{31}}
+	opData, err := replayed.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wl := childData.(logWriter).GetWriteLog()
+	if err := wl.Append(childData.DataUUID(), child, storage.LogMessage{EntryType: proto.MappingOpType, Data: opData}); err != nil {
+		t.Fatal(err)
+	}
+	leader.repo.dag.nodes[2].locked = true
+	leader.putUnversioned("max")
+	leader.put(2, "e", "f")
+	leader.merge(t, 2, 4, 30, 31, 32)
+	grandchild := leader.addVersion(2, 3)
+	leader.put(3, "g", "h", "i")
+	if open, err = rep.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(open, []dvid.UUID{grandchild}) {
+		t.Errorf("expected open version %s, got %v\n", grandchild, open)
+	}
+	checkReplicaValues(t, child, map[string]string{"d": "value d", "e": "value e", "f": "value f"})
+	checkReplicaMerges(t, child, map[uint64]uint64{31: 30, 32: 30})
+	checkReplicaUnversioned(t, child, map[string]string{"max": "value max"})
+	if parents, err := GetParentsByVersion(childV); err != nil || !reflect.DeepEqual(parents, []dvid.VersionID{rootV}) {
+		t.Errorf("expected replicated child to have parent %d, got %v: %v\n", rootV, parents, err)
+	}
+	if rep.ReplayOffset(child) != 0 {
+		t.Errorf("expected no replayed mutations for committed child, got offset %d\n", rep.ReplayOffset(child))
+	}
+
+	// Interrupt the copy of the committed grandchild and make sure it resumes from checkpoint.
+	if err := rep.SetReplayOffset(grandchild, 700, 7); err != nil {
+		t.Fatal(err)
+	}
+	leader.repo.dag.nodes[3].locked = true
+	leader.calls = nil
+	leader.failAt = 2
+	if _, err = rep.Sync(); err == nil {
+		t.Fatalf("expected error on interrupted sync\n")
+	}
+	if status := rep.Status(); status.LastError == "" || status.Connected {
+		t.Errorf("expected replica error status after interrupted sync, got %+v\n", status)
+	}
+
+	rep2 := NewReplica("leader", root)
+	rep2.src = leader
+	leader.calls = nil
+	leader.failAt = 0
+	if open, err = rep2.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(open) != 0 {
+		t.Errorf("expected no open versions, got %v\n", open)
+	}
+	if len(leader.calls) != 1 || leader.calls[0].After == nil {
+		t.Errorf("expected resumed copy with one request after checkpointed key, got %d requests\n", len(leader.calls))
+	}
+	checkReplicaValues(t, grandchild, map[string]string{"g": "value g", "h": "value h", "i": "value i"})
+	if status := rep2.Status(); status.CopiedVersions != 3 || status.Versions != 3 {
+		t.Errorf("unexpected replica status after resumed sync: %+v\n", status)
+	}
+}
@@ -7,7 +7,14 @@
 
 package datastore
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
 
 // MergeType describes the expectation of processing for the merge, e.g., is it
 // expected to be free of conflicts at the key-value level, require automated
@@ -46,3 +53,20 @@ var (
 	ErrBranchUnlockedNode = errors.New("can't branch an unlocked node")
 	ErrBranchUnique       = errors.New("branch already exists with given name")
 )
+
+// MergeConflictError is returned by a MergeTypeSpecificAuto merge when one or more
+// data instances have changes in the parents that could not be automatically
+// resolved.  Conflicts holds a type-specific description of the conflicts for each
+// data instance that had them.  No child version is created when this error is returned.
+type MergeConflictError struct {
+	Conflicts map[dvid.InstanceName]interface{}
+}
+
+func (e *MergeConflictError) Error() string {
+	names := make([]string, 0, len(e.Conflicts))
+	for name := range e.Conflicts {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("merge conflicts in data instances: %s", strings.Join(names, ", "))
+}
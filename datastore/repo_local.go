@@ -54,6 +54,7 @@ const (
 	formatKey
 	ServerLockKey // name of key for locking metadata globally
 	mutidKey
+	replicaKey
 )
 
 // Config specifies new instance and mutation ID generation
@@ -946,6 +947,9 @@ func (m *repoManager) matchingUUID(str string) (dvid.UUID, dvid.VersionID, error
 func (m *repoManager) addRepo(r *repoT) error {
 	m.repoMutex.Lock()
 	m.repos[r.uuid] = r
+	for _, node := range r.dag.nodes {
+		m.repos[node.uuid] = r
+	}
 	m.repoMutex.Unlock()
 
 	m.idMutex.Lock()
@@ -1670,13 +1674,34 @@ func (m *repoManager) merge(parents []dvid.UUID, note string, mt MergeType) (dvi
 	}
 	m.repoMutex.RUnlock()
 
+	// For type-specific merges, make sure all data instances can merge before
+	// creating the child.
+	var ancestor dvid.VersionID
+	var parentVs []dvid.VersionID
+	var mergers []VersionMerger
+	if mt == MergeTypeSpecificAuto {
+		var err error
+		if ancestor, parentVs, mergers, err = m.checkMergeConflicts(r, parents); err != nil {
+			return dvid.NilUUID, err
+		}
+	}
+
 	// Add the child node.  Since it's new and unavailable, no need to lock it.
 	childUUID, childV, err := m.newUUID(nil)
+	child := newNode(childUUID, childV)
+	child.note = note
+
+	// Type-specific merges need the child in the DAG to read through its ancestry, so
+	// a failed merge removes the child instead.
+	var merged bool
+	defer func() {
+		if !merged {
+			m.removeFailedChild(r, child)
+		}
+	}()
 	if err != nil {
 		return dvid.NilUUID, err
 	}
-	child := newNode(childUUID, childV)
-	child.note = note
 
 	m.repoMutex.Lock()
 	m.repos[childUUID] = r
@@ -1736,8 +1761,12 @@ func (m *repoManager) merge(parents []dvid.UUID, note string, mt MergeType) (dvi
 		// Any issues will be noted during key-value lookup while traversing the DAG.
 
 	case MergeTypeSpecificAuto:
-		return dvid.NilUUID, fmt.Errorf("the type-specific auto merge has not been implemented yet")
-		// go r.asyncMerge(parentNode1, parentNode2, child)
+		// Data instances that aren't VersionMergers are handled like conflict-free merges.
+		for _, merger := range mergers {
+			if err := merger.MergeVersions(ancestor, parentVs, childV); err != nil {
+				return dvid.NilUUID, err
+			}
+		}
 
 	case MergeExternalData:
 		return dvid.NilUUID, fmt.Errorf("merging with external data has not been implemented yet")
@@ -1746,12 +1775,171 @@ func (m *repoManager) merge(parents []dvid.UUID, note string, mt MergeType) (dvi
 		return dvid.NilUUID, ErrBadMergeType
 	}
 
+	merged = true
 	r.Lock()
 	r.updated = time.Now()
 	r.Unlock()
 	return child.uuid, r.save()
 }
 
+// removeFailedChild removes a child version whose merge failed from its parents, the
+// repo DAG, and the version maps.  Any data already written to the child version is
+// unreachable once its version ID is removed, except logs, which are deleted.
+func (m *repoManager) removeFailedChild(r *repoT, child *nodeT) {
+	dvid.Errorf("Removing version %s after failed merge\n", child.uuid)
+	r.RLock()
+	for _, dataservice := range r.data {
+		if lw, ok := dataservice.(logWriter); ok {
+			if log, ok := lw.GetWriteLog().(storage.DeletableLog); ok {
+				if err := log.Delete(dataservice.DataUUID(), child.uuid); err != nil {
+					dvid.Errorf("Unable to delete log of data %q for failed merge version %s: %v\n", dataservice.DataName(), child.uuid, err)
+				}
+			}
+		}
+	}
+	for _, v := range child.parents {
+		node, found := r.dag.nodes[v]
+		if !found {
+			continue
+		}
+		node.Lock()
+		for i, childV := range node.children {
+			if childV == child.version {
+				node.children = append(node.children[:i], node.children[i+1:]...)
+				break
+			}
+		}
+		node.Unlock()
+	}
+	r.RUnlock()
+
+	r.Lock()
+	delete(r.dag.nodes, child.version)
+	r.Unlock()
+
+	m.repoMutex.Lock()
+	delete(m.repos, child.uuid)
+	m.repoMutex.Unlock()
+
+	m.idMutex.Lock()
+	delete(m.uuidToVersion, child.uuid)
+	delete(m.versionToUUID, child.version)
+	m.idMutex.Unlock()
+	if err := m.putCaches(); err != nil {
+		dvid.Errorf("Unable to save version maps after removing version %s: %v\n", child.uuid, err)
+	}
+	if err := r.save(); err != nil {
+		dvid.Errorf("Unable to save repo after removing version %s: %v\n", child.uuid, err)
+	}
+}
+
+// checkMergeConflicts determines the common ancestor of the given locked parents and
+// asks every VersionMerger data instance whether it can merge the parents' changes.
+// A *MergeConflictError is returned if any data instance has conflicts.
+func (m *repoManager) checkMergeConflicts(r *repoT, parents []dvid.UUID) (ancestor dvid.VersionID, parentVs []dvid.VersionID, mergers []VersionMerger, err error) {
+	parentVs = make([]dvid.VersionID, len(parents))
+	for i, parent := range parents {
+		if parentVs[i], err = m.versionFromUUID(parent); err != nil {
+			return
+		}
+		r.RLock()
+		node, found := r.dag.nodes[parentVs[i]]
+		r.RUnlock()
+		if !found {
+			err = ErrInvalidVersion
+			return
+		}
+		node.RLock()
+		locked := node.locked
+		node.RUnlock()
+		if !locked {
+			err = ErrBranchUnlockedNode
+			return
+		}
+	}
+	if ancestor, err = m.getCommonAncestor(parentVs); err != nil {
+		return
+	}
+
+	// The conflict checks can scan many keys so don't hold the repo lock during them.
+	var dataservices []DataService
+	r.RLock()
+	for _, dataservice := range r.data {
+		dataservices = append(dataservices, dataservice)
+	}
+	r.RUnlock()
+
+	conflicts := make(map[dvid.InstanceName]interface{})
+	for _, dataservice := range dataservices {
+		merger, ok := dataservice.(VersionMerger)
+		if !ok {
+			continue
+		}
+		var c interface{}
+		if c, err = merger.MergeConflicts(ancestor, parentVs); err != nil {
+			return
+		}
+		if c != nil {
+			conflicts[dataservice.DataName()] = c
+		}
+		mergers = append(mergers, merger)
+	}
+	if len(conflicts) != 0 {
+		err = &MergeConflictError{Conflicts: conflicts}
+	}
+	return
+}
+
+// adds the given version and all its ancestors, following all parents, to the set.
+func (m *repoManager) addAllAncestors(v dvid.VersionID, ancestors map[dvid.VersionID]struct{}) error {
+	if _, found := ancestors[v]; found {
+		return nil
+	}
+	ancestors[v] = struct{}{}
+	parents, err := m.getParentsByVersion(v)
+	if err != nil {
+		return err
+	}
+	for _, parent := range parents {
+		if err := m.addAllAncestors(parent, ancestors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the closest version along the ancestry of the first version that is an
+// ancestor of all the other versions.
+func (m *repoManager) getCommonAncestor(versions []dvid.VersionID) (dvid.VersionID, error) {
+	if len(versions) == 0 {
+		return 0, fmt.Errorf("no versions given for common ancestor")
+	}
+	ancestorSets := make([]map[dvid.VersionID]struct{}, len(versions)-1)
+	for i, v := range versions[1:] {
+		ancestorSets[i] = make(map[dvid.VersionID]struct{})
+		if err := m.addAllAncestors(v, ancestorSets[i]); err != nil {
+			return 0, err
+		}
+	}
+	ancestry, err := m.getAncestry(versions[0])
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range ancestry {
+		common := true
+		for _, ancestorSet := range ancestorSets {
+			if _, found := ancestorSet[v]; !found {
+				common = false
+				break
+			}
+		}
+		if common {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("versions %v have no common ancestor", versions)
+}
+
 func (m *repoManager) invalidateAncestors(kvv kvVersions, v dvid.VersionID) error {
 	parents, err := m.getParentsByVersion(v)
 	if err != nil {
@@ -2460,17 +2648,17 @@ func (r *repoT) saveToStore(db storage.OrderedKeyValueDB) error {
 	if db == nil {
 		return fmt.Errorf("cannot save repo to nil store")
 	}
-	r.RLock()
 	compression, err := dvid.NewCompression(dvid.LZ4, dvid.DefaultCompression)
 	if err != nil {
 		return err
 	}
+	r.RLock()
 	serialization, err := dvid.Serialize(r, compression, dvid.CRC32)
+	tk := r.id.Bytes()
+	r.RUnlock()
 	if err != nil {
 		return err
 	}
-	tk := r.id.Bytes()
-	r.RUnlock()
 
 	var ctx storage.MetadataContext
 	return db.Put(ctx, storage.NewTKey(repoKey, tk), serialization)
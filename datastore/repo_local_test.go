@@ -3,6 +3,7 @@
 package datastore
 
 import (
+	"encoding/gob"
 	"fmt"
 	"reflect"
 	"strings"
@@ -238,3 +239,91 @@ func TestUUIDAssignment(t *testing.T) {
 		t.Errorf("Error getting back correct UUID %s from %s\n", myuuid, uuid)
 	}
 }
+
+func init() {
+	gob.Register(failingMerger{})
+}
+
+// failingMerger is a data instance whose type-specific merges always fail.
+type failingMerger struct {
+	*TestData
+}
+
+func (d failingMerger) MergeConflicts(ancestor dvid.VersionID, parents []dvid.VersionID) (interface{}, error) {
+	return nil, nil
+}
+
+func (d failingMerger) MergeVersions(ancestor dvid.VersionID, parents []dvid.VersionID, child dvid.VersionID) error {
+	return fmt.Errorf("merge failed")
+}
+
+func TestFailedMergeRemovesChild(t *testing.T) {
+	OpenTest()
+	defer CloseTest()
+
+	root, _ := NewTestRepo()
+	if err := Commit(root, "root", nil); err != nil {
+		t.Fatal(err)
+	}
+	parents := make([]dvid.UUID, 2)
+	for i := range parents {
+		var err error
+		if parents[i], err = NewVersion(root, fmt.Sprintf("parent %d", i), fmt.Sprintf("branch%d", i), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := Commit(parents[i], "parent", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := manager.repoFromUUID(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merger := failingMerger{&TestData{&Data{name: "failmerge", dataUUID: dvid.NewUUID()}}}
+	r.Lock()
+	r.data[merger.DataName()] = merger
+	numNodes := len(r.dag.nodes)
+	r.Unlock()
+
+	if _, err := Merge(parents, "failed merge", MergeTypeSpecificAuto); err == nil {
+		t.Fatalf("expected merge to fail\n")
+	}
+	r.RLock()
+	if len(r.dag.nodes) != numNodes {
+		t.Errorf("expected failed merge child to be removed from DAG, got %d nodes instead of %d\n", len(r.dag.nodes), numNodes)
+	}
+	r.RUnlock()
+	for _, parent := range parents {
+		v, err := VersionFromUUID(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		children, err := GetChildrenByVersion(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(children) != 0 {
+			t.Errorf("expected no children of parent %s after failed merge, got %v\n", parent, children)
+		}
+	}
+	manager.repoMutex.RLock()
+	for uuid, repo := range manager.repos {
+		if repo == r {
+			if _, err := VersionFromUUID(uuid); err != nil {
+				t.Errorf("repo has UUID %s without version: %v\n", uuid, err)
+			}
+		}
+	}
+	manager.repoMutex.RUnlock()
+
+	r.Lock()
+	delete(r.data, merger.DataName())
+	r.Unlock()
+	child, err := Merge(parents, "merge", MergeTypeSpecificAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VersionFromUUID(child); err != nil {
+		t.Errorf("expected merged child %s: %v\n", child, err)
+	}
+}
@@ -189,6 +189,54 @@ GET <api URL>/node/<UUID>/<data name>/roi/<ROI specification>
 
 	The returned point annotations will be an array of elements.
 
+GET <api URL>/node/<UUID>/<data name>/connections/<label>
+
+	Returns the upstream and downstream partners of the body with the given label in the
+	synced label instance.  A connection from body A to body B has a weight equal to the 
+	number of "PreSynTo" relationships from PreSyn elements in A to points in B.  
+	Connection weights are maintained as labels are merged, cleaved, or split and as
+	elements are added, moved, or deleted.  Relationships are expected to be recorded in
+	both directions, i.e., a PostSyn element should have a "PostSynTo" relationship for 
+	each PreSyn element that has a "PreSynTo" relationship to it.  Connection weights for
+	annotations added before connection support can be computed using the /reload endpoint.
+
+	Partners are ordered by decreasing weight.  Example returned JSON:
+
+	{
+		"body": 23,
+		"upstream": [ {"body": 17, "weight": 12}, {"body": 9, "weight": 3} ],
+		"downstream": [ {"body": 41, "weight": 7} ]
+	}
+
+POST <api URL>/node/<UUID>/<data name>/connectivity
+
+	Returns the weighted connection table among the bodies given in the POSTed JSON array
+	of labels, e.g., [23, 17, 41].  Only connections where both the pre- and post-synaptic
+	bodies are in the given set are returned.  See the /connections endpoint for a 
+	description of connection weights.  Example returned JSON:
+
+	[
+		{"pre": 17, "post": 23, "weight": 12},
+		{"pre": 23, "post": 41, "weight": 7}
+	]
+
+GET <api URL>/node/<UUID>/<data name>/connectivity/<ROI specification>
+
+	Returns the weighted connection table, in the same format as the POST above, among all 
+	bodies with PreSyn or PostSyn elements within the ROI.  The ROI specification is the 
+	same as for the /roi endpoint.
+
+GET <api URL>/node/<UUID>/<data name>/diff/<UUID B>
+
+	Streams the elements that differ from version <UUID> to version <UUID B>, which must be
+	in the same repo, as JSON lines.  Added elements include the element in <UUID B>, deleted
+	elements include the previous element in <UUID>, and modified elements, e.g., with changed
+	tags, properties or relationships, include both.  Example:
+
+	{"pos": [23, 45, 67], "change": "added", "element": {"Pos": [23, 45, 67], "Kind": "PostSyn", ...}}
+	{"pos": [24, 46, 68], "change": "deleted", "previous": {"Pos": [24, 46, 68], "Kind": "PreSyn", ...}}
+	{"pos": [25, 47, 69], "change": "modified", "element": {...}, "previous": {...}}
+
 GET <api URL>/node/<UUID>/<data name>/elements/<size>/<offset>
 
 	Returns all point annotations within subvolume of given size with upper left corner
@@ -1091,6 +1139,8 @@ type Data struct {
 
 	denormOngoing bool // true if we are doing denormalizations so avoid ops on them.
 
+	connMu sync.Mutex // serializes read-modify-write of connection counts.
+
 	sync.RWMutex // For CAS ops.  TODO: Make more specific (e.g., point locks) for efficiency.
 }
 
@@ -1293,6 +1343,7 @@ func (d *Data) deleteElementInLabel(ctx *datastore.VersionedCtx, batch storage.B
 	if err != nil {
 		return err
 	}
+	putElementLabel(batch, pt, 0)
 	tk := NewLabelTKey(label)
 	elems, err := getElementsNR(ctx, tk)
 	if err != nil {
@@ -1401,6 +1452,10 @@ func (d *Data) moveElementInLabels(ctx *datastore.VersionedCtx, batch storage.Ba
 	if err != nil {
 		return err
 	}
+	if moved.Kind == PreSyn || moved.Kind == PostSyn {
+		putElementLabel(batch, from, 0)
+		putElementLabel(batch, to, newLabel)
+	}
 	if oldLabel == newLabel {
 		return nil
 	}
@@ -1708,6 +1763,19 @@ func (d *Data) storeLabelElements(ctx *datastore.VersionedCtx, batch storage.Bat
 		return nil
 	}
 
+	// Elements without a label are no longer counted in connections.
+	labeled := make(map[string]struct{})
+	for _, additions := range toAdd {
+		for _, elem := range additions {
+			labeled[elem.Pos.MapKey()] = struct{}{}
+		}
+	}
+	for _, elem := range elems {
+		if _, found := labeled[elem.Pos.MapKey()]; !found && (elem.Kind == PreSyn || elem.Kind == PostSyn) {
+			putElementLabel(batch, elem.Pos, 0)
+		}
+	}
+
 	// Store all the added annotations to the appropriate labels.
 	var delta DeltaModifyElements
 	for label, additions := range toAdd {
@@ -1723,6 +1791,9 @@ func (d *Data) storeLabelElements(ctx *datastore.VersionedCtx, batch storage.Bat
 			emap[elem.Pos.MapKey()] = i
 		}
 		for _, elem := range additions {
+			if elem.Kind == PreSyn || elem.Kind == PostSyn {
+				putElementLabel(batch, elem.Pos, label)
+			}
 			i, found := emap[elem.Pos.MapKey()]
 			if !found {
 				elems = append(elems, elem)
@@ -2090,6 +2161,17 @@ func (d *Data) StoreElements(ctx *datastore.VersionedCtx, r io.Reader, kafkaOff
 	}
 	batch := batcher.NewBatch(ctx)
 
+	// Get connections of PreSyn elements that could be affected by the new elements.
+	prePts, err := d.getRelatedPreSyn(ctx, elems.positions())
+	if err != nil {
+		return err
+	}
+	prePts = append(prePts, relatedPreSyn(elems)...)
+	oldConns, err := d.connectionsBeforeEdit(ctx, prePts)
+	if err != nil {
+		return err
+	}
+
 	// Store the new block elements
 	if err := d.storeBlockElements(ctx, batch, addToBlock); err != nil {
 		return err
@@ -2130,7 +2212,10 @@ func (d *Data) StoreElements(ctx *datastore.VersionedCtx, r io.Reader, kafkaOff
 		}
 	}
 
-	return batch.Commit()
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	return d.connectionsAfterEdit(ctx, batcher, prePts, oldConns)
 }
 
 func (d *Data) DeleteElement(ctx *datastore.VersionedCtx, pt dvid.Point3d, kafkaOff bool) error {
@@ -2152,6 +2237,11 @@ func (d *Data) DeleteElement(ctx *datastore.VersionedCtx, pt dvid.Point3d, kafka
 	if deleted == nil {
 		return fmt.Errorf("Did not find element %s in datastore", pt)
 	}
+	prePts := relatedPreSyn(Elements{*deleted})
+	oldConns, err := d.connectionsBeforeEdit(ctx, prePts)
+	if err != nil {
+		return err
+	}
 
 	// Put block key version without given element
 	if err := putElements(ctx, tk, elems); err != nil {
@@ -2203,7 +2293,10 @@ func (d *Data) DeleteElement(ctx *datastore.VersionedCtx, pt dvid.Point3d, kafka
 		}
 	}
 
-	return batch.Commit()
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	return d.connectionsAfterEdit(ctx, batcher, prePts, oldConns)
 }
 
 func (d *Data) MoveElement(ctx *datastore.VersionedCtx, from, to dvid.Point3d, kafkaOff bool) error {
@@ -2229,6 +2322,16 @@ func (d *Data) MoveElement(ctx *datastore.VersionedCtx, from, to dvid.Point3d, k
 	}
 	batch := batcher.NewBatch(ctx)
 
+	// Get connections of PreSyn elements that could be affected by the move.
+	fromPrePts, err := d.getRelatedPreSyn(ctx, []dvid.Point3d{from})
+	if err != nil {
+		return err
+	}
+	oldConns, err := d.connectionsBeforeEdit(ctx, fromPrePts)
+	if err != nil {
+		return err
+	}
+
 	// Handle from block
 	fromElems, err := getElements(ctx, fromTk)
 	if err != nil {
@@ -2300,7 +2403,19 @@ func (d *Data) MoveElement(ctx *datastore.VersionedCtx, from, to dvid.Point3d, k
 		return err
 	}
 
-	return batch.Commit()
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	toPrePts := relatedPreSyn(Elements{*moved})
+	if oldConns == nil {
+		return nil
+	}
+	newConns, err := d.countConnectionsAt(ctx, toPrePts, nil)
+	if err != nil {
+		return err
+	}
+	newConns.add(oldConns, -1)
+	return d.applyConnectionDelta(ctx, batcher, newConns)
 }
 
 // RecreateDenormalizations will recreate label and tag denormalizations from
@@ -2509,6 +2624,10 @@ func (d *Data) resyncInMemory(ctx *datastore.VersionedCtx, check bool) {
 	close(ch)
 	wg.Wait()
 	timedLog.Infof("Finished denormalization of %d kvs, %d changed (%d errors)", numProcessed, numChanged, numErrs)
+
+	if err := d.rebuildConnections(ctx); err != nil {
+		dvid.Errorf("Error rebuilding connections of data %q: %v\n", d.DataName(), err)
+	}
 }
 
 // Get all keyBlock kv pairs, forcing the label and tag denormalizations.
@@ -2647,6 +2766,10 @@ func (d *Data) resyncLowMemory(ctx *datastore.VersionedCtx) {
 	}
 
 	timedLog.Infof("Completed asynchronous annotation %q reload of %d block and %d tag elements.", d.DataName(), totBlockE, totTagE)
+
+	if err := d.rebuildConnections(ctx); err != nil {
+		dvid.Errorf("Error rebuilding connections of data %q: %v\n", d.DataName(), err)
+	}
 }
 
 // GetByDataUUID returns a pointer to annotation data given a data UUID.
@@ -2925,6 +3048,122 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			return
 		}
 
+	case "connections":
+		// GET <api URL>/node/<UUID>/<data name>/connections/<label>
+		if action != "get" {
+			server.BadRequest(w, r, "Only GET action is available on 'connections' endpoint.")
+			return
+		}
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "Must include label after 'connections' endpoint.")
+			return
+		}
+		label, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if label == 0 {
+			server.BadRequest(w, r, "Label 0 is protected background value and cannot be used as query.")
+			return
+		}
+		partners, err := d.GetPartners(ctx, label)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		jsonBytes, err := json.Marshal(partners)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		if _, err := w.Write(jsonBytes); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		timedLog.Infof("HTTP %s: get connections for label %d (%s)", r.Method, label, r.URL)
+
+	case "diff":
+		// GET <api URL>/node/<UUID>/<data name>/diff/<UUID B>
+		if action != "get" {
+			server.BadRequest(w, r, "Only GET action is available on 'diff' endpoint.")
+			return
+		}
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "Must include UUID after 'diff' endpoint.")
+			return
+		}
+		uuidB, vB, err := datastore.GetDiffVersion(uuid, parts[4])
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-type", "application/x-ndjson")
+		numElements, err := d.WriteDiff(w, ctx.VersionID(), vB)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		timedLog.Infof("HTTP %s: diff of %d elements between %s and %s (%s)", r.Method, numElements, uuid, uuidB, r.URL)
+
+	case "connectivity":
+		var conns Connections
+		switch action {
+		case "post":
+			// POST <api URL>/node/<UUID>/<data name>/connectivity
+			var bodies []uint64
+			if err := json.NewDecoder(r.Body).Decode(&bodies); err != nil {
+				server.BadRequest(w, r, "expected JSON array of labels: %v", err)
+				return
+			}
+			var err error
+			if conns, err = d.GetConnectionTable(ctx, bodies); err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			timedLog.Infof("HTTP %s: connectivity among %d bodies (%s)", r.Method, len(bodies), r.URL)
+
+		case "get":
+			// GET <api URL>/node/<UUID>/<data name>/connectivity/<ROI specification>
+			if len(parts) < 5 {
+				server.BadRequest(w, r, "Expect ROI specification to follow 'connectivity' in GET request")
+				return
+			}
+			roiParts := strings.Split(parts[4], ",")
+			var roiSpec string
+			roiSpec = "roi:"
+			switch len(roiParts) {
+			case 1:
+				roiSpec += roiParts[0] + "," + string(uuid)
+			case 2:
+				roiSpec += parts[4]
+			default:
+				server.BadRequest(w, r, "Bad ROI specification: %q", parts[4])
+				return
+			}
+			var err error
+			if conns, err = d.GetROIConnectionTable(ctx, storage.FilterSpec(roiSpec)); err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			timedLog.Infof("HTTP %s: connectivity of bodies in ROI (%s) (%s)", r.Method, parts[4], r.URL)
+
+		default:
+			server.BadRequest(w, r, "Only GET or POST action is available on 'connectivity' endpoint.")
+			return
+		}
+		jsonBytes, err := json.Marshal(conns)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		if _, err := w.Write(jsonBytes); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+
 	case "blocks":
 		switch action {
 		case "get":
@@ -779,6 +779,61 @@ func TestRequests(t *testing.T) {
 	testResponse(t, synapse2, "%snode/%s/%s/tag/%s?relationships=true", server.WebAPIPath, uuid, data.DataName(), tag)
 }
 
+func TestDiff(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	server.CreateTestInstance(t, uuid, "annotation", "mysynapses", dvid.Config{})
+
+	elements := `[{"Pos":[10, 10, 10], "Kind":"PostSyn"},
+		{"Pos":[50, 50, 50], "Kind":"PostSyn"},
+		{"Pos":[100, 100, 100], "Kind":"PreSyn"}]`
+	url := fmt.Sprintf("%snode/%s/mysynapses/elements", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url, strings.NewReader(elements))
+	if err := datastore.Commit(uuid, "parent", nil); err != nil {
+		t.Fatalf("unable to commit parent: %v\n", err)
+	}
+	child, err := datastore.NewVersion(uuid, "child", "", nil)
+	if err != nil {
+		t.Fatalf("unable to create child: %v\n", err)
+	}
+	elements = `[{"Pos":[10, 10, 10], "Kind":"PostSyn", "Tags":["modified"]},
+		{"Pos":[200, 10, 10], "Kind":"PreSyn"}]`
+	url = fmt.Sprintf("%snode/%s/mysynapses/elements", server.WebAPIPath, child)
+	server.TestHTTP(t, "POST", url, strings.NewReader(elements))
+	url = fmt.Sprintf("%snode/%s/mysynapses/element/50_50_50", server.WebAPIPath, child)
+	server.TestHTTP(t, "DELETE", url, nil)
+
+	url = fmt.Sprintf("%snode/%s/mysynapses/diff/%s", server.WebAPIPath, uuid, child)
+	lines := strings.Split(strings.TrimSpace(string(server.TestHTTP(t, "GET", url, nil))), "\n")
+	got := make(map[string]ElementDiff, len(lines))
+	for _, line := range lines {
+		var diff ElementDiff
+		if err := json.Unmarshal([]byte(line), &diff); err != nil {
+			t.Fatalf("bad diff line %q: %v\n", line, err)
+		}
+		got[diff.Pos.String()] = diff
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 changed elements, got %d: %v\n", len(got), lines)
+	}
+	modified := got[dvid.Point3d{10, 10, 10}.String()]
+	if modified.Change != datastore.KeyModified || modified.Previous == nil || modified.Element == nil {
+		t.Errorf("expected modified element at (10,10,10), got %v\n", modified)
+	} else if len(modified.Previous.Tags) != 0 || len(modified.Element.Tags) != 1 {
+		t.Errorf("bad modified element tags: previous %v, now %v\n", modified.Previous.Tags, modified.Element.Tags)
+	}
+	if deleted := got[dvid.Point3d{50, 50, 50}.String()]; deleted.Change != datastore.KeyDeleted || deleted.Element != nil {
+		t.Errorf("expected deleted element at (50,50,50), got %v\n", deleted)
+	}
+	if added := got[dvid.Point3d{200, 10, 10}.String()]; added.Change != datastore.KeyAdded || added.Element.Kind != PreSyn {
+		t.Errorf("expected added PreSyn at (200,10,10), got %v\n", added)
+	}
+}
+
 func TestPropChange(t *testing.T) {
 	if err := server.OpenTest(); err != nil {
 		t.Fatalf("can't open test server: %v\n", err)
@@ -0,0 +1,607 @@
+/*
+	This file supports connectivity between bodies of a synced label instance, computed
+	from PreSyn elements and their PreSynTo relationships.  Connection counts are stored
+	per (pre, post) label pair and kept current from label changes and element edits.
+*/
+
+package annotation
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Connection is a weighted, directed edge between two bodies where the weight is the
+// number of PreSynTo relationships from PreSyn elements in the Pre body to positions
+// in the Post body.
+type Connection struct {
+	Pre    uint64 `json:"pre"`
+	Post   uint64 `json:"post"`
+	Weight uint32 `json:"weight"`
+}
+
+// Connections is a slice of connections ordered by pre and then post body.
+type Connections []Connection
+
+// Partner is a body connected to some other body with the given weight.
+type Partner struct {
+	Body   uint64 `json:"body"`
+	Weight uint32 `json:"weight"`
+}
+
+// Partners describes the upstream and downstream partners of a body.
+type Partners struct {
+	Body       uint64    `json:"body"`
+	Upstream   []Partner `json:"upstream"`
+	Downstream []Partner `json:"downstream"`
+}
+
+// number of connections keyed by (pre, post) labels.  Values can be negative when
+// used as a delta.
+type connectionCounts map[[2]uint64]int
+
+func (c connectionCounts) add(c2 connectionCounts, sign int) {
+	for pair, n := range c2 {
+		c[pair] += sign * n
+	}
+}
+
+// returns labels for the given points using the synced label data.
+func (d *Data) getPointLabels(v dvid.VersionID, pts []dvid.Point3d) ([]uint64, error) {
+	labelData := d.getSyncedLabels()
+	if labelData == nil {
+		return nil, fmt.Errorf("no synced labels for annotation %q", d.DataName())
+	}
+	if labelPointData, ok := labelData.(labelPointType); ok {
+		return labelPointData.GetLabelPoints(v, pts, 0, false)
+	}
+	lbls := make([]uint64, len(pts))
+	for i, pt := range pts {
+		label, err := labelData.GetLabelAtPoint(v, pt)
+		if err != nil {
+			return nil, err
+		}
+		lbls[i] = label
+	}
+	return lbls, nil
+}
+
+// returns the block elements, with relationships, at the given positions.
+func (d *Data) getElementsAt(ctx *datastore.VersionedCtx, pts []dvid.Point3d) (Elements, error) {
+	blockSize := d.blockSize()
+	blockPts := make(map[dvid.IZYXString]map[string]struct{})
+	for _, pt := range pts {
+		izyx := pt.ToBlockIZYXString(blockSize)
+		bp, found := blockPts[izyx]
+		if !found {
+			bp = make(map[string]struct{})
+			blockPts[izyx] = bp
+		}
+		bp[pt.MapKey()] = struct{}{}
+	}
+	var found Elements
+	for izyx, bp := range blockPts {
+		chunkPt, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return nil, err
+		}
+		elems, err := getElements(ctx, NewBlockTKey(chunkPt))
+		if err != nil {
+			return nil, err
+		}
+		for _, elem := range elems {
+			if _, ok := bp[elem.Pos.MapKey()]; ok {
+				found = append(found, elem)
+			}
+		}
+	}
+	return found, nil
+}
+
+// returns positions of PreSyn elements whose connections could change if the given
+// elements change: the PreSyn elements themselves and the partners of PostSyn elements.
+func relatedPreSyn(elems Elements) []dvid.Point3d {
+	var pts []dvid.Point3d
+	for _, elem := range elems {
+		switch elem.Kind {
+		case PreSyn:
+			pts = append(pts, elem.Pos)
+		case PostSyn:
+			for _, rel := range elem.Rels {
+				if rel.Rel == PostSynTo {
+					pts = append(pts, rel.To)
+				}
+			}
+		}
+	}
+	return pts
+}
+
+// returns the PreSyn positions related to stored elements at the given positions.
+func (d *Data) getRelatedPreSyn(ctx *datastore.VersionedCtx, pts []dvid.Point3d) ([]dvid.Point3d, error) {
+	elems, err := d.getElementsAt(ctx, pts)
+	if err != nil {
+		return nil, err
+	}
+	return relatedPreSyn(elems), nil
+}
+
+// returns labels for the given points as they were last counted for connections, using
+// the current labels of points without a stored label.
+func (d *Data) getElementLabels(ctx *datastore.VersionedCtx, pts []dvid.Point3d) ([]uint64, error) {
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, err
+	}
+	lbls := make([]uint64, len(pts))
+	var lookup []dvid.Point3d
+	var lookupIdx []int
+	for i, pt := range pts {
+		val, err := store.Get(ctx, NewElementLabelTKey(pt))
+		if err != nil {
+			return nil, err
+		}
+		if len(val) == 8 {
+			lbls[i] = binary.LittleEndian.Uint64(val)
+		} else {
+			lookup = append(lookup, pt)
+			lookupIdx = append(lookupIdx, i)
+		}
+	}
+	if len(lookup) != 0 {
+		curLabels, err := d.getPointLabels(ctx.VersionID(), lookup)
+		if err != nil {
+			return nil, err
+		}
+		for i, label := range curLabels {
+			lbls[lookupIdx[i]] = label
+		}
+	}
+	return lbls, nil
+}
+
+// stores the label a synaptic element is counted under for connections.  A zero label
+// deletes the stored label.
+func putElementLabel(batch storage.Batch, pt dvid.Point3d, label uint64) {
+	tk := NewElementLabelTKey(pt)
+	if label == 0 {
+		batch.Delete(tk)
+		return
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, label)
+	batch.Put(tk, buf)
+}
+
+// counts connections made by the given elements.  Labels in the override map, keyed by
+// point map key, are used instead of the labels the points were last counted under.
+func (d *Data) countConnections(ctx *datastore.VersionedCtx, elems Elements, override map[string]uint64) (connectionCounts, error) {
+	var pairs [][2]dvid.Point3d
+	for _, elem := range elems {
+		if elem.Kind != PreSyn {
+			continue
+		}
+		for _, rel := range elem.Rels {
+			if rel.Rel == PreSynTo {
+				pairs = append(pairs, [2]dvid.Point3d{elem.Pos, rel.To})
+			}
+		}
+	}
+	labelOf := make(map[string]uint64, 2*len(pairs))
+	var lookup []dvid.Point3d
+	for _, pair := range pairs {
+		for _, pt := range pair {
+			key := pt.MapKey()
+			if _, found := labelOf[key]; found {
+				continue
+			}
+			if label, found := override[key]; found {
+				labelOf[key] = label
+			} else {
+				labelOf[key] = 0
+				lookup = append(lookup, pt)
+			}
+		}
+	}
+	if len(lookup) != 0 {
+		lbls, err := d.getElementLabels(ctx, lookup)
+		if err != nil {
+			return nil, err
+		}
+		for i, pt := range lookup {
+			labelOf[pt.MapKey()] = lbls[i]
+		}
+	}
+	counts := make(connectionCounts)
+	for _, pair := range pairs {
+		pre, post := labelOf[pair[0].MapKey()], labelOf[pair[1].MapKey()]
+		if pre != 0 && post != 0 {
+			counts[[2]uint64{pre, post}]++
+		}
+	}
+	return counts, nil
+}
+
+// counts connections made by stored PreSyn elements at the given positions.
+func (d *Data) countConnectionsAt(ctx *datastore.VersionedCtx, prePts []dvid.Point3d, override map[string]uint64) (connectionCounts, error) {
+	elems, err := d.getElementsAt(ctx, prePts)
+	if err != nil {
+		return nil, err
+	}
+	return d.countConnections(ctx, elems, override)
+}
+
+// returns connections of the given PreSyn positions before an element edit, or nil if
+// there are no synced labels.
+func (d *Data) connectionsBeforeEdit(ctx *datastore.VersionedCtx, prePts []dvid.Point3d) (connectionCounts, error) {
+	if d.getSyncedLabels() == nil || len(prePts) == 0 {
+		return nil, nil
+	}
+	return d.countConnectionsAt(ctx, prePts, nil)
+}
+
+// applies the change in connections of PreSyn positions due to an element edit, given
+// the connections before the edit.
+func (d *Data) connectionsAfterEdit(ctx *datastore.VersionedCtx, batcher storage.KeyValueBatcher, prePts []dvid.Point3d, before connectionCounts) error {
+	if before == nil {
+		return nil
+	}
+	after, err := d.countConnectionsAt(ctx, prePts, nil)
+	if err != nil {
+		return err
+	}
+	after.add(before, -1)
+	return d.applyConnectionDelta(ctx, batcher, after)
+}
+
+// adds the given change in connection counts to the stored counts.
+func (d *Data) applyConnectionDelta(ctx *datastore.VersionedCtx, batcher storage.KeyValueBatcher, delta connectionCounts) error {
+	d.connMu.Lock()
+	defer d.connMu.Unlock()
+
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	batch := batcher.NewBatch(ctx)
+	var modified bool
+	for pair, change := range delta {
+		if change == 0 || pair[0] == 0 || pair[1] == 0 {
+			continue
+		}
+		tk := NewConnectionTKey(pair[0], pair[1])
+		val, err := store.Get(ctx, tk)
+		if err != nil {
+			return err
+		}
+		var weight int
+		if len(val) == 4 {
+			weight = int(binary.LittleEndian.Uint32(val))
+		}
+		weight += change
+		inTk := NewConnectionInTKey(pair[1], pair[0])
+		if weight <= 0 {
+			if weight < 0 {
+				dvid.Errorf("annotation %q connection %d -> %d went negative (%d), resetting to 0\n", d.DataName(), pair[0], pair[1], weight)
+			}
+			batch.Delete(tk)
+			batch.Delete(inTk)
+		} else {
+			buf := make([]byte, 4)
+			binary.LittleEndian.PutUint32(buf, uint32(weight))
+			batch.Put(tk, buf)
+			batch.Put(inTk, buf)
+		}
+		modified = true
+	}
+	if !modified {
+		return nil
+	}
+	return batch.Commit()
+}
+
+// updates connections after a change in the labels of elements, as described by the
+// elements' old and new labels in the delta.  Related elements outside the delta are
+// counted under the labels they were last counted under, so a mutation whose other
+// changes have not been synced yet does not affect their counts.
+func (d *Data) updateConnections(ctx *datastore.VersionedCtx, batcher storage.KeyValueBatcher, delta DeltaModifyElements) error {
+	if d.getSyncedLabels() == nil {
+		return nil
+	}
+	before := make(map[string]uint64)
+	after := make(map[string]uint64)
+	ptMap := make(map[string]dvid.Point3d)
+	for _, ep := range delta.Del {
+		if ep.Kind == PreSyn || ep.Kind == PostSyn {
+			key := ep.Pos.MapKey()
+			before[key] = ep.Label
+			ptMap[key] = ep.Pos
+		}
+	}
+	for _, ep := range delta.Add {
+		if ep.Kind == PreSyn || ep.Kind == PostSyn {
+			key := ep.Pos.MapKey()
+			after[key] = ep.Label
+			ptMap[key] = ep.Pos
+		}
+	}
+	if len(ptMap) == 0 {
+		return nil
+	}
+	pts := make([]dvid.Point3d, 0, len(ptMap))
+	for key, pt := range ptMap {
+		if _, found := before[key]; !found {
+			before[key] = 0
+		}
+		if _, found := after[key]; !found {
+			after[key] = 0
+		}
+		pts = append(pts, pt)
+	}
+	prePts, err := d.getRelatedPreSyn(ctx, pts)
+	if err != nil {
+		return err
+	}
+	elems, err := d.getElementsAt(ctx, prePts)
+	if err != nil {
+		return err
+	}
+	oldCounts, err := d.countConnections(ctx, elems, before)
+	if err != nil {
+		return err
+	}
+	newCounts, err := d.countConnections(ctx, elems, after)
+	if err != nil {
+		return err
+	}
+	newCounts.add(oldCounts, -1)
+	if err := d.applyConnectionDelta(ctx, batcher, newCounts); err != nil {
+		return err
+	}
+	batch := batcher.NewBatch(ctx)
+	for key, pt := range ptMap {
+		putElementLabel(batch, pt, after[key])
+	}
+	return batch.Commit()
+}
+
+// moves all connections of merged labels to the target label.
+func (d *Data) mergeConnections(ctx *datastore.VersionedCtx, batcher storage.KeyValueBatcher, target uint64, merged map[uint64]struct{}) error {
+	remap := func(label uint64) uint64 {
+		if _, found := merged[label]; found {
+			return target
+		}
+		return label
+	}
+	edges := make(connectionCounts)
+	for label := range merged {
+		downstream, err := d.getPartnerWeights(ctx, label, false)
+		if err != nil {
+			return err
+		}
+		for post, weight := range downstream {
+			edges[[2]uint64{label, post}] = int(weight)
+		}
+		upstream, err := d.getPartnerWeights(ctx, label, true)
+		if err != nil {
+			return err
+		}
+		for pre, weight := range upstream {
+			edges[[2]uint64{pre, label}] = int(weight)
+		}
+	}
+	delta := make(connectionCounts)
+	for pair, weight := range edges {
+		delta[pair] -= weight
+		delta[[2]uint64{remap(pair[0]), remap(pair[1])}] += weight
+	}
+	return d.applyConnectionDelta(ctx, batcher, delta)
+}
+
+// returns the partners of a label and the connection weights to them.  If upstream is
+// true, the partners are pre-synaptic to the label.
+func (d *Data) getPartnerWeights(ctx *datastore.VersionedCtx, label uint64, upstream bool) (map[uint64]uint32, error) {
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, err
+	}
+	var begTKey, endTKey storage.TKey
+	var class storage.TKeyClass
+	if upstream {
+		begTKey, endTKey = NewConnectionInTKey(label, 0), NewConnectionInTKey(label, math.MaxUint64)
+		class = keyConnectionIn
+	} else {
+		begTKey, endTKey = NewConnectionTKey(label, 0), NewConnectionTKey(label, math.MaxUint64)
+		class = keyConnection
+	}
+	kvs, err := store.GetRange(ctx, begTKey, endTKey)
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[uint64]uint32, len(kvs))
+	for _, kv := range kvs {
+		_, partner, err := DecodeConnectionTKey(kv.K, class)
+		if err != nil {
+			return nil, err
+		}
+		if len(kv.V) != 4 {
+			return nil, fmt.Errorf("bad connection value for label %d, partner %d in annotation %q", label, partner, d.DataName())
+		}
+		weights[partner] = binary.LittleEndian.Uint32(kv.V)
+	}
+	return weights, nil
+}
+
+func sortedPartners(weights map[uint64]uint32) []Partner {
+	partners := make([]Partner, 0, len(weights))
+	for body, weight := range weights {
+		partners = append(partners, Partner{Body: body, Weight: weight})
+	}
+	sort.Slice(partners, func(i, j int) bool {
+		if partners[i].Weight != partners[j].Weight {
+			return partners[i].Weight > partners[j].Weight
+		}
+		return partners[i].Body < partners[j].Body
+	})
+	return partners
+}
+
+// GetPartners returns the upstream and downstream partners of a body, ordered by
+// decreasing connection weight.
+func (d *Data) GetPartners(ctx *datastore.VersionedCtx, label uint64) (*Partners, error) {
+	upstream, err := d.getPartnerWeights(ctx, label, true)
+	if err != nil {
+		return nil, err
+	}
+	downstream, err := d.getPartnerWeights(ctx, label, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Partners{
+		Body:       label,
+		Upstream:   sortedPartners(upstream),
+		Downstream: sortedPartners(downstream),
+	}, nil
+}
+
+// GetConnectionTable returns the weighted connections among the given bodies.
+func (d *Data) GetConnectionTable(ctx *datastore.VersionedCtx, bodies []uint64) (Connections, error) {
+	bodySet := make(map[uint64]struct{}, len(bodies))
+	sorted := make([]uint64, 0, len(bodies))
+	for _, body := range bodies {
+		if _, found := bodySet[body]; found || body == 0 {
+			continue
+		}
+		bodySet[body] = struct{}{}
+		sorted = append(sorted, body)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	conns := Connections{}
+	for _, pre := range sorted {
+		weights, err := d.getPartnerWeights(ctx, pre, false)
+		if err != nil {
+			return nil, err
+		}
+		var posts []uint64
+		for post := range weights {
+			if _, found := bodySet[post]; found {
+				posts = append(posts, post)
+			}
+		}
+		sort.Slice(posts, func(i, j int) bool { return posts[i] < posts[j] })
+		for _, post := range posts {
+			conns = append(conns, Connection{Pre: pre, Post: post, Weight: weights[post]})
+		}
+	}
+	return conns, nil
+}
+
+// GetROIConnectionTable returns the weighted connections among all bodies with synaptic
+// elements within the given ROI.
+func (d *Data) GetROIConnectionTable(ctx *datastore.VersionedCtx, roiSpec storage.FilterSpec) (Connections, error) {
+	elems, err := d.GetROISynapses(ctx, roiSpec)
+	if err != nil {
+		return nil, err
+	}
+	var synaptic Elements
+	for _, elem := range elems {
+		if elem.Kind == PreSyn || elem.Kind == PostSyn {
+			synaptic = append(synaptic, elem)
+		}
+	}
+	if len(synaptic) == 0 {
+		return Connections{}, nil
+	}
+	lbls, err := d.getPointLabels(ctx.VersionID(), synaptic.positions())
+	if err != nil {
+		return nil, err
+	}
+	return d.GetConnectionTable(ctx, lbls)
+}
+
+// deletes and recomputes all connection counts and the labels they were counted under
+// from the block elements.
+func (d *Data) rebuildConnections(ctx *datastore.VersionedCtx) error {
+	if d.getSyncedLabels() == nil {
+		return nil
+	}
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	batcher, ok := store.(storage.KeyValueBatcher)
+	if !ok {
+		return fmt.Errorf("data type annotation requires batch-enabled store, which %q is not", store)
+	}
+	d.connMu.Lock()
+	defer d.connMu.Unlock()
+
+	timedLog := dvid.NewTimeLog()
+	for _, class := range []storage.TKeyClass{keyConnection, keyConnectionIn, keyElementLabel} {
+		if err := store.DeleteRange(ctx, storage.MinTKey(class), storage.MaxTKey(class)); err != nil {
+			return fmt.Errorf("unable to delete connections for annotation %q: %v", d.DataName(), err)
+		}
+	}
+	counts := make(connectionCounts)
+	err = store.ProcessRange(ctx, storage.MinTKey(keyBlock), storage.MaxTKey(keyBlock), &storage.ChunkOp{}, func(c *storage.Chunk) error {
+		if c == nil || c.TKeyValue == nil || c.V == nil {
+			return nil
+		}
+		var elems Elements
+		if err := json.Unmarshal(c.V, &elems); err != nil {
+			return fmt.Errorf("couldn't unmarshal block elements for annotation %q: %v", d.DataName(), err)
+		}
+		var synaptic Elements
+		for _, elem := range elems {
+			if elem.Kind == PreSyn || elem.Kind == PostSyn {
+				synaptic = append(synaptic, elem)
+			}
+		}
+		if len(synaptic) == 0 {
+			return nil
+		}
+		pts := synaptic.positions()
+		lbls, err := d.getPointLabels(ctx.VersionID(), pts)
+		if err != nil {
+			return err
+		}
+		blockLabels := make(map[string]uint64, len(pts))
+		batch := batcher.NewBatch(ctx)
+		for i, pt := range pts {
+			blockLabels[pt.MapKey()] = lbls[i]
+			putElementLabel(batch, pt, lbls[i])
+		}
+		if err := batch.Commit(); err != nil {
+			return err
+		}
+		blockCounts, err := d.countConnections(ctx, elems, blockLabels)
+		if err != nil {
+			return err
+		}
+		counts.add(blockCounts, 1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	batch := batcher.NewBatch(ctx)
+	for pair, weight := range counts {
+		buf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buf, uint32(weight))
+		batch.Put(NewConnectionTKey(pair[0], pair[1]), buf)
+		batch.Put(NewConnectionInTKey(pair[1], pair[0]), buf)
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	timedLog.Infof("Rebuilt %d connections for annotation %q", len(counts), d.DataName())
+	return nil
+}
@@ -0,0 +1,200 @@
+package annotation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// Sets voxels in box of given offset and size to given label.
+func (v *testVolume) addBox(offset, size dvid.Point3d, label uint64) {
+	for z := offset[2]; z < offset[2]+size[2]; z++ {
+		for y := offset[1]; y < offset[1]+size[1]; y++ {
+			for x := offset[0]; x < offset[0]+size[0]; x++ {
+				i := ((z*v.size[1]+y)*v.size[0] + x) * 8
+				binary.LittleEndian.PutUint64(v.data[i:i+8], label)
+			}
+		}
+	}
+}
+
+var connTestData = Elements{
+	{
+		ElementNR{Pos: dvid.Point3d{5, 5, 5}, Kind: PreSyn},
+		[]Relationship{{Rel: PreSynTo, To: dvid.Point3d{35, 5, 5}}, {Rel: PreSynTo, To: dvid.Point3d{36, 5, 5}}, {Rel: PreSynTo, To: dvid.Point3d{5, 40, 40}}},
+	},
+	{
+		ElementNR{Pos: dvid.Point3d{35, 5, 5}, Kind: PostSyn},
+		[]Relationship{{Rel: PostSynTo, To: dvid.Point3d{5, 5, 5}}},
+	},
+	{
+		ElementNR{Pos: dvid.Point3d{36, 5, 5}, Kind: PostSyn},
+		[]Relationship{{Rel: PostSynTo, To: dvid.Point3d{5, 5, 5}}},
+	},
+	{
+		ElementNR{Pos: dvid.Point3d{5, 40, 40}, Kind: PostSyn},
+		[]Relationship{{Rel: PostSynTo, To: dvid.Point3d{5, 5, 5}}},
+	},
+	{
+		ElementNR{Pos: dvid.Point3d{40, 5, 5}, Kind: PreSyn},
+		[]Relationship{{Rel: PreSynTo, To: dvid.Point3d{6, 40, 40}}, {Rel: PreSynTo, To: dvid.Point3d{40, 40, 40}}},
+	},
+	{
+		ElementNR{Pos: dvid.Point3d{6, 40, 40}, Kind: PostSyn},
+		[]Relationship{{Rel: PostSynTo, To: dvid.Point3d{40, 5, 5}}},
+	},
+	{
+		ElementNR{Pos: dvid.Point3d{40, 40, 40}, Kind: PostSyn},
+		[]Relationship{{Rel: PostSynTo, To: dvid.Point3d{40, 5, 5}}},
+	},
+}
+
+func testConnectivity(t *testing.T, uuid dvid.UUID, bodies string, expected Connections) {
+	url := fmt.Sprintf("%snode/%s/mysynapses/connectivity", server.WebAPIPath, uuid)
+	var got Connections
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", url, strings.NewReader(bodies)), &got); err != nil {
+		t.Fatalf("couldn't unmarshal connectivity response: %v\n", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected connectivity among %s:\n%v\ngot:\n%v\n", bodies, expected, got)
+	}
+}
+
+func waitOnSyncs(t *testing.T, uuid dvid.UUID) {
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on sync of synapses: %v\n", err)
+	}
+}
+
+func TestConnectivity(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, v := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelmap", "labels", config)
+
+	vol := newTestVolume(64, 64, 64)
+	vol.addBox(dvid.Point3d{0, 0, 0}, dvid.Point3d{16, 16, 16}, 1)
+	vol.addBox(dvid.Point3d{32, 0, 0}, dvid.Point3d{16, 16, 16}, 2)
+	vol.addBox(dvid.Point3d{0, 32, 32}, dvid.Point3d{16, 16, 16}, 3)
+	vol.addBox(dvid.Point3d{32, 32, 32}, dvid.Point3d{16, 16, 16}, 4)
+	vol.put(t, uuid, "labels")
+
+	server.CreateTestInstance(t, uuid, "annotation", "mysynapses", config)
+	server.CreateTestSync(t, uuid, "mysynapses", "labels")
+	waitOnSyncs(t, uuid)
+
+	testJSON, err := json.Marshal(connTestData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("%snode/%s/mysynapses/elements", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url, bytes.NewBuffer(testJSON))
+
+	testConnectivity(t, uuid, "[1, 2, 3, 4]", Connections{{1, 2, 2}, {1, 3, 1}, {2, 3, 1}, {2, 4, 1}})
+	testConnectivity(t, uuid, "[4, 2, 0]", Connections{{2, 4, 1}})
+	testConnectivity(t, uuid, "[1, 5]", Connections{})
+
+	url = fmt.Sprintf("%snode/%s/mysynapses/connections/3", server.WebAPIPath, uuid)
+	var partners Partners
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", url, nil), &partners); err != nil {
+		t.Fatal(err)
+	}
+	expected := Partners{Body: 3, Upstream: []Partner{{1, 1}, {2, 1}}, Downstream: []Partner{}}
+	if !reflect.DeepEqual(partners, expected) {
+		t.Fatalf("expected partners %v, got %v\n", expected, partners)
+	}
+	url = fmt.Sprintf("%snode/%s/mysynapses/connections/0", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "GET", url, nil)
+
+	// Connectivity among bodies with synapses in an ROI covering the first two blocks.
+	server.CreateTestInstance(t, uuid, "roi", "myroi", dvid.Config{})
+	url = fmt.Sprintf("%snode/%s/myroi/roi", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url, bytes.NewBufferString("[[0, 0, 0, 1]]"))
+	url = fmt.Sprintf("%snode/%s/mysynapses/connectivity/myroi", server.WebAPIPath, uuid)
+	var roiConns Connections
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", url, nil), &roiConns); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(roiConns, Connections{{1, 2, 2}}) {
+		t.Fatalf("bad ROI connectivity: %v\n", roiConns)
+	}
+
+	// Merges combine connections.
+	testMerge := mergeJSON(`[3, 4]`)
+	testMerge.send(t, uuid, "labels")
+	waitOnSyncs(t, uuid)
+	testConnectivity(t, uuid, "[1, 2, 3, 4]", Connections{{1, 2, 2}, {1, 3, 1}, {2, 3, 2}})
+
+	// Cleaving off the merged supervoxel restores the split connection.
+	url = fmt.Sprintf("%snode/%s/labels/cleave/3", server.WebAPIPath, uuid)
+	var cleaveResp struct {
+		CleavedLabel uint64
+	}
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", url, bytes.NewBufferString("[4]")), &cleaveResp); err != nil {
+		t.Fatal(err)
+	}
+	waitOnSyncs(t, uuid)
+	cleaved := cleaveResp.CleavedLabel
+	testConnectivity(t, uuid, fmt.Sprintf("[1, 2, 3, %d]", cleaved), Connections{{1, 2, 2}, {1, 3, 1}, {2, 3, 1}, {2, cleaved, 1}})
+
+	// Element edits modify connections.
+	url = fmt.Sprintf("%snode/%s/mysynapses/element/36_5_5", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "DELETE", url, nil)
+	testConnectivity(t, uuid, "[1, 2]", Connections{{1, 2, 1}})
+
+	url = fmt.Sprintf("%snode/%s/mysynapses/move/35_5_5/10_10_10", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url, nil)
+	testConnectivity(t, uuid, "[1, 2]", Connections{{1, 1, 1}})
+
+	url = fmt.Sprintf("%snode/%s/mysynapses/elements", server.WebAPIPath, uuid)
+	newSyn := `[{"Pos":[40,6,6],"Kind":"PreSyn","Rels":[{"Rel":"PreSynTo","To":[6,6,6]}]},` +
+		`{"Pos":[6,6,6],"Kind":"PostSyn","Rels":[{"Rel":"PostSynTo","To":[40,6,6]}]}]`
+	server.TestHTTP(t, "POST", url, strings.NewReader(newSyn))
+	testConnectivity(t, uuid, "[1, 2]", Connections{{1, 1, 1}, {2, 1, 1}})
+
+	// Rebuilding connections from scratch should give the maintained connections.
+	bodies := fmt.Sprintf("[1, 2, 3, %d]", cleaved)
+	expectedConns := Connections{{1, 1, 1}, {1, 3, 1}, {2, 1, 1}, {2, 3, 1}, {2, cleaved, 1}}
+	testConnectivity(t, uuid, bodies, expectedConns)
+	d, err := GetByUUIDName(uuid, "mysynapses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.rebuildConnections(datastore.NewVersionedCtx(d, v)); err != nil {
+		t.Fatal(err)
+	}
+	testConnectivity(t, uuid, bodies, expectedConns)
+
+	// Relabeling blocks with connections that cross between them, where each block is
+	// synced separately, moves the connections to the new labels.
+	vol.addBox(dvid.Point3d{0, 0, 0}, dvid.Point3d{16, 16, 16}, 10)
+	vol.addBox(dvid.Point3d{32, 0, 0}, dvid.Point3d{16, 16, 16}, 11)
+	vol.put(t, uuid, "labels")
+	waitOnSyncs(t, uuid)
+	bodies = fmt.Sprintf("[1, 2, 3, 10, 11, %d]", cleaved)
+	expectedConns = Connections{{10, 3, 1}, {10, 10, 1}, {11, 3, 1}, {11, 10, 1}, {11, cleaved, 1}}
+	sort.Slice(expectedConns, func(i, j int) bool {
+		if expectedConns[i].Pre != expectedConns[j].Pre {
+			return expectedConns[i].Pre < expectedConns[j].Pre
+		}
+		return expectedConns[i].Post < expectedConns[j].Post
+	})
+	testConnectivity(t, uuid, bodies, expectedConns)
+}
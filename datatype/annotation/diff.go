@@ -0,0 +1,98 @@
+/*
+	This file supports finding the elements that differ between two versions.
+*/
+
+package annotation
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// ElementDiff describes an element that differs between two versions.
+type ElementDiff struct {
+	Pos      dvid.Point3d        `json:"pos"`
+	Change   datastore.KeyChange `json:"change"`
+	Element  *Element            `json:"element,omitempty"`  // element in the later version
+	Previous *Element            `json:"previous,omitempty"` // element in the earlier version
+}
+
+func decodeBlockElements(data []byte) (Elements, error) {
+	var elems Elements
+	if len(data) == 0 {
+		return elems, nil
+	}
+	if err := json.Unmarshal(data, &elems); err != nil {
+		return nil, err
+	}
+	return elems, nil
+}
+
+// returns the differences between two versions of a block's elements, keyed by position.
+func diffBlockElements(elemsA, elemsB Elements) ([]ElementDiff, error) {
+	inA := make(map[string]int, len(elemsA))
+	for i, elem := range elemsA {
+		inA[elem.Pos.MapKey()] = i
+	}
+	inB := make(map[string]struct{}, len(elemsB))
+	var diffs []ElementDiff
+	for i, elem := range elemsB {
+		key := elem.Pos.MapKey()
+		inB[key] = struct{}{}
+		j, found := inA[key]
+		if !found {
+			diffs = append(diffs, ElementDiff{Pos: elem.Pos, Change: datastore.KeyAdded, Element: &elemsB[i]})
+			continue
+		}
+		jsonA, err := json.Marshal(elemsA[j])
+		if err != nil {
+			return nil, err
+		}
+		jsonB, err := json.Marshal(elem)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(jsonA, jsonB) {
+			diffs = append(diffs, ElementDiff{Pos: elem.Pos, Change: datastore.KeyModified, Element: &elemsB[i], Previous: &elemsA[j]})
+		}
+	}
+	for j, elem := range elemsA {
+		if _, found := inB[elem.Pos.MapKey()]; !found {
+			diffs = append(diffs, ElementDiff{Pos: elem.Pos, Change: datastore.KeyDeleted, Previous: &elemsA[j]})
+		}
+	}
+	return diffs, nil
+}
+
+// WriteDiff writes JSON lines for each element that was added, modified or deleted from
+// version vA to version vB, returning the number of elements written.
+func (d *Data) WriteDiff(w io.Writer, vA, vB dvid.VersionID) (numElements int, err error) {
+	enc := json.NewEncoder(w)
+	err = datastore.DiffVersions(d, vA, vB, storage.MinTKey(keyBlock), storage.MaxTKey(keyBlock), func(diff datastore.KeyDiff) error {
+		elemsA, err := decodeBlockElements(diff.ValueA)
+		if err != nil {
+			return err
+		}
+		elemsB, err := decodeBlockElements(diff.ValueB)
+		if err != nil {
+			return err
+		}
+		elemDiffs, err := diffBlockElements(elemsA, elemsB)
+		if err != nil {
+			return err
+		}
+		for _, elemDiff := range elemDiffs {
+			if err := enc.Encode(elemDiff); err != nil {
+				return err
+			}
+		}
+		numElements += len(elemDiffs)
+		return nil
+	})
+	return
+}
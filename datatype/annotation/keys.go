@@ -28,6 +28,16 @@ const (
 
 	// key is block coordinate.  value is serialization of synaptic elements.
 	keyBlock = 72
+
+	// key is pre-synaptic label then post-synaptic label.  value is number of connections.
+	keyConnection = 73
+
+	// key is post-synaptic label then pre-synaptic label.  value is number of connections.
+	keyConnectionIn = 74
+
+	// key is position of a synaptic element.  value is the label the element was last
+	// counted under for connections.
+	keyElementLabel = 75
 )
 
 // DescribeTKeyClass returns a string explanation of what a particular TKeyClass
@@ -40,6 +50,12 @@ func (d *Data) DescribeTKeyClass(tkc storage.TKeyClass) string {
 		return "annotation label key"
 	case keyBlock:
 		return "annotation block coord key"
+	case keyConnection:
+		return "annotation outgoing connection key"
+	case keyConnectionIn:
+		return "annotation incoming connection key"
+	case keyElementLabel:
+		return "annotation element label key"
 	default:
 	}
 	return "unknown annotation key"
@@ -101,3 +117,43 @@ func DecodeBlockTKey(tk storage.TKey) (pt dvid.ChunkPoint3d, err error) {
 	pt = dvid.ChunkPoint3d(idx)
 	return
 }
+
+// NewConnectionTKey returns a TKey for the connections from a pre-synaptic label to
+// a post-synaptic label.
+func NewConnectionTKey(pre, post uint64) storage.TKey {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[0:8], pre)
+	binary.BigEndian.PutUint64(buf[8:16], post)
+	return storage.NewTKey(keyConnection, buf)
+}
+
+// NewConnectionInTKey returns a TKey for the connections into a post-synaptic label
+// from a pre-synaptic label.
+func NewConnectionInTKey(post, pre uint64) storage.TKey {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[0:8], post)
+	binary.BigEndian.PutUint64(buf[8:16], pre)
+	return storage.NewTKey(keyConnectionIn, buf)
+}
+
+// DecodeConnectionTKey returns the two labels of a connection key of the given class,
+// i.e., (pre, post) for keyConnection and (post, pre) for keyConnectionIn.
+func DecodeConnectionTKey(tk storage.TKey, class storage.TKeyClass) (label1, label2 uint64, err error) {
+	ibytes, err := tk.ClassBytes(class)
+	if err != nil {
+		return
+	}
+	if len(ibytes) != 16 {
+		err = fmt.Errorf("expected 16 bytes for connection key, got %d", len(ibytes))
+		return
+	}
+	label1 = binary.BigEndian.Uint64(ibytes[0:8])
+	label2 = binary.BigEndian.Uint64(ibytes[8:16])
+	return
+}
+
+// NewElementLabelTKey returns a TKey for the connection label of a synaptic element at
+// the given position.
+func NewElementLabelTKey(pt dvid.Point3d) storage.TKey {
+	return storage.NewTKey(keyElementLabel, pt.Bytes())
+}
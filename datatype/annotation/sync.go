@@ -427,6 +427,9 @@ func (d *Data) ingestBlock(ctx *datastore.VersionedCtx, chunkPt dvid.ChunkPoint3
 		dvid.Criticalf("bad commit in annotations %q after delete block: %v\n", d.DataName(), err)
 		return
 	}
+	if err := d.updateConnections(ctx, batcher, delta); err != nil {
+		dvid.Errorf("unable to update connections for block %s in annotation %q: %v\n", chunkPt, d.DataName(), err)
+	}
 
 	// Notify any subscribers of label annotation changes.
 	evt := datastore.SyncEvent{Data: d.DataUUID(), Event: ModifyElementsEvent}
@@ -523,6 +526,9 @@ func (d *Data) mutateBlock(ctx *datastore.VersionedCtx, chunkPt dvid.ChunkPoint3
 		dvid.Criticalf("bad commit in annotations %q after delete block: %v\n", d.DataName(), err)
 		return
 	}
+	if err := d.updateConnections(ctx, batcher, delta); err != nil {
+		dvid.Errorf("unable to update connections for block %s in annotation %q: %v\n", chunkPt, d.DataName(), err)
+	}
 
 	// Notify any subscribers of label annotation changes.
 	evt := datastore.SyncEvent{Data: d.DataUUID(), Event: ModifyElementsEvent}
@@ -580,6 +586,9 @@ func (d *Data) mergeLabels(batcher storage.KeyValueBatcher, v dvid.VersionID, op
 		for _, elem := range elems {
 			delta.Add = append(delta.Add, ElementPos{Label: op.Target, Kind: elem.Kind, Pos: elem.Pos})
 			delta.Del = append(delta.Del, ElementPos{Label: label, Kind: elem.Kind, Pos: elem.Pos})
+			if elem.Kind == PreSyn || elem.Kind == PostSyn {
+				putElementLabel(batch, elem.Pos, op.Target)
+			}
 		}
 	}
 	if elemsAdded > 0 {
@@ -591,6 +600,9 @@ func (d *Data) mergeLabels(batcher storage.KeyValueBatcher, v dvid.VersionID, op
 		if err := batch.Commit(); err != nil {
 			return fmt.Errorf("unable to commit merge for instance %q: %v", d.DataName(), err)
 		}
+		if err := d.mergeConnections(ctx, batcher, op.Target, op.Merged); err != nil {
+			return fmt.Errorf("unable to merge connections for instance %q: %v", d.DataName(), err)
+		}
 
 		// send kafka merge event to instance-uuid topic
 		versionuuid, _ := datastore.UUIDFromVersion(v)
@@ -678,6 +690,9 @@ func (d *Data) cleaveLabels(batcher storage.KeyValueBatcher, v dvid.VersionID, o
 	if err := batch.Commit(); err != nil {
 		return fmt.Errorf("bad commit in annotations %q after split: %v", d.DataName(), err)
 	}
+	if err := d.updateConnections(ctx, batcher, delta); err != nil {
+		return fmt.Errorf("unable to update connections after cleave in annotations %q: %v", d.DataName(), err)
+	}
 
 	// Notify any subscribers of label annotation changes.
 	if len(delta.Add) != 0 || len(delta.Del) != 0 {
@@ -793,6 +808,9 @@ func (d *Data) splitLabelsCoarse(batcher storage.KeyValueBatcher, v dvid.Version
 	if err := batch.Commit(); err != nil {
 		return fmt.Errorf("bad commit in annotations %q after split: %v", d.DataName(), err)
 	}
+	if err := d.updateConnections(ctx, batcher, delta); err != nil {
+		return fmt.Errorf("unable to update connections after split in annotations %q: %v", d.DataName(), err)
+	}
 
 	// Notify any subscribers of label annotation changes.
 	evt := datastore.SyncEvent{Data: d.DataUUID(), Event: ModifyElementsEvent}
@@ -916,6 +934,9 @@ func (d *Data) splitLabels(batcher storage.KeyValueBatcher, v dvid.VersionID, op
 	if err := batch.Commit(); err != nil {
 		return fmt.Errorf("bad commit in annotations %q after split: %v", d.DataName(), err)
 	}
+	if err := d.updateConnections(ctx, batcher, delta); err != nil {
+		return fmt.Errorf("unable to update connections after split in annotations %q: %v", d.DataName(), err)
+	}
 
 	// Notify any subscribers of label annotation changes.
 	evt := datastore.SyncEvent{Data: d.DataUUID(), Event: ModifyElementsEvent}
@@ -0,0 +1,285 @@
+/*
+	Package mesh supports generation of surface meshes from binary volumes using marching
+	tetrahedra, a variant of marching cubes that splits each cube of voxel centers into six
+	tetrahedra and avoids the ambiguous cases of the original algorithm.  Meshes can be
+	written in Neuroglancer's legacy mesh format, OBJ, or binary PLY, and read and merged.
+*/
+package mesh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Mesh formats
+const (
+	FormatNgmesh = "ngmesh" // Neuroglancer legacy single-resolution mesh
+	FormatOBJ    = "obj"
+	FormatPLY    = "ply"
+)
+
+// ValidFormat returns true if the given string is a supported mesh format.
+func ValidFormat(format string) bool {
+	switch format {
+	case FormatNgmesh, FormatOBJ, FormatPLY:
+		return true
+	}
+	return false
+}
+
+// ContentType returns the HTTP content type for a mesh format.
+func ContentType(format string) string {
+	if format == FormatOBJ {
+		return "text/plain"
+	}
+	return "application/octet-stream"
+}
+
+// Mesh is a triangle mesh.
+type Mesh struct {
+	Vertices  []float32 // x, y, z for each vertex
+	Triangles []uint32  // three vertex indices per triangle, counter-clockwise seen from outside
+}
+
+// NumVertices returns the number of vertices in the mesh.
+func (m *Mesh) NumVertices() int {
+	return len(m.Vertices) / 3
+}
+
+// NumTriangles returns the number of triangles in the mesh.
+func (m *Mesh) NumTriangles() int {
+	return len(m.Triangles) / 3
+}
+
+// InsideFunc returns true if the voxel at the given coordinate is within the surface.
+type InsideFunc func(x, y, z int32) bool
+
+// Builder accumulates the triangles for a surface as cubes of voxels are marched.
+// Vertices lie at the midpoints of lattice edges so they are shared between cubes.
+type Builder struct {
+	vertices  map[[3]int32]uint32 // keyed by the sum of an edge's two lattice points
+	keys      [][3]int32
+	triangles []uint32
+}
+
+// NewBuilder returns a Builder for a new mesh.
+func NewBuilder() *Builder {
+	return &Builder{vertices: make(map[[3]int32]uint32)}
+}
+
+// the six tetrahedra sharing the cube diagonal from corner 0 to 7, where the
+// corner index is x + 2*y + 4*z for unit offsets.
+var tetrahedra = [6][4]uint8{
+	{0, 1, 3, 7},
+	{0, 1, 5, 7},
+	{0, 2, 3, 7},
+	{0, 2, 6, 7},
+	{0, 4, 5, 7},
+	{0, 4, 6, 7},
+}
+
+func cornerOffset(c uint8) [3]int32 {
+	return [3]int32{int32(c & 1), int32(c>>1) & 1, int32(c>>2) & 1}
+}
+
+// March adds the surface within the cubes whose minimum corner is within the given
+// voxel bounds, where begin is inclusive and end is exclusive.
+func (b *Builder) March(begin, end dvid.Point3d, inside InsideFunc) {
+	var corners [8]bool
+	for z := begin[2]; z < end[2]; z++ {
+		for y := begin[1]; y < end[1]; y++ {
+			for x := begin[0]; x < end[0]; x++ {
+				var numInside int
+				for c := uint8(0); c < 8; c++ {
+					off := cornerOffset(c)
+					corners[c] = inside(x+off[0], y+off[1], z+off[2])
+					if corners[c] {
+						numInside++
+					}
+				}
+				if numInside == 0 || numInside == 8 {
+					continue
+				}
+				base := [3]int32{2 * x, 2 * y, 2 * z}
+				for _, tet := range tetrahedra {
+					b.addTetrahedron(base, tet, &corners)
+				}
+			}
+		}
+	}
+}
+
+// returns the doubled lattice coordinate of a cube corner.
+func cornerKey(base [3]int32, c uint8) [3]int32 {
+	off := cornerOffset(c)
+	return [3]int32{base[0] + 2*off[0], base[1] + 2*off[1], base[2] + 2*off[2]}
+}
+
+// returns the index of the vertex at the midpoint of the edge between two cube corners.
+func (b *Builder) edgeVertex(base [3]int32, c1, c2 uint8) uint32 {
+	off1, off2 := cornerOffset(c1), cornerOffset(c2)
+	key := [3]int32{
+		base[0] + off1[0] + off2[0],
+		base[1] + off1[1] + off2[1],
+		base[2] + off1[2] + off2[2],
+	}
+	i, found := b.vertices[key]
+	if !found {
+		i = uint32(len(b.keys))
+		b.vertices[key] = i
+		b.keys = append(b.keys, key)
+	}
+	return i
+}
+
+// adds a triangle, flipping it if needed so its normal points away from the given
+// doubled lattice point inside the surface.
+func (b *Builder) addTriangle(v0, v1, v2 uint32, inside [3]int32) {
+	p0, p1, p2 := b.keys[v0], b.keys[v1], b.keys[v2]
+	var e1, e2, toOut [3]int64
+	for i := 0; i < 3; i++ {
+		e1[i] = int64(p1[i] - p0[i])
+		e2[i] = int64(p2[i] - p0[i])
+		toOut[i] = int64(p0[i] - inside[i])
+	}
+	normal := [3]int64{
+		e1[1]*e2[2] - e1[2]*e2[1],
+		e1[2]*e2[0] - e1[0]*e2[2],
+		e1[0]*e2[1] - e1[1]*e2[0],
+	}
+	if normal[0]*toOut[0]+normal[1]*toOut[1]+normal[2]*toOut[2] < 0 {
+		v1, v2 = v2, v1
+	}
+	b.triangles = append(b.triangles, v0, v1, v2)
+}
+
+func (b *Builder) addTetrahedron(base [3]int32, tet [4]uint8, corners *[8]bool) {
+	var in, out []uint8
+	for _, c := range tet {
+		if corners[c] {
+			in = append(in, c)
+		} else {
+			out = append(out, c)
+		}
+	}
+	switch len(in) {
+	case 1:
+		v0 := b.edgeVertex(base, in[0], out[0])
+		v1 := b.edgeVertex(base, in[0], out[1])
+		v2 := b.edgeVertex(base, in[0], out[2])
+		b.addTriangle(v0, v1, v2, cornerKey(base, in[0]))
+	case 2:
+		// The four crossed edges form a planar quad that separates the inside corners.
+		p0, p1 := cornerKey(base, in[0]), cornerKey(base, in[1])
+		inside := [3]int32{(p0[0] + p1[0]) / 2, (p0[1] + p1[1]) / 2, (p0[2] + p1[2]) / 2}
+		va := b.edgeVertex(base, in[0], out[0])
+		vb := b.edgeVertex(base, in[0], out[1])
+		vc := b.edgeVertex(base, in[1], out[1])
+		vd := b.edgeVertex(base, in[1], out[0])
+		b.addTriangle(va, vb, vc, inside)
+		b.addTriangle(va, vc, vd, inside)
+	case 3:
+		// Orient using the reflection of the outside corner through the triangle.
+		v0 := b.edgeVertex(base, out[0], in[0])
+		v1 := b.edgeVertex(base, out[0], in[1])
+		v2 := b.edgeVertex(base, out[0], in[2])
+		p0 := cornerKey(base, out[0])
+		key := b.keys[v0]
+		inside := [3]int32{2*key[0] - p0[0], 2*key[1] - p0[1], 2*key[2] - p0[2]}
+		b.addTriangle(v0, v1, v2, inside)
+	}
+}
+
+// NumTriangles returns the number of triangles added so far.
+func (b *Builder) NumTriangles() int {
+	return len(b.triangles) / 3
+}
+
+// Mesh returns the accumulated mesh with vertices scaled by the given voxel size
+// along each axis.  Voxel (i, j, k) spans [i, i+1) times the voxel size in each
+// dimension, so a voxel center lies at (i + 0.5) times the voxel size.
+func (b *Builder) Mesh(voxelSize [3]float32) *Mesh {
+	m := &Mesh{
+		Vertices:  make([]float32, 3*len(b.keys)),
+		Triangles: b.triangles,
+	}
+	for i, key := range b.keys {
+		for dim := 0; dim < 3; dim++ {
+			m.Vertices[3*i+dim] = (float32(key[dim]) + 1) * voxelSize[dim] / 2
+		}
+	}
+	return m
+}
+
+// Write serializes the mesh in the given format.
+func (m *Mesh) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatNgmesh:
+		return m.writeNgmesh(w)
+	case FormatOBJ:
+		return m.writeOBJ(w)
+	case FormatPLY:
+		return m.writePLY(w)
+	default:
+		return fmt.Errorf("unknown mesh format %q", format)
+	}
+}
+
+// Neuroglancer legacy format: uint32 # vertices, float32 vertex coordinates, then
+// uint32 triangle vertex indices, all little-endian.
+func (m *Mesh) writeNgmesh(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.LittleEndian, uint32(m.NumVertices())); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, m.Vertices); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, m.Triangles); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (m *Mesh) writeOBJ(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for i := 0; i < len(m.Vertices); i += 3 {
+		if _, err := fmt.Fprintf(bw, "v %g %g %g\n", m.Vertices[i], m.Vertices[i+1], m.Vertices[i+2]); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(m.Triangles); i += 3 {
+		if _, err := fmt.Fprintf(bw, "f %d %d %d\n", m.Triangles[i]+1, m.Triangles[i+1]+1, m.Triangles[i+2]+1); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func (m *Mesh) writePLY(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	header := "ply\nformat binary_little_endian 1.0\n" +
+		fmt.Sprintf("element vertex %d\n", m.NumVertices()) +
+		"property float x\nproperty float y\nproperty float z\n" +
+		fmt.Sprintf("element face %d\n", m.NumTriangles()) +
+		"property list uchar uint vertex_indices\nend_header\n"
+	if _, err := bw.WriteString(header); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, m.Vertices); err != nil {
+		return err
+	}
+	for i := 0; i < len(m.Triangles); i += 3 {
+		if err := bw.WriteByte(3); err != nil {
+			return err
+		}
+		if err := binary.Write(bw, binary.LittleEndian, m.Triangles[i:i+3]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
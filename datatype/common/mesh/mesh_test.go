@@ -0,0 +1,159 @@
+package mesh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// checks that every directed edge is used once and its reverse is used once, i.e.,
+// the surface is closed and consistently oriented.
+func checkClosed(t *testing.T, desc string, m *Mesh) {
+	edges := make(map[[2]uint32]int)
+	for i := 0; i < len(m.Triangles); i += 3 {
+		for j := 0; j < 3; j++ {
+			edge := [2]uint32{m.Triangles[i+j], m.Triangles[i+(j+1)%3]}
+			edges[edge]++
+		}
+	}
+	for edge, count := range edges {
+		if count != 1 {
+			t.Fatalf("%s: directed edge %v used %d times\n", desc, edge, count)
+		}
+		if edges[[2]uint32{edge[1], edge[0]}] != 1 {
+			t.Fatalf("%s: edge %v has no opposing edge\n", desc, edge)
+		}
+	}
+}
+
+// returns the signed volume enclosed by the mesh.
+func meshVolume(m *Mesh) float64 {
+	var vol float64
+	vertex := func(i uint32) [3]float64 {
+		return [3]float64{float64(m.Vertices[3*i]), float64(m.Vertices[3*i+1]), float64(m.Vertices[3*i+2])}
+	}
+	for i := 0; i < len(m.Triangles); i += 3 {
+		a, b, c := vertex(m.Triangles[i]), vertex(m.Triangles[i+1]), vertex(m.Triangles[i+2])
+		vol += a[0]*(b[1]*c[2]-b[2]*c[1]) - a[1]*(b[0]*c[2]-b[2]*c[0]) + a[2]*(b[0]*c[1]-b[1]*c[0])
+	}
+	return vol / 6
+}
+
+func marchVolume(inside InsideFunc, size int32) *Mesh {
+	b := NewBuilder()
+	b.March(dvid.Point3d{-1, -1, -1}, dvid.Point3d{size, size, size}, inside)
+	return b.Mesh([3]float32{1, 1, 1})
+}
+
+func TestMarchSingleVoxel(t *testing.T) {
+	m := marchVolume(func(x, y, z int32) bool {
+		return x == 0 && y == 0 && z == 0
+	}, 1)
+	if m.NumTriangles() == 0 {
+		t.Fatalf("expected triangles for single voxel\n")
+	}
+	checkClosed(t, "single voxel", m)
+	if vol := meshVolume(m); vol <= 0 || vol >= 1 {
+		t.Errorf("expected single voxel mesh volume in (0, 1), got %f\n", vol)
+	}
+	for i, v := range m.Vertices {
+		if v < 0 || v > 1 {
+			t.Errorf("vertex coordinate %d = %f outside voxel bounds\n", i, v)
+		}
+	}
+}
+
+func TestMarchSphere(t *testing.T) {
+	inside := func(x, y, z int32) bool {
+		dx, dy, dz := x-8, y-8, z-8
+		return dx*dx+dy*dy+dz*dz <= 36
+	}
+	m := marchVolume(inside, 17)
+	checkClosed(t, "sphere", m)
+	var numVoxels int
+	for z := int32(0); z < 17; z++ {
+		for y := int32(0); y < 17; y++ {
+			for x := int32(0); x < 17; x++ {
+				if inside(x, y, z) {
+					numVoxels++
+				}
+			}
+		}
+	}
+	vol := meshVolume(m)
+	if vol < 0.7*float64(numVoxels) || vol > 1.1*float64(numVoxels) {
+		t.Errorf("sphere mesh volume %f not close to %d voxels\n", vol, numVoxels)
+	}
+
+	// Marching in two halves should give the same mesh.
+	b := NewBuilder()
+	b.March(dvid.Point3d{-1, -1, -1}, dvid.Point3d{17, 17, 8}, inside)
+	b.March(dvid.Point3d{-1, -1, 8}, dvid.Point3d{17, 17, 17}, inside)
+	halves := b.Mesh([3]float32{1, 1, 1})
+	if halves.NumTriangles() != m.NumTriangles() || halves.NumVertices() != m.NumVertices() {
+		t.Errorf("expected %d triangles, %d vertices from halves, got %d, %d\n",
+			m.NumTriangles(), m.NumVertices(), halves.NumTriangles(), halves.NumVertices())
+	}
+	checkClosed(t, "sphere halves", halves)
+}
+
+func TestWriteFormats(t *testing.T) {
+	m := marchVolume(func(x, y, z int32) bool {
+		return x >= 0 && x < 2 && y == 0 && z == 0
+	}, 2)
+	nv, nt := m.NumVertices(), m.NumTriangles()
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf, FormatNgmesh); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 4+12*nv+12*nt {
+		t.Errorf("expected ngmesh of %d bytes, got %d\n", 4+12*nv+12*nt, buf.Len())
+	}
+	if got := binary.LittleEndian.Uint32(buf.Bytes()[:4]); int(got) != nv {
+		t.Errorf("expected ngmesh vertex count %d, got %d\n", nv, got)
+	}
+
+	buf.Reset()
+	if err := m.Write(&buf, FormatOBJ); err != nil {
+		t.Fatal(err)
+	}
+	var numV, numF int
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		switch {
+		case strings.HasPrefix(line, "v "):
+			numV++
+		case strings.HasPrefix(line, "f "):
+			numF++
+		default:
+			t.Errorf("unexpected OBJ line: %q\n", line)
+		}
+	}
+	if numV != nv || numF != nt {
+		t.Errorf("expected %d vertices, %d faces in OBJ, got %d, %d\n", nv, nt, numV, numF)
+	}
+
+	buf.Reset()
+	if err := m.Write(&buf, FormatPLY); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	headerEnd := bytes.Index(data, []byte("end_header\n"))
+	if headerEnd < 0 {
+		t.Fatalf("no PLY header end found\n")
+	}
+	bodyLen := len(data) - headerEnd - len("end_header\n")
+	if bodyLen != 12*nv+13*nt {
+		t.Errorf("expected PLY body of %d bytes, got %d\n", 12*nv+13*nt, bodyLen)
+	}
+
+	if err := m.Write(&buf, "stl"); err == nil {
+		t.Errorf("expected error on unknown format\n")
+	}
+	if ValidFormat("stl") || !ValidFormat(FormatPLY) {
+		t.Errorf("bad format validation\n")
+	}
+}
@@ -0,0 +1,374 @@
+/*
+	This file supports reading meshes in the supported formats and merging meshes into
+	a single mesh with vertices deduplicated by position.
+*/
+
+package mesh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Read parses a mesh in the given format.  Polygons with more than three vertices are
+// split into triangles that fan out from their first vertex.
+func Read(data []byte, format string) (*Mesh, error) {
+	switch format {
+	case FormatNgmesh:
+		return readNgmesh(data)
+	case FormatOBJ:
+		return readOBJ(data)
+	case FormatPLY:
+		return readPLY(data)
+	default:
+		return nil, fmt.Errorf("unknown mesh format %q", format)
+	}
+}
+
+// adds the triangles of a polygon given by vertex indices, checking the indices are
+// less than the number of vertices.
+func (m *Mesh) addPolygon(face []uint32, numVertices int) error {
+	for _, index := range face {
+		if int(index) >= numVertices {
+			return fmt.Errorf("face has vertex index %d but only %d vertices", index, numVertices)
+		}
+	}
+	for i := 2; i < len(face); i++ {
+		m.Triangles = append(m.Triangles, face[0], face[i-1], face[i])
+	}
+	return nil
+}
+
+func readNgmesh(data []byte) (*Mesh, error) {
+	m := new(Mesh)
+	if len(data) == 0 {
+		return m, nil
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("ngmesh of %d bytes is too small", len(data))
+	}
+	numVertices := int(binary.LittleEndian.Uint32(data[0:4]))
+	pos := 4
+	if len(data) < pos+numVertices*12 || (len(data)-pos-numVertices*12)%12 != 0 {
+		return nil, fmt.Errorf("ngmesh of %d bytes is bad size for %d vertices", len(data), numVertices)
+	}
+	m.Vertices = make([]float32, 3*numVertices)
+	for i := range m.Vertices {
+		m.Vertices[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+	}
+	m.Triangles = make([]uint32, (len(data)-pos)/4)
+	for i := range m.Triangles {
+		m.Triangles[i] = binary.LittleEndian.Uint32(data[pos : pos+4])
+		if int(m.Triangles[i]) >= numVertices {
+			return nil, fmt.Errorf("ngmesh has vertex index %d but only %d vertices", m.Triangles[i], numVertices)
+		}
+		pos += 4
+	}
+	return m, nil
+}
+
+// parses the vertices and faces of an OBJ mesh, ignoring other statements.
+func readOBJ(data []byte) (*Mesh, error) {
+	m := new(Mesh)
+	var faces [][]uint32
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "v":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("obj line %d: vertex needs 3 coordinates", lineNum)
+			}
+			for j := 1; j <= 3; j++ {
+				f, err := strconv.ParseFloat(fields[j], 32)
+				if err != nil {
+					return nil, fmt.Errorf("obj line %d: %v", lineNum, err)
+				}
+				m.Vertices = append(m.Vertices, float32(f))
+			}
+		case "f":
+			face := make([]uint32, len(fields)-1)
+			for j, field := range fields[1:] {
+				// only the vertex index of "v/vt/vn" is used.
+				if slash := strings.IndexByte(field, '/'); slash >= 0 {
+					field = field[:slash]
+				}
+				index, err := strconv.Atoi(field)
+				if err != nil {
+					return nil, fmt.Errorf("obj line %d: %v", lineNum, err)
+				}
+				if index < 0 {
+					index += m.NumVertices() // relative to the last vertex
+				} else {
+					index--
+				}
+				if index < 0 {
+					return nil, fmt.Errorf("obj line %d: bad vertex index %s", lineNum, field)
+				}
+				face[j] = uint32(index)
+			}
+			faces = append(faces, face)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, face := range faces {
+		if err := m.addPolygon(face, m.NumVertices()); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// plyProperty is a property of a PLY element, where a list property has a count type.
+type plyProperty struct {
+	name      string
+	valueType string
+	countType string
+}
+
+type plyElement struct {
+	name       string
+	count      int
+	properties []plyProperty
+}
+
+// returns the byte size of a PLY scalar type.
+func plyTypeSize(typ string) (int, error) {
+	switch typ {
+	case "char", "int8", "uchar", "uint8":
+		return 1, nil
+	case "short", "int16", "ushort", "uint16":
+		return 2, nil
+	case "int", "int32", "uint", "uint32", "float", "float32":
+		return 4, nil
+	case "double", "float64":
+		return 8, nil
+	default:
+		return 0, fmt.Errorf("unknown ply type %q", typ)
+	}
+}
+
+// plyReader reads scalar values from the body of an ASCII or binary PLY file.
+type plyReader struct {
+	data   []byte
+	pos    int
+	ascii  bool
+	fields []string
+	order  binary.ByteOrder
+}
+
+func (r *plyReader) next(typ string) (float64, error) {
+	if r.ascii {
+		if r.pos >= len(r.fields) {
+			return 0, fmt.Errorf("unexpected end of ply data")
+		}
+		field := r.fields[r.pos]
+		r.pos++
+		return strconv.ParseFloat(field, 64)
+	}
+	size, err := plyTypeSize(typ)
+	if err != nil {
+		return 0, err
+	}
+	if r.pos+size > len(r.data) {
+		return 0, fmt.Errorf("unexpected end of ply data")
+	}
+	b := r.data[r.pos : r.pos+size]
+	r.pos += size
+	switch typ {
+	case "char", "int8":
+		return float64(int8(b[0])), nil
+	case "uchar", "uint8":
+		return float64(b[0]), nil
+	case "short", "int16":
+		return float64(int16(r.order.Uint16(b))), nil
+	case "ushort", "uint16":
+		return float64(r.order.Uint16(b)), nil
+	case "int", "int32":
+		return float64(int32(r.order.Uint32(b))), nil
+	case "uint", "uint32":
+		return float64(r.order.Uint32(b)), nil
+	case "float", "float32":
+		return float64(math.Float32frombits(r.order.Uint32(b))), nil
+	default:
+		return math.Float64frombits(r.order.Uint64(b)), nil
+	}
+}
+
+// parses the vertex positions and faces of an ASCII or binary PLY mesh, skipping other
+// properties and elements.
+func readPLY(data []byte) (*Mesh, error) {
+	headerEnd := bytes.Index(data, []byte("end_header"))
+	if !bytes.HasPrefix(data, []byte("ply")) || headerEnd < 0 {
+		return nil, fmt.Errorf("data is not a ply file")
+	}
+	bodyStart := headerEnd + len("end_header")
+	if bodyStart < len(data) && data[bodyStart] == '\r' {
+		bodyStart++
+	}
+	if bodyStart < len(data) && data[bodyStart] == '\n' {
+		bodyStart++
+	}
+
+	r := &plyReader{data: data, pos: bodyStart}
+	var elements []plyElement
+	for _, line := range strings.Split(string(data[:headerEnd]), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "format":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("bad ply format line %q", line)
+			}
+			switch fields[1] {
+			case "ascii":
+				r.ascii = true
+			case "binary_little_endian":
+				r.order = binary.LittleEndian
+			case "binary_big_endian":
+				r.order = binary.BigEndian
+			default:
+				return nil, fmt.Errorf("unknown ply format %q", fields[1])
+			}
+		case "element":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("bad ply element line %q", line)
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("bad ply element line %q: %v", line, err)
+			}
+			elements = append(elements, plyElement{name: fields[1], count: count})
+		case "property":
+			if len(elements) == 0 {
+				return nil, fmt.Errorf("ply property before any element")
+			}
+			elem := &elements[len(elements)-1]
+			if len(fields) == 5 && fields[1] == "list" {
+				elem.properties = append(elem.properties, plyProperty{name: fields[4], valueType: fields[3], countType: fields[2]})
+			} else if len(fields) == 3 {
+				elem.properties = append(elem.properties, plyProperty{name: fields[2], valueType: fields[1]})
+			} else {
+				return nil, fmt.Errorf("bad ply property line %q", line)
+			}
+		}
+	}
+	if r.ascii {
+		r.fields = strings.Fields(string(data[bodyStart:]))
+		r.pos = 0
+	} else if r.order == nil {
+		return nil, fmt.Errorf("ply file has no format")
+	}
+
+	m := new(Mesh)
+	var faces [][]uint32
+	for _, elem := range elements {
+		for i := 0; i < elem.count; i++ {
+			var vertex [3]float32
+			var face []uint32
+			for _, prop := range elem.properties {
+				if prop.countType == "" {
+					value, err := r.next(prop.valueType)
+					if err != nil {
+						return nil, err
+					}
+					switch prop.name {
+					case "x":
+						vertex[0] = float32(value)
+					case "y":
+						vertex[1] = float32(value)
+					case "z":
+						vertex[2] = float32(value)
+					}
+					continue
+				}
+				count, err := r.next(prop.countType)
+				if err != nil {
+					return nil, err
+				}
+				isFace := elem.name == "face" && (prop.name == "vertex_indices" || prop.name == "vertex_index")
+				for j := 0; j < int(count); j++ {
+					value, err := r.next(prop.valueType)
+					if err != nil {
+						return nil, err
+					}
+					if isFace {
+						if value < 0 {
+							return nil, fmt.Errorf("bad ply vertex index %v", value)
+						}
+						face = append(face, uint32(value))
+					}
+				}
+			}
+			switch elem.name {
+			case "vertex":
+				m.Vertices = append(m.Vertices, vertex[:]...)
+			case "face":
+				faces = append(faces, face)
+			}
+		}
+	}
+	for _, face := range faces {
+		if err := m.addPolygon(face, m.NumVertices()); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Merger combines meshes into a single mesh whose vertices are deduplicated by position.
+type Merger struct {
+	mesh        Mesh
+	vertexIndex map[[3]float32]uint32
+}
+
+// NewMerger returns a Merger without any meshes.
+func NewMerger() *Merger {
+	return &Merger{vertexIndex: make(map[[3]float32]uint32)}
+}
+
+// Add adds the vertices and triangles of a mesh.
+func (mg *Merger) Add(m *Mesh) error {
+	numVertices := m.NumVertices()
+	for _, index := range m.Triangles {
+		if int(index) >= numVertices {
+			return fmt.Errorf("triangle has vertex index %d but only %d vertices", index, numVertices)
+		}
+	}
+	mapping := make([]uint32, numVertices)
+	for i := range mapping {
+		vertex := [3]float32{m.Vertices[3*i], m.Vertices[3*i+1], m.Vertices[3*i+2]}
+		index, found := mg.vertexIndex[vertex]
+		if !found {
+			index = uint32(mg.mesh.NumVertices())
+			mg.mesh.Vertices = append(mg.mesh.Vertices, vertex[:]...)
+			mg.vertexIndex[vertex] = index
+		}
+		mapping[i] = index
+	}
+	for _, index := range m.Triangles {
+		mg.mesh.Triangles = append(mg.mesh.Triangles, mapping[index])
+	}
+	return nil
+}
+
+// Mesh returns the merged mesh.
+func (mg *Merger) Mesh() *Mesh {
+	return &mg.mesh
+}
@@ -0,0 +1,80 @@
+package mesh
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// returns the mesh serialized in the given format.
+func writeMesh(t *testing.T, m *Mesh, format string) []byte {
+	var buf bytes.Buffer
+	if err := m.Write(&buf, format); err != nil {
+		t.Fatalf("unable to write %s mesh: %v\n", format, err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadMerge(t *testing.T) {
+	first := &Mesh{Vertices: []float32{0, 0, 0, 1, 0, 0, 0, 1, 0}, Triangles: []uint32{0, 1, 2}}
+	second := &Mesh{Vertices: []float32{1, 1, 0, 0, 1, 0, 1, 0, 0}, Triangles: []uint32{2, 0, 1}}
+	expected := &Mesh{
+		Vertices:  []float32{0, 0, 0, 1, 0, 0, 0, 1, 0, 1, 1, 0},
+		Triangles: []uint32{0, 1, 2, 1, 3, 2},
+	}
+	inputs := map[string][][]byte{
+		FormatNgmesh: {writeMesh(t, first, FormatNgmesh), writeMesh(t, second, FormatNgmesh)},
+		FormatOBJ: {
+			[]byte("# first\nv 0 0 0\nv 1 0 0\nv 0 1 0\nvn 0 0 1\nf 1//1 2//1 3//1\n"),
+			[]byte("v 1 1 0\nv 0 1 0\nv 1 0 0\nf -1 -3 -2\n"),
+		},
+		FormatPLY: {
+			[]byte("ply\nformat ascii 1.0\ncomment first\nelement vertex 3\nproperty float x\nproperty float y\nproperty float z\nproperty uchar red\n" +
+				"element face 1\nproperty list uchar int vertex_indices\nend_header\n0 0 0 255\n1 0 0 255\n0 1 0 255\n3 0 1 2\n"),
+			writeMesh(t, second, FormatPLY),
+		},
+	}
+	for format, meshes := range inputs {
+		merger := NewMerger()
+		for _, data := range meshes {
+			m, err := Read(data, format)
+			if err != nil {
+				t.Fatalf("unable to read %s mesh: %v\n", format, err)
+			}
+			if err := merger.Add(m); err != nil {
+				t.Fatalf("unable to merge %s mesh: %v\n", format, err)
+			}
+		}
+		if merged := merger.Mesh(); !reflect.DeepEqual(merged, expected) {
+			t.Fatalf("expected merged %s mesh %v, got %v\n", format, expected, merged)
+		}
+
+		// Written mesh should read back to the same mesh.
+		m, err := Read(writeMesh(t, merger.Mesh(), format), format)
+		if err != nil {
+			t.Fatalf("unable to read written %s mesh: %v\n", format, err)
+		}
+		if !reflect.DeepEqual(m, expected) {
+			t.Fatalf("expected written %s mesh %v, got %v\n", format, expected, m)
+		}
+	}
+
+	// Polygons are split into triangles.
+	m, err := Read([]byte("v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\nf 1 2 3 4\n"), FormatOBJ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Triangles, []uint32{0, 1, 2, 0, 2, 3}) {
+		t.Fatalf("bad triangles for quad: %v\n", m.Triangles)
+	}
+
+	if _, err := Read([]byte{1, 2, 3, 4, 5}, FormatNgmesh); err == nil {
+		t.Fatalf("expected error on bad ngmesh\n")
+	}
+	if _, err := Read([]byte("v 0 0 0\nf 1 2 3\n"), FormatOBJ); err == nil {
+		t.Fatalf("expected error on bad obj vertex index\n")
+	}
+	if _, err := Read([]byte("mesh"), "drc"); err == nil {
+		t.Fatalf("expected error on unknown mesh format\n")
+	}
+}
@@ -0,0 +1,241 @@
+/*
+	Package precomputed supports the Neuroglancer precomputed volume format so block-based
+	data can be served or exported for direct viewing in Neuroglancer.  Each precomputed
+	chunk corresponds to one DVID block at a given scale.
+
+	See https://github.com/google/neuroglancer/tree/master/src/neuroglancer/datasource/precomputed
+*/
+package precomputed
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Chunk encodings supported by Neuroglancer precomputed volumes.
+const (
+	EncodingRaw                    = "raw"
+	EncodingJPEG                   = "jpeg"
+	EncodingCompressedSegmentation = "compressed_segmentation"
+)
+
+// DefaultJPEGQuality is the quality used for jpeg-encoded chunks.
+const DefaultJPEGQuality = 85
+
+// CompressedSegmentationBlockSize is the size of the blocks within a chunk that are
+// separately encoded in the compressed_segmentation format.
+var CompressedSegmentationBlockSize = [3]int32{8, 8, 8}
+
+// Info is the JSON "info" file describing a multiscale volume.
+type Info struct {
+	Type        string  `json:"@type"`
+	DataType    string  `json:"data_type"`
+	NumChannels int     `json:"num_channels"`
+	VolumeType  string  `json:"type"` // "image" or "segmentation"
+	Scales      []Scale `json:"scales"`
+}
+
+// Scale describes one resolution level of a multiscale volume.
+type Scale struct {
+	Key                             string     `json:"key"`
+	Size                            [3]int32   `json:"size"`
+	Resolution                      [3]float32 `json:"resolution"`
+	VoxelOffset                     [3]int32   `json:"voxel_offset"`
+	ChunkSizes                      [][3]int32 `json:"chunk_sizes"`
+	Encoding                        string     `json:"encoding"`
+	CompressedSegmentationBlockSize *[3]int32  `json:"compressed_segmentation_block_size,omitempty"`
+}
+
+// NewInfo returns an info for a volume of the given data type, e.g., "uint8" or "uint64".
+func NewInfo(dataType, volumeType string) Info {
+	return Info{
+		Type:        "neuroglancer_multiscale_volume",
+		DataType:    dataType,
+		NumChannels: 1,
+		VolumeType:  volumeType,
+	}
+}
+
+// ValidEncoding returns an error if the encoding is unknown or can't be used with the
+// given data type.
+func ValidEncoding(encoding, dataType string) error {
+	switch encoding {
+	case EncodingRaw:
+		return nil
+	case EncodingJPEG:
+		if dataType != "uint8" {
+			return fmt.Errorf("jpeg encoding only supported for uint8 data, not %s", dataType)
+		}
+		return nil
+	case EncodingCompressedSegmentation:
+		if dataType != "uint32" && dataType != "uint64" {
+			return fmt.Errorf("compressed_segmentation encoding only supported for uint32 or uint64 data, not %s", dataType)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown precomputed encoding %q", encoding)
+	}
+}
+
+// ScaleKey returns the key, i.e., the subdirectory name, for a scale level.
+func ScaleKey(scale uint8) string {
+	return fmt.Sprintf("s%d", scale)
+}
+
+// ParseScaleKey returns the scale level for a key returned by ScaleKey.
+func ParseScaleKey(key string) (uint8, error) {
+	if !strings.HasPrefix(key, "s") {
+		return 0, fmt.Errorf("bad precomputed scale key %q", key)
+	}
+	scale, err := strconv.ParseUint(key[1:], 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("bad precomputed scale key %q: %v", key, err)
+	}
+	return uint8(scale), nil
+}
+
+// floor division that works with negative coordinates
+func floorDiv(a, b int32) int32 {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// BlockExtents returns the range of block coordinates at the given scale that cover
+// the voxel extents given at scale 0.
+func BlockExtents(minPoint, maxPoint, blockSize dvid.Point3d, scale uint8) (minBlock, maxBlock dvid.ChunkPoint3d) {
+	for i := 0; i < 3; i++ {
+		scaledSize := blockSize[i] << scale
+		minBlock[i] = floorDiv(minPoint[i], scaledSize)
+		maxBlock[i] = floorDiv(maxPoint[i], scaledSize)
+	}
+	return
+}
+
+// NewScale returns a Scale where each chunk is a block and the volume covers the given
+// range of block coordinates.  The voxel size is for scale 0 and is doubled at each
+// scale level.
+func NewScale(scale uint8, minBlock, maxBlock dvid.ChunkPoint3d, blockSize dvid.Point3d, voxelSize dvid.NdFloat32, encoding string) Scale {
+	s := Scale{
+		Key:        ScaleKey(scale),
+		ChunkSizes: [][3]int32{{blockSize[0], blockSize[1], blockSize[2]}},
+		Encoding:   encoding,
+	}
+	for i := 0; i < 3; i++ {
+		s.VoxelOffset[i] = minBlock[i] * blockSize[i]
+		s.Size[i] = (maxBlock[i] - minBlock[i] + 1) * blockSize[i]
+		s.Resolution[i] = 1
+		if i < len(voxelSize) {
+			s.Resolution[i] = voxelSize[i]
+		}
+		s.Resolution[i] *= float32(int32(1) << scale)
+	}
+	if encoding == EncodingCompressedSegmentation {
+		blockSize := CompressedSegmentationBlockSize
+		s.CompressedSegmentationBlockSize = &blockSize
+	}
+	return s
+}
+
+// ChunkName returns the name of the chunk file for a block, e.g., "64-128_0-64_128-192".
+func ChunkName(bcoord dvid.ChunkPoint3d, blockSize dvid.Point3d) string {
+	begin := bcoord.MinPoint(blockSize).(dvid.Point3d)
+	return fmt.Sprintf("%d-%d_%d-%d_%d-%d", begin[0], begin[0]+blockSize[0],
+		begin[1], begin[1]+blockSize[1], begin[2], begin[2]+blockSize[2])
+}
+
+// ParseChunkName returns the block coordinate for a chunk name.  The chunk must be
+// aligned with and have the size of a block.
+func ParseChunkName(name string, blockSize dvid.Point3d) (bcoord dvid.ChunkPoint3d, err error) {
+	ranges := strings.Split(name, "_")
+	if len(ranges) != 3 {
+		err = fmt.Errorf("bad precomputed chunk name %q", name)
+		return
+	}
+	for i, r := range ranges {
+		var begin, end int32
+		if _, err = fmt.Sscanf(r, "%d-%d", &begin, &end); err != nil {
+			err = fmt.Errorf("bad precomputed chunk name %q: %v", name, err)
+			return
+		}
+		if end-begin != blockSize[i] || begin%blockSize[i] != 0 {
+			err = fmt.Errorf("precomputed chunk %q is not aligned with block size %s", name, blockSize)
+			return
+		}
+		bcoord[i] = begin / blockSize[i]
+	}
+	return
+}
+
+// ParsePath parses the elements of a request path that follow the "precomputed"
+// endpoint.  Any leading elements before "info" or a scale key are returned as options,
+// which allows the info and chunk URLs of a volume to be modified by its base URL.
+func ParsePath(parts []string) (options []string, info bool, scale uint8, chunk string, err error) {
+	for i, part := range parts {
+		if part == "info" {
+			if i != len(parts)-1 {
+				err = fmt.Errorf("nothing can follow info in precomputed request")
+			}
+			return options, true, 0, "", err
+		}
+		if s, keyErr := ParseScaleKey(part); keyErr == nil {
+			if i != len(parts)-2 {
+				err = fmt.Errorf("precomputed scale key %q must be followed by a chunk name", part)
+				return
+			}
+			return options, false, s, parts[i+1], nil
+		}
+		options = append(options, part)
+	}
+	err = fmt.Errorf("precomputed request must end with info or <scale key>/<chunk name>")
+	return
+}
+
+// EncodeJPEG encodes a uint8 chunk in the precomputed jpeg format, a single grayscale
+// image with width equal to the chunk's x size and height equal to its y * z sizes.
+func EncodeJPEG(data []byte, size dvid.Point3d, quality int) ([]byte, error) {
+	width := int(size[0])
+	height := int(size[1] * size[2])
+	if len(data) != width*height {
+		return nil, fmt.Errorf("expected %d bytes for jpeg chunk of size %s, got %d", width*height, size, len(data))
+	}
+	img := &image.Gray{Pix: data, Stride: width, Rect: image.Rect(0, 0, width, height)}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteInfo writes the info file into the given directory, creating it if necessary.
+func WriteInfo(dirPath string, info Info) error {
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dirPath, "info"), data, 0644)
+}
+
+// WriteChunk writes an encoded chunk into the subdirectory for the scale key.
+func WriteChunk(dirPath, key, name string, data []byte) error {
+	scaleDir := filepath.Join(dirPath, key)
+	if err := os.MkdirAll(scaleDir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(scaleDir, name), data, 0644)
+}
@@ -0,0 +1,150 @@
+package precomputed
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestChunkNames(t *testing.T) {
+	blockSize := dvid.Point3d{64, 64, 64}
+	tests := []struct {
+		bcoord dvid.ChunkPoint3d
+		name   string
+	}{
+		{dvid.ChunkPoint3d{0, 0, 0}, "0-64_0-64_0-64"},
+		{dvid.ChunkPoint3d{1, 2, 3}, "64-128_128-192_192-256"},
+		{dvid.ChunkPoint3d{-1, 0, -2}, "-64-0_0-64_-128--64"},
+	}
+	for _, test := range tests {
+		name := ChunkName(test.bcoord, blockSize)
+		if name != test.name {
+			t.Errorf("expected chunk name %q for block %s, got %q\n", test.name, test.bcoord, name)
+		}
+		bcoord, err := ParseChunkName(name, blockSize)
+		if err != nil {
+			t.Fatalf("unable to parse chunk name %q: %v\n", name, err)
+		}
+		if bcoord != test.bcoord {
+			t.Errorf("expected block %s from chunk name %q, got %s\n", test.bcoord, name, bcoord)
+		}
+	}
+	for _, bad := range []string{"0-64_0-64", "0-32_0-64_0-64", "10-74_0-64_0-64", "a-b_0-64_0-64"} {
+		if _, err := ParseChunkName(bad, blockSize); err == nil {
+			t.Errorf("expected error parsing bad chunk name %q\n", bad)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	options, info, _, _, err := ParsePath([]string{"jpeg", "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info || !reflect.DeepEqual(options, []string{"jpeg"}) {
+		t.Errorf("bad parse of info path: info %t, options %v\n", info, options)
+	}
+	options, info, scale, chunk, err := ParsePath([]string{"s2", "0-64_0-64_0-64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info || len(options) != 0 || scale != 2 || chunk != "0-64_0-64_0-64" {
+		t.Errorf("bad parse of chunk path: info %t, options %v, scale %d, chunk %q\n", info, options, scale, chunk)
+	}
+	if _, _, _, _, err = ParsePath([]string{"raw"}); err == nil {
+		t.Errorf("expected error on path without info or chunk\n")
+	}
+	if _, _, _, _, err = ParsePath([]string{"s0"}); err == nil {
+		t.Errorf("expected error on scale key without chunk\n")
+	}
+}
+
+func TestScales(t *testing.T) {
+	blockSize := dvid.Point3d{32, 32, 32}
+	minPoint := dvid.Point3d{-10, 0, 40}
+	maxPoint := dvid.Point3d{100, 63, 200}
+	minBlock, maxBlock := BlockExtents(minPoint, maxPoint, blockSize, 0)
+	if minBlock != (dvid.ChunkPoint3d{-1, 0, 1}) || maxBlock != (dvid.ChunkPoint3d{3, 1, 6}) {
+		t.Errorf("bad scale 0 block extents: %s -> %s\n", minBlock, maxBlock)
+	}
+	scale := NewScale(0, minBlock, maxBlock, blockSize, dvid.NdFloat32{8, 8, 8}, EncodingRaw)
+	if scale.VoxelOffset != [3]int32{-32, 0, 32} || scale.Size != [3]int32{160, 64, 192} {
+		t.Errorf("bad scale 0 geometry: %v\n", scale)
+	}
+	if scale.CompressedSegmentationBlockSize != nil {
+		t.Errorf("expected no compressed segmentation block size for raw encoding\n")
+	}
+
+	minBlock, maxBlock = BlockExtents(minPoint, maxPoint, blockSize, 1)
+	if minBlock != (dvid.ChunkPoint3d{-1, 0, 0}) || maxBlock != (dvid.ChunkPoint3d{1, 0, 3}) {
+		t.Errorf("bad scale 1 block extents: %s -> %s\n", minBlock, maxBlock)
+	}
+	scale = NewScale(1, minBlock, maxBlock, blockSize, dvid.NdFloat32{8, 8, 8}, EncodingCompressedSegmentation)
+	if scale.Key != "s1" || scale.Resolution != [3]float32{16, 16, 16} {
+		t.Errorf("bad scale 1 key or resolution: %v\n", scale)
+	}
+	if scale.CompressedSegmentationBlockSize == nil {
+		t.Errorf("expected compressed segmentation block size for scale 1\n")
+	}
+	if err := ValidEncoding(EncodingJPEG, "uint64"); err == nil {
+		t.Errorf("expected jpeg encoding to be invalid for uint64 data\n")
+	}
+}
+
+func TestExportFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "precomputed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blockSize := dvid.Point3d{8, 8, 8}
+	info := NewInfo("uint8", "image")
+	info.Scales = []Scale{NewScale(0, dvid.ChunkPoint3d{0, 0, 0}, dvid.ChunkPoint3d{1, 1, 1}, blockSize, dvid.NdFloat32{4, 4, 40}, EncodingJPEG)}
+	if err := WriteInfo(dir, info); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "info"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var readInfo Info
+	if err := json.Unmarshal(data, &readInfo); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readInfo, info) {
+		t.Errorf("expected info %v, got %v\n", info, readInfo)
+	}
+
+	voxels := make([]byte, blockSize.Prod())
+	for i := range voxels {
+		voxels[i] = 128
+	}
+	encoded, err := EncodeJPEG(voxels, blockSize, DefaultJPEGQuality)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := ChunkName(dvid.ChunkPoint3d{1, 0, 1}, blockSize)
+	if err := WriteChunk(dir, info.Scales[0].Key, name, encoded); err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(filepath.Join(dir, "s0", "8-16_0-8_8-16"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() != 8 || bounds.Dy() != 64 {
+		t.Errorf("expected 8 x 64 jpeg image, got %d x %d\n", bounds.Dx(), bounds.Dy())
+	}
+}
@@ -0,0 +1,381 @@
+/*
+	Package skeleton supports skeletonization of binary volumes using a TEASAR-style
+	algorithm and serialization of skeletons in the SWC format.
+*/
+package skeleton
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Node is a point in a skeleton as described in the SWC format.
+type Node struct {
+	ID     int
+	Type   int
+	X      float32
+	Y      float32
+	Z      float32
+	Radius float32
+	Parent int // -1 for a root node
+}
+
+// Skeleton is a forest of nodes where each tree has a single root.
+type Skeleton struct {
+	Nodes []Node
+}
+
+// WriteSWC writes the skeleton in SWC format with any given comment lines as header.
+func (s *Skeleton) WriteSWC(w io.Writer, comments ...string) error {
+	bw := bufio.NewWriter(w)
+	for _, comment := range comments {
+		if _, err := fmt.Fprintf(bw, "# %s\n", comment); err != nil {
+			return err
+		}
+	}
+	for _, node := range s.Nodes {
+		_, err := fmt.Fprintf(bw, "%d %d %g %g %g %g %d\n", node.ID, node.Type, node.X, node.Y, node.Z, node.Radius, node.Parent)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ParseSWC returns a skeleton from SWC-formatted data.
+func ParseSWC(r io.Reader) (*Skeleton, error) {
+	s := new(Skeleton)
+	scanner := bufio.NewScanner(r)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("SWC line %d has %d fields, expected 7", lineNum, len(fields))
+		}
+		var node Node
+		var err error
+		var vals [4]float64
+		if node.ID, err = strconv.Atoi(fields[0]); err != nil {
+			return nil, fmt.Errorf("bad node id on SWC line %d: %v", lineNum, err)
+		}
+		if node.Type, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, fmt.Errorf("bad node type on SWC line %d: %v", lineNum, err)
+		}
+		for i := 0; i < 4; i++ {
+			if vals[i], err = strconv.ParseFloat(fields[2+i], 32); err != nil {
+				return nil, fmt.Errorf("bad value on SWC line %d: %v", lineNum, err)
+			}
+		}
+		node.X, node.Y, node.Z, node.Radius = float32(vals[0]), float32(vals[1]), float32(vals[2]), float32(vals[3])
+		if node.Parent, err = strconv.Atoi(fields[6]); err != nil {
+			return nil, fmt.Errorf("bad parent id on SWC line %d: %v", lineNum, err)
+		}
+		s.Nodes = append(s.Nodes, node)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Volume is a dense binary volume to be skeletonized.
+type Volume struct {
+	Offset    dvid.Point3d // coordinate of the first voxel
+	Size      dvid.Point3d
+	Mask      []bool     // true if voxel is in the object, in x, y, then z order
+	VoxelSize [3]float32 // physical size of a voxel along each axis
+}
+
+// Params are the TEASAR parameters.  A path's invalidation radius is Scale times the
+// distance to the boundary plus Const, both in voxels.
+type Params struct {
+	Scale float64
+	Const float64
+}
+
+// DefaultParams are the default TEASAR parameters.
+var DefaultParams = Params{Scale: 3, Const: 2}
+
+// penalty weight for voxels near the boundary so paths follow the object's center.
+const penaltyWeight = 5000
+
+var neighbors [26]struct {
+	dx, dy, dz int32
+	dist       float64
+}
+
+func init() {
+	var n int
+	for dz := int32(-1); dz <= 1; dz++ {
+		for dy := int32(-1); dy <= 1; dy++ {
+			for dx := int32(-1); dx <= 1; dx++ {
+				if dx == 0 && dy == 0 && dz == 0 {
+					continue
+				}
+				neighbors[n].dx, neighbors[n].dy, neighbors[n].dz = dx, dy, dz
+				neighbors[n].dist = math.Sqrt(float64(dx*dx + dy*dy + dz*dz))
+				n++
+			}
+		}
+	}
+}
+
+type queuedVoxel struct {
+	i    int
+	dist float64
+}
+
+type voxelQueue []queuedVoxel
+
+func (q voxelQueue) Len() int            { return len(q) }
+func (q voxelQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q voxelQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *voxelQueue) Push(x interface{}) { *q = append(*q, x.(queuedVoxel)) }
+func (q *voxelQueue) Pop() (x interface{}) {
+	n := len(*q)
+	x, *q = (*q)[n-1], (*q)[:n-1]
+	return
+}
+
+type teasar struct {
+	vol    *Volume
+	nx, ny int32
+	dbf    []float64 // distance to boundary
+	maxDBF float64
+}
+
+func (t *teasar) coord(i int) (x, y, z int32) {
+	x = int32(i) % t.nx
+	y = (int32(i) / t.nx) % t.ny
+	z = int32(i) / (t.nx * t.ny)
+	return
+}
+
+func (t *teasar) inside(x, y, z int32) bool {
+	size := t.vol.Size
+	if x < 0 || y < 0 || z < 0 || x >= size[0] || y >= size[1] || z >= size[2] {
+		return false
+	}
+	return t.vol.Mask[(z*t.ny+y)*t.nx+x]
+}
+
+// computes the chamfer distance of each object voxel to the nearest voxel outside
+// the object, where voxels outside the volume are outside the object.
+func (t *teasar) computeDBF() {
+	size := t.vol.Size
+	t.dbf = make([]float64, len(t.vol.Mask))
+	for i, in := range t.vol.Mask {
+		if in {
+			t.dbf[i] = math.Inf(1)
+		}
+	}
+	get := func(x, y, z int32) float64 {
+		if !t.inside(x, y, z) {
+			return 0
+		}
+		return t.dbf[(z*t.ny+y)*t.nx+x]
+	}
+	pass := func(x, y, z int32, forward bool) {
+		i := (z*t.ny+y)*t.nx + x
+		if !t.vol.Mask[i] {
+			return
+		}
+		for _, nb := range neighbors {
+			before := nb.dz < 0 || (nb.dz == 0 && (nb.dy < 0 || (nb.dy == 0 && nb.dx < 0)))
+			if before != forward {
+				continue
+			}
+			if d := get(x+nb.dx, y+nb.dy, z+nb.dz) + nb.dist; d < t.dbf[i] {
+				t.dbf[i] = d
+			}
+		}
+	}
+	for z := int32(0); z < size[2]; z++ {
+		for y := int32(0); y < size[1]; y++ {
+			for x := int32(0); x < size[0]; x++ {
+				pass(x, y, z, true)
+			}
+		}
+	}
+	for z := size[2] - 1; z >= 0; z-- {
+		for y := size[1] - 1; y >= 0; y-- {
+			for x := size[0] - 1; x >= 0; x-- {
+				pass(x, y, z, false)
+			}
+		}
+	}
+	for _, d := range t.dbf {
+		if d > t.maxDBF {
+			t.maxDBF = d
+		}
+	}
+}
+
+// returns the cost of stepping into a voxel where paths near the boundary are penalized.
+func (t *teasar) penalty(i int) float64 {
+	return penaltyWeight*math.Pow(1-t.dbf[i]/t.maxDBF, 16) + 1
+}
+
+// computes shortest path distances and parents from the source voxel through the object
+// voxels, optionally weighting steps by the boundary penalty.
+func (t *teasar) dijkstra(source int, penalized bool) (dist []float64, parent []int) {
+	dist = make([]float64, len(t.vol.Mask))
+	parent = make([]int, len(t.vol.Mask))
+	for i := range dist {
+		dist[i] = math.Inf(1)
+		parent[i] = -1
+	}
+	dist[source] = 0
+	q := &voxelQueue{{source, 0}}
+	done := make([]bool, len(t.vol.Mask))
+	for q.Len() > 0 {
+		i := heap.Pop(q).(queuedVoxel).i
+		if done[i] {
+			continue
+		}
+		done[i] = true
+		x, y, z := t.coord(i)
+		for _, nb := range neighbors {
+			nx, ny, nz := x+nb.dx, y+nb.dy, z+nb.dz
+			if !t.inside(nx, ny, nz) {
+				continue
+			}
+			j := int((nz*t.ny+ny)*t.nx + nx)
+			step := nb.dist
+			if penalized {
+				step *= t.penalty(j)
+			}
+			if d := dist[i] + step; d < dist[j] {
+				dist[j] = d
+				parent[j] = i
+				heap.Push(q, queuedVoxel{j, d})
+			}
+		}
+	}
+	return
+}
+
+// returns the reached voxel with the largest distance.
+func farthest(dist []float64) (far int) {
+	far = -1
+	maxDist := -1.0
+	for i, d := range dist {
+		if !math.IsInf(d, 1) && d > maxDist {
+			far, maxDist = i, d
+		}
+	}
+	return
+}
+
+// TEASAR returns a skeleton of the object in the volume with one tree per 26-connected
+// component.  The root of each tree is at an extreme of its component and branches are
+// added for the voxel farthest from the root not yet within the invalidation radius of
+// an existing branch.  Paths and distances are computed in voxel units, and node positions
+// are voxel centers scaled by the volume's voxel size.  Node radii are the distance to
+// the boundary scaled by the smallest voxel dimension.
+func TEASAR(vol *Volume, params Params) (*Skeleton, error) {
+	if int64(len(vol.Mask)) != vol.Size.Prod() {
+		return nil, fmt.Errorf("volume mask has %d voxels, expected %d for size %s", len(vol.Mask), vol.Size.Prod(), vol.Size)
+	}
+	t := &teasar{vol: vol, nx: vol.Size[0], ny: vol.Size[1]}
+	t.computeDBF()
+
+	minVoxelSize := vol.VoxelSize[0]
+	for _, size := range vol.VoxelSize[1:] {
+		if size < minVoxelSize {
+			minVoxelSize = size
+		}
+	}
+	skel := new(Skeleton)
+	addNode := func(i, parent int) int {
+		x, y, z := t.coord(i)
+		id := len(skel.Nodes) + 1
+		skel.Nodes = append(skel.Nodes, Node{
+			ID:     id,
+			X:      (float32(vol.Offset[0]+x) + 0.5) * vol.VoxelSize[0],
+			Y:      (float32(vol.Offset[1]+y) + 0.5) * vol.VoxelSize[1],
+			Z:      (float32(vol.Offset[2]+z) + 0.5) * vol.VoxelSize[2],
+			Radius: float32(t.dbf[i]) * minVoxelSize,
+			Parent: parent,
+		})
+		return id
+	}
+
+	processed := make([]bool, len(vol.Mask))
+	invalid := make([]bool, len(vol.Mask))
+	for start, in := range vol.Mask {
+		if !in || processed[start] {
+			continue
+		}
+		// The root is the voxel of the component farthest from an arbitrary voxel.
+		dist, _ := t.dijkstra(start, false)
+		root := farthest(dist)
+		daf, _ := t.dijkstra(root, false)
+		_, parent := t.dijkstra(root, true)
+
+		var component []int
+		for i, d := range daf {
+			if !math.IsInf(d, 1) {
+				component = append(component, i)
+				processed[i] = true
+			}
+		}
+		sort.SliceStable(component, func(a, b int) bool { return daf[component[a]] > daf[component[b]] })
+
+		nodeIDs := map[int]int{root: addNode(root, -1)}
+		t.invalidate(root, params, invalid)
+		for _, target := range component {
+			if invalid[target] {
+				continue
+			}
+			// Trace the path back to the existing skeleton.
+			var path []int
+			for i := target; i >= 0; i = parent[i] {
+				if _, found := nodeIDs[i]; found {
+					break
+				}
+				path = append(path, i)
+			}
+			if len(path) == 0 {
+				continue
+			}
+			parentID := nodeIDs[parent[path[len(path)-1]]]
+			for p := len(path) - 1; p >= 0; p-- {
+				parentID = addNode(path[p], parentID)
+				nodeIDs[path[p]] = parentID
+				t.invalidate(path[p], params, invalid)
+			}
+		}
+	}
+	return skel, nil
+}
+
+// marks the object voxels within the invalidation radius of the given voxel.
+func (t *teasar) invalidate(i int, params Params, invalid []bool) {
+	r := params.Scale*t.dbf[i] + params.Const
+	ri := int32(math.Ceil(r))
+	x, y, z := t.coord(i)
+	for dz := -ri; dz <= ri; dz++ {
+		for dy := -ri; dy <= ri; dy++ {
+			for dx := -ri; dx <= ri; dx++ {
+				if float64(dx*dx+dy*dy+dz*dz) > r*r || !t.inside(x+dx, y+dy, z+dz) {
+					continue
+				}
+				invalid[((z+dz)*t.ny+y+dy)*t.nx+x+dx] = true
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package skeleton
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func makeVolume(size dvid.Point3d, inside func(x, y, z int32) bool) *Volume {
+	vol := &Volume{
+		Size:      size,
+		Mask:      make([]bool, size.Prod()),
+		VoxelSize: [3]float32{1, 1, 1},
+	}
+	var i int
+	for z := int32(0); z < size[2]; z++ {
+		for y := int32(0); y < size[1]; y++ {
+			for x := int32(0); x < size[0]; x++ {
+				vol.Mask[i] = inside(x, y, z)
+				i++
+			}
+		}
+	}
+	return vol
+}
+
+// returns the number of roots and leaves of a skeleton after checking parent references.
+func checkTree(t *testing.T, skel *Skeleton) (roots, leaves int) {
+	children := make(map[int]int)
+	ids := make(map[int]bool)
+	for _, node := range skel.Nodes {
+		ids[node.ID] = true
+		if node.Parent == -1 {
+			roots++
+		} else {
+			if !ids[node.Parent] {
+				t.Fatalf("node %d has parent %d that isn't an earlier node\n", node.ID, node.Parent)
+			}
+			children[node.Parent]++
+		}
+	}
+	for _, node := range skel.Nodes {
+		if children[node.ID] == 0 {
+			leaves++
+		}
+	}
+	return
+}
+
+func TestTEASARTube(t *testing.T) {
+	vol := makeVolume(dvid.Point3d{60, 9, 9}, func(x, y, z int32) bool {
+		return x >= 2 && x < 58 && y >= 2 && y < 7 && z >= 2 && z < 7
+	})
+	vol.Offset = dvid.Point3d{100, 0, 0}
+	vol.VoxelSize = [3]float32{2, 2, 2}
+	skel, err := TEASAR(vol, DefaultParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, leaves := checkTree(t, skel)
+	if roots != 1 || leaves != 1 {
+		t.Errorf("expected single unbranched path for tube, got %d roots, %d leaves\n", roots, leaves)
+	}
+	minX, maxX := float32(1e9), float32(-1e9)
+	for i, node := range skel.Nodes {
+		if i > 2 && i < len(skel.Nodes)-3 && (node.Y != 9 || node.Z != 9) {
+			t.Errorf("expected tube skeleton along center, got node %v\n", node)
+		}
+		if node.X < minX {
+			minX = node.X
+		}
+		if node.X > maxX {
+			maxX = node.X
+		}
+		if node.Radius <= 0 || node.Radius > 6 {
+			t.Errorf("bad radius for node %v\n", node)
+		}
+	}
+	if minX > 2*106 || maxX < 2*154 {
+		t.Errorf("expected tube skeleton to span most of tube, got x from %f to %f\n", minX, maxX)
+	}
+}
+
+func TestTEASARBranches(t *testing.T) {
+	// A plus sign with four arms and a separate block.
+	vol := makeVolume(dvid.Point3d{64, 64, 5}, func(x, y, z int32) bool {
+		if z < 1 || z > 3 {
+			return false
+		}
+		if x >= 2 && x < 42 && y >= 20 && y < 23 {
+			return true
+		}
+		if y >= 2 && y < 42 && x >= 20 && x < 23 {
+			return true
+		}
+		return x >= 50 && x < 60 && y >= 50 && y < 53
+	})
+	skel, err := TEASAR(vol, DefaultParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, leaves := checkTree(t, skel)
+	if roots != 2 {
+		t.Errorf("expected 2 roots for 2 components, got %d\n", roots)
+	}
+	// The plus sign is rooted at the end of one arm so has a leaf at each other arm.
+	if leaves != 4 {
+		t.Errorf("expected 4 leaves, got %d\n", leaves)
+	}
+}
+
+func TestSWC(t *testing.T) {
+	skel := &Skeleton{Nodes: []Node{
+		{ID: 1, X: 1.5, Y: 2, Z: 3, Radius: 2.25, Parent: -1},
+		{ID: 2, Type: 3, X: 4, Y: 5, Z: 6.5, Radius: 1, Parent: 1},
+	}}
+	var buf bytes.Buffer
+	if err := skel.WriteSWC(&buf, "test skeleton"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# test skeleton\n1 0 1.5 2 3 2.25 -1\n2 3 4 5 6.5 1 1\n"
+	if buf.String() != expected {
+		t.Errorf("expected SWC:\n%s\ngot:\n%s\n", expected, buf.String())
+	}
+	parsed, err := ParseSWC(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, skel) {
+		t.Errorf("expected parsed skeleton %v, got %v\n", skel, parsed)
+	}
+	if _, err := ParseSWC(bytes.NewBufferString("1 0 1 2 3\n")); err == nil {
+		t.Errorf("expected error on bad SWC line\n")
+	}
+}
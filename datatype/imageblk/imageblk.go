@@ -445,6 +445,29 @@ GET  <api URL>/node/<UUID>/<data name>/arb/<top left>/<top right>/<bottom left>/
                     (all API calls that can be throttled) are handled.  If the server can't initiate the API 
                     call right away, a 503 (Service Unavailable) status code is returned.
 
+GET <api URL>/node/<UUID>/<data name>/precomputed[/<encoding>]/info
+GET <api URL>/node/<UUID>/<data name>/precomputed[/<encoding>]/<scale key>/<chunk name>
+
+    Serves single-channel image data in the Neuroglancer precomputed format so it can be viewed
+    directly with a Neuroglancer source URL like:
+
+    precomputed://http://<server>/api/node/3f8c/grayscale/precomputed/jpeg
+
+    The info file describes a single scale volume, with key "s0", covering the data extents.
+    Each chunk is a single block with a name giving its voxel bounds, e.g., "0-32_32-64_0-32".
+    Chunks for blocks that have not been stored are returned with background values.
+
+    Arguments:
+
+    UUID          Hexadecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data.
+    encoding      Optional chunk encoding: "raw" (default) or "jpeg" (uint8 data only).  The 
+                    encoding is part of the path so it applies to chunks requested by Neuroglancer.
+
+    A version can also be exported to a local directory in the same layout using the command:
+
+    $ dvid repo <UUID> export-precomputed <data name> <directory> [encoding=jpeg]
+
  GET <api URL>/node/<UUID>/<data name>/blocks/<block coord>/<spanX>
 POST <api URL>/node/<UUID>/<data name>/blocks/<block coord>/<spanX>
 
@@ -2122,6 +2145,10 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		fmt.Fprintf(w, string(jsonBytes))
 		return
 
+	case "precomputed":
+		d.handlePrecomputed(ctx, w, r, parts)
+		return
+
 	case "rawkey":
 		// GET <api URL>/node/<UUID>/<data name>/rawkey?x=<block x>&y=<block y>&z=<block z>
 		if len(parts) != 4 {
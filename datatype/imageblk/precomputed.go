@@ -0,0 +1,236 @@
+/*
+	This file supports serving and exporting image data in the Neuroglancer precomputed format.
+*/
+
+package imageblk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/precomputed"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// returns the precomputed data type for this data, which must have a single channel.
+func (d *Data) precomputedDataType() (string, error) {
+	// Datatypes that embed imageblk, e.g., labelblk, can store blocks differently.
+	if !strings.HasPrefix(string(d.TypeURL()), "github.com/janelia-flyem/dvid/datatype/imageblk/") {
+		return "", fmt.Errorf("precomputed format not supported for data %q of type %s", d.DataName(), d.TypeName())
+	}
+	if len(d.Properties.Values) != 1 {
+		return "", fmt.Errorf("precomputed format only supported for single channel data, not %d channels", len(d.Properties.Values))
+	}
+	switch d.Properties.Values[0].T {
+	case dvid.T_uint8:
+		return "uint8", nil
+	case dvid.T_uint16:
+		return "uint16", nil
+	case dvid.T_uint32:
+		return "uint32", nil
+	case dvid.T_uint64:
+		return "uint64", nil
+	case dvid.T_float32:
+		return "float32", nil
+	default:
+		return "", fmt.Errorf("data %q has a value type not supported by the precomputed format", d.DataName())
+	}
+}
+
+// returns the chunk encoding given by options, defaulting to raw.
+func (d *Data) precomputedEncoding(options []string) (string, error) {
+	dataType, err := d.precomputedDataType()
+	if err != nil {
+		return "", err
+	}
+	encoding := precomputed.EncodingRaw
+	switch len(options) {
+	case 0:
+	case 1:
+		encoding = options[0]
+	default:
+		return "", fmt.Errorf("only a single encoding option is allowed for data %q, got %v", d.DataName(), options)
+	}
+	if err := precomputed.ValidEncoding(encoding, dataType); err != nil {
+		return "", err
+	}
+	return encoding, nil
+}
+
+// precomputedInfo returns the info for a single scale volume covering the extents of this data.
+func (d *Data) precomputedInfo(ctx *datastore.VersionedCtx, encoding string) (info precomputed.Info, err error) {
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		err = fmt.Errorf("data %q has non-3d block size %s", d.DataName(), d.BlockSize())
+		return
+	}
+	var dataType string
+	if dataType, err = d.precomputedDataType(); err != nil {
+		return
+	}
+	var extents dvid.Extents
+	if extents, err = d.GetExtents(ctx); err != nil {
+		return
+	}
+	if extents.MinPoint == nil || extents.MaxPoint == nil {
+		err = fmt.Errorf("data %q has no extents yet", d.DataName())
+		return
+	}
+	minBlock, maxBlock := precomputed.BlockExtents(extents.MinPoint.(dvid.Point3d), extents.MaxPoint.(dvid.Point3d), blockSize, 0)
+	info = precomputed.NewInfo(dataType, "image")
+	info.Scales = []precomputed.Scale{precomputed.NewScale(0, minBlock, maxBlock, blockSize, d.Properties.VoxelSize, encoding)}
+	return
+}
+
+// encodes uncompressed block data in the precomputed format.
+func (d *Data) encodePrecomputedBlock(data []byte, encoding string) ([]byte, error) {
+	if encoding == precomputed.EncodingJPEG {
+		return precomputed.EncodeJPEG(data, d.BlockSize().(dvid.Point3d), precomputed.DefaultJPEGQuality)
+	}
+	return data, nil
+}
+
+// getPrecomputedChunk returns a block encoded as a precomputed chunk.  If no block
+// is stored at the given coordinate, a chunk of background values is returned.
+func (d *Data) getPrecomputedChunk(ctx *datastore.VersionedCtx, bcoord dvid.ChunkPoint3d, encoding string) ([]byte, error) {
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, err
+	}
+	val, err := store.Get(ctx, NewTKeyByCoord(bcoord.ToIZYXString()))
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if val == nil {
+		data = d.BackgroundBlock()
+	} else if data, _, err = dvid.DeserializeData(val, true); err != nil {
+		return nil, fmt.Errorf("unable to deserialize block %s in %q: %v", bcoord, d.DataName(), err)
+	}
+	return d.encodePrecomputedBlock(data, encoding)
+}
+
+func (d *Data) handlePrecomputed(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
+	// GET <api URL>/node/<UUID>/<data name>/precomputed[/<encoding>]/info
+	// GET <api URL>/node/<UUID>/<data name>/precomputed[/<encoding>]/<scale key>/<chunk name>
+	if strings.ToLower(r.Method) != "get" {
+		server.BadRequest(w, r, "only GET action is available on precomputed endpoint")
+		return
+	}
+	timedLog := dvid.NewTimeLog()
+	options, isInfo, scale, chunk, err := precomputed.ParsePath(parts[4:])
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	encoding, err := d.precomputedEncoding(options)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if isInfo {
+		info, err := d.precomputedInfo(ctx, encoding)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		jsonBytes, err := json.Marshal(info)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, string(jsonBytes))
+		return
+	}
+	if scale != 0 {
+		server.BadRequest(w, r, "data %q only has precomputed scale 0", d.DataName())
+		return
+	}
+	bcoord, err := precomputed.ParseChunkName(chunk, d.BlockSize().(dvid.Point3d))
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	data, err := d.getPrecomputedChunk(ctx, bcoord, encoding)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-type", "application/octet-stream")
+	if _, err := w.Write(data); err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	timedLog.Infof("HTTP GET precomputed chunk %s (%s)", chunk, r.URL)
+}
+
+// ExportPrecomputed writes all stored blocks in the given version to a directory using
+// the Neuroglancer precomputed layout.  Settings can include "encoding" ("raw" default
+// or "jpeg" for uint8 data).  Blocks that were never stored are not written.
+func (d *Data) ExportPrecomputed(uuid dvid.UUID, dirPath string, config dvid.Config) error {
+	timedLog := dvid.NewTimeLog()
+	var options []string
+	encoding, found, err := config.GetString("encoding")
+	if err != nil {
+		return err
+	}
+	if found {
+		options = append(options, encoding)
+	}
+	if encoding, err = d.precomputedEncoding(options); err != nil {
+		return err
+	}
+
+	v, err := datastore.VersionFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	ctx := datastore.NewVersionedCtx(d, v)
+	info, err := d.precomputedInfo(ctx, encoding)
+	if err != nil {
+		return err
+	}
+	if err := precomputed.WriteInfo(dirPath, info); err != nil {
+		return err
+	}
+
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	blockSize := d.BlockSize().(dvid.Point3d)
+	key := precomputed.ScaleKey(0)
+	begTKey := NewTKeyByCoord(dvid.MinIndexZYX.ToIZYXString())
+	endTKey := NewTKeyByCoord(dvid.MaxIndexZYX.ToIZYXString())
+	var numChunks int
+	err = store.ProcessRange(ctx, begTKey, endTKey, &storage.ChunkOp{}, func(c *storage.Chunk) error {
+		if c == nil || c.TKeyValue == nil || c.V == nil {
+			return nil
+		}
+		idx, err := DecodeTKey(c.K)
+		if err != nil {
+			return err
+		}
+		data, _, err := dvid.DeserializeData(c.V, true)
+		if err != nil {
+			return fmt.Errorf("unable to deserialize block %s in %q: %v", idx, d.DataName(), err)
+		}
+		encoded, err := d.encodePrecomputedBlock(data, encoding)
+		if err != nil {
+			return err
+		}
+		numChunks++
+		return precomputed.WriteChunk(dirPath, key, precomputed.ChunkName(dvid.ChunkPoint3d(*idx), blockSize), encoded)
+	})
+	if err != nil {
+		return err
+	}
+	timedLog.Infof("Exported %d precomputed chunks for %q, version %s, to %s", numChunks, d.DataName(), uuid, dirPath)
+	return nil
+}
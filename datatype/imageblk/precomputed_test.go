@@ -0,0 +1,94 @@
+package imageblk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datatype/common/precomputed"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// returns the voxels of one block in a volume with first voxel at (0,0,0)
+func extractBlock(volume []byte, volSize dvid.Point3d, bcoord dvid.ChunkPoint3d, blockSize dvid.Point3d) []byte {
+	block := make([]byte, 0, blockSize.Prod())
+	for z := bcoord[2] * blockSize[2]; z < (bcoord[2]+1)*blockSize[2]; z++ {
+		for y := bcoord[1] * blockSize[1]; y < (bcoord[1]+1)*blockSize[1]; y++ {
+			i := z*volSize[0]*volSize[1] + y*volSize[0] + bcoord[0]*blockSize[0]
+			block = append(block, volume[i:i+blockSize[0]]...)
+		}
+	}
+	return block
+}
+
+func TestPrecomputed(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	grayscale := makeGrayscale(uuid, t, "grayscale")
+	blockSize := grayscale.BlockSize().(dvid.Point3d)
+
+	vol := testVolume{
+		offset: dvid.Point3d{0, 0, 0},
+		size:   dvid.Point3d{2 * blockSize[0], blockSize[1], blockSize[2]},
+	}
+	vol.data = makeVolume(vol.offset, vol.size)
+	vol.put(t, uuid, "grayscale")
+
+	baseURL := fmt.Sprintf("%snode/%s/grayscale/precomputed", server.WebAPIPath, uuid)
+	var info precomputed.Info
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", baseURL+"/info", nil), &info); err != nil {
+		t.Fatalf("unable to decode precomputed info: %v\n", err)
+	}
+	if info.DataType != "uint8" || info.VolumeType != "image" || len(info.Scales) != 1 {
+		t.Fatalf("bad precomputed info: %v\n", info)
+	}
+	if info.Scales[0].Encoding != precomputed.EncodingRaw || info.Scales[0].Size != [3]int32{vol.size[0], vol.size[1], vol.size[2]} {
+		t.Errorf("bad precomputed scale: %v\n", info.Scales[0])
+	}
+
+	bcoord := dvid.ChunkPoint3d{1, 0, 0}
+	chunkName := precomputed.ChunkName(bcoord, blockSize)
+	expected := extractBlock(vol.data, vol.size, bcoord, blockSize)
+	chunk := server.TestHTTP(t, "GET", baseURL+"/s0/"+chunkName, nil)
+	if !bytes.Equal(chunk, expected) {
+		t.Errorf("raw precomputed chunk %s doesn't match posted data\n", chunkName)
+	}
+
+	chunk = server.TestHTTP(t, "GET", baseURL+"/jpeg/s0/"+chunkName, nil)
+	img, err := jpeg.Decode(bytes.NewBuffer(chunk))
+	if err != nil {
+		t.Fatalf("unable to decode jpeg chunk: %v\n", err)
+	}
+	if img.Bounds().Dx() != int(blockSize[0]) || img.Bounds().Dy() != int(blockSize[1]*blockSize[2]) {
+		t.Errorf("bad jpeg chunk dimensions: %v\n", img.Bounds())
+	}
+
+	server.TestBadHTTP(t, "GET", baseURL+"/compressed_segmentation/info", nil)
+	server.TestBadHTTP(t, "GET", baseURL+"/s1/"+chunkName, nil)
+
+	dir, err := ioutil.TempDir("", "imageblk-precomputed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := grayscale.ExportPrecomputed(uuid, dir, dvid.NewConfig()); err != nil {
+		t.Fatalf("error exporting precomputed: %v\n", err)
+	}
+	exported, err := ioutil.ReadFile(filepath.Join(dir, "s0", chunkName))
+	if err != nil {
+		t.Fatalf("unable to read exported chunk: %v\n", err)
+	}
+	if !bytes.Equal(exported, expected) {
+		t.Errorf("exported precomputed chunk %s doesn't match posted data\n", chunkName)
+	}
+}
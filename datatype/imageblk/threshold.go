@@ -0,0 +1,143 @@
+/*
+	This file supports finding the voxels with values in a range, e.g., for creating ROIs.
+*/
+
+package imageblk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// returns a function that reads the value of the i-th voxel of a block as a float64.
+func (d *Data) thresholdValueFunc() (func(data []byte, i int) float64, error) {
+	// Datatypes that embed imageblk, e.g., labelblk, can store blocks differently.
+	if !strings.HasPrefix(string(d.TypeURL()), "github.com/janelia-flyem/dvid/datatype/imageblk/") {
+		return nil, fmt.Errorf("thresholding not supported for data %q of type %s", d.DataName(), d.TypeName())
+	}
+	if len(d.Properties.Values) != 1 {
+		return nil, fmt.Errorf("thresholding only supported for single channel data, not %d channels", len(d.Properties.Values))
+	}
+	switch d.Properties.Values[0].T {
+	case dvid.T_uint8:
+		return func(data []byte, i int) float64 {
+			return float64(data[i])
+		}, nil
+	case dvid.T_uint16:
+		return func(data []byte, i int) float64 {
+			return float64(binary.LittleEndian.Uint16(data[i*2:]))
+		}, nil
+	case dvid.T_uint32:
+		return func(data []byte, i int) float64 {
+			return float64(binary.LittleEndian.Uint32(data[i*4:]))
+		}, nil
+	case dvid.T_uint64:
+		return func(data []byte, i int) float64 {
+			return float64(binary.LittleEndian.Uint64(data[i*8:]))
+		}, nil
+	case dvid.T_float32:
+		return func(data []byte, i int) float64 {
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:])))
+		}, nil
+	case dvid.T_float64:
+		return func(data []byte, i int) float64 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:]))
+		}, nil
+	default:
+		return nil, fmt.Errorf("data %q has a value type that can't be thresholded", d.DataName())
+	}
+}
+
+// CheckThreshold returns an error if the data's values can't be thresholded.  Implements the
+// roi.ThresholdSpanner interface.
+func (d *Data) CheckThreshold() error {
+	_, err := d.thresholdValueFunc()
+	return err
+}
+
+// ProcessThresholdSpans calls f with the voxel spans, [z, y, x0, x1] in voxel coordinates, of
+// voxels with values in [minValue, maxValue] within the given extents for each stored block
+// in ZYX order.  If the extents are nil, all stored blocks are processed.  Implements the
+// roi.ThresholdSpanner interface.
+func (d *Data) ProcessThresholdSpans(v dvid.VersionID, ext *dvid.Extents3d, minValue, maxValue float64, f func(dvid.Spans) error) error {
+	valueFunc, err := d.thresholdValueFunc()
+	if err != nil {
+		return err
+	}
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return fmt.Errorf("block size for data %q should be 3d, not: %s", d.DataName(), d.BlockSize())
+	}
+	minTKey := storage.MinTKey(keyImageBlock)
+	maxTKey := storage.MaxTKey(keyImageBlock)
+	var minBlock, maxBlock dvid.ChunkPoint3d
+	if ext != nil {
+		minBlock = ext.MinPoint.Chunk(blockSize).(dvid.ChunkPoint3d)
+		maxBlock = ext.MaxPoint.Chunk(blockSize).(dvid.ChunkPoint3d)
+		minIndex, maxIndex := dvid.IndexZYX(minBlock), dvid.IndexZYX(maxBlock)
+		minTKey, maxTKey = NewTKey(&minIndex), NewTKey(&maxIndex)
+	}
+
+	ctx := datastore.NewVersionedCtx(d, v)
+	return store.ProcessRange(ctx, minTKey, maxTKey, &storage.ChunkOp{}, func(chunk *storage.Chunk) error {
+		if chunk == nil || chunk.V == nil {
+			return nil
+		}
+		indexZYX, err := DecodeTKey(chunk.K)
+		if err != nil {
+			return fmt.Errorf("Error decoding voxel block key: %v\n", err)
+		}
+		bcoord := dvid.ChunkPoint3d(*indexZYX)
+		if ext != nil && (bcoord[0] < minBlock[0] || bcoord[0] > maxBlock[0] || bcoord[1] < minBlock[1] || bcoord[1] > maxBlock[1]) {
+			return nil
+		}
+		data, _, err := dvid.DeserializeData(chunk.V, true)
+		if err != nil {
+			return fmt.Errorf("Error decoding block: %v\n", err)
+		}
+		if int64(len(data)) != blockSize.Prod()*int64(d.Values.BytesPerElement()) {
+			return fmt.Errorf("block %s of %q has %d bytes, expected %d voxels", bcoord, d.DataName(), len(data), blockSize.Prod())
+		}
+		offset := bcoord.MinPoint(blockSize).(dvid.Point3d)
+		var spans dvid.Spans
+		i := 0
+		for z := offset[2]; z < offset[2]+blockSize[2]; z++ {
+			for y := offset[1]; y < offset[1]+blockSize[1]; y++ {
+				inSpan := false
+				var x0 int32
+				for x := offset[0]; x < offset[0]+blockSize[0]; x++ {
+					value := valueFunc(data, i)
+					inside := value >= minValue && value <= maxValue
+					if inside && ext != nil {
+						inside = ext.VoxelWithin(dvid.Point3d{x, y, z})
+					}
+					if inside && !inSpan {
+						x0 = x
+						inSpan = true
+					} else if !inside && inSpan {
+						spans = append(spans, dvid.Span{z, y, x0, x - 1})
+						inSpan = false
+					}
+					i++
+				}
+				if inSpan {
+					spans = append(spans, dvid.Span{z, y, x0, offset[0] + blockSize[0] - 1})
+				}
+			}
+		}
+		if len(spans) == 0 {
+			return nil
+		}
+		return f(spans)
+	})
+}
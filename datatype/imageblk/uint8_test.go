@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"net/http"
 	"reflect"
 	"sync"
 	"testing"
@@ -293,6 +294,82 @@ func TestForegroundROI(t *testing.T) {
 	}
 }
 
+func TestThresholdROI(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	makeGrayscale(uuid, t, "grayscale")
+	server.CreateTestInstance(t, uuid, "roi", "thresh", dvid.Config{})
+
+	// A 64^3 volume with a bright 20^3 box at (10,10,10) and dimmer voxels at (40,40,40).
+	data := make([]uint8, 64*64*64)
+	for z := 10; z < 30; z++ {
+		for y := 10; y < 30; y++ {
+			for x := 10; x < 30; x++ {
+				data[z*64*64+y*64+x] = 200
+			}
+		}
+	}
+	data[40*64*64+40*64+40] = 50
+	putRequest := fmt.Sprintf("%snode/%s/grayscale/raw/0_1_2/64_64_64/0_0_0", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", putRequest, bytes.NewBuffer(data))
+
+	boxSpans := func(x0, x1 int32) dvid.Spans {
+		var spans dvid.Spans
+		for z := int32(10); z < 30; z++ {
+			for y := int32(10); y < 30; y++ {
+				spans = append(spans, dvid.Span{z, y, x0, x1})
+			}
+		}
+		return spans
+	}
+	createRequest := fmt.Sprintf("%snode/%s/thresh/create", server.WebAPIPath, uuid)
+	roiRequest := fmt.Sprintf("%snode/%s/thresh/roi?voxels=true", server.WebAPIPath, uuid)
+	tests := []struct {
+		request  string
+		expected dvid.Spans
+	}{
+		{`{"source": "grayscale", "min": 100, "max": 255}`, boxSpans(10, 29)},
+		{`{"source": "grayscale", "min": 100, "max": 255, "offset": [0, 0, 0], "size": [20, 64, 64]}`, boxSpans(10, 19)},
+		{`{"source": "grayscale", "min": 40, "max": 60}`, dvid.Spans{{40, 40, 40, 40}}},
+	}
+	for _, tc := range tests {
+		server.TestHTTP(t, "POST", createRequest, bytes.NewBufferString(tc.request))
+		if err := datastore.BlockOnUpdating(uuid, "thresh"); err != nil {
+			t.Fatalf("Error blocking on threshold roi updating: %v\n", err)
+		}
+		var spans dvid.Spans
+		if err := json.Unmarshal(server.TestHTTP(t, "GET", roiRequest, nil), &spans); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(spans, tc.expected) {
+			t.Errorf("Bad threshold ROI for %s: got %d spans, expected %d\n", tc.request, len(spans), len(tc.expected))
+		}
+	}
+
+	// ROI is partial content while being created.
+	d, err := datastore.GetDataByUUIDName(uuid, "thresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updater := d.(interface {
+		StartUpdate()
+		StopUpdate()
+	})
+	updater.StartUpdate()
+	resp := server.TestHTTPResponse(t, "GET", roiRequest, nil)
+	updater.StopUpdate()
+	if resp.Code != http.StatusPartialContent {
+		t.Errorf("Expected status %d for ROI being created, got %d\n", http.StatusPartialContent, resp.Code)
+	}
+
+	server.TestBadHTTP(t, "POST", createRequest, bytes.NewBufferString(`{"source": "grayscale", "min": 100}`))
+	server.TestBadHTTP(t, "POST", createRequest, bytes.NewBufferString(`{"source": "grayscale", "bodies": [1]}`))
+}
+
 func TestDirectCalls(t *testing.T) {
 	if err := server.OpenTest(); err != nil {
 		t.Fatalf("can't open test server: %v\n", err)
@@ -73,7 +73,8 @@ $ dvid repo <UUID> new imagetile <data name> <settings...>
 					  tile request time and is a better choice if you primarily ask for arbitrary sized images
 					  (via GET .../raw/... or .../isotropic/...) instead of tiles (via GET .../tile/...)
 	Versioned      "true" or "false" (default)
-	Source         Name of uint8blk data instance if using the tile "generate" command below.
+	Source         Name of uint8blk data instance if using the tile "generate" command below
+	                  or syncing tiles to the source (see POST .../sync below).
 	Placeholder    Bool ("false", "true", "0", or "1").  Return placeholder tile if missing.
 
 
@@ -205,6 +206,30 @@ POST <api URL>/node/<UUID>/<data name>/metadata
 	thereby defining the extent of the tiled volume when coupled with level "0" tile sizes.
 
 
+POST <api URL>/node/<UUID>/<data name>/sync?<options>
+
+	Syncs the tiles to an imageblk source (e.g., uint8blk) so that the tiles at all scales
+	that intersect ingested or mutated source blocks are regenerated from the source voxels.
+	XY, XZ, and YZ tiles are regenerated and the tile metadata must already be set either
+	by the "generate" command or a POST to the metadata endpoint.  Expects JSON to be
+	POSTed with the following format:
+
+	{ "sync": "grayscale" }
+
+	To delete syncs, pass an empty string of names with query string "replace=true":
+
+	{ "sync": "" }
+
+	If the imagetile instance has no Source, it is set to the synced instance.  Otherwise
+	the imagetile can only be synced to its Source.  Tiles of blocks changed before the
+	sync are not regenerated.
+
+	POST Query-string Options:
+
+	replace    Set to "true" if you want passed syncs to replace and not be appended to current syncs.
+			   Default operation is false.
+
+
 GET  <api URL>/node/<UUID>/<data name>/tile/<dims>/<scaling>/<tile coord>[?noblanks=true]
 POST
 	Retrieves or adds tile of named data within a version node.  This GET call should be the fastest
@@ -622,7 +647,12 @@ type Properties struct {
 // Data embeds the datastore's Data and extends it with voxel-specific properties.
 type Data struct {
 	*datastore.Data
+	datastore.Updater
 	Properties
+
+	// channels for block change events from the synced source.
+	syncCh   chan datastore.SyncMessage
+	syncDone chan *sync.WaitGroup
 }
 
 // CopyPropertiesFrom copies the data instance-specific properties from a given
@@ -913,6 +943,18 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		}
 		timedLog.Infof("HTTP %s: metadata (%s)", r.Method, r.URL)
 
+	case "sync":
+		if action != "post" {
+			server.BadRequest(w, r, "Only POST allowed to sync endpoint")
+			return
+		}
+		replace := r.URL.Query().Get("replace") == "true"
+		if err := datastore.SetSyncByJSON(d, uuid, replace, r.Body); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		timedLog.Infof("HTTP %s: sync (%s)", r.Method, r.URL)
+
 	case "tile":
 		switch action {
 		case "post":
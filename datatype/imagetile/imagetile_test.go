@@ -5,8 +5,11 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"log"
+	"net/http"
 	"reflect"
 	"sync"
 	"testing"
@@ -162,7 +165,7 @@ func TestMultiscale2dRepoPersistence(t *testing.T) {
 	if !ok {
 		t.Fatalf("Can't cast imagetile data service into imagetile.Data\n")
 	}
-	oldData := *msdata
+	oldProperties := msdata.Properties
 
 	// Restart test datastore and see if datasets are still there.
 	if err = datastore.SaveDataByUUID(uuid, msdata); err != nil {
@@ -179,8 +182,8 @@ func TestMultiscale2dRepoPersistence(t *testing.T) {
 		t.Errorf("Returned new data instance 2 is not imagetile.Data\n")
 	}
 
-	if !reflect.DeepEqual(oldData.Properties, msdata2.Properties) {
-		t.Errorf("Expected properties %v, got %v\n", oldData.Properties, msdata2.Properties)
+	if !reflect.DeepEqual(oldProperties, msdata2.Properties) {
+		t.Errorf("Expected properties %v, got %v\n", oldProperties, msdata2.Properties)
 	}
 }
 
@@ -371,3 +374,140 @@ func TestTileCheck(t *testing.T) {
 	}
 
 }
+
+const testSyncMetadata = `
+{
+	"MinTileCoord": [0,0,0],
+	"MaxTileCoord": [1,1,1],
+	"Levels": {
+	    "0": {  "Resolution": [10.0, 10.0, 10.0], "TileSize": [32, 32, 32] },
+	    "1": {  "Resolution": [20.0, 20.0, 20.0], "TileSize": [32, 32, 32] }
+	}
+}
+`
+
+func postGrayscale(t *testing.T, uuid dvid.UUID, offset, size dvid.Point3d, value byte, mutate bool) {
+	data := bytes.Repeat([]byte{value}, int(size.Prod()))
+	url := fmt.Sprintf("%snode/%s/grayscale/raw/0_1_2/%d_%d_%d/%d_%d_%d", server.WebAPIPath, uuid,
+		size[0], size[1], size[2], offset[0], offset[1], offset[2])
+	if mutate {
+		url += "?mutate=true"
+	}
+	server.TestHTTP(t, "POST", url, bytes.NewBuffer(data))
+}
+
+// returns the decoded tile and checks it has the expected size.
+func getTestTile(t *testing.T, uuid dvid.UUID, tileURL string) *image.Gray {
+	url := fmt.Sprintf("%snode/%s/tiles/tile/%s?noblanks=true", server.WebAPIPath, uuid, tileURL)
+	data := server.TestHTTP(t, "GET", url, nil)
+	img, err := png.Decode(bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatalf("unable to decode tile %s: %v\n", tileURL, err)
+	}
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		t.Fatalf("expected gray tile %s, got %T\n", tileURL, img)
+	}
+	if gray.Bounds().Dx() != 32 || gray.Bounds().Dy() != 32 {
+		t.Fatalf("expected 32x32 tile %s, got %s\n", tileURL, gray.Bounds())
+	}
+	return gray
+}
+
+func checkTileValue(t *testing.T, tileURL string, img *image.Gray, x, y int, expected byte) {
+	if value := img.GrayAt(x, y).Y; value != expected {
+		t.Errorf("expected value %d at (%d,%d) in tile %s, got %d\n", expected, x, y, tileURL, value)
+	}
+}
+
+func TestSyncTiles(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	makeGrayscale(uuid, t, "grayscale")
+	server.CreateTestInstance(t, uuid, "imagetile", "tiles", dvid.Config{})
+
+	url := fmt.Sprintf("%snode/%s/tiles/metadata", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url, bytes.NewBufferString(testSyncMetadata))
+	url = fmt.Sprintf("%snode/%s/tiles/sync", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url, bytes.NewBufferString(`{"sync": "grayscale"}`))
+
+	dataservice, err := datastore.GetDataByUUIDName(uuid, "tiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiles, ok := dataservice.(*Data)
+	if !ok {
+		t.Fatalf("Can't cast imagetile data service into imagetile.Data\n")
+	}
+	if tiles.Source != "grayscale" {
+		t.Errorf("expected sync to set source to grayscale, got %q\n", tiles.Source)
+	}
+
+	// Ingest 64^3 voxels and check tiles at both scales for all planes.
+	postGrayscale(t, uuid, dvid.Point3d{0, 0, 0}, dvid.Point3d{64, 64, 64}, 100, false)
+	if err := datastore.BlockOnUpdating(uuid, "tiles"); err != nil {
+		t.Fatalf("error blocking on sync of tiles: %v\n", err)
+	}
+	for _, tileURL := range []string{"xy/0/0_0_5", "xy/0/1_1_63", "xz/0/1_10_0", "yz/0/40_1_1", "xy/1/0_0_5", "yz/1/0_0_0"} {
+		img := getTestTile(t, uuid, tileURL)
+		checkTileValue(t, tileURL, img, 0, 0, 100)
+		checkTileValue(t, tileURL, img, 31, 31, 100)
+	}
+
+	// Mutate a block and check the affected tiles were regenerated.
+	postGrayscale(t, uuid, dvid.Point3d{32, 0, 0}, dvid.Point3d{32, 32, 32}, 200, true)
+	if err := datastore.BlockOnUpdating(uuid, "tiles"); err != nil {
+		t.Fatalf("error blocking on sync of tiles: %v\n", err)
+	}
+	img := getTestTile(t, uuid, "xy/0/1_0_5")
+	checkTileValue(t, "xy/0/1_0_5", img, 0, 0, 200)
+	checkTileValue(t, "xy/0/1_0_5", img, 31, 31, 200)
+	img = getTestTile(t, uuid, "xy/0/0_0_5")
+	checkTileValue(t, "xy/0/0_0_5", img, 31, 31, 100)
+	img = getTestTile(t, uuid, "xy/0/1_1_5")
+	checkTileValue(t, "xy/0/1_1_5", img, 0, 0, 100)
+	img = getTestTile(t, uuid, "xy/0/1_0_40")
+	checkTileValue(t, "xy/0/1_0_40", img, 0, 0, 100)
+	img = getTestTile(t, uuid, "yz/0/40_0_0")
+	checkTileValue(t, "yz/0/40_0_0", img, 0, 0, 200)
+	checkTileValue(t, "yz/0/40_0_0", img, 31, 31, 200)
+	img = getTestTile(t, uuid, "xz/0/1_10_0")
+	checkTileValue(t, "xz/0/1_10_0", img, 0, 0, 200)
+	img = getTestTile(t, uuid, "xy/1/0_0_5")
+	checkTileValue(t, "xy/1/0_0_5", img, 4, 4, 100)
+	checkTileValue(t, "xy/1/0_0_5", img, 20, 4, 200)
+	checkTileValue(t, "xy/1/0_0_5", img, 20, 20, 100)
+
+	// Tiles partly outside the source extents are regenerated from the voxels within them.
+	postGrayscale(t, uuid, dvid.Point3d{64, 0, 0}, dvid.Point3d{32, 32, 32}, 150, false)
+	if err := datastore.BlockOnUpdating(uuid, "tiles"); err != nil {
+		t.Fatalf("error blocking on sync of tiles: %v\n", err)
+	}
+	img = getTestTile(t, uuid, "xy/0/2_0_5")
+	checkTileValue(t, "xy/0/2_0_5", img, 0, 0, 150)
+	img = getTestTile(t, uuid, "xy/1/1_0_5")
+	checkTileValue(t, "xy/1/1_0_5", img, 0, 0, 150)
+	checkTileValue(t, "xy/1/1_0_5", img, 15, 15, 150)
+	checkTileValue(t, "xy/1/1_0_5", img, 16, 0, 0)
+	checkTileValue(t, "xy/1/1_0_5", img, 0, 16, 0)
+
+	// Tiles beyond MaxTileCoord are not generated.
+	postGrayscale(t, uuid, dvid.Point3d{128, 0, 0}, dvid.Point3d{32, 32, 32}, 50, false)
+	if err := datastore.BlockOnUpdating(uuid, "tiles"); err != nil {
+		t.Fatalf("error blocking on sync of tiles: %v\n", err)
+	}
+	tileURL := fmt.Sprintf("%snode/%s/tiles/tile/xy/0/4_0_5?noblanks=true", server.WebAPIPath, uuid)
+	if resp := server.TestHTTPResponse(t, "GET", tileURL, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("expected no tile beyond MaxTileCoord, got status %d\n", resp.Code)
+	}
+
+	// Only an imageblk source can be synced.
+	if _, err := datastore.NewData(uuid, roitype, "myroi", dvid.NewConfig()); err != nil {
+		t.Fatalf("Error creating new roi instance: %v\n", err)
+	}
+	server.TestBadHTTP(t, "POST", url, bytes.NewBufferString(`{"sync": "myroi"}`))
+}
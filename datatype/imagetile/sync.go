@@ -0,0 +1,501 @@
+/*
+	This file supports imagetile instances synced to an imageblk source.  When blocks of the
+	source are ingested or mutated, the tiles at all scales that intersect the changed blocks
+	are regenerated from the source voxels.
+*/
+
+package imagetile
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/imageblk"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+const (
+	// Number of change messages we can buffer before blocking on sync channel.
+	syncBufferSize = 1000
+
+	// Time to accumulate block changes before regenerating the affected tiles, so tiles
+	// covering many changed blocks are only regenerated once.
+	syncBatchDelay = 250 * time.Millisecond
+
+	// Maximum number of block changes accumulated before tiles are regenerated.
+	syncBatchSize = 1000
+)
+
+// the tiled planes and the axis normal to each plane.
+var (
+	syncPlanes  = []dvid.DataShape{dvid.XY, dvid.XZ, dvid.YZ}
+	planeNormal = []uint8{2, 1, 0}
+)
+
+// tileID identifies a tile by the index of its plane in syncPlanes, its scale, and its
+// tile coordinate.
+type tileID struct {
+	plane int
+	scale Scaling
+	tile  dvid.ChunkPoint3d
+}
+
+// tileBatch holds the tiles affected by changed blocks in a version and the most recent
+// data of those blocks, which may not have been committed to the source yet.
+type tileBatch struct {
+	tiles  map[tileID]struct{}
+	blocks map[dvid.IndexZYX][]byte
+}
+
+// InitDataHandlers launches a goroutine to handle the source's block change events.
+func (d *Data) InitDataHandlers() error {
+	if d.syncCh != nil || d.syncDone != nil {
+		return nil
+	}
+	d.syncCh = make(chan datastore.SyncMessage, syncBufferSize)
+	d.syncDone = make(chan *sync.WaitGroup)
+
+	// Launch handlers of sync events.
+	dvid.Infof("Launching sync event handler for data %q...\n", d.DataName())
+	go d.processEvents()
+	return nil
+}
+
+// Shutdown terminates blocks until syncs are done then terminates background goroutines processing data.
+func (d *Data) Shutdown(wg *sync.WaitGroup) {
+	if d.syncDone != nil {
+		dwg := new(sync.WaitGroup)
+		dwg.Add(1)
+		d.syncDone <- dwg
+		dwg.Wait() // Block until we are done.
+	}
+	wg.Done()
+}
+
+// GetSyncSubs implements the datastore.Syncer interface.  Returns a list of subscriptions
+// to the sync data instance that will notify the receiver.
+func (d *Data) GetSyncSubs(synced dvid.Data) (subs datastore.SyncSubs, err error) {
+	if _, ok := synced.(*imageblk.Data); !ok {
+		err = fmt.Errorf("imagetile instances can only be synced to imageblk instances, not %q (type %s)", synced.DataName(), synced.TypeName())
+		return
+	}
+	if d.Source == "" {
+		d.Source = synced.DataName()
+	} else if d.Source != synced.DataName() {
+		err = fmt.Errorf("imagetile %q can only be synced to its source %q, not %q", d.DataName(), d.Source, synced.DataName())
+		return
+	}
+	if d.syncCh == nil {
+		if err = d.InitDataHandlers(); err != nil {
+			err = fmt.Errorf("unable to initialize handlers for data %q: %v", d.DataName(), err)
+			return
+		}
+	}
+	for _, event := range []string{imageblk.IngestBlockEvent, imageblk.MutateBlockEvent} {
+		subs = append(subs, datastore.SyncSub{
+			Event:  datastore.SyncEvent{Data: synced.DataUUID(), Event: event},
+			Notify: d.DataUUID(),
+			Ch:     d.syncCh,
+		})
+	}
+	return
+}
+
+func (d *Data) processEvents() {
+	defer func() {
+		if e := recover(); e != nil {
+			msg := fmt.Sprintf("Panic detected on imagetile sync thread: %+v\n", e)
+			dvid.ReportPanic(msg, server.WebServer())
+		}
+	}()
+	batches := make(map[dvid.VersionID]*tileBatch)
+	var pending int
+	var flush <-chan time.Time
+	regenerate := func() {
+		d.regenerateTiles(batches)
+		batches = make(map[dvid.VersionID]*tileBatch)
+		for ; pending > 0; pending-- {
+			d.StopUpdate()
+		}
+		flush = nil
+	}
+
+	var stop bool
+	var wg *sync.WaitGroup
+	for {
+		select {
+		case wg = <-d.syncDone:
+			queued := len(d.syncCh)
+			if queued > 0 {
+				dvid.Infof("Received shutdown signal for %q sync events (%d in queue)\n", d.DataName(), queued)
+				stop = true
+			} else {
+				regenerate()
+				dvid.Infof("Shutting down sync event handler for instance %q...\n", d.DataName())
+				wg.Done()
+				return
+			}
+		case <-flush:
+			regenerate()
+		case msg := <-d.syncCh:
+			d.StartUpdate()
+			pending++
+			d.queueSyncMessage(batches, msg)
+			if pending >= syncBatchSize {
+				regenerate()
+			} else if flush == nil {
+				flush = time.After(syncBatchDelay)
+			}
+
+			if stop && len(d.syncCh) == 0 {
+				regenerate()
+				dvid.Infof("Shutting down sync event handler for instance %q after draining sync events.\n", d.DataName())
+				wg.Done()
+				return
+			}
+		}
+	}
+}
+
+// adds the changed block of a sync message and the tiles it affects to the version's batch.
+func (d *Data) queueSyncMessage(batches map[dvid.VersionID]*tileBatch, msg datastore.SyncMessage) {
+	var index *dvid.IndexZYX
+	var data []byte
+	switch delta := msg.Delta.(type) {
+	case imageblk.Block:
+		index, data = delta.Index, delta.Data
+	case imageblk.MutatedBlock:
+		index, data = delta.Index, delta.Data
+	default:
+		dvid.Criticalf("Received unknown delta in imagetile %q sync: %v\n", d.DataName(), msg)
+		return
+	}
+	if len(d.Levels) == 0 {
+		dvid.Debugf("Ignoring block change for imagetile %q without tile metadata.\n", d.DataName())
+		return
+	}
+	source, err := datastore.GetDataByVersionName(msg.Version, d.Source)
+	if err != nil {
+		dvid.Errorf("unable to get source %q of imagetile %q: %v\n", d.Source, d.DataName(), err)
+		return
+	}
+	src, ok := source.(*imageblk.Data)
+	if !ok {
+		dvid.Errorf("source %q of imagetile %q is not imageblk data\n", d.Source, d.DataName())
+		return
+	}
+
+	batch, found := batches[msg.Version]
+	if !found {
+		batch = &tileBatch{
+			tiles:  make(map[tileID]struct{}),
+			blocks: make(map[dvid.IndexZYX][]byte),
+		}
+		batches[msg.Version] = batch
+	}
+	batch.blocks[*index] = data
+
+	blockSize, ok := src.BlockSize().(dvid.Point3d)
+	if !ok {
+		dvid.Errorf("source %q of imagetile %q does not have 3d blocks\n", d.Source, d.DataName())
+		return
+	}
+	mags, err := d.scaleMags()
+	if err != nil {
+		dvid.Errorf("imagetile %q: %v\n", d.DataName(), err)
+		return
+	}
+	chunkPt := dvid.ChunkPoint3d(*index)
+	blockExtents := dvid.Extents3d{
+		MinPoint: chunkPt.MinPoint(blockSize).(dvid.Point3d),
+		MaxPoint: chunkPt.MaxPoint(blockSize).(dvid.Point3d),
+	}
+	for scale := Scaling(0); scale < Scaling(len(d.Levels)); scale++ {
+		tileSize := d.Levels[scale].TileSize.Mult(mags[scale]).(dvid.Point3d)
+		for i, normal := range planeNormal {
+			// Tiles are indexed by voxel coordinate along the axis normal to the plane.
+			minTile, maxTile := blockExtents.BlockRange(tileSize)
+			minTile[normal] = blockExtents.MinPoint[normal]
+			maxTile[normal] = blockExtents.MaxPoint[normal]
+			for z := minTile[2]; z <= maxTile[2]; z++ {
+				for y := minTile[1]; y <= maxTile[1]; y++ {
+					for x := minTile[0]; x <= maxTile[0]; x++ {
+						batch.tiles[tileID{i, scale, dvid.ChunkPoint3d{x, y, z}}] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+}
+
+// returns the magnification of the voxels at each scale relative to the source voxels,
+// cascading the magnification between levels as ConstructTiles does.  Tile specs read
+// from storage don't keep the magnification between levels, so those default to 2x as
+// in computeVoxelBounds.
+func (d *Data) scaleMags() ([]dvid.Point3d, error) {
+	mags := make([]dvid.Point3d, len(d.Levels))
+	mag := dvid.Point3d{1, 1, 1}
+	for scale := range mags {
+		spec, found := d.Levels[Scaling(scale)]
+		if !found {
+			return nil, fmt.Errorf("no tile spec for scale %d", scale)
+		}
+		mags[scale] = mag
+		mag = mag.Mult(levelMag(spec)).(dvid.Point3d)
+	}
+	return mags, nil
+}
+
+// returns the magnification from the given level to the next level.
+func levelMag(spec TileScaleSpec) dvid.Point3d {
+	if spec.levelMag[0] == 0 || spec.levelMag[1] == 0 || spec.levelMag[2] == 0 {
+		return dvid.Point3d{2, 2, 2}
+	}
+	return spec.levelMag
+}
+
+// sliceID identifies a full resolution slice by the index of its plane in syncPlanes and
+// its voxel coordinate along the axis normal to the plane.
+type sliceID struct {
+	plane int
+	coord int32
+}
+
+// regenerates the tiles of each version's batch.
+func (d *Data) regenerateTiles(batches map[dvid.VersionID]*tileBatch) {
+	for v, batch := range batches {
+		if len(batch.tiles) == 0 {
+			continue
+		}
+		timedLog := dvid.NewTimeLog()
+		source, err := datastore.GetDataByVersionName(v, d.Source)
+		if err != nil {
+			dvid.Errorf("unable to get source %q of imagetile %q: %v\n", d.Source, d.DataName(), err)
+			continue
+		}
+		src, ok := source.(*imageblk.Data)
+		if !ok {
+			dvid.Errorf("source %q of imagetile %q is not imageblk data\n", d.Source, d.DataName())
+			continue
+		}
+		outF, err := d.putTileFunc(v)
+		if err != nil {
+			dvid.Errorf("unable to regenerate tiles for imagetile %q: %v\n", d.DataName(), err)
+			continue
+		}
+
+		// Group tiles by the full resolution slice they are generated from.
+		slices := make(map[sliceID][]tileID)
+		for id := range batch.tiles {
+			sid := sliceID{id.plane, id.tile[planeNormal[id.plane]]}
+			slices[sid] = append(slices[sid], id)
+		}
+		for sid, tiles := range slices {
+			server.BlockOnInteractiveRequests("imagetile.regenerateTiles")
+			if err := d.regenerateSlice(src, v, sid, tiles, batch.blocks, outF); err != nil {
+				dvid.Errorf("unable to regenerate %s tiles at %d for imagetile %q: %v\n",
+					syncPlanes[sid.plane], sid.coord, d.DataName(), err)
+			}
+		}
+		timedLog.Debugf("Regenerated %d tiles of imagetile %q after %d block changes", len(batch.tiles), d.DataName(), len(batch.blocks))
+	}
+}
+
+// returns the intersection of two extents and whether it is non-empty.
+func intersectExtents(a, b dvid.Extents3d) (dvid.Extents3d, bool) {
+	var ext dvid.Extents3d
+	for i := 0; i < 3; i++ {
+		ext.MinPoint[i] = a.MinPoint[i]
+		if b.MinPoint[i] > ext.MinPoint[i] {
+			ext.MinPoint[i] = b.MinPoint[i]
+		}
+		ext.MaxPoint[i] = a.MaxPoint[i]
+		if b.MaxPoint[i] < ext.MaxPoint[i] {
+			ext.MaxPoint[i] = b.MaxPoint[i]
+		}
+		if ext.MinPoint[i] > ext.MaxPoint[i] {
+			return ext, false
+		}
+	}
+	return ext, true
+}
+
+// returns the voxel bounds of the tiles between MinTileCoord and MaxTileCoord, which are
+// given at the lowest scale like ConstructTiles does.
+func (d *Data) tiledExtents(mags []dvid.Point3d) dvid.Extents3d {
+	lastScale := Scaling(len(mags) - 1)
+	loresSize := d.Levels[lastScale].TileSize.Mult(mags[lastScale]).(dvid.Point3d)
+	var ext dvid.Extents3d
+	for i := 0; i < 3; i++ {
+		ext.MinPoint[i] = d.MinTileCoord[i] * loresSize[i]
+		ext.MaxPoint[i] = (d.MaxTileCoord[i]+1)*loresSize[i] - 1
+	}
+	return ext
+}
+
+// regenerates the tiles of one full resolution slice from the source voxels, using the given
+// block data in place of any stored data for those blocks.  The part of the slice covering
+// the tiles is read once, then reduced from scale to scale like ConstructTiles does.  Tiles
+// outside the tiled bounds are skipped and only voxels within the tiled bounds and the
+// source extents are read.
+func (d *Data) regenerateSlice(src *imageblk.Data, v dvid.VersionID, sid sliceID, tiles []tileID, blocks map[dvid.IndexZYX][]byte, outF outFunc) error {
+	plane := syncPlanes[sid.plane]
+	mags, err := d.scaleMags()
+	if err != nil {
+		return err
+	}
+	tiled := d.tiledExtents(mags)
+
+	// Get the voxel bounds of all tiles, which are aligned to the tiles of the lowest scale.
+	var tileExtents []dvid.Extents3d
+	var inBounds []tileID
+	var extents dvid.Extents3d
+	var maxScale Scaling
+	for _, id := range tiles {
+		if int(id.scale) >= len(mags) {
+			return fmt.Errorf("no tile spec for scale %d", id.scale)
+		}
+		tileSize := d.Levels[id.scale].TileSize.Mult(mags[id.scale]).(dvid.Point3d)
+		tileExt, err := dvid.GetTileExtents(id.tile, plane, tileSize)
+		if err != nil {
+			return err
+		}
+		if _, ok := intersectExtents(tileExt, tiled); !ok {
+			continue
+		}
+		if len(inBounds) == 0 {
+			extents = tileExt
+		} else {
+			extents.Extend(tileExt.MinPoint)
+			extents.Extend(tileExt.MaxPoint)
+		}
+		tileExtents = append(tileExtents, tileExt)
+		inBounds = append(inBounds, id)
+		if id.scale > maxScale {
+			maxScale = id.scale
+		}
+	}
+	if len(inBounds) == 0 {
+		return nil
+	}
+	tiles = inBounds
+
+	width, height, err := plane.GetSize2D(extents.MaxPoint.Sub(extents.MinPoint).AddScalar(1))
+	if err != nil {
+		return err
+	}
+	slice, err := dvid.NewOrthogSlice(plane, extents.MinPoint, dvid.Point2d{width, height})
+	if err != nil {
+		return err
+	}
+	voxels, err := src.NewVoxels(slice, nil)
+	if err != nil {
+		return err
+	}
+	if err := d.readSliceVoxels(src, v, plane, extents, tiled, voxels); err != nil {
+		return err
+	}
+
+	// Overwrite with changed blocks that may not have been committed when notified.
+	blockSize := src.BlockSize()
+	for index, data := range blocks {
+		if !extents.BlockWithin(blockSize.(dvid.Point3d), dvid.ChunkPoint3d(index)) {
+			continue
+		}
+		block := &storage.TKeyValue{K: imageblk.NewTKey(&index), V: data}
+		if err := voxels.ReadBlock(block, blockSize, 0); err != nil {
+			return err
+		}
+	}
+
+	for scale := Scaling(0); scale <= maxScale; scale++ {
+		img, err := voxels.GetImage2d()
+		if err != nil {
+			return err
+		}
+		magX, magY, err := plane.GetSize2D(mags[scale])
+		if err != nil {
+			return err
+		}
+		tileW, tileH, err := plane.GetSize2D(d.Levels[scale].TileSize)
+		if err != nil {
+			return err
+		}
+		for i, id := range tiles {
+			if id.scale != scale {
+				continue
+			}
+			x0, y0, err := plane.GetSize2D(tileExtents[i].MinPoint.Sub(extents.MinPoint))
+			if err != nil {
+				return err
+			}
+			x0, y0 = x0/magX, y0/magY
+			tile, err := img.SubImage(image.Rect(int(x0), int(y0), int(x0+tileW), int(y0+tileH)))
+			if err != nil {
+				return err
+			}
+			if err := outF(NewTileReq(id.tile, plane, id.scale), tile); err != nil {
+				return err
+			}
+		}
+		if scale < maxScale {
+			if err := voxels.DownRes(levelMag(d.Levels[scale])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reads the source voxels of the slice with the given extents, limited to the tiled bounds
+// and the source extents, into the voxels.  Voxels outside those bounds are left zero.
+func (d *Data) readSliceVoxels(src *imageblk.Data, v dvid.VersionID, plane dvid.DataShape, extents, tiled dvid.Extents3d, voxels *imageblk.Voxels) error {
+	readExt, ok := intersectExtents(extents, tiled)
+	if !ok {
+		return nil
+	}
+	srcMin, minOK := src.MinPoint.(dvid.Point3d)
+	srcMax, maxOK := src.MaxPoint.(dvid.Point3d)
+	if minOK && maxOK {
+		if readExt, ok = intersectExtents(readExt, dvid.Extents3d{MinPoint: srcMin, MaxPoint: srcMax}); !ok {
+			return nil
+		}
+	}
+	if readExt == extents {
+		return src.GetVoxels(v, voxels, "")
+	}
+
+	width, height, err := plane.GetSize2D(readExt.MaxPoint.Sub(readExt.MinPoint).AddScalar(1))
+	if err != nil {
+		return err
+	}
+	slice, err := dvid.NewOrthogSlice(plane, readExt.MinPoint, dvid.Point2d{width, height})
+	if err != nil {
+		return err
+	}
+	part, err := src.NewVoxels(slice, nil)
+	if err != nil {
+		return err
+	}
+	if err := src.GetVoxels(v, part, ""); err != nil {
+		return err
+	}
+	x0, y0, err := plane.GetSize2D(readExt.MinPoint.Sub(extents.MinPoint))
+	if err != nil {
+		return err
+	}
+	bytesPerVoxel := voxels.BytesPerVoxel()
+	rowBytes := width * bytesPerVoxel
+	data, partData := voxels.Data(), part.Data()
+	for y := int32(0); y < height; y++ {
+		i := (y0+y)*voxels.Stride() + x0*bytesPerVoxel
+		j := y * part.Stride()
+		copy(data[i:i+rowBytes], partData[j:j+rowBytes])
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+/*
+	This file supports conditional writes of keys using ETags derived from the hash of
+	their values, including an all-or-nothing batch compare-and-swap.
+*/
+
+package keyvalue
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// ETag returns the entity tag, a quoted content hash, for a key's value.
+func ETag(value []byte) string {
+	hash := sha256.Sum256(value)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// Condition is a precondition on the current value of a key using ETags as in the HTTP
+// If-Match and If-None-Match headers.  Each may be "*" or a comma-separated list of ETags.
+// An empty Condition always holds.
+type Condition struct {
+	IfMatch     string `json:"if_match,omitempty"`
+	IfNoneMatch string `json:"if_none_match,omitempty"`
+}
+
+// ConditionFromRequest returns the precondition given by the HTTP request headers.
+func ConditionFromRequest(r *http.Request) Condition {
+	return Condition{
+		IfMatch:     r.Header.Get("If-Match"),
+		IfNoneMatch: r.Header.Get("If-None-Match"),
+	}
+}
+
+// IsEmpty returns true if there is no precondition.
+func (c Condition) IsEmpty() bool {
+	return c.IfMatch == "" && c.IfNoneMatch == ""
+}
+
+// Holds returns true if the precondition is met by the current value of a key.
+func (c Condition) Holds(value []byte, found bool) bool {
+	var etag string
+	if found {
+		etag = ETag(value)
+	}
+	if c.IfMatch != "" && (!found || !etagListMatches(c.IfMatch, etag)) {
+		return false
+	}
+	if c.IfNoneMatch != "" && found && etagListMatches(c.IfNoneMatch, etag) {
+		return false
+	}
+	return true
+}
+
+// returns true if the ETag is in the list or the list is "*".  Weak ETags are compared
+// by their opaque tag since values are only compared by content.
+func etagListMatches(list, etag string) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// PreconditionError is returned when a conditional write is not done because the current
+// values of one or more keys do not meet the preconditions.
+type PreconditionError struct {
+	Failed []FailedKey `json:"failed"`
+}
+
+// FailedKey gives the current ETag, empty if the key has no value, of a key whose
+// precondition failed.
+type FailedKey struct {
+	Key  string `json:"key"`
+	ETag string `json:"etag"`
+}
+
+func (e PreconditionError) Error() string {
+	keys := make([]string, len(e.Failed))
+	for i, failed := range e.Failed {
+		keys[i] = failed.Key
+	}
+	return fmt.Sprintf("precondition failed for keys %q", keys)
+}
+
+// KeyWrite is one write of a batch compare-and-swap.  The key is deleted if Delete is true,
+// else set to Value.
+type KeyWrite struct {
+	Key    string `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+	Delete bool   `json:"delete,omitempty"`
+	Condition
+}
+
+// PutDataIf puts a key-value if the current value meets the condition, returning the new
+// ETag.  A PreconditionError is returned if the condition fails.
+func (d *Data) PutDataIf(ctx storage.Context, keyStr string, value []byte, cond Condition) (string, error) {
+	err := d.CompareAndSwap(ctx, []KeyWrite{{Key: keyStr, Value: value, Condition: cond}})
+	if err != nil {
+		return "", err
+	}
+	return ETag(value), nil
+}
+
+// DeleteDataIf deletes a key-value if the current value meets the condition.  A
+// PreconditionError is returned if the condition fails.
+func (d *Data) DeleteDataIf(ctx storage.Context, keyStr string, cond Condition) error {
+	return d.CompareAndSwap(ctx, []KeyWrite{{Key: keyStr, Delete: true, Condition: cond}})
+}
+
+// CompareAndSwap applies all writes only if every write's condition holds for the current
+// value of its key.  Otherwise no writes are done and a PreconditionError listing the keys
+// whose conditions failed is returned.  Conditional writes are serialized per data instance
+// so the values can't change between the checks and the writes, although unconditional
+// writes are not blocked.  All writes, along with their index and history updates, are
+// committed in one batch.
+func (d *Data) CompareAndSwap(ctx storage.Context, writes []KeyWrite) error {
+	db, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	batcher, ok := db.(storage.KeyValueBatcher)
+	if !ok {
+		return fmt.Errorf("compare-and-swap requires batch-enabled store, which %q is not", db)
+	}
+
+	d.condMu.Lock()
+	defer d.condMu.Unlock()
+	if len(d.IndexedFields) != 0 {
+		d.indexMu.Lock()
+		defer d.indexMu.Unlock()
+	}
+
+	var failed []FailedKey
+	for _, write := range writes {
+		if write.Condition.IsEmpty() {
+			continue
+		}
+		value, found, err := d.GetData(ctx, write.Key)
+		if err != nil {
+			return err
+		}
+		if !write.Condition.Holds(value, found) {
+			var etag string
+			if found {
+				etag = ETag(value)
+			}
+			failed = append(failed, FailedKey{Key: write.Key, ETag: etag})
+		}
+	}
+	if len(failed) != 0 {
+		return PreconditionError{Failed: failed}
+	}
+
+	// Values as of the earlier writes in the batch, needed to update the index.
+	values := make(map[string][]byte)
+	var historyKeys []string
+	history := make(map[string][]historyRecord)
+	batch := batcher.NewBatch(ctx)
+	for _, write := range writes {
+		tk, err := NewTKey(write.Key)
+		if err != nil {
+			return err
+		}
+		var value []byte
+		if write.Delete {
+			batch.Delete(tk)
+		} else {
+			value = write.Value
+			serialization, err := dvid.SerializeData(value, d.Compression(), d.Checksum())
+			if err != nil {
+				return fmt.Errorf("Unable to serialize data: %v\n", err)
+			}
+			batch.Put(tk, serialization)
+		}
+		if len(d.IndexedFields) != 0 {
+			oldValue, found := values[write.Key]
+			if !found {
+				if oldValue, _, err = d.GetData(ctx, write.Key); err != nil {
+					return err
+				}
+			}
+			deleted, added := d.indexChanges(write.Key, oldValue, value)
+			for _, itk := range deleted {
+				batch.Delete(itk)
+			}
+			for _, itk := range added {
+				batch.Put(itk, []byte{})
+			}
+			values[write.Key] = value
+		}
+		if _, found := history[write.Key]; !found {
+			historyKeys = append(historyKeys, write.Key)
+		}
+		history[write.Key] = append(history[write.Key], d.newHistoryRecord(ctx, value, write.Delete))
+	}
+	for _, keyStr := range historyKeys {
+		puts, deletes, err := d.historyChanges(ctx, db, keyStr, history[keyStr])
+		if err != nil {
+			return err
+		}
+		for _, tk := range deletes {
+			batch.Delete(tk)
+		}
+		for _, kv := range puts {
+			batch.Put(kv.K, kv.V)
+		}
+	}
+	return batch.Commit()
+}
@@ -0,0 +1,38 @@
+/*
+	This file supports finding the keys that differ between two versions.
+*/
+
+package keyvalue
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// KeyDiff describes a key whose value differs between two versions.
+type KeyDiff struct {
+	Key    string              `json:"key"`
+	Change datastore.KeyChange `json:"change"`
+}
+
+// WriteDiff writes JSON lines for each key whose value differs from version vA to version vB,
+// returning the number of keys written.
+func (d *Data) WriteDiff(w io.Writer, vA, vB dvid.VersionID) (numKeys int, err error) {
+	if !d.Versioned() {
+		return 0, nil // all versions share the same keys
+	}
+	enc := json.NewEncoder(w)
+	err = datastore.DiffVersions(d, vA, vB, storage.MinTKey(keyStandard), storage.MaxTKey(keyStandard), func(diff datastore.KeyDiff) error {
+		key, err := DecodeTKey(diff.TKey)
+		if err != nil {
+			return err
+		}
+		numKeys++
+		return enc.Encode(KeyDiff{Key: key, Change: diff.Change})
+	})
+	return
+}
@@ -0,0 +1,356 @@
+/*
+	This file supports recording the writes to each key so a key's value can be read
+	as of an earlier mutation ID or time.  Since each record holds a copy of the value,
+	full history is only recorded for instances created with the "History" setting, and
+	only the latest writes to a key in each version are kept.  Other instances keep a
+	record of only the latest write to a key in each version, without its value, so the
+	mutation ID, time, and user of the write are known.
+*/
+
+package keyvalue
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// DefaultHistoryMax is the default number of writes to a key recorded in each version.
+const DefaultHistoryMax = 100
+
+// historyRecord describes a write to a key.
+type historyRecord struct {
+	MutID   uint64
+	Time    time.Time
+	User    string
+	Deleted bool
+	Pruned  bool // true if earlier records of the key were pruned
+	NoValue bool // true if the value is not recorded, so it is only known for the latest write
+	Value   []byte
+}
+
+// flags of an encoded history record.
+const (
+	historyDeleted uint8 = 1 << iota
+	historyHasUser
+	historyPruned
+	historyNoValue
+)
+
+// encodes the record, except for the mutation id which is part of its key.  Records
+// written before users were recorded have no user flag or user.
+func (rec historyRecord) encode() []byte {
+	var userBytes int
+	if rec.User != "" {
+		userBytes = 2 + len(rec.User)
+	}
+	buf := make([]byte, 9+userBytes+len(rec.Value))
+	binary.LittleEndian.PutUint64(buf[0:8], uint64(rec.Time.UnixNano()))
+	if rec.Deleted {
+		buf[8] |= historyDeleted
+	}
+	if rec.Pruned {
+		buf[8] |= historyPruned
+	}
+	if rec.NoValue {
+		buf[8] |= historyNoValue
+	}
+	if rec.User != "" {
+		buf[8] |= historyHasUser
+		binary.LittleEndian.PutUint16(buf[9:11], uint16(len(rec.User)))
+		copy(buf[11:], rec.User)
+	}
+	copy(buf[9+userBytes:], rec.Value)
+	return buf
+}
+
+func (rec *historyRecord) decode(buf []byte) error {
+	if len(buf) < 9 {
+		return fmt.Errorf("bad keyvalue history record of %d bytes", len(buf))
+	}
+	rec.Time = time.Unix(0, int64(binary.LittleEndian.Uint64(buf[0:8])))
+	rec.Deleted = buf[8]&historyDeleted != 0
+	rec.Pruned = buf[8]&historyPruned != 0
+	rec.NoValue = buf[8]&historyNoValue != 0
+	flags := buf[8]
+	buf = buf[9:]
+	rec.User = ""
+	if flags&historyHasUser != 0 {
+		if len(buf) < 2 {
+			return fmt.Errorf("bad keyvalue history record user")
+		}
+		userLen := int(binary.LittleEndian.Uint16(buf[0:2]))
+		if len(buf) < 2+userLen {
+			return fmt.Errorf("bad keyvalue history record user of %d bytes", userLen)
+		}
+		rec.User = string(buf[2 : 2+userLen])
+		buf = buf[2+userLen:]
+	}
+	rec.Value = buf
+	return nil
+}
+
+// returns the maximum number of writes recorded per key in a version.  Only the latest
+// write is recorded without the "History" setting.
+func (d *Data) historyMax() int {
+	if !d.History {
+		return 1
+	}
+	if d.HistoryMax > 0 {
+		return d.HistoryMax
+	}
+	return DefaultHistoryMax
+}
+
+// returns the record of a put or delete of a key with a new mutation id.  The value is
+// only recorded with the "History" setting.
+func (d *Data) newHistoryRecord(ctx storage.Context, value []byte, deleted bool) historyRecord {
+	rec := historyRecord{
+		MutID:   d.NewMutationID(),
+		Time:    time.Now(),
+		Deleted: deleted,
+		NoValue: !d.History && !deleted,
+	}
+	if !rec.NoValue {
+		rec.Value = value
+	}
+	if vctx, ok := ctx.(*datastore.VersionedCtx); ok {
+		rec.User = vctx.User
+		if len(rec.User) > math.MaxUint16 {
+			rec.User = rec.User[:math.MaxUint16]
+		}
+	}
+	return rec
+}
+
+// records a put or delete of a key with a new mutation id.
+func (d *Data) recordHistory(ctx storage.Context, db storage.OrderedKeyValueDB, keyStr string, value []byte, deleted bool) error {
+	puts, deletes, err := d.historyChanges(ctx, db, keyStr, []historyRecord{d.newHistoryRecord(ctx, value, deleted)})
+	if err != nil {
+		return err
+	}
+	for _, tk := range deletes {
+		if err := db.Delete(ctx, tk); err != nil {
+			return err
+		}
+	}
+	for _, kv := range puts {
+		if err := db.Put(ctx, kv.K, kv.V); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the history key-values to put and keys to delete when adding the given records,
+// ordered by mutation id, to a key's history.  The oldest records beyond the maximum kept
+// are deleted or never put, and the oldest remaining record is marked so reads before it
+// fail instead of finding no value.
+func (d *Data) historyChanges(ctx storage.Context, db storage.OrderedKeyValueDB, keyStr string, recs []historyRecord) (puts []storage.TKeyValue, deletes []storage.TKey, err error) {
+	var tks []storage.TKey
+	if tks, err = db.KeysInRange(ctx, NewHistoryTKey(keyStr, 0), NewHistoryTKey(keyStr, math.MaxUint64)); err != nil {
+		return
+	}
+	excess := len(tks) + len(recs) - d.historyMax()
+	switch {
+	case excess <= 0:
+	case excess < len(tks):
+		deletes = tks[:excess]
+		var data []byte
+		if data, err = db.Get(ctx, tks[excess]); err != nil {
+			return
+		}
+		if data == nil {
+			err = fmt.Errorf("missing history record of key %q", keyStr)
+			return
+		}
+		var rec historyRecord
+		if rec, err = decodeHistoryKV(keyStr, tks[excess], data); err != nil {
+			return
+		}
+		if !rec.Pruned {
+			rec.Pruned = true
+			recs = append([]historyRecord{rec}, recs...)
+		}
+	default:
+		deletes = tks
+		recs = recs[excess-len(tks):]
+		recs[0].Pruned = true
+	}
+	puts = make([]storage.TKeyValue, len(recs))
+	for i, rec := range recs {
+		serialization, err := dvid.SerializeData(rec.encode(), d.Compression(), d.Checksum())
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to serialize history of key %q: %v", keyStr, err)
+		}
+		puts[i] = storage.TKeyValue{K: NewHistoryTKey(keyStr, rec.MutID), V: serialization}
+	}
+	return
+}
+
+func decodeHistoryKV(keyStr string, tk storage.TKey, v []byte) (rec historyRecord, err error) {
+	if _, rec.MutID, err = DecodeHistoryTKey(tk); err != nil {
+		return
+	}
+	data, _, err := dvid.DeserializeData(v, true)
+	if err != nil {
+		err = fmt.Errorf("unable to deserialize history of key %q: %v", keyStr, err)
+		return
+	}
+	err = rec.decode(data)
+	return
+}
+
+// returns the recorded writes of a key in order of mutation id.
+func (d *Data) getHistory(ctx storage.Context, db storage.OrderedKeyValueDB, keyStr string) ([]historyRecord, error) {
+	kvs, err := db.GetRange(ctx, NewHistoryTKey(keyStr, 0), NewHistoryTKey(keyStr, math.MaxUint64))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]historyRecord, len(kvs))
+	for i, kv := range kvs {
+		if records[i], err = decodeHistoryKV(keyStr, kv.K, kv.V); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// GetDataAsOf gets the value of a key as of a mutation ID or time using the recorded
+// history of the key.  If no history was recorded for the key, the store must be able
+// to supply the time of the last write, which must precede the requested time.
+func (d *Data) GetDataAsOf(ctx storage.Context, keyStr string, asOf dvid.AsOf) ([]byte, bool, error) {
+	db, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, false, err
+	}
+	records, err := d.getHistory(ctx, db, keyStr)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(records) == 0 {
+		return d.getDataAsOfTimestamp(ctx, db, keyStr, asOf)
+	}
+	last := -1
+	for i, rec := range records {
+		if asOf.Time.IsZero() {
+			if rec.MutID > asOf.MutID {
+				continue
+			}
+		} else if rec.Time.After(asOf.Time) {
+			continue
+		}
+		last = i
+	}
+	if last < 0 && records[0].Pruned {
+		return nil, false, fmt.Errorf("history of key %q before mutation %d was pruned so unable to get value as of %s", keyStr, records[0].MutID, asOf)
+	}
+	if last < 0 || records[last].Deleted {
+		return nil, false, nil
+	}
+	if records[last].NoValue {
+		if last != len(records)-1 {
+			return nil, false, fmt.Errorf("value of key %q as of %s was not recorded", keyStr, asOf)
+		}
+		return d.GetData(ctx, keyStr)
+	}
+	return records[last].Value, true, nil
+}
+
+// gets the current value of a key if the store's timestamp shows it was last written
+// before the requested time.
+func (d *Data) getDataAsOfTimestamp(ctx storage.Context, db storage.OrderedKeyValueDB, keyStr string, asOf dvid.AsOf) ([]byte, bool, error) {
+	tsGetter, ok := db.(storage.KeyValueTimestampGetter)
+	if !ok || asOf.Time.IsZero() {
+		return nil, false, fmt.Errorf("no history recorded for key %q so unable to get value as of %s", keyStr, asOf)
+	}
+	tk, err := NewTKey(keyStr)
+	if err != nil {
+		return nil, false, err
+	}
+	data, modTime, err := tsGetter.GetWithTimestamp(ctx, tk)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error in retrieving key '%s': %v", keyStr, err)
+	}
+	if data == nil {
+		return nil, false, nil
+	}
+	if modTime.After(asOf.Time) {
+		return nil, false, fmt.Errorf("key %q was modified at %s, after %s, and has no recorded history", keyStr, modTime.Format(time.RFC3339Nano), asOf)
+	}
+	value, _, err := dvid.DeserializeData(data, true)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to deserialize data for key '%s': %v\n", keyStr, err)
+	}
+	return value, true, nil
+}
+
+// KeyVersionHistory describes the value of a key in a version along the ancestry where it
+// was written or deleted.  The mutation ID, timestamp, and user are of the last write in
+// that version and are omitted if the write was not recorded.
+type KeyVersionHistory struct {
+	UUID      dvid.UUID `json:"uuid"`
+	Deleted   bool      `json:"deleted"`
+	Bytes     int       `json:"bytes"`
+	ETag      string    `json:"etag,omitempty"`
+	MutID     uint64    `json:"mutid,omitempty"`
+	Timestamp string    `json:"timestamp,omitempty"`
+	User      string    `json:"user,omitempty"`
+}
+
+// GetKeyHistory returns the changes of a key in the versions along the ancestry of the
+// given version, ordered from the root version.
+func (d *Data) GetKeyHistory(v dvid.VersionID, keyStr string) ([]KeyVersionHistory, error) {
+	tk, err := NewTKey(keyStr)
+	if err != nil {
+		return nil, err
+	}
+	keyVersions, err := datastore.GetKeyVersions(d, v, tk, tk)
+	if err != nil {
+		return nil, err
+	}
+	recVersions, err := datastore.GetKeyVersions(d, v, NewHistoryTKey(keyStr, 0), NewHistoryTKey(keyStr, math.MaxUint64))
+	if err != nil {
+		return nil, err
+	}
+	// history records are in mutation id order so keep the last one in each version.
+	lastRecords := make(map[dvid.VersionID]historyRecord)
+	for _, recVersion := range recVersions {
+		if recVersion.Tombstone {
+			continue
+		}
+		rec, err := decodeHistoryKV(keyStr, recVersion.TKey, recVersion.Value)
+		if err != nil {
+			return nil, err
+		}
+		lastRecords[recVersion.Version] = rec
+	}
+
+	history := make([]KeyVersionHistory, len(keyVersions))
+	for i, keyVersion := range keyVersions {
+		if history[i].UUID, err = datastore.UUIDFromVersion(keyVersion.Version); err != nil {
+			return nil, err
+		}
+		if keyVersion.Tombstone {
+			history[i].Deleted = true
+		} else {
+			value, _, err := dvid.DeserializeData(keyVersion.Value, true)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to deserialize data for key '%s': %v\n", keyStr, err)
+			}
+			history[i].Bytes = len(value)
+			history[i].ETag = ETag(value)
+		}
+		if rec, found := lastRecords[keyVersion.Version]; found {
+			history[i].MutID = rec.MutID
+			history[i].Timestamp = rec.Time.Format(time.RFC3339Nano)
+			history[i].User = rec.User
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,203 @@
+/*
+	This file supports secondary indexes over the values of declared fields in JSON values
+	so keys can be queried by field value.
+*/
+
+package keyvalue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// IndexedFields parses a comma-separated list of JSON fields to be indexed.  Nested fields
+// are given by dot-separated paths, e.g., "meta.status".
+func IndexedFields(s string) ([]string, error) {
+	var fields []string
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if strings.IndexByte(field, 0) >= 0 {
+			return nil, fmt.Errorf("indexed field %q can't contain a 0 byte", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// isIndexedField returns true if the field is declared as indexed.
+func (d *Data) isIndexedField(field string) bool {
+	for _, indexed := range d.IndexedFields {
+		if field == indexed {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the values of a field in a JSON value, where a dot-separated field descends into
+// nested objects.  Scalar values are returned as strings, with numbers and booleans as
+// their JSON text, and each scalar of an array is a separate value.  Values that are not
+// JSON or don't have the field return no values.
+func jsonFieldValues(value []byte, field string) []string {
+	var obj interface{}
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil
+	}
+	for _, name := range strings.Split(field, ".") {
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if obj, ok = m[name]; !ok {
+			return nil
+		}
+	}
+	var values []string
+	addValue := func(v interface{}) {
+		var s string
+		switch t := v.(type) {
+		case string:
+			s = t
+		case json.Number:
+			s = t.String()
+		case bool:
+			if t {
+				s = "true"
+			} else {
+				s = "false"
+			}
+		default:
+			return
+		}
+		if strings.IndexByte(s, 0) < 0 {
+			values = append(values, s)
+		}
+	}
+	if array, ok := obj.([]interface{}); ok {
+		for _, elem := range array {
+			addValue(elem)
+		}
+	} else {
+		addValue(obj)
+	}
+	return values
+}
+
+// returns the index keys for a key's value.
+func (d *Data) indexTKeys(keyStr string, value []byte) map[string]storage.TKey {
+	tkeys := make(map[string]storage.TKey)
+	if value == nil {
+		return tkeys
+	}
+	for _, field := range d.IndexedFields {
+		for _, fieldValue := range jsonFieldValues(value, field) {
+			tk := NewIndexTKey(field, fieldValue, keyStr)
+			tkeys[string(tk)] = tk
+		}
+	}
+	return tkeys
+}
+
+// returns the index keys to delete and add for a key whose value changed from oldValue to
+// newValue, where a nil value means the key has no value.
+func (d *Data) indexChanges(keyStr string, oldValue, newValue []byte) (deleted, added []storage.TKey) {
+	oldTKeys := d.indexTKeys(keyStr, oldValue)
+	newTKeys := d.indexTKeys(keyStr, newValue)
+	for s, tk := range oldTKeys {
+		if _, found := newTKeys[s]; !found {
+			deleted = append(deleted, tk)
+		}
+	}
+	for s, tk := range newTKeys {
+		if _, found := oldTKeys[s]; !found {
+			added = append(added, tk)
+		}
+	}
+	return
+}
+
+// updates the index entries of a key whose value changed from oldValue to newValue, where
+// a nil value means the key has no value.
+func (d *Data) updateIndex(ctx storage.Context, db storage.OrderedKeyValueDB, keyStr string, oldValue, newValue []byte) error {
+	deleted, added := d.indexChanges(keyStr, oldValue, newValue)
+	for _, tk := range deleted {
+		if err := db.Delete(ctx, tk); err != nil {
+			return err
+		}
+	}
+	for _, tk := range added {
+		if err := db.Put(ctx, tk, []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryIndex returns the sorted keys whose JSON values match all of the given indexed field
+// values.  A field with more than one value matches keys with any of the values.
+func (d *Data) QueryIndex(ctx storage.Context, query map[string][]string) ([]string, error) {
+	if len(query) == 0 {
+		return nil, fmt.Errorf("query requires at least one indexed field value")
+	}
+	db, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, err
+	}
+	var matches map[string]struct{}
+	for field, values := range query {
+		if !d.isIndexedField(field) {
+			return nil, fmt.Errorf("field %q is not indexed for data %q", field, d.DataName())
+		}
+		fieldMatches := make(map[string]struct{})
+		for _, value := range values {
+			begTKey, endTKey := indexRange(field, value)
+			tkeys, err := db.KeysInRange(ctx, begTKey, endTKey)
+			if err != nil {
+				return nil, err
+			}
+			for _, tk := range tkeys {
+				_, _, keyStr, err := DecodeIndexTKey(tk)
+				if err != nil {
+					return nil, err
+				}
+				if matches == nil {
+					fieldMatches[keyStr] = struct{}{}
+				} else if _, found := matches[keyStr]; found {
+					fieldMatches[keyStr] = struct{}{}
+				}
+			}
+		}
+		matches = fieldMatches
+	}
+	keys := make([]string, 0, len(matches))
+	for keyStr := range matches {
+		keys = append(keys, keyStr)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// returns the indexed field values of a /query request, ignoring the query-string options
+// used by all requests.
+func indexQuery(params url.Values) map[string][]string {
+	query := make(map[string][]string, len(params))
+	for field, values := range params {
+		if field == "u" || field == "app" {
+			continue
+		}
+		query[field] = values
+	}
+	return query
+}
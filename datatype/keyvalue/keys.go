@@ -5,6 +5,8 @@
 package keyvalue
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 
 	"github.com/janelia-flyem/dvid/datastore"
@@ -20,13 +22,25 @@ const (
 
 	// the byte id for a standard key of a keyvalue
 	keyStandard = 177
+
+	// key = key + 0 + mutation id.  value = serialized history record of a write.
+	keyHistory = 178
+
+	// key = field + 0 + field value + 0 + key.  value = empty.  Indexes keys by JSON field values.
+	keyIndex = 179
 )
 
 // DescribeTKeyClass returns a string explanation of what a particular TKeyClass
 // is used for.  Implements the datastore.TKeyClassDescriber interface.
 func (d *Data) DescribeTKeyClass(tkc storage.TKeyClass) string {
-	if tkc == keyStandard {
+	switch tkc {
+	case keyStandard:
 		return "keyvalue generic key"
+	case keyHistory:
+		return "keyvalue key history"
+	case keyIndex:
+		return "keyvalue JSON field index"
+	default:
 	}
 	return "unknown keyvalue key"
 }
@@ -51,3 +65,65 @@ func DecodeTKey(tk storage.TKey) (string, error) {
 	}
 	return string(ibytes[:sz]), nil
 }
+
+// NewHistoryTKey returns the key for the history record of a key's write with given mutation id.
+func NewHistoryTKey(key string, mutID uint64) storage.TKey {
+	buf := make([]byte, len(key)+9)
+	copy(buf, key)
+	binary.BigEndian.PutUint64(buf[len(key)+1:], mutID)
+	return storage.NewTKey(keyHistory, buf)
+}
+
+// DecodeHistoryTKey returns the key and mutation id of a history record key.
+func DecodeHistoryTKey(tk storage.TKey) (key string, mutID uint64, err error) {
+	ibytes, err := tk.ClassBytes(keyHistory)
+	if err != nil {
+		return
+	}
+	sz := len(ibytes) - 9
+	if sz <= 0 {
+		err = fmt.Errorf("bad keyvalue history key of %d bytes", len(ibytes))
+		return
+	}
+	if ibytes[sz] != 0 {
+		err = fmt.Errorf("expected 0 byte ending key of keyvalue history key, got %d", ibytes[sz])
+		return
+	}
+	return string(ibytes[:sz]), binary.BigEndian.Uint64(ibytes[sz+1:]), nil
+}
+
+// NewIndexTKey returns the key for the index entry of a key with the given value of an
+// indexed JSON field.
+func NewIndexTKey(field, value, key string) storage.TKey {
+	buf := make([]byte, len(field)+len(value)+len(key)+2)
+	n := copy(buf, field)
+	n += copy(buf[n+1:], value) + 1
+	copy(buf[n+1:], key)
+	return storage.NewTKey(keyIndex, buf)
+}
+
+// indexRange returns the range of index keys for all keys with the given field value.  The
+// range begins after the empty key since versioned range queries group the keys following
+// a beginning key that is their prefix with the versions of the beginning key.
+func indexRange(field, value string) (begTKey, endTKey storage.TKey) {
+	begTKey = NewIndexTKey(field, value, "\x00")
+	buf := make([]byte, len(field)+len(value)+2)
+	n := copy(buf, field)
+	n += copy(buf[n+1:], value) + 1
+	buf[n] = 1
+	return begTKey, storage.NewTKey(keyIndex, buf)
+}
+
+// DecodeIndexTKey returns the field, field value, and key of an index entry key.
+func DecodeIndexTKey(tk storage.TKey) (field, value, key string, err error) {
+	ibytes, err := tk.ClassBytes(keyIndex)
+	if err != nil {
+		return
+	}
+	parts := bytes.SplitN(ibytes, []byte{0}, 3)
+	if len(parts) != 3 {
+		err = fmt.Errorf("bad keyvalue index key of %d bytes", len(ibytes))
+		return
+	}
+	return string(parts[0]), string(parts[1]), string(parts[2]), nil
+}
@@ -9,9 +9,12 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
@@ -63,6 +66,15 @@ $ dvid repo <UUID> new keyvalue <data name> <settings...>
 				   not differentiate between versions in the same repo.  Note that unlike
 				   versioned data, distribution (push/pull) of unversioned data is not defined 
 				   at this time.
+	IndexedFields  Comma-separated list of fields of JSON values to index for the /query
+				   endpoint, e.g., "IndexedFields=status,owner,meta.celltype".  Nested fields
+				   are given by dot-separated paths.
+	History        Set to "true" or "1" to record each write to a key so values can be read
+				   as of an earlier mutation ID or time.  Each record holds a copy of the value.
+				   Without it, only the latest write to a key in each version is recorded,
+				   without its value.
+	HistoryMax     Maximum number of writes recorded per key in each version when "History"
+				   is set, after which the oldest are pruned.  Default is %d.
 
 $ dvid -stdin node <UUID> <data name> put <key> < data
 
@@ -98,6 +110,42 @@ POST <api URL>/node/<UUID>/<data name>/info
 	UUID          Hexadecimal string with enough characters to uniquely identify a version node.
 	data name     Name of keyvalue data instance.
 
+POST <api URL>/node/<UUID>/<data name>/sync?<options>
+
+    Establishes labelmap instances whose changes invalidate keys.  Expects JSON to be POSTed
+    with the following format:
+
+    { "sync": "segmentation" }
+
+	To delete syncs, pass an empty string of names with query string "replace=true":
+
+	{ "sync": "" }
+
+    When synced, any key beginning with "<label>_", e.g., "23_mesh_s0.ngmesh", is deleted
+    when the label is changed by a merge, cleave, split, or supervoxel split in the labelmap.  
+    This allows a keyvalue instance to serve as a cache of per-label data like the meshes 
+    generated by the labelmap /mesh endpoint.  Direct writes of label blocks delete the keys
+    of the supervoxels in the written blocks and of the bodies containing them.
+
+    The keyvalue data type only accepts syncs to labelmap instances.
+
+    GET Query-string Options:
+
+    replace    Set to "true" if you want passed syncs to replace and not be appended to current syncs.
+			   Default operation is false.
+
+GET  <api URL>/node/<UUID>/<data name>/diff/<UUID B>
+
+	Streams the keys that differ from version <UUID> to version <UUID B>, which must be in
+	the same repo, as JSON lines:
+
+	{"key": "mykey", "change": "added"}
+	{"key": "otherkey", "change": "modified"}
+	{"key": "oldkey", "change": "deleted"}
+
+	A key is "added" if it only has a value in <UUID B>, "deleted" if it only has a value in
+	<UUID>, and "modified" if the values differ.
+
 GET  <api URL>/node/<UUID>/<data name>/keys
 
 	Returns all keys for this data instance in JSON format:
@@ -117,6 +165,19 @@ GET  <api URL>/node/<UUID>/<data name>/keyrange/<key1>/<key2>
 	key1          Lexicographically lowest alphanumeric key in range.
 	key2          Lexicographically highest alphanumeric key in range.
 
+GET  <api URL>/node/<UUID>/<data name>/query?<field>=<value>[&<field2>=<value2>...]
+
+	Returns the keys, in JSON format, whose JSON values have the given values for fields
+	declared in the "IndexedFields" setting when the instance was created:
+
+	[key1, key2, ...]
+
+	For example, "query?status=Traced&owner=jdoe" returns the keys of all JSON values with
+	a "status" of "Traced" and an "owner" of "jdoe".  A field given more than once matches
+	any of its values.  Numbers and booleans are matched by their JSON text, e.g., "true",
+	and an array matches each of its elements.  The index is updated on every POST and
+	DELETE of a key and, like key lookups, reflects the values visible in the given version.
+
 GET  <api URL>/node/<UUID>/<data name>/key/<key>
 POST <api URL>/node/<UUID>/<data name>/key/<key>
 DEL  <api URL>/node/<UUID>/<data name>/key/<key> 
@@ -144,6 +205,26 @@ HEAD <api URL>/node/<UUID>/<data name>/key/<key>
 	data name     Name of keyvalue data instance.
 	key           An alphanumeric key.
 	
+	GET Query-string Options:
+
+	as_of         Returns the value as of a mutation ID or RFC3339 timestamp, e.g.,
+	                "as_of=2024-03-01T12:00:00Z".  If the instance was created with the
+	                "History" setting, each POST or DELETE of a key is recorded with a new
+	                mutation ID and time so earlier values can be reconstructed, back to the
+	                oldest write kept by "HistoryMax".  Otherwise only the latest write is
+	                recorded, so values are only known as of that write or later.  Keys without
+	                recorded writes can only be read as of a timestamp if the store provides
+	                modification times.
+
+	GET and POST return the value's ETag, a quoted hash of its content, in the "ETag" header.
+	A GET with an "If-None-Match" header listing the current ETag returns 304 (Not Modified).
+
+	POST and DELETE are conditional if an "If-Match" or "If-None-Match" header is given with a
+	comma-separated list of ETags or "*".  "If-Match" requires the key to have a value with a
+	listed ETag, and "If-None-Match" requires the key to not have such a value, so
+	"If-None-Match: *" only creates a new key.  If the condition fails, nothing is written
+	and 412 (Precondition Failed) is returned.
+	
 	POSTs will be logged as a Kafka JSON message with the following format:
 	{ 
 		"Action": "postkv",
@@ -152,8 +233,33 @@ HEAD <api URL>/node/<UUID>/<data name>/key/<key>
 		"UUID": <UUID on which POST was done>
 	}
 
+	DELETEs will be logged as a Kafka JSON message with the following format:
+	{ 
+		"Action": "delkv",
+		"Key": <key>,
+		"UUID": <UUID on which DELETE was done>
+	}
+
+GET  <api URL>/node/<UUID>/<data name>/key/<key>/history
+
+	Returns the changes of the key in the versions along the ancestry of <UUID>, ordered
+	from the root version, including versions where the key was deleted:
+
+	[
+		{"uuid": "3f8c...", "deleted": false, "bytes": 12, "etag": "\"<etag>\"", "mutid": 3,
+		 "timestamp": "2024-03-01T12:00:00.123456789-05:00", "user": "jdoe"},
+		{"uuid": "8a2b...", "deleted": true, "bytes": 0, "mutid": 9,
+		 "timestamp": "2024-03-02T09:30:00.123456789-05:00", "user": "asmith"}
+	]
+
+	The "etag" is the ETag of the value at the end of that version.  The mutation ID,
+	timestamp, and user are those of the last write of the key in that version, where the
+	user is given by the "u=<user>" query string of the POST or DELETE.  They are recorded
+	whether or not the instance has the "History" setting, and are omitted if the write was
+	made before writes were recorded.
+
 GET <api URL>/node/<UUID>/<data name>/keyvalues[?jsontar=true]
-POST <api URL>/node/<UUID>/<data name>/keyvalues
+POST <api URL>/node/<UUID>/<data name>/keyvalues[?cas=true]
 
 	Allows batch query or ingest of data. 
 
@@ -195,6 +301,31 @@ POST <api URL>/node/<UUID>/<data name>/keyvalues
 
 	jsontar		If set to any value for GET, query body must be JSON array of string keys
 				and the returned data will be a tarfile with keys as file names.
+	as_of		Returns values as of a mutation ID or RFC3339 timestamp as in GET /key.
+
+	POST Query-string Options:
+
+	cas			If "true", the POST is an all-or-nothing compare-and-swap and the query body
+				must be a JSON array of writes, each with optional preconditions on the key's
+				current value as in the "If-Match" and "If-None-Match" headers of POST /key:
+
+				[
+					{"key": "a", "value": "<base64 value>", "if_match": "\"<etag>\""},
+					{"key": "b", "value": "<base64 value>", "if_none_match": "*"},
+					{"key": "c", "delete": true, "if_match": "*"}
+				]
+
+				If all preconditions hold, all writes are applied and the new ETags are
+				returned, with deleted keys having an empty ETag:
+
+				{"etags": {"a": "\"<etag>\"", "b": "\"<etag>\"", "c": ""}}
+
+				Writes are logged as Kafka JSON messages like those of POST and DELETE /key.
+
+				Otherwise nothing is written and 412 (Precondition Failed) is returned with
+				the current ETags of the failed keys:
+
+				{"failed": [{"key": "b", "etag": "\"<etag>\""}]}
 `
 
 func init() {
@@ -232,11 +363,30 @@ func (dtype *Type) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.
 	if err != nil {
 		return nil, err
 	}
-	return &Data{basedata}, nil
+	var props Properties
+	fields, found, err := c.GetString("IndexedFields")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if props.IndexedFields, err = IndexedFields(fields); err != nil {
+			return nil, err
+		}
+	}
+	if props.History, _, err = c.GetBool("History"); err != nil {
+		return nil, err
+	}
+	if props.HistoryMax, found, err = c.GetInt("HistoryMax"); err != nil {
+		return nil, err
+	}
+	if found && props.HistoryMax <= 0 {
+		return nil, fmt.Errorf("HistoryMax must be positive, got %d", props.HistoryMax)
+	}
+	return &Data{Data: basedata, Properties: props}, nil
 }
 
 func (dtype *Type) Help() string {
-	return fmt.Sprintf(helpMessage)
+	return fmt.Sprintf(helpMessage, DefaultHistoryMax)
 }
 
 // GetByUUIDName returns a pointer to labelblk data given a UUID and data name.
@@ -252,25 +402,52 @@ func GetByUUIDName(uuid dvid.UUID, name dvid.InstanceName) (*Data, error) {
 	return data, nil
 }
 
-// Data embeds the datastore's Data and extends it with keyvalue properties (none for now).
+// Properties are additional properties for keyvalue data instances.
+type Properties struct {
+	// IndexedFields are the fields of JSON values, with dot-separated paths for nested
+	// fields, whose values are indexed for queries.
+	IndexedFields []string
+
+	// History is true if each write to a key is recorded so values can be read as of an
+	// earlier mutation ID or time.
+	History bool
+
+	// HistoryMax is the maximum number of writes recorded per key in a version.  If zero,
+	// DefaultHistoryMax is used.
+	HistoryMax int
+}
+
+// Data embeds the datastore's Data and extends it with keyvalue properties.
 type Data struct {
 	*datastore.Data
+	datastore.Updater
+	Properties
+
+	// channels for sync events from labelmap instances.
+	syncCh   chan datastore.SyncMessage
+	syncDone chan *sync.WaitGroup
+
+	// serializes conditional writes so preconditions are checked atomically with writes.
+	condMu sync.Mutex
+
+	// serializes writes to keys when fields are indexed so the index matches the values.
+	indexMu sync.Mutex
 }
 
 func (d *Data) Equals(d2 *Data) bool {
 	if !d.Data.Equals(d2.Data) {
 		return false
 	}
-	return true
+	return reflect.DeepEqual(d.Properties, d2.Properties)
 }
 
 func (d *Data) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Base     *datastore.Data
-		Extended struct{}
+		Extended Properties
 	}{
 		d.Data,
-		struct{}{},
+		d.Properties,
 	})
 }
 
@@ -280,6 +457,10 @@ func (d *Data) GobDecode(b []byte) error {
 	if err := dec.Decode(&(d.Data)); err != nil {
 		return err
 	}
+	// Instances stored before properties were added only have the base data.
+	if err := dec.Decode(&(d.Properties)); err != nil && err != io.EOF {
+		return err
+	}
 	return nil
 }
 
@@ -289,6 +470,9 @@ func (d *Data) GobEncode() ([]byte, error) {
 	if err := enc.Encode(d.Data); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(d.Properties); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -399,7 +583,23 @@ func (d *Data) PutData(ctx storage.Context, keyStr string, value []byte) error {
 	if err != nil {
 		return err
 	}
-	return db.Put(ctx, tk, serialization)
+	var oldValue []byte
+	if len(d.IndexedFields) != 0 {
+		d.indexMu.Lock()
+		defer d.indexMu.Unlock()
+		if oldValue, _, err = d.GetData(ctx, keyStr); err != nil {
+			return err
+		}
+	}
+	if err := db.Put(ctx, tk, serialization); err != nil {
+		return err
+	}
+	if len(d.IndexedFields) != 0 {
+		if err := d.updateIndex(ctx, db, keyStr, oldValue, value); err != nil {
+			return err
+		}
+	}
+	return d.recordHistory(ctx, db, keyStr, value, false)
 }
 
 // DeleteData deletes a key-value pair
@@ -412,7 +612,23 @@ func (d *Data) DeleteData(ctx storage.Context, keyStr string) error {
 	if err != nil {
 		return err
 	}
-	return db.Delete(ctx, tk)
+	var oldValue []byte
+	if len(d.IndexedFields) != 0 {
+		d.indexMu.Lock()
+		defer d.indexMu.Unlock()
+		if oldValue, _, err = d.GetData(ctx, keyStr); err != nil {
+			return err
+		}
+	}
+	if err := db.Delete(ctx, tk); err != nil {
+		return err
+	}
+	if len(d.IndexedFields) != 0 {
+		if err := d.updateIndex(ctx, db, keyStr, oldValue, nil); err != nil {
+			return err
+		}
+	}
+	return d.recordHistory(ctx, db, keyStr, nil, true)
 }
 
 // put handles a PUT command-line request.
@@ -461,7 +677,7 @@ func (d *Data) JSONString() (jsonStr string, err error) {
 // --- DataService interface ---
 
 func (d *Data) Help() string {
-	return fmt.Sprintf(helpMessage)
+	return fmt.Sprintf(helpMessage, DefaultHistoryMax)
 }
 
 // DoRPC acts as a switchboard for RPC commands.
@@ -494,6 +710,11 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 	var comment string
 	action := strings.ToLower(r.Method)
 
+	// Add user to context if provided
+	if user := r.URL.Query().Get("u"); user != "" {
+		ctx.User = user
+	}
+
 	switch parts[3] {
 	case "help":
 		w.Header().Set("Content-Type", "text/plain")
@@ -510,6 +731,40 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		fmt.Fprintf(w, jsonStr)
 		return
 
+	case "sync":
+		if action != "post" {
+			server.BadRequest(w, r, "Only POST allowed to sync endpoint")
+			return
+		}
+		replace := r.URL.Query().Get("replace") == "true"
+		if err := datastore.SetSyncByJSON(d, uuid, replace, r.Body); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		comment = "HTTP POST sync"
+
+	case "diff":
+		if action != "get" {
+			server.BadRequest(w, r, "Only GET allowed to diff endpoint")
+			return
+		}
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "expect UUID to follow 'diff' endpoint")
+			return
+		}
+		uuidB, vB, err := datastore.GetDiffVersion(uuid, parts[4])
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		numKeys, err := d.WriteDiff(w, ctx.VersionID(), vB)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		comment = fmt.Sprintf("HTTP GET diff of %d keys between %s and %s", numKeys, uuid, uuidB)
+
 	case "keys":
 		keyList, err := d.GetKeys(ctx)
 		if err != nil {
@@ -548,6 +803,23 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		fmt.Fprintf(w, string(jsonBytes))
 		comment = fmt.Sprintf("HTTP GET keyrange [%q, %q]", keyBeg, keyEnd)
 
+	case "query":
+		if action != "get" {
+			server.BadRequest(w, r, "query endpoint only supports GET HTTP verb")
+			return
+		}
+		keyList, err := d.QueryIndex(ctx, indexQuery(r.URL.Query()))
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(keyList); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		comment = fmt.Sprintf("HTTP GET query %q of keyvalue %q: %d keys", r.URL.RawQuery, d.DataName(), len(keyList))
+
 	case "keyvalues":
 		switch action {
 		case "get":
@@ -558,6 +830,15 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			}
 			comment = fmt.Sprintf("HTTP GET keyvalues on %d keys, %d bytes, data %q", numKeys, writtenBytes, d.DataName())
 		case "post":
+			if r.URL.Query().Get("cas") == "true" {
+				numKeys, err := d.handleCompareAndSwap(w, r, uuid, ctx)
+				if err != nil {
+					server.BadRequest(w, r, err)
+					return
+				}
+				comment = fmt.Sprintf("HTTP POST keyvalues compare-and-swap on %d keys, data %q", numKeys, d.DataName())
+				break
+			}
 			if err := d.handleIngest(r, uuid, ctx); err != nil {
 				server.BadRequest(w, r, err)
 				return
@@ -575,6 +856,25 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		}
 		keyStr := parts[4]
 
+		if len(parts) > 5 && parts[5] == "history" {
+			if action != "get" {
+				server.BadRequest(w, r, "key history endpoint only supports GET HTTP verb")
+				return
+			}
+			history, err := d.GetKeyHistory(ctx.VersionID(), keyStr)
+			if err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(history); err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			comment = fmt.Sprintf("HTTP GET history of key %q of keyvalue %q: %d versions (%s)", keyStr, d.DataName(), len(history), url)
+			break
+		}
+
 		switch action {
 		case "head":
 			found, err := d.KeyExists(ctx, keyStr)
@@ -591,7 +891,18 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 
 		case "get":
 			// Return value of single key
-			value, found, err := d.GetData(ctx, keyStr)
+			asOf, err := dvid.GetAsOf(r)
+			if err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			var value []byte
+			var found bool
+			if asOf != nil {
+				value, found, err = d.GetDataAsOf(ctx, keyStr, *asOf)
+			} else {
+				value, found, err = d.GetData(ctx, keyStr)
+			}
 			if err != nil {
 				server.BadRequest(w, r, err)
 				return
@@ -600,6 +911,12 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 				http.Error(w, fmt.Sprintf("Key %q not found", keyStr), http.StatusNotFound)
 				return
 			}
+			etag := ETag(value)
+			w.Header().Set("ETag", etag)
+			if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagListMatches(ifNoneMatch, etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
 			if value != nil || len(value) > 0 {
 				_, err = w.Write(value)
 				if err != nil {
@@ -611,10 +928,34 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			comment = fmt.Sprintf("HTTP GET key %q of keyvalue %q: %d bytes (%s)", keyStr, d.DataName(), len(value), url)
 
 		case "delete":
-			if err := d.DeleteData(ctx, keyStr); err != nil {
+			var err error
+			cond := ConditionFromRequest(r)
+			if cond.IsEmpty() {
+				err = d.DeleteData(ctx, keyStr)
+			} else {
+				err = d.DeleteDataIf(ctx, keyStr, cond)
+			}
+			if err != nil {
+				if precondErr, ok := err.(PreconditionError); ok {
+					http.Error(w, precondErr.Error(), http.StatusPreconditionFailed)
+					return
+				}
 				server.BadRequest(w, r, err)
 				return
 			}
+
+			go func() {
+				msginfo := map[string]interface{}{
+					"Action":    "delkv",
+					"Key":       keyStr,
+					"UUID":      string(uuid),
+					"Timestamp": time.Now().String(),
+				}
+				jsonmsg, _ := json.Marshal(msginfo)
+				if err := d.ProduceKafkaMsg(jsonmsg); err != nil {
+					dvid.Errorf("Error on sending keyvalue DELETE op to kafka: %v\n", err)
+				}
+			}()
 			comment = fmt.Sprintf("HTTP DELETE data with key %q of keyvalue %q (%s)", keyStr, d.DataName(), url)
 
 		case "post":
@@ -624,6 +965,21 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 				return
 			}
 
+			cond := ConditionFromRequest(r)
+			if cond.IsEmpty() {
+				err = d.PutData(ctx, keyStr, data)
+			} else {
+				_, err = d.PutDataIf(ctx, keyStr, data, cond)
+			}
+			if err != nil {
+				if precondErr, ok := err.(PreconditionError); ok {
+					http.Error(w, precondErr.Error(), http.StatusPreconditionFailed)
+					return
+				}
+				server.BadRequest(w, r, err)
+				return
+			}
+
 			go func() {
 				msginfo := map[string]interface{}{
 					"Action":    "postkv",
@@ -633,16 +989,11 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 					"Timestamp": time.Now().String(),
 				}
 				jsonmsg, _ := json.Marshal(msginfo)
-				if err = d.ProduceKafkaMsg(jsonmsg); err != nil {
+				if err := d.ProduceKafkaMsg(jsonmsg); err != nil {
 					dvid.Errorf("Error on sending keyvalue POST op to kafka: %v\n", err)
 				}
 			}()
-
-			err = d.PutData(ctx, keyStr, data)
-			if err != nil {
-				server.BadRequest(w, r, err)
-				return
-			}
+			w.Header().Set("ETag", ETag(data))
 			comment = fmt.Sprintf("HTTP POST keyvalue '%s': %d bytes (%s)", d.DataName(), len(data), url)
 		default:
 			server.BadRequest(w, r, "key endpoint does not support %q HTTP verb", action)
@@ -660,6 +1011,16 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 
 func (d *Data) handleKeyValues(w http.ResponseWriter, r *http.Request, uuid dvid.UUID, ctx *datastore.VersionedCtx) (numKeys, writtenBytes int, err error) {
 	jsontar := (r.URL.Query().Get("jsontar") != "")
+	var asOf *dvid.AsOf
+	if asOf, err = dvid.GetAsOf(r); err != nil {
+		return
+	}
+	getData := func(key string) ([]byte, bool, error) {
+		if asOf != nil {
+			return d.GetDataAsOf(ctx, key, *asOf)
+		}
+		return d.GetData(ctx, key)
+	}
 	var data []byte
 	data, err = ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -679,7 +1040,7 @@ func (d *Data) handleKeyValues(w http.ResponseWriter, r *http.Request, uuid dvid
 
 		var val []byte
 		for _, key := range keys {
-			if val, found, err = d.GetData(ctx, key); err != nil {
+			if val, found, err = getData(key); err != nil {
 				return
 			}
 			if !found {
@@ -711,7 +1072,7 @@ func (d *Data) handleKeyValues(w http.ResponseWriter, r *http.Request, uuid dvid
 		var kvs KeyValues
 		kvs.Kvs = make([]*KeyValue, numKeys)
 		for i, key := range keys.Keys {
-			if val, found, err = d.GetData(ctx, key); err != nil {
+			if val, found, err = getData(key); err != nil {
 				return
 			}
 			if !found {
@@ -765,3 +1126,60 @@ func (d *Data) handleIngest(r *http.Request, uuid dvid.UUID, ctx *datastore.Vers
 	}
 	return nil
 }
+
+func (d *Data) handleCompareAndSwap(w http.ResponseWriter, r *http.Request, uuid dvid.UUID, ctx *datastore.VersionedCtx) (numKeys int, err error) {
+	var data []byte
+	if data, err = ioutil.ReadAll(r.Body); err != nil {
+		return
+	}
+	var writes []KeyWrite
+	if err = json.Unmarshal(data, &writes); err != nil {
+		err = fmt.Errorf("Error trying to parse POSTed compare-and-swap JSON: %v", err)
+		return
+	}
+	numKeys = len(writes)
+	if err = d.CompareAndSwap(ctx, writes); err != nil {
+		if precondErr, ok := err.(PreconditionError); ok {
+			w.Header().Set("Content-type", "application/json")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			err = json.NewEncoder(w).Encode(precondErr)
+		}
+		return
+	}
+	etags := make(map[string]string, len(writes))
+	for _, write := range writes {
+		if write.Delete {
+			etags[write.Key] = ""
+
+			msginfo := map[string]interface{}{
+				"Action":    "delkv",
+				"Key":       write.Key,
+				"UUID":      string(uuid),
+				"Timestamp": time.Now().String(),
+			}
+			jsonmsg, _ := json.Marshal(msginfo)
+			if err := d.ProduceKafkaMsg(jsonmsg); err != nil {
+				dvid.Errorf("Error on sending keyvalue DELETE op to kafka: %v\n", err)
+			}
+			continue
+		}
+		etags[write.Key] = ETag(write.Value)
+
+		msginfo := map[string]interface{}{
+			"Action":    "postkv",
+			"Key":       write.Key,
+			"Bytes":     len(write.Value),
+			"UUID":      string(uuid),
+			"Timestamp": time.Now().String(),
+		}
+		jsonmsg, _ := json.Marshal(msginfo)
+		if err := d.ProduceKafkaMsg(jsonmsg); err != nil {
+			dvid.Errorf("Error on sending keyvalue POST op to kafka: %v\n", err)
+		}
+	}
+	w.Header().Set("Content-type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		ETags map[string]string `json:"etags"`
+	}{etags})
+	return
+}
@@ -9,13 +9,16 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
 )
 
 var (
@@ -134,7 +137,7 @@ func TestKeyvalueRepoPersistence(t *testing.T) {
 	if !ok {
 		t.Errorf("Can't cast keyvalue data service into keyvalue.Data\n")
 	}
-	oldData := *kvdata
+	oldData := &Data{Data: kvdata.Data}
 
 	// Restart test datastore and see if datasets are still there.
 	if err = datastore.SaveDataByUUID(uuid, kvdata); err != nil {
@@ -151,7 +154,7 @@ func TestKeyvalueRepoPersistence(t *testing.T) {
 		t.Errorf("Returned new data instance 2 is not keyvalue.Data\n")
 	}
 	if !oldData.Equals(kvdata2) {
-		t.Errorf("Expected %v, got %v\n", oldData, *kvdata2)
+		t.Errorf("Expected %v, got %v\n", oldData.Data, kvdata2.Data)
 	}
 }
 
@@ -896,6 +899,547 @@ func TestDiamondGetOnMerge(t *testing.T) {
 	}
 }
 
+func TestKeyvalueAsOf(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, versionID := initTestRepo()
+	config := dvid.NewConfig()
+	config.Set("History", "true")
+	server.CreateTestInstance(t, uuid, "keyvalue", "mykv", config)
+
+	url := fmt.Sprintf("%snode/%s/mykv/key/mykey", server.WebAPIPath, uuid)
+	asOfURL := func(asOf string) string {
+		return url + "?as_of=" + asOf
+	}
+	timeStr := func() string {
+		time.Sleep(5 * time.Millisecond)
+		defer time.Sleep(5 * time.Millisecond)
+		return time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	beforeAll := timeStr()
+	server.TestHTTP(t, "POST", url, strings.NewReader("first"))
+	afterFirst := timeStr()
+	server.TestHTTP(t, "POST", url, strings.NewReader("second"))
+	afterSecond := timeStr()
+	server.TestHTTP(t, "DELETE", url, nil)
+	afterDelete := timeStr()
+
+	resp := server.TestHTTPResponse(t, "GET", asOfURL(beforeAll), nil)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected key as of %s to be not found, got status %d\n", beforeAll, resp.Code)
+	}
+	if value := server.TestHTTP(t, "GET", asOfURL(afterFirst), nil); string(value) != "first" {
+		t.Fatalf("expected first value as of %s, got %q\n", afterFirst, string(value))
+	}
+	if value := server.TestHTTP(t, "GET", asOfURL(afterSecond), nil); string(value) != "second" {
+		t.Fatalf("expected second value as of %s, got %q\n", afterSecond, string(value))
+	}
+	resp = server.TestHTTPResponse(t, "GET", asOfURL(afterDelete), nil)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected deleted key as of %s to be not found, got status %d\n", afterDelete, resp.Code)
+	}
+	server.TestBadHTTP(t, "GET", asOfURL("yesterday"), nil)
+
+	// Reads as of the mutation ids of the writes.
+	d, err := GetByUUIDName(uuid, "mykv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := d.getHistory(datastore.NewVersionedCtx(d, versionID), db, "mykey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || !records[2].Deleted {
+		t.Fatalf("expected 2 puts and a delete in history, got %v\n", records)
+	}
+	if value := server.TestHTTP(t, "GET", asOfURL(fmt.Sprintf("%d", records[1].MutID)), nil); string(value) != "second" {
+		t.Fatalf("expected second value as of mutation %d, got %q\n", records[1].MutID, string(value))
+	}
+
+	// Batch reads use the same point in time.
+	keysReq := fmt.Sprintf("%snode/%s/mykv/keyvalues?jsontar=true&as_of=%d", server.WebAPIPath, uuid, records[0].MutID)
+	tarData := server.TestHTTP(t, "GET", keysReq, strings.NewReader(`["mykey"]`))
+	tr := tar.NewReader(bytes.NewBuffer(tarData))
+	if _, err := tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	var value bytes.Buffer
+	if _, err := io.Copy(&value, tr); err != nil {
+		t.Fatal(err)
+	}
+	if value.String() != "first" {
+		t.Fatalf("expected first value in batch read as of mutation %d, got %q\n", records[0].MutID, value.String())
+	}
+
+	// Only the latest writes are kept, and reads before them fail.
+	config = dvid.NewConfig()
+	config.Set("History", "true")
+	config.Set("HistoryMax", "2")
+	server.CreateTestInstance(t, uuid, "keyvalue", "prunedkv", config)
+	prunedURL := fmt.Sprintf("%snode/%s/prunedkv/key/mykey", server.WebAPIPath, uuid)
+	for _, value := range []string{"first", "second", "third"} {
+		server.TestHTTP(t, "POST", prunedURL, strings.NewReader(value))
+	}
+	pruned, err := GetByUUIDName(uuid, "prunedkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err = pruned.getHistory(datastore.NewVersionedCtx(pruned, versionID), db, "mykey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !records[0].Pruned || records[1].Pruned || string(records[0].Value) != "second" {
+		t.Fatalf("expected 2 latest records with earlier ones pruned, got %v\n", records)
+	}
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%s?as_of=%d", prunedURL, records[0].MutID-1), nil)
+	if value := server.TestHTTP(t, "GET", fmt.Sprintf("%s?as_of=%d", prunedURL, records[0].MutID), nil); string(value) != "second" {
+		t.Fatalf("expected second value as of mutation %d, got %q\n", records[0].MutID, string(value))
+	}
+
+	// Instances without the History setting record only the latest write without its value.
+	server.CreateTestInstance(t, uuid, "keyvalue", "nohistkv", dvid.NewConfig())
+	nohistURL := fmt.Sprintf("%snode/%s/nohistkv/key/mykey", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", nohistURL, strings.NewReader("first"))
+	server.TestHTTP(t, "POST", nohistURL, strings.NewReader("second"))
+	nohist, err := GetByUUIDName(uuid, "nohistkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err = nohist.getHistory(datastore.NewVersionedCtx(nohist, versionID), db, "mykey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || !records[0].NoValue || !records[0].Pruned || len(records[0].Value) != 0 {
+		t.Fatalf("expected latest write without value and without History setting, got %v\n", records)
+	}
+	if value := server.TestHTTP(t, "GET", fmt.Sprintf("%s?as_of=%d", nohistURL, records[0].MutID), nil); string(value) != "second" {
+		t.Fatalf("expected second value as of mutation %d, got %q\n", records[0].MutID, string(value))
+	}
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%s?as_of=%d", nohistURL, records[0].MutID-1), nil)
+}
+
+func TestKeyvalueDiff(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "keyvalue", "mykv", config)
+
+	keyURL := func(uuid dvid.UUID, key string) string {
+		return fmt.Sprintf("%snode/%s/mykv/key/%s", server.WebAPIPath, uuid, key)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		server.TestHTTP(t, "POST", keyURL(uuid, key), strings.NewReader("value "+key))
+	}
+	if err := datastore.Commit(uuid, "parent", nil); err != nil {
+		t.Fatalf("unable to commit parent: %v\n", err)
+	}
+	child, err := datastore.NewVersion(uuid, "child", "", nil)
+	if err != nil {
+		t.Fatalf("unable to create child: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", keyURL(child, "a"), strings.NewReader("value a"))
+	server.TestHTTP(t, "POST", keyURL(child, "b"), strings.NewReader("new value b"))
+	server.TestHTTP(t, "DELETE", keyURL(child, "c"), nil)
+	server.TestHTTP(t, "POST", keyURL(child, "d"), strings.NewReader("value d"))
+
+	diffURL := fmt.Sprintf("%snode/%s/mykv/diff/%s", server.WebAPIPath, uuid, child)
+	expected := `{"key":"b","change":"modified"}
+{"key":"c","change":"deleted"}
+{"key":"d","change":"added"}
+`
+	if got := string(server.TestHTTP(t, "GET", diffURL, nil)); got != expected {
+		t.Fatalf("bad diff from parent to child:\nGot: %s\nExpected: %s\n", got, expected)
+	}
+	diffURL = fmt.Sprintf("%snode/%s/mykv/diff/%s", server.WebAPIPath, child, uuid)
+	expected = `{"key":"b","change":"modified"}
+{"key":"c","change":"added"}
+{"key":"d","change":"deleted"}
+`
+	if got := string(server.TestHTTP(t, "GET", diffURL, nil)); got != expected {
+		t.Fatalf("bad diff from child to parent:\nGot: %s\nExpected: %s\n", got, expected)
+	}
+	diffURL = fmt.Sprintf("%snode/%s/mykv/diff/%s", server.WebAPIPath, child, child)
+	if got := server.TestHTTP(t, "GET", diffURL, nil); len(got) != 0 {
+		t.Fatalf("expected no diff between same versions, got %s\n", string(got))
+	}
+
+	// A callback error stops the diff of more keys than the scan buffers.
+	dataservice, err := datastore.GetDataByUUIDName(child, "mykv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, ok := dataservice.(*Data)
+	if !ok {
+		t.Fatalf("Can't cast keyvalue data service into keyvalue.Data\n")
+	}
+	vParent, err := datastore.VersionFromUUID(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vChild, err := datastore.VersionFromUUID(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := datastore.NewVersionedCtx(data, vChild)
+	for i := 0; i < 3000; i++ {
+		if err := data.PutData(ctx, fmt.Sprintf("many-%04d", i), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stopErr := fmt.Errorf("stop diff")
+	var numDiffs int
+	err = datastore.DiffVersions(data, vParent, vChild, storage.MinTKey(keyStandard), storage.MaxTKey(keyStandard), func(datastore.KeyDiff) error {
+		numDiffs++
+		return stopErr
+	})
+	if err != stopErr || numDiffs != 1 {
+		t.Fatalf("expected diff to stop after first callback error, got %d diffs and error %v\n", numDiffs, err)
+	}
+	numDiffs = 0
+	err = datastore.DiffVersions(data, vParent, vChild, storage.MinTKey(keyStandard), storage.MaxTKey(keyStandard), func(datastore.KeyDiff) error {
+		numDiffs++
+		return nil
+	})
+	if err != nil || numDiffs != 3003 {
+		t.Fatalf("expected 3003 diffs after stopped diff, got %d and error %v\n", numDiffs, err)
+	}
+
+	otherUUID, _ := initTestRepo()
+	diffURL = fmt.Sprintf("%snode/%s/mykv/diff/%s", server.WebAPIPath, uuid, otherUUID)
+	server.TestBadHTTP(t, "GET", diffURL, nil)
+}
+
+func conditionalRequest(t *testing.T, method, urlStr string, payload io.Reader, header, etags string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, urlStr, payload)
+	if err != nil {
+		t.Fatalf("Unsuccessful %s on %q: %v\n", method, urlStr, err)
+	}
+	req.Header.Set(header, etags)
+	resp := httptest.NewRecorder()
+	server.ServeSingleHTTP(resp, req)
+	return resp
+}
+
+func TestKeyvalueConditional(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "keyvalue", "mykv", config)
+
+	keyURL := fmt.Sprintf("%snode/%s/mykv/key/doc", server.WebAPIPath, uuid)
+	resp := conditionalRequest(t, "POST", keyURL, strings.NewReader("v1"), "If-None-Match", "*")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected create with If-None-Match: * to succeed, got status %d\n", resp.Code)
+	}
+	etag1 := resp.Header().Get("ETag")
+	if etag1 != ETag([]byte("v1")) {
+		t.Fatalf("expected ETag %s on POST, got %s\n", ETag([]byte("v1")), etag1)
+	}
+	resp = conditionalRequest(t, "POST", keyURL, strings.NewReader("other"), "If-None-Match", "*")
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 on second create, got status %d\n", resp.Code)
+	}
+
+	resp = server.TestHTTPResponse(t, "GET", keyURL, nil)
+	if resp.Header().Get("ETag") != etag1 {
+		t.Fatalf("expected ETag %s on GET, got %s\n", etag1, resp.Header().Get("ETag"))
+	}
+	resp = conditionalRequest(t, "GET", keyURL, nil, "If-None-Match", etag1)
+	if resp.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 on GET with current ETag, got status %d\n", resp.Code)
+	}
+
+	resp = conditionalRequest(t, "POST", keyURL, strings.NewReader("v2"), "If-Match", etag1)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected swap with current ETag to succeed, got status %d\n", resp.Code)
+	}
+	resp = conditionalRequest(t, "POST", keyURL, strings.NewReader("v3"), "If-Match", etag1)
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 on swap with stale ETag, got status %d\n", resp.Code)
+	}
+	resp = conditionalRequest(t, "DELETE", keyURL, nil, "If-Match", etag1)
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 on delete with stale ETag, got status %d\n", resp.Code)
+	}
+	if value := server.TestHTTP(t, "GET", keyURL, nil); string(value) != "v2" {
+		t.Fatalf("expected value v2 after failed writes, got %q\n", string(value))
+	}
+	resp = conditionalRequest(t, "DELETE", keyURL, nil, "If-Match", `"stale", `+ETag([]byte("v2")))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected delete with current ETag in list to succeed, got status %d\n", resp.Code)
+	}
+	server.TestBadHTTP(t, "GET", keyURL, nil)
+
+	// Batch compare-and-swap.
+	casURL := fmt.Sprintf("%snode/%s/mykv/keyvalues?cas=true", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", fmt.Sprintf("%snode/%s/mykv/key/a", server.WebAPIPath, uuid), strings.NewReader("a1"))
+	writes := []KeyWrite{
+		{Key: "a", Value: []byte("a2"), Condition: Condition{IfMatch: ETag([]byte("a1"))}},
+		{Key: "b", Value: []byte("b1"), Condition: Condition{IfNoneMatch: "*"}},
+	}
+	jsonBytes, err := json.Marshal(writes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		ETags map[string]string `json:"etags"`
+	}
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", casURL, bytes.NewBuffer(jsonBytes)), &result); err != nil {
+		t.Fatalf("bad compare-and-swap response: %v\n", err)
+	}
+	if result.ETags["a"] != ETag([]byte("a2")) || result.ETags["b"] != ETag([]byte("b1")) {
+		t.Fatalf("bad ETags from compare-and-swap: %v\n", result.ETags)
+	}
+
+	// Applying the same writes again should fail for both keys and write nothing.
+	writes = append(writes, KeyWrite{Key: "c", Value: []byte("c1")})
+	if jsonBytes, err = json.Marshal(writes); err != nil {
+		t.Fatal(err)
+	}
+	resp = server.TestHTTPResponse(t, "POST", casURL, bytes.NewBuffer(jsonBytes))
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 on stale compare-and-swap, got status %d\n", resp.Code)
+	}
+	var precondErr PreconditionError
+	if err := json.Unmarshal(resp.Body.Bytes(), &precondErr); err != nil {
+		t.Fatalf("bad precondition failure response: %v\n", err)
+	}
+	expected := []FailedKey{{Key: "a", ETag: ETag([]byte("a2"))}, {Key: "b", ETag: ETag([]byte("b1"))}}
+	if len(precondErr.Failed) != 2 || precondErr.Failed[0] != expected[0] || precondErr.Failed[1] != expected[1] {
+		t.Fatalf("expected failed keys %v, got %v\n", expected, precondErr.Failed)
+	}
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/mykv/key/c", server.WebAPIPath, uuid), nil)
+}
+
+func TestKeyvalueCompareAndSwapIndexHistory(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, v := initTestRepo()
+	config := dvid.NewConfig()
+	config.Set("IndexedFields", "status")
+	config.Set("History", "true")
+	config.Set("HistoryMax", "2")
+	server.CreateTestInstance(t, uuid, "keyvalue", "mykv", config)
+	dataservice, err := datastore.GetDataByUUIDName(uuid, "mykv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, ok := dataservice.(*Data)
+	if !ok {
+		t.Fatalf("Can't cast keyvalue data service into keyvalue.Data\n")
+	}
+	db, err := datastore.GetOrderedKeyValueDB(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := datastore.NewVersionedCtx(data, v)
+
+	if err := data.PutData(ctx, "a", []byte(`{"status": "old"}`)); err != nil {
+		t.Fatal(err)
+	}
+	writes := []KeyWrite{
+		{Key: "a", Value: []byte(`{"status": "new"}`), Condition: Condition{IfMatch: ETag([]byte(`{"status": "old"}`))}},
+		{Key: "a", Value: []byte(`{"status": "done"}`)},
+		{Key: "b", Value: []byte(`{"status": "new"}`), Condition: Condition{IfNoneMatch: "*"}},
+	}
+	if err := data.CompareAndSwap(ctx, writes); err != nil {
+		t.Fatalf("compare-and-swap failed: %v\n", err)
+	}
+	for status, expected := range map[string][]string{"old": nil, "new": {"b"}, "done": {"a"}} {
+		keys, err := data.QueryIndex(ctx, map[string][]string{"status": {status}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(keys) != fmt.Sprint(expected) {
+			t.Errorf("expected keys %v with status %q after compare-and-swap, got %v\n", expected, status, keys)
+		}
+	}
+
+	// The three writes to "a" exceed the two kept, so the oldest is pruned.
+	records, err := data.getHistory(ctx, db, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || string(records[0].Value) != `{"status": "new"}` || string(records[1].Value) != `{"status": "done"}` {
+		t.Fatalf("bad history of key after compare-and-swap: %v\n", records)
+	}
+	if !records[0].Pruned || records[1].Pruned {
+		t.Errorf("expected only the oldest remaining record to be marked pruned: %v\n", records)
+	}
+
+	// Deleting in a batch removes the index entries and records the deletion.
+	if err := data.CompareAndSwap(ctx, []KeyWrite{{Key: "a", Delete: true}}); err != nil {
+		t.Fatalf("compare-and-swap delete failed: %v\n", err)
+	}
+	keys, err := data.QueryIndex(ctx, map[string][]string{"status": {"done"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("expected no keys with status done after delete, got %v\n", keys)
+	}
+	if records, err = data.getHistory(ctx, db, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !records[0].Pruned || string(records[0].Value) != `{"status": "done"}` || !records[1].Deleted {
+		t.Fatalf("bad history of key after compare-and-swap delete: %v\n", records)
+	}
+}
+
+func TestKeyvalueQuery(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("IndexedFields", "status, meta.celltype,tags")
+	server.CreateTestInstance(t, uuid, "keyvalue", "annotations", config)
+
+	keyURL := func(uuid dvid.UUID, key string) string {
+		return fmt.Sprintf("%snode/%s/annotations/key/%s", server.WebAPIPath, uuid, key)
+	}
+	query := func(uuid dvid.UUID, params string) []string {
+		queryURL := fmt.Sprintf("%snode/%s/annotations/query?%s", server.WebAPIPath, uuid, params)
+		var keys []string
+		if err := json.Unmarshal(server.TestHTTP(t, "GET", queryURL, nil), &keys); err != nil {
+			t.Fatalf("bad query response for %q: %v\n", params, err)
+		}
+		return keys
+	}
+	checkKeys := func(got []string, expected ...string) {
+		if len(got) != len(expected) {
+			t.Fatalf("expected keys %v, got %v\n", expected, got)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("expected keys %v, got %v\n", expected, got)
+			}
+		}
+	}
+
+	server.TestHTTP(t, "POST", keyURL(uuid, "100"), strings.NewReader(`{"status": "Traced", "meta": {"celltype": "KC"}, "tags": ["a", 7]}`))
+	server.TestHTTP(t, "POST", keyURL(uuid, "200"), strings.NewReader(`{"status": "Traced", "meta": {"celltype": "PN"}}`))
+	server.TestHTTP(t, "POST", keyURL(uuid, "300"), strings.NewReader(`{"status": "Orphan"}`))
+	server.TestHTTP(t, "POST", keyURL(uuid, "400"), strings.NewReader("not json"))
+
+	checkKeys(query(uuid, "status=Traced"), "100", "200")
+	checkKeys(query(uuid, "status=Traced&meta.celltype=KC"), "100")
+	checkKeys(query(uuid, "status=Traced&status=Orphan"), "100", "200", "300")
+	checkKeys(query(uuid, "tags=7"), "100")
+	checkKeys(query(uuid, "status=Unknown"))
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/annotations/query?owner=me", server.WebAPIPath, uuid), nil)
+
+	if err := datastore.Commit(uuid, "parent", nil); err != nil {
+		t.Fatalf("unable to commit parent: %v\n", err)
+	}
+	child, err := datastore.NewVersion(uuid, "child", "", nil)
+	if err != nil {
+		t.Fatalf("unable to create child: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", keyURL(child, "300"), strings.NewReader(`{"status": "Traced"}`))
+	server.TestHTTP(t, "DELETE", keyURL(child, "200"), nil)
+
+	checkKeys(query(child, "status=Traced"), "100", "300")
+	checkKeys(query(child, "status=Orphan"))
+	checkKeys(query(child, "meta.celltype=PN"))
+	checkKeys(query(uuid, "status=Traced"), "100", "200")
+	checkKeys(query(uuid, "status=Orphan"), "300")
+}
+
+func TestKeyvalueHistory(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	config := dvid.NewConfig()
+	config.Set("History", "true")
+	server.CreateTestInstance(t, uuid, "keyvalue", "mykv", config)
+
+	keyURL := func(uuid dvid.UUID, key, user string) string {
+		return fmt.Sprintf("%snode/%s/mykv/key/%s?u=%s", server.WebAPIPath, uuid, key, user)
+	}
+	getHistory := func(uuid dvid.UUID) []KeyVersionHistory {
+		historyURL := fmt.Sprintf("%snode/%s/mykv/key/doc/history", server.WebAPIPath, uuid)
+		var history []KeyVersionHistory
+		if err := json.Unmarshal(server.TestHTTP(t, "GET", historyURL, nil), &history); err != nil {
+			t.Fatalf("bad key history response: %v\n", err)
+		}
+		return history
+	}
+
+	server.TestHTTP(t, "POST", keyURL(uuid, "doc", "alice"), strings.NewReader("first"))
+	server.TestHTTP(t, "POST", keyURL(uuid, "doc", "bob"), strings.NewReader("second"))
+	if err := datastore.Commit(uuid, "parent", nil); err != nil {
+		t.Fatalf("unable to commit parent: %v\n", err)
+	}
+	child, err := datastore.NewVersion(uuid, "child", "", nil)
+	if err != nil {
+		t.Fatalf("unable to create child: %v\n", err)
+	}
+	sibling, err := datastore.NewVersion(uuid, "sibling", "sibling", nil)
+	if err != nil {
+		t.Fatalf("unable to create sibling: %v\n", err)
+	}
+	server.TestHTTP(t, "DELETE", keyURL(child, "doc", "carol"), nil)
+	server.TestHTTP(t, "POST", keyURL(sibling, "doc", "dave"), strings.NewReader("sibling value"))
+
+	history := getHistory(child)
+	if len(history) != 2 {
+		t.Fatalf("expected 2 versions in child key history, got %v\n", history)
+	}
+	if history[0].UUID != uuid || history[0].Deleted || history[0].User != "bob" || history[0].ETag != ETag([]byte("second")) || history[0].Bytes != 6 {
+		t.Fatalf("bad parent entry in key history: %v\n", history[0])
+	}
+	if history[1].UUID != child || !history[1].Deleted || history[1].User != "carol" || history[1].ETag != "" {
+		t.Fatalf("bad child entry in key history: %v\n", history[1])
+	}
+	if history[1].MutID <= history[0].MutID || history[0].Timestamp == "" || history[1].Timestamp == "" {
+		t.Fatalf("bad mutation ids or timestamps in key history: %v\n", history)
+	}
+
+	history = getHistory(sibling)
+	if len(history) != 2 || history[1].UUID != sibling || history[1].Deleted || history[1].User != "dave" {
+		t.Fatalf("bad sibling key history: %v\n", history)
+	}
+	if history = getHistory(uuid); len(history) != 1 || history[0].UUID != uuid {
+		t.Fatalf("bad parent key history: %v\n", history)
+	}
+	// Writes are recorded without the History setting.
+	server.CreateTestInstance(t, child, "keyvalue", "nohistkv", dvid.NewConfig())
+	nohistURL := fmt.Sprintf("%snode/%s/nohistkv/key/doc", server.WebAPIPath, child)
+	server.TestHTTP(t, "POST", nohistURL+"?u=erin", strings.NewReader("value"))
+	history = nil
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", nohistURL+"/history", nil), &history); err != nil {
+		t.Fatalf("bad key history response: %v\n", err)
+	}
+	if len(history) != 1 || history[0].UUID != child || history[0].User != "erin" || history[0].MutID == 0 || history[0].Timestamp == "" {
+		t.Fatalf("bad key history without History setting: %v\n", history)
+	}
+}
+
 /*
 TODO -- Complete when mutation log access added, so we can check mutation is logged and test blobstore
 		fetch with reference.
@@ -0,0 +1,205 @@
+/*
+	This file supports keyvalue instances synced to labelmap instances, e.g., for caching
+	meshes.  Keys beginning with "<label>_" are deleted when the label is changed by a
+	merge, cleave or split, or when a block with the label or one of its supervoxels is
+	written.
+*/
+
+package keyvalue
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// Number of change messages we can buffer before blocking on sync channel.
+const syncBufferSize = 1000
+
+// InitDataHandlers launches goroutines to handle each labelmap instance's syncs.
+func (d *Data) InitDataHandlers() error {
+	if d.syncCh != nil || d.syncDone != nil {
+		return nil
+	}
+	d.syncCh = make(chan datastore.SyncMessage, syncBufferSize)
+	d.syncDone = make(chan *sync.WaitGroup)
+
+	// Launch handlers of sync events.
+	dvid.Infof("Launching sync event handler for data %q...\n", d.DataName())
+	go d.processEvents()
+	return nil
+}
+
+// Shutdown terminates blocks until syncs are done then terminates background goroutines processing data.
+func (d *Data) Shutdown(wg *sync.WaitGroup) {
+	if d.syncDone != nil {
+		dwg := new(sync.WaitGroup)
+		dwg.Add(1)
+		d.syncDone <- dwg
+		dwg.Wait() // Block until we are done.
+	}
+	wg.Done()
+}
+
+// blockDelta is implemented by the block deltas of a synced labelmap.
+type blockDelta interface {
+	Supervoxels() []uint64
+}
+
+// labelMapper is the portion of the labelmap datatype used to find the bodies of
+// supervoxels in written blocks.
+type labelMapper interface {
+	GetMappedLabels(v dvid.VersionID, supervoxels []uint64) (mapped []uint64, found []bool, err error)
+}
+
+// GetSyncSubs implements the datastore.Syncer interface.  Returns a list of subscriptions
+// to the sync data instance that will notify the receiver.
+func (d *Data) GetSyncSubs(synced dvid.Data) (subs datastore.SyncSubs, err error) {
+	if synced.TypeName() != "labelmap" {
+		err = fmt.Errorf("keyvalue instances can only be synced to labelmap instances, not %q (type %s)", synced.DataName(), synced.TypeName())
+		return
+	}
+	if d.syncCh == nil {
+		if err = d.InitDataHandlers(); err != nil {
+			err = fmt.Errorf("unable to initialize handlers for data %q: %v", d.DataName(), err)
+			return
+		}
+	}
+	events := []string{
+		labels.MergeBlockEvent, labels.CleaveLabelEvent, labels.SplitLabelEvent, labels.SupervoxelSplitEvent,
+		labels.IngestBlockEvent, labels.MutateBlockEvent,
+	}
+	for _, event := range events {
+		subs = append(subs, datastore.SyncSub{
+			Event:  datastore.SyncEvent{Data: synced.DataUUID(), Event: event},
+			Notify: d.DataUUID(),
+			Ch:     d.syncCh,
+		})
+	}
+	return
+}
+
+func (d *Data) processEvents() {
+	defer func() {
+		if e := recover(); e != nil {
+			msg := fmt.Sprintf("Panic detected on keyvalue sync thread: %+v\n", e)
+			dvid.ReportPanic(msg, server.WebServer())
+		}
+	}()
+	var stop bool
+	var wg *sync.WaitGroup
+	for {
+		select {
+		case wg = <-d.syncDone:
+			queued := len(d.syncCh)
+			if queued > 0 {
+				dvid.Infof("Received shutdown signal for %q sync events (%d in queue)\n", d.DataName(), queued)
+				stop = true
+			} else {
+				dvid.Infof("Shutting down sync event handler for instance %q...\n", d.DataName())
+				wg.Done()
+				return
+			}
+		case msg := <-d.syncCh:
+			d.handleSyncMessage(msg)
+
+			if stop && len(d.syncCh) == 0 {
+				dvid.Infof("Shutting down sync event handler for instance %q after draining sync events.\n", d.DataName())
+				wg.Done()
+				return
+			}
+		}
+	}
+}
+
+func (d *Data) handleSyncMessage(msg datastore.SyncMessage) {
+	d.StartUpdate()
+	defer d.StopUpdate()
+
+	var changed []uint64
+	switch delta := msg.Delta.(type) {
+	case labels.DeltaMerge:
+		changed = append(changed, delta.Target)
+		for label := range delta.Merged {
+			changed = append(changed, label)
+		}
+	case labels.CleaveOp:
+		changed = []uint64{delta.Target, delta.CleavedLabel}
+	case labels.DeltaSplit:
+		changed = []uint64{delta.OldLabel, delta.NewLabel}
+	case labels.SplitSupervoxelOp:
+		// Undoing a supervoxel split sends the same op, so the split supervoxels also change.
+		changed = []uint64{delta.Supervoxel, delta.SplitSupervoxel, delta.RemainSupervoxel}
+	case blockDelta:
+		var err error
+		if changed, err = d.blockLabels(msg.Version, delta.Supervoxels()); err != nil {
+			dvid.Errorf("unable to get labels of written block in keyvalue %q sync: %v\n", d.DataName(), err)
+			return
+		}
+	default:
+		dvid.Criticalf("Received unknown delta in keyvalue %q sync: %v\n", d.DataName(), msg)
+		return
+	}
+	ctx := datastore.NewVersionedCtx(d, msg.Version)
+	for _, label := range changed {
+		if err := d.deleteLabelKeys(ctx, label); err != nil {
+			dvid.Errorf("unable to delete keys for label %d in keyvalue %q: %v\n", label, d.DataName(), err)
+		}
+	}
+}
+
+// returns the given supervoxels of a written block and the bodies they belong to.
+func (d *Data) blockLabels(v dvid.VersionID, supervoxels []uint64) ([]uint64, error) {
+	changed := make(labels.Set, len(supervoxels))
+	var lookup []uint64
+	for _, sv := range supervoxels {
+		if _, found := changed[sv]; !found && sv != 0 {
+			changed[sv] = struct{}{}
+			lookup = append(lookup, sv)
+		}
+	}
+	for dataUUID := range d.SyncedData() {
+		synced, err := datastore.GetDataByDataUUID(dataUUID)
+		if err != nil {
+			return nil, err
+		}
+		mapper, ok := synced.(labelMapper)
+		if !ok {
+			continue
+		}
+		bodies, _, err := mapper.GetMappedLabels(v, lookup)
+		if err != nil {
+			return nil, err
+		}
+		for _, body := range bodies {
+			if body != 0 {
+				changed[body] = struct{}{}
+			}
+		}
+	}
+	result := make([]uint64, 0, len(changed))
+	for label := range changed {
+		result = append(result, label)
+	}
+	return result, nil
+}
+
+// deletes all keys with the prefix "<label>_".
+func (d *Data) deleteLabelKeys(ctx *datastore.VersionedCtx, label uint64) error {
+	prefix := strconv.FormatUint(label, 10) + "_"
+	keys, err := d.GetKeysInRange(ctx, prefix, prefix+"\xff")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := d.DeleteData(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+/*
+	This file supports graph analysis for proofreading: weighted shortest paths, connected
+	components under an edge weight threshold, and ranked merge proposals.
+*/
+
+package labelgraph
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// labelPath is the lowest total edge weight path between two vertices.
+type labelPath struct {
+	Distance float64
+	Vertices []labelVertex
+	Edges    []labelEdge
+}
+
+// labelComponent is a connected component and the sum of its vertex weights.
+type labelComponent struct {
+	Vertices []dvid.VertexID
+	Weight   float64
+}
+
+// mergeProposal is an edge proposed for merging, where Size1 and Size2 are the weights
+// of its vertices and Score determines its rank.
+type mergeProposal struct {
+	Id1    dvid.VertexID
+	Id2    dvid.VertexID
+	Weight float64
+	Size1  float64
+	Size2  float64
+	Score  float64
+}
+
+// pathItem is a vertex and its distance from the start vertex in the shortest path queue.
+type pathItem struct {
+	id       dvid.VertexID
+	distance float64
+}
+
+type pathQueue []pathItem
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].distance < q[j].distance }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathItem)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// ShortestPath returns the path between two vertices with the lowest total edge weight
+// using Dijkstra's algorithm, where only the vertices reached by the search are read.
+// Edge weights must be non-negative.
+func (d *Data) ShortestPath(ctx storage.Context, db storage.GraphDB, from, to dvid.VertexID) (*labelPath, error) {
+	start, err := db.GetVertex(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve vertex %d: %v\n", from, err)
+	}
+	if _, err := db.GetVertex(ctx, to); err != nil {
+		return nil, fmt.Errorf("Failed to retrieve vertex %d: %v\n", to, err)
+	}
+
+	distances := map[dvid.VertexID]float64{from: 0}
+	previous := make(map[dvid.VertexID]dvid.VertexID)
+	weights := map[dvid.VertexID]float64{from: start.Weight}
+	visited := make(map[dvid.VertexID]struct{})
+	queue := &pathQueue{{from, 0}}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(pathItem)
+		if _, found := visited[item.id]; found {
+			continue
+		}
+		visited[item.id] = struct{}{}
+		if item.id == to {
+			break
+		}
+		vertex, err := db.GetVertex(ctx, item.id)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to retrieve vertex %d: %v\n", item.id, err)
+		}
+		weights[item.id] = vertex.Weight
+		for _, neighbor := range vertex.Vertices {
+			if _, found := visited[neighbor]; found {
+				continue
+			}
+			edge, err := db.GetEdge(ctx, item.id, neighbor)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to retrieve edge %d-%d: %v\n", item.id, neighbor, err)
+			}
+			if edge.Weight < 0 {
+				return nil, fmt.Errorf("Edge %d-%d has negative weight %g, which is not allowed for shortest paths", item.id, neighbor, edge.Weight)
+			}
+			distance := item.distance + edge.Weight
+			if cur, found := distances[neighbor]; !found || distance < cur {
+				distances[neighbor] = distance
+				previous[neighbor] = item.id
+				heap.Push(queue, pathItem{neighbor, distance})
+			}
+		}
+	}
+	if _, found := visited[to]; !found {
+		return nil, fmt.Errorf("No path between vertices %d and %d", from, to)
+	}
+
+	// Walk back from the end vertex to get the path.
+	ids := []dvid.VertexID{to}
+	for id := to; id != from; {
+		id = previous[id]
+		ids = append(ids, id)
+	}
+	path := &labelPath{Distance: distances[to]}
+	for i := len(ids) - 1; i >= 0; i-- {
+		id := ids[i]
+		if _, found := weights[id]; !found {
+			vertex, err := db.GetVertex(ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to retrieve vertex %d: %v\n", id, err)
+			}
+			weights[id] = vertex.Weight
+		}
+		path.Vertices = append(path.Vertices, labelVertex{id, weights[id]})
+		if i > 0 {
+			path.Edges = append(path.Edges, labelEdge{id, ids[i-1], distances[ids[i-1]] - distances[id]})
+		}
+	}
+	return path, nil
+}
+
+// ConnectedComponents returns the connected components of the graph when only edges
+// passing the include test are used.  Components are ordered by decreasing total vertex
+// weight and each component's vertices are in increasing order.
+func (d *Data) ConnectedComponents(ctx storage.Context, db storage.GraphDB, include func(weight float64) bool) ([]labelComponent, error) {
+	vertices, err := db.GetVertices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve vertices: %v\n", err)
+	}
+	edges, err := db.GetEdges(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve edges: %v\n", err)
+	}
+
+	// Union-find with path halving.
+	parent := make(map[dvid.VertexID]dvid.VertexID, len(vertices))
+	for _, vertex := range vertices {
+		parent[vertex.Id] = vertex.Id
+	}
+	find := func(id dvid.VertexID) dvid.VertexID {
+		for parent[id] != id {
+			parent[id] = parent[parent[id]]
+			id = parent[id]
+		}
+		return id
+	}
+	for _, edge := range edges {
+		if !include(edge.Weight) {
+			continue
+		}
+		id1, id2 := edge.Vertexpair.Vertex1, edge.Vertexpair.Vertex2
+		if _, found := parent[id1]; !found {
+			continue
+		}
+		if _, found := parent[id2]; !found {
+			continue
+		}
+		root1, root2 := find(id1), find(id2)
+		if root1 != root2 {
+			parent[root1] = root2
+		}
+	}
+
+	componentMap := make(map[dvid.VertexID]*labelComponent)
+	for _, vertex := range vertices {
+		root := find(vertex.Id)
+		component, found := componentMap[root]
+		if !found {
+			component = new(labelComponent)
+			componentMap[root] = component
+		}
+		component.Vertices = append(component.Vertices, vertex.Id)
+		component.Weight += vertex.Weight
+	}
+	components := make([]labelComponent, 0, len(componentMap))
+	for _, component := range componentMap {
+		sort.Slice(component.Vertices, func(i, j int) bool { return component.Vertices[i] < component.Vertices[j] })
+		components = append(components, *component)
+	}
+	sort.Slice(components, func(i, j int) bool {
+		if components[i].Weight != components[j].Weight {
+			return components[i].Weight > components[j].Weight
+		}
+		return components[i].Vertices[0] < components[j].Vertices[0]
+	})
+	return components, nil
+}
+
+// MergeProposals returns edges ranked for merging.  The score of an edge is its weight, or
+// if sizePrior is true, its weight multiplied by log(1 + s) where s is the smaller weight of
+// its two vertices so merges of large vertices are proposed first.  Edges with a vertex of
+// weight less than minSize are skipped, and at most maxProposals are returned if positive.
+func (d *Data) MergeProposals(ctx storage.Context, db storage.GraphDB, sizePrior, ascending bool, minSize float64, maxProposals int) ([]mergeProposal, error) {
+	vertices, err := db.GetVertices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve vertices: %v\n", err)
+	}
+	edges, err := db.GetEdges(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve edges: %v\n", err)
+	}
+	sizes := make(map[dvid.VertexID]float64, len(vertices))
+	for _, vertex := range vertices {
+		sizes[vertex.Id] = vertex.Weight
+	}
+
+	proposals := make([]mergeProposal, 0, len(edges))
+	for _, edge := range edges {
+		proposal := mergeProposal{
+			Id1:    edge.Vertexpair.Vertex1,
+			Id2:    edge.Vertexpair.Vertex2,
+			Weight: edge.Weight,
+			Size1:  sizes[edge.Vertexpair.Vertex1],
+			Size2:  sizes[edge.Vertexpair.Vertex2],
+		}
+		minVertexSize := math.Min(proposal.Size1, proposal.Size2)
+		if minVertexSize < minSize {
+			continue
+		}
+		proposal.Score = proposal.Weight
+		if sizePrior {
+			proposal.Score *= math.Log1p(math.Max(minVertexSize, 0))
+		}
+		proposals = append(proposals, proposal)
+	}
+	sort.Slice(proposals, func(i, j int) bool {
+		pi, pj := proposals[i], proposals[j]
+		if pi.Score != pj.Score {
+			if ascending {
+				return pi.Score < pj.Score
+			}
+			return pi.Score > pj.Score
+		}
+		if pi.Id1 != pj.Id1 {
+			return pi.Id1 < pj.Id1
+		}
+		return pi.Id2 < pj.Id2
+	})
+	if maxProposals > 0 && len(proposals) > maxProposals {
+		proposals = proposals[:maxProposals]
+	}
+	return proposals, nil
+}
+
+// handleShortestPath returns the shortest path between the two vertices in the path.
+func (d *Data) handleShortestPath(ctx *datastore.VersionedCtx, db storage.GraphDB, w http.ResponseWriter, path []string) error {
+	if len(path) < 2 {
+		return fmt.Errorf("Must specify two vertices for shortest path")
+	}
+	from, err := strconv.ParseUint(path[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("Bad vertex %q: %v", path[0], err)
+	}
+	to, err := strconv.ParseUint(path[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("Bad vertex %q: %v", path[1], err)
+	}
+	shortest, err := d.ShortestPath(ctx, db, dvid.VertexID(from), dvid.VertexID(to))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, shortest)
+}
+
+// handleComponents returns the connected components using edges passing an optional
+// weight threshold.
+func (d *Data) handleComponents(ctx *datastore.VersionedCtx, db storage.GraphDB, w http.ResponseWriter, query url.Values) error {
+	if !d.setBusy() {
+		return fmt.Errorf("Server busy with bulk transaction")
+	}
+	defer d.setNotBusy()
+
+	include := func(weight float64) bool { return true }
+	if thresholdStr := query.Get("threshold"); thresholdStr != "" {
+		threshold, err := strconv.ParseFloat(thresholdStr, 64)
+		if err != nil {
+			return fmt.Errorf("Bad threshold %q: %v", thresholdStr, err)
+		}
+		if query.Get("above") == "true" {
+			include = func(weight float64) bool { return weight >= threshold }
+		} else {
+			include = func(weight float64) bool { return weight <= threshold }
+		}
+	}
+	components, err := d.ConnectedComponents(ctx, db, include)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, struct{ Components []labelComponent }{components})
+}
+
+// handleProposals returns the ranked merge proposals.
+func (d *Data) handleProposals(ctx *datastore.VersionedCtx, db storage.GraphDB, w http.ResponseWriter, query url.Values) error {
+	if !d.setBusy() {
+		return fmt.Errorf("Server busy with bulk transaction")
+	}
+	defer d.setNotBusy()
+
+	sizePrior := query.Get("sizeprior") != "false"
+	var ascending bool
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		ascending = true
+	default:
+		return fmt.Errorf("Bad order %q, must be \"asc\" or \"desc\"", query.Get("order"))
+	}
+	var minSize float64
+	if minSizeStr := query.Get("minsize"); minSizeStr != "" {
+		var err error
+		if minSize, err = strconv.ParseFloat(minSizeStr, 64); err != nil {
+			return fmt.Errorf("Bad minsize %q: %v", minSizeStr, err)
+		}
+	}
+	var maxProposals int
+	if nStr := query.Get("n"); nStr != "" {
+		var err error
+		if maxProposals, err = strconv.Atoi(nStr); err != nil {
+			return fmt.Errorf("Bad number of proposals %q: %v", nStr, err)
+		}
+	}
+	proposals, err := d.MergeProposals(ctx, db, sizePrior, ascending, minSize, maxProposals)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, struct{ Proposals []mergeProposal }{proposals})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	m, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Could not serialize response: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(m)
+	return err
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -131,6 +132,33 @@ POST <api URL>/node/<UUID>/<data name>/info
     data name     Name of voxels data.
 
 
+POST <api URL>/node/<UUID>/<data name>/sync?<options>
+
+    Establishes a labelmap instance whose body merges, cleaves, and splits are applied to
+    the graph, where vertex IDs are body labels.  Expects JSON to be POSTed with the
+    following format:
+
+    { "sync": "segmentation" }
+
+    To delete syncs, pass an empty string of names with query string "replace=true":
+
+    { "sync": "" }
+
+    On a merge, the merged vertices are removed and their weights are added to the target
+    vertex.  Their edges are moved to the target vertex, where the weights of edges to the
+    same vertex are combined using the "EdgeCombine" setting given when the labelgraph is
+    created: "sum" (default), "max", or "min".  Edges between merged vertices are removed.
+
+    On a cleave or split, a vertex is added for the new body with the edges of the original
+    body since the graph doesn't record which part of a body each edge touches.  The original
+    vertex weight is divided between the two vertices in proportion to their voxel counts.
+
+    POST Query-string Options:
+
+    replace    Set to "true" if you want passed syncs to replace and not be appended to current syncs.
+               Default operation is false.
+
+
 GET  <api URL>/node/<UUID>/<data name>/subgraph
 POST  <api URL>/node/<UUID>/<data name>/subgraph
 DELETE  <api URL>/node/<UUID>/<data name>/subgraph
@@ -206,6 +234,67 @@ GET  <api URL>/node/<UUID>/<data name>/neighbors/<vertex>
     vertex        ID of vertex
 
 
+GET  <api URL>/node/<UUID>/<data name>/shortestpath/<vertex1>/<vertex2>
+
+    Retrieves the path between the two vertices with the lowest sum of edge weights.  Edge
+    weights must be non-negative.  An error is returned if there is no path.
+
+    The "Content-type" of the HTTP response is "application/json" with the total edge weight
+    of the path and the path's vertices and edges in order from vertex1 to vertex2:
+
+    { "Distance": 3.5, "Vertices": [{"Id": 1, "Weight": 2.3}, ...], "Edges": [{"Id1": 1, "Id2": 4, "Weight": 1.5}, ...] }
+
+    Arguments:
+
+    UUID          Hexadecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to add/retrieve.
+    vertex1       ID of the starting vertex
+    vertex2       ID of the ending vertex
+
+
+GET  <api URL>/node/<UUID>/<data name>/components[?<options>]
+
+    Retrieves the connected components of the graph, where vertices are connected only by
+    edges passing the weight threshold if given.  Components are returned in order of
+    decreasing sum of vertex weights and the vertices of each component are in increasing order.
+
+    { "Components": [ {"Vertices": [1, 4, 7], "Weight": 100.5}, {"Vertices": [2], "Weight": 3.2}, ... ] }
+
+    Arguments:
+
+    UUID          Hexadecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to add/retrieve.
+
+    Query-string Options:
+
+    threshold     Only use edges with weight less than or equal to this threshold.
+    above         If "true", only use edges with weight greater than or equal to the threshold.
+
+
+GET  <api URL>/node/<UUID>/<data name>/proposals[?<options>]
+
+    Retrieves edges ranked as merge proposals, e.g., for focused proofreading.  Each proposal
+    gives the edge vertices and weight, the weights (sizes) of the vertices, and a score used
+    for ranking.  By default, the score is the edge weight multiplied by a size prior, log(1 + s)
+    where s is the smaller of the two vertex weights, so edges between large vertices are
+    proposed first.  Proposals are in decreasing score order by default.
+
+    { "Proposals": [ {"Id1": 1, "Id2": 4, "Weight": 0.9, "Size1": 100, "Size2": 20, "Score": 2.74}, ... ] }
+
+    Arguments:
+
+    UUID          Hexadecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to add/retrieve.
+
+    Query-string Options:
+
+    n             Maximum number of proposals returned.
+    order         "desc" (default) for decreasing score or "asc" for increasing score, e.g., if
+                  edge weights are boundary predictions where lower weights are better merges.
+    sizeprior     If "false", the score is just the edge weight.
+    minsize       Skip edges where either vertex has weight less than this size.
+
+
 POST  <api URL>/node/<UUID>/<data name>/weight
 
     Updates the weight associated with the provided vertices and edges.  Requests
@@ -550,11 +639,22 @@ func NewType() *Type {
 
 // NewDataService returns a pointer to new keyvalue data with default values.
 func (dtype *Type) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.InstanceName, c dvid.Config) (datastore.DataService, error) {
+	var props Properties
+	combine, found, err := c.GetString("EdgeCombine")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		props.EdgeCombine = strings.ToLower(combine)
+		if _, err := edgeCombiner(props.EdgeCombine); err != nil {
+			return nil, err
+		}
+	}
 	basedata, err := datastore.NewDataService(dtype, uuid, id, name, c)
 	if err != nil {
 		return nil, err
 	}
-	return &Data{Data: basedata}, nil
+	return &Data{Data: basedata, Properties: props}, nil
 }
 
 // Help returns help mesage for datatype
@@ -566,23 +666,42 @@ func (dtype *Type) Help() string {
 // (default values are okay after deserializing).
 type Data struct {
 	*datastore.Data
+	datastore.Updater
+	Properties
 	transaction_log *transactionLog
 	busy            bool
 	datawide_mutex  sync.Mutex
+
+	// channels for sync events from labelmap instances.
+	syncCh   chan datastore.SyncMessage
+	syncDone chan *sync.WaitGroup
+}
+
+// Properties are additional properties for labelgraph data instances beyond those
+// in standard datastore.Data.   These will be persisted to metadata storage.
+type Properties struct {
+	// EdgeCombine is the rule for combining weights of edges to the same vertex when
+	// vertices are merged by a synced labelmap: "sum" (default), "max", or "min".
+	EdgeCombine string `json:",omitempty"`
 }
 
 func (d *Data) Equals(d2 *Data) bool {
 	if !d.Data.Equals(d2.Data) {
 		return false
 	}
+	if d.Properties != d2.Properties {
+		return false
+	}
 	return true
 }
 
 func (d *Data) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Base *datastore.Data
+		Base     *datastore.Data
+		Extended Properties
 	}{
 		d.Data,
+		d.Properties,
 	})
 }
 
@@ -592,6 +711,10 @@ func (d *Data) GobDecode(b []byte) error {
 	if err := dec.Decode(&(d.Data)); err != nil {
 		return err
 	}
+	// Instances created before properties were added have none.
+	if err := dec.Decode(&(d.Properties)); err != nil && err != io.EOF {
+		return err
+	}
 	return nil
 }
 
@@ -601,6 +724,9 @@ func (d *Data) GobEncode() ([]byte, error) {
 	if err := enc.Encode(d.Data); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(d.Properties); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -1411,6 +1537,42 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		if err != nil {
 			server.BadRequest(w, r, err)
 		}
+	case "shortestpath":
+		if method != "get" {
+			server.BadRequest(w, r, "Only supports GETs")
+			return
+		}
+		err := d.handleShortestPath(ctx, db, w, parts[4:])
+		if err != nil {
+			server.BadRequest(w, r, err)
+		}
+	case "components":
+		if method != "get" {
+			server.BadRequest(w, r, "Only supports GETs")
+			return
+		}
+		err := d.handleComponents(ctx, db, w, r.URL.Query())
+		if err != nil {
+			server.BadRequest(w, r, err)
+		}
+	case "proposals":
+		if method != "get" {
+			server.BadRequest(w, r, "Only supports GETs")
+			return
+		}
+		err := d.handleProposals(ctx, db, w, r.URL.Query())
+		if err != nil {
+			server.BadRequest(w, r, err)
+		}
+	case "sync":
+		if method != "post" {
+			server.BadRequest(w, r, "Only POST allowed to sync endpoint")
+			return
+		}
+		replace := r.URL.Query().Get("replace") == "true"
+		if err := datastore.SetSyncByJSON(d, uuid, replace, r.Body); err != nil {
+			server.BadRequest(w, r, err)
+		}
 	case "undomerge":
 		// not supported until transaction history is supported
 		server.BadRequest(w, r, "undomerge not yet implemented")
@@ -2,11 +2,13 @@ package labelgraph
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/janelia-flyem/dvid/datastore"
@@ -180,3 +182,204 @@ func TestLabelgraphPostAndDelete(t *testing.T) {
 		t.Errorf("Bad ROI after ROI delete.  Should be %s got: %s\n", expectedResp, string(returnedData))
 	}
 }
+
+// return a graph for testing graph algorithms where vertex 5 is isolated.
+func getAlgorithmGraphJSON() io.Reader {
+	graph := LabelGraph{
+		Vertices: []labelVertex{{1, 10}, {2, 5}, {3, 1}, {4, 20}, {5, 2}},
+		Edges:    []labelEdge{{1, 2, 1}, {2, 3, 1}, {1, 3, 5}, {3, 4, 0.5}},
+	}
+	jsonBytes, err := json.Marshal(graph)
+	if err != nil {
+		log.Fatalf("Can't encode graph into JSON: %v\n", err)
+	}
+	return bytes.NewReader(jsonBytes)
+}
+
+func TestLabelgraphAlgorithms(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	if _, err := datastore.NewData(uuid, dtype, "lg", dvid.NewConfig()); err != nil {
+		t.Fatalf("Error creating new labelgraph instance: %v\n", err)
+	}
+	baseURL := fmt.Sprintf("%snode/%s/lg", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", baseURL+"/subgraph", getAlgorithmGraphJSON())
+
+	// Shortest path avoids the heavy 1-3 edge.
+	var path labelPath
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", baseURL+"/shortestpath/1/4", nil), &path); err != nil {
+		t.Fatalf("Couldn't parse shortest path: %v\n", err)
+	}
+	if path.Distance != 2.5 {
+		t.Errorf("Expected shortest path distance 2.5, got %g\n", path.Distance)
+	}
+	var pathIDs []dvid.VertexID
+	for _, vertex := range path.Vertices {
+		pathIDs = append(pathIDs, vertex.Id)
+	}
+	if !reflect.DeepEqual(pathIDs, []dvid.VertexID{1, 2, 3, 4}) {
+		t.Errorf("Expected shortest path 1-2-3-4, got %v\n", pathIDs)
+	}
+	if len(path.Edges) != 3 || path.Edges[2] != (labelEdge{3, 4, 0.5}) {
+		t.Errorf("Bad shortest path edges: %v\n", path.Edges)
+	}
+	server.TestBadHTTP(t, "GET", baseURL+"/shortestpath/1/5", nil)
+
+	// Connected components under various thresholds.
+	tests := []struct {
+		query    string
+		expected [][]dvid.VertexID
+	}{
+		{"", [][]dvid.VertexID{{1, 2, 3, 4}, {5}}},
+		{"?threshold=1", [][]dvid.VertexID{{1, 2, 3, 4}, {5}}},
+		{"?threshold=0.6", [][]dvid.VertexID{{4, 3}, {1}, {2}, {5}}},
+		{"?threshold=1&above=true", [][]dvid.VertexID{{4}, {1, 2, 3}, {5}}},
+	}
+	for _, tc := range tests {
+		var resp struct{ Components []labelComponent }
+		if err := json.Unmarshal(server.TestHTTP(t, "GET", baseURL+"/components"+tc.query, nil), &resp); err != nil {
+			t.Fatalf("Couldn't parse components: %v\n", err)
+		}
+		var got [][]dvid.VertexID
+		for _, component := range resp.Components {
+			got = append(got, component.Vertices)
+		}
+		for _, expected := range tc.expected {
+			sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Expected components %v for query %q, got %v\n", tc.expected, tc.query, got)
+		}
+	}
+
+	// Merge proposals with and without size prior.
+	getProposals := func(query string) []mergeProposal {
+		var resp struct{ Proposals []mergeProposal }
+		if err := json.Unmarshal(server.TestHTTP(t, "GET", baseURL+"/proposals"+query, nil), &resp); err != nil {
+			t.Fatalf("Couldn't parse proposals: %v\n", err)
+		}
+		return resp.Proposals
+	}
+	proposals := getProposals("?sizeprior=false")
+	if len(proposals) != 4 || proposals[0].Weight != 5 || proposals[3].Weight != 0.5 {
+		t.Errorf("Bad proposals ranked by weight: %v\n", proposals)
+	}
+	proposals = getProposals("?n=2")
+	if len(proposals) != 2 {
+		t.Fatalf("Expected 2 proposals, got %v\n", proposals)
+	}
+	if proposals[0].Weight != 5 || proposals[1].Id1+proposals[1].Id2 != 3 {
+		t.Errorf("Expected 1-3 then 1-2 proposals with size prior, got %v\n", proposals)
+	}
+	if proposals[1].Size1+proposals[1].Size2 != 15 {
+		t.Errorf("Bad vertex sizes in proposal: %v\n", proposals[1])
+	}
+	proposals = getProposals("?order=asc&minsize=5")
+	if len(proposals) != 1 || proposals[0].Weight != 1 {
+		t.Errorf("Expected only 1-2 proposal with minsize 5, got %v\n", proposals)
+	}
+}
+
+// returns the vertex weight and edge weights of a vertex's neighbors.
+func getNeighbors(t *testing.T, uuid dvid.UUID, id dvid.VertexID) (float64, map[dvid.VertexID]float64) {
+	url := fmt.Sprintf("%snode/%s/lg/neighbors/%d", server.WebAPIPath, uuid, id)
+	graph, err := loadGraphJSON(server.TestHTTP(t, "GET", url, nil))
+	if err != nil {
+		t.Fatalf("Couldn't parse neighbors of vertex %d: %v\n", id, err)
+	}
+	edges := make(map[dvid.VertexID]float64)
+	for _, edge := range graph.Edges {
+		if edge.Id1 == id {
+			edges[edge.Id2] = edge.Weight
+		} else {
+			edges[edge.Id1] = edge.Weight
+		}
+	}
+	return graph.Vertices[0].Weight, edges
+}
+
+func TestLabelgraphSync(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "labelmap", "labels", config)
+	config.Set("EdgeCombine", "max")
+	server.CreateTestInstance(t, uuid, "labelgraph", "lg", config)
+	server.CreateTestSync(t, uuid, "lg", "labels")
+
+	graph := LabelGraph{
+		Vertices: []labelVertex{{1, 10}, {2, 20}, {3, 5}, {4, 7}},
+		Edges:    []labelEdge{{1, 2, 1}, {1, 3, 2}, {2, 3, 5}, {2, 4, 3}},
+	}
+	jsonBytes, err := json.Marshal(graph)
+	if err != nil {
+		t.Fatalf("Can't encode graph into JSON: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", fmt.Sprintf("%snode/%s/lg/subgraph", server.WebAPIPath, uuid), bytes.NewBuffer(jsonBytes))
+
+	// Labels 1-4 each have a quarter of the voxels.
+	n := 64
+	voxels := make([]byte, n*n*n*8)
+	for i := 0; i < n*n*n; i++ {
+		binary.LittleEndian.PutUint64(voxels[i*8:i*8+8], uint64((i%4)+1))
+	}
+	apiStr := fmt.Sprintf("%snode/%s/labels/raw/0_1_2/%d_%d_%d/0_0_0", server.WebAPIPath, uuid, n, n, n)
+	server.TestHTTP(t, "POST", apiStr, bytes.NewBuffer(voxels))
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// Merge 2 into 1, combining edges to 3 with the max rule.
+	apiStr = fmt.Sprintf("%snode/%s/labels/merge", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", apiStr, bytes.NewBufferString("[1, 2]"))
+	if err := datastore.BlockOnUpdating(uuid, "lg"); err != nil {
+		t.Fatalf("Error blocking on sync of labelgraph: %v\n", err)
+	}
+	weight, edges := getNeighbors(t, uuid, 1)
+	if weight != 30 {
+		t.Errorf("Expected merged vertex weight 30, got %g\n", weight)
+	}
+	if !reflect.DeepEqual(edges, map[dvid.VertexID]float64{3: 5, 4: 3}) {
+		t.Errorf("Bad edges after merge: %v\n", edges)
+	}
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/lg/neighbors/2", server.WebAPIPath, uuid), nil)
+
+	// Cleave supervoxel 2 off body 1, which splits the vertex weight in half.
+	apiStr = fmt.Sprintf("%snode/%s/labels/cleave/1", server.WebAPIPath, uuid)
+	var cleaveResp struct {
+		CleavedLabel uint64
+	}
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", apiStr, bytes.NewBufferString("[2]")), &cleaveResp); err != nil {
+		t.Fatalf("Couldn't parse cleave response: %v\n", err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "lg"); err != nil {
+		t.Fatalf("Error blocking on sync of labelgraph: %v\n", err)
+	}
+	weight, edges = getNeighbors(t, uuid, 1)
+	if weight != 15 {
+		t.Errorf("Expected cleaved vertex weight 15, got %g\n", weight)
+	}
+	cleavedWeight, cleavedEdges := getNeighbors(t, uuid, dvid.VertexID(cleaveResp.CleavedLabel))
+	if cleavedWeight != 15 {
+		t.Errorf("Expected new vertex weight 15, got %g\n", cleavedWeight)
+	}
+	if !reflect.DeepEqual(cleavedEdges, edges) {
+		t.Errorf("Expected cleaved vertex edges %v, got %v\n", edges, cleavedEdges)
+	}
+
+	// Only labelmap instances can be synced and edge combination rules are checked.
+	config.Clear()
+	config.Set("EdgeCombine", "average")
+	if _, err := datastore.NewData(uuid, dtype, "badrule", config); err == nil {
+		t.Errorf("Expected error for unknown edge combination rule\n")
+	}
+	server.TestBadHTTP(t, "POST", fmt.Sprintf("%snode/%s/lg/sync", server.WebAPIPath, uuid), bytes.NewBufferString(`{"sync": "lg"}`))
+}
@@ -0,0 +1,290 @@
+/*
+	This file supports labelgraph instances synced to labelmap instances, where vertex IDs
+	are body labels.  Merges, cleaves, and splits of bodies are applied to the graph.
+*/
+
+package labelgraph
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/datatype/labelmap"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Number of change messages we can buffer before blocking on sync channel.
+const syncBufferSize = 1000
+
+// returns the function combining the weights of two edges to the same vertex.
+func edgeCombiner(rule string) (func(w1, w2 float64) float64, error) {
+	switch rule {
+	case "", "sum":
+		return func(w1, w2 float64) float64 { return w1 + w2 }, nil
+	case "max":
+		return math.Max, nil
+	case "min":
+		return math.Min, nil
+	default:
+		return nil, fmt.Errorf("unknown edge combination rule %q, must be \"sum\", \"max\", or \"min\"", rule)
+	}
+}
+
+// InitDataHandlers launches goroutines to handle each labelmap instance's syncs.
+func (d *Data) InitDataHandlers() error {
+	if d.syncCh != nil || d.syncDone != nil {
+		return nil
+	}
+	d.syncCh = make(chan datastore.SyncMessage, syncBufferSize)
+	d.syncDone = make(chan *sync.WaitGroup)
+
+	// Launch handlers of sync events.
+	dvid.Infof("Launching sync event handler for data %q...\n", d.DataName())
+	go d.processEvents()
+	return nil
+}
+
+// Shutdown terminates blocks until syncs are done then terminates background goroutines processing data.
+func (d *Data) Shutdown(wg *sync.WaitGroup) {
+	if d.syncDone != nil {
+		dwg := new(sync.WaitGroup)
+		dwg.Add(1)
+		d.syncDone <- dwg
+		dwg.Wait() // Block until we are done.
+	}
+	wg.Done()
+}
+
+// GetSyncSubs implements the datastore.Syncer interface.  Returns a list of subscriptions
+// to the sync data instance that will notify the receiver.
+func (d *Data) GetSyncSubs(synced dvid.Data) (subs datastore.SyncSubs, err error) {
+	if synced.TypeName() != "labelmap" {
+		err = fmt.Errorf("labelgraph instances can only be synced to labelmap instances, not %q (type %s)", synced.DataName(), synced.TypeName())
+		return
+	}
+	if d.syncCh == nil {
+		if err = d.InitDataHandlers(); err != nil {
+			err = fmt.Errorf("unable to initialize handlers for data %q: %v", d.DataName(), err)
+			return
+		}
+	}
+	for _, event := range []string{labels.MergeBlockEvent, labels.CleaveLabelEvent, labels.SplitLabelEvent} {
+		subs = append(subs, datastore.SyncSub{
+			Event:  datastore.SyncEvent{Data: synced.DataUUID(), Event: event},
+			Notify: d.DataUUID(),
+			Ch:     d.syncCh,
+		})
+	}
+	return
+}
+
+func (d *Data) processEvents() {
+	defer func() {
+		if e := recover(); e != nil {
+			msg := fmt.Sprintf("Panic detected on labelgraph sync thread: %+v\n", e)
+			dvid.ReportPanic(msg, server.WebServer())
+		}
+	}()
+	var stop bool
+	var wg *sync.WaitGroup
+	for {
+		select {
+		case wg = <-d.syncDone:
+			queued := len(d.syncCh)
+			if queued > 0 {
+				dvid.Infof("Received shutdown signal for %q sync events (%d in queue)\n", d.DataName(), queued)
+				stop = true
+			} else {
+				dvid.Infof("Shutting down sync event handler for instance %q...\n", d.DataName())
+				wg.Done()
+				return
+			}
+		case msg := <-d.syncCh:
+			d.handleSyncMessage(msg)
+
+			if stop && len(d.syncCh) == 0 {
+				dvid.Infof("Shutting down sync event handler for instance %q after draining sync events.\n", d.DataName())
+				wg.Done()
+				return
+			}
+		}
+	}
+}
+
+func (d *Data) handleSyncMessage(msg datastore.SyncMessage) {
+	d.StartUpdate()
+	defer d.StopUpdate()
+
+	db, err := storage.GraphStore()
+	if err != nil {
+		dvid.Errorf("unable to get graph store for labelgraph %q sync: %v\n", d.DataName(), err)
+		return
+	}
+
+	// Wait for any bulk transaction since sync events can't be dropped.
+	for !d.setBusy() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer d.setNotBusy()
+
+	ctx := datastore.NewVersionedCtx(d, msg.Version)
+	switch delta := msg.Delta.(type) {
+	case labels.DeltaMerge:
+		var merged []dvid.VertexID
+		for label := range delta.Merged {
+			merged = append(merged, dvid.VertexID(label))
+		}
+		err = d.mergeVertices(ctx, db, dvid.VertexID(delta.Target), merged)
+	case labels.CleaveOp:
+		err = d.splitVertex(ctx, db, msg.Version, delta.Target, delta.CleavedLabel)
+	case labels.DeltaSplit:
+		err = d.splitVertex(ctx, db, msg.Version, delta.OldLabel, delta.NewLabel)
+	default:
+		dvid.Criticalf("Received unknown delta in labelgraph %q sync: %v\n", d.DataName(), msg)
+		return
+	}
+	if err != nil {
+		dvid.Errorf("unable to apply %s to labelgraph %q: %v\n", msg.Event, d.DataName(), err)
+	}
+}
+
+// mergeVertices merges vertices into the target vertex, summing vertex weights and
+// combining the weights of edges to the same vertex with the instance's EdgeCombine rule.
+// Merged vertices not in the graph are ignored.
+func (d *Data) mergeVertices(ctx storage.Context, db storage.GraphDB, target dvid.VertexID, merged []dvid.VertexID) error {
+	combine, err := edgeCombiner(d.EdgeCombine)
+	if err != nil {
+		return err
+	}
+	mergedSet := make(map[dvid.VertexID]struct{}, len(merged))
+	var mergedVertices []dvid.GraphVertex
+	for _, id := range merged {
+		mergedSet[id] = struct{}{}
+		if vertex, err := db.GetVertex(ctx, id); err == nil {
+			mergedVertices = append(mergedVertices, vertex)
+		}
+	}
+	if len(mergedVertices) == 0 {
+		return nil
+	}
+
+	weight := float64(0)
+	targetEdges := make(map[dvid.VertexID]float64)
+	if targetVertex, err := db.GetVertex(ctx, target); err != nil {
+		if err := db.AddVertex(ctx, target, 0); err != nil {
+			return fmt.Errorf("Failed to add vertex %d: %v", target, err)
+		}
+	} else {
+		weight = targetVertex.Weight
+		for _, id2 := range targetVertex.Vertices {
+			edge, err := db.GetEdge(ctx, target, id2)
+			if err != nil {
+				return fmt.Errorf("Failed to retrieve edge %d-%d: %v", target, id2, err)
+			}
+			targetEdges[id2] = edge.Weight
+		}
+	}
+
+	// Combine the edges of the merged vertices to vertices outside the merge.
+	edges := make(map[dvid.VertexID]float64, len(targetEdges))
+	for id2, edgeWeight := range targetEdges {
+		if _, found := mergedSet[id2]; !found {
+			edges[id2] = edgeWeight
+		}
+	}
+	for _, vertex := range mergedVertices {
+		weight += vertex.Weight
+		for _, id2 := range vertex.Vertices {
+			if _, found := mergedSet[id2]; found || id2 == target {
+				continue
+			}
+			edge, err := db.GetEdge(ctx, vertex.Id, id2)
+			if err != nil {
+				return fmt.Errorf("Failed to retrieve edge %d-%d: %v", vertex.Id, id2, err)
+			}
+			if cur, found := edges[id2]; found {
+				edges[id2] = combine(cur, edge.Weight)
+			} else {
+				edges[id2] = edge.Weight
+			}
+		}
+	}
+
+	// Removing the merged vertices also removes their edges.
+	for _, vertex := range mergedVertices {
+		if err := db.RemoveVertex(ctx, vertex.Id); err != nil {
+			return fmt.Errorf("Failed to remove vertex %d: %v", vertex.Id, err)
+		}
+	}
+	for id2, edgeWeight := range edges {
+		if _, found := targetEdges[id2]; found {
+			err = db.SetEdgeWeight(ctx, target, id2, edgeWeight)
+		} else {
+			err = db.AddEdge(ctx, target, id2, edgeWeight)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to set edge %d-%d: %v", target, id2, err)
+		}
+	}
+	if err := db.SetVertexWeight(ctx, target, weight); err != nil {
+		return fmt.Errorf("Failed to update weight on vertex %d: %v", target, err)
+	}
+	return nil
+}
+
+// splitVertex adds a vertex for a label split from the original label.  The new vertex gets
+// the edges of the original vertex and the original vertex weight is divided in proportion
+// to the current voxel counts of the two labels in the synced labelmap.
+func (d *Data) splitVertex(ctx storage.Context, db storage.GraphDB, v dvid.VersionID, original, split uint64) error {
+	vertex, err := db.GetVertex(ctx, dvid.VertexID(original))
+	if err != nil {
+		return nil // original label isn't in graph.
+	}
+	fraction := 0.5
+	if lmap := d.getSyncedLabels(); lmap != nil {
+		sizes, err := labelmap.GetLabelSizes(lmap, v, []uint64{original, split}, false)
+		if err != nil {
+			return err
+		}
+		if total := sizes[0] + sizes[1]; total != 0 {
+			fraction = float64(sizes[1]) / float64(total)
+		}
+	}
+
+	if err := db.AddVertex(ctx, dvid.VertexID(split), vertex.Weight*fraction); err != nil {
+		return fmt.Errorf("Failed to add vertex %d: %v", split, err)
+	}
+	if err := db.SetVertexWeight(ctx, vertex.Id, vertex.Weight*(1-fraction)); err != nil {
+		return fmt.Errorf("Failed to update weight on vertex %d: %v", vertex.Id, err)
+	}
+	for _, id2 := range vertex.Vertices {
+		if id2 == dvid.VertexID(split) {
+			continue
+		}
+		edge, err := db.GetEdge(ctx, vertex.Id, id2)
+		if err != nil {
+			return fmt.Errorf("Failed to retrieve edge %d-%d: %v", vertex.Id, id2, err)
+		}
+		if err := db.AddEdge(ctx, dvid.VertexID(split), id2, edge.Weight); err != nil {
+			return fmt.Errorf("Failed to add edge %d-%d: %v", split, id2, err)
+		}
+	}
+	return nil
+}
+
+// returns the synced labelmap instance or nil if there is none.
+func (d *Data) getSyncedLabels() *labelmap.Data {
+	for dataUUID := range d.SyncedData() {
+		ldata, err := labelmap.GetByDataUUID(dataUUID)
+		if err == nil {
+			return ldata
+		}
+	}
+	return nil
+}
@@ -0,0 +1,442 @@
+/*
+	This file supports point-in-time reads of a version using its mutation log.
+*/
+
+package labelmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/datatype/common/proto"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// records the current time for a mutation so as_of timestamps can be matched to
+// positions in the mutation log.
+func storeMutationTime(d dvid.Data, v dvid.VersionID, mutID uint64) error {
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(time.Now().UnixNano()))
+	ctx := datastore.NewVersionedCtx(d, v)
+	if err := store.Put(ctx, NewMutationTimeTKey(mutID), buf); err != nil {
+		return fmt.Errorf("unable to store time of mutation %d: %v", mutID, err)
+	}
+	return nil
+}
+
+// returns the time a mutation was logged or false if no time was recorded.
+func getMutationTime(ctx *datastore.VersionedCtx, store storage.OrderedKeyValueDB, mutID uint64) (t time.Time, found bool, err error) {
+	var data []byte
+	if data, err = store.Get(ctx, NewMutationTimeTKey(mutID)); err != nil {
+		return
+	}
+	if len(data) != 8 {
+		return
+	}
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(data))), true, nil
+}
+
+var blockWriteMu sync.Mutex
+
+// records the mutation ID and time of a block write that isn't in the mutation log, keeping
+// the latest of each, so as_of reads of voxels from before the write can be rejected.
+func storeBlockWrite(d dvid.Data, v dvid.VersionID, mutID uint64) error {
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	blockWriteMu.Lock()
+	defer blockWriteMu.Unlock()
+
+	ctx := datastore.NewVersionedCtx(d, v)
+	lastMutID, lastTime, found, err := getBlockWrite(ctx, store)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if found && lastMutID > mutID {
+		mutID = lastMutID
+	}
+	if found && lastTime.After(now) {
+		now = lastTime
+	}
+	buf := make([]byte, 16)
+	binary.LittleEndian.PutUint64(buf[0:8], mutID)
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(now.UnixNano()))
+	if err := store.Put(ctx, blockWriteTKey, buf); err != nil {
+		return fmt.Errorf("unable to store block write of mutation %d: %v", mutID, err)
+	}
+	return nil
+}
+
+// returns the mutation ID and time of the last block write outside the mutation log or
+// false if no block write was recorded.
+func getBlockWrite(ctx *datastore.VersionedCtx, store storage.OrderedKeyValueDB) (mutID uint64, t time.Time, found bool, err error) {
+	var data []byte
+	if data, err = store.Get(ctx, blockWriteTKey); err != nil {
+		return
+	}
+	if len(data) != 16 {
+		return
+	}
+	mutID = binary.LittleEndian.Uint64(data[0:8])
+	t = time.Unix(0, int64(binary.LittleEndian.Uint64(data[8:16])))
+	return mutID, t, true, nil
+}
+
+// returns an error if blocks were written after the point in time, since the mutation log
+// can't reverse voxel writes.
+func (d *Data) checkBlockWritesAsOf(v dvid.VersionID, asOf dvid.AsOf) error {
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	mutID, t, found, err := getBlockWrite(datastore.NewVersionedCtx(d, v), store)
+	if err != nil || !found {
+		return err
+	}
+	if (asOf.Time.IsZero() && mutID > asOf.MutID) || (!asOf.Time.IsZero() && t.After(asOf.Time)) {
+		return fmt.Errorf("voxels were written by mutation %d after %s, so labels at that time can't be reconstructed", mutID, asOf)
+	}
+	return nil
+}
+
+// asOfState holds what is needed to reconstruct the supervoxel mapping of a version at an
+// earlier point in its mutation log.  Mutations are only undone with respect to the mapping
+// and supervoxel splits, so reads of voxels first check no blocks were written afterward.
+type asOfState struct {
+	v        dvid.VersionID
+	svmap    *SVMap
+	mapping  map[uint64]uint64   // supervoxel mappings logged in the version up to the point in time
+	origSV   map[uint64]uint64   // supervoxels created by later splits -> supervoxel split
+	children map[uint64][]uint64 // supervoxels split later -> split and remain supervoxels
+	touched  labels.Set          // labels modified by later mutations
+}
+
+// returns the state of a version at the given point in its mutation log.
+func (d *Data) getAsOfState(v dvid.VersionID, asOf dvid.AsOf) (*asOfState, error) {
+	svmap, err := getMapping(d, v)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := d.getLogMessages(v)
+	if err != nil {
+		return nil, err
+	}
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, err
+	}
+	ctx := datastore.NewVersionedCtx(d, v)
+
+	// Find the first log message after the point in time.  Logged ops without a recorded
+	// time, e.g., ingested mappings, are considered to occur at the time of the prior op.
+	cutoff := len(msgs)
+	var lastTime time.Time
+	times := make(map[uint64]time.Time)
+	for i, msg := range msgs {
+		op, ok, err := getLoggedOp(msg)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read mutation log entry: %v", err)
+		}
+		if !ok {
+			continue
+		}
+		var after bool
+		if asOf.Time.IsZero() {
+			after = op.mutID > asOf.MutID
+		} else {
+			t, found := times[op.mutID]
+			if !found {
+				if t, found, err = getMutationTime(ctx, store, op.mutID); err != nil {
+					return nil, err
+				}
+				if found {
+					times[op.mutID] = t
+				}
+			}
+			if found {
+				lastTime = t
+			}
+			after = lastTime.After(asOf.Time)
+		}
+		if after {
+			cutoff = i
+			break
+		}
+	}
+
+	state := &asOfState{
+		v:        v,
+		svmap:    svmap,
+		mapping:  make(map[uint64]uint64),
+		origSV:   make(map[uint64]uint64),
+		children: make(map[uint64][]uint64),
+		touched:  make(labels.Set),
+	}
+	for _, msg := range msgs[:cutoff] {
+		if msg.EntryType != proto.MappingOpType {
+			continue
+		}
+		var op proto.MappingOp
+		if err := op.Unmarshal(msg.Data); err != nil {
+			return nil, err
+		}
+		for _, supervoxel := range op.Original {
+			state.mapping[supervoxel] = op.Mapped
+		}
+	}
+	for _, msg := range msgs[cutoff:] {
+		op, ok, err := getLoggedOp(msg)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read mutation log entry: %v", err)
+		}
+		if !ok {
+			continue
+		}
+		for _, label := range op.labels {
+			if label != 0 {
+				state.touched[label] = struct{}{}
+			}
+		}
+		switch msg.EntryType {
+		case proto.SplitOpType:
+			var splitOp proto.SplitOp
+			if err := splitOp.Unmarshal(msg.Data); err != nil {
+				return nil, err
+			}
+			for supervoxel, svsplit := range splitOp.Svsplits {
+				state.addSplit(supervoxel, svsplit.Splitlabel, svsplit.Remainlabel)
+			}
+		case proto.SupervoxelSplitType:
+			var svSplitOp proto.SupervoxelSplitOp
+			if err := svSplitOp.Unmarshal(msg.Data); err != nil {
+				return nil, err
+			}
+			state.addSplit(svSplitOp.Supervoxel, svSplitOp.Splitlabel, svSplitOp.Remainlabel)
+		case proto.MappingOpType:
+			var mappingOp proto.MappingOp
+			if err := mappingOp.Unmarshal(msg.Data); err != nil {
+				return nil, err
+			}
+			if mappingOp.Mapped == 0 {
+				continue
+			}
+			for _, supervoxel := range mappingOp.Original {
+				if mapped, found := state.mapping[supervoxel]; found && mapped == 0 {
+					return nil, fmt.Errorf("supervoxel %d was removed before %s and restored by mutation %d, so its voxels at that time can't be reconstructed", supervoxel, asOf, mappingOp.Mutid)
+				}
+			}
+		}
+	}
+	return state, nil
+}
+
+func (s *asOfState) addSplit(supervoxel, split, remain uint64) {
+	if _, found := s.origSV[split]; found {
+		return // supervoxel split logged more than once
+	}
+	s.origSV[split] = supervoxel
+	s.origSV[remain] = supervoxel
+	s.children[supervoxel] = append(s.children[supervoxel], split, remain)
+}
+
+// returns the supervoxel at the point in time from which a current supervoxel derives.
+func (s *asOfState) originalSupervoxel(supervoxel uint64) uint64 {
+	for {
+		orig, found := s.origSV[supervoxel]
+		if !found {
+			return supervoxel
+		}
+		supervoxel = orig
+	}
+}
+
+// returns the label of a supervoxel at the point in time.
+func (s *asOfState) mappedLabel(supervoxel uint64) (uint64, error) {
+	if supervoxel == 0 {
+		return 0, nil
+	}
+	if _, found := s.origSV[supervoxel]; found {
+		return 0, nil // supervoxel didn't exist yet
+	}
+	if label, found := s.mapping[supervoxel]; found {
+		return label, nil
+	}
+	return s.svmap.ancestorMappedLabel(s.v, supervoxel)
+}
+
+// returns the label at the point in time for a current supervoxel.
+func (s *asOfState) currentMappedLabel(supervoxel uint64) (uint64, error) {
+	return s.mappedLabel(s.originalSupervoxel(supervoxel))
+}
+
+// returns the current supervoxels derived from a supervoxel at the point in time.
+func (s *asOfState) currentSupervoxels(supervoxel uint64) []uint64 {
+	var current []uint64
+	stack := []uint64{supervoxel}
+	for len(stack) != 0 {
+		sv := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if children, found := s.children[sv]; found {
+			stack = append(stack, children...)
+		} else {
+			current = append(current, sv)
+		}
+	}
+	return current
+}
+
+// GetLabelPointsAsOf returns the labels or supervoxels at the given points as of an earlier
+// point in the version's mutation log.
+func (d *Data) GetLabelPointsAsOf(v dvid.VersionID, asOf dvid.AsOf, pts []dvid.Point3d, scale uint8, isSupervoxel bool) ([]uint64, error) {
+	if err := d.checkBlockWritesAsOf(v, asOf); err != nil {
+		return nil, err
+	}
+	state, err := d.getAsOfState(v, asOf)
+	if err != nil {
+		return nil, err
+	}
+	supervoxels, err := d.GetLabelPoints(v, pts, scale, true)
+	if err != nil {
+		return nil, err
+	}
+	mapped := make([]uint64, len(supervoxels))
+	for i, supervoxel := range supervoxels {
+		orig := state.originalSupervoxel(supervoxel)
+		if isSupervoxel {
+			mapped[i] = orig
+		} else if mapped[i], err = state.mappedLabel(orig); err != nil {
+			return nil, err
+		}
+	}
+	return mapped, nil
+}
+
+// GetMappedLabelsAsOf returns the labels of the given supervoxels as of an earlier point in
+// the version's mutation log.  Supervoxels that did not exist at that time are mapped to 0.
+func (d *Data) GetMappedLabelsAsOf(v dvid.VersionID, asOf dvid.AsOf, supervoxels []uint64) ([]uint64, error) {
+	state, err := d.getAsOfState(v, asOf)
+	if err != nil {
+		return nil, err
+	}
+	mapped := make([]uint64, len(supervoxels))
+	for i, supervoxel := range supervoxels {
+		if mapped[i], err = state.mappedLabel(supervoxel); err != nil {
+			return nil, err
+		}
+	}
+	return mapped, nil
+}
+
+// returns an index limited to the current supervoxels that made up the given label or
+// supervoxel at the point in time.
+func (d *Data) getAsOfIndex(state *asOfState, label uint64, isSupervoxel bool) (*labels.Index, labels.Set, error) {
+	selected := make(labels.Set)
+	var indices []*labels.Index
+	if isSupervoxel {
+		if mapped, err := state.mappedLabel(label); err != nil || mapped == 0 {
+			return nil, nil, err
+		}
+		for _, supervoxel := range state.currentSupervoxels(label) {
+			idx, err := GetLabelIndex(d, state.v, supervoxel, true)
+			if err != nil {
+				return nil, nil, err
+			}
+			if idx != nil {
+				selected[supervoxel] = struct{}{}
+				indices = append(indices, idx)
+			}
+		}
+	} else {
+		candidates := labels.Set{label: struct{}{}}
+		for touched := range state.touched {
+			candidates[touched] = struct{}{}
+		}
+		for candidate := range candidates {
+			idx, err := GetLabelIndex(d, state.v, candidate, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			if idx == nil {
+				continue
+			}
+			var found bool
+			for supervoxel := range idx.GetSupervoxels() {
+				mapped, err := state.currentMappedLabel(supervoxel)
+				if err != nil {
+					return nil, nil, err
+				}
+				if mapped == label {
+					selected[supervoxel] = struct{}{}
+					found = true
+				}
+			}
+			if found {
+				indices = append(indices, idx)
+			}
+		}
+	}
+
+	asOfIdx := new(labels.Index)
+	asOfIdx.Label = label
+	asOfIdx.Blocks = make(map[uint64]*proto.SVCount)
+	for _, idx := range indices {
+		for zyx, svc := range idx.Blocks {
+			if svc == nil {
+				continue
+			}
+			for supervoxel, count := range svc.Counts {
+				if _, found := selected[supervoxel]; !found {
+					continue
+				}
+				asOfSVC, found := asOfIdx.Blocks[zyx]
+				if !found {
+					asOfSVC = &proto.SVCount{Counts: make(map[uint64]uint32)}
+					asOfIdx.Blocks[zyx] = asOfSVC
+				}
+				asOfSVC.Counts[supervoxel] = count
+			}
+		}
+	}
+	if len(asOfIdx.Blocks) == 0 {
+		return nil, nil, nil
+	}
+	return asOfIdx, selected, nil
+}
+
+// writes the legacy RLE sparse volume of a label or supervoxel as of an earlier point in
+// the version's mutation log.
+func (d *Data) writeLegacyRLEAsOf(ctx *datastore.VersionedCtx, asOf dvid.AsOf, label uint64, scale uint8, b dvid.Bounds, compression string, isSupervoxel bool, w io.Writer) (found bool, err error) {
+	if err = d.checkBlockWritesAsOf(ctx.VersionID(), asOf); err != nil {
+		return
+	}
+	var state *asOfState
+	if state, err = d.getAsOfState(ctx.VersionID(), asOf); err != nil {
+		return
+	}
+	idx, supervoxels, err := d.getAsOfIndex(state, label, isSupervoxel)
+	if err != nil || idx == nil {
+		return
+	}
+	var data []byte
+	if data, err = d.getIndexRLEs(ctx, idx, supervoxels, scale, b); err != nil {
+		return
+	}
+	if len(data) == 0 {
+		return
+	}
+	found = true
+	err = writeCompressedRLE(data, compression, w)
+	return
+}
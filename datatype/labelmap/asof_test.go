@@ -0,0 +1,182 @@
+package labelmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func getLabelsAsOf(t *testing.T, uuid dvid.UUID, asOf string, supervoxels bool, pts string) []uint64 {
+	url := fmt.Sprintf("%snode/%s/labels/labels?as_of=%s&supervoxels=%t", server.WebAPIPath, uuid, asOf, supervoxels)
+	var got []uint64
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", url, strings.NewReader(pts)), &got); err != nil {
+		t.Fatalf("bad labels response as of %s: %v\n", asOf, err)
+	}
+	return got
+}
+
+func getMappingAsOf(t *testing.T, uuid dvid.UUID, asOf string, supervoxels string) []uint64 {
+	url := fmt.Sprintf("%snode/%s/labels/mapping?as_of=%s", server.WebAPIPath, uuid, asOf)
+	var got []uint64
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", url, strings.NewReader(supervoxels)), &got); err != nil {
+		t.Fatalf("bad mapping response as of %s: %v\n", asOf, err)
+	}
+	return got
+}
+
+// returns the # of voxels in the sparse volume of a label as of a point in time or 0 if not found.
+func sparsevolVoxelsAsOf(t *testing.T, uuid dvid.UUID, label uint64, asOf string, supervoxels bool) uint64 {
+	url := fmt.Sprintf("%snode/%s/labels/sparsevol/%d?as_of=%s&supervoxels=%t", server.WebAPIPath, uuid, label, asOf, supervoxels)
+	resp := server.TestHTTPResponse(t, "GET", url, nil)
+	if resp.Code == http.StatusNotFound {
+		return 0
+	}
+	if resp.Code != http.StatusOK {
+		t.Fatalf("bad status %d for sparsevol of label %d as of %s: %s\n", resp.Code, label, asOf, resp.Body.String())
+	}
+	data := resp.Body.Bytes()
+	if len(data) < 12 {
+		t.Fatalf("bad sparsevol of label %d as of %s: %d bytes\n", label, asOf, len(data))
+	}
+	var rles dvid.RLEs
+	if err := rles.UnmarshalBinary(data[12:]); err != nil {
+		t.Fatalf("unable to decode sparsevol of label %d as of %s: %v\n", label, asOf, err)
+	}
+	numVoxels, _ := rles.Stats()
+	return numVoxels
+}
+
+func TestAsOfReads(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelmap", "labels", config)
+
+	orig := newTestVolume(64, 64, 32)
+	orig.addSubvol(dvid.Point3d{0, 0, 0}, dvid.Point3d{32, 32, 32}, 1)
+	orig.addSubvol(dvid.Point3d{32, 0, 0}, dvid.Point3d{32, 32, 32}, 2)
+	orig.addSubvol(dvid.Point3d{0, 32, 0}, dvid.Point3d{64, 32, 32}, 3)
+	orig.put(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("error blocking on labels update: %v\n", err)
+	}
+	timeStr := func() string {
+		time.Sleep(5 * time.Millisecond)
+		defer time.Sleep(5 * time.Millisecond)
+		return time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	beforeMerge := timeStr()
+	mergeID := postMutation(t, uuid, "merge", bytes.NewBufferString("[1, 2]"))
+	afterMerge := timeStr()
+	splitID := postMutation(t, uuid, "split/3", subvolSparsevol(t, dvid.Point3d{0, 32, 0}, dvid.Point3d{16, 32, 32}))
+	beforeMergeID := fmt.Sprintf("%d", mergeID-1)
+	afterMergeID := fmt.Sprintf("%d", mergeID)
+
+	pts := "[[40, 5, 5], [5, 40, 5], [40, 40, 5]]"
+	current := getLabelsAsOf(t, uuid, fmt.Sprintf("%d", splitID), false, pts)
+	if current[0] != 1 || current[1] == 3 || current[2] != 3 {
+		t.Fatalf("unexpected labels after split: %v\n", current)
+	}
+	splitLabel := current[1]
+	for _, asOf := range []string{beforeMergeID, beforeMerge} {
+		if got := getLabelsAsOf(t, uuid, asOf, false, pts); !reflect.DeepEqual(got, []uint64{2, 3, 3}) {
+			t.Errorf("expected labels [2 3 3] as of %s, got %v\n", asOf, got)
+		}
+	}
+	for _, asOf := range []string{afterMergeID, afterMerge} {
+		if got := getLabelsAsOf(t, uuid, asOf, false, pts); !reflect.DeepEqual(got, []uint64{1, 3, 3}) {
+			t.Errorf("expected labels [1 3 3] as of %s, got %v\n", asOf, got)
+		}
+	}
+	if got := getLabelsAsOf(t, uuid, afterMergeID, true, pts); !reflect.DeepEqual(got, []uint64{2, 3, 3}) {
+		t.Errorf("expected supervoxels [2 3 3] as of merge, got %v\n", got)
+	}
+	url := fmt.Sprintf("%snode/%s/labels/label/5_40_5?as_of=%s", server.WebAPIPath, uuid, afterMergeID)
+	var single struct {
+		Label uint64
+	}
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", url, nil), &single); err != nil {
+		t.Fatal(err)
+	}
+	if single.Label != 3 {
+		t.Errorf("expected label 3 as of merge, got %d\n", single.Label)
+	}
+
+	// The split supervoxels didn't exist before the split.
+	splitSVs := getLabelsAsOf(t, uuid, fmt.Sprintf("%d", splitID), true, "[[5, 40, 5], [40, 40, 5]]")
+	svStr := fmt.Sprintf("[1, 2, %d, %d]", splitSVs[0], splitSVs[1])
+	if got := getMappingAsOf(t, uuid, beforeMergeID, svStr); !reflect.DeepEqual(got, []uint64{1, 2, 0, 0}) {
+		t.Errorf("expected mapping [1 2 0 0] before merge, got %v\n", got)
+	}
+	if got := getMappingAsOf(t, uuid, afterMergeID, svStr); !reflect.DeepEqual(got, []uint64{1, 1, 0, 0}) {
+		t.Errorf("expected mapping [1 1 0 0] after merge, got %v\n", got)
+	}
+	if got := getMappingAsOf(t, uuid, fmt.Sprintf("%d", splitID), svStr); !reflect.DeepEqual(got, []uint64{1, 1, splitLabel, 3}) {
+		t.Errorf("expected mapping [1 1 %d 3] after split, got %v\n", splitLabel, got)
+	}
+
+	// Sparse volumes are reconstructed from current supervoxels.
+	blockVoxels := uint64(32 * 32 * 32)
+	sparsevolTests := []struct {
+		label       uint64
+		asOf        string
+		supervoxels bool
+		expected    uint64
+	}{
+		{1, beforeMergeID, false, blockVoxels},
+		{2, beforeMergeID, false, blockVoxels},
+		{1, afterMergeID, false, 2 * blockVoxels},
+		{2, afterMergeID, false, 0},
+		{3, afterMergeID, false, 2 * blockVoxels},
+		{3, afterMergeID, true, 2 * blockVoxels},
+		{splitLabel, afterMergeID, false, 0},
+		{3, fmt.Sprintf("%d", splitID), false, 3 * blockVoxels / 2},
+		{splitLabel, fmt.Sprintf("%d", splitID), false, blockVoxels / 2},
+	}
+	for _, tc := range sparsevolTests {
+		if got := sparsevolVoxelsAsOf(t, uuid, tc.label, tc.asOf, tc.supervoxels); got != tc.expected {
+			t.Errorf("sparsevol of label %d (supervoxels %t) as of %s: expected %d voxels, got %d\n", tc.label, tc.supervoxels, tc.asOf, tc.expected, got)
+		}
+	}
+
+	url = fmt.Sprintf("%snode/%s/labels/sparsevol/1?as_of=%s&format=srles", server.WebAPIPath, uuid, afterMergeID)
+	server.TestBadHTTP(t, "GET", url, nil)
+	url = fmt.Sprintf("%snode/%s/labels/label/5_40_5?as_of=yesterday", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "GET", url, nil)
+
+	// Voxels from before a later block write can't be reconstructed, but mappings can.
+	relabeled := newTestVolume(32, 32, 32)
+	relabeled.addSubvol(dvid.Point3d{0, 0, 0}, dvid.Point3d{32, 32, 32}, 7)
+	relabeled.put(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("error blocking on labels update: %v\n", err)
+	}
+	afterWrite := timeStr()
+	for _, asOf := range []string{afterMergeID, afterMerge} {
+		url = fmt.Sprintf("%snode/%s/labels/labels?as_of=%s", server.WebAPIPath, uuid, asOf)
+		server.TestBadHTTP(t, "GET", url, bytes.NewBufferString(pts))
+		url = fmt.Sprintf("%snode/%s/labels/sparsevol/1?as_of=%s", server.WebAPIPath, uuid, asOf)
+		server.TestBadHTTP(t, "GET", url, nil)
+	}
+	if got := getMappingAsOf(t, uuid, afterMergeID, "[2]"); !reflect.DeepEqual(got, []uint64{1}) {
+		t.Errorf("expected mapping [1] of supervoxel 2 as of merge after block write, got %v\n", got)
+	}
+	if got := getLabelsAsOf(t, uuid, afterWrite, false, pts); !reflect.DeepEqual(got, []uint64{1, splitLabel, 3}) {
+		t.Errorf("expected labels [1 %d 3] as of block write, got %v\n", splitLabel, got)
+	}
+}
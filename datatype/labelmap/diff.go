@@ -0,0 +1,58 @@
+/*
+	This file supports finding the labels and blocks that differ between two versions.
+*/
+
+package labelmap
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// LabelDiff describes a label whose index differs between two versions.
+type LabelDiff struct {
+	Label  uint64              `json:"label"`
+	Change datastore.KeyChange `json:"change"`
+}
+
+// BlockDiff describes a label block that differs between two versions.
+type BlockDiff struct {
+	Block  dvid.ChunkPoint3d   `json:"block"`
+	Scale  uint8               `json:"scale"`
+	Change datastore.KeyChange `json:"change"`
+}
+
+// WriteDiff writes JSON lines for each label whose index was added, modified or deleted from
+// version vA to version vB, followed by the blocks at the given scale that differ if
+// withBlocks is true.  The number of labels and blocks written are returned.
+func (d *Data) WriteDiff(w io.Writer, vA, vB dvid.VersionID, scale uint8, withBlocks bool) (numLabels, numBlocks int, err error) {
+	enc := json.NewEncoder(w)
+	begTKey := NewLabelIndexTKey(0)
+	endTKey := NewLabelIndexTKey(math.MaxUint64)
+	err = datastore.DiffVersions(d, vA, vB, begTKey, endTKey, func(diff datastore.KeyDiff) error {
+		label, err := DecodeLabelIndexTKey(diff.TKey)
+		if err != nil {
+			return err
+		}
+		numLabels++
+		return enc.Encode(LabelDiff{Label: label, Change: diff.Change})
+	})
+	if err != nil || !withBlocks {
+		return
+	}
+	begTKey = NewBlockTKeyByCoord(scale, dvid.MinIndexZYX.ToIZYXString())
+	endTKey = NewBlockTKeyByCoord(scale, dvid.MaxIndexZYX.ToIZYXString())
+	err = datastore.DiffVersions(d, vA, vB, begTKey, endTKey, func(diff datastore.KeyDiff) error {
+		blockScale, idx, err := DecodeBlockTKey(diff.TKey)
+		if err != nil {
+			return err
+		}
+		numBlocks++
+		return enc.Encode(BlockDiff{Block: dvid.ChunkPoint3d(*idx), Scale: blockScale, Change: diff.Change})
+	})
+	return
+}
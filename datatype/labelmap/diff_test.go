@@ -0,0 +1,100 @@
+package labelmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// returns the label and block diffs between two versions.
+func getDiff(t *testing.T, uuidA, uuidB dvid.UUID, query string) (map[uint64]datastore.KeyChange, []BlockDiff) {
+	url := fmt.Sprintf("%snode/%s/labels/diff/%s%s", server.WebAPIPath, uuidA, uuidB, query)
+	labelDiffs := make(map[uint64]datastore.KeyChange)
+	var blockDiffs []BlockDiff
+	data := strings.TrimSpace(string(server.TestHTTP(t, "GET", url, nil)))
+	if len(data) == 0 {
+		return labelDiffs, blockDiffs
+	}
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, `{"label"`) {
+			var diff LabelDiff
+			if err := json.Unmarshal([]byte(line), &diff); err != nil {
+				t.Fatalf("bad label diff %q: %v\n", line, err)
+			}
+			labelDiffs[diff.Label] = diff.Change
+		} else {
+			var diff BlockDiff
+			if err := json.Unmarshal([]byte(line), &diff); err != nil {
+				t.Fatalf("bad block diff %q: %v\n", line, err)
+			}
+			blockDiffs = append(blockDiffs, diff)
+		}
+	}
+	return labelDiffs, blockDiffs
+}
+
+func TestDiff(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelmap", "labels", config)
+
+	orig := newTestVolume(64, 64, 32)
+	orig.addSubvol(dvid.Point3d{0, 0, 0}, dvid.Point3d{32, 32, 32}, 1)
+	orig.addSubvol(dvid.Point3d{32, 0, 0}, dvid.Point3d{32, 32, 32}, 2)
+	orig.addSubvol(dvid.Point3d{0, 32, 0}, dvid.Point3d{64, 32, 32}, 3)
+	orig.put(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("error blocking on labels update: %v\n", err)
+	}
+	if err := datastore.Commit(uuid, "parent", nil); err != nil {
+		t.Fatalf("unable to commit parent: %v\n", err)
+	}
+	child, err := datastore.NewVersion(uuid, "child", "", nil)
+	if err != nil {
+		t.Fatalf("unable to create child: %v\n", err)
+	}
+
+	// Merges only modify label indices.
+	postMutation(t, child, "merge", bytes.NewBufferString("[1, 2]"))
+	labelDiffs, blockDiffs := getDiff(t, uuid, child, "")
+	if len(labelDiffs) != 2 || labelDiffs[1] != datastore.KeyModified || labelDiffs[2] != datastore.KeyDeleted {
+		t.Fatalf("expected label 1 modified and 2 deleted after merge, got %v\n", labelDiffs)
+	}
+	if len(blockDiffs) != 0 {
+		t.Fatalf("expected no changed blocks after merge, got %v\n", blockDiffs)
+	}
+
+	// Splits relabel the split and remaining supervoxels within all blocks of the supervoxel.
+	postMutation(t, child, "split/3", subvolSparsevol(t, dvid.Point3d{0, 32, 0}, dvid.Point3d{16, 32, 32}))
+	if err := datastore.BlockOnUpdating(child, "labels"); err != nil {
+		t.Fatalf("error blocking on labels update: %v\n", err)
+	}
+	labelDiffs, blockDiffs = getDiff(t, uuid, child, "")
+	if len(labelDiffs) != 4 || labelDiffs[3] != datastore.KeyModified {
+		t.Fatalf("expected label 3 modified and a new split label after split, got %v\n", labelDiffs)
+	}
+	if len(blockDiffs) != 2 || blockDiffs[0].Block != (dvid.ChunkPoint3d{0, 1, 0}) || blockDiffs[1].Block != (dvid.ChunkPoint3d{1, 1, 0}) {
+		t.Fatalf("expected blocks (0,1,0) and (1,1,0) modified after split, got %v\n", blockDiffs)
+	}
+	if _, blockDiffs = getDiff(t, uuid, child, "?blocks=false"); len(blockDiffs) != 0 {
+		t.Fatalf("expected no block diffs when blocks=false, got %v\n", blockDiffs)
+	}
+	if labelDiffs, blockDiffs = getDiff(t, child, child, ""); len(labelDiffs) != 0 || len(blockDiffs) != 0 {
+		t.Fatalf("expected no diffs between same versions, got %v, %v\n", labelDiffs, blockDiffs)
+	}
+
+	url := fmt.Sprintf("%snode/%s/labels/diff", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "GET", url, nil)
+}
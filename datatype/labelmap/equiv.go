@@ -72,6 +72,9 @@ func (d *Data) DumpMutations(leafUUID dvid.UUID, filename string) (comment strin
 }
 
 func (d *Data) ingestMappings(ctx *datastore.VersionedCtx, mappings proto.MappingOps) error {
+	d.mutateMu.RLock()
+	defer d.mutateMu.RUnlock()
+
 	m, err := getMapping(d, ctx.VersionID())
 	if err != nil {
 		return err
@@ -188,53 +191,8 @@ func (svm *SVMap) initToVersion(d dvid.Data, v dvid.VersionID) error {
 			numMsgs := 0
 			for msg := range ch { // expects channel to be closed on completion
 				numMsgs++
-				switch msg.EntryType {
-				case proto.MappingOpType:
-					var op proto.MappingOp
-					if err := op.Unmarshal(msg.Data); err != nil {
-						dvid.Errorf("unable to unmarshal mapping log message for version %d: %v\n", ancestor, err)
-						wg.Done()
-						continue
-					}
-					mapped := op.GetMapped()
-					for _, supervoxel := range op.GetOriginal() {
-						svm.setMapping(vid, supervoxel, mapped)
-					}
-				case proto.SplitOpType:
-					var op proto.SplitOp
-					if err := op.Unmarshal(msg.Data); err != nil {
-						dvid.Errorf("unable to unmarshal split log message for version %d: %v\n", ancestor, err)
-						wg.Done()
-						continue
-					}
-					splits := svm.splits[vid]
-					for supervoxel, svsplit := range op.GetSvsplits() {
-						rec := proto.SupervoxelSplitOp{
-							Mutid:       op.Mutid,
-							Supervoxel:  supervoxel,
-							Remainlabel: svsplit.Remainlabel,
-							Splitlabel:  svsplit.Splitlabel,
-						}
-						splits = append(splits, rec)
-						svm.setMapping(vid, supervoxel, 0)
-					}
-					svm.splits[vid] = splits
-				case proto.SupervoxelSplitType:
-					var op proto.SupervoxelSplitOp
-					if err := op.Unmarshal(msg.Data); err != nil {
-						dvid.Errorf("unable to unmarshal split log message for version %d: %v\n", ancestor, err)
-						wg.Done()
-						continue
-					}
-					rec := proto.SupervoxelSplitOp{
-						Mutid:       op.Mutid,
-						Supervoxel:  op.Supervoxel,
-						Remainlabel: op.Remainlabel,
-						Splitlabel:  op.Splitlabel,
-					}
-					svm.splits[vid] = append(svm.splits[vid], rec)
-					svm.setMapping(vid, op.Supervoxel, 0)
-				default:
+				if err := svm.applyLogMessage(vid, msg); err != nil {
+					dvid.Errorf("unable to apply mutation log message for version %d: %v\n", ancestor, err)
 				}
 				wg.Done()
 			}
@@ -248,6 +206,55 @@ func (svm *SVMap) initToVersion(d dvid.Data, v dvid.VersionID) error {
 	return nil
 }
 
+// applyLogMessage modifies the mappings and splits for the given short version id using
+// a mutation log message.  Log entries that don't affect mappings are ignored.
+// receiver Lock should be provided outside.
+func (svm *SVMap) applyLogMessage(vid uint8, msg storage.LogMessage) error {
+	switch msg.EntryType {
+	case proto.MappingOpType:
+		var op proto.MappingOp
+		if err := op.Unmarshal(msg.Data); err != nil {
+			return fmt.Errorf("unable to unmarshal mapping log message: %v", err)
+		}
+		mapped := op.GetMapped()
+		for _, supervoxel := range op.GetOriginal() {
+			svm.setMapping(vid, supervoxel, mapped)
+		}
+	case proto.SplitOpType:
+		var op proto.SplitOp
+		if err := op.Unmarshal(msg.Data); err != nil {
+			return fmt.Errorf("unable to unmarshal split log message: %v", err)
+		}
+		splits := svm.splits[vid]
+		for supervoxel, svsplit := range op.GetSvsplits() {
+			rec := proto.SupervoxelSplitOp{
+				Mutid:       op.Mutid,
+				Supervoxel:  supervoxel,
+				Remainlabel: svsplit.Remainlabel,
+				Splitlabel:  svsplit.Splitlabel,
+			}
+			splits = append(splits, rec)
+			svm.setMapping(vid, supervoxel, 0)
+		}
+		svm.splits[vid] = splits
+	case proto.SupervoxelSplitType:
+		var op proto.SupervoxelSplitOp
+		if err := op.Unmarshal(msg.Data); err != nil {
+			return fmt.Errorf("unable to unmarshal supervoxel split log message: %v", err)
+		}
+		rec := proto.SupervoxelSplitOp{
+			Mutid:       op.Mutid,
+			Supervoxel:  op.Supervoxel,
+			Remainlabel: op.Remainlabel,
+			Splitlabel:  op.Splitlabel,
+		}
+		svm.splits[vid] = append(svm.splits[vid], rec)
+		svm.setMapping(vid, op.Supervoxel, 0)
+	default:
+	}
+	return nil
+}
+
 // getAncestry returns a slice of short version ids that actually have mappings,
 // from current version to root along ancestry.  Since all ancestors are immutable,
 // we can cache the ancestor slice and check if we should add current short version id.
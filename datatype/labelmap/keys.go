@@ -29,6 +29,12 @@ const (
 	// key = label.  value = datatype/common/proto/AffinityTable serialization
 	keyAffinities = 188
 
+	// key = mutation id.  value = unix nanoseconds of when the mutation was logged.
+	keyMutationTime = 189
+
+	// key = nil.  value = mutation id + unix nanoseconds of the last block write outside the mutation log.
+	keyBlockWrite = 190
+
 	// Used to store max label on commit for each version of the instance.
 	keyLabelMax = 237
 
@@ -48,6 +54,10 @@ func (d *Data) DescribeTKeyClass(tkc storage.TKeyClass) string {
 		return "labelmap label index key"
 	case keyAffinities:
 		return "labelmap affinities key"
+	case keyMutationTime:
+		return "labelmap mutation time key"
+	case keyBlockWrite:
+		return "labelmap last block write key"
 	case keyLabelMax:
 		return "labelmap label max key"
 	case keyRepoLabelMax:
@@ -60,6 +70,7 @@ func (d *Data) DescribeTKeyClass(tkc storage.TKeyClass) string {
 var (
 	maxLabelTKey     = storage.NewTKey(keyLabelMax, nil)
 	maxRepoLabelTKey = storage.NewTKey(keyRepoLabelMax, nil)
+	blockWriteTKey   = storage.NewTKey(keyBlockWrite, nil)
 )
 
 // NewBlockTKey returns a TKey for a label block, which is a slice suitable for
@@ -129,3 +140,20 @@ func DecodeAffinitiesTKey(tk storage.TKey) (label uint64, err error) {
 	label = binary.BigEndian.Uint64(ibytes[0:8])
 	return
 }
+
+// NewMutationTimeTKey returns a TKey for the time of a mutation.
+func NewMutationTimeTKey(mutID uint64) storage.TKey {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, mutID)
+	return storage.NewTKey(keyMutationTime, buf)
+}
+
+// DecodeMutationTimeTKey parses a TKey and returns the corresponding mutation id.
+func DecodeMutationTimeTKey(tk storage.TKey) (mutID uint64, err error) {
+	ibytes, err := tk.ClassBytes(keyMutationTime)
+	if err != nil {
+		return
+	}
+	mutID = binary.BigEndian.Uint64(ibytes[0:8])
+	return
+}
@@ -1055,6 +1055,12 @@ func (d *Data) writeLegacyRLE(ctx *datastore.VersionedCtx, label uint64, scale u
 		return
 	}
 	found = true
+	err = writeCompressedRLE(data, compression, w)
+	return
+}
+
+// writes legacy RLE data with the given compression.
+func writeCompressedRLE(data []byte, compression string, w io.Writer) (err error) {
 	switch compression {
 	case "":
 		_, err = w.Write(data)
@@ -1115,7 +1121,11 @@ func (d *Data) getLegacyRLEs(ctx *datastore.VersionedCtx, label uint64, scale ui
 		}
 		supervoxels = labels.Set{label: struct{}{}}
 	}
+	return d.getIndexRLEs(ctx, idx, supervoxels, scale, bounds)
+}
 
+// returns the legacy RLE encoding of the given supervoxels within the blocks of an index.
+func (d *Data) getIndexRLEs(ctx *datastore.VersionedCtx, idx *labels.Index, supervoxels labels.Set, scale uint8, bounds dvid.Bounds) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	buf.WriteByte(dvid.EncodingBinary)
 	binary.Write(buf, binary.LittleEndian, uint8(3))  // # of dimensions
@@ -443,6 +443,32 @@ GET  <api URL>/node/<UUID>/<data name>/pseudocolor/<dims>/<size>/<offset>[?query
                     are handled.  If the server can't initiate the API call right away, a 503 (Service Unavailable) 
                     status code is returned.
 
+GET <api URL>/node/<UUID>/<data name>/precomputed[/<option>...]/info
+GET <api URL>/node/<UUID>/<data name>/precomputed[/<option>...]/<scale key>/<chunk name>
+
+	Serves the label data in the Neuroglancer precomputed format so it can be viewed directly
+	with a Neuroglancer source URL like:
+
+	precomputed://http://<server>/api/node/3f8c/segmentation/precomputed
+
+	The info file describes a multiscale volume covering the data extents with one scale per
+	down-res level.  Scale keys are "s0", "s1", ... and each chunk is a single block with a name
+	giving its voxel bounds at that scale, e.g., "0-64_64-128_0-64".  Chunks for blocks that
+	have not been stored are returned with all zero labels.
+
+    Arguments:
+
+    UUID          Hexadecimal string with enough characters to uniquely identify a version node.
+    data name     Name of labelmap instance.
+    option        Optional path elements that change the returned volume:
+                    "compressed_segmentation" (default) or "raw" chooses the chunk encoding.
+                    "supervoxels" returns unmapped supervoxel ids instead of agglomerated labels.
+                    Options are part of the path so they apply to chunks requested by Neuroglancer.
+
+	A version can also be exported to a local directory in the same layout using the command:
+
+	$ dvid repo <UUID> export-precomputed <data name> <directory> [encoding=raw] [supervoxels=true]
+
 GET <api URL>/node/<UUID>/<data name>/label/<coord>[?queryopts]
 
 	Returns JSON for the label at the given coordinate:
@@ -458,6 +484,10 @@ GET <api URL>/node/<UUID>/<data name>/label/<coord>[?queryopts]
 	supervoxels   If "true", returns unmapped supervoxel label, disregarding any kind of merges.
     scale         A number from 0 up to MaxDownresLevel where each level beyond 0 has 1/2 resolution
 	                of previous level.  Level 0 is the highest resolution.
+    as_of         Returns labels as of a mutation ID or RFC3339 timestamp within this version's
+                    mutation log, e.g., "as_of=2024-03-01T12:00:00Z".  Merges, cleaves and splits
+                    after that point are reversed using the mutation log.  Returns an error if
+                    voxels were written after that point.
 
 GET <api URL>/node/<UUID>/<data name>/labels[?queryopts]
 
@@ -479,6 +509,10 @@ GET <api URL>/node/<UUID>/<data name>/labels[?queryopts]
     scale         A number from 0 up to MaxDownresLevel where each level beyond 0 has 1/2 resolution
 	                of previous level.  Level 0 is the highest resolution.
     hash          MD5 hash of request body content in hexidecimal string format.
+    as_of         Returns labels as of a mutation ID or RFC3339 timestamp within this version's
+                    mutation log, e.g., "as_of=2024-03-01T12:00:00Z".  Merges, cleaves and splits
+                    after that point are reversed using the mutation log.  Returns an error if
+                    voxels were written after that point.
 
 GET <api URL>/node/<UUID>/<data name>/history/<label>/<from UUID>/<to UUID>
 
@@ -492,6 +526,26 @@ GET <api URL>/node/<UUID>/<data name>/history/<label>/<from UUID>/<to UUID>
 	to UUID       The UUID of the later version in time range.
 
 
+GET <api URL>/node/<UUID>/<data name>/diff/<UUID B>[?queryopts]
+
+	Streams the labels and blocks that differ from version <UUID> to version <UUID B>, which
+	must be in the same repo, as JSON lines.  Labels whose index was added, modified, e.g., by
+	merges, splits or voxel writes, or deleted are listed first, followed by changed blocks:
+
+	{"label": 23, "change": "modified"}
+	{"label": 911, "change": "deleted"}
+	{"block": [10, 20, 30], "scale": 0, "change": "modified"}
+
+    Arguments:
+    UUID          Hexadecimal string with enough characters to uniquely identify a version node.
+    data name     Name of labelmap instance.
+    UUID B        The version to compare against.
+
+    Query-string Options:
+
+    scale         The scale of blocks to compare.  Default is 0, the highest resolution.
+    blocks        If "false", only changed labels are returned.
+
 GET <api URL>/node/<UUID>/<data name>/mapping[?queryopts]
 
 	Returns JSON for mapped labels given a list of supervoxels.  Expects JSON in GET body:
@@ -514,6 +568,8 @@ GET <api URL>/node/<UUID>/<data name>/mapping[?queryopts]
 	nolookup      if "true", dvid won't verify that a supervoxel actually exists by looking up
 	                the label indices.  Only use this if supervoxels were known to exist at some time.
     hash          MD5 hash of request body content in hexidecimal string format.
+    as_of         Returns mappings as of a mutation ID or RFC3339 timestamp within this version's
+                    mutation log.  Supervoxels created by later splits are mapped to 0.
 
 GET <api URL>/node/<UUID>/<data name>/supervoxel-splits
 
@@ -903,6 +959,10 @@ GET  <api URL>/node/<UUID>/<data name>/sparsevol/<label>?<options>
 	scale        A number from 0 up to MaxDownresLevel where each level beyond 0 has 1/2 
                    resolution of previous level.  Level 0 is the highest resolution.
 	supervoxels   If "true", interprets the given label as a supervoxel id.
+	as_of        Returns the sparse volume of the label as of a mutation ID or RFC3339 timestamp
+	               within this version's mutation log.  Only the "rles" format is supported.
+	               Merges, cleaves and splits after that point are reversed using the mutation log.
+	               Returns an error if voxels were written after that point.
 
 
 HEAD <api URL>/node/<UUID>/<data name>/sparsevol/<label>[?supervoxels=true]
@@ -1201,6 +1261,106 @@ POST <api URL>/node/<UUID>/<data name>/split/<label>
 			"UUID": <UUID on which split was done>
 		}
 
+POST <api URL>/node/<UUID>/<data name>/undo?mutid=<mutation id>
+
+	Undoes a merge, cleave, split or split-supervoxel mutation done in this version by
+	applying its inverse as new mutations:
+
+		merge             Cleaves the supervoxels of each merged label back into that label.
+		cleave            Merges the cleaved label back into the original label.
+		split             Restores the split supervoxels and merges the new label back into
+		                    the original label.
+		split-supervoxel  Relabels the split and remain supervoxels with the original supervoxel.
+
+	Label indices, mappings and block data (including lower-res scales) are restored and
+	synced data like annotations and labelsz receive the corresponding merge or cleave
+	events.  A bad request error (status 400) is returned if the mutation isn't found in
+	this version's mutation log or if a later mutation modified any of the labels or 
+	supervoxels changed by the mutation.  Undoing the cleave or merge returned by an undo
+	of a merge or cleave will redo the original mutation.
+
+	Returns the following JSON:
+
+		{ 
+			"MutationID": <undone mutation id>,
+			"Action": <"merge", "cleave", "split" or "split-supervoxel">,
+			"UndoMutationIDs": [<mutation id of inverse mutation 1>, ...]
+		}
+
+	The inverse merges and cleaves generate their usual Kafka messages.  After completion,
+	the following JSON message is published:
+		{ 
+			"Action": "undo",
+			"UndoneMutationID": <undone mutation id>,
+			"UndoneAction": <action of undone mutation>,
+			"MutationIDs": [<mutation id of inverse mutation 1>, ...],
+			"UUID": <UUID on which undo was done>
+		}
+
+
+GET <api URL>/node/<UUID>/<data name>/mesh/<label>[?queryopts]
+
+	Returns a surface mesh of a body (or supervoxel if "supervoxels=true") computed by
+	marching tetrahedra, a variant of marching cubes, over the label blocks at the given
+	scale.  Only the blocks within the label's index are read.  Vertex coordinates are in
+	the physical units of the labelmap's VoxelSize at scale 0.  Returns a status code 404
+	(Not Found) if the label does not exist.
+
+	If a keyvalue instance is synced to this labelmap, meshes are cached in it under keys
+	"<label>_mesh_s<scale>.<format>" for bodies and "<label>_svmesh_s<scale>.<format>" for
+	supervoxels.  The keyvalue instance deletes cached meshes of labels changed by merges, 
+	cleaves and splits.  To set up a cache, create a keyvalue instance and POST to its 
+	sync endpoint the name of this labelmap instance:
+
+	$ dvid repo <UUID> new keyvalue meshes
+	POST <api URL>/node/<UUID>/meshes/sync with { "sync": "<data name>" }
+
+	Example: 
+
+	GET <api URL>/node/3f8c/segmentation/mesh/23?scale=2&format=obj
+
+	Arguments:
+	UUID          Hexadecimal string with enough characters to uniquely identify a version node.
+	data name     Name of labelmap instance.
+	label         The body or supervoxel label.
+
+	Query-string Options:
+
+	scale         A number from 0 up to MaxDownresLevel where each level beyond 0 has 1/2 resolution
+	                of previous level.  Level 0 (default) is the highest resolution.
+	format        "ngmesh" (default) for the Neuroglancer legacy mesh format, "obj" for Wavefront
+	                OBJ text, or "ply" for binary little-endian PLY.
+	supervoxels   If "true", returns the mesh of the given supervoxel instead of a body.
+
+
+GET <api URL>/node/<UUID>/<data name>/skeleton/<label>[?queryopts]
+
+	Returns a skeleton of a body in SWC format computed by a TEASAR-style algorithm over 
+	the label blocks at the given scale.  Only the blocks within the label's index are read,
+	and the bounding box of the body's blocks at the given scale must be under 2^28 voxels,
+	so higher scales should be used for large bodies.  Each connected component of the body
+	is a separate tree rooted at one of its extremes.  Node coordinates and radii are in the 
+	physical units of the labelmap's VoxelSize at scale 0.  Returns a status code 404 (Not Found)
+	if the label does not exist.
+
+	If a keyvalue instance is synced to this labelmap, skeletons are cached in it under keys
+	"<label>_skeleton_s<scale>.swc" and deleted when the body is changed by a merge, cleave 
+	or split.  See the /mesh endpoint for setting up a cache.
+
+	Example: 
+
+	GET <api URL>/node/3f8c/segmentation/skeleton/23?scale=2
+
+	Arguments:
+	UUID          Hexadecimal string with enough characters to uniquely identify a version node.
+	data name     Name of labelmap instance.
+	label         The body label.
+
+	Query-string Options:
+
+	scale         A number from 0 up to MaxDownresLevel where each level beyond 0 has 1/2 resolution
+	                of previous level.  Level 0 (default) is the highest resolution.
+
 
 GET  <api URL>/node/<UUID>/<data name>/index/<label>
 POST <api URL>/node/<UUID>/<data name>/index/<label>
@@ -1484,6 +1644,8 @@ type Data struct {
 	mlMu sync.RWMutex // For atomic access of MaxLabel and MaxRepoLabel
 
 	voxelMu sync.Mutex // Only allow voxel-level label mutation ops sequentially.
+
+	mutateMu sync.RWMutex // Read-locked by logged mutations, write-locked by undo across its check and apply.
 }
 
 // --- LogReadable interface ---
@@ -2560,6 +2722,9 @@ func (d *Data) ReceiveBlocks(ctx *datastore.VersionedCtx, r io.ReadCloser, scale
 	}
 
 	mutID := d.NewMutationID()
+	if err := storeBlockWrite(d, ctx.VersionID(), mutID); err != nil {
+		return err
+	}
 	var downresMut *downres.Mutation
 	if downscale {
 		downresMut = downres.NewMutation(d, ctx.VersionID(), mutID)
@@ -3131,7 +3296,7 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 	// Prevent use of APIs that require IndexedLabels when it is not set.
 	if !d.IndexedLabels {
 		switch parts[3] {
-		case "sparsevol", "sparsevol-by-point", "sparsevol-coarse", "maxlabel", "nextlabel", "split-supervoxel", "cleave", "merge":
+		case "sparsevol", "sparsevol-by-point", "sparsevol-coarse", "maxlabel", "nextlabel", "split-supervoxel", "cleave", "merge", "undo":
 			server.BadRequest(w, r, "data %q is not label indexed (IndexedLabels=false): %q endpoint is not supported", d.DataName(), parts[3])
 			return
 		}
@@ -3228,6 +3393,9 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 	case "pseudocolor":
 		d.handlePseudocolor(ctx, w, r, parts)
 
+	case "precomputed":
+		d.handlePrecomputed(ctx, w, r, parts)
+
 	case "raw", "isotropic":
 		d.handleDataRequest(ctx, w, r, parts)
 
@@ -3278,6 +3446,15 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 	case "merge":
 		d.handleMerge(ctx, w, r, parts)
 
+	case "undo":
+		d.handleUndo(ctx, w, r)
+
+	case "mesh":
+		d.handleMesh(ctx, w, r, parts)
+
+	case "skeleton":
+		d.handleSkeleton(ctx, w, r, parts)
+
 	case "index":
 		d.handleIndex(ctx, w, r, parts)
 
@@ -3290,6 +3467,9 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 	case "history":
 		d.handleHistory(ctx, w, r, parts)
 
+	case "diff":
+		d.handleDiff(ctx, w, r, parts)
+
 	default:
 		server.BadAPIRequest(w, r, d)
 	}
@@ -3318,8 +3498,18 @@ func (d *Data) handleLabel(ctx *datastore.VersionedCtx, w http.ResponseWriter, r
 		return
 	}
 	isSupervoxel := queryStrings.Get("supervoxels") == "true"
+	asOf, err := dvid.GetAsOf(r)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
 
-	labels, err := d.GetLabelPoints(ctx.VersionID(), []dvid.Point3d{coord}, scale, isSupervoxel)
+	var labels []uint64
+	if asOf != nil {
+		labels, err = d.GetLabelPointsAsOf(ctx.VersionID(), *asOf, []dvid.Point3d{coord}, scale, isSupervoxel)
+	} else {
+		labels, err = d.GetLabelPoints(ctx.VersionID(), []dvid.Point3d{coord}, scale, isSupervoxel)
+	}
 	if err != nil {
 		server.BadRequest(w, r, err)
 		return
@@ -3351,6 +3541,11 @@ func (d *Data) handleLabels(ctx *datastore.VersionedCtx, w http.ResponseWriter,
 		return
 	}
 	isSupervoxel := queryStrings.Get("supervoxels") == "true"
+	asOf, err := dvid.GetAsOf(r)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
 	hash := queryStrings.Get("hash")
 	if err := checkContentHash(hash, data); err != nil {
 		server.BadRequest(w, r, err)
@@ -3361,7 +3556,12 @@ func (d *Data) handleLabels(ctx *datastore.VersionedCtx, w http.ResponseWriter,
 		server.BadRequest(w, r, fmt.Sprintf("Bad labels request JSON: %v", err))
 		return
 	}
-	labels, err := d.GetLabelPoints(ctx.VersionID(), coords, scale, isSupervoxel)
+	var labels []uint64
+	if asOf != nil {
+		labels, err = d.GetLabelPointsAsOf(ctx.VersionID(), *asOf, coords, scale, isSupervoxel)
+	} else {
+		labels, err = d.GetLabelPoints(ctx.VersionID(), coords, scale, isSupervoxel)
+	}
 	if err != nil {
 		server.BadRequest(w, r, err)
 		return
@@ -3405,22 +3605,36 @@ func (d *Data) handleMapping(ctx *datastore.VersionedCtx, w http.ResponseWriter,
 		server.BadRequest(w, r, fmt.Sprintf("Bad mapping request JSON: %v", err))
 		return
 	}
-	svmap, err := getMapping(d, ctx.VersionID())
-	if err != nil {
-		server.BadRequest(w, r, "couldn't get mapping for data %q, version %d: %v", d.DataName(), ctx.VersionID(), err)
-		return
-	}
-	labels, found, err := svmap.MappedLabels(ctx.VersionID(), supervoxels)
+	asOf, err := dvid.GetAsOf(r)
 	if err != nil {
 		server.BadRequest(w, r, err)
 		return
 	}
-	if queryStrings.Get("nolookup") != "true" {
-		labels, err = d.verifyMappings(ctx, supervoxels, labels, found)
+	var labels []uint64
+	if asOf != nil {
+		if labels, err = d.GetMappedLabelsAsOf(ctx.VersionID(), *asOf, supervoxels); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+	} else {
+		svmap, err := getMapping(d, ctx.VersionID())
+		if err != nil {
+			server.BadRequest(w, r, "couldn't get mapping for data %q, version %d: %v", d.DataName(), ctx.VersionID(), err)
+			return
+		}
+		var found []bool
+		labels, found, err = svmap.MappedLabels(ctx.VersionID(), supervoxels)
 		if err != nil {
 			server.BadRequest(w, r, err)
 			return
 		}
+		if queryStrings.Get("nolookup") != "true" {
+			labels, err = d.verifyMappings(ctx, supervoxels, labels, found)
+			if err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+		}
 	}
 
 	w.Header().Set("Content-type", "application/json")
@@ -3811,6 +4025,44 @@ func (d *Data) handleHistory(ctx *datastore.VersionedCtx, w http.ResponseWriter,
 	timedLog.Infof("HTTP GET history (%s)", r.URL)
 }
 
+func (d *Data) handleDiff(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
+	// GET <api URL>/node/<UUID>/<data name>/diff/<UUID B>
+	if len(parts) < 5 {
+		server.BadRequest(w, r, "ERROR: DVID requires UUID to follow 'diff' command")
+		return
+	}
+	timedLog := dvid.NewTimeLog()
+
+	if strings.ToLower(r.Method) != "get" {
+		server.BadRequest(w, r, "only GET action allowed for /diff endpoint")
+		return
+	}
+	queryStrings := r.URL.Query()
+	scale, err := getScale(queryStrings)
+	if err != nil {
+		server.BadRequest(w, r, "bad scale specified: %v", err)
+		return
+	}
+	withBlocks := queryStrings.Get("blocks") != "false"
+	uuid, err := datastore.UUIDFromVersion(ctx.VersionID())
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	uuidB, vB, err := datastore.GetDiffVersion(uuid, parts[4])
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-type", "application/x-ndjson")
+	numLabels, numBlocks, err := d.WriteDiff(w, ctx.VersionID(), vB, scale, withBlocks)
+	if err != nil {
+		server.BadRequest(w, r, "unable to diff versions: %v", err)
+		return
+	}
+	timedLog.Infof("HTTP GET diff of %d labels, %d blocks between %s and %s (%s)", numLabels, numBlocks, uuid, uuidB, r.URL)
+}
+
 func (d *Data) handlePseudocolor(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
 	if len(parts) < 7 {
 		server.BadRequest(w, r, "'%s' must be followed by shape/size/offset", parts[3])
@@ -4290,6 +4542,11 @@ func (d *Data) handleSparsevol(ctx *datastore.VersionedCtx, w http.ResponseWrite
 		server.BadRequest(w, r, err)
 		return
 	}
+	asOf, err := dvid.GetAsOf(r)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
 
 	timedLog := dvid.NewTimeLog()
 	switch strings.ToLower(r.Method) {
@@ -4297,9 +4554,18 @@ func (d *Data) handleSparsevol(ctx *datastore.VersionedCtx, w http.ResponseWrite
 		w.Header().Set("Content-type", "application/octet-stream")
 
 		var found bool
-		switch svformatFromQueryString(r) {
+		format := svformatFromQueryString(r)
+		if asOf != nil && format != FormatLegacyRLE {
+			server.BadRequest(w, r, "as_of is only supported for the legacy RLE sparsevol format")
+			return
+		}
+		switch format {
 		case FormatLegacyRLE:
-			found, err = d.writeLegacyRLE(ctx, label, scale, b, compression, isSupervoxel, w)
+			if asOf != nil {
+				found, err = d.writeLegacyRLEAsOf(ctx, *asOf, label, scale, b, compression, isSupervoxel, w)
+			} else {
+				found, err = d.writeLegacyRLE(ctx, label, scale, b, compression, isSupervoxel, w)
+			}
 		case FormatBinaryBlocks:
 			found, err = d.writeBinaryBlocks(ctx, label, scale, b, compression, isSupervoxel, w)
 		case FormatStreamingRLE:
@@ -4541,6 +4807,13 @@ func (d *Data) handleMaxlabel(ctx *datastore.VersionedCtx, w http.ResponseWriter
 	timedLog.Infof("HTTP maxlabel request (%s)", r.URL)
 }
 
+// labelAssignments are the labels assigned by a mutation, which are logged with the mutation
+// so replicas replaying it assign the same labels.
+type labelAssignments struct {
+	Labels   []uint64                  `json:",omitempty"`
+	SVSplits map[uint64]labels.SVSplit `json:",omitempty"`
+}
+
 func (d *Data) handleNextlabel(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
 	// GET <api URL>/node/<UUID>/<data name>/nextlabel
 	// POST <api URL>/node/<UUID>/<data name>/nextlabel/<number of labels>
@@ -4559,11 +4832,23 @@ func (d *Data) handleNextlabel(ctx *datastore.VersionedCtx, w http.ResponseWrite
 			server.BadRequest(w, r, err)
 			return
 		}
-		start, end, err := d.newLabels(ctx.VersionID(), numLabels)
+		var assigned labelAssignments
+		if _, err := server.ReplayedAssignments(r, &assigned); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		var start, end uint64
+		if len(assigned.Labels) == 2 {
+			start, end = assigned.Labels[0], assigned.Labels[1]
+			_, err = d.updateMaxLabel(ctx.VersionID(), end)
+		} else {
+			start, end, err = d.newLabels(ctx.VersionID(), numLabels)
+		}
 		if err != nil {
 			server.BadRequest(w, r, err)
 			return
 		}
+		server.SetMutationAssignments(r, labelAssignments{Labels: []uint64{start, end}})
 		fmt.Fprintf(w, `{"start": %d, "end": %d}`, start, end)
 		versionuuid, _ := datastore.UUIDFromVersion(ctx.VersionID())
 		msginfo := map[string]interface{}{
@@ -4630,12 +4915,21 @@ func (d *Data) handleSplitSupervoxel(ctx *datastore.VersionedCtx, w http.Respons
 		server.BadRequest(w, r, "Label 0 is protected background value and cannot be used as split target\n")
 		return
 	}
+	var assigned labelAssignments
+	if _, err := server.ReplayedAssignments(r, &assigned); err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if len(assigned.Labels) == 2 {
+		split, remain = assigned.Labels[0], assigned.Labels[1]
+	}
 	info := dvid.GetModInfo(r)
 	splitSupervoxel, remainSupervoxel, mutID, err := d.SplitSupervoxel(ctx.VersionID(), supervoxel, split, remain, r.Body, info, downscale)
 	if err != nil {
 		server.BadRequest(w, r, fmt.Sprintf("split supervoxel %d -> %d, %d: %v", supervoxel, splitSupervoxel, remainSupervoxel, err))
 		return
 	}
+	server.SetMutationAssignments(r, labelAssignments{Labels: []uint64{splitSupervoxel, remainSupervoxel}})
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprintf(w, `{"SplitSupervoxel": %d, "RemainSupervoxel": %d, "MutationID": %d}`, splitSupervoxel, remainSupervoxel, mutID)
 
@@ -4663,12 +4957,22 @@ func (d *Data) handleCleave(ctx *datastore.VersionedCtx, w http.ResponseWriter,
 		server.BadRequest(w, r, "Label 0 is protected background value and cannot be used as cleave target\n")
 		return
 	}
+	var assigned labelAssignments
+	if _, err := server.ReplayedAssignments(r, &assigned); err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	var toLabel uint64
+	if len(assigned.Labels) == 1 {
+		toLabel = assigned.Labels[0]
+	}
 	modInfo := dvid.GetModInfo(r)
-	cleaveLabel, mutID, err := d.CleaveLabel(ctx.VersionID(), label, modInfo, r.Body)
+	cleaveLabel, mutID, err := d.CleaveLabel(ctx.VersionID(), label, toLabel, modInfo, r.Body)
 	if err != nil {
 		server.BadRequest(w, r, err)
 		return
 	}
+	server.SetMutationAssignments(r, labelAssignments{Labels: []uint64{cleaveLabel}})
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprintf(w, `{"CleavedLabel": %d, "MutationID": %d}`, cleaveLabel, mutID)
 
@@ -4696,12 +5000,23 @@ func (d *Data) handleSplit(ctx *datastore.VersionedCtx, w http.ResponseWriter, r
 		server.BadRequest(w, r, "Label 0 is protected background value and cannot be used as sparse volume.\n")
 		return
 	}
+	var assigned labelAssignments
+	if _, err := server.ReplayedAssignments(r, &assigned); err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	var splitLabel uint64
+	if len(assigned.Labels) == 1 {
+		splitLabel = assigned.Labels[0]
+	}
+	svsplit := &labels.SVSplitMap{Splits: assigned.SVSplits}
 	info := dvid.GetModInfo(r)
-	toLabel, mutID, err := d.SplitLabels(ctx.VersionID(), fromLabel, r.Body, info)
+	toLabel, mutID, err := d.SplitLabels(ctx.VersionID(), fromLabel, splitLabel, svsplit, r.Body, info)
 	if err != nil {
 		server.BadRequest(w, r, fmt.Sprintf("split label %d: %v", fromLabel, err))
 		return
 	}
+	server.SetMutationAssignments(r, labelAssignments{Labels: []uint64{toLabel}, SVSplits: svsplit.Splits})
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprintf(w, `{"label": %d, "MutationID": %d}`, toLabel, mutID)
 
@@ -4743,6 +5058,41 @@ func (d *Data) handleMerge(ctx *datastore.VersionedCtx, w http.ResponseWriter, r
 	timedLog.Infof("HTTP merge request (%s)", r.URL)
 }
 
+func (d *Data) handleUndo(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request) {
+	// POST <api URL>/node/<UUID>/<data name>/undo?mutid=<mutation id>
+	if strings.ToLower(r.Method) != "post" {
+		server.BadRequest(w, r, "Undo requests must be POST actions.")
+		return
+	}
+	timedLog := dvid.NewTimeLog()
+
+	mutidStr := r.URL.Query().Get("mutid")
+	if mutidStr == "" {
+		server.BadRequest(w, r, "undo requires a mutation id via 'mutid' query string")
+		return
+	}
+	mutID, err := strconv.ParseUint(mutidStr, 10, 64)
+	if err != nil {
+		server.BadRequest(w, r, "bad mutation id %q: %v", mutidStr, err)
+		return
+	}
+	info := dvid.GetModInfo(r)
+	result, err := d.UndoMutation(ctx.VersionID(), mutID, info)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(jsonBytes))
+
+	timedLog.Infof("HTTP undo of mutation %d request (%s)", mutID, r.URL)
+}
+
 // --------- Other functions on labelmap Data -----------------
 
 // GetLabelBlock returns a compressed label Block of the given block coordinate.
@@ -0,0 +1,503 @@
+/*
+	This file supports type-specific merging of labelmap versions.
+*/
+
+package labelmap
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/datatype/common/proto"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// MergeConflicts describes the changes in the parents of a type-specific merge
+// that can't be automatically resolved.
+type MergeConflicts struct {
+	Labels      []uint64   `json:"labels"`       // bodies modified in more than one parent
+	Supervoxels []uint64   `json:"supervoxels"`  // supervoxels modified in more than one parent
+	Blocks      [][3]int32 `json:"blocks"`       // scale 0 block coordinates written in more than one parent
+	MutationIDs []uint64   `json:"mutation_ids"` // mutations in any parent that touch conflicting labels
+}
+
+// loggedOp records the labels and supervoxels touched by a logged mutation.
+type loggedOp struct {
+	mutID       uint64
+	labels      []uint64
+	supervoxels []uint64
+}
+
+// mergeBranch holds the changes along the ancestry of one merge parent since the
+// common ancestor.
+type mergeBranch struct {
+	versions    []dvid.VersionID // from child of ancestor to parent
+	ops         []loggedOp
+	labels      labels.Set
+	supervoxels labels.Set
+}
+
+// keyWrites holds the keys, grouped by key class, written in more than one branch.
+type keyWrites struct {
+	labels   labels.Set                         // label index or affinity keys
+	blocks   map[uint8]map[dvid.IZYXString]bool // block keys by scale
+	maxScale uint8
+}
+
+func getLoggedOp(msg storage.LogMessage) (op loggedOp, ok bool, err error) {
+	switch msg.EntryType {
+	case proto.MergeOpType:
+		var mergeOp proto.MergeOp
+		if err = mergeOp.Unmarshal(msg.Data); err != nil {
+			return
+		}
+		op.mutID = mergeOp.Mutid
+		op.labels = append([]uint64{mergeOp.Target}, mergeOp.Merged...)
+	case proto.CleaveOpType:
+		var cleaveOp proto.CleaveOp
+		if err = cleaveOp.Unmarshal(msg.Data); err != nil {
+			return
+		}
+		op.mutID = cleaveOp.Mutid
+		op.labels = []uint64{cleaveOp.Target, cleaveOp.Cleavedlabel}
+		op.supervoxels = cleaveOp.Cleaved
+	case proto.SplitOpType:
+		var splitOp proto.SplitOp
+		if err = splitOp.Unmarshal(msg.Data); err != nil {
+			return
+		}
+		op.mutID = splitOp.Mutid
+		op.labels = []uint64{splitOp.Target, splitOp.Newlabel}
+		for supervoxel, svsplit := range splitOp.Svsplits {
+			op.supervoxels = append(op.supervoxels, supervoxel, svsplit.Splitlabel, svsplit.Remainlabel)
+		}
+	case proto.SupervoxelSplitType:
+		var svSplitOp proto.SupervoxelSplitOp
+		if err = svSplitOp.Unmarshal(msg.Data); err != nil {
+			return
+		}
+		op.mutID = svSplitOp.Mutid
+		op.supervoxels = []uint64{svSplitOp.Supervoxel, svSplitOp.Splitlabel, svSplitOp.Remainlabel}
+	case proto.MappingOpType:
+		var mappingOp proto.MappingOp
+		if err = mappingOp.Unmarshal(msg.Data); err != nil {
+			return
+		}
+		op.mutID = mappingOp.Mutid
+		op.labels = []uint64{mappingOp.Mapped}
+		op.supervoxels = mappingOp.Original
+	default:
+		return
+	}
+	ok = true
+	return
+}
+
+// returns the mutation log messages for a version in order of application.
+func (d *Data) getLogMessages(v dvid.VersionID) ([]storage.LogMessage, error) {
+	var msgs []storage.LogMessage
+	ch := make(chan storage.LogMessage, 100)
+	wg := new(sync.WaitGroup)
+	go func() {
+		for msg := range ch {
+			msgs = append(msgs, msg)
+			wg.Done()
+		}
+	}()
+	if err := labels.StreamLog(d, v, ch, wg); err != nil {
+		return nil, fmt.Errorf("problem loading mutation log for version %d: %v", v, err)
+	}
+	wg.Wait()
+	return msgs, nil
+}
+
+// streams the mutation logs along a merge branch, calling the given function
+// for each log message in order of application.
+func (d *Data) processBranchLogs(branch *mergeBranch, f func(v dvid.VersionID, msg storage.LogMessage) error) error {
+	for _, v := range branch.versions {
+		msgs, err := d.getLogMessages(v)
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if err := f(v, msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getMergeBranches returns the changes recorded in the mutation logs for each parent
+// since the common ancestor.
+func (d *Data) getMergeBranches(ancestor dvid.VersionID, parents []dvid.VersionID) ([]*mergeBranch, error) {
+	branches := make([]*mergeBranch, len(parents))
+	for i, parent := range parents {
+		versions, err := datastore.GetVersionsSince(ancestor, parent)
+		if err != nil {
+			return nil, err
+		}
+		branch := &mergeBranch{
+			versions:    versions,
+			labels:      make(labels.Set),
+			supervoxels: make(labels.Set),
+		}
+		err = d.processBranchLogs(branch, func(v dvid.VersionID, msg storage.LogMessage) error {
+			op, ok, err := getLoggedOp(msg)
+			if err != nil {
+				return fmt.Errorf("unable to decode mutation log entry for version %d: %v", v, err)
+			}
+			if !ok {
+				return nil
+			}
+			branch.ops = append(branch.ops, op)
+			for _, label := range op.labels {
+				branch.labels[label] = struct{}{}
+			}
+			for _, supervoxel := range op.supervoxels {
+				branch.supervoxels[supervoxel] = struct{}{}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		branches[i] = branch
+	}
+	return branches, nil
+}
+
+// getConflictingWrites scans the keys of this data instance and returns the label
+// and block keys that were written in more than one branch.
+func (d *Data) getConflictingWrites(branches []*mergeBranch) (*keyWrites, error) {
+	branchOfVersion := make(map[dvid.VersionID]int)
+	for i, branch := range branches {
+		for _, v := range branch.versions {
+			if _, found := branchOfVersion[v]; !found {
+				branchOfVersion[v] = i
+			}
+		}
+	}
+	writes := &keyWrites{
+		labels: make(labels.Set),
+		blocks: make(map[uint8]map[dvid.IZYXString]bool),
+	}
+
+	ctx := storage.NewDataContext(d, 0)
+	ch := make(chan *storage.KeyValue, 1000)
+	errCh := make(chan error, 1)
+	go func() {
+		var scanErr error
+		var curTK storage.TKey
+		writers := make(map[int]struct{})
+		for {
+			kv := <-ch
+			if scanErr != nil { // keep reading so the range query can complete.
+				if kv == nil {
+					break
+				}
+				continue
+			}
+			var tk storage.TKey
+			if kv != nil {
+				if tk, scanErr = storage.TKeyFromKey(kv.K); scanErr != nil {
+					continue
+				}
+			}
+			if kv == nil || !bytes.Equal(tk, curTK) {
+				if len(writers) > 1 {
+					scanErr = writes.add(curTK)
+				}
+				if kv == nil {
+					break
+				}
+				curTK = tk
+				writers = make(map[int]struct{})
+			}
+			v, err := ctx.VersionFromKey(kv.K)
+			if err != nil {
+				scanErr = err
+				continue
+			}
+			if i, found := branchOfVersion[v]; found {
+				writers[i] = struct{}{}
+			}
+		}
+		errCh <- scanErr
+	}()
+
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, err
+	}
+	minKey, maxKey := ctx.KeyRange()
+	keysOnly := true
+	if err = store.RawRangeQuery(minKey, maxKey, keysOnly, ch, nil); err != nil {
+		return nil, err
+	}
+	if err = <-errCh; err != nil {
+		return nil, fmt.Errorf("error scanning keys of labelmap %q for merge: %v", d.DataName(), err)
+	}
+	return writes, nil
+}
+
+func (writes *keyWrites) add(tk storage.TKey) error {
+	class, err := tk.Class()
+	if err != nil {
+		return err
+	}
+	switch class {
+	case keyLabelBlock:
+		scale, idx, err := DecodeBlockTKey(tk)
+		if err != nil {
+			return err
+		}
+		scaleBlocks, found := writes.blocks[scale]
+		if !found {
+			scaleBlocks = make(map[dvid.IZYXString]bool)
+			writes.blocks[scale] = scaleBlocks
+		}
+		scaleBlocks[idx.ToIZYXString()] = true
+		if scale > writes.maxScale {
+			writes.maxScale = scale
+		}
+	case keyLabelIndex:
+		label, err := DecodeLabelIndexTKey(tk)
+		if err != nil {
+			return err
+		}
+		writes.labels[label] = struct{}{}
+	case keyAffinities:
+		label, err := DecodeAffinitiesTKey(tk)
+		if err != nil {
+			return err
+		}
+		writes.labels[label] = struct{}{}
+	default:
+		// Max label keys are resolved by taking the maximum over the parents.
+	}
+	return nil
+}
+
+// returns the elements of a set in ascending order.
+func sortedSet(set labels.Set) []uint64 {
+	sorted := make([]uint64, 0, len(set))
+	for label := range set {
+		sorted = append(sorted, label)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// --- datastore.VersionMerger interface -----
+
+// MergeConflicts returns a *MergeConflicts if the parents have modified the same
+// labels, supervoxels, or scale 0 blocks since the common ancestor, or nil if the
+// parents can be automatically merged.
+func (d *Data) MergeConflicts(ancestor dvid.VersionID, parents []dvid.VersionID) (interface{}, error) {
+	timedLog := dvid.NewTimeLog()
+	branches, err := d.getMergeBranches(ancestor, parents)
+	if err != nil {
+		return nil, err
+	}
+	writes, err := d.getConflictingWrites(branches)
+	if err != nil {
+		return nil, err
+	}
+
+	conflictLabels := writes.labels
+	conflictSupervoxels := make(labels.Set)
+	for i, branch := range branches {
+		for _, other := range branches[i+1:] {
+			for label := range branch.labels {
+				if _, found := other.labels[label]; found {
+					conflictLabels[label] = struct{}{}
+				}
+			}
+			for supervoxel := range branch.supervoxels {
+				if _, found := other.supervoxels[supervoxel]; found {
+					conflictSupervoxels[supervoxel] = struct{}{}
+				}
+			}
+		}
+	}
+	blocks := writes.blocks[0]
+	if len(conflictLabels) == 0 && len(conflictSupervoxels) == 0 && len(blocks) == 0 {
+		timedLog.Infof("No merge conflicts found for labelmap %q", d.DataName())
+		return nil, nil
+	}
+
+	conflicts := &MergeConflicts{
+		Labels:      sortedSet(conflictLabels),
+		Supervoxels: sortedSet(conflictSupervoxels),
+		Blocks:      make([][3]int32, 0, len(blocks)),
+	}
+	for izyx := range blocks {
+		chunkPt, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return nil, err
+		}
+		conflicts.Blocks = append(conflicts.Blocks, [3]int32(chunkPt))
+	}
+	sort.Slice(conflicts.Blocks, func(i, j int) bool {
+		bi, bj := conflicts.Blocks[i], conflicts.Blocks[j]
+		for n := 2; n >= 0; n-- {
+			if bi[n] != bj[n] {
+				return bi[n] < bj[n]
+			}
+		}
+		return false
+	})
+	mutIDs := make(labels.Set) // set of mutation ids rather than labels
+	for _, branch := range branches {
+		for _, op := range branch.ops {
+			var conflicting bool
+			for _, label := range op.labels {
+				if _, found := conflictLabels[label]; found {
+					conflicting = true
+				}
+			}
+			for _, supervoxel := range op.supervoxels {
+				if _, found := conflictSupervoxels[supervoxel]; found {
+					conflicting = true
+				}
+			}
+			if conflicting {
+				mutIDs[op.mutID] = struct{}{}
+			}
+		}
+	}
+	conflicts.MutationIDs = sortedSet(mutIDs)
+	timedLog.Infof("Found %d conflicting labels, %d supervoxels, and %d blocks for merge of labelmap %q",
+		len(conflicts.Labels), len(conflicts.Supervoxels), len(conflicts.Blocks), d.DataName())
+	return conflicts, nil
+}
+
+// MergeVersions applies an automatic merge of the parents into the child version.
+// Mutations of all but the first parent are replayed into the child's mutation log
+// and mappings, the child's max label is set to the largest of the parents', and
+// lower-resolution blocks written in more than one parent are recomputed from the
+// merged higher-resolution blocks.
+func (d *Data) MergeVersions(ancestor dvid.VersionID, parents []dvid.VersionID, child dvid.VersionID) error {
+	timedLog := dvid.NewTimeLog()
+	branches, err := d.getMergeBranches(ancestor, parents)
+	if err != nil {
+		return err
+	}
+	childUUID, err := datastore.UUIDFromVersion(child)
+	if err != nil {
+		return err
+	}
+
+	// The child's first parent provides the default ancestry for mappings, so only
+	// changes along the other parents need to be replayed into the child.
+	svmap, err := getMapping(d, child)
+	if err != nil {
+		return err
+	}
+	svmap.Lock()
+	vid, err := svmap.createShortVersion(child)
+	if err != nil {
+		svmap.Unlock()
+		return err
+	}
+	var numReplayed int
+	for _, branch := range branches[1:] {
+		err = d.processBranchLogs(branch, func(v dvid.VersionID, msg storage.LogMessage) error {
+			if log := d.GetWriteLog(); log != nil {
+				if err := log.Append(d.DataUUID(), childUUID, msg); err != nil {
+					return err
+				}
+			}
+			numReplayed++
+			return svmap.applyLogMessage(vid, msg)
+		})
+		if err != nil {
+			break
+		}
+	}
+	svmap.Unlock()
+	if err != nil {
+		return fmt.Errorf("unable to replay mutations into merged version of labelmap %q: %v", d.DataName(), err)
+	}
+
+	var maxLabel uint64
+	d.mlMu.RLock()
+	for _, parent := range parents {
+		if label, found := d.MaxLabel[parent]; found && label > maxLabel {
+			maxLabel = label
+		}
+	}
+	d.mlMu.RUnlock()
+	if maxLabel != 0 {
+		if _, err := d.updateMaxLabel(child, maxLabel); err != nil {
+			return err
+		}
+	}
+
+	writes, err := d.getConflictingWrites(branches)
+	if err != nil {
+		return err
+	}
+	for scale := uint8(1); scale <= writes.maxScale; scale++ {
+		if err := d.mergeDownres(child, scale, writes.blocks[scale]); err != nil {
+			return err
+		}
+	}
+	timedLog.Infof("Merged %d parents of labelmap %q into version %s, replaying %d mutation log entries",
+		len(parents), d.DataName(), childUUID, numReplayed)
+	return nil
+}
+
+// recomputes the given blocks at a scale from the merged blocks at the next higher resolution.
+func (d *Data) mergeDownres(v dvid.VersionID, scale uint8, blocks map[dvid.IZYXString]bool) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return fmt.Errorf("block size for data %q is not 3d: %v", d.DataName(), d.BlockSize())
+	}
+	batcher, err := datastore.GetKeyValueBatcher(d)
+	if err != nil {
+		return err
+	}
+	ctx := datastore.NewVersionedCtx(d, v)
+	batch := batcher.NewBatch(ctx)
+	for loresZYX := range blocks {
+		loresCoord, err := loresZYX.ToChunkPoint3d()
+		if err != nil {
+			return err
+		}
+		var octant [8]*labels.Block
+		for octidx := int32(0); octidx < 8; octidx++ {
+			hiresCoord := dvid.ChunkPoint3d{
+				loresCoord[0]*2 + octidx%2,
+				loresCoord[1]*2 + (octidx>>1)%2,
+				loresCoord[2]*2 + octidx>>2,
+			}
+			if octant[octidx], err = d.GetLabelBlock(v, hiresCoord, scale-1); err != nil {
+				return err
+			}
+		}
+		loresBlock := labels.MakeSolidBlock(0, blockSize)
+		if err := loresBlock.Downres(octant); err != nil {
+			return err
+		}
+		compressed, _ := loresBlock.MarshalBinary()
+		serialization, err := dvid.SerializeData(compressed, d.Compression(), d.Checksum())
+		if err != nil {
+			return fmt.Errorf("unable to serialize downres block in %q: %v", d.DataName(), err)
+		}
+		batch.Put(NewBlockTKeyByCoord(scale, loresZYX), serialization)
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("error on trying to write merged downres blocks of scale %d: %v", scale, err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package labelmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func newTestBranch(t *testing.T, parent dvid.UUID, branch string) dvid.UUID {
+	child, err := datastore.NewVersion(parent, fmt.Sprintf("branch %s", branch), branch, nil)
+	if err != nil {
+		t.Fatalf("couldn't create branch %q: %v\n", branch, err)
+	}
+	return child
+}
+
+func commitTestVersion(t *testing.T, uuid dvid.UUID) {
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	if err := datastore.Commit(uuid, "", nil); err != nil {
+		t.Fatalf("couldn't commit %s: %v\n", uuid, err)
+	}
+}
+
+func postRepoMerge(t *testing.T, mergeType string, parents ...dvid.UUID) *http.Response {
+	parentsJSON, err := json.Marshal(parents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := fmt.Sprintf(`{"mergeType": %q, "parents": %s, "note": "type-specific merge"}`, mergeType, string(parentsJSON))
+	reqStr := fmt.Sprintf("%srepo/%s/merge", server.WebAPIPath, parents[0])
+	return server.TestHTTPResponse(t, "POST", reqStr, bytes.NewBufferString(payload)).Result()
+}
+
+func TestTypeSpecificMerge(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelmap", "labels", config)
+	expected := createLabelTestVolume(t, uuid, "labels")
+	commitTestVersion(t, uuid)
+
+	// Make disjoint merges in two branches.
+	branchA := newTestBranch(t, uuid, "a")
+	mergeJSON(`[4, 3]`).send(t, branchA, "labels")
+	commitTestVersion(t, branchA)
+
+	branchB := newTestBranch(t, uuid, "b")
+	mergeJSON(`[2, 1]`).send(t, branchB, "labels")
+	commitTestVersion(t, branchB)
+
+	resp := postRepoMerge(t, "type-specific", branchA, branchB)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected successful type-specific merge, got status %d\n", resp.StatusCode)
+	}
+	var childResp struct {
+		Child dvid.UUID `json:"child"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&childResp); err != nil {
+		t.Fatalf("unable to decode merge response: %v\n", err)
+	}
+	child := childResp.Child
+
+	retrieved := newTestVolume(128, 128, 128)
+	retrieved.get(t, child, "labels", false)
+	expected.addBody(body3, 4)
+	expected.addBody(body1, 2)
+	if err := retrieved.equals(expected); err != nil {
+		t.Errorf("merged label volume not equal to expected: %v\n", err)
+	}
+	reqStr := fmt.Sprintf("%snode/%s/labels/sparsevol/2", server.WebAPIPath, child)
+	encoding := server.TestHTTP(t, "GET", reqStr, nil)
+	body1.add(body2).checkSparseVol(t, encoding, dvid.OptionalBounds{})
+
+	// A branch that modifies label 4 again should conflict with branch A.
+	branchC := newTestBranch(t, uuid, "c")
+	mergeJSON(`[4, 2]`).send(t, branchC, "labels")
+	commitTestVersion(t, branchC)
+
+	resp = postRepoMerge(t, "type-specific", branchA, branchC)
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected conflict status for type-specific merge, got %d\n", resp.StatusCode)
+	}
+	var conflictResp struct {
+		Conflicts map[string]MergeConflicts `json:"conflicts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&conflictResp); err != nil {
+		t.Fatalf("unable to decode merge conflicts: %v\n", err)
+	}
+	conflicts, found := conflictResp.Conflicts["labels"]
+	if !found {
+		t.Fatalf("expected conflicts for labels instance, got %v\n", conflictResp)
+	}
+	if !reflect.DeepEqual(conflicts.Labels, []uint64{4}) {
+		t.Errorf("expected conflicting label 4, got %v\n", conflicts.Labels)
+	}
+	if len(conflicts.Blocks) != 0 {
+		t.Errorf("expected no conflicting blocks, got %v\n", conflicts.Blocks)
+	}
+	expectedMutIDs := []uint64{datastore.InitialMutationID + 1, datastore.InitialMutationID + 3}
+	if !reflect.DeepEqual(conflicts.MutationIDs, expectedMutIDs) {
+		t.Errorf("expected conflicting mutations %v, got %v\n", expectedMutIDs, conflicts.MutationIDs)
+	}
+
+	// The conflict-free merge is still available.
+	resp = postRepoMerge(t, "conflict-free", branchA, branchC)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected successful conflict-free merge, got status %d\n", resp.StatusCode)
+	}
+}
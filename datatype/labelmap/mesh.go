@@ -0,0 +1,318 @@
+/*
+	This file supports generation of surface meshes for bodies and supervoxels, optionally
+	cached in a keyvalue instance synced to the labelmap.  Skeletons share the cache.
+*/
+
+package labelmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/datatype/common/mesh"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// labelCache is the portion of the keyvalue datatype used to cache per-label data
+// like meshes and skeletons.
+type labelCache interface {
+	dvid.Data
+	GetData(ctx storage.Context, keyStr string) ([]byte, bool, error)
+	PutData(ctx storage.Context, keyStr string, value []byte) error
+}
+
+// MeshCacheKey returns the key under which a mesh is cached in a keyvalue instance synced
+// to a labelmap.  All keys for a label begin with the label followed by an underscore.
+func MeshCacheKey(label uint64, scale uint8, format string, supervoxels bool) string {
+	if supervoxels {
+		return fmt.Sprintf("%d_svmesh_s%d.%s", label, scale, format)
+	}
+	return fmt.Sprintf("%d_mesh_s%d.%s", label, scale, format)
+}
+
+// returns the first keyvalue instance synced to this labelmap or nil if there is none.
+func (d *Data) getLabelCache() (labelCache, error) {
+	synced, err := datastore.GetSyncedTo(d)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range synced {
+		if data.TypeName() != "keyvalue" {
+			continue
+		}
+		if cache, ok := data.(labelCache); ok {
+			return cache, nil
+		}
+	}
+	return nil, nil
+}
+
+// returns the index for a body or, if supervoxels is true, the index limited to a supervoxel.
+func (d *Data) getMeshIndex(v dvid.VersionID, label uint64, supervoxels bool) (*labels.Index, error) {
+	idx, err := GetLabelIndex(d, v, label, supervoxels)
+	if err != nil || idx == nil {
+		return nil, err
+	}
+	if supervoxels {
+		return idx.LimitToSupervoxel(label)
+	}
+	return idx, nil
+}
+
+// GetMesh returns a surface mesh for a body or, if supervoxels is true, a supervoxel,
+// computed from the label blocks at the given scale.  Only blocks within the label index
+// are read.  Vertices are in the units of the data's voxel size at scale 0.  A nil mesh
+// is returned if the label doesn't exist.
+func (d *Data) GetMesh(v dvid.VersionID, label uint64, scale uint8, supervoxels bool) (*mesh.Mesh, error) {
+	if scale > d.MaxDownresLevel {
+		return nil, fmt.Errorf("scale %d exceeds max downres level %d of labelmap %q", scale, d.MaxDownresLevel, d.DataName())
+	}
+	idx, err := d.getMeshIndex(v, label, supervoxels)
+	if err != nil || idx == nil {
+		return nil, err
+	}
+	return d.computeMesh(v, idx, label, scale, supervoxels)
+}
+
+// returns a mask for each block at the given scale with voxels of the label, using
+// the label index to determine the blocks to read.
+func (d *Data) getBlockMasks(v dvid.VersionID, idx *labels.Index, label uint64, scale uint8, supervoxels bool) (map[dvid.ChunkPoint3d][]bool, error) {
+	var svset labels.Set
+	if supervoxels {
+		svset = labels.NewSet(label)
+	} else {
+		svset = idx.GetSupervoxels()
+	}
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return nil, fmt.Errorf("labelmap %q has non-3d block size %s", d.DataName(), d.BlockSize())
+	}
+	indices, err := idx.GetProcessedBlockIndices(scale, dvid.Bounds{})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := datastore.NewVersionedCtx(d, v)
+	masks := make(map[dvid.ChunkPoint3d][]bool, len(indices))
+	for _, izyx := range indices {
+		pb, err := d.getLabelBlock(ctx, scale, izyx)
+		if err != nil {
+			return nil, err
+		}
+		if pb == nil {
+			continue
+		}
+		bcoord, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return nil, err
+		}
+		lblarray, size := pb.MakeLabelVolume()
+		if size != blockSize {
+			return nil, fmt.Errorf("block %s in labelmap %q has size %s, expected %s", bcoord, d.DataName(), size, blockSize)
+		}
+		mask := make([]bool, size.Prod())
+		for i := range mask {
+			_, mask[i] = svset[binary.LittleEndian.Uint64(lblarray[i*8:i*8+8])]
+		}
+		masks[bcoord] = mask
+	}
+	return masks, nil
+}
+
+// returns the physical size of a voxel at the given scale.
+func (d *Data) scaledVoxelSize(scale uint8) (voxelSize [3]float32) {
+	for dim := 0; dim < 3; dim++ {
+		voxelSize[dim] = float32(int32(1) << scale)
+		if dim < len(d.Properties.VoxelSize) {
+			voxelSize[dim] *= d.Properties.VoxelSize[dim]
+		}
+	}
+	return
+}
+
+func (d *Data) computeMesh(v dvid.VersionID, idx *labels.Index, label uint64, scale uint8, supervoxels bool) (*mesh.Mesh, error) {
+	masks, err := d.getBlockMasks(v, idx, label, scale, supervoxels)
+	if err != nil {
+		return nil, err
+	}
+	blockSize := d.BlockSize().(dvid.Point3d)
+
+	var lastCoord dvid.ChunkPoint3d
+	var lastMask []bool
+	var haveLast bool
+	inside := func(x, y, z int32) bool {
+		bcoord := dvid.ChunkPoint3d{floorDiv(x, blockSize[0]), floorDiv(y, blockSize[1]), floorDiv(z, blockSize[2])}
+		if !haveLast || bcoord != lastCoord {
+			lastCoord, lastMask, haveLast = bcoord, masks[bcoord], true
+		}
+		if lastMask == nil {
+			return false
+		}
+		bx := x - bcoord[0]*blockSize[0]
+		by := y - bcoord[1]*blockSize[1]
+		bz := z - bcoord[2]*blockSize[2]
+		return lastMask[(bz*blockSize[1]+by)*blockSize[0]+bx]
+	}
+
+	// March the cubes whose minimum corner is in each block with label voxels.  Cubes
+	// with minimum corner in an adjacent block without label voxels need only be marched
+	// along the faces of that block nearest the labeled block.  Blocks are marched in
+	// sorted order so the mesh is deterministic.
+	bcoords := make([]dvid.ChunkPoint3d, 0, len(masks))
+	for bcoord := range masks {
+		bcoords = append(bcoords, bcoord)
+	}
+	sort.Slice(bcoords, func(i, j int) bool {
+		a, b := bcoords[i], bcoords[j]
+		if a[2] != b[2] {
+			return a[2] < b[2]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[0] < b[0]
+	})
+	builder := mesh.NewBuilder()
+	absentDone := make(map[dvid.ChunkPoint3d]struct{})
+	for _, bcoord := range bcoords {
+		bmin := dvid.Point3d{bcoord[0] * blockSize[0], bcoord[1] * blockSize[1], bcoord[2] * blockSize[2]}
+		builder.March(bmin, bmin.Add3d(blockSize), inside)
+
+		for off := int32(1); off < 8; off++ {
+			nb := dvid.ChunkPoint3d{bcoord[0] - (off & 1), bcoord[1] - (off>>1)&1, bcoord[2] - (off>>2)&1}
+			if _, found := masks[nb]; found {
+				continue
+			}
+			if _, found := absentDone[nb]; found {
+				continue
+			}
+			absentDone[nb] = struct{}{}
+			nbmin := dvid.Point3d{nb[0] * blockSize[0], nb[1] * blockSize[1], nb[2] * blockSize[2]}
+			nbmax := nbmin.Add3d(blockSize)
+			builder.March(dvid.Point3d{nbmax[0] - 1, nbmin[1], nbmin[2]}, nbmax, inside)
+			builder.March(dvid.Point3d{nbmin[0], nbmax[1] - 1, nbmin[2]}, dvid.Point3d{nbmax[0] - 1, nbmax[1], nbmax[2]}, inside)
+			builder.March(dvid.Point3d{nbmin[0], nbmin[1], nbmax[2] - 1}, dvid.Point3d{nbmax[0] - 1, nbmax[1] - 1, nbmax[2]}, inside)
+		}
+	}
+
+	return builder.Mesh(d.scaledVoxelSize(scale)), nil
+}
+
+// returns the floor of a / b for positive b.
+func floorDiv(a, b int32) int32 {
+	if a < 0 {
+		return (a - b + 1) / b
+	}
+	return a / b
+}
+
+// GetMeshData returns a serialized mesh in the given format, using and populating a mesh
+// cache in a synced keyvalue instance if available.  A nil slice is returned if the label
+// doesn't exist.
+func (d *Data) GetMeshData(v dvid.VersionID, label uint64, scale uint8, format string, supervoxels bool) ([]byte, error) {
+	if !mesh.ValidFormat(format) {
+		return nil, fmt.Errorf("unknown mesh format %q", format)
+	}
+	if scale > d.MaxDownresLevel {
+		return nil, fmt.Errorf("scale %d exceeds max downres level %d of labelmap %q", scale, d.MaxDownresLevel, d.DataName())
+	}
+
+	// Always check the label index so deleted or split labels aren't served from cache.
+	idx, err := d.getMeshIndex(v, label, supervoxels)
+	if err != nil || idx == nil {
+		return nil, err
+	}
+
+	cache, err := d.getLabelCache()
+	if err != nil {
+		return nil, err
+	}
+	key := MeshCacheKey(label, scale, format, supervoxels)
+	if cache != nil {
+		data, found, err := cache.GetData(datastore.NewVersionedCtx(cache, v), key)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return data, nil
+		}
+	}
+
+	m, err := d.computeMesh(v, idx, label, scale, supervoxels)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := m.Write(&buf, format); err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		// Only cache if the label wasn't modified while the mesh was being computed.
+		curIdx, err := d.getMeshIndex(v, label, supervoxels)
+		if err == nil && curIdx != nil && curIdx.LastMutId == idx.LastMutId && len(curIdx.Blocks) == len(idx.Blocks) {
+			if err := cache.PutData(datastore.NewVersionedCtx(cache, v), key, buf.Bytes()); err != nil {
+				dvid.Errorf("unable to cache mesh for label %d in %q: %v\n", label, cache.DataName(), err)
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *Data) handleMesh(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
+	// GET <api URL>/node/<UUID>/<data name>/mesh/<label>?scale=N&format=ngmesh|obj|ply
+	if strings.ToLower(r.Method) != "get" {
+		server.BadRequest(w, r, "DVID does not support %s on /mesh endpoint", r.Method)
+		return
+	}
+	if len(parts) < 5 {
+		server.BadRequest(w, r, "DVID requires label ID to follow 'mesh' command")
+		return
+	}
+	timedLog := dvid.NewTimeLog()
+
+	label, err := strconv.ParseUint(parts[4], 10, 64)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if label == 0 {
+		server.BadRequest(w, r, "Label 0 is protected background value and cannot be used for meshes")
+		return
+	}
+	queryStrings := r.URL.Query()
+	scale, err := getScale(queryStrings)
+	if err != nil {
+		server.BadRequest(w, r, "bad scale specified: %v", err)
+		return
+	}
+	format := queryStrings.Get("format")
+	if format == "" {
+		format = mesh.FormatNgmesh
+	}
+	isSupervoxel := queryStrings.Get("supervoxels") == "true"
+
+	data, err := d.GetMeshData(ctx.VersionID(), label, scale, format, isSupervoxel)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if data == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-type", mesh.ContentType(format))
+	if _, err := w.Write(data); err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	timedLog.Infof("HTTP GET mesh for label %d, scale %d, format %s (%s)", label, scale, format, r.URL)
+}
@@ -0,0 +1,170 @@
+package labelmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+
+	_ "github.com/janelia-flyem/dvid/datatype/keyvalue"
+)
+
+// decodes a Neuroglancer legacy mesh into vertices and triangles.
+func decodeNgmesh(t *testing.T, data []byte) ([]float32, []uint32) {
+	if len(data) < 4 {
+		t.Fatalf("ngmesh too small: %d bytes\n", len(data))
+	}
+	numVertices := int(binary.LittleEndian.Uint32(data[:4]))
+	if len(data) < 4+12*numVertices || (len(data)-4-12*numVertices)%12 != 0 {
+		t.Fatalf("bad ngmesh size %d for %d vertices\n", len(data), numVertices)
+	}
+	vertices := make([]float32, 3*numVertices)
+	triangles := make([]uint32, (len(data)-4-12*numVertices)/4)
+	buf := bytes.NewBuffer(data[4:])
+	binary.Read(buf, binary.LittleEndian, vertices)
+	binary.Read(buf, binary.LittleEndian, triangles)
+	return vertices, triangles
+}
+
+// checks the ngmesh is closed and returns its enclosed volume.
+func checkNgmesh(t *testing.T, desc string, data []byte) float64 {
+	vertices, triangles := decodeNgmesh(t, data)
+	if len(triangles) == 0 {
+		t.Fatalf("%s: no triangles in mesh\n", desc)
+	}
+	edges := make(map[[2]uint32]int)
+	var vol float64
+	for i := 0; i < len(triangles); i += 3 {
+		var p [3][3]float64
+		for j := 0; j < 3; j++ {
+			v := triangles[i+j]
+			if int(v) >= len(vertices)/3 {
+				t.Fatalf("%s: bad vertex index %d\n", desc, v)
+			}
+			edges[[2]uint32{v, triangles[i+(j+1)%3]}]++
+			for dim := 0; dim < 3; dim++ {
+				p[j][dim] = float64(vertices[3*v+uint32(dim)])
+			}
+		}
+		vol += p[0][0]*(p[1][1]*p[2][2]-p[1][2]*p[2][1]) -
+			p[0][1]*(p[1][0]*p[2][2]-p[1][2]*p[2][0]) +
+			p[0][2]*(p[1][0]*p[2][1]-p[1][1]*p[2][0])
+	}
+	for edge, count := range edges {
+		if count != 1 || edges[[2]uint32{edge[1], edge[0]}] != 1 {
+			t.Fatalf("%s: mesh is not closed and consistently oriented at edge %v\n", desc, edge)
+		}
+	}
+	return vol / 6
+}
+
+func TestMeshes(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	config.Set("VoxelSize", "4,4,4")
+	config.Set("MaxDownresLevel", "2")
+	server.CreateTestInstance(t, uuid, "labelmap", "labels", config)
+
+	vol := newTestVolume(64, 64, 64)
+	vol.addSubvol(dvid.Point3d{8, 8, 8}, dvid.Point3d{40, 16, 16}, 1)
+	vol.addSubvol(dvid.Point3d{40, 40, 24}, dvid.Point3d{16, 16, 16}, 2)
+	vol.put(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("error blocking on labels update: %v\n", err)
+	}
+
+	// Label 1 spans two blocks and its mesh should enclose about its voxel volume.
+	url := fmt.Sprintf("%snode/%s/labels/mesh/1", server.WebAPIPath, uuid)
+	mesh1 := server.TestHTTP(t, "GET", url, nil)
+	voxelVol := float64(40*16*16) * 4 * 4 * 4
+	if vol := checkNgmesh(t, "label 1", mesh1); vol < 0.85*voxelVol || vol > voxelVol {
+		t.Errorf("expected label 1 mesh volume near %f, got %f\n", voxelVol, vol)
+	}
+	vertices, _ := decodeNgmesh(t, mesh1)
+	for i := 0; i < len(vertices); i += 3 {
+		x, y, z := vertices[i]/4, vertices[i+1]/4, vertices[i+2]/4
+		if x < 8 || x > 48 || y < 8 || y > 24 || z < 8 || z > 24 {
+			t.Fatalf("label 1 mesh vertex (%f, %f, %f) outside label bounds\n", x, y, z)
+		}
+	}
+
+	url = fmt.Sprintf("%snode/%s/labels/mesh/1?scale=1&format=obj", server.WebAPIPath, uuid)
+	obj := string(server.TestHTTP(t, "GET", url, nil))
+	if !strings.HasPrefix(obj, "v ") || !strings.Contains(obj, "\nf ") {
+		t.Errorf("bad OBJ mesh returned\n")
+	}
+	url = fmt.Sprintf("%snode/%s/labels/mesh/1?format=ply", server.WebAPIPath, uuid)
+	if ply := server.TestHTTP(t, "GET", url, nil); !bytes.HasPrefix(ply, []byte("ply\nformat binary_little_endian 1.0\n")) {
+		t.Errorf("bad PLY mesh header returned\n")
+	}
+	url = fmt.Sprintf("%snode/%s/labels/mesh/1?format=stl", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "GET", url, nil)
+	url = fmt.Sprintf("%snode/%s/labels/mesh/3", server.WebAPIPath, uuid)
+	if resp := server.TestHTTPResponse(t, "GET", url, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for mesh of nonexistent label, got %d\n", resp.Code)
+	}
+
+	// Cache meshes in a synced keyvalue and make sure a merge invalidates them.
+	server.CreateTestInstance(t, uuid, "keyvalue", "meshes", dvid.Config{})
+	server.CreateTestSync(t, uuid, "meshes", "labels")
+
+	url = fmt.Sprintf("%snode/%s/labels/mesh/2", server.WebAPIPath, uuid)
+	mesh2 := server.TestHTTP(t, "GET", url, nil)
+	checkNgmesh(t, "label 2", mesh2)
+	keyURL := fmt.Sprintf("%snode/%s/meshes/key/%s", server.WebAPIPath, uuid, MeshCacheKey(2, 0, "ngmesh", false))
+	if cached := server.TestHTTP(t, "GET", keyURL, nil); !bytes.Equal(cached, mesh2) {
+		t.Errorf("cached mesh for label 2 differs from returned mesh\n")
+	}
+	url = fmt.Sprintf("%snode/%s/labels/mesh/2?supervoxels=true", server.WebAPIPath, uuid)
+	if svmesh := server.TestHTTP(t, "GET", url, nil); !bytes.Equal(svmesh, mesh2) {
+		t.Errorf("expected supervoxel 2 mesh to equal body 2 mesh before merge\n")
+	}
+
+	testMerge := mergeJSON(`[1, 2]`)
+	testMerge.send(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "meshes"); err != nil {
+		t.Fatalf("error blocking on meshes update: %v\n", err)
+	}
+	server.TestBadHTTP(t, "GET", keyURL, nil)
+	svKeyURL := fmt.Sprintf("%snode/%s/meshes/key/%s", server.WebAPIPath, uuid, MeshCacheKey(2, 0, "ngmesh", true))
+	server.TestBadHTTP(t, "GET", svKeyURL, nil)
+
+	url = fmt.Sprintf("%snode/%s/labels/mesh/1", server.WebAPIPath, uuid)
+	merged := server.TestHTTP(t, "GET", url, nil)
+	_, tri1 := decodeNgmesh(t, mesh1)
+	_, tri2 := decodeNgmesh(t, mesh2)
+	_, triMerged := decodeNgmesh(t, merged)
+	if len(triMerged) != len(tri1)+len(tri2) {
+		t.Errorf("expected merged mesh of %d triangles, got %d\n", (len(tri1)+len(tri2))/3, len(triMerged)/3)
+	}
+	url = fmt.Sprintf("%snode/%s/labels/mesh/2", server.WebAPIPath, uuid)
+	if resp := server.TestHTTPResponse(t, "GET", url, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for mesh of merged label 2, got %d\n", resp.Code)
+	}
+	// Writing a block with a supervoxel of a body invalidates the body's cached mesh.
+	keyURL = fmt.Sprintf("%snode/%s/meshes/key/%s", server.WebAPIPath, uuid, MeshCacheKey(1, 0, "ngmesh", false))
+	if cached := server.TestHTTP(t, "GET", keyURL, nil); !bytes.Equal(cached, merged) {
+		t.Errorf("cached mesh for merged label 1 differs from returned mesh\n")
+	}
+	vol.addSubvol(dvid.Point3d{40, 40, 24}, dvid.Point3d{4, 4, 4}, 0)
+	vol.putMutable(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("error blocking on labels update: %v\n", err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "meshes"); err != nil {
+		t.Fatalf("error blocking on meshes update: %v\n", err)
+	}
+	server.TestBadHTTP(t, "GET", keyURL, nil)
+}
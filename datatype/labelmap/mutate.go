@@ -44,6 +44,13 @@ type sizeChange struct {
 // labels.MergeEndEvent occurs at end of merge and transmits labels.DeltaMergeEnd struct.
 //
 func (d *Data) MergeLabels(v dvid.VersionID, op labels.MergeOp, info dvid.ModInfo) (mutID uint64, err error) {
+	d.mutateMu.RLock()
+	defer d.mutateMu.RUnlock()
+	return d.mergeLabels(v, op, info)
+}
+
+// merges labels for callers that already hold the mutation lock.
+func (d *Data) mergeLabels(v dvid.VersionID, op labels.MergeOp, info dvid.ModInfo) (mutID uint64, err error) {
 	dvid.Debugf("Merging %s into label %d ...\n", op.Merged, op.Target)
 
 	d.StartUpdate()
@@ -51,6 +58,7 @@ func (d *Data) MergeLabels(v dvid.VersionID, op labels.MergeOp, info dvid.ModInf
 
 	timedLog := dvid.NewTimeLog()
 	mutID = d.NewMutationID()
+	op.MutID = mutID
 
 	// send kafka merge event to instance-uuid topic
 	// msg: {"action": "merge", "target": targetlabel, "labels": [merge labels]}
@@ -128,6 +136,9 @@ func (d *Data) MergeLabels(v dvid.VersionID, op labels.MergeOp, info dvid.ModInf
 	if err = labels.LogMerge(d, v, op); err != nil {
 		return
 	}
+	if err = storeMutationTime(d, v, mutID); err != nil {
+		return
+	}
 
 	dvid.Infof("merged label %d: supervoxels %v, %d blocks\n", op.Target, mergeIdx.GetSupervoxels(), len(mergeIdx.Blocks))
 
@@ -166,14 +177,21 @@ func (d *Data) MergeLabels(v dvid.VersionID, op labels.MergeOp, info dvid.ModInf
 // given a new label or the one optionally supplied via the "cleavelabel" query string.
 // A cleave label can be specified via the "toLabel" parameter, which if 0 will have an
 // automatic label ID selected for the cleaved body.
-func (d *Data) CleaveLabel(v dvid.VersionID, label uint64, info dvid.ModInfo, r io.ReadCloser) (cleaveLabel, mutID uint64, err error) {
+func (d *Data) CleaveLabel(v dvid.VersionID, label, toLabel uint64, info dvid.ModInfo, r io.ReadCloser) (cleaveLabel, mutID uint64, err error) {
+	d.mutateMu.RLock()
+	defer d.mutateMu.RUnlock()
+
 	if r == nil {
 		err = fmt.Errorf("no cleave supervoxels JSON was POSTed")
 		return
 	}
 
-	cleaveLabel, err = d.newLabel(v)
-	if err != nil {
+	if toLabel != 0 {
+		cleaveLabel = toLabel
+		if _, err = d.updateMaxLabel(v, toLabel); err != nil {
+			return
+		}
+	} else if cleaveLabel, err = d.newLabel(v); err != nil {
 		return
 	}
 	dvid.Debugf("Cleaving subset of label %d into new label %d.\n", label, cleaveLabel)
@@ -193,7 +211,12 @@ func (d *Data) CleaveLabel(v dvid.VersionID, label uint64, info dvid.ModInfo, r
 		err = fmt.Errorf("bad cleave supervoxels JSON: %v", err)
 		return
 	}
+	mutID, err = d.cleaveLabel(v, label, cleaveLabel, cleaveSupervoxels, info)
+	return
+}
 
+// cleaves the given supervoxels from a label into the given cleave label.
+func (d *Data) cleaveLabel(v dvid.VersionID, label, cleaveLabel uint64, cleaveSupervoxels []uint64, info dvid.ModInfo) (mutID uint64, err error) {
 	// send kafka cleave event to instance-uuid topic
 	mutID = d.NewMutationID()
 	versionuuid, _ := datastore.UUIDFromVersion(v)
@@ -244,6 +267,9 @@ func (d *Data) CleaveLabel(v dvid.VersionID, label uint64, info dvid.ModInfo, r
 	if err = labels.LogCleave(d, v, op); err != nil {
 		return
 	}
+	if err = storeMutationTime(d, v, mutID); err != nil {
+		return
+	}
 
 	// notify syncs after processing because downstream sync might rely on changes
 	evt := datastore.SyncEvent{d.DataUUID(), labels.CleaveLabelEvent}
@@ -271,10 +297,9 @@ func (d *Data) CleaveLabel(v dvid.VersionID, label uint64, info dvid.ModInfo, r
 type blockSplitsMap map[uint64]map[uint64]labels.SVSplitCount
 
 // 1st pass: retrieve and check blocks intersecting split RLEs and get mappings of current supervoxels
-// to new split supervoxels.
-func (d *Data) splitPass1(ctx *datastore.VersionedCtx, splitmap dvid.BlockRLEs, splitblks dvid.IZYXSlice) (blockSplitsMap, *labels.SVSplitMap, error) {
+// to new split supervoxels, adding any not already in the given mappings.
+func (d *Data) splitPass1(ctx *datastore.VersionedCtx, splitmap dvid.BlockRLEs, splitblks dvid.IZYXSlice, svsplit *labels.SVSplitMap) (blockSplitsMap, error) {
 	timedLog := dvid.NewTimeLog()
-	svsplit := new(labels.SVSplitMap)
 	newLabelFunc := func() (uint64, error) {
 		return d.newLabel(ctx.VersionID())
 	}
@@ -313,10 +338,10 @@ func (d *Data) splitPass1(ctx *datastore.VersionedCtx, splitmap dvid.BlockRLEs,
 	for _, izyx := range splitblks {
 		pb, err := d.getLabelBlock(ctx, scale, izyx)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 		if pb == nil {
-			return nil, nil, fmt.Errorf("split on block %s attempted but block doesn't exist", izyx)
+			return nil, fmt.Errorf("split on block %s attempted but block doesn't exist", izyx)
 		}
 		numBlocks++
 		blockCh <- pb
@@ -334,7 +359,7 @@ func (d *Data) splitPass1(ctx *datastore.VersionedCtx, splitmap dvid.BlockRLEs,
 	}
 
 	timedLog.Debugf("split pass 1 completed: %d blocks with %d errors (max queue %d/%d)\n", numBlocks, numErr, maxQueue, len(splitblks))
-	return blockSplits, svsplit, lastErr
+	return blockSplits, lastErr
 }
 
 // if every split supervoxel in a split block is fully replaceable, we can just switch header.
@@ -520,15 +545,31 @@ func getAffectedBlocks(idx *labels.Index, svsplit *labels.SVSplitMap) (affectedB
 // preferably be the smaller portion of a labeled region.  In other words, the caller should chose
 // to submit for relabeling the smaller portion of any split.  It is assumed that the given split
 // voxels are within the fromLabel set of voxels and will generate unspecified behavior if this is
-// not the case.
-func (d *Data) SplitLabels(v dvid.VersionID, fromLabel uint64, r io.ReadCloser, info dvid.ModInfo) (toLabel, mutID uint64, err error) {
+// not the case.  The split supervoxels are added to the given mappings of supervoxels to their
+// split and remaining labels, and supervoxels already mapped, e.g., by a leader whose split is
+// being replayed, keep their labels.
+func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, svsplit *labels.SVSplitMap, r io.ReadCloser, info dvid.ModInfo) (toLabel, mutID uint64, err error) {
+	d.mutateMu.RLock()
+	defer d.mutateMu.RUnlock()
+
 	timedLog := dvid.NewTimeLog()
 
 	// Create a new label id for this version that will persist to store
-	toLabel, err = d.newLabel(v)
-	if err != nil {
+	if splitLabel != 0 {
+		toLabel = splitLabel
+		if _, err = d.updateMaxLabel(v, splitLabel); err != nil {
+			return
+		}
+	} else if toLabel, err = d.newLabel(v); err != nil {
 		return
 	}
+	for _, split := range svsplit.Splits {
+		for _, label := range []uint64{split.Split, split.Remain} {
+			if _, err = d.updateMaxLabel(v, label); err != nil {
+				return
+			}
+		}
+	}
 	dvid.Debugf("Splitting subset of label %d into new label %d ...\n", fromLabel, toLabel)
 
 	// Read the sparse volume from reader.
@@ -588,8 +629,7 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel uint64, r io.ReadCloser,
 	// to new split supervoxels
 	ctx := datastore.NewVersionedCtx(d, v)
 	var blockSplits blockSplitsMap
-	var svsplit *labels.SVSplitMap
-	if blockSplits, svsplit, err = d.splitPass1(ctx, splitmap, splitblks); err != nil {
+	if blockSplits, err = d.splitPass1(ctx, splitmap, splitblks, svsplit); err != nil {
 		return
 	}
 	labelSupervoxels := idx.GetSupervoxels()
@@ -660,6 +700,9 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel uint64, r io.ReadCloser,
 	if err = labels.LogSplit(d, v, op); err != nil {
 		return
 	}
+	if err = storeMutationTime(d, v, mutID); err != nil {
+		return
+	}
 	if err = downresMut.Execute(); err != nil {
 		return
 	}
@@ -698,6 +741,9 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel uint64, r io.ReadCloser,
 // The first returned label is assigned to the split voxels while the second returned label is
 // assigned to the remainder voxels.
 func (d *Data) SplitSupervoxel(v dvid.VersionID, svlabel, splitlabel, remainlabel uint64, r io.ReadCloser, info dvid.ModInfo, downscale bool) (splitSupervoxel, remainSupervoxel, mutID uint64, err error) {
+	d.mutateMu.RLock()
+	defer d.mutateMu.RUnlock()
+
 	timedLog := dvid.NewTimeLog()
 
 	// Create new labels for this split that will persist to store
@@ -888,6 +934,9 @@ func (d *Data) SplitSupervoxel(v dvid.VersionID, svlabel, splitlabel, remainlabe
 	if err = labels.LogSupervoxelSplit(d, v, op); err != nil {
 		return
 	}
+	if err = storeMutationTime(d, v, mutID); err != nil {
+		return
+	}
 	// store the new split index
 	if err = putCachedLabelIndex(d, v, idx); err != nil {
 		d.restoreOldBlocks(ctx, numBlocks, origBlocks)
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"reflect"
@@ -2123,6 +2124,33 @@ func TestMergeCleave(t *testing.T) {
 	encoding := server.TestHTTP(t, "GET", reqStr, nil)
 	body3.checkSparseVol(t, encoding, dvid.OptionalBounds{})
 
+	// A replayed cleave uses the label assigned by the leader.
+	testMerge = mergeJSON(`[4, 5]`)
+	testMerge.send(t, uuid, "labels")
+	d, err := GetByUUIDName(uuid, "labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := datastore.VersionFromUUID(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleaveLabel, _, err := d.CleaveLabel(v, 4, 20, dvid.ModInfo{}, ioutil.NopCloser(bytes.NewBufferString("[3]")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cleaveLabel != 20 {
+		t.Errorf("expected cleave into assigned label 20, got %d\n", cleaveLabel)
+	}
+	reqStr = fmt.Sprintf("%snode/%s/labels/maxlabel", server.WebAPIPath, uuid)
+	jsonStr = server.TestHTTP(t, "GET", reqStr, nil)
+	if string(jsonStr) != `{"maxlabel": 20}` {
+		t.Errorf("expected max label 20 after cleave into assigned label, got %s\n", string(jsonStr))
+	}
+	reqStr = fmt.Sprintf("%snode/%s/labels/sparsevol/20", server.WebAPIPath, uuid)
+	encoding = server.TestHTTP(t, "GET", reqStr, nil)
+	body3.checkSparseVol(t, encoding, dvid.OptionalBounds{})
+
 	// make sure you can't cleave all supervoxels from a label
 	reqStr = fmt.Sprintf("%snode/%s/labels/cleave/4", server.WebAPIPath, uuid)
 	server.TestBadHTTP(t, "POST", reqStr, bytes.NewBufferString("[4]"))
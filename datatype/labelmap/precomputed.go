@@ -0,0 +1,236 @@
+/*
+	This file supports serving and exporting labelmap data in the Neuroglancer precomputed format.
+*/
+
+package labelmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/datatype/common/precomputed"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// precomputedOptions are the settings for precomputed output given via path elements
+// in HTTP requests or via settings in the export command.
+type precomputedOptions struct {
+	encoding    string
+	supervoxels bool
+}
+
+func parsePrecomputedOptions(options []string) (opts precomputedOptions, err error) {
+	opts.encoding = precomputed.EncodingCompressedSegmentation
+	for _, option := range options {
+		switch option {
+		case "supervoxels":
+			opts.supervoxels = true
+		default:
+			opts.encoding = option
+		}
+	}
+	err = precomputed.ValidEncoding(opts.encoding, "uint64")
+	return
+}
+
+// precomputedInfo returns the info for a multiscale volume covering the extents of this
+// data with a scale for each down-res level.
+func (d *Data) precomputedInfo(ctx *datastore.VersionedCtx, encoding string) (info precomputed.Info, err error) {
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		err = fmt.Errorf("data %q has non-3d block size %s", d.DataName(), d.BlockSize())
+		return
+	}
+	var extents dvid.Extents
+	if extents, err = d.GetExtents(ctx); err != nil {
+		return
+	}
+	if extents.MinPoint == nil || extents.MaxPoint == nil {
+		err = fmt.Errorf("data %q has no extents yet", d.DataName())
+		return
+	}
+	minPoint := extents.MinPoint.(dvid.Point3d)
+	maxPoint := extents.MaxPoint.(dvid.Point3d)
+	info = precomputed.NewInfo("uint64", "segmentation")
+	for scale := uint8(0); scale <= d.MaxDownresLevel; scale++ {
+		minBlock, maxBlock := precomputed.BlockExtents(minPoint, maxPoint, blockSize, scale)
+		info.Scales = append(info.Scales, precomputed.NewScale(scale, minBlock, maxBlock, blockSize, d.Properties.VoxelSize, encoding))
+	}
+	return
+}
+
+// encodes a label block in the precomputed format, first mapping supervoxels to labels if requested.
+func (d *Data) encodePrecomputedBlock(v dvid.VersionID, block *labels.Block, opts precomputedOptions) ([]byte, error) {
+	if !opts.supervoxels {
+		mapping, err := getMapping(d, v)
+		if err != nil {
+			return nil, err
+		}
+		if mapping != nil && mapping.exists(v) {
+			if err := modifyBlockMapping(v, block, mapping); err != nil {
+				return nil, err
+			}
+		}
+	}
+	uint64array, size := block.MakeLabelVolume()
+	if opts.encoding == precomputed.EncodingRaw {
+		return uint64array, nil
+	}
+	return compressGoogle(uint64array, dvid.NewSubvolume(dvid.Point3d{0, 0, 0}, size))
+}
+
+// getPrecomputedChunk returns a block encoded as a precomputed chunk.  If no block
+// is stored at the given coordinate, a chunk of zero labels is returned.
+func (d *Data) getPrecomputedChunk(ctx *datastore.VersionedCtx, scale uint8, bcoord dvid.ChunkPoint3d, opts precomputedOptions) ([]byte, error) {
+	if scale > d.MaxDownresLevel {
+		return nil, fmt.Errorf("scale %d exceeds maximum down-res level %d for data %q", scale, d.MaxDownresLevel, d.DataName())
+	}
+	pb, err := d.getLabelBlock(ctx, scale, bcoord.ToIZYXString())
+	if err != nil {
+		return nil, err
+	}
+	var block *labels.Block
+	if pb == nil {
+		block = labels.MakeSolidBlock(0, d.BlockSize().(dvid.Point3d))
+	} else {
+		block = &pb.Block
+	}
+	return d.encodePrecomputedBlock(ctx.VersionID(), block, opts)
+}
+
+func (d *Data) handlePrecomputed(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
+	// GET <api URL>/node/<UUID>/<data name>/precomputed[/<option>...]/info
+	// GET <api URL>/node/<UUID>/<data name>/precomputed[/<option>...]/<scale key>/<chunk name>
+	if strings.ToLower(r.Method) != "get" {
+		server.BadRequest(w, r, "only GET action is available on precomputed endpoint")
+		return
+	}
+	timedLog := dvid.NewTimeLog()
+	options, isInfo, scale, chunk, err := precomputed.ParsePath(parts[4:])
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	opts, err := parsePrecomputedOptions(options)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if isInfo {
+		info, err := d.precomputedInfo(ctx, opts.encoding)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		jsonBytes, err := json.Marshal(info)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprint(w, string(jsonBytes))
+		return
+	}
+	bcoord, err := precomputed.ParseChunkName(chunk, d.BlockSize().(dvid.Point3d))
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	data, err := d.getPrecomputedChunk(ctx, scale, bcoord, opts)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-type", "application/octet-stream")
+	if _, err := w.Write(data); err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	timedLog.Infof("HTTP GET precomputed chunk %s, scale %d (%s)", chunk, scale, r.URL)
+}
+
+// ExportPrecomputed writes all stored blocks in the given version to a directory using
+// the Neuroglancer precomputed layout.  Settings can include "encoding" (default
+// "compressed_segmentation" or "raw") and "supervoxels" (default false).  Blocks that
+// were never stored are not written and are treated as empty by Neuroglancer.
+func (d *Data) ExportPrecomputed(uuid dvid.UUID, dirPath string, config dvid.Config) error {
+	timedLog := dvid.NewTimeLog()
+	var options []string
+	encoding, found, err := config.GetString("encoding")
+	if err != nil {
+		return err
+	}
+	if found {
+		options = append(options, encoding)
+	}
+	supervoxels, _, err := config.GetBool("supervoxels")
+	if err != nil {
+		return err
+	}
+	if supervoxels {
+		options = append(options, "supervoxels")
+	}
+	opts, err := parsePrecomputedOptions(options)
+	if err != nil {
+		return err
+	}
+
+	v, err := datastore.VersionFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	ctx := datastore.NewVersionedCtx(d, v)
+	info, err := d.precomputedInfo(ctx, opts.encoding)
+	if err != nil {
+		return err
+	}
+	if err := precomputed.WriteInfo(dirPath, info); err != nil {
+		return err
+	}
+
+	store, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return err
+	}
+	blockSize := d.BlockSize().(dvid.Point3d)
+	var numChunks int
+	for scale := uint8(0); scale <= d.MaxDownresLevel; scale++ {
+		key := precomputed.ScaleKey(scale)
+		begTKey := NewBlockTKeyByCoord(scale, dvid.MinIndexZYX.ToIZYXString())
+		endTKey := NewBlockTKeyByCoord(scale, dvid.MaxIndexZYX.ToIZYXString())
+		err = store.ProcessRange(ctx, begTKey, endTKey, &storage.ChunkOp{}, func(c *storage.Chunk) error {
+			if c == nil || c.TKeyValue == nil || c.V == nil {
+				return nil
+			}
+			_, idx, err := DecodeBlockTKey(c.K)
+			if err != nil {
+				return err
+			}
+			data, _, err := dvid.DeserializeData(c.V, true)
+			if err != nil {
+				return fmt.Errorf("unable to deserialize block %s in %q: %v", idx, d.DataName(), err)
+			}
+			var block labels.Block
+			if err := block.UnmarshalBinary(data); err != nil {
+				return err
+			}
+			encoded, err := d.encodePrecomputedBlock(v, &block, opts)
+			if err != nil {
+				return err
+			}
+			numChunks++
+			return precomputed.WriteChunk(dirPath, key, precomputed.ChunkName(dvid.ChunkPoint3d(*idx), blockSize), encoded)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	timedLog.Infof("Exported %d precomputed chunks for labelmap %q, version %s, to %s", numChunks, d.DataName(), uuid, dirPath)
+	return nil
+}
@@ -0,0 +1,125 @@
+package labelmap
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/precomputed"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// returns the number of voxels with each label in a raw uint64 chunk.
+func countChunkLabels(t *testing.T, data []byte) map[uint64]int {
+	if len(data)%8 != 0 {
+		t.Fatalf("raw chunk has %d bytes, not a multiple of 8\n", len(data))
+	}
+	counts := make(map[uint64]int)
+	for i := 0; i < len(data); i += 8 {
+		counts[binary.LittleEndian.Uint64(data[i:i+8])]++
+	}
+	return counts
+}
+
+func TestPrecomputed(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	config.Set("MaxDownresLevel", "1")
+	server.CreateTestInstance(t, uuid, "labelmap", "labels", config)
+
+	vol := newTestVolume(64, 64, 32)
+	vol.addSubvol(dvid.Point3d{0, 0, 0}, dvid.Point3d{32, 32, 32}, 1)
+	vol.addSubvol(dvid.Point3d{32, 0, 0}, dvid.Point3d{32, 32, 32}, 2)
+	vol.put(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	mergeJSON(`[1, 2]`).send(t, uuid, "labels")
+
+	baseURL := fmt.Sprintf("%snode/%s/labels/precomputed", server.WebAPIPath, uuid)
+	var info precomputed.Info
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", baseURL+"/info", nil), &info); err != nil {
+		t.Fatalf("unable to decode precomputed info: %v\n", err)
+	}
+	if info.DataType != "uint64" || info.VolumeType != "segmentation" || len(info.Scales) != 2 {
+		t.Fatalf("bad precomputed info: %v\n", info)
+	}
+	scale0 := info.Scales[0]
+	if scale0.Encoding != precomputed.EncodingCompressedSegmentation || scale0.Size != [3]int32{64, 64, 32} {
+		t.Errorf("bad precomputed scale 0: %v\n", scale0)
+	}
+	if info.Scales[1].Key != "s1" || info.Scales[1].Size != [3]int32{32, 32, 32} {
+		t.Errorf("bad precomputed scale 1: %v\n", info.Scales[1])
+	}
+
+	// Mapped labels should reflect the merge while supervoxels should not.
+	chunk := server.TestHTTP(t, "GET", baseURL+"/raw/s0/32-64_0-32_0-32", nil)
+	counts := countChunkLabels(t, chunk)
+	if len(counts) != 1 || counts[1] != 32*32*32 {
+		t.Errorf("expected raw chunk to have only merged label 1, got counts %v\n", counts)
+	}
+	chunk = server.TestHTTP(t, "GET", baseURL+"/raw/supervoxels/s0/32-64_0-32_0-32", nil)
+	counts = countChunkLabels(t, chunk)
+	if len(counts) != 1 || counts[2] != 32*32*32 {
+		t.Errorf("expected supervoxel chunk to have only label 2, got counts %v\n", counts)
+	}
+	chunk = server.TestHTTP(t, "GET", baseURL+"/raw/s0/0-32_0-32_32-64", nil)
+	counts = countChunkLabels(t, chunk)
+	if len(counts) != 1 || counts[0] != 32*32*32 {
+		t.Errorf("expected missing block to return zero labels, got counts %v\n", counts)
+	}
+
+	// A solid block is encoded with a single-entry lookup table for each 8x8x8 block.
+	chunk = server.TestHTTP(t, "GET", baseURL+"/s0/0-32_0-32_0-32", nil)
+	expectedBytes := 4 + 64*(8+8)
+	if len(chunk) != expectedBytes {
+		t.Errorf("expected compressed segmentation chunk of %d bytes, got %d\n", expectedBytes, len(chunk))
+	}
+
+	server.TestBadHTTP(t, "GET", baseURL+"/jpeg/info", nil)
+	server.TestBadHTTP(t, "GET", baseURL+"/s0/0-16_0-32_0-32", nil)
+	server.TestBadHTTP(t, "GET", baseURL+"/s2/0-32_0-32_0-32", nil)
+
+	// Export and check files match the served chunks.
+	dir, err := ioutil.TempDir("", "labelmap-precomputed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := GetByUUIDName(uuid, "labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = dvid.NewConfig()
+	config.Set("encoding", "raw")
+	if err := d.ExportPrecomputed(uuid, dir, config); err != nil {
+		t.Fatalf("error exporting precomputed: %v\n", err)
+	}
+	exported, err := ioutil.ReadFile(filepath.Join(dir, "s0", "32-64_0-32_0-32"))
+	if err != nil {
+		t.Fatalf("unable to read exported chunk: %v\n", err)
+	}
+	counts = countChunkLabels(t, exported)
+	if len(counts) != 1 || counts[1] != 32*32*32 {
+		t.Errorf("expected exported chunk to have only merged label 1, got counts %v\n", counts)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "s0", "0-32_0-32_32-64")); !os.IsNotExist(err) {
+		t.Errorf("expected no exported chunk for missing block\n")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "info")); err != nil {
+		t.Errorf("expected exported info file: %v\n", err)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+	This file supports skeletonization of bodies, optionally cached in a keyvalue instance
+	synced to the labelmap.
+*/
+
+package labelmap
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/skeleton"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// maximum number of voxels in the bounding box of a body to be skeletonized.
+const maxSkeletonVoxels = 1 << 28
+
+// SkeletonCacheKey returns the key under which a body's SWC skeleton is cached in a
+// keyvalue instance synced to a labelmap.
+func SkeletonCacheKey(label uint64, scale uint8) string {
+	return fmt.Sprintf("%d_skeleton_s%d.swc", label, scale)
+}
+
+// GetSkeleton returns a TEASAR skeleton for a body computed from the label blocks at the
+// given scale.  Only blocks within the label index are read.  Node positions and radii
+// are in the units of the data's voxel size at scale 0.  A nil skeleton is returned if
+// the label doesn't exist.
+func (d *Data) GetSkeleton(v dvid.VersionID, label uint64, scale uint8) (*skeleton.Skeleton, error) {
+	if scale > d.MaxDownresLevel {
+		return nil, fmt.Errorf("scale %d exceeds max downres level %d of labelmap %q", scale, d.MaxDownresLevel, d.DataName())
+	}
+	idx, err := GetLabelIndex(d, v, label, false)
+	if err != nil || idx == nil {
+		return nil, err
+	}
+	masks, err := d.getBlockMasks(v, idx, label, scale, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(masks) == 0 {
+		return &skeleton.Skeleton{}, nil
+	}
+
+	// Build a dense volume over the bounding box of the body's blocks.
+	blockSize := d.BlockSize().(dvid.Point3d)
+	var minBlock, maxBlock dvid.ChunkPoint3d
+	first := true
+	for bcoord := range masks {
+		for dim := 0; dim < 3; dim++ {
+			if first || bcoord[dim] < minBlock[dim] {
+				minBlock[dim] = bcoord[dim]
+			}
+			if first || bcoord[dim] > maxBlock[dim] {
+				maxBlock[dim] = bcoord[dim]
+			}
+		}
+		first = false
+	}
+	var size, offset dvid.Point3d
+	for dim := 0; dim < 3; dim++ {
+		size[dim] = (maxBlock[dim] - minBlock[dim] + 1) * blockSize[dim]
+		offset[dim] = minBlock[dim] * blockSize[dim]
+	}
+	if size.Prod() > maxSkeletonVoxels {
+		return nil, fmt.Errorf("body %d has bounding box %s at scale %d, too large to skeletonize; use a higher scale", label, size, scale)
+	}
+	vol := &skeleton.Volume{
+		Offset:    offset,
+		Size:      size,
+		Mask:      make([]bool, size.Prod()),
+		VoxelSize: d.scaledVoxelSize(scale),
+	}
+	for bcoord, mask := range masks {
+		var i int
+		for z := int32(0); z < blockSize[2]; z++ {
+			vz := (bcoord[2]-minBlock[2])*blockSize[2] + z
+			for y := int32(0); y < blockSize[1]; y++ {
+				vy := (bcoord[1]-minBlock[1])*blockSize[1] + y
+				vi := (vz*size[1]+vy)*size[0] + (bcoord[0]-minBlock[0])*blockSize[0]
+				copy(vol.Mask[vi:vi+blockSize[0]], mask[i:i+int(blockSize[0])])
+				i += int(blockSize[0])
+			}
+		}
+	}
+	return skeleton.TEASAR(vol, skeleton.DefaultParams)
+}
+
+// GetSkeletonData returns a body's skeleton in SWC format, using and populating a cache
+// in a synced keyvalue instance if available.  A nil slice is returned if the label
+// doesn't exist.
+func (d *Data) GetSkeletonData(v dvid.VersionID, label uint64, scale uint8) ([]byte, error) {
+	idx, err := GetLabelIndex(d, v, label, false)
+	if err != nil || idx == nil {
+		return nil, err
+	}
+	cache, err := d.getLabelCache()
+	if err != nil {
+		return nil, err
+	}
+	key := SkeletonCacheKey(label, scale)
+	if cache != nil {
+		data, found, err := cache.GetData(datastore.NewVersionedCtx(cache, v), key)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return data, nil
+		}
+	}
+
+	skel, err := d.GetSkeleton(v, label, scale)
+	if err != nil {
+		return nil, err
+	}
+	if skel == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	comment := fmt.Sprintf("skeleton of body %d in labelmap %q at scale %d", label, d.DataName(), scale)
+	if err := skel.WriteSWC(&buf, comment); err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		// Only cache if the label wasn't modified while the skeleton was being computed.
+		curIdx, err := GetLabelIndex(d, v, label, false)
+		if err == nil && curIdx != nil && curIdx.LastMutId == idx.LastMutId && len(curIdx.Blocks) == len(idx.Blocks) {
+			if err := cache.PutData(datastore.NewVersionedCtx(cache, v), key, buf.Bytes()); err != nil {
+				dvid.Errorf("unable to cache skeleton for label %d in %q: %v\n", label, cache.DataName(), err)
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *Data) handleSkeleton(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
+	// GET <api URL>/node/<UUID>/<data name>/skeleton/<label>?scale=N
+	if strings.ToLower(r.Method) != "get" {
+		server.BadRequest(w, r, "DVID does not support %s on /skeleton endpoint", r.Method)
+		return
+	}
+	if len(parts) < 5 {
+		server.BadRequest(w, r, "DVID requires label ID to follow 'skeleton' command")
+		return
+	}
+	timedLog := dvid.NewTimeLog()
+
+	label, err := strconv.ParseUint(parts[4], 10, 64)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if label == 0 {
+		server.BadRequest(w, r, "Label 0 is protected background value and cannot be skeletonized")
+		return
+	}
+	scale, err := getScale(r.URL.Query())
+	if err != nil {
+		server.BadRequest(w, r, "bad scale specified: %v", err)
+		return
+	}
+
+	data, err := d.GetSkeletonData(ctx.VersionID(), label, scale)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if data == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-type", "text/plain")
+	if _, err := w.Write(data); err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	timedLog.Infof("HTTP GET skeleton for label %d, scale %d (%s)", label, scale, r.URL)
+}
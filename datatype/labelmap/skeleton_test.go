@@ -0,0 +1,101 @@
+package labelmap
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/skeleton"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func TestSkeletons(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	config.Set("MaxDownresLevel", "1")
+	config.Set("VoxelSize", "1,1,1")
+	server.CreateTestInstance(t, uuid, "labelmap", "labels", config)
+	server.CreateTestInstance(t, uuid, "keyvalue", "skeletons", dvid.Config{})
+	server.CreateTestSync(t, uuid, "skeletons", "labels")
+
+	// Label 1 is an L-shaped tube across blocks and label 2 is a separate bar.
+	vol := newTestVolume(64, 64, 32)
+	vol.addSubvol(dvid.Point3d{4, 10, 10}, dvid.Point3d{56, 6, 6}, 1)
+	vol.addSubvol(dvid.Point3d{54, 16, 10}, dvid.Point3d{6, 44, 6}, 1)
+	vol.addSubvol(dvid.Point3d{4, 40, 20}, dvid.Point3d{30, 6, 6}, 2)
+	vol.put(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("error blocking on labels update: %v\n", err)
+	}
+
+	url := fmt.Sprintf("%snode/%s/labels/skeleton/1", server.WebAPIPath, uuid)
+	swc := server.TestHTTP(t, "GET", url, nil)
+	skel, err := skeleton.ParseSWC(bytes.NewBuffer(swc))
+	if err != nil {
+		t.Fatalf("unable to parse returned SWC: %v\n", err)
+	}
+	var roots int
+	children := make(map[int]int)
+	for _, node := range skel.Nodes {
+		if node.Parent == -1 {
+			roots++
+		} else {
+			children[node.Parent]++
+		}
+		if node.X < 4 || node.X > 60 || node.Y < 10 || node.Y > 60 || node.Z < 10 || node.Z > 16 {
+			t.Errorf("skeleton node outside of body: %v\n", node)
+		}
+	}
+	if roots != 1 || len(skel.Nodes) < 50 {
+		t.Fatalf("expected single tree of at least 50 nodes for body 1, got %d roots, %d nodes\n", roots, len(skel.Nodes))
+	}
+	for _, node := range skel.Nodes {
+		if children[node.ID] > 1 {
+			t.Errorf("expected unbranched skeleton for L-shaped body, node %d has %d children\n", node.ID, children[node.ID])
+		}
+	}
+
+	url = fmt.Sprintf("%snode/%s/labels/skeleton/1?scale=1", server.WebAPIPath, uuid)
+	if swc1 := server.TestHTTP(t, "GET", url, nil); bytes.Equal(swc1, swc) {
+		t.Errorf("expected different skeleton at scale 1\n")
+	}
+	url = fmt.Sprintf("%snode/%s/labels/skeleton/3", server.WebAPIPath, uuid)
+	if resp := server.TestHTTPResponse(t, "GET", url, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for skeleton of nonexistent label, got %d\n", resp.Code)
+	}
+
+	// Skeletons are cached and invalidated on merge.
+	keyURL := fmt.Sprintf("%snode/%s/skeletons/key/%s", server.WebAPIPath, uuid, SkeletonCacheKey(1, 0))
+	if cached := server.TestHTTP(t, "GET", keyURL, nil); !bytes.Equal(cached, swc) {
+		t.Errorf("cached skeleton for body 1 differs from returned skeleton\n")
+	}
+	testMerge := mergeJSON(`[1, 2]`)
+	testMerge.send(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "skeletons"); err != nil {
+		t.Fatalf("error blocking on skeletons update: %v\n", err)
+	}
+	server.TestBadHTTP(t, "GET", keyURL, nil)
+
+	url = fmt.Sprintf("%snode/%s/labels/skeleton/1", server.WebAPIPath, uuid)
+	if skel, err = skeleton.ParseSWC(bytes.NewBuffer(server.TestHTTP(t, "GET", url, nil))); err != nil {
+		t.Fatalf("unable to parse returned SWC: %v\n", err)
+	}
+	roots = 0
+	for _, node := range skel.Nodes {
+		if node.Parent == -1 {
+			roots++
+		}
+	}
+	if roots != 2 {
+		t.Errorf("expected 2 trees for merged body with 2 components, got %d\n", roots)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+	This file supports finding the voxel spans of bodies, e.g., for creating ROIs.
+*/
+
+package labelmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// ProcessLabelSpans calls f with the voxel spans, [z, y, x0, x1] in voxel coordinates, of the
+// given bodies, or supervoxels if isSupervoxel is true, for each block containing them.  Blocks
+// are found through the label indices and processed in ZYX order.  Implements the
+// roi.LabelSpanner interface.
+func (d *Data) ProcessLabelSpans(v dvid.VersionID, lbls []uint64, isSupervoxel bool, f func(dvid.Spans) error) error {
+	supervoxels := make(labels.Set)
+	blockSet := make(map[dvid.IZYXString]struct{})
+	for _, label := range lbls {
+		idx, err := GetLabelIndex(d, v, label, isSupervoxel)
+		if err != nil {
+			return err
+		}
+		if idx == nil {
+			return fmt.Errorf("label %d not found in %q", label, d.DataName())
+		}
+		labelSVs := idx.GetSupervoxels()
+		if isSupervoxel {
+			labelSVs = labels.Set{label: struct{}{}}
+		}
+		for izyx := range idx.GetSupervoxelsBlocks(labelSVs) {
+			blockSet[izyx] = struct{}{}
+		}
+		for supervoxel := range labelSVs {
+			supervoxels[supervoxel] = struct{}{}
+		}
+	}
+	blocks := make(dvid.IZYXSlice, 0, len(blockSet))
+	for izyx := range blockSet {
+		blocks = append(blocks, izyx)
+	}
+	sort.Sort(blocks)
+
+	for _, izyx := range blocks {
+		bcoord, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return err
+		}
+		block, err := d.GetLabelBlock(v, bcoord, 0)
+		if err != nil {
+			return err
+		}
+		lblarray, size := block.MakeLabelVolume()
+		offset := bcoord.MinPoint(size).(dvid.Point3d)
+		var spans dvid.Spans
+		i := 0
+		for z := int32(0); z < size[2]; z++ {
+			for y := int32(0); y < size[1]; y++ {
+				inSpan := false
+				var x0 int32
+				for x := int32(0); x < size[0]; x++ {
+					_, inBody := supervoxels[binary.LittleEndian.Uint64(lblarray[i*8:i*8+8])]
+					if inBody && !inSpan {
+						x0 = x
+						inSpan = true
+					} else if !inBody && inSpan {
+						spans = append(spans, dvid.Span{offset[2] + z, offset[1] + y, offset[0] + x0, offset[0] + x - 1})
+						inSpan = false
+					}
+					i++
+				}
+				if inSpan {
+					spans = append(spans, dvid.Span{offset[2] + z, offset[1] + y, offset[0] + x0, offset[0] + size[0] - 1})
+				}
+			}
+		}
+		if err := f(spans); err != nil {
+			return err
+		}
+	}
+	return nil
+}
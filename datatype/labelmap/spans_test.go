@@ -0,0 +1,123 @@
+package labelmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+func TestROIFromBodies(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelmap", "labels", config)
+	var roiConfig dvid.Config
+	roiConfig.Set("BlockSize", "16,16,16")
+	server.CreateTestInstance(t, uuid, "roi", "bodies", roiConfig)
+
+	vol := newTestVolume(64, 64, 32)
+	vol.addSubvol(dvid.Point3d{0, 0, 0}, dvid.Point3d{32, 32, 32}, 1)
+	vol.addSubvol(dvid.Point3d{32, 0, 0}, dvid.Point3d{32, 32, 32}, 2)
+	vol.addSubvol(dvid.Point3d{0, 32, 0}, dvid.Point3d{64, 32, 32}, 3)
+	vol.addSubvol(dvid.Point3d{10, 40, 0}, dvid.Point3d{5, 5, 32}, 4)
+	vol.put(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("error blocking on labels update: %v\n", err)
+	}
+	postMutation(t, uuid, "merge", bytes.NewBufferString("[3, 4]"))
+
+	boxSpans := func(minPt, maxPt dvid.Point3d, holeMin, holeMax *dvid.Point3d) dvid.Spans {
+		var spans dvid.Spans
+		for z := minPt[2]; z <= maxPt[2]; z++ {
+			for y := minPt[1]; y <= maxPt[1]; y++ {
+				if holeMin != nil && y >= holeMin[1] && y <= holeMax[1] {
+					spans = append(spans, dvid.Span{z, y, minPt[0], holeMin[0] - 1}, dvid.Span{z, y, holeMax[0] + 1, maxPt[0]})
+				} else {
+					spans = append(spans, dvid.Span{z, y, minPt[0], maxPt[0]})
+				}
+			}
+		}
+		return spans
+	}
+	createRequest := fmt.Sprintf("%snode/%s/bodies/create", server.WebAPIPath, uuid)
+	roiRequest := fmt.Sprintf("%snode/%s/bodies/roi?voxels=true", server.WebAPIPath, uuid)
+	tests := []struct {
+		request  string
+		expected dvid.Spans
+	}{
+		{
+			`{"source": "labels", "bodies": [1, 2]}`,
+			boxSpans(dvid.Point3d{0, 0, 0}, dvid.Point3d{63, 31, 31}, nil, nil),
+		},
+		{
+			`{"source": "labels", "bodies": [3]}`,
+			boxSpans(dvid.Point3d{0, 32, 0}, dvid.Point3d{63, 63, 31}, nil, nil),
+		},
+		{
+			`{"source": "labels", "bodies": [3], "supervoxels": true}`,
+			boxSpans(dvid.Point3d{0, 32, 0}, dvid.Point3d{63, 63, 31}, &dvid.Point3d{10, 40, 0}, &dvid.Point3d{14, 44, 31}),
+		},
+	}
+	for _, tc := range tests {
+		server.TestHTTP(t, "POST", createRequest, bytes.NewBufferString(tc.request))
+		if err := datastore.BlockOnUpdating(uuid, "bodies"); err != nil {
+			t.Fatalf("Error blocking on ROI creation: %v\n", err)
+		}
+		var spans dvid.Spans
+		if err := json.Unmarshal(server.TestHTTP(t, "GET", roiRequest, nil), &spans); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(spans, tc.expected) {
+			t.Errorf("Bad ROI for %s: got %d spans, expected %d\n", tc.request, len(spans), len(tc.expected))
+		}
+	}
+
+	roiRequest = fmt.Sprintf("%snode/%s/bodies/roi", server.WebAPIPath, uuid)
+	var blockSpans dvid.Spans
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", roiRequest, nil), &blockSpans); err != nil {
+		t.Fatal(err)
+	}
+	if len(blockSpans) != 4 || blockSpans[0] != (dvid.Span{0, 2, 0, 3}) {
+		t.Errorf("Bad block spans for ROI of supervoxel 3: %v\n", blockSpans)
+	}
+	server.TestBadHTTP(t, "POST", createRequest, bytes.NewBufferString(`{"source": "labels", "min": 0, "max": 1}`))
+
+	// A failed creation is reported by GET until the ROI is replaced.
+	server.TestHTTP(t, "POST", createRequest, bytes.NewBufferString(`{"source": "labels", "bodies": [999]}`))
+	if err := datastore.BlockOnUpdating(uuid, "bodies"); err != nil {
+		t.Fatalf("Error blocking on ROI creation: %v\n", err)
+	}
+	server.TestBadHTTP(t, "GET", roiRequest, nil)
+	server.TestHTTP(t, "POST", createRequest, bytes.NewBufferString(`{"source": "labels", "bodies": [3]}`))
+	if err := datastore.BlockOnUpdating(uuid, "bodies"); err != nil {
+		t.Fatalf("Error blocking on ROI creation: %v\n", err)
+	}
+	server.TestHTTP(t, "GET", roiRequest, nil)
+
+	// Creation is rejected while the ROI is updating.
+	roiData, err := datastore.GetDataByUUIDName(uuid, "bodies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updater, ok := roiData.(interface {
+		StartUpdate()
+		StopUpdate()
+	})
+	if !ok {
+		t.Fatalf("ROI data %q can't be marked as updating\n", roiData.DataName())
+	}
+	updater.StartUpdate()
+	server.TestBadHTTP(t, "POST", createRequest, bytes.NewBufferString(`{"source": "labels", "bodies": [3]}`))
+	updater.StopUpdate()
+}
@@ -35,6 +35,26 @@ type MutatedBlock struct {
 	Data   *labels.Block
 }
 
+// Supervoxels returns the supervoxels in the ingested block.
+func (b IngestedBlock) Supervoxels() []uint64 {
+	if b.Data == nil {
+		return nil
+	}
+	return b.Data.Labels
+}
+
+// Supervoxels returns the supervoxels in the block before or after the mutation.
+func (b MutatedBlock) Supervoxels() []uint64 {
+	var svs []uint64
+	if b.Prev != nil {
+		svs = append(svs, b.Prev.Labels...)
+	}
+	if b.Data != nil {
+		svs = append(svs, b.Data.Labels...)
+	}
+	return svs
+}
+
 type procMsg struct {
 	v  dvid.VersionID
 	op interface{}
@@ -0,0 +1,550 @@
+/*
+	This file supports undoing a logged labelmap mutation by applying its inverse.
+*/
+
+package labelmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/downres"
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/datatype/common/proto"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// UndoResult describes the mutations applied to undo a mutation.
+type UndoResult struct {
+	MutationID      uint64   // the mutation that was undone
+	Action          string   // "merge", "cleave", "split" or "split-supervoxel"
+	UndoMutationIDs []uint64 // mutations applied to undo the mutation
+}
+
+// UndoMutation reverts a merge, cleave, split or supervoxel split logged with the given
+// mutation ID in the given version by applying its inverse as new mutations.  An error
+// is returned if any later mutation in the version touched the labels or supervoxels
+// modified by the mutation.  Other mutations of the data wait until the undo is applied
+// so none can start depending on the mutation after the check.
+func (d *Data) UndoMutation(v dvid.VersionID, mutID uint64, info dvid.ModInfo) (result UndoResult, err error) {
+	var locked bool
+	if locked, err = datastore.LockedVersion(v); err != nil {
+		return
+	}
+	if locked {
+		err = fmt.Errorf("can't undo mutation %d in locked version id %d", mutID, v)
+		return
+	}
+	d.mutateMu.Lock()
+	defer d.mutateMu.Unlock()
+
+	var msgs []storage.LogMessage
+	if msgs, err = d.getLogMessages(v); err != nil {
+		return
+	}
+
+	// Find the logged op and make sure no later op depends on it.
+	pos := -1
+	var touched loggedOp
+	for i, msg := range msgs {
+		if msg.EntryType == proto.MappingOpType {
+			continue
+		}
+		op, ok, opErr := getLoggedOp(msg)
+		if opErr != nil {
+			err = fmt.Errorf("unable to read mutation log entry: %v", opErr)
+			return
+		}
+		if ok && op.mutID == mutID {
+			pos = i
+			touched = op
+		}
+	}
+	if pos < 0 {
+		err = fmt.Errorf("no merge, cleave, split or supervoxel split with mutation id %d in version id %d", mutID, v)
+		return
+	}
+	labelSet := labels.NewSet(touched.labels...)
+	supervoxelSet := labels.NewSet(touched.supervoxels...)
+	for _, msg := range msgs[pos+1:] {
+		op, ok, opErr := getLoggedOp(msg)
+		if opErr != nil || !ok || op.mutID == mutID {
+			continue
+		}
+		for _, label := range op.labels {
+			if _, found := labelSet[label]; found {
+				err = fmt.Errorf("cannot undo mutation %d since later mutation %d modified label %d", mutID, op.mutID, label)
+				return
+			}
+		}
+		for _, supervoxel := range op.supervoxels {
+			if _, found := supervoxelSet[supervoxel]; found {
+				err = fmt.Errorf("cannot undo mutation %d since later mutation %d modified supervoxel %d", mutID, op.mutID, supervoxel)
+				return
+			}
+			if _, found := labelSet[supervoxel]; found {
+				err = fmt.Errorf("cannot undo mutation %d since later mutation %d modified label %d", mutID, op.mutID, supervoxel)
+				return
+			}
+		}
+	}
+
+	result.MutationID = mutID
+	msg := msgs[pos]
+	switch msg.EntryType {
+	case proto.MergeOpType:
+		var op proto.MergeOp
+		if err = op.Unmarshal(msg.Data); err != nil {
+			return
+		}
+		result.Action = "merge"
+		result.UndoMutationIDs, err = d.undoMerge(v, op, msgs[:pos], info)
+	case proto.CleaveOpType:
+		var op proto.CleaveOp
+		if err = op.Unmarshal(msg.Data); err != nil {
+			return
+		}
+		result.Action = "cleave"
+		mergeOp := labels.MergeOp{Target: op.Target, Merged: labels.NewSet(op.Cleavedlabel)}
+		var undoID uint64
+		if undoID, err = d.mergeLabels(v, mergeOp, info); err == nil {
+			result.UndoMutationIDs = []uint64{undoID}
+		}
+	case proto.SplitOpType:
+		var op proto.SplitOp
+		if err = op.Unmarshal(msg.Data); err != nil {
+			return
+		}
+		result.Action = "split"
+		var undoID uint64
+		if undoID, err = d.undoSplit(v, op, info); err == nil {
+			result.UndoMutationIDs = []uint64{undoID}
+		}
+	case proto.SupervoxelSplitType:
+		var op proto.SupervoxelSplitOp
+		if err = op.Unmarshal(msg.Data); err != nil {
+			return
+		}
+		result.Action = "split-supervoxel"
+		var undoID uint64
+		if undoID, err = d.undoSupervoxelSplit(v, op, info); err == nil {
+			result.UndoMutationIDs = []uint64{undoID}
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("unable to undo %s mutation %d: %v", result.Action, mutID, err)
+		return
+	}
+
+	versionuuid, _ := datastore.UUIDFromVersion(v)
+	msginfo := map[string]interface{}{
+		"Action":           "undo",
+		"UndoneMutationID": mutID,
+		"UndoneAction":     result.Action,
+		"MutationIDs":      result.UndoMutationIDs,
+		"UUID":             string(versionuuid),
+		"Timestamp":        time.Now().String(),
+	}
+	if info.User != "" {
+		msginfo["User"] = info.User
+	}
+	if info.App != "" {
+		msginfo["App"] = info.App
+	}
+	jsonmsg, _ := json.Marshal(msginfo)
+	if err := d.ProduceKafkaMsg(jsonmsg); err != nil {
+		dvid.Errorf("error on sending undo op to kafka: %v\n", err)
+	}
+	return
+}
+
+// returns the label a supervoxel was mapped to in the ancestors of the given version,
+// ignoring any mapping made in the version itself.
+func (svm *SVMap) ancestorMappedLabel(v dvid.VersionID, supervoxel uint64) (uint64, error) {
+	ancestry, err := svm.getAncestry(v)
+	if err != nil {
+		return 0, err
+	}
+	svm.RLock()
+	defer svm.RUnlock()
+	if vid, found := svm.versions[v]; found && len(ancestry) != 0 && ancestry[0] == vid {
+		ancestry = ancestry[1:]
+	}
+	if label, found := svm.mapLabel(supervoxel, ancestry); found {
+		return label, nil
+	}
+	return supervoxel, nil
+}
+
+// undoes a merge by cleaving the supervoxels of each merged label back into that label.
+// The given log messages precede the merge and are used to determine the label of each
+// merged supervoxel just before the merge.
+func (d *Data) undoMerge(v dvid.VersionID, op proto.MergeOp, prior []storage.LogMessage, info dvid.ModInfo) (undoIDs []uint64, err error) {
+	// The merge logged a mapping of all merged supervoxels to the target.
+	var merged []uint64
+	for _, msg := range prior {
+		if msg.EntryType != proto.MappingOpType {
+			continue
+		}
+		var mapOp proto.MappingOp
+		if err = mapOp.Unmarshal(msg.Data); err != nil {
+			return
+		}
+		if mapOp.Mutid == op.Mutid && mapOp.Mapped == op.Target {
+			merged = append(merged, mapOp.Original...)
+		}
+	}
+
+	// Get the label of each merged supervoxel before the merge.
+	before := make(map[uint64]uint64, len(merged))
+	for _, msg := range prior {
+		if msg.EntryType != proto.MappingOpType {
+			continue
+		}
+		var mapOp proto.MappingOp
+		if err = mapOp.Unmarshal(msg.Data); err != nil {
+			return
+		}
+		if mapOp.Mutid == op.Mutid {
+			continue
+		}
+		for _, supervoxel := range mapOp.Original {
+			before[supervoxel] = mapOp.Mapped
+		}
+	}
+	var svmap *SVMap
+	if svmap, err = getMapping(d, v); err != nil {
+		return
+	}
+	mergedSet := labels.NewSet(op.Merged...)
+	cleaves := make(map[uint64][]uint64, len(op.Merged))
+	for _, supervoxel := range merged {
+		label, found := before[supervoxel]
+		if !found {
+			if label, err = svmap.ancestorMappedLabel(v, supervoxel); err != nil {
+				return
+			}
+		}
+		if _, found := mergedSet[label]; !found {
+			err = fmt.Errorf("merged supervoxel %d was in label %d, not one of the merged labels %v", supervoxel, label, op.Merged)
+			return
+		}
+		cleaves[label] = append(cleaves[label], supervoxel)
+	}
+
+	cleaveLabels := make([]uint64, 0, len(cleaves))
+	for label := range cleaves {
+		var idx *labels.Index
+		if idx, err = GetLabelIndex(d, v, label, false); err != nil {
+			return
+		}
+		if idx != nil {
+			err = fmt.Errorf("merged label %d has been reused since the merge", label)
+			return
+		}
+		cleaveLabels = append(cleaveLabels, label)
+	}
+	sort.Slice(cleaveLabels, func(i, j int) bool { return cleaveLabels[i] < cleaveLabels[j] })
+	for _, label := range cleaveLabels {
+		supervoxels := cleaves[label]
+		sort.Slice(supervoxels, func(i, j int) bool { return supervoxels[i] < supervoxels[j] })
+		var mutID uint64
+		if mutID, err = d.cleaveLabel(v, op.Target, label, supervoxels, info); err != nil {
+			return
+		}
+		undoIDs = append(undoIDs, mutID)
+	}
+	return
+}
+
+// relabels the scale 0 blocks and the label index so that each supervoxel in the given
+// mapping is replaced by its original supervoxel.
+func (d *Data) restoreSupervoxels(ctx *datastore.VersionedCtx, downresMut *downres.Mutation, idx *labels.Index, restore map[uint64]uint64) error {
+	var scale uint8
+	for zyx, svc := range idx.Blocks {
+		var replaced []uint64
+		for supervoxel := range svc.Counts {
+			if _, found := restore[supervoxel]; found {
+				replaced = append(replaced, supervoxel)
+			}
+		}
+		if len(replaced) == 0 {
+			continue
+		}
+		for _, supervoxel := range replaced {
+			svc.Counts[restore[supervoxel]] += svc.Counts[supervoxel]
+			delete(svc.Counts, supervoxel)
+		}
+
+		izyx := labels.BlockIndexToIZYXString(zyx)
+		pb, err := d.getLabelBlock(ctx, scale, izyx)
+		if err != nil {
+			return err
+		}
+		if pb == nil {
+			return fmt.Errorf("block %s in label %d index doesn't exist", izyx, idx.Label)
+		}
+		block, _, err := pb.ReplaceLabels(restore)
+		if err != nil {
+			return fmt.Errorf("unable to relabel block %s: %v", izyx, err)
+		}
+		// remake the block so restored supervoxels aren't duplicated in its label list.
+		lblarray, size := block.MakeLabelVolume()
+		if block, err = labels.MakeBlock(lblarray, size); err != nil {
+			return fmt.Errorf("unable to remake block %s: %v", izyx, err)
+		}
+		restored := labels.PositionedBlock{Block: *block, BCoord: izyx}
+		if err := d.putLabelBlock(ctx, scale, &restored); err != nil {
+			return fmt.Errorf("unable to put block %s, data %q: %v", izyx, d.DataName(), err)
+		}
+		if err := downresMut.BlockMutated(izyx, block); err != nil {
+			return fmt.Errorf("data %q publishing downres: %v", d.DataName(), err)
+		}
+	}
+	return nil
+}
+
+// sets the mappings of supervoxels restored by undoing a split and logs them.
+func addRestoreToMapping(d dvid.Data, v dvid.VersionID, mutID uint64, restored map[uint64]uint64, removed labels.Set) error {
+	m, err := getMapping(d, v)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	vid, err := m.createShortVersion(v)
+	if err != nil {
+		m.Unlock()
+		return err
+	}
+	for supervoxel := range removed {
+		m.setMapping(vid, supervoxel, 0)
+	}
+	mappedOps := make(map[uint64]labels.Set)
+	for supervoxel, label := range restored {
+		m.setMapping(vid, supervoxel, label)
+		if _, found := mappedOps[label]; !found {
+			mappedOps[label] = make(labels.Set)
+		}
+		mappedOps[label][supervoxel] = struct{}{}
+	}
+	m.Unlock()
+
+	mapOp := labels.MappingOp{
+		MutID:    mutID,
+		Mapped:   0,
+		Original: removed,
+	}
+	if err := labels.LogMapping(d, v, mapOp); err != nil {
+		return fmt.Errorf("unable to log the mapping of removed supervoxels %s: %v", removed, err)
+	}
+	for label, supervoxels := range mappedOps {
+		mapOp = labels.MappingOp{
+			MutID:    mutID,
+			Mapped:   label,
+			Original: supervoxels,
+		}
+		if err := labels.LogMapping(d, v, mapOp); err != nil {
+			return fmt.Errorf("unable to log the mapping of restored supervoxels %s: %v", supervoxels, err)
+		}
+	}
+	return nil
+}
+
+// undoes a split by restoring the split supervoxels and merging the split label
+// back into the target label.
+func (d *Data) undoSplit(v dvid.VersionID, op proto.SplitOp, info dvid.ModInfo) (mutID uint64, err error) {
+	timedLog := dvid.NewTimeLog()
+
+	d.voxelMu.Lock()
+	defer d.voxelMu.Unlock()
+
+	d.StartUpdate()
+	defer d.StopUpdate()
+
+	// Lock the index shards of both labels, lowest shard first to avoid deadlock.
+	shards := []uint64{op.Target % numIndexShards, op.Newlabel % numIndexShards}
+	if shards[0] > shards[1] {
+		shards[0], shards[1] = shards[1], shards[0]
+	} else if shards[0] == shards[1] {
+		shards = shards[:1]
+	}
+	for _, shard := range shards {
+		indexMu[shard].Lock()
+		defer indexMu[shard].Unlock()
+	}
+
+	var targetIdx, splitIdx *labels.Index
+	if targetIdx, err = getCachedLabelIndex(d, v, op.Target); err != nil {
+		return
+	}
+	if targetIdx == nil {
+		err = fmt.Errorf("split target label %d no longer exists", op.Target)
+		return
+	}
+	if splitIdx, err = getCachedLabelIndex(d, v, op.Newlabel); err != nil {
+		return
+	}
+	if splitIdx == nil {
+		err = fmt.Errorf("split label %d no longer exists", op.Newlabel)
+		return
+	}
+
+	mutID = d.NewMutationID()
+	mergeOp := labels.MergeOp{MutID: mutID, Target: op.Target, Merged: labels.NewSet(op.Newlabel)}
+	delta := labels.DeltaMerge{
+		MergeOp:      mergeOp,
+		TargetVoxels: targetIdx.NumVoxels(),
+		MergedVoxels: splitIdx.NumVoxels(),
+	}
+
+	evt := datastore.SyncEvent{Data: d.DataUUID(), Event: labels.MergeStartEvent}
+	msg := datastore.SyncMessage{Event: labels.MergeStartEvent, Version: v, Delta: labels.DeltaMergeStart{MergeOp: mergeOp}}
+	if err = datastore.NotifySubscribers(evt, msg); err != nil {
+		return
+	}
+
+	if err = targetIdx.Add(splitIdx); err != nil {
+		return
+	}
+	restore := make(map[uint64]uint64, 2*len(op.Svsplits))
+	restored := make(map[uint64]uint64, len(op.Svsplits))
+	removed := make(labels.Set, 2*len(op.Svsplits))
+	for supervoxel, svsplit := range op.Svsplits {
+		restore[svsplit.Splitlabel] = supervoxel
+		restore[svsplit.Remainlabel] = supervoxel
+		restored[supervoxel] = op.Target
+		removed[svsplit.Splitlabel] = struct{}{}
+		removed[svsplit.Remainlabel] = struct{}{}
+	}
+	ctx := datastore.NewVersionedCtx(d, v)
+	downresMut := downres.NewMutation(d, v, mutID)
+	if err = d.restoreSupervoxels(ctx, downresMut, targetIdx, restore); err != nil {
+		return
+	}
+	targetIdx.Label = op.Target
+	targetIdx.LastMutId = mutID
+	targetIdx.LastModUser = info.User
+	targetIdx.LastModTime = info.Time
+	targetIdx.LastModApp = info.App
+	if err = putCachedLabelIndex(d, v, targetIdx); err != nil {
+		return
+	}
+	if err = deleteCachedLabelIndex(d, v, op.Newlabel); err != nil {
+		return
+	}
+	if err = addRestoreToMapping(d, v, mutID, restored, removed); err != nil {
+		return
+	}
+	if err = labels.LogMerge(d, v, mergeOp); err != nil {
+		return
+	}
+	if err = storeMutationTime(d, v, mutID); err != nil {
+		return
+	}
+	if err = downresMut.Execute(); err != nil {
+		return
+	}
+
+	// Downstream syncs see the undo as a merge of the split label into the target.
+	delta.Blocks = targetIdx.GetBlockIndices()
+	evt = datastore.SyncEvent{Data: d.DataUUID(), Event: labels.MergeBlockEvent}
+	msg = datastore.SyncMessage{Event: labels.MergeBlockEvent, Version: v, Delta: delta}
+	if err = datastore.NotifySubscribers(evt, msg); err != nil {
+		err = fmt.Errorf("can't notify subscribers for event %v: %v", evt, err)
+		return
+	}
+	evt = datastore.SyncEvent{Data: d.DataUUID(), Event: labels.MergeEndEvent}
+	msg = datastore.SyncMessage{Event: labels.MergeEndEvent, Version: v, Delta: labels.DeltaMergeEnd{MergeOp: mergeOp}}
+	if err := datastore.NotifySubscribers(evt, msg); err != nil {
+		dvid.Criticalf("can't notify subscribers for event %v: %v\n", evt, err)
+	}
+
+	timedLog.Infof("Undid split %d of label %d -> %d, data %q, restoring %d supervoxels", op.Mutid, op.Target, op.Newlabel, d.DataName(), len(op.Svsplits))
+	return
+}
+
+// undoes a supervoxel split by relabeling the split and remain supervoxels with the
+// original supervoxel.  Since body labels don't change, synced data only receives a
+// supervoxel split event for the supervoxels involved, as for the original split.
+func (d *Data) undoSupervoxelSplit(v dvid.VersionID, op proto.SupervoxelSplitOp, info dvid.ModInfo) (mutID uint64, err error) {
+	timedLog := dvid.NewTimeLog()
+
+	var svmap *SVMap
+	if svmap, err = getMapping(d, v); err != nil {
+		return
+	}
+	label := op.Splitlabel
+	if mapped, found := svmap.MappedLabel(v, op.Splitlabel); found {
+		label = mapped
+	}
+	if remainLabel, found := svmap.MappedLabel(v, op.Remainlabel); found && remainLabel != label {
+		err = fmt.Errorf("split supervoxel %d and remain supervoxel %d are in different labels %d and %d", op.Splitlabel, op.Remainlabel, label, remainLabel)
+		return
+	}
+
+	d.voxelMu.Lock()
+	defer d.voxelMu.Unlock()
+
+	d.StartUpdate()
+	defer d.StopUpdate()
+
+	shard := label % numIndexShards
+	indexMu[shard].Lock()
+	defer indexMu[shard].Unlock()
+
+	var idx *labels.Index
+	if idx, err = getCachedLabelIndex(d, v, label); err != nil {
+		return
+	}
+	if idx == nil {
+		err = fmt.Errorf("label %d of split supervoxels no longer exists", label)
+		return
+	}
+
+	mutID = d.NewMutationID()
+	restore := map[uint64]uint64{
+		op.Splitlabel:  op.Supervoxel,
+		op.Remainlabel: op.Supervoxel,
+	}
+	ctx := datastore.NewVersionedCtx(d, v)
+	downresMut := downres.NewMutation(d, v, mutID)
+	if err = d.restoreSupervoxels(ctx, downresMut, idx, restore); err != nil {
+		return
+	}
+	idx.LastMutId = mutID
+	idx.LastModUser = info.User
+	idx.LastModTime = info.Time
+	idx.LastModApp = info.App
+	if err = putCachedLabelIndex(d, v, idx); err != nil {
+		return
+	}
+	removed := labels.NewSet(op.Splitlabel, op.Remainlabel)
+	if err = addRestoreToMapping(d, v, mutID, map[uint64]uint64{op.Supervoxel: label}, removed); err != nil {
+		return
+	}
+	if err = storeMutationTime(d, v, mutID); err != nil {
+		return
+	}
+	if err = downresMut.Execute(); err != nil {
+		return
+	}
+
+	svop := labels.SplitSupervoxelOp{
+		MutID:            mutID,
+		Supervoxel:       op.Supervoxel,
+		SplitSupervoxel:  op.Splitlabel,
+		RemainSupervoxel: op.Remainlabel,
+	}
+	evt := datastore.SyncEvent{Data: d.DataUUID(), Event: labels.SupervoxelSplitEvent}
+	msg := datastore.SyncMessage{Event: labels.SupervoxelSplitEvent, Version: v, Delta: svop}
+	if err := datastore.NotifySubscribers(evt, msg); err != nil {
+		dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
+	}
+
+	timedLog.Infof("Undid supervoxel split %d of supervoxel %d in label %d, data %q", op.Mutid, op.Supervoxel, label, d.DataName())
+	return
+}
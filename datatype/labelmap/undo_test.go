@@ -0,0 +1,135 @@
+package labelmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// returns a binary sparse volume for the given subvolume.
+func subvolSparsevol(t *testing.T, origin, size dvid.Point3d) *bytes.Buffer {
+	var rles dvid.RLEs
+	for z := origin[2]; z < origin[2]+size[2]; z++ {
+		for y := origin[1]; y < origin[1]+size[1]; y++ {
+			rles = append(rles, dvid.NewRLE(dvid.Point3d{origin[0], y, z}, size[0]))
+		}
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(dvid.EncodingBinary)
+	binary.Write(buf, binary.LittleEndian, uint8(3))
+	binary.Write(buf, binary.LittleEndian, byte(0))
+	buf.WriteByte(byte(0))
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+	binary.Write(buf, binary.LittleEndian, uint32(len(rles)))
+	rleBytes, err := rles.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unable to serialize RLEs: %v\n", err)
+	}
+	buf.Write(rleBytes)
+	return buf
+}
+
+func postMutation(t *testing.T, uuid dvid.UUID, endpoint string, payload *bytes.Buffer) uint64 {
+	url := fmt.Sprintf("%snode/%s/labels/%s", server.WebAPIPath, uuid, endpoint)
+	var resp struct {
+		MutationID uint64
+	}
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", url, payload), &resp); err != nil {
+		t.Fatalf("bad response to %s: %v\n", endpoint, err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("error blocking on labels update: %v\n", err)
+	}
+	return resp.MutationID
+}
+
+func undoMutation(t *testing.T, uuid dvid.UUID, mutID uint64, action string) UndoResult {
+	url := fmt.Sprintf("%snode/%s/labels/undo?mutid=%d", server.WebAPIPath, uuid, mutID)
+	var result UndoResult
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", url, nil), &result); err != nil {
+		t.Fatalf("bad response to undo of mutation %d: %v\n", mutID, err)
+	}
+	if result.MutationID != mutID || result.Action != action || len(result.UndoMutationIDs) == 0 {
+		t.Errorf("bad undo response for %s mutation %d: %v\n", action, mutID, result)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("error blocking on labels update: %v\n", err)
+	}
+	return result
+}
+
+func checkVolume(t *testing.T, desc string, uuid dvid.UUID, expected *testVolume, supervoxels bool) {
+	got := newTestVolume(expected.size[0], expected.size[1], expected.size[2])
+	got.get(t, uuid, "labels", supervoxels)
+	if err := expected.equals(got); err != nil {
+		t.Errorf("%s: %v\n", desc, err)
+	}
+}
+
+func TestUndoMutations(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelmap", "labels", config)
+
+	orig := newTestVolume(64, 64, 32)
+	orig.addSubvol(dvid.Point3d{0, 0, 0}, dvid.Point3d{32, 32, 32}, 1)
+	orig.addSubvol(dvid.Point3d{32, 0, 0}, dvid.Point3d{32, 32, 32}, 2)
+	orig.addSubvol(dvid.Point3d{0, 32, 0}, dvid.Point3d{64, 32, 32}, 3)
+	orig.put(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("error blocking on labels update: %v\n", err)
+	}
+
+	// Undo a merge.
+	mergeID := postMutation(t, uuid, "merge", bytes.NewBufferString("[1, 2]"))
+	undoMutation(t, uuid, mergeID, "merge")
+	checkVolume(t, "after merge undo", uuid, orig, false)
+	if idx := getIndex(t, uuid, "labels", 2); idx.NumVoxels() != 32*32*32 {
+		t.Errorf("expected restored label 2 index to have %d voxels, got %d\n", 32*32*32, idx.NumVoxels())
+	}
+
+	// Undo a cleave, then make sure the earlier merge can't be undone.
+	mergeID = postMutation(t, uuid, "merge", bytes.NewBufferString("[1, 2]"))
+	cleaveID := postMutation(t, uuid, "cleave/1", bytes.NewBufferString("[2]"))
+	undoMutation(t, uuid, cleaveID, "cleave")
+	merged := newTestVolume(64, 64, 32)
+	copy(merged.data, orig.data)
+	merged.addSubvol(dvid.Point3d{32, 0, 0}, dvid.Point3d{32, 32, 32}, 1)
+	checkVolume(t, "after cleave undo", uuid, merged, false)
+	url := fmt.Sprintf("%snode/%s/labels/undo?mutid=%d", server.WebAPIPath, uuid, mergeID)
+	server.TestBadHTTP(t, "POST", url, nil)
+
+	// Undo a split.
+	splitID := postMutation(t, uuid, "split/3", subvolSparsevol(t, dvid.Point3d{0, 32, 0}, dvid.Point3d{16, 32, 32}))
+	undoMutation(t, uuid, splitID, "split")
+	checkVolume(t, "after split undo", uuid, merged, false)
+	checkVolume(t, "supervoxels after split undo", uuid, orig, true)
+	if idx := getIndex(t, uuid, "labels", 3); idx.NumVoxels() != 64*32*32 || len(idx.GetSupervoxels()) != 1 {
+		t.Errorf("bad label 3 index after split undo: %d voxels, supervoxels %v\n", idx.NumVoxels(), idx.GetSupervoxels())
+	}
+
+	// Undo a supervoxel split.
+	svSplitID := postMutation(t, uuid, "split-supervoxel/3", subvolSparsevol(t, dvid.Point3d{0, 32, 0}, dvid.Point3d{40, 32, 32}))
+	undoMutation(t, uuid, svSplitID, "split-supervoxel")
+	checkVolume(t, "supervoxels after supervoxel split undo", uuid, orig, true)
+
+	// The split was followed by a supervoxel split of the restored supervoxel.
+	url = fmt.Sprintf("%snode/%s/labels/undo?mutid=%d", server.WebAPIPath, uuid, splitID)
+	server.TestBadHTTP(t, "POST", url, nil)
+
+	// Unknown mutations can't be undone.
+	url = fmt.Sprintf("%snode/%s/labels/undo?mutid=%d", server.WebAPIPath, uuid, svSplitID+100)
+	server.TestBadHTTP(t, "POST", url, nil)
+}
@@ -86,6 +86,9 @@ func (d *Data) PutLabels(v dvid.VersionID, subvol *dvid.Subvolume, data []byte,
 
 	// Iterate through index space for this data.
 	mutID := d.NewMutationID()
+	if err := storeBlockWrite(d, v, mutID); err != nil {
+		return err
+	}
 	downresMut := downres.NewMutation(d, v, mutID)
 
 	wg := new(sync.WaitGroup)
@@ -335,6 +338,10 @@ func (d *Data) writeBlocks(v dvid.VersionID, b storage.TKeyValues, wg1, wg2 *syn
 		}()
 
 		mutID := d.NewMutationID()
+		if err := storeBlockWrite(d, v, mutID); err != nil {
+			dvid.Errorf("Unable to record block write in %q: %v\n", d.DataName(), err)
+			return
+		}
 		batch := batcher.NewBatch(ctx)
 		for i, block := range b {
 			preCompress += len(block.V)
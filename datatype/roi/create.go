@@ -0,0 +1,152 @@
+/*
+	This file supports asynchronous creation of an ROI from the bodies of label data or
+	by thresholding image data.
+*/
+
+package roi
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// LabelSpanner is implemented by label data, e.g., labelmap, that can find the voxels of
+// labels using their label indices.
+type LabelSpanner interface {
+	// ProcessLabelSpans calls f with the voxel spans, [z, y, x0, x1] in voxel coordinates,
+	// of the given labels, or supervoxels if isSupervoxel is true, one block at a time.
+	ProcessLabelSpans(v dvid.VersionID, labels []uint64, isSupervoxel bool, f func(dvid.Spans) error) error
+}
+
+// ThresholdSpanner is implemented by image data, e.g., imageblk, that can find the voxels
+// with values in a range.
+type ThresholdSpanner interface {
+	// CheckThreshold returns an error if the data's values can't be thresholded.
+	CheckThreshold() error
+
+	// ProcessThresholdSpans calls f with the voxel spans, [z, y, x0, x1] in voxel coordinates,
+	// of voxels with values in [minValue, maxValue] within the given extents, one block at a
+	// time.  If the extents are nil, all stored blocks are processed.
+	ProcessThresholdSpans(v dvid.VersionID, ext *dvid.Extents3d, minValue, maxValue float64, f func(dvid.Spans) error) error
+}
+
+// createRequest is the JSON sent to POST /create.
+type createRequest struct {
+	Source      dvid.InstanceName `json:"source"`
+	Bodies      []uint64          `json:"bodies"`
+	Supervoxels bool              `json:"supervoxels"`
+	Min         *float64          `json:"min"`
+	Max         *float64          `json:"max"`
+	Offset      *dvid.Point3d     `json:"offset"`
+	Size        *dvid.Point3d     `json:"size"`
+}
+
+// CreateFromJSON starts asynchronous creation of the ROI from the bodies of a label data
+// instance or a threshold of an image data instance as described by the JSON.  The ROI
+// is marked as updating until its creation is complete, and a creation is rejected while
+// the ROI is updating.  The error of a failed creation is kept until the ROI is replaced.
+func (d *Data) CreateFromJSON(v dvid.VersionID, jsonBytes []byte) error {
+	var req createRequest
+	if err := json.Unmarshal(jsonBytes, &req); err != nil {
+		return fmt.Errorf("Error trying to parse POSTed JSON: %v", err)
+	}
+	source, err := datastore.GetDataByVersionName(v, req.Source)
+	if err != nil {
+		return err
+	}
+	var process func(f func(dvid.Spans) error) error
+	switch {
+	case len(req.Bodies) != 0:
+		spanner, ok := source.(LabelSpanner)
+		if !ok {
+			return fmt.Errorf("data %q of type %s can't supply bodies for an ROI", req.Source, source.TypeName())
+		}
+		process = func(f func(dvid.Spans) error) error {
+			return spanner.ProcessLabelSpans(v, req.Bodies, req.Supervoxels, f)
+		}
+	case req.Min != nil || req.Max != nil:
+		spanner, ok := source.(ThresholdSpanner)
+		if !ok {
+			return fmt.Errorf("data %q of type %s can't be thresholded for an ROI", req.Source, source.TypeName())
+		}
+		if err := spanner.CheckThreshold(); err != nil {
+			return err
+		}
+		if req.Min == nil || req.Max == nil {
+			return fmt.Errorf("both min and max must be given for a threshold")
+		}
+		var ext *dvid.Extents3d
+		if req.Offset != nil || req.Size != nil {
+			if req.Offset == nil || req.Size == nil {
+				return fmt.Errorf("both offset and size must be given for a threshold region")
+			}
+			if (*req.Size)[0] <= 0 || (*req.Size)[1] <= 0 || (*req.Size)[2] <= 0 {
+				return fmt.Errorf("bad threshold region size %s", *req.Size)
+			}
+			ext = &dvid.Extents3d{MinPoint: *req.Offset, MaxPoint: req.Offset.Add3d(*req.Size).Add3d(dvid.Point3d{-1, -1, -1})}
+		}
+		process = func(f func(dvid.Spans) error) error {
+			return spanner.ProcessThresholdSpans(v, ext, *req.Min, *req.Max, f)
+		}
+	default:
+		return fmt.Errorf("ROI creation requires either bodies or a min and max threshold")
+	}
+
+	d.createMu.Lock()
+	if d.Updating() {
+		d.createMu.Unlock()
+		return fmt.Errorf("ROI %q is already being updated", d.DataName())
+	}
+	d.StartUpdate()
+	d.createErr = nil
+	d.createMu.Unlock()
+
+	go func() {
+		defer d.StopUpdate()
+		if err := d.createROI(v, process); err != nil {
+			dvid.Errorf("Error creating ROI %q from %q: %v\n", d.DataName(), req.Source, err)
+			d.setCreateError(err)
+		}
+	}()
+	return nil
+}
+
+// returns the error of the last asynchronous creation of the ROI, if it failed.
+func (d *Data) createError() error {
+	d.createMu.Lock()
+	defer d.createMu.Unlock()
+	return d.createErr
+}
+
+func (d *Data) setCreateError(err error) {
+	d.createMu.Lock()
+	d.createErr = err
+	d.createMu.Unlock()
+}
+
+func (d *Data) createROI(v dvid.VersionID, process func(f func(dvid.Spans) error) error) error {
+	timedLog := dvid.NewTimeLog()
+	vr := newVoxelROI(d.BlockSize)
+	err := process(func(spans dvid.Spans) error {
+		for _, span := range spans {
+			if err := vr.addVoxelSpan(span); err != nil {
+				return err
+			}
+		}
+		server.BlockOnInteractiveRequests("roi [create]")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	vr.normalize()
+	if err := d.putVoxelROI(v, vr); err != nil {
+		return err
+	}
+	timedLog.Infof("Created ROI %q with %d blocks", d.DataName(), len(vr.blocks))
+	return nil
+}
@@ -17,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
@@ -110,7 +111,8 @@ DEL  <api URL>/node/<UUID>/<data name>/roi
     Returns the data associated with the "medulla" ROI at version 3f8c.
     If an ROI is currently being created asynchronously, e.g., during an imageblk
     foreground command, then a HTTP status code 206 (Partial Content) is returned
-    until the ROI is completely stored (HTTP status code 200).
+    until the ROI is completely stored (HTTP status code 200).  If the last creation
+    of the ROI via POST /create failed, its error is returned until the ROI is replaced.
 
     The "Content-type" of the HTTP response (and usually the request) are
     "application/json" for arbitrary binary data.  Returns a list of 4-tuples:
@@ -121,11 +123,51 @@ DEL  <api URL>/node/<UUID>/<data name>/roi
 	(x0, y, z) to (x1, y, z).  Each block is a chunking of voxel space using the BlockSize for 
 	the ROI.
 
+	If the "voxels" query string is "true", the ROI is sent or returned at voxel resolution,
+	where each element [z, y, x0, x1] represents voxels (x0, y, z) to (x1, y, z).  Blocks
+	only partially within a voxel-resolution ROI are stored with a compressed mask of their 
+	voxels in the ROI.  Without the query string, a GET returns all blocks with any voxel in
+	the ROI and a POST replaces the ROI with whole blocks.
+
     Arguments:
 
     UUID          Hexadecimal string with enough characters to uniquely identify a version node.
     data name     Name of ROI data to save/modify or get.
 
+    Query-string Options:
+
+    voxels        If "true", spans are in voxel rather than block coordinates.
+
+POST <api URL>/node/<UUID>/<data name>/create
+
+	Asynchronously replaces the ROI with the voxels of bodies in a label data instance, e.g.,
+	labelmap, or the voxels of an image data instance, e.g., imageblk, with values in a range.
+	Until the ROI is created, GET requests for the ROI return HTTP status code 206 (Partial 
+	Content), and if the creation fails, they return its error.  A creation is rejected
+	while the ROI is being updated.  The POSTed JSON for bodies is of the form:
+
+	{ "source": "segmentation", "bodies": [23, 1001], "supervoxels": false }
+
+	where the optional "supervoxels" flag specifies that the ids are supervoxels.  The ROI is
+	built from the label indices of the bodies.  For a threshold, the JSON is of the form:
+
+	{ "source": "grayscale", "min": 100, "max": 255, "offset": [0, 0, 0], "size": [512, 512, 512] }
+
+	where voxels with values in [min, max] are within the ROI.  The region given by the optional
+	"offset" and "size" limits the voxels considered.  Otherwise all stored blocks are considered.
+	Only single channel image data can be thresholded.
+
+POST <api URL>/node/<UUID>/<data name>/combine
+
+	Replaces the ROI with the union, intersection or difference of ROIs at the same version,
+	which must have the same block size.  The POSTed JSON is of the form:
+
+	{ "op": "union", "rois": ["medulla", "lobula"] }
+
+	where "op" is "union", "intersect" or "difference".  For a difference, voxels in all
+	other ROIs are removed from the first ROI.  The operations are done at voxel resolution
+	and the named ROIs may include the ROI being written.
+
 GET <api URL>/node/<UUID>/<data name>/mask/0_1_2/<size>/<offset>
 
 	Returns a binary volume in ZYX order (increasing X is contiguous in array) same as format of
@@ -140,7 +182,8 @@ GET <api URL>/node/<UUID>/<data name>/mask/0_1_2/<size>/<offset>
 	GET <api URL>/node/3f8c/myroi/mask/0_1_2/512_512_256/100_200_300
 
 	Returns a binary volume with non-zero elements for voxels within ROI.  The binary volume
-	has size 512 x 512 x 256 voxels and an offset of (100, 200, 300).
+	has size 512 x 512 x 256 voxels and an offset of (100, 200, 300).  Voxel-resolution ROIs
+	only mark the voxels within the ROI.
 
 
 POST <api URL>/node/<UUID>/<data name>/ptquery
@@ -158,6 +201,9 @@ POST <api URL>/node/<UUID>/<data name>/ptquery
 
   	Returned: "[false, true]"
 
+	Points within blocks only partially within a voxel-resolution ROI are checked at voxel 
+	resolution.
+
 
 GET <api URL>/node/<UUID>/<data name>/partition?batchsize=8
 
@@ -169,6 +215,9 @@ GET <api URL>/node/<UUID>/<data name>/partition?batchsize=8
     optimized   If "true" or "on", partioning returns non-fixed sized subvolumes where the coverage
                   is better in terms of subvolumes having more active blocks.
 
+	For voxel-resolution ROIs, each subvolume's voxel extents are tightened to the ROI voxels
+	within it and "ActiveVoxels" gives the number of those voxels.
+
 TODO (API endpoints that are planned in near future)
 
 GET  <api URL>/node/<UUID>/<data name>/erode/<element size>
@@ -264,12 +313,18 @@ type Immutable struct {
 	version   dvid.VersionID
 	blockSize dvid.Point3d
 	blocks    map[dvid.IZYXString]struct{}
+	masks     map[dvid.IZYXString]blockMask
 }
 
 func (i Immutable) VoxelWithin(p dvid.Point3d) bool {
 	izyx := p.ToBlockIZYXString(i.blockSize)
-	_, found := i.blocks[izyx]
-	return found
+	if _, found := i.blocks[izyx]; !found {
+		return false
+	}
+	if mask, found := i.masks[izyx]; found {
+		return mask.isSet(maskIndex(p, i.blockSize))
+	}
+	return true
 }
 
 // ImmutableBySpec returns an Immutable ROI (or nil if not available) given
@@ -289,11 +344,17 @@ func ImmutableBySpec(spec string) (*Immutable, error) {
 		return nil, err
 	}
 
+	masks, err := d.getMasks(datastore.NewVersionedCtx(d, v), math.MinInt32, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+
 	// Setup the immutable.
 	im := Immutable{
 		version:   v,
 		blockSize: d.BlockSize,
 		blocks:    make(map[dvid.IZYXString]struct{}),
+		masks:     masks,
 	}
 	for _, span := range spans {
 		z, y, x0, x1 := span[0], span[1], span[2], span[3]
@@ -313,6 +374,9 @@ type Data struct {
 	Properties
 
 	sync.RWMutex
+
+	createMu  sync.Mutex
+	createErr error // error of the last asynchronous creation, cleared when the ROI is replaced
 }
 
 // IsMutationRequest overrides the default behavior to specify POST /ptquery as an immutable
@@ -328,6 +392,9 @@ func (d *Data) IsMutationRequest(action, endpoint string) bool {
 // DescribeTKeyClass returns a string explanation of what a particular TKeyClass
 // is used for.  Implements the datastore.TKeyClassDescriber interface.
 func (d *Data) DescribeTKeyClass(tkc storage.TKeyClass) string {
+	if tkc == keyMask {
+		return "ROI block voxel mask key"
+	}
 	return "ROI block + span key"
 }
 
@@ -450,6 +517,9 @@ const (
 
 	// keyROI are keys for ROI RLEs
 	keyROI = 90
+
+	// keyMask are keys for the voxel masks of blocks partially within the ROI
+	keyMask = 91
 )
 
 var (
@@ -585,6 +655,7 @@ func (d *Data) Delete(ctx storage.VersionedCtx) error {
 	putMutex.Lock()
 	defer putMutex.Unlock()
 
+	d.setCreateError(nil)
 	d.MinZ = math.MaxInt32
 	d.MaxZ = math.MinInt32
 	if err := datastore.SaveDataByVersion(ctx.VersionID(), d); err != nil {
@@ -602,13 +673,22 @@ func (d *Data) Delete(ctx storage.VersionedCtx) error {
 		span:  math.MaxUint32,
 	}
 	endTk := storage.NewTKey(keyROI, endIndex.Bytes())
-	return db.DeleteRange(ctx, begTk, endTk)
+	if err := db.DeleteRange(ctx, begTk, endTk); err != nil {
+		return err
+	}
+	return db.DeleteRange(ctx, storage.MinTKey(keyMask), storage.MaxTKey(keyMask))
 }
 
 // PutSpans saves a slice of spans representing an ROI into the datastore.
 // If the init parameter is true, all previous spans of this ROI are deleted before
 // writing these spans.
 func (d *Data) PutSpans(versionID dvid.VersionID, spans []dvid.Span, init bool) error {
+	return d.putSpans(versionID, spans, nil, init)
+}
+
+// putSpans saves block spans and the voxel masks of any blocks only partially within the ROI.
+// Blocks in the spans without a given mask are completely within the ROI.
+func (d *Data) putSpans(versionID dvid.VersionID, spans []dvid.Span, masks map[dvid.IZYXString]blockMask, init bool) error {
 	ctx := datastore.NewVersionedCtx(d, versionID)
 	db, err := datastore.GetOrderedKeyValueDB(d)
 	if err != nil {
@@ -646,6 +726,23 @@ func (d *Data) PutSpans(versionID dvid.VersionID, spans []dvid.Span, init bool)
 		putMutex.Unlock()
 	}()
 
+	// Blocks added to an existing ROI are now completely within it.
+	var oldMasks map[dvid.IZYXString]blockMask
+	if !init && len(spans) != 0 {
+		minZ, maxZ := spans[0][0], spans[0][0]
+		for _, span := range spans {
+			if span[0] < minZ {
+				minZ = span[0]
+			}
+			if span[0] > maxZ {
+				maxZ = span[0]
+			}
+		}
+		if oldMasks, err = d.getMasks(ctx, minZ, maxZ); err != nil {
+			return err
+		}
+	}
+
 	// Put the new key/values
 	const BATCH_SIZE = 10000
 	batch := batcher.NewBatch(ctx)
@@ -665,6 +762,14 @@ func (d *Data) PutSpans(versionID dvid.VersionID, spans []dvid.Span, init bool)
 		}
 		tk := storage.NewTKey(keyROI, index.Bytes())
 		batch.Put(tk, dvid.EmptyValue())
+		for izyx := range oldMasks {
+			if _, found := masks[izyx]; found {
+				continue
+			}
+			if c, err := izyx.ToChunkPoint3d(); err == nil && span.Includes(c) {
+				batch.Delete(maskTKey(izyx))
+			}
+		}
 		if (i+1)%BATCH_SIZE == 0 {
 			if err := batch.Commit(); err != nil {
 				return fmt.Errorf("Error on batch PUT at span %d: %v\n", i, err)
@@ -672,10 +777,15 @@ func (d *Data) PutSpans(versionID dvid.VersionID, spans []dvid.Span, init bool)
 			batch = batcher.NewBatch(ctx)
 		}
 	}
-	if len(spans)%BATCH_SIZE != 0 {
-		if err := batch.Commit(); err != nil {
-			return fmt.Errorf("Error on last batch PUT: %v\n", err)
+	for izyx, mask := range masks {
+		serialization, err := dvid.SerializeData(mask, d.Compression(), d.Checksum())
+		if err != nil {
+			return fmt.Errorf("unable to serialize ROI block mask: %v", err)
 		}
+		batch.Put(maskTKey(izyx), serialization)
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("Error on last batch PUT: %v\n", err)
 	}
 	return nil
 }
@@ -766,6 +876,36 @@ func (d *Data) GetMask(ctx *datastore.VersionedCtx, subvol *dvid.Subvolume) ([]b
 			}
 		}
 	}
+
+	// Clear voxels outside the ROI within blocks partially in the ROI.
+	masks, err := d.getMasks(ctx, minBlockZ, maxBlockZ)
+	if err != nil {
+		return nil, err
+	}
+	for izyx, mask := range masks {
+		c, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return nil, err
+		}
+		if c[1] < minBlockY || c[1] > maxBlockY || c[0] < minBlockX || c[0] > maxBlockX {
+			continue
+		}
+		x0, x1 := voxelRange(d.BlockSize[0], c[0], c[0], pt0.Value(0), pt1.Value(0))
+		y0, y1 := voxelRange(d.BlockSize[1], c[1], c[1], pt0.Value(1), pt1.Value(1))
+		z0, z1 := voxelRange(d.BlockSize[2], c[2], c[2], pt0.Value(2), pt1.Value(2))
+		for z := z0; z <= z1; z++ {
+			for y := y0; y <= y1; y++ {
+				i := z*nxy + y*nx + x0
+				for x := x0; x <= x1; x++ {
+					pt := dvid.Point3d{x + pt0.Value(0), y + pt0.Value(1), z + pt0.Value(2)}
+					if !mask.isSet(maskIndex(pt, d.BlockSize)) {
+						data[i] = 0
+					}
+					i++
+				}
+			}
+		}
+	}
 	return data, nil
 }
 
@@ -819,6 +959,31 @@ func (d *Data) PointQuery(ctx *datastore.VersionedCtx, jsonBytes []byte) ([]byte
 		inclusions[origIndex] = included
 	}
 
+	// Check included points against the masks of their blocks, which are only stored for
+	// blocks partially in the ROI.
+	var pts []dvid.Point3d
+	if err := json.Unmarshal(jsonBytes, &pts); err != nil {
+		return nil, err
+	}
+	blocks := make(map[dvid.IZYXString]struct{})
+	for i, pt := range pts {
+		if inclusions[i] {
+			blocks[pt.ToBlockIZYXString(d.BlockSize)] = struct{}{}
+		}
+	}
+	masks, err := d.getBlockMasks(ctx, blocks)
+	if err != nil {
+		return nil, err
+	}
+	for i, pt := range pts {
+		if !inclusions[i] {
+			continue
+		}
+		if mask, found := masks[pt.ToBlockIZYXString(d.BlockSize)]; found {
+			inclusions[i] = mask.isSet(maskIndex(pt, d.BlockSize))
+		}
+	}
+
 	// Convert to JSON
 	inclusionsJSON, err := json.Marshal(inclusions)
 	if err != nil {
@@ -830,6 +995,7 @@ func (d *Data) PointQuery(ctx *datastore.VersionedCtx, jsonBytes []byte) ([]byte
 type subvolumesT struct {
 	NumTotalBlocks  uint64
 	NumActiveBlocks uint64
+	NumActiveVoxels uint64 `json:",omitempty"`
 	NumSubvolumes   int32
 	ROI             dvid.ChunkExtents3d
 	Subvolumes      []subvolumeT
@@ -840,6 +1006,7 @@ type subvolumeT struct {
 	dvid.ChunkExtents3d
 	TotalBlocks  uint64
 	ActiveBlocks uint64
+	ActiveVoxels uint64 `json:",omitempty"`
 }
 
 type layerT struct {
@@ -1160,6 +1327,9 @@ func (d *Data) Partition(ctx storage.Context, batchsize int32) ([]byte, error) {
 		d.addSubvolumes(layer, &subvolumes, batchsize, merge)
 	}
 	subvolumes.NumSubvolumes = int32(len(subvolumes.Subvolumes))
+	if err := d.addSubvolumeVoxels(ctx, &subvolumes); err != nil {
+		return nil, err
+	}
 
 	// Encode as JSON
 	jsonBytes, err := json.MarshalIndent(subvolumes, "", "    ")
@@ -1296,6 +1466,9 @@ func (d *Data) SimplePartition(ctx storage.Context, batchsize int32) ([]byte, er
 		d.addSubvolumesGrid(layer, &subvolumes, batchsize)
 	}
 	subvolumes.NumSubvolumes = int32(len(subvolumes.Subvolumes))
+	if err := d.addSubvolumeVoxels(ctx, &subvolumes); err != nil {
+		return nil, err
+	}
 
 	// Encode as JSON
 	jsonBytes, err := json.MarshalIndent(subvolumes, "", "    ")
@@ -1317,6 +1490,20 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 		d.DataName(), d.TypeName(), request.TypeCommand())
 }
 
+// sends a mutation message for changes to the ROI to Kafka and any change feed.
+func (d *Data) sendMutationMsg(uuid dvid.UUID, action string, numBytes int) {
+	msginfo := map[string]interface{}{
+		"Action":    action,
+		"Bytes":     numBytes,
+		"UUID":      string(uuid),
+		"Timestamp": time.Now().String(),
+	}
+	jsonmsg, _ := json.Marshal(msginfo)
+	if err := d.ProduceKafkaMsg(jsonmsg); err != nil {
+		dvid.Errorf("Error on sending roi %s op to kafka: %v\n", action, err)
+	}
+}
+
 // ServeHTTP handles all incoming HTTP requests for this data.
 func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request) (activity map[string]interface{}) {
 	timedLog := dvid.NewTimeLog()
@@ -1357,16 +1544,33 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 	method := strings.ToLower(r.Method)
 	switch command {
 	case "roi":
+		voxels := r.URL.Query().Get("voxels") == "true"
 		switch method {
 		case "get":
+			if err := d.createError(); err != nil {
+				server.BadRequest(w, r, "creation of ROI %q failed: %v", d.DataName(), err)
+				return
+			}
+			var jsonBytes []byte
+			var err error
 			d.RLock()
-			jsonBytes, err := Get(ctx)
+			if voxels {
+				var spans []dvid.Span
+				if spans, err = d.GetVoxelSpans(ctx.VersionID()); err == nil {
+					jsonBytes, err = json.Marshal(spans)
+				}
+			} else {
+				jsonBytes, err = Get(ctx)
+			}
 			d.RUnlock()
 			if err != nil {
 				server.BadRequest(w, r, err)
 				return
 			}
 			w.Header().Set("Content-Type", "application/json")
+			if d.Updating() {
+				w.WriteHeader(http.StatusPartialContent)
+			}
 			fmt.Fprintf(w, string(jsonBytes))
 			comment = fmt.Sprintf("HTTP GET ROI %q: %d bytes", d.DataName(), len(jsonBytes))
 		case "post":
@@ -1375,19 +1579,62 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 				server.BadRequest(w, r, err)
 				return
 			}
-			err = d.PutJSON(ctx.VersionID(), data)
+			if voxels {
+				spans := []dvid.Span{}
+				if err = json.Unmarshal(data, &spans); err != nil {
+					server.BadRequest(w, r, "Error trying to parse POSTed JSON: %v", err)
+					return
+				}
+				err = d.PutVoxelSpans(ctx.VersionID(), spans)
+			} else {
+				err = d.PutJSON(ctx.VersionID(), data)
+			}
 			if err != nil {
 				server.BadRequest(w, r, err)
 				return
 			}
+			d.sendMutationMsg(uuid, "postroi", len(data))
 			comment = fmt.Sprintf("HTTP POST ROI %q: %d bytes", d.DataName(), len(data))
 		case "delete":
 			if err := d.Delete(ctx); err != nil {
 				server.BadRequest(w, r, err)
 				return
 			}
+			d.sendMutationMsg(uuid, "deleteroi", 0)
 			comment = fmt.Sprintf("HTTP DELETE ROI %q", d.DataName())
 		}
+	case "create":
+		if method != "post" {
+			server.BadRequest(w, r, "create only supports POST request")
+			return
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if err := d.CreateFromJSON(ctx.VersionID(), data); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		d.sendMutationMsg(uuid, "create", len(data))
+		comment = fmt.Sprintf("HTTP POST create ROI %q: %s", d.DataName(), string(data))
+	case "combine":
+		if method != "post" {
+			server.BadRequest(w, r, "combine only supports POST request")
+			return
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if err := d.CombineJSON(ctx.VersionID(), data); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		d.sendMutationMsg(uuid, "combine", len(data))
+		comment = fmt.Sprintf("HTTP POST combine ROI %q: %s", d.DataName(), string(data))
 	case "mask":
 		if method != "get" {
 			server.BadRequest(w, r, "ROI mask only supports GET")
@@ -384,6 +384,139 @@ func TestROIPostAndDelete(t *testing.T) {
 	}
 }
 
+// returns voxel spans for each row of a box.
+func boxSpans(minPt, maxPt dvid.Point3d) dvid.Spans {
+	var spans dvid.Spans
+	for z := minPt[2]; z <= maxPt[2]; z++ {
+		for y := minPt[1]; y <= maxPt[1]; y++ {
+			spans = append(spans, dvid.Span{z, y, minPt[0], maxPt[0]})
+		}
+	}
+	return spans
+}
+
+func getVoxelSpans(t *testing.T, uuid dvid.UUID, name string) dvid.Spans {
+	roiRequest := fmt.Sprintf("%snode/%s/%s/roi?voxels=true", server.WebAPIPath, uuid, name)
+	spans, err := putSpansJSON(server.TestHTTP(t, "GET", roiRequest, nil))
+	if err != nil {
+		t.Fatalf("Error on getting back JSON from roi GET: %v\n", err)
+	}
+	return dvid.Spans(spans).Normalize()
+}
+
+func TestVoxelROI(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	config := dvid.NewConfig()
+	config.Set("BlockSize", "8,8,8")
+	for _, name := range []string{"a", "b", "c"} {
+		server.CreateTestInstance(t, uuid, "roi", name, config)
+	}
+
+	// ROI "a" has a full block at (2,0,0) and voxels partially within blocks (0,0,0) and (1,0,0).
+	fullBlock := boxSpans(dvid.Point3d{16, 0, 0}, dvid.Point3d{23, 7, 7})
+	partial := boxSpans(dvid.Point3d{0, 0, 0}, dvid.Point3d{9, 1, 1})
+	spansA := append(append(dvid.Spans{}, partial...), fullBlock...).Normalize()
+	roiRequest := fmt.Sprintf("%snode/%s/a/roi?voxels=true", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", roiRequest, getSpansJSON(spansA))
+	if got := getVoxelSpans(t, uuid, "a"); !reflect.DeepEqual(got, spansA) {
+		t.Fatalf("Bad voxel ROI roundtrip\nOriginal:\n%v\nReturned:\n%v\n", spansA, got)
+	}
+	roiRequest = fmt.Sprintf("%snode/%s/a/roi", server.WebAPIPath, uuid)
+	blockSpans, err := putSpansJSON(server.TestHTTP(t, "GET", roiRequest, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(blockSpans, []dvid.Span{{0, 0, 0, 2}}) {
+		t.Errorf("Bad block spans for voxel ROI: %v\n", blockSpans)
+	}
+
+	// Point queries and masks use voxel resolution.
+	ptRequest := fmt.Sprintf("%snode/%s/a/ptquery", server.WebAPIPath, uuid)
+	resp := server.TestHTTP(t, "POST", ptRequest, bytes.NewBufferString("[[0,0,0],[9,1,1],[10,0,0],[5,5,5],[20,5,5],[30,5,5]]"))
+	if string(resp) != "[true,true,false,false,true,false]" {
+		t.Errorf("Bad voxel ROI point query: %s\n", string(resp))
+	}
+	maskRequest := fmt.Sprintf("%snode/%s/a/mask/0_1_2/24_8_8/0_0_0", server.WebAPIPath, uuid)
+	mask := server.TestHTTP(t, "GET", maskRequest, nil)
+	var numInside int
+	for _, v := range mask {
+		if v != 0 {
+			numInside++
+		}
+	}
+	if numInside != 2*2*10+512 || mask[0] != 1 || mask[10] != 0 || mask[24*8+9] != 1 {
+		t.Errorf("Bad voxel ROI mask with %d voxels inside\n", numInside)
+	}
+	im, err := ImmutableBySpec(fmt.Sprintf("a,%s", uuid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !im.VoxelWithin(dvid.Point3d{9, 1, 1}) || im.VoxelWithin(dvid.Point3d{9, 2, 1}) {
+		t.Errorf("Bad voxel checks for immutable voxel ROI\n")
+	}
+
+	// Partitions are tightened to ROI voxels.
+	partitionRequest := fmt.Sprintf("%snode/%s/a/partition?batchsize=1", server.WebAPIPath, uuid)
+	var subvolumes subvolumesT
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", partitionRequest, nil), &subvolumes); err != nil {
+		t.Fatal(err)
+	}
+	if subvolumes.NumActiveVoxels != 2*2*10+512 || len(subvolumes.Subvolumes) != 3 {
+		t.Fatalf("Bad voxel ROI partition: %v\n", subvolumes)
+	}
+	expectedExt := dvid.Extents3d{MinPoint: dvid.Point3d{0, 0, 0}, MaxPoint: dvid.Point3d{7, 1, 1}}
+	if subvolumes.Subvolumes[0].Extents3d != expectedExt || subvolumes.Subvolumes[0].ActiveVoxels != 2*2*8 {
+		t.Errorf("Bad first subvolume of voxel ROI partition: %v\n", subvolumes.Subvolumes[0])
+	}
+
+	// Set operations between ROIs.
+	roiRequest = fmt.Sprintf("%snode/%s/b/roi?voxels=true", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", roiRequest, getSpansJSON(boxSpans(dvid.Point3d{5, 0, 0}, dvid.Point3d{20, 1, 1})))
+	combineRequest := fmt.Sprintf("%snode/%s/c/combine", server.WebAPIPath, uuid)
+	tests := []struct {
+		op       string
+		expected dvid.Spans
+	}{
+		{
+			"intersect",
+			append(boxSpans(dvid.Point3d{5, 0, 0}, dvid.Point3d{9, 1, 1}), boxSpans(dvid.Point3d{16, 0, 0}, dvid.Point3d{20, 1, 1})...),
+		},
+		{
+			"union",
+			append(boxSpans(dvid.Point3d{0, 0, 0}, dvid.Point3d{23, 1, 1}), fullBlock...),
+		},
+		{
+			"difference",
+			append(append(boxSpans(dvid.Point3d{0, 0, 0}, dvid.Point3d{4, 1, 1}), boxSpans(dvid.Point3d{21, 0, 0}, dvid.Point3d{23, 1, 1})...),
+				boxSpans(dvid.Point3d{16, 2, 0}, dvid.Point3d{23, 7, 1})...),
+		},
+	}
+	for _, tc := range tests {
+		if tc.op == "difference" {
+			tc.expected = append(tc.expected, boxSpans(dvid.Point3d{16, 0, 2}, dvid.Point3d{23, 7, 7})...)
+		}
+		body := fmt.Sprintf(`{"op": %q, "rois": ["a", "b"]}`, tc.op)
+		server.TestHTTP(t, "POST", combineRequest, bytes.NewBufferString(body))
+		if got, expected := getVoxelSpans(t, uuid, "c"), tc.expected.Normalize(); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Bad %s of ROIs\nExpected:\n%v\nGot:\n%v\n", tc.op, expected, got)
+		}
+	}
+	server.TestBadHTTP(t, "POST", combineRequest, bytes.NewBufferString(`{"op": "xor", "rois": ["a", "b"]}`))
+
+	// Replacing with whole blocks removes the voxel masks.
+	roiRequest = fmt.Sprintf("%snode/%s/a/roi", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", roiRequest, getSpansJSON([]dvid.Span{{0, 0, 0, 0}}))
+	resp = server.TestHTTP(t, "POST", ptRequest, bytes.NewBufferString("[[0,0,0],[7,7,7],[8,0,0]]"))
+	if string(resp) != "[true,true,false]" {
+		t.Errorf("Bad point query after replacing voxel ROI with blocks: %s\n", string(resp))
+	}
+}
+
 func TestROICreateAndSerialize(t *testing.T) {
 	if err := server.OpenTest(); err != nil {
 		t.Fatalf("can't open test server: %v\n", err)
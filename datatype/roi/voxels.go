@@ -0,0 +1,545 @@
+/*
+	This file supports voxel-resolution ROIs, where each block only partially within the ROI
+	has a stored mask of its voxels inside the ROI, and set operations between ROIs.
+*/
+
+package roi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// blockMask has a bit for each voxel of a block in ZYX order.
+type blockMask []byte
+
+func newBlockMask(numVoxels int) blockMask {
+	return make(blockMask, (numVoxels+7)/8)
+}
+
+func (m blockMask) set(i int) {
+	m[i>>3] |= 1 << uint(i&7)
+}
+
+func (m blockMask) isSet(i int) bool {
+	return m[i>>3]&(1<<uint(i&7)) != 0
+}
+
+func (m blockMask) count() int {
+	var n int
+	for _, b := range m {
+		n += bits.OnesCount8(b)
+	}
+	return n
+}
+
+// returns the mask for a block completely within the ROI.
+func fullBlockMask(numVoxels int) blockMask {
+	m := newBlockMask(numVoxels)
+	for i := 0; i < numVoxels; i++ {
+		m.set(i)
+	}
+	return m
+}
+
+func floorDiv(a, b int32) int32 {
+	if a < 0 {
+		return (a - b + 1) / b
+	}
+	return a / b
+}
+
+// voxelROI is an in-memory ROI where blocks completely within the ROI have a nil mask.
+type voxelROI struct {
+	blockSize dvid.Point3d
+	blocks    map[dvid.IZYXString]blockMask
+}
+
+func newVoxelROI(blockSize dvid.Point3d) *voxelROI {
+	return &voxelROI{
+		blockSize: blockSize,
+		blocks:    make(map[dvid.IZYXString]blockMask),
+	}
+}
+
+func (vr *voxelROI) blockVoxels() int {
+	return int(vr.blockSize.Prod())
+}
+
+// returns the mask of a block in the ROI, with a full mask for blocks without one.
+func (vr *voxelROI) mask(izyx dvid.IZYXString) (blockMask, bool) {
+	m, found := vr.blocks[izyx]
+	if found && m == nil {
+		m = fullBlockMask(vr.blockVoxels())
+	}
+	return m, found
+}
+
+// sets the mask of a block, removing the block if empty and dropping the mask if full.
+func (vr *voxelROI) setMask(izyx dvid.IZYXString, m blockMask) {
+	switch m.count() {
+	case 0:
+		delete(vr.blocks, izyx)
+	case vr.blockVoxels():
+		vr.blocks[izyx] = nil
+	default:
+		vr.blocks[izyx] = m
+	}
+}
+
+// addVoxelSpan adds a span of voxels given as [z, y, x0, x1] in voxel coordinates.
+func (vr *voxelROI) addVoxelSpan(span dvid.Span) error {
+	z, y, x0, x1 := span.Unpack()
+	if x1 < x0 {
+		return fmt.Errorf("bad voxel span %v: x1 < x0", span)
+	}
+	bz := floorDiv(z, vr.blockSize[2])
+	by := floorDiv(y, vr.blockSize[1])
+	lz := z - bz*vr.blockSize[2]
+	ly := y - by*vr.blockSize[1]
+	rowOffset := int((lz*vr.blockSize[1] + ly) * vr.blockSize[0])
+	for bx := floorDiv(x0, vr.blockSize[0]); bx <= floorDiv(x1, vr.blockSize[0]); bx++ {
+		izyx := dvid.ChunkPoint3d{bx, by, bz}.ToIZYXString()
+		m, found := vr.blocks[izyx]
+		if found && m == nil {
+			continue // already full
+		}
+		if !found {
+			m = newBlockMask(vr.blockVoxels())
+			vr.blocks[izyx] = m
+		}
+		blockX0 := bx * vr.blockSize[0]
+		lx0, lx1 := x0-blockX0, x1-blockX0
+		if lx0 < 0 {
+			lx0 = 0
+		}
+		if lx1 >= vr.blockSize[0] {
+			lx1 = vr.blockSize[0] - 1
+		}
+		for lx := lx0; lx <= lx1; lx++ {
+			m.set(rowOffset + int(lx))
+		}
+	}
+	return nil
+}
+
+// normalize drops masks of blocks that are completely within the ROI.
+func (vr *voxelROI) normalize() {
+	for izyx, m := range vr.blocks {
+		if m != nil {
+			vr.setMask(izyx, m)
+		}
+	}
+}
+
+// blockSpans returns the normalized spans of blocks with any voxel in the ROI.
+func (vr *voxelROI) blockSpans() (dvid.Spans, error) {
+	spans := make(dvid.Spans, 0, len(vr.blocks))
+	for izyx := range vr.blocks {
+		x, y, z, err := izyx.Unpack()
+		if err != nil {
+			return nil, err
+		}
+		spans = append(spans, dvid.Span{z, y, x, x})
+	}
+	return spans.Normalize(), nil
+}
+
+// voxelSpans returns the normalized spans of voxels in the ROI.
+func (vr *voxelROI) voxelSpans() (dvid.Spans, error) {
+	var spans dvid.Spans
+	bs := vr.blockSize
+	for izyx, m := range vr.blocks {
+		c, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return nil, err
+		}
+		offset := c.MinPoint(bs).(dvid.Point3d)
+		if m == nil {
+			for z := int32(0); z < bs[2]; z++ {
+				for y := int32(0); y < bs[1]; y++ {
+					spans = append(spans, dvid.Span{offset[2] + z, offset[1] + y, offset[0], offset[0] + bs[0] - 1})
+				}
+			}
+			continue
+		}
+		i := 0
+		for z := int32(0); z < bs[2]; z++ {
+			for y := int32(0); y < bs[1]; y++ {
+				inSpan := false
+				var x0 int32
+				for x := int32(0); x < bs[0]; x++ {
+					if m.isSet(i) {
+						if !inSpan {
+							x0 = x
+							inSpan = true
+						}
+					} else if inSpan {
+						spans = append(spans, dvid.Span{offset[2] + z, offset[1] + y, offset[0] + x0, offset[0] + x - 1})
+						inSpan = false
+					}
+					i++
+				}
+				if inSpan {
+					spans = append(spans, dvid.Span{offset[2] + z, offset[1] + y, offset[0] + x0, offset[0] + bs[0] - 1})
+				}
+			}
+		}
+	}
+	return spans.Normalize(), nil
+}
+
+// voxelBounds returns the extents and number of the voxels in the ROI within a block.
+func (vr *voxelROI) voxelBounds(izyx dvid.IZYXString) (ext dvid.Extents3d, numVoxels uint64, err error) {
+	var c dvid.ChunkPoint3d
+	if c, err = izyx.ToChunkPoint3d(); err != nil {
+		return
+	}
+	offset := c.MinPoint(vr.blockSize).(dvid.Point3d)
+	m := vr.blocks[izyx]
+	if m == nil {
+		ext = dvid.Extents3d{MinPoint: offset, MaxPoint: c.MaxPoint(vr.blockSize).(dvid.Point3d)}
+		numVoxels = uint64(vr.blockVoxels())
+		return
+	}
+	ext = dvid.Extents3d{
+		MinPoint: dvid.Point3d{math.MaxInt32, math.MaxInt32, math.MaxInt32},
+		MaxPoint: dvid.Point3d{math.MinInt32, math.MinInt32, math.MinInt32},
+	}
+	i := 0
+	for z := int32(0); z < vr.blockSize[2]; z++ {
+		for y := int32(0); y < vr.blockSize[1]; y++ {
+			for x := int32(0); x < vr.blockSize[0]; x++ {
+				if m.isSet(i) {
+					ext.Extend(dvid.Point3d{offset[0] + x, offset[1] + y, offset[2] + z})
+					numVoxels++
+				}
+				i++
+			}
+		}
+	}
+	return
+}
+
+// union adds the voxels of another ROI with the same block size.
+func (vr *voxelROI) union(vr2 *voxelROI) {
+	for izyx, m2 := range vr2.blocks {
+		m, found := vr.blocks[izyx]
+		switch {
+		case !found || m2 == nil:
+			vr.blocks[izyx] = m2
+		case m == nil:
+		default:
+			union := newBlockMask(vr.blockVoxels())
+			for i := range union {
+				union[i] = m[i] | m2[i]
+			}
+			vr.setMask(izyx, union)
+		}
+	}
+}
+
+// intersect removes the voxels not in another ROI with the same block size.
+func (vr *voxelROI) intersect(vr2 *voxelROI) {
+	for izyx, m := range vr.blocks {
+		m2, found := vr2.blocks[izyx]
+		switch {
+		case !found:
+			delete(vr.blocks, izyx)
+		case m2 == nil:
+		case m == nil:
+			vr.blocks[izyx] = m2
+		default:
+			intersection := newBlockMask(vr.blockVoxels())
+			for i := range intersection {
+				intersection[i] = m[i] & m2[i]
+			}
+			vr.setMask(izyx, intersection)
+		}
+	}
+}
+
+// subtract removes the voxels in another ROI with the same block size.
+func (vr *voxelROI) subtract(vr2 *voxelROI) {
+	for izyx := range vr.blocks {
+		m2, found := vr2.blocks[izyx]
+		switch {
+		case !found:
+		case m2 == nil:
+			delete(vr.blocks, izyx)
+		default:
+			m, _ := vr.mask(izyx)
+			difference := newBlockMask(vr.blockVoxels())
+			for i := range difference {
+				difference[i] = m[i] &^ m2[i]
+			}
+			vr.setMask(izyx, difference)
+		}
+	}
+}
+
+// returns the bit index of a voxel within its block.
+func maskIndex(pt dvid.Point3d, blockSize dvid.Point3d) int {
+	lx := pt[0] - floorDiv(pt[0], blockSize[0])*blockSize[0]
+	ly := pt[1] - floorDiv(pt[1], blockSize[1])*blockSize[1]
+	lz := pt[2] - floorDiv(pt[2], blockSize[2])*blockSize[2]
+	return int((lz*blockSize[1]+ly)*blockSize[0] + lx)
+}
+
+func maskTKey(izyx dvid.IZYXString) storage.TKey {
+	return storage.NewTKey(keyMask, []byte(izyx))
+}
+
+// returns the block masks between the given block Z coordinates, inclusive.
+func (d *Data) getMasks(ctx storage.Context, minZ, maxZ int32) (map[dvid.IZYXString]blockMask, error) {
+	db, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, err
+	}
+	masks := make(map[dvid.IZYXString]blockMask)
+	begIndex := dvid.IndexZYX{math.MinInt32, math.MinInt32, minZ}
+	endIndex := dvid.IndexZYX{math.MaxInt32, math.MaxInt32, maxZ}
+	begTKey := storage.NewTKey(keyMask, begIndex.Bytes())
+	endTKey := storage.NewTKey(keyMask, endIndex.Bytes())
+	err = db.ProcessRange(ctx, begTKey, endTKey, &storage.ChunkOp{}, func(chunk *storage.Chunk) error {
+		ibytes, err := chunk.K.ClassBytes(keyMask)
+		if err != nil {
+			return err
+		}
+		data, _, err := dvid.DeserializeData(chunk.V, true)
+		if err != nil {
+			return fmt.Errorf("unable to deserialize ROI block mask: %v", err)
+		}
+		masks[dvid.IZYXString(ibytes)] = blockMask(data)
+		return nil
+	})
+	return masks, err
+}
+
+// returns the masks of the given blocks, omitting blocks without a stored mask.
+func (d *Data) getBlockMasks(ctx storage.Context, blocks map[dvid.IZYXString]struct{}) (map[dvid.IZYXString]blockMask, error) {
+	db, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, err
+	}
+	masks := make(map[dvid.IZYXString]blockMask)
+	for izyx := range blocks {
+		value, err := db.Get(ctx, maskTKey(izyx))
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		data, _, err := dvid.DeserializeData(value, true)
+		if err != nil {
+			return nil, fmt.Errorf("unable to deserialize ROI block mask: %v", err)
+		}
+		masks[izyx] = blockMask(data)
+	}
+	return masks, nil
+}
+
+// getVoxelROI returns the ROI with masks for blocks partially within the ROI.
+func (d *Data) getVoxelROI(ctx storage.Context) (*voxelROI, error) {
+	db, err := datastore.GetOrderedKeyValueDB(d)
+	if err != nil {
+		return nil, err
+	}
+	vr := newVoxelROI(d.BlockSize)
+	err = db.ProcessRange(ctx, storage.MinTKey(keyROI), storage.MaxTKey(keyROI), &storage.ChunkOp{}, func(chunk *storage.Chunk) error {
+		ibytes, err := chunk.K.ClassBytes(keyROI)
+		if err != nil {
+			return err
+		}
+		index := new(indexRLE)
+		if err = index.IndexFromBytes(ibytes); err != nil {
+			return fmt.Errorf("Unable to get indexRLE out of []byte encoding: %v\n", err)
+		}
+		x0, y, z := index.start.Unpack()
+		for x := x0; x < x0+int32(index.span); x++ {
+			vr.blocks[dvid.ChunkPoint3d{x, y, z}.ToIZYXString()] = nil
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	masks, err := d.getMasks(ctx, math.MinInt32, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	for izyx, m := range masks {
+		if _, found := vr.blocks[izyx]; found {
+			vr.blocks[izyx] = m
+		}
+	}
+	return vr, nil
+}
+
+// PutVoxelSpans replaces the ROI with the given voxel spans, each of the form [z, y, x0, x1]
+// in voxel coordinates.
+func (d *Data) PutVoxelSpans(v dvid.VersionID, spans []dvid.Span) error {
+	vr := newVoxelROI(d.BlockSize)
+	for _, span := range spans {
+		if err := vr.addVoxelSpan(span); err != nil {
+			return err
+		}
+	}
+	vr.normalize()
+	return d.putVoxelROI(v, vr)
+}
+
+// GetVoxelSpans returns the voxel spans of the ROI, each of the form [z, y, x0, x1] in voxel
+// coordinates, sorted by z, y, then x0.
+func (d *Data) GetVoxelSpans(v dvid.VersionID) ([]dvid.Span, error) {
+	vr, err := d.getVoxelROI(datastore.NewVersionedCtx(d, v))
+	if err != nil {
+		return nil, err
+	}
+	return vr.voxelSpans()
+}
+
+// replaces the ROI with the given voxel ROI.
+func (d *Data) putVoxelROI(v dvid.VersionID, vr *voxelROI) error {
+	spans, err := vr.blockSpans()
+	if err != nil {
+		return err
+	}
+	masks := make(map[dvid.IZYXString]blockMask)
+	for izyx, m := range vr.blocks {
+		if m != nil {
+			masks[izyx] = m
+		}
+	}
+	return d.putSpans(v, spans, masks, true)
+}
+
+// CombineOp is a set operation between ROIs.
+type CombineOp string
+
+const (
+	Union      CombineOp = "union"
+	Intersect  CombineOp = "intersect"
+	Difference CombineOp = "difference"
+)
+
+// Combine replaces the ROI with the result of the operation on the given ROIs at the same
+// version.  For a difference, the voxels in all other ROIs are removed from the first ROI.
+func (d *Data) Combine(v dvid.VersionID, op CombineOp, roiNames []dvid.InstanceName) error {
+	if len(roiNames) == 0 {
+		return fmt.Errorf("no ROIs given for %s", op)
+	}
+	var result *voxelROI
+	for _, name := range roiNames {
+		dataservice, err := datastore.GetDataByVersionName(v, name)
+		if err != nil {
+			return err
+		}
+		d2, ok := dataservice.(*Data)
+		if !ok {
+			return fmt.Errorf("data instance %q is not an ROI", name)
+		}
+		if !d2.BlockSize.Equals(d.BlockSize) {
+			return fmt.Errorf("ROI %q has block size %s, not %s", name, d2.BlockSize, d.BlockSize)
+		}
+		d2.RLock()
+		vr, err := d2.getVoxelROI(datastore.NewVersionedCtx(d2, v))
+		d2.RUnlock()
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			result = vr
+			continue
+		}
+		switch op {
+		case Union:
+			result.union(vr)
+		case Intersect:
+			result.intersect(vr)
+		case Difference:
+			result.subtract(vr)
+		default:
+			return fmt.Errorf("unknown ROI operation %q", op)
+		}
+	}
+	return d.putVoxelROI(v, result)
+}
+
+// combineRequest is the JSON sent to POST /combine.
+type combineRequest struct {
+	Op   CombineOp           `json:"op"`
+	ROIs []dvid.InstanceName `json:"rois"`
+}
+
+// CombineJSON replaces the ROI with the result of a JSON-encoded set operation on ROIs.
+func (d *Data) CombineJSON(v dvid.VersionID, jsonBytes []byte) error {
+	var req combineRequest
+	if err := json.Unmarshal(jsonBytes, &req); err != nil {
+		return fmt.Errorf("Error trying to parse POSTed JSON: %v", err)
+	}
+	switch req.Op {
+	case Union, Intersect, Difference:
+	default:
+		return fmt.Errorf("unknown ROI operation %q, must be %q, %q or %q", req.Op, Union, Intersect, Difference)
+	}
+	return d.Combine(v, req.Op, req.ROIs)
+}
+
+// tightens the voxel extents of subvolumes to the voxels of an ROI with block masks and
+// adds the number of voxels within each subvolume.
+func (d *Data) addSubvolumeVoxels(ctx storage.Context, subvolumes *subvolumesT) error {
+	vr, err := d.getVoxelROI(ctx)
+	if err != nil {
+		return err
+	}
+	var hasMasks bool
+	for _, m := range vr.blocks {
+		if m != nil {
+			hasMasks = true
+			break
+		}
+	}
+	if !hasMasks {
+		return nil
+	}
+	for i, subvolume := range subvolumes.Subvolumes {
+		minChunk, maxChunk := subvolume.MinChunk, subvolume.MaxChunk
+		ext := dvid.Extents3d{
+			MinPoint: dvid.Point3d{math.MaxInt32, math.MaxInt32, math.MaxInt32},
+			MaxPoint: dvid.Point3d{math.MinInt32, math.MinInt32, math.MinInt32},
+		}
+		var numVoxels uint64
+		for z := minChunk[2]; z <= maxChunk[2]; z++ {
+			for y := minChunk[1]; y <= maxChunk[1]; y++ {
+				for x := minChunk[0]; x <= maxChunk[0]; x++ {
+					izyx := dvid.ChunkPoint3d{x, y, z}.ToIZYXString()
+					if _, found := vr.blocks[izyx]; !found {
+						continue
+					}
+					blockExt, blockVoxels, err := vr.voxelBounds(izyx)
+					if err != nil {
+						return err
+					}
+					ext.Extend(blockExt.MinPoint)
+					ext.Extend(blockExt.MaxPoint)
+					numVoxels += blockVoxels
+				}
+			}
+		}
+		if numVoxels == 0 {
+			continue
+		}
+		subvolumes.Subvolumes[i].Extents3d = ext
+		subvolumes.Subvolumes[i].ActiveVoxels = numVoxels
+		subvolumes.NumActiveVoxels += numVoxels
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+	This file supports alternative aggregate formats for the supervoxel data of a body:
+	zip archives, merged meshes, and newline-delimited JSON manifests.
+*/
+
+package tarsupervoxels
+
+import (
+	"archive/zip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datatype/common/mesh"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// manifestEntry is one line of the JSON manifest for a body's supervoxel data.
+type manifestEntry struct {
+	Supervoxel uint64 `json:"supervoxel"`
+	File       string `json:"file"`
+	Size       int    `json:"size"`
+	MD5        string `json:"md5,omitempty"`
+	Missing    bool   `json:"missing,omitempty"`
+}
+
+// sendAggregate writes the supervoxel data of a label in the given format, which may be
+// "zip", "mesh", or "ndjson".
+func (d *Data) sendAggregate(w http.ResponseWriter, uuid dvid.UUID, label uint64, generate bool, format string) error {
+	switch format {
+	case "zip":
+		return d.sendZip(w, uuid, label, generate)
+	case "mesh":
+		return d.sendMergedMesh(w, uuid, label, generate)
+	case "ndjson":
+		return d.sendManifest(w, uuid, label, generate)
+	default:
+		return fmt.Errorf("unknown aggregate format %q", format)
+	}
+}
+
+func (d *Data) sendZip(w http.ResponseWriter, uuid dvid.UUID, label uint64, generate bool) error {
+	var zw *zip.Writer
+	err := d.processSupervoxelFiles(uuid, label, generate, func(fd fileData) error {
+		if zw == nil {
+			w.Header().Set("Content-type", "application/zip")
+			zw = zip.NewWriter(w)
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     fd.header.Name,
+			Method:   zip.Deflate,
+			Modified: fd.header.ModTime,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(fd.data)
+		return err
+	})
+	if zw != nil {
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// sends a single mesh merged from the supervoxel meshes in supervoxel order, skipping
+// missing supervoxels.
+func (d *Data) sendMergedMesh(w http.ResponseWriter, uuid dvid.UUID, label uint64, generate bool) error {
+	format := strings.ToLower(d.Extension)
+	if !mesh.ValidFormat(format) {
+		return fmt.Errorf("data %q has extension %q, which is not a mergeable mesh format (ngmesh, obj, ply)", d.DataName(), d.Extension)
+	}
+	var files []fileData
+	err := d.processSupervoxelFiles(uuid, label, generate, func(fd fileData) error {
+		if !fd.missing {
+			files = append(files, fd)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].supervoxel < files[j].supervoxel })
+	merger := mesh.NewMerger()
+	for _, fd := range files {
+		m, err := mesh.Read(fd.data, format)
+		if err == nil {
+			err = merger.Add(m)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to merge mesh for supervoxel %d: %v", fd.supervoxel, err)
+		}
+	}
+	w.Header().Set("Content-type", mesh.ContentType(format))
+	return merger.Mesh().Write(w, format)
+}
+
+// sends a JSON line for each supervoxel's data in supervoxel order.
+func (d *Data) sendManifest(w http.ResponseWriter, uuid dvid.UUID, label uint64, generate bool) error {
+	var entries []manifestEntry
+	err := d.processSupervoxelFiles(uuid, label, generate, func(fd fileData) error {
+		entry := manifestEntry{
+			Supervoxel: fd.supervoxel,
+			File:       fd.header.Name,
+			Size:       len(fd.data),
+			Missing:    fd.missing,
+		}
+		if !fd.missing {
+			hash := md5.Sum(fd.data)
+			entry.MD5 = hex.EncodeToString(hash[:])
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Supervoxel < entries[j].Supervoxel })
+	w.Header().Set("Content-type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
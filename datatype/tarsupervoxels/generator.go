@@ -0,0 +1,118 @@
+/*
+	This file supports on-demand generation of data for supervoxels without stored data,
+	either by a command in the server configuration or a registered Go Generator.
+*/
+
+package tarsupervoxels
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+const (
+	// maximum number of supervoxels generated concurrently for each data instance.
+	maxConcurrentGenerations = 8
+
+	// maximum time allowed for a generator command to produce a supervoxel's data.
+	generatorCommandTimeout = 10 * time.Minute
+)
+
+// Generator produces the data for a supervoxel that has no stored data, e.g., by computing
+// a mesh from the synced labelmap after a supervoxel split.
+type Generator interface {
+	// GenerateSupervoxel returns the data for the supervoxel in the given version, where
+	// labelmap is the name of the synced labelmap instance.
+	GenerateSupervoxel(uuid dvid.UUID, labelmap dvid.InstanceName, supervoxel uint64) ([]byte, error)
+}
+
+var (
+	generatorsMu sync.RWMutex
+	generators   = make(map[string]Generator)
+)
+
+// RegisterGenerator makes a Generator available by name for the "Generator" setting of
+// tarsupervoxels instances.
+func RegisterGenerator(name string, g Generator) {
+	generatorsMu.Lock()
+	generators[name] = g
+	generatorsMu.Unlock()
+}
+
+// getGenerator returns a registered Generator or, if none has the given name, a generator
+// for the command with that name in the server configuration.  Commands are only taken
+// from the server configuration so clients can't run arbitrary commands on the server.
+func getGenerator(name string) (Generator, error) {
+	generatorsMu.RLock()
+	g, found := generators[name]
+	generatorsMu.RUnlock()
+	if found {
+		return g, nil
+	}
+	args := strings.Fields(server.GeneratorCommand(name))
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no tarsupervoxels generator %q has been registered or configured for the server", name)
+	}
+	return commandGenerator{args: args}, nil
+}
+
+// commandGenerator runs a command from the server configuration with the UUID, labelmap name, and supervoxel
+// appended as arguments and uses its standard output as the supervoxel data.
+type commandGenerator struct {
+	args []string
+}
+
+func (g commandGenerator) GenerateSupervoxel(uuid dvid.UUID, labelmap dvid.InstanceName, supervoxel uint64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), generatorCommandTimeout)
+	defer cancel()
+	args := append(g.args[1:len(g.args):len(g.args)], string(uuid), string(labelmap), strconv.FormatUint(supervoxel, 10))
+	cmd := exec.CommandContext(ctx, g.args[0], args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("generator command %q for supervoxel %d failed: %v: %s", strings.Join(g.args, " "), supervoxel, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// returns the configured generator or nil if there is none.
+func (d *Data) getGenerator() (Generator, error) {
+	if d.Generator == "" {
+		return nil, nil
+	}
+	return getGenerator(d.Generator)
+}
+
+// generateSupervoxel generates and stores the data for a supervoxel, limiting the number of
+// concurrent generations for this data instance.
+func (d *Data) generateSupervoxel(g Generator, uuid dvid.UUID, labelmap dvid.InstanceName, supervoxel uint64) ([]byte, error) {
+	d.genOnce.Do(func() {
+		d.genSem = make(chan struct{}, maxConcurrentGenerations)
+	})
+	d.genSem <- struct{}{}
+	defer func() {
+		<-d.genSem
+	}()
+
+	data, err := g.GenerateSupervoxel(uuid, labelmap, supervoxel)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	if err := d.PutData(uuid, supervoxel, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
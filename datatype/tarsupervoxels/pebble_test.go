@@ -0,0 +1,9 @@
+// +build pebble
+
+package tarsupervoxels
+
+import "testing"
+
+func TestPebbleTarballRoundTrip(t *testing.T) {
+	testTarball(t, "pebble")
+}
@@ -0,0 +1,9 @@
+// +build s3
+
+package tarsupervoxels
+
+import "testing"
+
+func TestS3TarballRoundTrip(t *testing.T) {
+	testTarball(t, "s3")
+}
@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
@@ -61,6 +62,17 @@ $ dvid repo <UUID> new tarsupervoxels <data name> <settings...>
 	data name      Name of data to create, e.g., "supervoxel-meshes"
 	settings       Configuration settings in "key=value" format separated by spaces.
 
+	Configuration Settings (case-insensitive keys):
+
+	Extension         The extension of the stored supervoxel files, e.g., "drc".  Required.
+	Generator         Optional name of a generator used to generate the data for supervoxels
+	                  without stored data when a tarfile is requested, e.g., after a supervoxel
+	                  split.  The name must be a Go generator registered with RegisterGenerator
+	                  or a command in the [generators] section of the server configuration.
+	                  A command is run with the UUID, synced labelmap name, and supervoxel id
+	                  appended as arguments, and its standard output is stored as the supervoxel
+	                  data.
+
 	
 	------------------
 
@@ -146,6 +158,26 @@ HEAD <api URL>/node/<UUID>/<data name>/tarfile/<label>
 	has been initiated, and if an error occurs during the return, there will be an ill-formed
 	tar file.  This is a tradeoff to allow streaming response.
 
+	If the instance has a generator (see the Generator setting), the data
+	for missing supervoxels is generated and stored before being added to the tarfile, so
+	the tarfile is complete unless generation fails for a supervoxel.  Generation can be
+	skipped with the query string "generate=false".
+
+	GET Query-string Options:
+
+	generate      Set to "false" to not generate missing supervoxel data.
+	format        Returns the supervoxel data in an alternative aggregate format:
+	                "tar" (default) is the tarfile described above.
+	                "zip" is a zip archive with the same file names as the tarfile.
+	                "mesh" is a single mesh merged from the supervoxel meshes with duplicate
+	                  vertices removed.  The extension of the data instance must be a
+	                  mergeable mesh format: "ngmesh", "obj", or "ply".  The merged mesh is in
+	                  the same format, except PLY meshes are returned as binary little endian.
+	                  Missing supervoxels are skipped.
+	                "ndjson" is a manifest with a JSON line for each supervoxel in order:
+	                  {"supervoxel": 18, "file": "18.drc", "size": 1823, "md5": "<hex hash>"}
+	                  {"supervoxel": 19, "file": "19.missing", "size": 0, "missing": true}
+
 	HEAD returns 200 if the body exists and all supervoxels have stored data, even if it is
 	a zero length value.  HTTP status code 400 (Bad Request) is returned if no such label 
 	exists, or one of the label's supervoxels has no associated data, or there was an error.
@@ -260,7 +292,17 @@ func (dtype *Type) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.
 	if !found {
 		return nil, fmt.Errorf("tarsupervoxels instances must have Extension set in the configuration")
 	}
-	return &Data{Data: basedata, Extension: extension}, nil
+	if _, found, _ := c.GetString("GeneratorCommand"); found {
+		return nil, fmt.Errorf("tarsupervoxels generator commands must be set in the server configuration and selected with the Generator setting")
+	}
+	data := &Data{Data: basedata, Extension: extension}
+	if data.Generator, _, err = c.GetString("Generator"); err != nil {
+		return nil, err
+	}
+	if _, err := data.getGenerator(); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 func (dtype *Type) Help() string {
@@ -293,6 +335,15 @@ type Data struct {
 	// Extension is the expected extension for blobs uploaded.
 	// If no extension is given, it is "dat" by default.
 	Extension string
+
+	// Generator is the optional name of a registered Generator or a generator command in
+	// the server configuration used to generate the data for supervoxels without stored
+	// data when sending tarfiles.
+	Generator string
+
+	// limits concurrent generation of supervoxel data.
+	genOnce sync.Once
+	genSem  chan struct{}
 }
 
 func (d *Data) getSyncedLabels() mappedLabelType {
@@ -314,6 +365,7 @@ func (d *Data) Equals(d2 *Data) bool {
 
 type propsJSON struct {
 	Extension string
+	Generator string `json:",omitempty"`
 }
 
 func (d *Data) MarshalJSON() ([]byte, error) {
@@ -324,6 +376,7 @@ func (d *Data) MarshalJSON() ([]byte, error) {
 		d.Data,
 		propsJSON{
 			Extension: d.Extension,
+			Generator: d.Generator,
 		},
 	})
 }
@@ -337,6 +390,10 @@ func (d *Data) GobDecode(b []byte) error {
 	if err := dec.Decode(&(d.Extension)); err != nil {
 		return fmt.Errorf("decoding tarsupervoxels %q: no Extension", d.DataName())
 	}
+	// Instances stored before generators were added have no generator setting.
+	if err := dec.Decode(&(d.Generator)); err != nil && err != io.EOF {
+		return err
+	}
 	return nil
 }
 
@@ -349,6 +406,9 @@ func (d *Data) GobEncode() ([]byte, error) {
 	if err := enc.Encode(d.Extension); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(d.Generator); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -432,12 +492,21 @@ func (d *Data) JSONString() (jsonStr string, err error) {
 }
 
 type fileData struct {
-	header *tar.Header
-	data   []byte
-	err    error
+	supervoxel uint64
+	missing    bool
+	header     *tar.Header
+	data       []byte
+	err        error
 }
 
-func (d *Data) getSupervoxelGoroutine(db storage.KeyValueDB, ctx *datastore.VersionedCtx, supervoxels []uint64, outCh chan fileData, done <-chan struct{}) {
+// genRequest holds what's needed to generate missing supervoxel data, if possible.
+type genRequest struct {
+	generator Generator
+	uuid      dvid.UUID
+	labelmap  dvid.InstanceName
+}
+
+func (d *Data) getSupervoxelGoroutine(db storage.KeyValueDB, ctx *datastore.VersionedCtx, supervoxels []uint64, gen *genRequest, outCh chan fileData, done <-chan struct{}) {
 	dbt, canGetTimestamp := db.(storage.KeyValueTimestampGetter)
 	for _, supervoxel := range supervoxels {
 		tk, err := NewTKey(supervoxel, d.Extension)
@@ -458,6 +527,13 @@ func (d *Data) getSupervoxelGoroutine(db storage.KeyValueDB, ctx *datastore.Vers
 			outCh <- fileData{err: err}
 			continue
 		}
+		if data == nil && gen != nil {
+			if data, err = d.generateSupervoxel(gen.generator, gen.uuid, gen.labelmap, supervoxel); err != nil {
+				dvid.Errorf("unable to generate supervoxel %d data for %q: %v\n", supervoxel, d.DataName(), err)
+			} else {
+				modTime = time.Now()
+			}
+		}
 		var ext string
 		if data == nil {
 			ext = "missing"
@@ -471,7 +547,7 @@ func (d *Data) getSupervoxelGoroutine(db storage.KeyValueDB, ctx *datastore.Vers
 			ModTime: modTime,
 		}
 		select {
-		case outCh <- fileData{header: hdr, data: data}:
+		case outCh <- fileData{supervoxel: supervoxel, missing: data == nil, header: hdr, data: data}:
 		case <-done:
 		}
 	}
@@ -643,7 +719,9 @@ func (d *Data) checkTarfile(w http.ResponseWriter, uuid dvid.UUID, label uint64)
 	return nil
 }
 
-func (d *Data) sendTarfile(w http.ResponseWriter, uuid dvid.UUID, label uint64) error {
+// processSupervoxelFiles calls f, in no particular order, with the file for each supervoxel
+// of a label, generating the data for missing supervoxels if requested and possible.
+func (d *Data) processSupervoxelFiles(uuid dvid.UUID, label uint64, generate bool, f func(fileData) error) error {
 	db, err := datastore.GetKeyValueDB(d)
 	if err != nil {
 		return err
@@ -667,6 +745,16 @@ func (d *Data) sendTarfile(w http.ResponseWriter, uuid dvid.UUID, label uint64)
 	if len(supervoxels) == 0 {
 		return fmt.Errorf("label %d has no supervoxels", label)
 	}
+	var gen *genRequest
+	if generate {
+		generator, err := d.getGenerator()
+		if err != nil {
+			return err
+		}
+		if generator != nil {
+			gen = &genRequest{generator: generator, uuid: uuid, labelmap: ldata.DataName()}
+		}
+	}
 	numHandlers := 256 // Must be less than max open files, probably equal to multiple of disk queue
 	svlist := make(map[int][]uint64, len(supervoxels))
 	i := 0
@@ -682,22 +770,16 @@ func (d *Data) sendTarfile(w http.ResponseWriter, uuid dvid.UUID, label uint64)
 	defer close(done)
 	outCh := make(chan fileData, len(supervoxels))
 	for i := 0; i < numHandlers; i++ {
-		go d.getSupervoxelGoroutine(db, ctx, svlist[i], outCh, done)
+		go d.getSupervoxelGoroutine(db, ctx, svlist[i], gen, outCh, done)
 	}
 
-	w.Header().Set("Content-type", "application/tar")
-	tw := tar.NewWriter(w)
-	defer tw.Close()
 	for i := 0; i < len(supervoxels); i++ {
 		fd := <-outCh
 		if fd.err != nil {
 			return fd.err
 		}
 		if fd.header != nil {
-			if err := tw.WriteHeader(fd.header); err != nil {
-				return err
-			}
-			if _, err := tw.Write(fd.data); err != nil {
+			if err := f(fd); err != nil {
 				return err
 			}
 		}
@@ -705,6 +787,27 @@ func (d *Data) sendTarfile(w http.ResponseWriter, uuid dvid.UUID, label uint64)
 	return nil
 }
 
+func (d *Data) sendTarfile(w http.ResponseWriter, uuid dvid.UUID, label uint64, generate bool) error {
+	var tw *tar.Writer
+	err := d.processSupervoxelFiles(uuid, label, generate, func(fd fileData) error {
+		if tw == nil {
+			w.Header().Set("Content-type", "application/tar")
+			tw = tar.NewWriter(w)
+		}
+		if err := tw.WriteHeader(fd.header); err != nil {
+			return err
+		}
+		_, err := tw.Write(fd.data)
+		return err
+	})
+	if tw != nil {
+		if closeErr := tw.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
 func (d *Data) ingestTarfile(r *http.Request, uuid dvid.UUID) error {
 	db, err := datastore.GetKeyValueDB(d)
 	if err != nil {
@@ -861,7 +964,17 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		}
 		switch action {
 		case "get":
-			if err := d.sendTarfile(w, uuid, label); err != nil {
+			generate := r.URL.Query().Get("generate") != "false"
+			format := r.URL.Query().Get("format")
+			if format != "" && format != "tar" {
+				if err := d.sendAggregate(w, uuid, label, generate, format); err != nil {
+					server.BadRequest(w, r, "can't send %s for label %d: %v", format, label, err)
+					return
+				}
+				comment = fmt.Sprintf("HTTP GET tarfile as %s on data %q, label %d", format, d.DataName(), label)
+				break
+			}
+			if err := d.sendTarfile(w, uuid, label, generate); err != nil {
 				server.BadRequest(w, r, "can't send tarfile for label %d: %v", label, err)
 				return
 			}
@@ -2,17 +2,22 @@ package tarsupervoxels
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"crypto/md5"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"reflect"
 	"sync"
 	"testing"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/datatype/common/mesh"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/server"
 	"github.com/janelia-flyem/dvid/storage"
@@ -219,3 +224,243 @@ func testTarball(t *testing.T, storetype storage.Alias) {
 	apiStr = fmt.Sprintf("%snode/%s/%s/tarfile/30", server.WebAPIPath, uuid, tarsvname)
 	server.TestHTTP(t, "HEAD", apiStr, nil) // now has every supervoxel including 15
 }
+
+type testGenerator struct{}
+
+func (g testGenerator) GenerateSupervoxel(uuid dvid.UUID, labelmap dvid.InstanceName, supervoxel uint64) ([]byte, error) {
+	return []byte(fmt.Sprintf("generated %d from %s", supervoxel, labelmap)), nil
+}
+
+// returns the tarfile contents for a label as a map of file names to data.
+func getTarfile(t *testing.T, uuid dvid.UUID, name string, label uint64, query string) map[string]string {
+	apiStr := fmt.Sprintf("%snode/%s/%s/tarfile/%d%s", server.WebAPIPath, uuid, name, label, query)
+	tr := tar.NewReader(bytes.NewBuffer(server.TestHTTP(t, "GET", apiStr, nil)))
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error parsing tar: %v\n", err)
+		}
+		var svdata bytes.Buffer
+		if _, err := io.Copy(&svdata, tr); err != nil {
+			t.Fatalf("error reading tar data: %v\n", err)
+		}
+		files[hdr.Name] = svdata.String()
+	}
+	return files
+}
+
+// puts supervoxels 1-4 into the labelmap "labels" and merges them into body 1.
+func createMergedBody(t *testing.T, uuid dvid.UUID) {
+	n := 64
+	voxels := make([]byte, n*n*n*8)
+	for i := 0; i < n*n*n; i++ {
+		binary.LittleEndian.PutUint64(voxels[i*8:i*8+8], uint64((i%4)+1))
+	}
+	apiStr := fmt.Sprintf("%snode/%s/labels/raw/0_1_2/%d_%d_%d/0_0_0", server.WebAPIPath, uuid, n, n, n)
+	server.TestHTTP(t, "POST", apiStr, bytes.NewBuffer(voxels))
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	apiStr = fmt.Sprintf("%snode/%s/labels/merge", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", apiStr, bytes.NewBufferString("[1, 2, 3, 4]"))
+}
+
+func TestGenerator(t *testing.T) {
+	if err := server.OpenTest(server.TestConfig{Generators: map[string]string{"echo": "echo mesh"}}); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	RegisterGenerator("test", testGenerator{})
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "labelmap", "labels", config)
+	config.Set("Extension", "dat")
+	config.Set("Generator", "test")
+	server.CreateTestInstance(t, uuid, "tarsupervoxels", "meshes", config)
+	server.CreateTestSync(t, uuid, "meshes", "labels")
+	config.Clear()
+	config.Set("Extension", "txt")
+	config.Set("Generator", "echo")
+	server.CreateTestInstance(t, uuid, "tarsupervoxels", "cmdmeshes", config)
+	server.CreateTestSync(t, uuid, "cmdmeshes", "labels")
+	config.Clear()
+	config.Set("Extension", "dat")
+	server.CreateTestInstance(t, uuid, "tarsupervoxels", "plain", config)
+
+	// Clients can only select generators configured on the server.
+	for setting, value := range map[string]string{"GeneratorCommand": "echo mesh", "Generator": "unknown"} {
+		config.Clear()
+		config.Set("typename", "tarsupervoxels")
+		config.Set("dataname", "badgen")
+		config.Set("Extension", "txt")
+		config.Set(setting, value)
+		jsonData, err := config.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		apiStr := fmt.Sprintf("%srepo/%s/instance", server.WebAPIPath, uuid)
+		server.TestBadHTTP(t, "POST", apiStr, bytes.NewBuffer(jsonData))
+	}
+	server.CreateTestSync(t, uuid, "plain", "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	createMergedBody(t, uuid)
+
+	for _, name := range []string{"meshes", "plain"} {
+		apiStr := fmt.Sprintf("%snode/%s/%s/supervoxel/2", server.WebAPIPath, uuid, name)
+		server.TestHTTP(t, "POST", apiStr, bytes.NewBufferString("stored 2"))
+	}
+
+	// Without a generator, missing supervoxels have placeholders.
+	files := getTarfile(t, uuid, "plain", 1, "")
+	if len(files) != 4 || files["2.dat"] != "stored 2" {
+		t.Fatalf("bad tarfile without generator: %v\n", files)
+	}
+	if _, found := files["3.missing"]; !found {
+		t.Fatalf("expected placeholder for supervoxel 3 without generator: %v\n", files)
+	}
+
+	// Skipping generation should also give placeholders.
+	files = getTarfile(t, uuid, "meshes", 1, "?generate=false")
+	if _, found := files["3.missing"]; !found || len(files) != 4 {
+		t.Fatalf("expected placeholder for supervoxel 3 when not generating: %v\n", files)
+	}
+
+	files = getTarfile(t, uuid, "meshes", 1, "")
+	expected := map[string]string{
+		"1.dat": "generated 1 from labels",
+		"2.dat": "stored 2",
+		"3.dat": "generated 3 from labels",
+		"4.dat": "generated 4 from labels",
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("expected tarfile %v, got %v\n", expected, files)
+	}
+	for filename, data := range expected {
+		if files[filename] != data {
+			t.Fatalf("expected tarfile %v, got %v\n", expected, files)
+		}
+	}
+
+	// Generated data should now be stored.
+	apiStr := fmt.Sprintf("%snode/%s/meshes/missing/1", server.WebAPIPath, uuid)
+	if missing := string(server.TestHTTP(t, "GET", apiStr, nil)); missing != "[]" {
+		t.Fatalf("expected no missing supervoxels after generation, got %s\n", missing)
+	}
+	apiStr = fmt.Sprintf("%snode/%s/meshes/tarfile/1", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "HEAD", apiStr, nil)
+
+	files = getTarfile(t, uuid, "cmdmeshes", 1, "")
+	if files["3.txt"] != fmt.Sprintf("mesh %s labels 3\n", uuid) || len(files) != 4 {
+		t.Fatalf("bad tarfile from generator command: %v\n", files)
+	}
+}
+
+// returns an ngmesh with the given vertex coordinates and triangle vertex indices.
+func makeNgmesh(t *testing.T, vertices []float32, triangles []uint32) []byte {
+	var buf bytes.Buffer
+	m := &mesh.Mesh{Vertices: vertices, Triangles: triangles}
+	if err := m.Write(&buf, mesh.FormatNgmesh); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAggregateFormats(t *testing.T) {
+	if err := server.OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer server.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "labelmap", "labels", config)
+	config.Set("Extension", "ngmesh")
+	server.CreateTestInstance(t, uuid, "tarsupervoxels", "meshes", config)
+	server.CreateTestSync(t, uuid, "meshes", "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	createMergedBody(t, uuid)
+
+	meshes := map[uint64][]byte{
+		1: makeNgmesh(t, []float32{0, 0, 0, 1, 0, 0, 0, 1, 0}, []uint32{0, 1, 2}),
+		2: makeNgmesh(t, []float32{1, 1, 0, 0, 1, 0, 1, 0, 0}, []uint32{2, 0, 1}),
+		4: makeNgmesh(t, []float32{0, 1, 0, 1, 1, 0}, nil),
+	}
+	for supervoxel, data := range meshes {
+		apiStr := fmt.Sprintf("%snode/%s/meshes/supervoxel/%d", server.WebAPIPath, uuid, supervoxel)
+		server.TestHTTP(t, "POST", apiStr, bytes.NewBuffer(data))
+	}
+	tarfileURL := fmt.Sprintf("%snode/%s/meshes/tarfile/1", server.WebAPIPath, uuid)
+
+	// zip archive
+	data := server.TestHTTP(t, "GET", tarfileURL+"?format=zip", nil)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("bad zip archive: %v\n", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("can't open zip file %s: %v\n", f.Name, err)
+		}
+		if files[f.Name], err = ioutil.ReadAll(rc); err != nil {
+			t.Fatalf("can't read zip file %s: %v\n", f.Name, err)
+		}
+		rc.Close()
+	}
+	if len(files) != 4 || !bytes.Equal(files["1.ngmesh"], meshes[1]) || !bytes.Equal(files["4.ngmesh"], meshes[4]) {
+		t.Fatalf("bad zip archive files: %v\n", files)
+	}
+	if _, found := files["3.missing"]; !found {
+		t.Fatalf("expected placeholder for supervoxel 3 in zip archive\n")
+	}
+
+	// ndjson manifest
+	data = server.TestHTTP(t, "GET", tarfileURL+"?format=ndjson", nil)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var entries []manifestEntry
+	for dec.More() {
+		var entry manifestEntry
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatalf("bad manifest line: %v\n", err)
+		}
+		entries = append(entries, entry)
+	}
+	expected := []manifestEntry{
+		{Supervoxel: 1, File: "1.ngmesh", Size: len(meshes[1]), MD5: fmt.Sprintf("%x", md5.Sum(meshes[1]))},
+		{Supervoxel: 2, File: "2.ngmesh", Size: len(meshes[2]), MD5: fmt.Sprintf("%x", md5.Sum(meshes[2]))},
+		{Supervoxel: 3, File: "3.missing", Missing: true},
+		{Supervoxel: 4, File: "4.ngmesh", Size: len(meshes[4]), MD5: fmt.Sprintf("%x", md5.Sum(meshes[4]))},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected manifest %v, got %v\n", expected, entries)
+	}
+
+	// merged mesh
+	data = server.TestHTTP(t, "GET", tarfileURL+"?format=mesh", nil)
+	merged, err := mesh.Read(data, mesh.FormatNgmesh)
+	if err != nil {
+		t.Fatalf("bad merged mesh: %v\n", err)
+	}
+	expectedMesh := &mesh.Mesh{
+		Vertices:  []float32{0, 0, 0, 1, 0, 0, 0, 1, 0, 1, 1, 0},
+		Triangles: []uint32{0, 1, 2, 1, 3, 2},
+	}
+	if !reflect.DeepEqual(merged, expectedMesh) {
+		t.Fatalf("expected merged mesh %v, got %v\n", expectedMesh, merged)
+	}
+
+	server.TestBadHTTP(t, "GET", tarfileURL+"?format=rar", nil)
+}
@@ -16,6 +16,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -107,6 +108,37 @@ func GetModInfo(r *http.Request) ModInfo {
 	return info
 }
 
+// AsOf specifies a point within the history of a version, either just after a given
+// mutation ID or at a given time.  If Time is zero, MutID is used.
+type AsOf struct {
+	MutID uint64
+	Time  time.Time
+}
+
+func (a AsOf) String() string {
+	if a.Time.IsZero() {
+		return fmt.Sprintf("mutation %d", a.MutID)
+	}
+	return a.Time.Format(time.RFC3339Nano)
+}
+
+// GetAsOf returns the point in time given by an "as_of" query string, which is either
+// a mutation ID or an RFC3339 timestamp.  A nil AsOf is returned if no "as_of" was given.
+func GetAsOf(r *http.Request) (*AsOf, error) {
+	s := r.URL.Query().Get("as_of")
+	if s == "" {
+		return nil, nil
+	}
+	if mutID, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return &AsOf{MutID: mutID}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, fmt.Errorf("as_of %q must be a mutation ID or RFC3339 timestamp", s)
+	}
+	return &AsOf{Time: t}, nil
+}
+
 // RandomBytes returns a slices of random bytes.
 func RandomBytes(numBytes int32) []byte {
 	buf := make([]byte, numBytes)
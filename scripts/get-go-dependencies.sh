@@ -122,12 +122,18 @@ go get github.com/pkg/errors
 go get golang.org/x/sys/unix
 go get github.com/dustin/go-humanize
 
+# pebble (pure-Go alternative to basholeveldb)
+go get github.com/cockroachdb/pebble
+
 # freecache
 go get github.com/coocood/freecache
 
 # Openstack Swift
 go get github.com/ncw/swift
 
+# S3-compatible object stores, e.g., MinIO
+go get github.com/minio/minio-go
+
 # kafka
 CONFLUENTINC_DIR=${GOPATH}/src/github.com/confluentinc
 KAFKA_GO_DIR=${CONFLUENTINC_DIR}/confluent-kafka-go
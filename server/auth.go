@@ -0,0 +1,317 @@
+/*
+	This file supports bearer token authentication and per-repo access control for
+	the HTTP API.  Tokens are JWTs signed with a shared HMAC secret (HS256) so they
+	can be verified without contacting an external service.
+*/
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+
+	"github.com/zenazn/goji/web"
+)
+
+// authRole is the level of access granted to a user.  Each role includes the
+// access of the roles below it.
+type authRole uint8
+
+const (
+	roleNone authRole = iota
+	roleRead
+	roleWrite
+	roleAdmin
+)
+
+func (role authRole) String() string {
+	switch role {
+	case roleRead:
+		return "read"
+	case roleWrite:
+		return "write"
+	case roleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+func parseAuthRole(s string) (authRole, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return roleNone, nil
+	case "read":
+		return roleRead, nil
+	case "write":
+		return roleWrite, nil
+	case "admin":
+		return roleAdmin, nil
+	default:
+		return roleNone, fmt.Errorf("unknown role %q, must be one of none, read, write, or admin", s)
+	}
+}
+
+// AuthConfig holds the [auth] settings of the TOML configuration.  If neither a
+// secret nor a secret file is given, authentication is disabled and all requests
+// are allowed subject to the read-only and full-write server modes.
+type AuthConfig struct {
+	Secret     string      // shared HMAC secret used to verify HS256-signed tokens
+	SecretFile string      // file holding the shared secret if Secret isn't given
+	Anonymous  string      // role for requests without a token; defaults to "none"
+	Grant      []AuthGrant // roles for authenticated users
+}
+
+// AuthGrant gives a role to a user for a repo or a data instance within a repo.
+type AuthGrant struct {
+	User     string // "sub" claim of the token or "*" for any authenticated user
+	Repo     string // UUID of any version in the repo or "*" for all repos and server-wide requests
+	Instance string // data instance name or empty for all instances in the repo
+	Role     string // one of "read", "write", or "admin"
+}
+
+// Enabled returns true if authentication is required for the HTTP API.
+func (ac AuthConfig) Enabled() bool {
+	return ac.Secret != "" || ac.SecretFile != ""
+}
+
+// returns the secret used to verify tokens.
+func (ac AuthConfig) secret() ([]byte, error) {
+	if ac.Secret != "" {
+		return []byte(ac.Secret), nil
+	}
+	data, err := ioutil.ReadFile(ac.SecretFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read auth secret file %q: %v", ac.SecretFile, err)
+	}
+	return []byte(strings.TrimSpace(string(data))), nil
+}
+
+// role returns the highest role granted to the user for the given repo and data instance.
+// A nil repo root denotes a server-wide request, which only matches grants for all repos.
+func (ac AuthConfig) role(user string, repoRoot dvid.UUID, instance dvid.InstanceName) (authRole, error) {
+	role, err := parseAuthRole(ac.Anonymous)
+	if err != nil {
+		return roleNone, err
+	}
+	if user == "" {
+		return role, nil
+	}
+	for _, grant := range ac.Grant {
+		if grant.User != "*" && grant.User != user {
+			continue
+		}
+		if grant.Repo != "*" {
+			if repoRoot == dvid.NilUUID {
+				continue
+			}
+			uuid, _, err := datastore.MatchingUUID(grant.Repo)
+			if err != nil {
+				dvid.Errorf("ignoring auth grant for user %q with bad repo %q: %v\n", grant.User, grant.Repo, err)
+				continue
+			}
+			grantRoot, err := datastore.GetRepoRoot(uuid)
+			if err != nil || grantRoot != repoRoot {
+				continue
+			}
+		}
+		if grant.Instance != "" && dvid.InstanceName(grant.Instance) != instance {
+			continue
+		}
+		grantRole, err := parseAuthRole(grant.Role)
+		if err != nil {
+			return roleNone, fmt.Errorf("bad auth grant for user %q: %v", grant.User, err)
+		}
+		if grantRole > role {
+			role = grantRole
+		}
+	}
+	return role, nil
+}
+
+// tokenClaims are the JWT claims used by DVID.
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+}
+
+// verifyToken checks the signature and time limits of a JWT signed with HS256 and
+// returns the user given by its "sub" claim.
+func verifyToken(token string, secret []byte) (user string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed token")
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("malformed token header: %v", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return "", fmt.Errorf("malformed token header: %v", err)
+	}
+	if header.Alg != "HS256" {
+		return "", fmt.Errorf("token signing algorithm must be HS256, not %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed token signature: %v", err)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", fmt.Errorf("token signature is invalid")
+	}
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed token claims: %v", err)
+	}
+	var claims tokenClaims
+	if err = json.Unmarshal(claimsBytes, &claims); err != nil {
+		return "", fmt.Errorf("malformed token claims: %v", err)
+	}
+	now := time.Now().Unix()
+	if claims.ExpiresAt != 0 && now >= claims.ExpiresAt {
+		return "", fmt.Errorf("token has expired")
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return "", fmt.Errorf("token is not valid yet")
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("token has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// newToken returns a JWT for the given user signed with HS256 using the secret.
+// If expiration is non-zero, the token will expire after that duration.
+func newToken(user string, secret []byte, expiration time.Duration) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims := tokenClaims{Subject: user}
+	if expiration != 0 {
+		claims.ExpiresAt = time.Now().Add(expiration).Unix()
+	}
+	claimsBytes, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := header + "." + base64.RawURLEncoding.EncodeToString(claimsBytes)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// authorize checks the request's bearer token against the role needed for the given
+// repo (nil UUID for server-wide requests) and data instance.  If access is denied,
+// an error status is written and false is returned.  The authenticated user, if any,
+// is also returned for activity logging.
+func authorize(w http.ResponseWriter, r *http.Request, needed authRole, uuid dvid.UUID, instance dvid.InstanceName) (user string, ok bool) {
+	ac := AuthSpec()
+	if !ac.Enabled() {
+		return "", true
+	}
+	authHeader := r.Header.Get("Authorization")
+	if authHeader != "" {
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			http.Error(w, "Authorization header must use Bearer scheme", http.StatusUnauthorized)
+			return "", false
+		}
+		secret, err := ac.secret()
+		if err != nil {
+			dvid.Errorf("%v\n", err)
+			http.Error(w, "unable to verify token", http.StatusInternalServerError)
+			return "", false
+		}
+		if user, err = verifyToken(strings.TrimPrefix(authHeader, "Bearer "), secret); err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, fmt.Sprintf("bad bearer token: %v", err), http.StatusUnauthorized)
+			return "", false
+		}
+	}
+	var repoRoot dvid.UUID
+	if uuid != dvid.NilUUID {
+		var err error
+		if repoRoot, err = datastore.GetRepoRoot(uuid); err != nil {
+			BadRequest(w, r, err)
+			return user, false
+		}
+	}
+	role, err := ac.role(user, repoRoot, instance)
+	if err != nil {
+		dvid.Errorf("%v\n", err)
+		http.Error(w, "bad auth configuration", http.StatusInternalServerError)
+		return user, false
+	}
+	if role < needed {
+		if user == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, fmt.Sprintf("%s access requires a bearer token (%s)", needed, r.URL.Path), http.StatusUnauthorized)
+		} else {
+			http.Error(w, fmt.Sprintf("user %q does not have %s access (%s)", user, needed, r.URL.Path), http.StatusForbidden)
+		}
+		dvid.Infof("denied %s access to user %q for %s %s\n", needed, user, r.Method, r.URL.Path)
+		return user, false
+	}
+	return user, true
+}
+
+// returns the role needed for a request where GET and HEAD only require read access.
+func neededRole(r *http.Request, admin bool) authRole {
+	switch strings.ToLower(r.Method) {
+	case "get", "head", "options":
+		return roleRead
+	}
+	if admin {
+		return roleAdmin
+	}
+	return roleWrite
+}
+
+// authHandler returns middleware that enforces access control using any repo and
+// data instance identified by earlier middleware.  Requests that mutate using one of
+// the given actions require an admin role.  The authenticated user is stored as
+// "authUser" in the environment.
+func authHandler(adminActions ...string) func(*web.C, http.Handler) http.Handler {
+	return func(c *web.C, h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			var admin bool
+			for _, action := range adminActions {
+				if c.URLParams["action"] == action {
+					admin = true
+				}
+			}
+			uuid, _ := c.Env["uuid"].(dvid.UUID)
+			instance := dvid.InstanceName(c.URLParams["dataname"])
+			user, ok := authorize(w, r, neededRole(r, admin), uuid, instance)
+			if !ok {
+				return
+			}
+			c.Env["authUser"] = user
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// authServerHandler wraps a handler for server-wide requests outside of the muxes
+// that use authHandler middleware.
+func authServerHandler(admin bool, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authorize(w, r, neededRole(r, admin), dvid.NilUUID, ""); ok {
+			h(w, r)
+		}
+	}
+}
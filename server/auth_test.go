@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestVerifyToken(t *testing.T) {
+	secret := []byte("my secret")
+	token, err := newToken("jdoe", secret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := verifyToken(token, secret)
+	if err != nil {
+		t.Fatalf("unable to verify good token: %v\n", err)
+	}
+	if user != "jdoe" {
+		t.Errorf("expected user jdoe from token, got %q\n", user)
+	}
+	if _, err = verifyToken(token, []byte("other secret")); err == nil {
+		t.Errorf("expected token signed with other secret to fail verification\n")
+	}
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + parts[1] + "x." + parts[2]
+	if _, err = verifyToken(tampered, secret); err == nil {
+		t.Errorf("expected tampered token to fail verification\n")
+	}
+	expired, err := newToken("jdoe", secret, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verifyToken(expired, secret); err == nil {
+		t.Errorf("expected expired token to fail verification\n")
+	}
+}
+
+// authHTTP returns the status code of a request made with the given bearer token.
+func authHTTP(t *testing.T, method, urlStr, token, payload string) int {
+	req, err := http.NewRequest(method, urlStr, bytes.NewBufferString(payload))
+	if err != nil {
+		t.Fatalf("Unsuccessful %s on %q: %v\n", method, urlStr, err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	ServeSingleHTTP(resp, req)
+	return resp.Code
+}
+
+func TestAuthRoles(t *testing.T) {
+	if err := OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+	otherUUID, _ := datastore.NewTestRepo()
+
+	secret := "test secret"
+	tc.Auth = AuthConfig{
+		Secret: secret,
+		Grant: []AuthGrant{
+			{User: "admin", Repo: "*", Role: "admin"},
+			{User: "reader", Repo: string(uuid), Role: "read"},
+			{User: "writer", Repo: string(uuid), Role: "write"},
+		},
+	}
+	defer func() {
+		tc.Auth = AuthConfig{}
+	}()
+	tokens := make(map[string]string)
+	for _, user := range []string{"admin", "reader", "writer"} {
+		token, err := newToken(user, []byte(secret), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[user] = token
+	}
+
+	noteURL := fmt.Sprintf("%snode/%s/note", WebAPIPath, uuid)
+	otherNoteURL := fmt.Sprintf("%snode/%s/note", WebAPIPath, otherUUID)
+	notePayload := `{"note": "restricted"}`
+	instanceURL := fmt.Sprintf("%srepo/%s/instance", WebAPIPath, uuid)
+	instancePayload := `{"typename": "keyvalue", "dataname": "kv"}`
+
+	tests := []struct {
+		method, url, user, payload string
+		status                     int
+	}{
+		{"GET", noteURL, "", "", http.StatusUnauthorized},
+		{"GET", noteURL, "reader", "", http.StatusOK},
+		{"POST", noteURL, "reader", notePayload, http.StatusForbidden},
+		{"POST", noteURL, "writer", notePayload, http.StatusOK},
+		{"GET", otherNoteURL, "writer", "", http.StatusForbidden},
+		{"GET", otherNoteURL, "admin", "", http.StatusOK},
+		{"POST", instanceURL, "writer", instancePayload, http.StatusForbidden},
+		{"POST", instanceURL, "admin", instancePayload, http.StatusBadRequest}, // no datatypes compiled into server tests
+		{"GET", WebAPIPath + "repos/info", "reader", "", http.StatusForbidden},
+		{"GET", WebAPIPath + "repos/info", "admin", "", http.StatusOK},
+		{"GET", WebAPIPath + "storage", "", "", http.StatusUnauthorized},
+		{"GET", WebAPIPath + "storage", "admin", "", http.StatusOK},
+	}
+	for i, test := range tests {
+		status := authHTTP(t, test.method, test.url, tokens[test.user], test.payload)
+		if status != test.status {
+			t.Errorf("test %d: expected status %d for %s %s by user %q, got %d\n", i, test.status, test.method, test.url, test.user, status)
+		}
+	}
+
+	// Instance-level grants and anonymous access.
+	tc.Auth.Anonymous = "read"
+	tc.Auth.Grant = append(tc.Auth.Grant, AuthGrant{User: "reader", Repo: string(uuid), Instance: "kv", Role: "write"})
+	if status := authHTTP(t, "GET", noteURL, "", ""); status != http.StatusOK {
+		t.Errorf("expected anonymous read to succeed, got status %d\n", status)
+	}
+	if status := authHTTP(t, "GET", noteURL, "bad.token.here", ""); status != http.StatusUnauthorized {
+		t.Errorf("expected bad token to be unauthorized, got status %d\n", status)
+	}
+	kvURL := fmt.Sprintf("%snode/%s/kv/key/foo", WebAPIPath, uuid)
+	// Without a keyvalue datatype in server tests, passing authorization yields a bad request.
+	if status := authHTTP(t, "POST", kvURL, tokens["reader"], "bar"); status != http.StatusBadRequest {
+		t.Errorf("expected instance-level write grant to allow POST, got status %d\n", status)
+	}
+	if status := authHTTP(t, "POST", noteURL, tokens["reader"], notePayload); status != http.StatusForbidden {
+		t.Errorf("expected instance-level grant not to apply to node note, got status %d\n", status)
+	}
+
+	role, err := tc.Auth.role("reader", dvid.NilUUID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != roleRead {
+		t.Errorf("expected anonymous read role for server-wide request by reader, got %s\n", role)
+	}
+}
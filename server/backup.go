@@ -0,0 +1,70 @@
+/*
+	This file supports online backups of this server's stores triggered via HTTP or RPC.
+	Backup points are written by the datastore package and restored offline with the
+	"dvid restore" command.
+*/
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// BackupConfig specifies the directory for online backups.
+type BackupConfig struct {
+	Dir string // directory holding a series of backup points
+}
+
+func backupDir(dir string) (string, error) {
+	if dir == "" {
+		dir = tc.Backup.Dir
+	}
+	if dir == "" {
+		return "", fmt.Errorf("no backup directory given or set in [backup] configuration")
+	}
+	return dir, nil
+}
+
+// StartBackup snapshots all stores and writes a new backup point in the background,
+// returning the ID of the point.  The point is incremental unless full is true or the
+// backup directory has no earlier points.  If dir is empty, the configured directory
+// is used.
+func StartBackup(dir string, full bool) (uint64, error) {
+	dir, err := backupDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	b, err := datastore.NewBackup(dir, full)
+	if err != nil {
+		return 0, err
+	}
+	go func() {
+		if _, err := b.Write(); err != nil {
+			dvid.Errorf("Backup point %d in %s failed: %v\n", b.ID(), dir, err)
+		}
+	}()
+	return b.ID(), nil
+}
+
+// BackupInfo describes the status of backups and the points in a backup directory.
+type BackupInfo struct {
+	Status datastore.BackupStatus
+	Points []*datastore.BackupManifest
+}
+
+// GetBackupInfo returns the backup status and the points in the given backup directory,
+// or the configured directory if dir is empty.
+func GetBackupInfo(dir string) (*BackupInfo, error) {
+	dir, err := backupDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	points, err := datastore.BackupPoints(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &BackupInfo{Status: datastore.GetBackupStatus(), Points: points}, nil
+}
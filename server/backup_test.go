@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+)
+
+func TestBackupHTTP(t *testing.T) {
+	if err := OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer CloseTest()
+
+	backupStr := fmt.Sprintf("%sserver/backup", WebAPIPath)
+	oldDir := tc.Backup.Dir
+	tc.Backup.Dir = ""
+	defer func() { tc.Backup.Dir = oldDir }()
+	if resp := TestHTTPResponse(t, "POST", backupStr, nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request for backup without directory, got status %d\n", resp.Code)
+	}
+
+	dir, err := ioutil.TempDir("", "dvid-backup-http-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tc.Backup.Dir = dir
+
+	datastore.NewTestRepo()
+	var started struct{ ID uint64 }
+	if err := json.Unmarshal(TestHTTP(t, "POST", backupStr+"?full=true", nil), &started); err != nil {
+		t.Fatal(err)
+	}
+	var info BackupInfo
+	for i := 0; i < 100; i++ {
+		if err := json.Unmarshal(TestHTTP(t, "GET", backupStr, nil), &info); err != nil {
+			t.Fatal(err)
+		}
+		if !info.Status.Running {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if info.Status.Running || info.Status.LastError != "" {
+		t.Fatalf("expected finished backup, got status %+v\n", info.Status)
+	}
+	if len(info.Points) != 1 || info.Points[0].ID != started.ID || info.Points[0].Parent != 0 {
+		t.Fatalf("expected one full backup point %d, got %v\n", started.ID, info.Points)
+	}
+	if len(info.Points[0].Stores) == 0 || info.Points[0].Stores[0].KeyValues == 0 {
+		t.Errorf("expected backed up key-values, got %v\n", info.Points[0].Stores)
+	}
+}
@@ -0,0 +1,251 @@
+/*
+	This file supports streaming the change feed of a data instance over Server-Sent
+	Events or a WebSocket.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+
+	"golang.org/x/net/websocket"
+)
+
+// EventKeepAlive is the interval between keep-alive comments on Server-Sent Event streams.
+var EventKeepAlive = 30 * time.Second
+
+// eventFilter selects the feed events sent to a client.
+type eventFilter struct {
+	uuid    dvid.UUID // if non-empty, only events for this version are sent
+	labels  map[uint64]struct{}
+	actions map[string]struct{}
+}
+
+func parseEventFilter(r *http.Request, uuid dvid.UUID) (filter eventFilter, err error) {
+	queryStrings := r.URL.Query()
+	if queryStrings.Get("allversions") != "true" {
+		filter.uuid = uuid
+	}
+	if labelStr := queryStrings.Get("label"); labelStr != "" {
+		filter.labels = make(map[uint64]struct{})
+		for _, s := range strings.Split(labelStr, ",") {
+			var label uint64
+			if label, err = strconv.ParseUint(s, 10, 64); err != nil {
+				err = fmt.Errorf("bad label %q in events filter: %v", s, err)
+				return
+			}
+			filter.labels[label] = struct{}{}
+		}
+	}
+	if actionStr := queryStrings.Get("action"); actionStr != "" {
+		filter.actions = make(map[string]struct{})
+		for _, action := range strings.Split(actionStr, ",") {
+			filter.actions[action] = struct{}{}
+		}
+	}
+	return
+}
+
+func (filter eventFilter) matches(evt *datastore.MutationEvent) bool {
+	if filter.uuid != "" && evt.UUID != "" && evt.UUID != filter.uuid {
+		return false
+	}
+	if filter.actions != nil {
+		if _, found := filter.actions[evt.Action]; !found {
+			return false
+		}
+	}
+	if filter.labels != nil {
+		for _, label := range evt.Labels {
+			if _, found := filter.labels[label]; found {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// returns the cursor given by the "cursor" query string or the Last-Event-ID header
+// sent by reconnecting Server-Sent Event clients.
+func parseEventCursor(r *http.Request) (cursor uint64, hasCursor bool, err error) {
+	cursorStr := r.URL.Query().Get("cursor")
+	if cursorStr == "" {
+		cursorStr = r.Header.Get("Last-Event-ID")
+	}
+	if cursorStr == "" {
+		return 0, false, nil
+	}
+	if cursor, err = strconv.ParseUint(cursorStr, 10, 64); err != nil {
+		return 0, false, fmt.Errorf("bad events cursor %q: %v", cursorStr, err)
+	}
+	return cursor, true, nil
+}
+
+// serveEvents streams the change feed of a data instance.  WebSocket upgrade requests
+// receive a JSON object per event, otherwise events are sent as Server-Sent Events.
+func serveEvents(w http.ResponseWriter, r *http.Request, data datastore.DataService, uuid dvid.UUID) {
+	if strings.ToLower(r.Method) != "get" {
+		BadRequest(w, r, "only GET action is available on events endpoint")
+		return
+	}
+	filter, err := parseEventFilter(r, uuid)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	cursor, hasCursor, err := parseEventCursor(r)
+	if err != nil {
+		BadRequest(w, r, err)
+		return
+	}
+	feed := datastore.GetEventFeed(data.DataUUID())
+	backlog, sub, err := feed.Subscribe(cursor, hasCursor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	defer feed.Unsubscribe(sub)
+
+	dvid.Infof("Streaming events for data %q @ %s to %s\n", data.DataName(), uuid, r.RemoteAddr)
+	if strings.ToLower(r.Header.Get("Upgrade")) == "websocket" {
+		wsServer := websocket.Server{
+			Handshake: func(config *websocket.Config, r *http.Request) error { return checkEventOrigin(r) },
+			Handler: func(ws *websocket.Conn) {
+				streamWebSocketEvents(ws, filter, backlog, sub)
+			},
+		}
+		wsServer.ServeHTTP(w, r)
+		return
+	}
+	streamSSEEvents(w, r, filter, backlog, sub)
+}
+
+// checkEventOrigin returns an error if a WebSocket request comes from a browser page
+// whose origin isn't this server or one of the allowed origins in the configuration.
+// Requests without an Origin header, i.e., from non-browser clients, are allowed.
+func checkEventOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	for _, allowed := range tc.Server.AllowedOrigins {
+		if allowed == "*" || strings.TrimSuffix(allowed, "/") == origin {
+			return nil
+		}
+	}
+	u, err := url.Parse(origin)
+	if err == nil && u.Host != "" && u.Host == r.Host {
+		return nil
+	}
+	return fmt.Errorf("origin %q is not allowed", origin)
+}
+
+func writeSSEEvent(w http.ResponseWriter, evt *datastore.MutationEvent) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", evt.Seq, evt.Action, evt.Msg)
+	return err
+}
+
+func streamSSEEvents(w http.ResponseWriter, r *http.Request, filter eventFilter, backlog []*datastore.MutationEvent, sub *datastore.EventSubscription) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		BadRequest(w, r, "streaming of events is not supported by this connection")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, evt := range backlog {
+		if filter.matches(evt) {
+			if err := writeSSEEvent(w, evt); err != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	closed := r.Context().Done()
+	ticker := time.NewTicker(EventKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case evt, ok := <-sub.Ch:
+			if !ok {
+				return // subscriber dropped so client should reconnect with cursor.
+			}
+			if filter.matches(evt) {
+				if err := writeSSEEvent(w, evt); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-closed:
+			return
+		}
+	}
+}
+
+// webSocketEvent is the JSON sent for each event on a WebSocket.
+type webSocketEvent struct {
+	Cursor     uint64
+	MutationID uint64 `json:",omitempty"`
+	Action     string
+	Event      json.RawMessage
+}
+
+func sendWebSocketEvent(ws *websocket.Conn, evt *datastore.MutationEvent) error {
+	return websocket.JSON.Send(ws, webSocketEvent{evt.Seq, evt.MutationID, evt.Action, evt.Msg})
+}
+
+func streamWebSocketEvents(ws *websocket.Conn, filter eventFilter, backlog []*datastore.MutationEvent, sub *datastore.EventSubscription) {
+	defer ws.Close()
+
+	// Detect client disconnects by reading until an error.
+	closed := make(chan struct{})
+	go func() {
+		var discard []byte
+		for {
+			if err := websocket.Message.Receive(ws, &discard); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	for _, evt := range backlog {
+		if filter.matches(evt) {
+			if err := sendWebSocketEvent(ws, evt); err != nil {
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case evt, ok := <-sub.Ch:
+			if !ok {
+				return
+			}
+			if filter.matches(evt) {
+				if err := sendWebSocketEvent(ws, evt); err != nil {
+					return
+				}
+			}
+		case <-closed:
+			return
+		}
+	}
+}
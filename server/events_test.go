@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestEventFilter(t *testing.T) {
+	uuid := dvid.UUID("abc")
+	r, _ := http.NewRequest("GET", "/events?label=3,4&action=merge,split", nil)
+	filter, err := parseEventFilter(r, uuid)
+	if err != nil {
+		t.Fatalf("error parsing events filter: %v\n", err)
+	}
+	tests := []struct {
+		evt     datastore.MutationEvent
+		matches bool
+	}{
+		{datastore.MutationEvent{Action: "merge", UUID: uuid, Labels: []uint64{1, 4}}, true},
+		{datastore.MutationEvent{Action: "split", UUID: uuid, Labels: []uint64{3}}, true},
+		{datastore.MutationEvent{Action: "cleave", UUID: uuid, Labels: []uint64{3}}, false},
+		{datastore.MutationEvent{Action: "merge", UUID: uuid, Labels: []uint64{5}}, false},
+		{datastore.MutationEvent{Action: "merge", UUID: "def", Labels: []uint64{3}}, false},
+	}
+	for i, tc := range tests {
+		if filter.matches(&tc.evt) != tc.matches {
+			t.Errorf("test %d: expected match %t for event %v\n", i, tc.matches, tc.evt)
+		}
+	}
+
+	r, _ = http.NewRequest("GET", "/events?allversions=true", nil)
+	if filter, err = parseEventFilter(r, uuid); err != nil {
+		t.Fatal(err)
+	}
+	if !filter.matches(&datastore.MutationEvent{Action: "postkv", UUID: "def"}) {
+		t.Errorf("expected allversions filter to match event from other version\n")
+	}
+
+	r, _ = http.NewRequest("GET", "/events?label=foo", nil)
+	if _, err = parseEventFilter(r, uuid); err == nil {
+		t.Errorf("expected error on bad label in events filter\n")
+	}
+}
+
+func TestEventCursor(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/events", nil)
+	if _, hasCursor, err := parseEventCursor(r); err != nil || hasCursor {
+		t.Errorf("expected no cursor, got %t, %v\n", hasCursor, err)
+	}
+	r.Header.Set("Last-Event-ID", "17")
+	if cursor, hasCursor, err := parseEventCursor(r); err != nil || !hasCursor || cursor != 17 {
+		t.Errorf("expected cursor 17 from Last-Event-ID, got %d, %t, %v\n", cursor, hasCursor, err)
+	}
+	r, _ = http.NewRequest("GET", "/events?cursor=23", nil)
+	r.Header.Set("Last-Event-ID", "17")
+	if cursor, _, err := parseEventCursor(r); err != nil || cursor != 23 {
+		t.Errorf("expected cursor query string to take precedence, got %d, %v\n", cursor, err)
+	}
+	r, _ = http.NewRequest("GET", "/events?cursor=-1", nil)
+	if _, _, err := parseEventCursor(r); err == nil {
+		t.Errorf("expected error on bad cursor\n")
+	}
+}
+
+func TestStreamSSEEvents(t *testing.T) {
+	feed := datastore.GetEventFeed(dvid.NewUUID())
+	_, sub, err := feed.Subscribe(0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Unsubscribe(sub)
+
+	backlog := []*datastore.MutationEvent{
+		{Seq: 105, MutationID: 5, Action: "merge", UUID: "abc", Msg: []byte(`{"Action":"merge"}`)},
+		{Seq: 106, MutationID: 5, Action: "split", UUID: "def", Msg: []byte(`{"Action":"split"}`)},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ := http.NewRequest("GET", "/events", nil)
+	r = r.WithContext(ctx)
+	w := httptest.NewRecorder()
+	cancel()
+	streamSSEEvents(w, r, eventFilter{uuid: "abc"}, backlog, sub)
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("bad content type for event stream: %q\n", ct)
+	}
+	expected := "id: 105\nevent: merge\ndata: {\"Action\":\"merge\"}\n\n"
+	if body := w.Body.String(); body != expected {
+		t.Errorf("expected event stream %q, got %q\n", expected, body)
+	}
+	if strings.Contains(w.Body.String(), "split") {
+		t.Errorf("event from filtered version was streamed\n")
+	}
+}
+
+func TestEventOrigin(t *testing.T) {
+	oldOrigins := tc.Server.AllowedOrigins
+	defer func() { tc.Server.AllowedOrigins = oldOrigins }()
+
+	request := func(origin string) *http.Request {
+		r, _ := http.NewRequest("GET", "http://dvid.example.org:8000/api/node/abc/labels/events", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+	tc.Server.AllowedOrigins = nil
+	for origin, allowed := range map[string]bool{
+		"":                               true,
+		"http://dvid.example.org:8000":   true,
+		"https://evil.example.com":       false,
+		"http://dvid.example.org:8000.x": false,
+		"null":                           false,
+	} {
+		if err := checkEventOrigin(request(origin)); (err == nil) != allowed {
+			t.Errorf("expected origin %q allowed to be %t, got error %v\n", origin, allowed, err)
+		}
+	}
+	tc.Server.AllowedOrigins = []string{"https://neutu.example.com"}
+	if err := checkEventOrigin(request("https://neutu.example.com")); err != nil {
+		t.Errorf("expected configured origin to be allowed: %v\n", err)
+	}
+	if err := checkEventOrigin(request("https://evil.example.com")); err == nil {
+		t.Errorf("expected unconfigured origin to be rejected\n")
+	}
+	tc.Server.AllowedOrigins = []string{"*"}
+	if err := checkEventOrigin(request("https://evil.example.com")); err != nil {
+		t.Errorf("expected all origins to be allowed with \"*\": %v\n", err)
+	}
+}
@@ -0,0 +1,628 @@
+/*
+	This file supports mirroring of POST requests to remote DVID servers.  Each POST is
+	appended to a durable outbox for each mirror and then delivered in order for each data
+	instance, retrying with exponential backoff until the mirror accepts it.  Only a limited
+	amount of queued POST bodies is held in memory, and the rest are read back from the
+	outbox when delivered.  The outbox is deleted once all its POSTs are delivered.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+const (
+	// DefaultMirrorMaxRetries is the number of failed attempts to deliver a POST before
+	// delivery to that data instance on the mirror is stalled until replayed.
+	DefaultMirrorMaxRetries = 20
+
+	// DefaultMirrorRetryBase is the delay in seconds before the first retry.  The delay
+	// doubles with each failed attempt up to DefaultMirrorRetryMax seconds.
+	DefaultMirrorRetryBase = 1
+	DefaultMirrorRetryMax  = 300
+
+	// DefaultMirrorMemoryMB is the MB of queued POST bodies held in memory for each mirror
+	// before bodies are only kept in the outbox.
+	DefaultMirrorMemoryMB = 64
+
+	// number of outbox messages read at a time when looking for a POST body.
+	mirrorReadPage = 16
+)
+
+// entry types in a mirror outbox log
+const (
+	mirrorPostEntry uint16 = iota + 1
+	mirrorAckEntry
+)
+
+// the data ID used for all outbox logs, which are named "mirror-<host hash>".
+const mirrorLogID = dvid.UUID("mirror")
+
+var mirrorClient = &http.Client{Timeout: 10 * time.Minute}
+
+// unit of the retry delays in the mirroring configuration.
+var mirrorRetryUnit = time.Second
+
+// MirroringConfig specifies the durable outbox and retry policy for mirrored POSTs.
+type MirroringConfig struct {
+	Outbox     storage.Alias // append-only log store, e.g., filelog.  If empty, the default log store is used.
+	MaxRetries int           `toml:"max_retries"` // failed attempts before stalling; negative = retry forever.
+	RetryBase  int           `toml:"retry_base"`  // seconds before first retry.
+	RetryMax   int           `toml:"retry_max"`   // maximum seconds between retries.
+	MemoryMB   int           `toml:"memory_mb"`   // MB of queued POST bodies held in memory.
+}
+
+// returns the maximum bytes of queued POST bodies held in memory.
+func (c MirroringConfig) memoryBytes() int {
+	if c.MemoryMB <= 0 {
+		return DefaultMirrorMemoryMB << 20
+	}
+	return c.MemoryMB << 20
+}
+
+func (c MirroringConfig) maxRetries() int {
+	if c.MaxRetries == 0 {
+		return DefaultMirrorMaxRetries
+	}
+	return c.MaxRetries
+}
+
+// returns the delay before the next attempt after the given number of failed attempts.
+func (c MirroringConfig) retryDelay(attempts int) time.Duration {
+	base, max := c.RetryBase, c.RetryMax
+	if base <= 0 {
+		base = DefaultMirrorRetryBase
+	}
+	if max <= 0 {
+		max = DefaultMirrorRetryMax
+	}
+	delay, maxDelay := time.Duration(base)*mirrorRetryUnit, time.Duration(max)*mirrorRetryUnit
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// mirrorPost is a POST request queued for delivery to a mirror.
+type mirrorPost struct {
+	seq         uint64
+	queued      time.Time
+	dataUUID    dvid.UUID
+	uri         string // path and query string
+	contentType string
+	size        int    // bytes in body
+	body        []byte // nil if the body is only in the outbox
+}
+
+func (p *mirrorPost) encode() []byte {
+	var buf bytes.Buffer
+	var hdr [16]byte
+	binary.LittleEndian.PutUint64(hdr[0:8], p.seq)
+	binary.LittleEndian.PutUint64(hdr[8:16], uint64(p.queued.UnixNano()))
+	buf.Write(hdr[:])
+	for _, s := range []string{string(p.dataUUID), p.uri, p.contentType} {
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(s)))
+		buf.Write(size[:])
+		buf.WriteString(s)
+	}
+	buf.Write(p.body)
+	return buf.Bytes()
+}
+
+func decodeMirrorPost(data []byte) (*mirrorPost, error) {
+	if len(data) < 16 {
+		return nil, fmt.Errorf("mirror outbox entry of %d bytes is too small", len(data))
+	}
+	p := &mirrorPost{
+		seq:    binary.LittleEndian.Uint64(data[0:8]),
+		queued: time.Unix(0, int64(binary.LittleEndian.Uint64(data[8:16]))),
+	}
+	pos := 16
+	var strs [3]string
+	for i := range strs {
+		if pos+4 > len(data) {
+			return nil, fmt.Errorf("mirror outbox entry %d is truncated", p.seq)
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+		if pos+size > len(data) {
+			return nil, fmt.Errorf("mirror outbox entry %d is truncated", p.seq)
+		}
+		strs[i] = string(data[pos : pos+size])
+		pos += size
+	}
+	p.dataUUID, p.uri, p.contentType = dvid.UUID(strs[0]), strs[1], strs[2]
+	p.body = data[pos:]
+	p.size = len(p.body)
+	return p, nil
+}
+
+// mirrorQueue holds the undelivered POSTs for one data instance on a mirror.
+type mirrorQueue struct {
+	pending  []*mirrorPost
+	attempts int   // failed attempts for the first pending POST
+	stalled  bool  // true if max retries exceeded
+	offset   int64 // outbox offset after the last POST body read from the outbox
+	wake     chan struct{}
+}
+
+// mirrorHost handles the outbox and delivery of POSTs to one mirror.
+type mirrorHost struct {
+	host  string
+	cfg   MirroringConfig
+	logID dvid.UUID
+	wlog  storage.WriteLog      // nil if outbox isn't durable
+	rlog  storage.OffsetReadLog // nil if outbox isn't durable
+
+	sync.Mutex
+	nextSeq       uint64
+	queues        map[dvid.UUID]*mirrorQueue
+	queued        int // number of undelivered POSTs
+	memBytes      int // bytes of undelivered POST bodies held in memory
+	delivered     uint64
+	failed        uint64
+	lastError     string
+	lastErrorTime time.Time
+}
+
+// MirrorStatus gives the delivery status of a mirror.
+type MirrorStatus struct {
+	Host           string
+	Durable        bool        // true if queued POSTs are kept in an outbox log
+	Queued         int         // number of POSTs not yet delivered
+	QueuedBytes    int         // bytes of POST bodies not yet delivered
+	LagSeconds     float64     // age of the oldest undelivered POST
+	Delivered      uint64      // POSTs delivered since server start
+	FailedAttempts uint64      // failed delivery attempts since server start
+	Stalled        []dvid.UUID // data instances whose delivery stopped after max retries
+	LastError      string      `json:",omitempty"`
+	LastErrorTime  string      `json:",omitempty"`
+}
+
+var mirrors struct {
+	sync.Mutex
+	hosts map[string]*mirrorHost
+}
+
+// returns the log ID for the outbox of a mirror host.
+func mirrorHostLogID(host string) dvid.UUID {
+	h := fnv.New64a()
+	h.Write([]byte(host))
+	return dvid.UUID(fmt.Sprintf("%016x", h.Sum64()))
+}
+
+// returns the outbox log store or nil if no log store is available.
+func mirrorOutbox() (storage.WriteLog, storage.OffsetReadLog, error) {
+	var store dvid.Store
+	var err error
+	if tc.Mirroring.Outbox != "" {
+		if store, err = storage.GetStoreByAlias(tc.Mirroring.Outbox); err != nil {
+			return nil, nil, err
+		}
+	} else if store, err = storage.DefaultLogStore(); err != nil {
+		return nil, nil, nil
+	}
+	wlog, ok := store.(storage.WriteLog)
+	if !ok {
+		return nil, nil, fmt.Errorf("mirror outbox store %q is not a write log", store)
+	}
+	rlog, ok := store.(storage.OffsetReadLog)
+	if !ok {
+		return nil, nil, fmt.Errorf("mirror outbox store %q can't be read by offset", store)
+	}
+	return wlog, rlog, nil
+}
+
+// getMirrorHost returns the handler for the mirror, creating it and resuming delivery of
+// any POSTs in its outbox if necessary.
+func getMirrorHost(host string) (*mirrorHost, error) {
+	mirrors.Lock()
+	defer mirrors.Unlock()
+	if mh, found := mirrors.hosts[host]; found {
+		return mh, nil
+	}
+	wlog, rlog, err := mirrorOutbox()
+	if err != nil {
+		return nil, err
+	}
+	if wlog == nil {
+		dvid.Errorf("no log store available for mirror outbox so POSTs to %s will be lost on restart\n", host)
+	}
+	mh, err := newMirrorHost(host, tc.Mirroring, wlog, rlog)
+	if err != nil {
+		return nil, err
+	}
+	if mirrors.hosts == nil {
+		mirrors.hosts = make(map[string]*mirrorHost)
+	}
+	mirrors.hosts[host] = mh
+	return mh, nil
+}
+
+// newMirrorHost returns a handler for a mirror, loading undelivered POSTs from the
+// outbox log if given and starting their delivery.
+func newMirrorHost(host string, cfg MirroringConfig, wlog storage.WriteLog, rlog storage.OffsetReadLog) (*mirrorHost, error) {
+	mh := &mirrorHost{
+		host:    host,
+		cfg:     cfg,
+		logID:   mirrorHostLogID(host),
+		wlog:    wlog,
+		rlog:    rlog,
+		nextSeq: 1,
+		queues:  make(map[dvid.UUID]*mirrorQueue),
+	}
+	if rlog == nil {
+		return mh, nil
+	}
+	pending := make(map[uint64]*mirrorPost)
+	var offset int64
+	var numMsgs int
+	for {
+		msgs, ends, err := rlog.ReadFrom(mirrorLogID, mh.logID, offset, mirrorReadPage)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read outbox for mirror %s: %v", host, err)
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, msg := range msgs {
+			switch msg.EntryType {
+			case mirrorPostEntry:
+				p, err := decodeMirrorPost(msg.Data)
+				if err != nil {
+					return nil, fmt.Errorf("bad outbox for mirror %s: %v", host, err)
+				}
+				mh.holdBody(p)
+				pending[p.seq] = p
+				if p.seq >= mh.nextSeq {
+					mh.nextSeq = p.seq + 1
+				}
+			case mirrorAckEntry:
+				if len(msg.Data) != 8 {
+					return nil, fmt.Errorf("bad ack of %d bytes in outbox for mirror %s", len(msg.Data), host)
+				}
+				seq := binary.LittleEndian.Uint64(msg.Data)
+				if p, found := pending[seq]; found {
+					mh.releaseBody(p)
+					delete(pending, seq)
+				}
+			default:
+				return nil, fmt.Errorf("unknown entry type %d in outbox for mirror %s", msg.EntryType, host)
+			}
+		}
+		numMsgs += len(msgs)
+		offset = ends[len(ends)-1]
+	}
+	posts := make([]*mirrorPost, 0, len(pending))
+	for _, p := range pending {
+		posts = append(posts, p)
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].seq < posts[j].seq })
+	mh.Lock()
+	defer mh.Unlock()
+	for _, p := range posts {
+		q := mh.getQueue(p.dataUUID)
+		q.pending = append(q.pending, p)
+	}
+	mh.queued = len(posts)
+	if len(posts) > 0 {
+		dvid.Infof("Resuming delivery of %d POSTs in outbox for mirror %s\n", len(posts), host)
+	} else if numMsgs > 0 {
+		mh.compactOutbox()
+	}
+	return mh, nil
+}
+
+// holds a POST's body in memory if under the memory limit, else drops it to be read from
+// the outbox when delivered.  The body is always held if the outbox isn't durable.
+func (mh *mirrorHost) holdBody(p *mirrorPost) {
+	if mh.rlog != nil && mh.memBytes+p.size > mh.cfg.memoryBytes() {
+		p.body = nil
+		return
+	}
+	mh.memBytes += p.size
+}
+
+func (mh *mirrorHost) releaseBody(p *mirrorPost) {
+	if p.body != nil {
+		mh.memBytes -= p.size
+	}
+}
+
+// compactOutbox deletes the outbox, which must have no undelivered POSTs, so it doesn't
+// grow without bound.  The caller must hold the lock.
+func (mh *mirrorHost) compactOutbox() {
+	dlog, ok := mh.wlog.(storage.DeletableLog)
+	if !ok {
+		return
+	}
+	if err := dlog.Delete(mirrorLogID, mh.logID); err != nil {
+		dvid.Errorf("unable to delete delivered outbox for mirror %s: %v\n", mh.host, err)
+		return
+	}
+	for _, q := range mh.queues {
+		q.offset = 0
+	}
+}
+
+// readBody returns the body of a POST that isn't held in memory by reading the outbox
+// forward from the end of the last body read for the queue, since each queue's POSTs
+// are in outbox order.
+func (mh *mirrorHost) readBody(q *mirrorQueue, p *mirrorPost) ([]byte, error) {
+	for {
+		msgs, ends, err := mh.rlog.ReadFrom(mirrorLogID, mh.logID, q.offset, mirrorReadPage)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read outbox for mirror %s: %v", mh.host, err)
+		}
+		if len(msgs) == 0 {
+			return nil, fmt.Errorf("POST %s (%d) not found in outbox for mirror %s", p.uri, p.seq, mh.host)
+		}
+		for i, msg := range msgs {
+			if msg.EntryType != mirrorPostEntry || len(msg.Data) < 8 || binary.LittleEndian.Uint64(msg.Data[0:8]) != p.seq {
+				continue
+			}
+			stored, err := decodeMirrorPost(msg.Data)
+			if err != nil {
+				return nil, fmt.Errorf("bad outbox for mirror %s: %v", mh.host, err)
+			}
+			q.offset = ends[i]
+			return stored.body, nil
+		}
+		q.offset = ends[len(ends)-1]
+	}
+}
+
+// returns the queue for a data instance, starting its delivery goroutine if necessary.
+// The caller must hold the lock.
+func (mh *mirrorHost) getQueue(dataUUID dvid.UUID) *mirrorQueue {
+	q, found := mh.queues[dataUUID]
+	if !found {
+		q = &mirrorQueue{wake: make(chan struct{}, 1)}
+		mh.queues[dataUUID] = q
+		go mh.deliver(q)
+	}
+	return q
+}
+
+func (q *mirrorQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue adds a POST to the outbox and queues it for delivery.
+func (mh *mirrorHost) enqueue(dataUUID dvid.UUID, uri, contentType string, body []byte) error {
+	mh.Lock()
+	defer mh.Unlock()
+	p := &mirrorPost{
+		seq:         mh.nextSeq,
+		queued:      time.Now(),
+		dataUUID:    dataUUID,
+		uri:         uri,
+		contentType: contentType,
+		size:        len(body),
+		body:        body,
+	}
+	if mh.wlog != nil {
+		msg := storage.LogMessage{EntryType: mirrorPostEntry, Data: p.encode()}
+		if err := mh.wlog.Append(mirrorLogID, mh.logID, msg); err != nil {
+			return fmt.Errorf("unable to add POST %s to outbox for mirror %s: %v", uri, mh.host, err)
+		}
+	}
+	mh.nextSeq++
+	mh.queued++
+	mh.holdBody(p)
+	q := mh.getQueue(dataUUID)
+	q.pending = append(q.pending, p)
+	if len(q.pending) == 1 {
+		q.signal() // don't cut short any retry delay for an earlier POST.
+	}
+	return nil
+}
+
+// deliver sends the queued POSTs of a data instance in order, retrying on failure.
+func (mh *mirrorHost) deliver(q *mirrorQueue) {
+	for {
+		mh.Lock()
+		if len(q.pending) == 0 || q.stalled {
+			mh.Unlock()
+			<-q.wake
+			continue
+		}
+		p := q.pending[0]
+		mh.Unlock()
+
+		body := p.body
+		var err error
+		if body == nil {
+			body, err = mh.readBody(q, p)
+		}
+		if err == nil {
+			err = mh.send(p, body)
+		}
+
+		mh.Lock()
+		if err == nil {
+			q.pending = q.pending[1:]
+			q.attempts = 0
+			mh.delivered++
+			mh.queued--
+			mh.releaseBody(p)
+			if mh.wlog != nil {
+				var seq [8]byte
+				binary.LittleEndian.PutUint64(seq[:], p.seq)
+				msg := storage.LogMessage{EntryType: mirrorAckEntry, Data: seq[:]}
+				if err := mh.wlog.Append(mirrorLogID, mh.logID, msg); err != nil {
+					dvid.Errorf("unable to record delivery of POST %s to mirror %s: %v\n", p.uri, mh.host, err)
+				} else if mh.queued == 0 {
+					mh.compactOutbox()
+				}
+			}
+			mh.Unlock()
+			continue
+		}
+		q.attempts++
+		mh.failed++
+		mh.lastError = err.Error()
+		mh.lastErrorTime = time.Now()
+		attempts := q.attempts
+		if max := mh.cfg.maxRetries(); max >= 0 && attempts > max {
+			q.stalled = true
+			dvid.Errorf("stopped delivery of POSTs for data %s to mirror %s after %d failed attempts: %v\n", p.dataUUID, mh.host, attempts, err)
+			mh.Unlock()
+			continue
+		}
+		mh.Unlock()
+		delay := mh.cfg.retryDelay(attempts)
+		dvid.Errorf("problem echoing POST (%s%s), retrying in %s: %v\n", mh.host, p.uri, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-q.wake:
+		}
+	}
+}
+
+// send POSTs to the mirror and returns an error if unsuccessful.
+func (mh *mirrorHost) send(p *mirrorPost, body []byte) error {
+	url := mh.host + p.uri
+	resp, err := mirrorClient.Post(url, p.contentType, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	dvid.Debugf("echoed POST: %s (status %d)\n", url, resp.StatusCode)
+	return nil
+}
+
+// replay resumes delivery of all queued POSTs immediately, including stalled queues.
+// Returns the number of queued POSTs.
+func (mh *mirrorHost) replay() int {
+	mh.Lock()
+	defer mh.Unlock()
+	var queued int
+	for _, q := range mh.queues {
+		q.stalled = false
+		q.attempts = 0
+		queued += len(q.pending)
+		q.signal()
+	}
+	return queued
+}
+
+func (mh *mirrorHost) status() MirrorStatus {
+	mh.Lock()
+	defer mh.Unlock()
+	status := MirrorStatus{
+		Host:           mh.host,
+		Durable:        mh.wlog != nil,
+		Delivered:      mh.delivered,
+		FailedAttempts: mh.failed,
+		Stalled:        []dvid.UUID{},
+		LastError:      mh.lastError,
+	}
+	if !mh.lastErrorTime.IsZero() {
+		status.LastErrorTime = mh.lastErrorTime.Format(time.RFC3339)
+	}
+	var oldest time.Time
+	for dataUUID, q := range mh.queues {
+		status.Queued += len(q.pending)
+		for _, p := range q.pending {
+			status.QueuedBytes += p.size
+		}
+		if len(q.pending) > 0 && (oldest.IsZero() || q.pending[0].queued.Before(oldest)) {
+			oldest = q.pending[0].queued
+		}
+		if q.stalled {
+			status.Stalled = append(status.Stalled, dataUUID)
+		}
+	}
+	if !oldest.IsZero() {
+		status.LagSeconds = time.Since(oldest).Seconds()
+	}
+	sort.Slice(status.Stalled, func(i, j int) bool { return status.Stalled[i] < status.Stalled[j] })
+	return status
+}
+
+// startMirrors resumes delivery of queued POSTs for all configured mirrors.
+func startMirrors() {
+	for _, mirror := range tc.Mirror {
+		for _, host := range mirror.Servers {
+			if _, err := getMirrorHost(host); err != nil {
+				dvid.Errorf("unable to start mirroring to %s: %v\n", host, err)
+			}
+		}
+	}
+}
+
+// mirrorPOST queues a successful POST to a data instance for delivery to any mirrors.
+func mirrorPOST(dataUUID, versionUUID dvid.UUID, r *http.Request, body []byte) {
+	for _, host := range instanceMirrors(dataUUID, versionUUID) {
+		mh, err := getMirrorHost(host)
+		if err == nil {
+			err = mh.enqueue(dataUUID, r.URL.RequestURI(), r.Header.Get("Content-Type"), body)
+		}
+		if err != nil {
+			dvid.Criticalf("unable to mirror POST %s to %s: %v\n", r.URL.Path, host, err)
+		}
+	}
+}
+
+// MirrorsStatus returns the delivery status of all mirrors in host order.
+func MirrorsStatus() []MirrorStatus {
+	mirrors.Lock()
+	hosts := make([]*mirrorHost, 0, len(mirrors.hosts))
+	for _, mh := range mirrors.hosts {
+		hosts = append(hosts, mh)
+	}
+	mirrors.Unlock()
+	statuses := make([]MirrorStatus, len(hosts))
+	for i, mh := range hosts {
+		statuses[i] = mh.status()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Host < statuses[j].Host })
+	return statuses
+}
+
+// ReplayMirror resumes delivery of all queued POSTs to the given mirror, or to all
+// mirrors if host is empty, and returns the number of queued POSTs.
+func ReplayMirror(host string) (int, error) {
+	mirrors.Lock()
+	var hosts []*mirrorHost
+	for h, mh := range mirrors.hosts {
+		if host == "" || h == host {
+			hosts = append(hosts, mh)
+		}
+	}
+	mirrors.Unlock()
+	if host != "" && len(hosts) == 0 {
+		return 0, fmt.Errorf("no mirror %q has been used by this server", host)
+	}
+	var queued int
+	for _, mh := range hosts {
+		queued += mh.replay()
+	}
+	return queued, nil
+}
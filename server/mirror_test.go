@@ -0,0 +1,286 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// memLog is an in-memory append-only log for testing mirror outboxes.
+type memLog struct {
+	sync.Mutex
+	msgs map[string][]storage.LogMessage
+}
+
+func (l *memLog) String() string                     { return "memory log" }
+func (l *memLog) Close()                             {}
+func (l *memLog) Equal(config dvid.StoreConfig) bool { return false }
+
+func (l *memLog) Append(dataID, version dvid.UUID, msg storage.LogMessage) error {
+	l.Lock()
+	defer l.Unlock()
+	if l.msgs == nil {
+		l.msgs = make(map[string][]storage.LogMessage)
+	}
+	k := string(dataID + "-" + version)
+	l.msgs[k] = append(l.msgs[k], msg)
+	return nil
+}
+
+func (l *memLog) CloseLog(dataID, version dvid.UUID) error               { return nil }
+func (l *memLog) TopicAppend(topic string, msg storage.LogMessage) error { return nil }
+func (l *memLog) TopicClose(topic string) error                          { return nil }
+
+func (l *memLog) ReadAll(dataID, version dvid.UUID) ([]storage.LogMessage, error) {
+	l.Lock()
+	defer l.Unlock()
+	return append([]storage.LogMessage{}, l.msgs[string(dataID+"-"+version)]...), nil
+}
+
+func (l *memLog) TopicReadAll(topic string) ([]storage.LogMessage, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (l *memLog) ReadBinary(dataID, version dvid.UUID) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (l *memLog) StreamAll(dataID, version dvid.UUID, ch chan storage.LogMessage, wg *sync.WaitGroup) error {
+	return fmt.Errorf("not implemented")
+}
+
+// ReadFrom uses the index of each message as its offset.
+func (l *memLog) ReadFrom(dataID, version dvid.UUID, offset int64, max int) ([]storage.LogMessage, []int64, error) {
+	l.Lock()
+	defer l.Unlock()
+	msgs := l.msgs[string(dataID+"-"+version)]
+	var page []storage.LogMessage
+	var ends []int64
+	for i := offset; i < int64(len(msgs)) && len(page) < max; i++ {
+		page = append(page, msgs[i])
+		ends = append(ends, i+1)
+	}
+	return page, ends, nil
+}
+
+func (l *memLog) TopicReadFrom(topic string, offset int64, max int) ([]storage.LogMessage, []int64, error) {
+	return nil, nil, fmt.Errorf("not implemented")
+}
+
+func (l *memLog) Delete(dataID, version dvid.UUID) error {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.msgs, string(dataID+"-"+version))
+	return nil
+}
+
+func (l *memLog) TopicDelete(topic string) error { return fmt.Errorf("not implemented") }
+
+// mirrorServer records POSTed bodies, failing while down.
+type mirrorServer struct {
+	sync.Mutex
+	down   bool
+	bodies []string
+}
+
+func (s *mirrorServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	if s.down {
+		http.Error(w, "mirror down", http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	s.bodies = append(s.bodies, r.URL.RequestURI()+" "+string(body))
+}
+
+func (s *mirrorServer) received() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.bodies...)
+}
+
+func waitForStatus(t *testing.T, mh *mirrorHost, done func(MirrorStatus) bool) MirrorStatus {
+	for i := 0; i < 500; i++ {
+		status := mh.status()
+		if done(status) {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := mh.status()
+	t.Fatalf("timed out waiting for mirror status, last was %+v\n", status)
+	return status
+}
+
+func TestMirrorPostEncoding(t *testing.T) {
+	p := &mirrorPost{
+		seq:         7,
+		queued:      time.Unix(0, 1551812345000000000),
+		dataUUID:    "abc123",
+		uri:         "/api/node/def456/keys/key/foo?u=bob",
+		contentType: "application/octet-stream",
+		size:        10,
+		body:        []byte("some value"),
+	}
+	p2, err := decodeMirrorPost(p.encode())
+	if err != nil {
+		t.Fatalf("unable to decode mirror post: %v\n", err)
+	}
+	if !reflect.DeepEqual(p, p2) {
+		t.Errorf("expected decoded post %+v, got %+v\n", p, p2)
+	}
+	if _, err := decodeMirrorPost(p.encode()[:20]); err == nil {
+		t.Errorf("expected error decoding truncated post\n")
+	}
+}
+
+func TestMirrorRetryDelay(t *testing.T) {
+	cfg := MirroringConfig{RetryBase: 2, RetryMax: 10}
+	expected := []int{2, 2, 4, 8, 10, 10}
+	for attempts, secs := range expected {
+		if delay := cfg.retryDelay(attempts); delay != time.Duration(secs)*time.Second {
+			t.Errorf("after %d attempts expected %d sec delay, got %s\n", attempts, secs, delay)
+		}
+	}
+}
+
+func TestMirrorOutbox(t *testing.T) {
+	mirrorRetryUnit = time.Millisecond
+	defer func() { mirrorRetryUnit = time.Second }()
+
+	mirror := &mirrorServer{down: true}
+	ts := httptest.NewServer(mirror)
+	defer ts.Close()
+
+	outbox := new(memLog)
+	cfg := MirroringConfig{MaxRetries: 3, RetryBase: 1, RetryMax: 4}
+	mh, err := newMirrorHost(ts.URL, cfg, outbox, outbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := mh.enqueue("data1", fmt.Sprintf("/api/node/abc/data1/key/%d", i), "text/plain", []byte(fmt.Sprintf("a%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mh.enqueue("data2", "/api/node/abc/data2/key/1", "text/plain", []byte("b1")); err != nil {
+		t.Fatal(err)
+	}
+	status := waitForStatus(t, mh, func(s MirrorStatus) bool { return len(s.Stalled) == 2 })
+	if status.Queued != 4 || status.Delivered != 0 || status.FailedAttempts != 8 || !status.Durable {
+		t.Errorf("unexpected status for stalled mirror: %+v\n", status)
+	}
+	if status.LastError == "" || status.LagSeconds <= 0 {
+		t.Errorf("expected error and lag for stalled mirror: %+v\n", status)
+	}
+
+	// Simulate a restart and make sure the queued POSTs are delivered in order on replay.
+	mh2, err := newMirrorHost(ts.URL, cfg, outbox, outbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued := mh2.replay(); queued != 4 {
+		t.Errorf("expected 4 queued POSTs after restart, got %d\n", queued)
+	}
+	mirror.Lock()
+	mirror.down = false
+	mirror.Unlock()
+	mh2.replay()
+	waitForStatus(t, mh2, func(s MirrorStatus) bool { return s.Queued == 0 })
+	var data1 []string
+	for _, body := range mirror.received() {
+		if body != "/api/node/abc/data2/key/1 b1" {
+			data1 = append(data1, body)
+		}
+	}
+	expected := []string{"/api/node/abc/data1/key/1 a1", "/api/node/abc/data1/key/2 a2", "/api/node/abc/data1/key/3 a3"}
+	if !reflect.DeepEqual(data1, expected) {
+		t.Errorf("expected POSTs delivered in order %v, got %v\n", expected, mirror.received())
+	}
+	if len(mirror.received()) != 4 {
+		t.Errorf("expected 4 delivered POSTs, got %v\n", mirror.received())
+	}
+
+	// Delivered POSTs should not be sent again after another restart.
+	mh3, err := newMirrorHost(ts.URL, cfg, outbox, outbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := mh3.status(); status.Queued != 0 {
+		t.Errorf("expected no queued POSTs after delivery, got %+v\n", status)
+	}
+	if err := mh3.enqueue("data1", "/api/node/abc/data1/key/4", "text/plain", []byte("a4")); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, mh3, func(s MirrorStatus) bool { return s.Delivered == 1 })
+	if received := mirror.received(); len(received) != 5 || received[4] != "/api/node/abc/data1/key/4 a4" {
+		t.Errorf("expected new POST delivered after restart, got %v\n", received)
+	}
+
+	// The outbox is deleted once all its POSTs are delivered.
+	if msgs, err := outbox.ReadAll(mirrorLogID, mh3.logID); err != nil || len(msgs) != 0 {
+		t.Errorf("expected empty outbox after delivery, got %d messages: %v\n", len(msgs), err)
+	}
+}
+
+func TestMirrorOutboxMemory(t *testing.T) {
+	mirrorRetryUnit = time.Millisecond
+	defer func() { mirrorRetryUnit = time.Second }()
+
+	mirror := &mirrorServer{down: true}
+	ts := httptest.NewServer(mirror)
+	defer ts.Close()
+
+	outbox := new(memLog)
+	cfg := MirroringConfig{MaxRetries: 1, RetryBase: 1, RetryMax: 1, MemoryMB: 1}
+	mh, err := newMirrorHost(ts.URL, cfg, outbox, outbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := make([]string, 3)
+	for i := range bodies {
+		bodies[i] = strings.Repeat(fmt.Sprintf("%d", i), 400000)
+		if err := mh.enqueue("data1", fmt.Sprintf("/api/node/abc/data1/key/%d", i), "text/plain", []byte(bodies[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status := waitForStatus(t, mh, func(s MirrorStatus) bool { return len(s.Stalled) == 1 })
+	if status.Queued != 3 || status.QueuedBytes != 1200000 {
+		t.Errorf("unexpected status for stalled mirror: %+v\n", status)
+	}
+	mh.Lock()
+	if mh.memBytes != 800000 {
+		t.Errorf("expected 2 POST bodies held in memory, got %d bytes\n", mh.memBytes)
+	}
+	mh.Unlock()
+
+	mirror.Lock()
+	mirror.down = false
+	mirror.Unlock()
+	mh.replay()
+	waitForStatus(t, mh, func(s MirrorStatus) bool { return s.Queued == 0 })
+	received := mirror.received()
+	if len(received) != 3 {
+		t.Fatalf("expected 3 delivered POSTs, got %d\n", len(received))
+	}
+	for i, body := range bodies {
+		if received[i] != fmt.Sprintf("/api/node/abc/data1/key/%d %s", i, body) {
+			t.Errorf("bad body delivered for POST %d\n", i)
+		}
+	}
+	mh.Lock()
+	if mh.memBytes != 0 {
+		t.Errorf("expected no POST bodies in memory after delivery, got %d bytes\n", mh.memBytes)
+	}
+	mh.Unlock()
+}
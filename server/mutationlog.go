@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -13,8 +14,6 @@ import (
 )
 
 var (
-	mutCfg MutationsConfig
-
 	mutOrderID  uint64
 	mutOrderMux sync.RWMutex
 )
@@ -28,21 +27,102 @@ type MutationsConfig struct {
 	Blobstore storage.Alias // alias to a store
 }
 
-func logMutationPayload(data []byte) (ref string, err error) {
-	var store dvid.Store
-	if store, err = storage.GetStoreByAlias(mutCfg.Blobstore); err != nil {
-		return
+func mutationBlobstore(mutCfg MutationsConfig) (storage.BlobStore, error) {
+	store, err := storage.GetStoreByAlias(mutCfg.Blobstore)
+	if err != nil {
+		return nil, err
 	}
 	blobstore, ok := store.(storage.BlobStore)
 	if !ok {
-		err = fmt.Errorf("mutation blobstore %q is not a valid blob store", mutCfg.Blobstore)
+		return nil, fmt.Errorf("mutation blobstore %q is not a valid blob store", mutCfg.Blobstore)
+	}
+	return blobstore, nil
+}
+
+func logMutationPayload(mutCfg MutationsConfig, data []byte) (ref string, err error) {
+	var blobstore storage.BlobStore
+	if blobstore, err = mutationBlobstore(mutCfg); err != nil {
 		return
 	}
 	return blobstore.PutBlob(data)
 }
 
-// LogMutation logs a HTTP mutation request to the mutation log specific in the config.
-func LogMutation(versionID, dataID dvid.UUID, r *http.Request, data []byte) (err error) {
+// mutationLogStore returns the store for a "logstore:alias" mutation log specification.
+func mutationLogStore(mutCfg MutationsConfig) (dvid.Store, error) {
+	parts := strings.Split(mutCfg.Logstore, ":")
+	if len(parts) != 2 || parts[0] != "logstore" {
+		return nil, fmt.Errorf("mutation log %q is not a logstore specification", mutCfg.Logstore)
+	}
+	store, err := storage.GetStoreByAlias(storage.Alias(parts[1]))
+	if err != nil {
+		return nil, fmt.Errorf("bad mutation logstore specification %q", parts[1])
+	}
+	return store, nil
+}
+
+// readMutationLog returns up to max mutations logged for a version in the mutation logstore,
+// starting at the given byte offset, and the byte offset after each mutation returned.
+func readMutationLog(versionID dvid.UUID, offset int64, max int) ([]storage.LogMessage, []int64, error) {
+	mutCfg := MutationLogSpec()
+	store, err := mutationLogStore(mutCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	var log storage.ReadLog
+	switch s := store.(type) {
+	case storage.ReadLog:
+		log = s
+	case storage.LogReadable:
+		log = s.GetReadLog()
+	}
+	orl, ok := log.(storage.OffsetReadLog)
+	if !ok {
+		return nil, nil, fmt.Errorf("mutation logstore %q is not a log readable by offset", mutCfg.Logstore)
+	}
+	return orl.TopicReadFrom(string(versionID), offset, max)
+}
+
+type mutationAssignmentsKey struct{}
+
+// mutationAssignments holds the IDs, e.g., new labels, assigned while handling a mutation.
+type mutationAssignments struct {
+	assigned interface{}     // set by the data instance handling the mutation
+	replayed json.RawMessage // logged by the leader for a mutation replayed on a replica
+}
+
+// withMutationAssignments returns a copy of the request that can hold assigned IDs.
+func withMutationAssignments(r *http.Request, a *mutationAssignments) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), mutationAssignmentsKey{}, a))
+}
+
+// SetMutationAssignments records the IDs, e.g., new labels, assigned while handling a
+// mutation request so they are logged with the mutation.  Replicas replaying the mutation
+// get them through ReplayedAssignments so they assign the same IDs.  The assigned IDs must
+// be JSON encodable.
+func SetMutationAssignments(r *http.Request, assigned interface{}) {
+	if a, ok := r.Context().Value(mutationAssignmentsKey{}).(*mutationAssignments); ok {
+		a.assigned = assigned
+	}
+}
+
+// ReplayedAssignments decodes the IDs logged by the leader for a mutation request that is
+// being replayed on a replica.  Returns false if the request isn't a replay of a mutation
+// with assigned IDs.
+func ReplayedAssignments(r *http.Request, assigned interface{}) (bool, error) {
+	a, ok := r.Context().Value(mutationAssignmentsKey{}).(*mutationAssignments)
+	if !ok || len(a.replayed) == 0 {
+		return false, nil
+	}
+	if err := json.Unmarshal(a.replayed, assigned); err != nil {
+		return false, fmt.Errorf("bad IDs assigned by leader to replayed mutation: %v", err)
+	}
+	return true, nil
+}
+
+// LogMutation logs a HTTP mutation request to the mutation log specific in the config,
+// along with the status of its response and any IDs assigned while handling it.
+func LogMutation(versionID, dataID dvid.UUID, r *http.Request, data []byte, status int, assigned interface{}) (err error) {
+	mutCfg := MutationLogSpec()
 	if mutCfg.Blobstore == "" || mutCfg.Logstore == "" {
 		return nil
 	}
@@ -52,13 +132,17 @@ func LogMutation(versionID, dataID dvid.UUID, r *http.Request, data []byte) (err
 		"URI":         r.RequestURI,
 		"RemoteAddr":  r.RemoteAddr,
 		"ContentType": r.Header.Get("Content-Type"),
+		"Status":      status,
 	}
 	if dataID != "" {
 		mutation["DataUUID"] = dataID
 	}
+	if assigned != nil {
+		mutation["Assigned"] = assigned
+	}
 	if len(data) != 0 {
 		var postRef string
-		if postRef, err = logMutationPayload(data); err != nil {
+		if postRef, err = logMutationPayload(mutCfg, data); err != nil {
 			return fmt.Errorf("unable to store mutation payload (%s): %v", r.RequestURI, err)
 		}
 		mutation["DataBytes"] = len(data)
@@ -87,17 +171,19 @@ func LogMutation(versionID, dataID dvid.UUID, r *http.Request, data []byte) (err
 			return fmt.Errorf("error on sending mutation (%s) to kafka: %v", r.RequestURI, err)
 		}
 	case "logstore":
-		store, err := storage.GetStoreByAlias(storage.Alias(spec))
+		store, err := mutationLogStore(mutCfg)
 		if err != nil {
-			return fmt.Errorf("bad mutation logstore specification %q", spec)
+			return err
 		}
-		logable, ok := store.(storage.LogWritable)
-		if !ok {
-			return fmt.Errorf("mutation logstore %q was not a valid write log", spec)
+		var log storage.WriteLog
+		switch s := store.(type) {
+		case storage.WriteLog:
+			log = s
+		case storage.LogWritable:
+			log = s.GetWriteLog()
 		}
-		log := logable.GetWriteLog()
 		if log == nil {
-			return fmt.Errorf("unable to get write log from store %s", store)
+			return fmt.Errorf("mutation logstore %q was not a valid write log", spec)
 		}
 		return log.TopicAppend(string(versionID), storage.LogMessage{Data: jsonmsg})
 	default:
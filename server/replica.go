@@ -0,0 +1,318 @@
+/*
+	This file supports continuous replication of repos from a leader DVID server, which
+	makes this server a warm read replica.  Each replicated repo is periodically synced
+	with the leader, which copies new versions, data instances, and the key-values of
+	newly committed versions.  Open versions are kept up-to-date by replaying the HTTP
+	mutations in the leader's mutation log, which requires the leader to have a
+	[mutations] logstore and blobstore configured.  Replicated repos only accept GET and
+	HEAD requests on this server so they don't diverge from the leader.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/rpc"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/valyala/gorpc"
+)
+
+// DefaultReplicationInterval is the number of seconds between syncs with a leader.
+const DefaultReplicationInterval = 10
+
+const replicaMutationsMsg = "server.replicaMutations"
+
+// maximum number of mutation log entries requested from a leader in one call.
+var replicaMutationsMax = 100
+
+// ReplicationConfig specifies repos replicated from a leader DVID server.
+type ReplicationConfig struct {
+	Leader   string   // RPC address of the leader, e.g., "leader.janelia.org:8001"
+	Repos    []string // UUIDs of versions within the repos to replicate
+	Interval int      // seconds between syncs with the leader
+}
+
+var replicas struct {
+	sync.Mutex
+	list []*datastore.Replica
+}
+
+func init() {
+	rpc.Dispatcher().AddFunc(replicaMutationsMsg, handleReplicaMutations)
+
+	gorpc.RegisterType(&replicaMutationsRequest{})
+	gorpc.RegisterType(&replicaMutations{})
+}
+
+type replicaMutationsRequest struct {
+	Version dvid.UUID
+	Offset  int64 // byte offset of the first mutation log entry to send
+	Max     int
+}
+
+// replicaMutation is a logged HTTP request with any payload retrieved from the blobstore.
+type replicaMutation struct {
+	Method      string
+	URI         string
+	ContentType string
+	DataUUID    dvid.UUID // empty for requests not on a data instance
+	Data        []byte
+	Status      int    // status of the leader's response or 0 if not logged
+	Assigned    []byte // JSON of any IDs, e.g., new labels, assigned by the leader
+	Next        int64  // byte offset of the mutation log entry after this one
+}
+
+type replicaMutations struct {
+	Mutations []replicaMutation
+	Next      int64 // byte offset of the mutation log entry after the last sent
+	Done      bool  // true if there were no more entries in the mutation log
+}
+
+// mutationEntry holds the fields of a mutation log entry needed for replay.
+type mutationEntry struct {
+	Method      string
+	URI         string
+	ContentType string
+	DataUUID    dvid.UUID
+	DataRef     string
+	Status      int
+	Assigned    json.RawMessage
+}
+
+// handleReplicaMutations returns entries from this leader's mutation log for a version.
+func handleReplicaMutations(m *replicaMutationsRequest) (*replicaMutations, error) {
+	msgs, ends, err := readMutationLog(m.Version, m.Offset, m.Max)
+	if err != nil {
+		return nil, err
+	}
+	var blobstore storage.BlobStore
+	getBlob := func(ref string) ([]byte, error) {
+		if blobstore == nil {
+			if blobstore, err = mutationBlobstore(MutationLogSpec()); err != nil {
+				return nil, err
+			}
+		}
+		return blobstore.GetBlob(ref)
+	}
+	return pageMutations(m, msgs, ends, getBlob)
+}
+
+// pageMutations returns a page of mutation log entries read from the requested offset,
+// where ends gives the byte offset after each entry, retrieving payloads of data instance
+// mutations using the given function.  Logged GET and HEAD requests are skipped.
+func pageMutations(m *replicaMutationsRequest, msgs []storage.LogMessage, ends []int64, getBlob func(ref string) ([]byte, error)) (*replicaMutations, error) {
+	resp := &replicaMutations{Next: m.Offset, Done: len(msgs) < m.Max}
+	for i, msg := range msgs {
+		var entry mutationEntry
+		if err := json.Unmarshal(msg.Data, &entry); err != nil {
+			return nil, fmt.Errorf("bad mutation log entry at offset %d for version %s: %v", resp.Next, m.Version, err)
+		}
+		if entry.Method == "GET" || entry.Method == "HEAD" {
+			resp.Next = ends[i]
+			continue
+		}
+		mutation := replicaMutation{
+			Method:      entry.Method,
+			URI:         entry.URI,
+			ContentType: entry.ContentType,
+			DataUUID:    entry.DataUUID,
+			Status:      entry.Status,
+			Assigned:    entry.Assigned,
+			Next:        ends[i],
+		}
+		if entry.DataRef != "" && entry.DataUUID != "" {
+			var err error
+			if mutation.Data, err = getBlob(entry.DataRef); err != nil {
+				return nil, fmt.Errorf("unable to get payload %q of mutation at offset %d for version %s: %v", entry.DataRef, resp.Next, m.Version, err)
+			}
+		}
+		resp.Mutations = append(resp.Mutations, mutation)
+		resp.Next = ends[i]
+	}
+	return resp, nil
+}
+
+// startReplication launches a goroutine for each repo replicated from a leader.
+func startReplication() {
+	cfg := tc.Replication
+	if cfg.Leader == "" || len(cfg.Repos) == 0 {
+		return
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = DefaultReplicationInterval
+	}
+	for _, uuidStr := range cfg.Repos {
+		rep := datastore.NewReplica(cfg.Leader, dvid.UUID(uuidStr))
+		replicas.Lock()
+		replicas.list = append(replicas.list, rep)
+		replicas.Unlock()
+		dvid.Infof("Replicating repo with version %s from leader %s every %d seconds\n", uuidStr, cfg.Leader, interval)
+		go replicate(rep, time.Duration(interval)*time.Second)
+	}
+}
+
+func replicate(rep *datastore.Replica, interval time.Duration) {
+	for {
+		open, err := rep.Sync()
+		if err == nil {
+			for _, uuid := range open {
+				if err := replayVersion(rep, uuid); err != nil {
+					dvid.Errorf("unable to replay mutations for version %s: %v\n", uuid, err)
+				}
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// ReplicationStatus returns the progress of each repo replicated from a leader.
+func ReplicationStatus() []datastore.ReplicaStatus {
+	replicas.Lock()
+	defer replicas.Unlock()
+	statuses := make([]datastore.ReplicaStatus, len(replicas.list))
+	for i, rep := range replicas.list {
+		statuses[i] = rep.Status()
+	}
+	return statuses
+}
+
+// replayVersion replays the leader's mutation log for an open version, starting after
+// the last checkpointed entry.
+func replayVersion(rep *datastore.Replica, uuid dvid.UUID) error {
+	for {
+		offset := rep.ReplayOffset(uuid)
+		resp, err := rep.Call(replicaMutationsMsg, replicaMutationsRequest{Version: uuid, Offset: offset, Max: replicaMutationsMax})
+		if err != nil {
+			return err
+		}
+		page, ok := resp.(*replicaMutations)
+		if !ok {
+			return fmt.Errorf("received response during replication that wasn't expected mutations: %v", resp)
+		}
+		for i := range page.Mutations {
+			if err := replayMutation(uuid, &page.Mutations[i]); err != nil {
+				if i > 0 {
+					if err2 := rep.SetReplayOffset(uuid, page.Mutations[i-1].Next, i); err2 != nil {
+						dvid.Errorf("unable to checkpoint replayed mutations for version %s: %v\n", uuid, err2)
+					}
+				}
+				return err
+			}
+		}
+		if err := rep.SetReplayOffset(uuid, page.Next, len(page.Mutations)); err != nil {
+			return err
+		}
+		if page.Done || len(page.Mutations) == 0 {
+			return nil
+		}
+	}
+}
+
+// replicaLeader returns the leader of the repo containing the given version if the repo
+// is replicated, or an empty string if it isn't.
+func replicaLeader(uuid dvid.UUID) string {
+	cfg := tc.Replication
+	if cfg.Leader == "" || len(cfg.Repos) == 0 {
+		return ""
+	}
+	root, err := datastore.GetRepoRoot(uuid)
+	if err != nil {
+		return ""
+	}
+	for _, uuidStr := range cfg.Repos {
+		replicated, _, err := datastore.MatchingUUID(uuidStr)
+		if err != nil {
+			continue
+		}
+		if replicatedRoot, err := datastore.GetRepoRoot(replicated); err == nil && replicatedRoot == root {
+			return cfg.Leader
+		}
+	}
+	return ""
+}
+
+// replayMutation sends a mutation logged by the leader directly to the data instance,
+// bypassing the middleware that would log or mirror it again.  Mutations rejected by the
+// leader are skipped.  Any failure to replay other mutations, including a panic or an
+// error response, is returned so replay of the version stops at the failed mutation.
+func replayMutation(uuid dvid.UUID, mutation *replicaMutation) (err error) {
+	if mutation.DataUUID == "" || mutation.Method == "GET" || mutation.Method == "HEAD" {
+		return nil
+	}
+	if mutation.Status >= 300 {
+		dvid.Infof("skipping replay of %s %s, which leader rejected with status %d\n", mutation.Method, mutation.URI, mutation.Status)
+		return nil
+	}
+	data, err := datastore.GetDataByDataUUID(mutation.DataUUID)
+	if err != nil {
+		dvid.Errorf("skipping replay of %s %s on deleted data %s\n", mutation.Method, mutation.URI, mutation.DataUUID)
+		return nil
+	}
+	v, err := datastore.VersionFromUUID(uuid)
+	if err != nil {
+		return err
+	}
+	if !data.Versioned() {
+		if v, err = datastore.GetRepoRootVersion(v); err != nil {
+			return err
+		}
+	}
+	r, err := http.NewRequest(mutation.Method, mutation.URI, bytes.NewReader(mutation.Data))
+	if err != nil {
+		dvid.Errorf("skipping replay of %s %s: %v\n", mutation.Method, mutation.URI, err)
+		return nil
+	}
+	r.RequestURI = mutation.URI
+	if mutation.ContentType != "" {
+		r.Header.Set("Content-Type", mutation.ContentType)
+	}
+	if len(mutation.Assigned) != 0 {
+		r = withMutationAssignments(r, &mutationAssignments{replayed: mutation.Assigned})
+	}
+
+	w := &replayResponseWriter{header: make(http.Header), status: http.StatusOK}
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("panic on replay of %s %s: %v", mutation.Method, mutation.URI, e)
+		}
+	}()
+	data.ServeHTTP(uuid, datastore.NewVersionedCtx(data, v), w, r)
+	if w.status >= 300 {
+		return fmt.Errorf("replay of %s %s returned status %d: %s", mutation.Method, mutation.URI, w.status, w.body.String())
+	}
+	return nil
+}
+
+// replayResponseWriter records the status and the start of the body of a replayed request.
+type replayResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *replayResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *replayResponseWriter) Write(b []byte) (int, error) {
+	if n := 200 - w.body.Len(); n > 0 {
+		if n > len(b) {
+			n = len(b)
+		}
+		w.body.Write(b[:n])
+	}
+	return len(b), nil
+}
+
+func (w *replayResponseWriter) WriteHeader(code int) {
+	w.status = code
+}
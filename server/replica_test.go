@@ -0,0 +1,175 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func TestReplicaMutationPages(t *testing.T) {
+	blobs := map[string][]byte{
+		"ref1": []byte("first payload"),
+		"ref2": []byte("second payload"),
+	}
+	entries := []map[string]interface{}{
+		{"Method": "POST", "URI": "/api/node/abc/labels/split/23", "ContentType": "application/json", "DataUUID": "d1", "DataRef": "ref1"},
+		{"Method": "POST", "URI": "/api/node/abc/commit", "DataRef": "ref2"},
+		{"Method": "DELETE", "URI": "/api/node/abc/keys/key/foo", "DataUUID": "d2"},
+		{"Method": "POST", "URI": "/api/node/abc/keys/key/bar", "DataUUID": "d2", "DataRef": "ref2", "TimeUnix": 1552069930, "Status": 200, "Assigned": []uint64{7}},
+		{"Method": "GET", "URI": "/api/node/abc/keys/key/bar", "DataUUID": "d2", "DataRef": "ref1", "Status": 200},
+	}
+	msgs := make([]storage.LogMessage, len(entries))
+	for i, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs[i] = storage.LogMessage{Data: data}
+	}
+	// entries in the simulated log are 10 bytes each.
+	readLog := func(offset int64, max int) ([]storage.LogMessage, []int64) {
+		var page []storage.LogMessage
+		var ends []int64
+		for i := int(offset / 10); i < len(msgs) && len(page) < max; i++ {
+			page = append(page, msgs[i])
+			ends = append(ends, int64(i+1)*10)
+		}
+		return page, ends
+	}
+	var fetched []string
+	getBlob := func(ref string) ([]byte, error) {
+		fetched = append(fetched, ref)
+		blob, found := blobs[ref]
+		if !found {
+			return nil, fmt.Errorf("no blob %q", ref)
+		}
+		return blob, nil
+	}
+
+	page1, ends := readLog(0, 3)
+	page, err := pageMutations(&replicaMutationsRequest{Version: "abc", Offset: 0, Max: 3}, page1, ends, getBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []replicaMutation{
+		{Method: "POST", URI: "/api/node/abc/labels/split/23", ContentType: "application/json", DataUUID: "d1", Data: blobs["ref1"], Next: 10},
+		{Method: "POST", URI: "/api/node/abc/commit", Next: 20},
+		{Method: "DELETE", URI: "/api/node/abc/keys/key/foo", DataUUID: "d2", Next: 30},
+	}
+	if !reflect.DeepEqual(page.Mutations, expected) {
+		t.Errorf("expected first page of mutations:\n%v\ngot:\n%v\n", expected, page.Mutations)
+	}
+	if page.Next != 30 || page.Done {
+		t.Errorf("expected next offset 30 and more mutations, got next %d, done %t\n", page.Next, page.Done)
+	}
+	if !reflect.DeepEqual(fetched, []string{"ref1"}) {
+		t.Errorf("expected only payload of data mutation to be fetched, got %v\n", fetched)
+	}
+
+	page2, ends := readLog(page.Next, 3)
+	page, err = pageMutations(&replicaMutationsRequest{Version: "abc", Offset: page.Next, Max: 3}, page2, ends, getBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Mutations) != 1 || string(page.Mutations[0].Data) != "second payload" || page.Next != 50 || !page.Done {
+		t.Errorf("bad last page of mutations: %v\n", page)
+	}
+	if page.Mutations[0].Status != 200 || string(page.Mutations[0].Assigned) != "[7]" {
+		t.Errorf("expected status and assigned IDs logged by leader, got %d, %q\n", page.Mutations[0].Status, page.Mutations[0].Assigned)
+	}
+	if !reflect.DeepEqual(fetched, []string{"ref1", "ref2"}) {
+		t.Errorf("expected payload of logged GET to not be fetched, got %v\n", fetched)
+	}
+
+	page, err = pageMutations(&replicaMutationsRequest{Version: "abc", Offset: 50, Max: 3}, nil, nil, getBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Mutations) != 0 || page.Next != 50 || !page.Done {
+		t.Errorf("expected no mutations after end of log, got %v\n", page)
+	}
+
+	delete(blobs, "ref1")
+	page1, ends = readLog(0, 3)
+	if _, err = pageMutations(&replicaMutationsRequest{Version: "abc", Offset: 0, Max: 3}, page1, ends, getBlob); err == nil {
+		t.Errorf("expected error on missing mutation payload\n")
+	}
+}
+
+func TestReplayResponseWriter(t *testing.T) {
+	w := &replayResponseWriter{header: make(http.Header), status: http.StatusOK}
+	r, err := http.NewRequest("POST", "/api/node/abc/labels/merge", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	BadRequest(w, r, "bad label %d", 23)
+	if w.status != http.StatusBadRequest {
+		t.Errorf("expected bad request status on replay, got %d\n", w.status)
+	}
+	if w.body.String() != "bad label 23 (/api/node/abc/labels/merge).\n" {
+		t.Errorf("unexpected replay response body: %q\n", w.body.String())
+	}
+
+	// Only mutations on data instances are replayed.
+	mutation := &replicaMutation{Method: "POST", URI: "/api/node/abc/commit"}
+	if err := replayMutation(dvid.UUID("abc"), mutation); err != nil {
+		t.Errorf("expected mutation without data instance to be skipped, got %v\n", err)
+	}
+}
+
+func TestMutationAssignments(t *testing.T) {
+	r, err := http.NewRequest("POST", "/api/node/abc/labels/cleave/23", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var assigned []uint64
+	if replayed, err := ReplayedAssignments(r, &assigned); err != nil || replayed {
+		t.Errorf("expected request without assignments to not be a replay, got %t: %v\n", replayed, err)
+	}
+	SetMutationAssignments(r, []uint64{5}) // ignored since mutation isn't logged
+
+	a := new(mutationAssignments)
+	r = withMutationAssignments(r, a)
+	SetMutationAssignments(r, []uint64{24})
+	if !reflect.DeepEqual(a.assigned, []uint64{24}) {
+		t.Errorf("expected assignments to be recorded for logging, got %v\n", a.assigned)
+	}
+
+	r = withMutationAssignments(r, &mutationAssignments{replayed: []byte("[24]")})
+	if replayed, err := ReplayedAssignments(r, &assigned); err != nil || !replayed || !reflect.DeepEqual(assigned, []uint64{24}) {
+		t.Errorf("expected replayed assignment of label 24, got %v, %t: %v\n", assigned, replayed, err)
+	}
+}
+
+func TestReplicaReadOnly(t *testing.T) {
+	if err := OpenTest(); err != nil {
+		t.Fatalf("can't open test server: %v\n", err)
+	}
+	defer CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+	other, _ := datastore.NewTestRepo()
+	tc.Replication = ReplicationConfig{Leader: "leader.janelia.org:8001", Repos: []string{string(uuid)[:8]}}
+	defer func() { tc.Replication = ReplicationConfig{} }()
+
+	if leader := replicaLeader(uuid); leader != "leader.janelia.org:8001" {
+		t.Errorf("expected repo %s to be replicated, got leader %q\n", uuid, leader)
+	}
+	if leader := replicaLeader(other); leader != "" {
+		t.Errorf("expected repo %s to not be replicated, got leader %q\n", other, leader)
+	}
+
+	apiStr := fmt.Sprintf("%snode/%s/note", WebAPIPath, uuid)
+	TestBadHTTP(t, "POST", apiStr, strings.NewReader(`{"note": "local change"}`))
+	TestHTTP(t, "GET", apiStr, nil)
+
+	apiStr = fmt.Sprintf("%snode/%s/note", WebAPIPath, other)
+	TestHTTP(t, "POST", apiStr, strings.NewReader(`{"note": "local change"}`))
+}
@@ -9,6 +9,7 @@ package server
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
@@ -50,6 +51,31 @@ Commands executed on the server (rpc address = %s):
 
 	node <UUID> <data name> <type-specific commands>
 
+	mirrors status
+
+		Prints the delivery status of each mirror receiving POSTs from this server.
+
+	mirrors replay [<mirror host>]
+
+		Resumes delivery of queued POSTs to the given mirror, or all mirrors if none is
+		given, including any data whose delivery stopped after the maximum number of
+		retries.  Use after a mirror that was down is available again.
+
+	replication status
+
+		Prints the progress of each repo replicated from a leader DVID server.
+
+	backup [full] [<backup directory>]
+
+		Starts an online backup of all stores into a new backup point in the given directory,
+		or the [backup] directory in the configuration.  The point is incremental, holding
+		only the changes since the previous point, unless "full" is given.  Use the
+		"dvid restore" command to rebuild a server from a backup point.
+
+	backup status [<backup directory>]
+
+		Prints the status of any running backup and the backup points in the directory.
+
 DANGEROUS COMMANDS (only available via command line)
 
 	repos delete <UUID> <repo passcode if any>
@@ -72,6 +98,13 @@ EXPERIMENTAL COMMANDS
 		Makes a log of all mutations from ancestors up to given UUID for
 		the given data UUID.
 
+	repo <UUID> export-precomputed <data name> <directory> <settings...>
+
+		Writes the data at the given version into a local directory using the
+		Neuroglancer precomputed layout, i.e., an "info" file and a subdirectory
+		of chunk files for each scale.  See the data type's help for settings,
+		e.g., "encoding=jpeg" for uint8blk or "supervoxels=true" for labelmap.
+
 	repo <UUID> migrate <instance name> <src store> <dst store> <settings...>
     
 		Migrates all of this instance's data from a source store (specified by 
@@ -483,6 +516,30 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 				return
 			}
 
+		case "export-precomputed":
+			var dataname, dirPath string
+			cmd.CommandArgs(3, &dataname, &dirPath)
+			if dirPath == "" {
+				err = fmt.Errorf("export-precomputed requires a data name and output directory")
+				return
+			}
+			var d datastore.DataService
+			if d, err = datastore.GetDataByUUIDName(uuid, dvid.InstanceName(dataname)); err != nil {
+				return
+			}
+			exporter, ok := d.(datastore.PrecomputedExporter)
+			if !ok {
+				err = fmt.Errorf("data %q [%s] does not support precomputed export", dataname, d.TypeName())
+				return
+			}
+			config := cmd.Settings()
+			go func() {
+				if err := exporter.ExportPrecomputed(uuid, dirPath, config); err != nil {
+					dvid.Errorf("export-precomputed error for data %q: %v\n", dataname, err)
+				}
+			}()
+			reply.Text = fmt.Sprintf("Started precomputed export of data %q @ %s to directory %q...\n", dataname, uuid, dirPath)
+
 		case "migrate":
 			var source, srcStoreName, dstStoreName string
 			cmd.CommandArgs(3, &source, &srcStoreName, &dstStoreName)
@@ -593,6 +650,99 @@ func handleCommand(cmd *datastore.Request) (reply *datastore.Response, err error
 			return
 		}
 
+	case "mirrors":
+		var subcommand, host string
+		cmd.CommandArgs(1, &subcommand, &host)
+		switch subcommand {
+		case "status":
+			for _, status := range MirrorsStatus() {
+				reply.Text += fmt.Sprintf("%s: %d queued (%d bytes, lag %.1f sec), %d delivered, %d failed attempts",
+					status.Host, status.Queued, status.QueuedBytes, status.LagSeconds, status.Delivered, status.FailedAttempts)
+				if len(status.Stalled) != 0 {
+					reply.Text += fmt.Sprintf(", stalled data %v", status.Stalled)
+				}
+				reply.Text += "\n"
+			}
+			if reply.Text == "" {
+				reply.Text = "No mirrors have been used by this server.\n"
+			}
+		case "replay":
+			var queued int
+			if queued, err = ReplayMirror(host); err != nil {
+				return
+			}
+			reply.Text = fmt.Sprintf("Resumed delivery of %d queued POSTs to mirrors.\n", queued)
+		default:
+			err = fmt.Errorf("Unknown mirrors command: %q", subcommand)
+		}
+
+	case "replication":
+		var subcommand string
+		cmd.CommandArgs(1, &subcommand)
+		if subcommand != "status" {
+			err = fmt.Errorf("Unknown replication command: %q", subcommand)
+			return
+		}
+		for _, status := range ReplicationStatus() {
+			reply.Text += fmt.Sprintf("%s from %s: %d of %d versions copied, %d key-values and %d log messages (%d bytes), %d mutations replayed",
+				status.UUID, status.Leader, status.CopiedVersions, status.Versions, status.KeyValuesCopied,
+				status.LogMessagesCopied, status.BytesCopied, status.MutationsReplayed)
+			if status.LastError != "" {
+				reply.Text += fmt.Sprintf(", last error at %s: %s", status.LastErrorTime.Format(time.RFC3339), status.LastError)
+			}
+			reply.Text += "\n"
+		}
+		if reply.Text == "" {
+			reply.Text = "No repos are replicated by this server.\n"
+		}
+
+	case "backup":
+		var arg1, arg2 string
+		cmd.CommandArgs(1, &arg1, &arg2)
+		switch arg1 {
+		case "status":
+			var info *BackupInfo
+			if info, err = GetBackupInfo(arg2); err != nil {
+				return
+			}
+			if info.Status.Running {
+				reply.Text = fmt.Sprintf("Backup point %d in %s started %s is being written.\n",
+					info.Status.ID, info.Status.Dir, info.Status.Started.Format(time.RFC3339))
+			}
+			if info.Status.LastError != "" {
+				reply.Text += fmt.Sprintf("Last backup error at %s: %s\n",
+					info.Status.LastErrorTime.Format(time.RFC3339), info.Status.LastError)
+			}
+			for _, point := range info.Points {
+				var puts, deletes, bytes uint64
+				for _, s := range point.Stores {
+					puts += s.Puts
+					deletes += s.Deletes
+					bytes += s.Bytes
+				}
+				kind := "full"
+				if point.Parent != 0 {
+					kind = fmt.Sprintf("incremental after %d", point.Parent)
+				}
+				reply.Text += fmt.Sprintf("%d: %s, %s, %d stores, %d puts (%d bytes), %d deletes\n",
+					point.ID, point.Created.Format(time.RFC3339), kind, len(point.Stores), puts, bytes, deletes)
+			}
+			if len(info.Points) == 0 {
+				reply.Text += "No backup points found.\n"
+			}
+		default:
+			full := arg1 == "full"
+			dir := arg1
+			if full {
+				dir = arg2
+			}
+			var id uint64
+			if id, err = StartBackup(dir, full); err != nil {
+				return
+			}
+			reply.Text = fmt.Sprintf("Started writing backup point %d.  Use \"backup status\" to check progress.\n", id)
+		}
+
 	case "node":
 		var uuidStr, descriptor string
 		cmd.CommandArgs(1, &uuidStr, &descriptor)
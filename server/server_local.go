@@ -65,6 +65,7 @@ type TestConfig struct {
 	KVStoresMap  storage.DataMap
 	LogStoresMap storage.DataMap
 	CacheSize    map[string]int // MB for caches
+	Generators   map[string]string
 }
 
 // OpenTest initializes the server for testing, setting up caching, datastore, etc.
@@ -83,6 +84,14 @@ func OpenTest(configs ...TestConfig) error {
 				dataMap.LogStores = c.LogStoresMap
 				dataMapped = true
 			}
+			if len(c.Generators) != 0 {
+				if tc.Generators == nil {
+					tc.Generators = make(map[string]generatorConfig)
+				}
+				for name, command := range c.Generators {
+					tc.Generators[name] = generatorConfig{Command: command}
+				}
+			}
 			if len(c.CacheSize) != 0 {
 				for id, size := range c.CacheSize {
 					if tc.Cache == nil {
@@ -165,16 +174,21 @@ func Initialize() error {
 }
 
 type tomlConfig struct {
-	Server     localConfig
-	Email      dvid.EmailConfig
-	Logging    dvid.LogConfig
-	Mutations  MutationsConfig
-	Kafka      storage.KafkaConfig
-	Store      map[storage.Alias]storeConfig
-	Backend    map[dvid.DataSpecifier]backendConfig
-	Cache      map[string]sizeConfig
-	Groupcache storage.GroupcacheConfig
-	Mirror     map[dvid.DataSpecifier]mirrorConfig
+	Server      localConfig
+	Email       dvid.EmailConfig
+	Logging     dvid.LogConfig
+	Mutations   MutationsConfig
+	Kafka       storage.KafkaConfig
+	Store       map[storage.Alias]storeConfig
+	Backend     map[dvid.DataSpecifier]backendConfig
+	Cache       map[string]sizeConfig
+	Groupcache  storage.GroupcacheConfig
+	Mirror      map[dvid.DataSpecifier]mirrorConfig
+	Mirroring   MirroringConfig
+	Replication ReplicationConfig
+	Backup      BackupConfig
+	Auth        AuthConfig
+	Generators  map[string]generatorConfig
 }
 
 // Some settings in the TOML can be given as relative paths.
@@ -197,6 +211,14 @@ func (c *tomlConfig) convertPathsToAbsolute(configPath string) error {
 		return fmt.Errorf("Error converting logfile setting to absolute path")
 	}
 
+	// [backup].dir
+	if c.Backup.Dir != "" {
+		c.Backup.Dir, err = dvid.ConvertToAbsolute(c.Backup.Dir, configDir)
+		if err != nil {
+			return fmt.Errorf("Error converting backup dir setting to absolute path")
+		}
+	}
+
 	// [store.foobar].path
 	for alias, sc := range c.Store {
 		p, ok := sc["path"]
@@ -294,6 +316,11 @@ func MutationLogSpec() MutationsConfig {
 	return tc.Mutations
 }
 
+// AuthSpec returns the authentication and access control configuration.
+func AuthSpec() AuthConfig {
+	return tc.Auth
+}
+
 func repoMirrors(dataUUID, versionUUID dvid.UUID) []string {
 	if len(tc.Mirror) == 0 {
 		return nil
@@ -322,6 +349,15 @@ func instanceMirrors(dataUUID, versionUUID dvid.UUID) []string {
 	return nil
 }
 
+// GeneratorCommand returns the command line given in the server configuration for the
+// named generator or an empty string if there is no such generator.
+func GeneratorCommand(name string) string {
+	if tc.Generators == nil {
+		return ""
+	}
+	return tc.Generators[name].Command
+}
+
 // CacheSize returns the number oF bytes reserved for the given identifier.
 // If unset, will return 0.
 func CacheSize(id string) int {
@@ -361,6 +397,8 @@ type localConfig struct {
 	StartWebhook       string // http address that should be called when server is started up.
 	StartJaneliaConfig string // like StartWebhook, but with Janelia-specific behavior
 
+	AllowedOrigins []string `toml:"allowed_origins"` // Origins of browser pages besides this server allowed to open WebSockets, or "*" for any.
+
 	IIDGen   string `toml:"instance_id_gen"`
 	IIDStart uint32 `toml:"instance_id_start"`
 
@@ -395,6 +433,10 @@ type mirrorConfig struct {
 	Servers []string
 }
 
+type generatorConfig struct {
+	Command string
+}
+
 // LoadConfig loads DVID server configuration from a TOML file.
 func LoadConfig(filename string) error {
 	if filename == "" {
@@ -496,6 +538,12 @@ func Serve() {
 	dvid.TimeInfof("Using web client files from %s\n", tc.Server.WebClient)
 	dvid.TimeInfof("Using %d of %d logical CPUs for DVID.\n", dvid.NumCPU, runtime.NumCPU())
 
+	// Resume delivery of any POSTs queued for mirrors.
+	startMirrors()
+
+	// Keep any repos replicated from a leader up-to-date.
+	startReplication()
+
 	// Launch the web server
 	go serveHTTP()
 
@@ -146,6 +146,27 @@ func TestParseConfig(t *testing.T) {
 		t.Errorf("Bad Kafka config: %v\n", kafkaCfg)
 	}
 
+	mirroringCfg := tc.Mirroring
+	if mirroringCfg.Outbox != "mutationlog" || mirroringCfg.MaxRetries != 20 || mirroringCfg.RetryBase != 1 || mirroringCfg.RetryMax != 300 {
+		t.Errorf("Bad mirroring config: %v\n", mirroringCfg)
+	}
+
+	replicationCfg := tc.Replication
+	if replicationCfg.Leader != "leader.janelia.org:8001" || len(replicationCfg.Repos) != 1 || replicationCfg.Repos[0] != "99ef22cd85f143f58a623bd22aad0ef7" || replicationCfg.Interval != 10 {
+		t.Errorf("Bad replication config: %v\n", replicationCfg)
+	}
+
+	if tc.Backup.Dir != "/data/dvid-backups" {
+		t.Errorf("Bad backup config: %v\n", tc.Backup)
+	}
+
+	if cmd := GeneratorCommand("meshes"); cmd != "/opt/dvid/bin/supervoxel-mesh --format drc" {
+		t.Errorf("Bad generator command: %q\n", cmd)
+	}
+	if cmd := GeneratorCommand("unknown"); cmd != "" {
+		t.Errorf("Expected no command for unknown generator, got %q\n", cmd)
+	}
+
 	if len(tc.Mirror) != 2 {
 		t.Errorf("Bad mirror config: %v\n", tc.Mirror)
 	}
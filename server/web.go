@@ -74,6 +74,18 @@ const webHelp = `
 		The online documentation doesn't show the server host prefixed to the "/api/..." URL,
 		but it is required.
 
+		<p>If the server configuration has an [auth] section, requests to repo, node, data instance,
+		and server endpoints require a bearer token unless anonymous access is allowed:
+		<pre>
+Authorization: Bearer &lt;JWT signed with HS256 using the configured secret&gt;
+		</pre>
+		The token's "sub" claim is the user, which is given read, write, or admin roles for repos
+		and data instances by the configuration.  GET and HEAD requests need read access, other
+		requests need write access, and creating data instances, merging versions, and changing
+		server settings need admin access.  Missing or invalid tokens receive Unauthorized (401)
+		and insufficient roles receive Forbidden (403).  The authenticated user is recorded in the
+		activity log as "auth_user" alongside any "u" query string user.</p>
+
 		<h4>General commands</h4>
 
 		<pre>
@@ -147,6 +159,118 @@ GET /api/user-latencies[?u=<username]
  	Returns JSON for groupcache statistics for this server.  See github.com/golang/groupcache package
 	Stats and CacheStats for MainCache and HotCache.
 
+ GET  /api/server/mirrors
+
+	Returns JSON with the delivery status of each mirror receiving POSTs from this server:
+	[
+		{
+			"Host": "http://mirror1.janelia.org:7000",
+			"Durable": true,
+			"Queued": 3,
+			"QueuedBytes": 125829,
+			"LagSeconds": 12.5,
+			"Delivered": 1021,
+			"FailedAttempts": 4,
+			"Stalled": [],
+			"LastError": "status 503: ...",
+			"LastErrorTime": "2019-03-05T14:12:10-05:00"
+		},
+		...
+	]
+
+	"Queued" POSTs have not yet been accepted by the mirror and "LagSeconds" is the age of 
+	the oldest one.  "Stalled" lists data UUIDs whose delivery stopped after the maximum
+	number of retries.  Use the "mirrors replay" command to resume delivery.
+
+GET  /api/server/replication
+
+	Returns JSON with the progress of each repo replicated from a leader DVID server:
+	[
+		{
+			"Leader": "leader.janelia.org:8001",
+			"UUID": "99ef22cd85f143f58a623bd22aad0ef7",
+			"Root": "28841c8277e044a7b187dda03e18da13",
+			"Connected": true,
+			"Versions": 12,
+			"CopiedVersions": 11,
+			"OpenVersions": ["99ef22cd85f143f58a623bd22aad0ef7"],
+			"KeyValuesCopied": 1802237,
+			"LogMessagesCopied": 5120,
+			"BytesCopied": 9237812301,
+			"MutationsReplayed": 420,
+			"LastSync": "2019-03-05T14:12:10-05:00"
+		},
+		...
+	]
+
+	Committed versions, including the logs of data instances like labelmap mappings, are
+	copied from the leader once, and "Copying" gives any version whose copy is in progress.
+	"OpenVersions" are kept up-to-date by replaying the leader's mutation log.  Replicated
+	repos only accept GET and HEAD requests.
+
+GET  /api/server/backup
+
+	Returns JSON with the status of any running backup and the points in the backup
+	directory set in the [backup] configuration:
+	{
+		"Status": {
+			"Running": true,
+			"Dir": "/data/dvid-backups",
+			"ID": 1000213877,
+			"Started": "2019-03-05T14:12:10-05:00",
+			...
+		},
+		"Points": [
+			{
+				"ID": 1000200412,
+				"Parent": 0,
+				"Created": "2019-03-04T02:00:01-05:00",
+				"MutationIDs": { "28841c8277e044a7b187dda03e18da13": 1000200412 },
+				"Stores": [
+					{
+						"Alias": "raid6",
+						"Description": "basho-tuned leveldb @ /data/dvid",
+						"Consistent": true,
+						"KeyValues": 1802237,
+						"Puts": 1802237,
+						"Deletes": 0,
+						"Bytes": 9237812301
+					},
+					{
+						"Alias": "mutationlog",
+						"Description": "write logs @ /data/dvid-logs",
+						"Log": true,
+						"Consistent": true,
+						"KeyValues": 0,
+						"Puts": 0,
+						"Logs": 43,
+						"Messages": 81223,
+						"Deletes": 0,
+						"Bytes": 19872310
+					}
+				]
+			},
+			...
+		]
+	}
+
+	Each point's ID is the highest mutation ID issued across repos when the point was
+	taken.  A point with a "Parent" only holds the changes since that point.  Log stores
+	are backed up by the length of each log, so a point only holds the messages appended
+	since its parent.
+
+POST  /api/server/backup
+
+	Starts an online backup of all stores into a new point in the [backup] directory and
+	returns JSON with the ID of the point, e.g., {"ID": 1000213877}.  The point is written
+	in the background.  The point is incremental unless the query string "full=true" is
+	given or there are no earlier points.  Use the "dvid restore" command to rebuild a
+	server from a backup point.  The backup fails if any store can't be backed up.
+
+	Query-string Options:
+
+	full      If "true", writes all key-values instead of changes since the last point.
+
 POST  /api/server/settings
 
 	Sets server parameters.  Expects JSON to be posted with optional keys denoting parameters:
@@ -294,11 +418,33 @@ Repo-Level REST endpoints
 
 	The elements of the JSON object are:
 
-		mergeType:  must be "conflict-free".  We will introduce other merge options in future.
+		mergeType:  either "conflict-free" or "type-specific" (see below).
 		parents:    a list of the parent UUIDs to be merged. 
 		note:       any note that should be set for the child version.
 
-	A JSON response will be sent with the following format:
+	A "type-specific" merge asks each data instance that supports it (currently labelmap)
+	to do a three-way merge of the changes made in each parent since their closest common
+	ancestor.  Data instances that don't support type-specific merges are merged as if
+	"conflict-free".  If any data instance has changes that can't be automatically merged,
+	no child is created and a Conflict (409) status is returned with a JSON report of the
+	conflicts for each data instance:
+
+	{
+		"conflicts": {
+			"segmentation": {
+				"labels": [ 23, 109 ],
+				"supervoxels": [ 1004 ],
+				"blocks": [ [10, 3, 7] ],
+				"mutation_ids": [ 1000018, 1000023 ]
+			}
+		}
+	}
+
+	For labelmap instances, "labels" and "supervoxels" were modified in more than one parent,
+	"blocks" are the scale 0 block coordinates written in more than one parent, and
+	"mutation_ids" are the mutations in any parent that touched the conflicting labels.
+
+	If the merge succeeds, a JSON response will be sent with the following format:
 
 	{ "child": "3f01a8856" }
 
@@ -482,6 +628,36 @@ Node-Level REST endpoints
 
 	Note that POST /blobstore will not be logged in any associated kafka system.
 
+ GET /api/node/{uuid}/{data name}/events[?queryopts]
+
+	Streams the mutation messages of the given data instance, i.e., the same JSON messages
+	sent to kafka, so clients can follow changes without a kafka system.  If the request is
+	a WebSocket upgrade, each event is sent as a JSON object:
+
+	{ "Cursor": <cursor>, "MutationID": <message mutation ID>, "Action": <message action>,
+	  "Event": <message JSON> }
+
+	Otherwise events are sent as Server-Sent Events (text/event-stream) with the cursor as
+	the event id, the message action as the event type, and the message JSON as the data.
+	WebSocket requests from browser pages are only accepted from this server's origin or the
+	"allowed_origins" in the [server] configuration.
+
+	Each event's cursor is a sequence number that increases with every event of the data
+	instance, since one mutation can produce several messages with the same "MutationID".
+	Recent events are retained in memory once the first stream for a data instance is opened,
+	so clients can resume after a dropped connection by giving the last received cursor.  A
+	410 (Gone) status is returned if the events after the cursor are no longer retained or the
+	cursor wasn't sent by this server, e.g., before a restart.  Events are not retained across
+	server restarts.
+
+	Query-string Options:
+
+	cursor        Send retained events after the event with this cursor before any new
+	                events.  Server-Sent Event clients can instead use the Last-Event-ID header.
+	label         Only send events involving one of the given comma-separated labels.
+	action        Only send events with one of the given comma-separated actions, e.g., "merge,split".
+	allversions   If "true", send events for all versions instead of just the given UUID.
+
 		</pre>
 
 		<h4>Data type commands</h4>
@@ -664,10 +840,11 @@ func initRoutes() {
 	mainMux.Get("/api/help/", helpHandler)
 	mainMux.Get("/api/help/:typename", typehelpHandler)
 
-	mainMux.Get("/api/storage", serverStorageHandler)
+	mainMux.Get("/api/storage", authServerHandler(false, serverStorageHandler))
 
 	serverMux := web.New()
 	mainMux.Handle("/api/server/:action", serverMux)
+	serverMux.Use(authHandler("settings", "reload-metadata", "backup"))
 	serverMux.Use(activityLogHandler)
 	serverMux.Get("/api/server/info", serverInfoHandler)
 	serverMux.Get("/api/server/info/", serverInfoHandler)
@@ -679,26 +856,36 @@ func initRoutes() {
 	serverMux.Get("/api/server/compiled-types/", serverCompiledTypesHandler)
 	serverMux.Get("/api/server/groupcache", serverGroupcacheHandler)
 	serverMux.Get("/api/server/groupcache/", serverGroupcacheHandler)
+	serverMux.Get("/api/server/mirrors", serverMirrorsHandler)
+	serverMux.Get("/api/server/mirrors/", serverMirrorsHandler)
+	serverMux.Get("/api/server/replication", serverReplicationHandler)
+	serverMux.Get("/api/server/replication/", serverReplicationHandler)
+	serverMux.Get("/api/server/backup", serverBackupHandler)
+	serverMux.Get("/api/server/backup/", serverBackupHandler)
+	serverMux.Post("/api/server/backup", serverBackupHandler)
+	serverMux.Post("/api/server/backup/", serverBackupHandler)
 	serverMux.Post("/api/server/settings", serverSettingsHandler)
 	serverMux.Post("/api/server/reload-metadata", serverReload)
 	serverMux.Post("/api/server/reload-metadata/", serverReload)
 	serverMux.Get("/api/server/blobstore/:ref", blobstoreHandler)
 
 	if !readonly {
-		mainMux.Post("/api/repos", reposPostHandler)
+		mainMux.Post("/api/repos", authServerHandler(true, reposPostHandler))
 	}
-	mainMux.Get("/api/repos/info", reposInfoHandler)
+	mainMux.Get("/api/repos/info", authServerHandler(false, reposInfoHandler))
 
 	repoRawMux := web.New()
 	mainMux.Handle("/api/repo/:uuid", repoRawMux)
 	repoRawMux.Use(activityLogHandler)
 	repoRawMux.Use(repoRawSelector)
+	repoRawMux.Use(authHandler())
 	repoRawMux.Head("/api/repo/:uuid", repoHeadHandler)
 
 	repoMux := web.New()
 	mainMux.Handle("/api/repo/:uuid/:action", repoMux)
 	mainMux.Handle("/api/repo/:uuid/:action/:name", repoMux)
 	repoMux.Use(repoRawSelector)
+	repoMux.Use(authHandler("instance", "merge", "resolve"))
 	repoMux.Use(mutationsHandler)
 	repoMux.Use(activityLogHandler)
 	repoMux.Use(repoSelector)
@@ -714,6 +901,7 @@ func initRoutes() {
 	mainMux.Handle("/api/node/:uuid", nodeMux)
 	mainMux.Handle("/api/node/:uuid/:action", nodeMux)
 	nodeMux.Use(repoRawSelector)
+	nodeMux.Use(authHandler())
 	nodeMux.Use(mutationsHandler)
 	nodeMux.Use(activityLogHandler)
 	nodeMux.Use(nodeSelector)
@@ -731,6 +919,7 @@ func initRoutes() {
 	mainMux.Handle("/api/node/:uuid/:dataname/:keyword", instanceMux)
 	mainMux.Handle("/api/node/:uuid/:dataname/:keyword/*", instanceMux)
 	instanceMux.Use(repoRawSelector)
+	instanceMux.Use(authHandler())
 	instanceMux.Use(mutationsHandler)
 	instanceMux.Use(instanceSelector)
 	instanceMux.NotFound(notFound)
@@ -772,6 +961,14 @@ func (w *wrappedResponseWriter) Write(buf []byte) (int, error) {
 	return n, err
 }
 
+// Flush sends any buffered data to the client if the wrapped writer supports it, e.g.,
+// for streamed events.
+func (w *wrappedResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func wrapResponseWriter(w http.ResponseWriter) *wrappedResponseWriter {
 	wr := wrappedResponseWriter{
 		ResponseWriter: w,
@@ -811,7 +1008,9 @@ func recoverHandler(c *web.C, h http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-// Middleware that logs all mutations to any configured mutation log
+// Middleware that logs all mutations to any configured mutation log.  Requests are logged
+// after they are handled so the log includes the response status and any IDs, e.g., new
+// labels, assigned while handling the request, which replicas need for replay.
 func mutationsHandler(c *web.C, h http.Handler) http.Handler {
 	mutConfig := MutationLogSpec()
 	fn := func(w http.ResponseWriter, r *http.Request) {
@@ -845,10 +1044,18 @@ func mutationsHandler(c *web.C, h http.Handler) http.Handler {
 				}
 				dataID = data.DataUUID()
 			}
-			if err := LogMutation(uuid, dataID, r, buf); err != nil {
-				BadRequest(w, r, err)
-				return
+			assignments := new(mutationAssignments)
+			r = withMutationAssignments(r, assignments)
+			myw := wrapResponseWriter(w)
+			h.ServeHTTP(myw, r)
+			status := myw.status
+			if !myw.wroteHeader {
+				status = http.StatusOK
+			}
+			if err := LogMutation(uuid, dataID, r, buf, status, assignments.assigned); err != nil {
+				dvid.Errorf("unable to log mutation %s %s: %v\n", r.Method, r.RequestURI, err)
 			}
+			return
 		}
 		h.ServeHTTP(w, r)
 	}
@@ -877,6 +1084,9 @@ func activityLogHandler(c *web.C, h http.Handler) http.Handler {
 				"bytes_out":   myw.bytes,
 				"remote_addr": r.RemoteAddr,
 			}
+			if authUser, ok := c.Env["authUser"].(string); ok && authUser != "" {
+				activity["auth_user"] = authUser
+			}
 			storage.LogActivityToKafka(activity)
 		}
 	}
@@ -993,6 +1203,12 @@ func repoRawSelector(c *web.C, h http.Handler) http.Handler {
 			BadRequest(w, r, err)
 			return
 		}
+		if method != "get" && method != "head" {
+			if leader := replicaLeader(uuid); leader != "" {
+				BadRequest(w, r, "Repo with version %s is replicated from %s and will only accept GET and HEAD requests", uuid, leader)
+				return
+			}
+		}
 		c.Env["uuid"] = uuid
 		c.Env["name"] = c.URLParams["name"]
 
@@ -1114,6 +1330,12 @@ func instanceSelector(c *web.C, h http.Handler) http.Handler {
 			return
 		}
 
+		// handle change feed requests for any data instance
+		if c.URLParams["keyword"] == "events" {
+			serveEvents(w, r, data, uuid)
+			return
+		}
+
 		v, err := datastore.VersionFromUUID(uuid)
 		if err != nil {
 			BadRequest(w, r, err)
@@ -1157,32 +1379,21 @@ func instanceSelector(c *web.C, h http.Handler) http.Handler {
 			w.Header().Set("Timing-Allow-Origin", "*")
 		}
 
-		if method == "post" {
-			mirrors := instanceMirrors(data.DataUUID(), uuid)
-			if len(mirrors) > 0 {
-				buf, err := ioutil.ReadAll(r.Body)
-				if err != nil {
-					BadRequest(w, r, "unable to read POST for mirroring: %v", err)
-					return
-				}
-				r.Body = ioutil.NopCloser(bytes.NewBuffer(buf))
-				contentType := r.Header.Get("Content-Type")
-				for _, hostAndPort := range mirrors {
-					url := hostAndPort + r.URL.Path
-					dvid.Infof("echoing POST asynchronously to %s\n", url)
-					go func(url string) {
-						resp, err := http.Post(url, contentType, bytes.NewBuffer(buf))
-						if err != nil {
-							dvid.Errorf("problem echoing POST (%s): %v\n", url, err)
-						} else {
-							dvid.Infof("echoed POST: %s (status %d)\n", url, resp.StatusCode)
-						}
-					}(url)
-				}
+		var mirrorBody []byte
+		mirrored := method == "post" && len(instanceMirrors(data.DataUUID(), uuid)) > 0
+		if mirrored {
+			var err error
+			if mirrorBody, err = ioutil.ReadAll(r.Body); err != nil {
+				BadRequest(w, r, "unable to read POST for mirroring: %v", err)
+				return
 			}
+			r.Body = ioutil.NopCloser(bytes.NewBuffer(mirrorBody))
 		}
 		myw := wrapResponseWriter(w)
 		activity := data.ServeHTTP(uuid, ctx, myw, r)
+		if mirrored && myw.status < 300 {
+			mirrorPOST(data.DataUUID(), uuid, r, mirrorBody)
+		}
 		if KafkaAvailable() {
 			user := r.URL.Query().Get("u")
 			app := r.URL.Query().Get("app")
@@ -1199,6 +1410,9 @@ func instanceSelector(c *web.C, h http.Handler) http.Handler {
 				"bytes_out":   myw.bytes,
 				"remote_addr": r.RemoteAddr,
 			}
+			if authUser, ok := c.Env["authUser"].(string); ok && authUser != "" {
+				data["auth_user"] = authUser
+			}
 			if len(activity) > 0 {
 				for k, v := range activity {
 					data[k] = v
@@ -1427,6 +1641,53 @@ func serverGroupcacheHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, string(m))
 }
 
+func serverMirrorsHandler(w http.ResponseWriter, r *http.Request) {
+	m, err := json.Marshal(MirrorsStatus())
+	if err != nil {
+		BadRequest(w, r, fmt.Sprintf("Cannot marshal JSON mirror status: %v\n", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(m))
+}
+
+func serverReplicationHandler(w http.ResponseWriter, r *http.Request) {
+	m, err := json.Marshal(ReplicationStatus())
+	if err != nil {
+		BadRequest(w, r, fmt.Sprintf("Cannot marshal JSON replication status: %v\n", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(m))
+}
+
+func serverBackupHandler(w http.ResponseWriter, r *http.Request) {
+	var m []byte
+	var err error
+	if strings.ToLower(r.Method) == "post" {
+		full := r.URL.Query().Get("full") == "true"
+		var id uint64
+		if id, err = StartBackup("", full); err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+		m, err = json.Marshal(map[string]uint64{"ID": id})
+	} else {
+		var info *BackupInfo
+		if info, err = GetBackupInfo(""); err != nil {
+			BadRequest(w, r, err)
+			return
+		}
+		m, err = json.Marshal(info)
+	}
+	if err != nil {
+		BadRequest(w, r, fmt.Sprintf("Cannot marshal JSON backup info: %v\n", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(m))
+}
+
 func serverSettingsHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	config := dvid.NewConfig()
 	if err := config.SetByJSON(r.Body); err != nil {
@@ -2059,14 +2320,28 @@ func repoMergeHandler(c web.C, w http.ResponseWriter, r *http.Request) {
 	switch jsonData.MergeType {
 	case "conflict-free":
 		mt = datastore.MergeConflictFree
+	case "type-specific":
+		mt = datastore.MergeTypeSpecificAuto
 	default:
-		BadRequest(w, r, fmt.Sprintf("'mergeType' must be 'conflict-free' at this time"))
+		BadRequest(w, r, fmt.Sprintf("'mergeType' must be 'conflict-free' or 'type-specific'"))
 		return
 	}
 
 	// Do the merge
 	newuuid, err := datastore.Merge(parents, jsonData.Note, mt)
 	if err != nil {
+		if conflictErr, ok := err.(*datastore.MergeConflictError); ok {
+			jsonBytes, err := json.Marshal(map[string]interface{}{"conflicts": conflictErr.Conflicts})
+			if err != nil {
+				BadRequest(w, r, err)
+				return
+			}
+			dvid.Infof("Merge of %v aborted due to conflicts: %s\n", parents, string(jsonBytes))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			w.Write(jsonBytes)
+			return
+		}
 		BadRequest(w, r, err)
 	} else {
 		w.Header().Set("Content-Type", "application/json")
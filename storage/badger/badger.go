@@ -737,39 +737,65 @@ func (db *BadgerDB) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out c
 	if db == nil {
 		return fmt.Errorf("Can't call RawRangeQuery on nil BadgerDB")
 	}
-	err := db.bdp.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		if keysOnly {
-			opts.PrefetchValues = false
+	return db.bdp.View(func(txn *badger.Txn) error {
+		return rawRangeQuery(txn, kStart, kEnd, keysOnly, out, cancel)
+	})
+}
+
+func rawRangeQuery(txn *badger.Txn, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	opts := badger.DefaultIteratorOptions
+	if keysOnly {
+		opts.PrefetchValues = false
+	}
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(kStart); it.Valid(); it.Next() {
+		kv := new(storage.KeyValue)
+		item := it.Item()
+		kv.K = item.KeyCopy(nil)
+		storage.StoreKeyBytesRead <- len(kv.K)
+		// Did we pass the final key?
+		if bytes.Compare(kv.K, kEnd) > 0 {
+			break
 		}
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Seek(kStart); it.Valid(); it.Next() {
-			kv := new(storage.KeyValue)
-			item := it.Item()
-			kv.K = item.KeyCopy(nil)
-			storage.StoreKeyBytesRead <- len(kv.K)
-			// Did we pass the final key?
-			if bytes.Compare(kv.K, kEnd) > 0 {
-				break
-			}
-			if !keysOnly {
-				var err error
-				if kv.V, err = item.ValueCopy(nil); err != nil {
-					return err
-				}
-				storage.StoreValueBytesRead <- len(kv.V)
-			}
-			select {
-			case out <- kv:
-			case <-cancel:
-				return nil
+		if !keysOnly {
+			var err error
+			if kv.V, err = item.ValueCopy(nil); err != nil {
+				return err
 			}
+			storage.StoreValueBytesRead <- len(kv.V)
 		}
-		out <- nil
-		return nil
-	})
-	return err
+		select {
+		case out <- kv:
+		case <-cancel:
+			return nil
+		}
+	}
+	out <- nil
+	return nil
+}
+
+// ---- KeyValueSnapshotter interface ------
+
+// badgerSnapshot is a read-only transaction, which sees the database as of its creation.
+type badgerSnapshot struct {
+	txn *badger.Txn
+}
+
+// NewSnapshot returns a consistent view of the database at the current time.
+func (db *BadgerDB) NewSnapshot() (storage.KeyValueSnapshot, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call NewSnapshot on nil BadgerDB")
+	}
+	return &badgerSnapshot{db.bdp.NewTransaction(false)}, nil
+}
+
+func (s *badgerSnapshot) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	return rawRangeQuery(s.txn, kStart, kEnd, keysOnly, out, cancel)
+}
+
+func (s *badgerSnapshot) Release() {
+	s.txn.Discard()
 }
 
 // ---- KeyValueSetter interface ------
@@ -888,8 +888,11 @@ func (db *LevelDB) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out ch
 	if db == nil {
 		return fmt.Errorf("Can't call RawRangeQuery on nil LevelDB")
 	}
+	return db.rawRangeQuery(levigo.NewReadOptions(), kStart, kEnd, keysOnly, out, cancel)
+}
+
+func (db *LevelDB) rawRangeQuery(ro *levigo.ReadOptions, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
 	dvid.StartCgo()
-	ro := levigo.NewReadOptions()
 	it := db.ldb.NewIterator(ro)
 	defer func() {
 		it.Close()
@@ -929,6 +932,38 @@ func (db *LevelDB) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out ch
 	return nil
 }
 
+// ---- KeyValueSnapshotter interface ------
+
+type levelSnapshot struct {
+	db   *LevelDB
+	snap *levigo.Snapshot
+	ro   *levigo.ReadOptions
+}
+
+// NewSnapshot returns a consistent view of the database at the current time.
+func (db *LevelDB) NewSnapshot() (storage.KeyValueSnapshot, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call NewSnapshot on nil LevelDB")
+	}
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	s := &levelSnapshot{db: db, snap: db.ldb.NewSnapshot(), ro: levigo.NewReadOptions()}
+	s.ro.SetSnapshot(s.snap)
+	s.ro.SetFillCache(false)
+	return s, nil
+}
+
+func (s *levelSnapshot) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	return s.db.rawRangeQuery(s.ro, kStart, kEnd, keysOnly, out, cancel)
+}
+
+func (s *levelSnapshot) Release() {
+	dvid.StartCgo()
+	s.ro.Close()
+	s.db.ldb.ReleaseSnapshot(s.snap)
+	dvid.StopCgo()
+}
+
 // ---- KeyValueSetter interface ------
 
 // Put writes a value with given key.
@@ -1,6 +1,7 @@
 package filelog
 
 import (
+	"bufio"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -162,7 +163,15 @@ type fileLogs struct {
 }
 
 func (flogs *fileLogs) ReadAll(dataID, version dvid.UUID) ([]storage.LogMessage, error) {
-	k := string(dataID + "-" + version)
+	return flogs.readAll(string(dataID + "-" + version))
+}
+
+// TopicReadAll reads all messages appended to a topic.
+func (flogs *fileLogs) TopicReadAll(topic string) ([]storage.LogMessage, error) {
+	return flogs.readAll(topic)
+}
+
+func (flogs *fileLogs) readAll(k string) ([]storage.LogMessage, error) {
 	filename := filepath.Join(flogs.path, k)
 
 	flogs.RLock()
@@ -279,6 +288,59 @@ restart:
 	return err
 }
 
+// ReadFrom returns up to max messages from the log of a data instance and version,
+// starting at the given byte offset, and the byte offset after each message returned.
+func (flogs *fileLogs) ReadFrom(dataID, version dvid.UUID, offset int64, max int) ([]storage.LogMessage, []int64, error) {
+	return flogs.readFrom(string(dataID+"-"+version), offset, max)
+}
+
+// TopicReadFrom returns up to max messages from a topic starting at the given byte offset,
+// and the byte offset after each message returned.
+func (flogs *fileLogs) TopicReadFrom(topic string, offset int64, max int) ([]storage.LogMessage, []int64, error) {
+	return flogs.readFrom(topic, offset, max)
+}
+
+// readFrom reads messages without closing any write log since appends are only visible
+// to readers once written, and a partially appended message at the end is not returned.
+func (flogs *fileLogs) readFrom(k string, offset int64, max int) ([]storage.LogMessage, []int64, error) {
+	f, err := os.Open(filepath.Join(flogs.path, k))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(f)
+	var msgs []storage.LogMessage
+	var ends []int64
+	hdrbuf := make([]byte, 6)
+	for len(msgs) < max {
+		if _, err := io.ReadFull(r, hdrbuf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, nil, err
+		}
+		entryType := binary.LittleEndian.Uint16(hdrbuf[0:2])
+		size := binary.LittleEndian.Uint32(hdrbuf[2:])
+		databuf := make([]byte, size)
+		if _, err := io.ReadFull(r, databuf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, nil, err
+		}
+		offset += int64(len(hdrbuf)) + int64(size)
+		msgs = append(msgs, storage.LogMessage{EntryType: entryType, Data: databuf})
+		ends = append(ends, offset)
+	}
+	return msgs, ends, nil
+}
+
 func (flogs *fileLogs) getWriteLog(topic string) (fl *fileLog, err error) {
 	var found bool
 	flogs.RLock()
@@ -361,6 +423,43 @@ func (flogs *fileLogs) TopicClose(topic string) error {
 	return flogs.closeWriteLog(topic)
 }
 
+// TopicSizes returns the size in bytes of every log keyed by topic, where the topic of the
+// log of a data instance and version is "<data UUID>-<version UUID>".
+func (flogs *fileLogs) TopicSizes() (map[string]int64, error) {
+	entries, err := ioutil.ReadDir(flogs.path)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64, len(entries))
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() {
+			sizes[entry.Name()] = entry.Size()
+		}
+	}
+	return sizes, nil
+}
+
+// Delete removes the log of a data instance and version.
+func (flogs *fileLogs) Delete(dataID, version dvid.UUID) error {
+	return flogs.deleteLog(string(dataID + "-" + version))
+}
+
+// TopicDelete removes the log of a topic.
+func (flogs *fileLogs) TopicDelete(topic string) error {
+	return flogs.deleteLog(topic)
+}
+
+func (flogs *fileLogs) deleteLog(k string) error {
+	if err := flogs.closeWriteLog(k); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(flogs.path, k))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
 func (flogs *fileLogs) Close() {
 	flogs.Lock()
 	for _, flogs := range flogs.files {
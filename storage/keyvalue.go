@@ -322,6 +322,23 @@ type TransactionDB interface {
 	Patch(Context, TKey, PatchFunc) error
 }
 
+// KeyValueSnapshotter is an ordered key-value store that can provide a consistent,
+// read-only view of all its key-value pairs at a point in time, e.g., for online backups.
+type KeyValueSnapshotter interface {
+	NewSnapshot() (KeyValueSnapshot, error)
+}
+
+// KeyValueSnapshot is a point-in-time view of a store that is unaffected by later
+// writes.  Release must be called when the snapshot is no longer needed.
+type KeyValueSnapshot interface {
+	// RawRangeQuery sends a range of full keys as they were when the snapshot was taken.
+	// A nil is sent down the channel when the range is complete.  The query can be
+	// cancelled by sending a value down the cancel channel.
+	RawRangeQuery(kStart, kEnd Key, keysOnly bool, out chan *KeyValue, cancel <-chan struct{}) error
+
+	Release()
+}
+
 // RequestBufferSubset implements a subset of the ordered key/value interface.
 // It declares interface common to both ordered key value and RequestBuffer
 type BufferableOps interface {
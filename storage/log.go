@@ -29,9 +29,40 @@ type ReadLog interface {
 	dvid.Store
 	ReadBinary(dataID, version dvid.UUID) ([]byte, error)
 	ReadAll(dataID, version dvid.UUID) ([]LogMessage, error)
+	TopicReadAll(topic string) ([]LogMessage, error)
 	StreamAll(dataID, version dvid.UUID, ch chan LogMessage, wg *sync.WaitGroup) error
 }
 
+// OffsetReadLog is a ReadLog that can also read messages starting at a byte offset, so
+// large logs can be read incrementally without holding them in memory.
+type OffsetReadLog interface {
+	ReadLog
+
+	// ReadFrom returns up to max messages from the log of a data instance and version,
+	// starting at the given byte offset, and the byte offset after each message returned.
+	ReadFrom(dataID, version dvid.UUID, offset int64, max int) (msgs []LogMessage, ends []int64, err error)
+
+	// TopicReadFrom returns up to max messages from a topic starting at the given byte
+	// offset, and the byte offset after each message returned.
+	TopicReadFrom(topic string, offset int64, max int) (msgs []LogMessage, ends []int64, err error)
+}
+
+// LogSizer is a log store that can list its logs and their sizes in bytes.  Since logs are
+// append-only, the sizes give a point-in-time view of the store, e.g., for backups.
+type LogSizer interface {
+	// TopicSizes returns the size of every log in the store keyed by topic.  The log of a
+	// data instance and version is also listed under a topic, which can be used with any
+	// Topic method to read or write the log.
+	TopicSizes() (map[string]int64, error)
+}
+
+// DeletableLog is a WriteLog whose logs can be deleted.
+type DeletableLog interface {
+	WriteLog
+	Delete(dataID, version dvid.UUID) error
+	TopicDelete(topic string) error
+}
+
 type LogReadable interface {
 	ReadLogRequired() bool
 	GetReadLog() ReadLog
@@ -0,0 +1,1274 @@
+// +build pebble
+
+package pebble
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	humanize "github.com/janelia-flyem/go/go-humanize"
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/bloom"
+)
+
+// Pebble is a pure-Go LSM key-value store modeled on RocksDB, so unlike basholeveldb it
+// requires no cgo and can be used in static builds.  The defaults mirror the basholeveldb
+// tuning where pebble has an equivalent setting.
+const (
+	// Default size of the block cache shared across all levels.
+	DefaultCacheSize = 536870912
+
+	// Default # bits for Bloom Filter.  The filter reduces the number of unnecessary
+	// disk reads needed for Get() calls by a large factor.
+	DefaultBloomBits = 16
+
+	// Number of open files that can be used by the datastore.
+	DefaultMaxOpenFiles = 1024
+
+	// Approximate size of uncompressed user data packed per block.
+	DefaultBlockSize = 64 * dvid.Kilo
+
+	// Size of each memtable before it is flushed to a sorted on-disk file.  Larger
+	// values increase performance during bulk loads at the cost of memory and a longer
+	// recovery time when the database is next opened.
+	DefaultMemTableSize = 62914560
+
+	// If true, each write is synced to disk before it is considered complete, which makes
+	// writes resilient to machine crashes at the cost of speed.
+	DefaultSync = false
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in pebble: %v\n", err)
+	}
+	e := Engine{"pebble", "Pebble pure-Go LSM", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return false
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns a pebble store. The passed Config must contain "path" string.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return e.newDB(config)
+}
+
+func parseConfig(config dvid.StoreConfig) (path string, testing bool, err error) {
+	c := config.GetAll()
+
+	v, found := c["path"]
+	if !found {
+		err = fmt.Errorf("%q must be specified for pebble configuration", "path")
+		return
+	}
+	var ok bool
+	path, ok = v.(string)
+	if !ok {
+		err = fmt.Errorf("%q setting must be a string (%v)", "path", v)
+		return
+	}
+	v, found = c["testing"]
+	if found {
+		testing, ok = v.(bool)
+		if !ok {
+			err = fmt.Errorf("%q setting must be a bool (%v)", "testing", v)
+			return
+		}
+	}
+	if testing {
+		path = filepath.Join(os.TempDir(), path)
+	}
+	return
+}
+
+// newDB returns a pebble backend, creating one at path if it doesn't exist.
+func (e Engine) newDB(config dvid.StoreConfig) (*PebbleDB, bool, error) {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Is there a database already at this path?  If not, create.
+	var created bool
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		dvid.TimeInfof("Database not already at path (%s). Creating directory...\n", path)
+		created = true
+		// Make a directory at the path.
+		if err := os.MkdirAll(path, 0744); err != nil {
+			return nil, true, fmt.Errorf("Can't make directory at %s: %v", path, err)
+		}
+	} else {
+		dvid.TimeInfof("Found directory at %s (err = %v)\n", path, err)
+	}
+
+	// Open the database
+	opts, err := getOptions(config.Config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pebbleDB := &PebbleDB{
+		directory: path,
+		config:    config,
+		options:   opts,
+	}
+
+	dvid.TimeInfof("Opening pebble @ path %s\n", path)
+	pdb, err := pebble.Open(path, opts.Options)
+	if err != nil {
+		opts.cache.Unref()
+		return nil, false, err
+	}
+	pebbleDB.pdb = pdb
+
+	// if we know it's newly created, just return.
+	if created {
+		return pebbleDB, created, nil
+	}
+
+	// otherwise, check if there's been any metadata or we need to initialize it.
+	metadataExists, err := pebbleDB.metadataExists()
+	if err != nil {
+		pebbleDB.Close()
+		return nil, false, err
+	}
+
+	return pebbleDB, !metadataExists, nil
+}
+
+// ---- RepairableEngine interface not implemented ------
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig add this engine to be used for testing.
+func (e Engine) AddTestConfig(backend *storage.Backend) (storage.Alias, error) {
+	alias := storage.Alias("pebble")
+	if backend.DefaultKVDB == "" {
+		backend.DefaultKVDB = alias
+	}
+	if backend.Metadata == "" {
+		backend.Metadata = alias
+	}
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"path":    fmt.Sprintf("dvid-test-pebble-%x", uuid.NewV4().Bytes()),
+		"testing": true,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "pebble"}
+	return alias, nil
+}
+
+// Delete implements the TestableEngine interface by providing a way to dispose
+// of testing databases.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Delete the directory if it exists
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("Can't delete old datastore %q: %v", path, err)
+		}
+	}
+	return nil
+}
+
+func (db *PebbleDB) String() string {
+	return fmt.Sprintf("pebble @ %s", db.directory)
+}
+
+// --- The PebbleDB Implementation must satisfy a Engine interface ----
+
+type PebbleDB struct {
+	// Directory of datastore
+	directory string
+
+	// Config at time of Open()
+	config dvid.StoreConfig
+
+	options *pebbleOptions
+	pdb     *pebble.DB
+}
+
+type pebbleOptions struct {
+	*pebble.Options
+	wo *pebble.WriteOptions
+
+	// Keep the cache for release on close.
+	cache *pebble.Cache
+}
+
+func getOptions(config dvid.Config) (*pebbleOptions, error) {
+	opts := &pebbleOptions{
+		Options: &pebble.Options{},
+		wo:      pebble.NoSync,
+	}
+
+	readOnly, found, err := config.GetBool("ReadOnly")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		opts.ReadOnly = readOnly
+	}
+
+	sync, found, err := config.GetBool("Sync")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		sync = DefaultSync
+	}
+	if sync {
+		opts.wo = pebble.Sync
+	}
+
+	bloomBits, found, err := config.GetInt("BloomFilterBitsPerKey")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		bloomBits = DefaultBloomBits
+	}
+
+	cacheSize, found, err := config.GetInt("CacheSize")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		cacheSize = DefaultCacheSize
+	} else {
+		cacheSize *= dvid.Mega
+	}
+	dvid.TimeInfof("pebble cache size: %s\n", humanize.Bytes(uint64(cacheSize)))
+
+	memTableSize, found, err := config.GetInt("WriteBufferSize")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		memTableSize = DefaultMemTableSize
+	} else {
+		memTableSize *= dvid.Mega
+	}
+	dvid.TimeInfof("pebble memtable size: %s\n", humanize.Bytes(uint64(memTableSize)))
+	opts.MemTableSize = uint64(memTableSize)
+
+	maxOpenFiles, found, err := config.GetInt("MaxOpenFiles")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		maxOpenFiles = DefaultMaxOpenFiles
+	}
+	opts.MaxOpenFiles = maxOpenFiles
+
+	blockSize, found, err := config.GetInt("BlockSize")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		blockSize = DefaultBlockSize
+	}
+
+	// Don't bother with compression on pebble side because it will be selectively
+	// applied on DVID side, as with basholeveldb.
+	opts.Levels = make([]pebble.LevelOptions, 7)
+	for i := range opts.Levels {
+		opts.Levels[i] = pebble.LevelOptions{
+			BlockSize:    blockSize,
+			FilterPolicy: bloom.FilterPolicy(bloomBits),
+			Compression:  pebble.NoCompression,
+		}
+	}
+
+	opts.cache = pebble.NewCache(int64(cacheSize))
+	opts.Cache = opts.cache
+	opts.EnsureDefaults()
+	return opts, nil
+}
+
+// Close closes the pebble store and releases its cache.
+func (db *PebbleDB) Close() {
+	if db != nil {
+		if db.pdb != nil {
+			if err := db.pdb.Close(); err != nil {
+				dvid.Errorf("Error closing %s: %v\n", db, err)
+			}
+		}
+		if db.options != nil && db.options.cache != nil {
+			db.options.cache.Unref()
+		}
+		db.pdb = nil
+		db.options = nil
+	}
+}
+
+// Equal returns true if the pebble store matches the given store configuration.
+func (db *PebbleDB) Equal(config dvid.StoreConfig) bool {
+	path, _, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.directory == path
+}
+
+func (db *PebbleDB) metadataExists() (bool, error) {
+	var ctx storage.MetadataContext
+	keyBeg, keyEnd := ctx.KeyRange()
+	it, err := db.pdb.NewIter(&pebble.IterOptions{LowerBound: keyBeg})
+	if err != nil {
+		return false, err
+	}
+	defer it.Close()
+
+	if it.SeekGE(keyBeg) && bytes.Compare(it.Key(), keyEnd) <= 0 {
+		return true, nil
+	}
+	if err := it.Error(); err != nil {
+		return false, err
+	}
+	dvid.TimeInfof("No metadata found for %s...\n", db)
+	return false, nil
+}
+
+// returns a copy of bytes owned by pebble, which are only valid until the next
+// iterator positioning or the release of a Get().
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// get returns the value of a full key or nil if the key isn't present.
+func (db *PebbleDB) get(key storage.Key) ([]byte, error) {
+	v, closer, err := db.pdb.Get(key)
+	if err == pebble.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	value := copyBytes(v)
+	if err := closer.Close(); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// ---- KeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *PebbleDB) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GET on nil PebbleDB")
+	}
+	if db.options == nil {
+		return nil, fmt.Errorf("Can't call GET on db with nil options: %v", db)
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+
+		// Get all versions of this key and return the most recent
+		values, err := db.getSingleKeyVersions(vctx, tk)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if kv != nil {
+			return kv.V, err
+		}
+		return nil, err
+	} else {
+		v, err := db.get(ctx.ConstructKey(tk))
+		storage.StoreValueBytesRead <- len(v)
+		return v, err
+	}
+}
+
+// Exists returns true if the key exists.
+func (db *PebbleDB) Exists(ctx storage.Context, tk storage.TKey) (found bool, err error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists() on nil PebbleDB")
+	}
+	if db.options == nil {
+		return false, fmt.Errorf("Can't call Exists() on db with nil options: %v", db)
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if ctx.Versioned() {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+		}
+		// Get all versions of this key and return the most recent
+		values, err := db.getSingleKeyVersions(vctx, tk)
+		if err != nil {
+			return false, err
+		}
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil {
+			return false, err
+		}
+		return kv != nil, nil
+	}
+	_, closer, err := db.pdb.Get(ctx.ConstructKey(tk))
+	if err == pebble.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, closer.Close()
+}
+
+// getSingleKeyVersions returns all versions of a key.  These key-value pairs will be sorted
+// in ascending key order and could include a tombstone key.
+func (db *PebbleDB) getSingleKeyVersions(vctx storage.VersionedCtx, tk []byte) ([]*storage.KeyValue, error) {
+	begKey, err := vctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := vctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	it, err := db.pdb.NewIter(&pebble.IterOptions{LowerBound: begKey})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	values := []*storage.KeyValue{}
+	for valid := it.SeekGE(begKey); valid; valid = it.Next() {
+		itKey := it.Key()
+		storage.StoreKeyBytesRead <- len(itKey)
+		if bytes.Compare(itKey, endKey) > 0 {
+			break
+		}
+		itValue := it.Value()
+		storage.StoreValueBytesRead <- len(itValue)
+		values = append(values, &storage.KeyValue{K: copyBytes(itKey), V: copyBytes(itValue)})
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+type errorableKV struct {
+	*storage.KeyValue
+	error
+}
+
+func sendKV(vctx storage.VersionedCtx, values []*storage.KeyValue, ch chan errorableKV) {
+	if len(values) != 0 {
+		kv, err := vctx.VersionedKeyValue(values)
+		if err != nil {
+			ch <- errorableKV{nil, err}
+			return
+		}
+		if kv != nil {
+			ch <- errorableKV{kv, nil}
+		}
+	}
+}
+
+// versionedRange sends a range of key-value pairs for a particular version down a channel.
+func (db *PebbleDB) versionedRange(vctx storage.VersionedCtx, begTKey, endTKey storage.TKey, ch chan errorableKV, done <-chan struct{}, keysOnly bool) {
+	minKey, err := vctx.MinVersionKey(begTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	maxKey, err := vctx.MaxVersionKey(endTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+
+	values := []*storage.KeyValue{}
+	maxVersionKey, err := vctx.MaxVersionKey(begTKey)
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+
+	it, err := db.pdb.NewIter(&pebble.IterOptions{LowerBound: minKey})
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	defer it.Close()
+
+	var itValue []byte
+	for valid := it.SeekGE(minKey); valid; valid = it.Next() {
+		select {
+		case <-done: // only happens if we don't care about rest of data.
+			ch <- errorableKV{nil, nil}
+			return
+		default:
+		}
+		if !keysOnly {
+			itValue = copyBytes(it.Value())
+			storage.StoreValueBytesRead <- len(itValue)
+		}
+		itKey := copyBytes(it.Key())
+		storage.StoreKeyBytesRead <- len(itKey)
+
+		// Did we pass all versions for last key read?
+		if bytes.Compare(itKey, maxVersionKey) > 0 {
+			if storage.Key(itKey).IsDataKey() {
+				indexBytes, err := storage.TKeyFromKey(itKey)
+				if err != nil {
+					ch <- errorableKV{nil, err}
+					return
+				}
+				maxVersionKey, err = vctx.MaxVersionKey(indexBytes)
+				if err != nil {
+					ch <- errorableKV{nil, err}
+					return
+				}
+			}
+			sendKV(vctx, values, ch)
+			values = []*storage.KeyValue{}
+		}
+		// Did we pass the final key?
+		if bytes.Compare(itKey, maxKey) > 0 {
+			if len(values) > 0 {
+				sendKV(vctx, values, ch)
+			}
+			ch <- errorableKV{nil, nil}
+			return
+		}
+		values = append(values, &storage.KeyValue{K: itKey, V: itValue})
+	}
+	if err = it.Error(); err != nil {
+		ch <- errorableKV{nil, err}
+	} else {
+		sendKV(vctx, values, ch)
+		ch <- errorableKV{nil, nil}
+	}
+}
+
+// unversionedRange sends a range of key-value pairs down a channel.
+func (db *PebbleDB) unversionedRange(ctx storage.Context, begTKey, endTKey storage.TKey, ch chan errorableKV, done <-chan struct{}, keysOnly bool) {
+	// Apply context if applicable
+	begKey := ctx.ConstructKey(begTKey)
+	endKey := ctx.ConstructKey(endTKey)
+
+	it, err := db.pdb.NewIter(&pebble.IterOptions{LowerBound: begKey})
+	if err != nil {
+		ch <- errorableKV{nil, err}
+		return
+	}
+	defer it.Close()
+
+	var itValue []byte
+	for valid := it.SeekGE(begKey); valid; valid = it.Next() {
+		if !keysOnly {
+			itValue = copyBytes(it.Value())
+			storage.StoreValueBytesRead <- len(itValue)
+		}
+		itKey := it.Key()
+		storage.StoreKeyBytesRead <- len(itKey)
+		// Did we pass the final key?
+		if bytes.Compare(itKey, endKey) > 0 {
+			break
+		}
+		select {
+		case <-done:
+			ch <- errorableKV{nil, nil}
+			return
+		case ch <- errorableKV{&storage.KeyValue{K: copyBytes(itKey), V: itValue}, nil}:
+		}
+	}
+	if err := it.Error(); err != nil {
+		ch <- errorableKV{nil, err}
+	} else {
+		ch <- errorableKV{nil, nil}
+	}
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *PebbleDB) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange on nil PebbleDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	ch := make(chan errorableKV)
+	done := make(chan struct{})
+	defer close(done)
+
+	// Run the range query on a potentially versioned key in a goroutine.
+	go func() {
+		if !ctx.Versioned() {
+			db.unversionedRange(ctx, kStart, kEnd, ch, done, true)
+		} else {
+			db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, true)
+		}
+	}()
+
+	// Consume the keys.
+	values := []storage.TKey{}
+	for {
+		result := <-ch
+		if result.error != nil {
+			return nil, result.error
+		}
+		if result.KeyValue == nil {
+			return values, nil
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, tk)
+	}
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *PebbleDB) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, kch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	ch := make(chan errorableKV)
+	done := make(chan struct{})
+	defer close(done)
+
+	// Run the range query on a potentially versioned key in a goroutine.
+	go func() {
+		if !ctx.Versioned() {
+			db.unversionedRange(ctx, kStart, kEnd, ch, done, true)
+		} else {
+			db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, true)
+		}
+	}()
+
+	// Consume the keys.
+	for {
+		result := <-ch
+		if result.error != nil {
+			kch <- nil
+			return result.error
+		}
+		if result.KeyValue == nil {
+			kch <- nil
+			return nil
+		}
+		kch <- result.KeyValue.K
+	}
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *PebbleDB) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange on nil PebbleDB")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	ch := make(chan errorableKV)
+	done := make(chan struct{})
+	defer close(done)
+
+	// Run the range query on a potentially versioned key in a goroutine.
+	go func() {
+		if !ctx.Versioned() {
+			db.unversionedRange(ctx, kStart, kEnd, ch, done, false)
+		} else {
+			db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, false)
+		}
+	}()
+
+	// Consume the key-value pairs.
+	values := []*storage.TKeyValue{}
+	for {
+		result := <-ch
+		if result.error != nil {
+			return nil, result.error
+		}
+		if result.KeyValue == nil {
+			return values, nil
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: result.KeyValue.V})
+	}
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *PebbleDB) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	ch := make(chan errorableKV)
+	done := make(chan struct{})
+	defer close(done)
+
+	// Run the range query on a potentially versioned key in a goroutine.
+	go func() {
+		if !ctx.Versioned() {
+			db.unversionedRange(ctx, kStart, kEnd, ch, done, false)
+		} else {
+			db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, false)
+		}
+	}()
+
+	// Consume the key-value pairs.
+	for {
+		result := <-ch
+		if result.error != nil {
+			return result.error
+		}
+		if result.KeyValue == nil {
+			return nil
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			return err
+		}
+		tkv := storage.TKeyValue{K: tk, V: result.KeyValue.V}
+		chunk := &storage.Chunk{ChunkOp: op, TKeyValue: &tkv}
+		if err := f(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  A nil is sent down the channel when the
+// range is complete.
+func (db *PebbleDB) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery on nil PebbleDB")
+	}
+	return rawRangeQuery(db.pdb, kStart, kEnd, keysOnly, out, cancel)
+}
+
+// iterReader is satisfied by both a pebble database and its snapshots.
+type iterReader interface {
+	NewIter(*pebble.IterOptions) (*pebble.Iterator, error)
+}
+
+func rawRangeQuery(r iterReader, kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	it, err := r.NewIter(&pebble.IterOptions{LowerBound: kStart})
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	var itValue []byte
+	for valid := it.SeekGE(kStart); valid; valid = it.Next() {
+		if !keysOnly {
+			itValue = copyBytes(it.Value())
+			storage.StoreValueBytesRead <- len(itValue)
+		}
+		itKey := it.Key()
+		storage.StoreKeyBytesRead <- len(itKey)
+		// Did we pass the final key?
+		if bytes.Compare(itKey, kEnd) > 0 {
+			break
+		}
+		kv := storage.KeyValue{K: copyBytes(itKey), V: itValue}
+		select {
+		case out <- &kv:
+		case <-cancel:
+			return nil
+		}
+	}
+	out <- nil
+	return it.Error()
+}
+
+// ---- KeyValueSnapshotter interface ------
+
+type pebbleSnapshot struct {
+	snap *pebble.Snapshot
+}
+
+// NewSnapshot returns a consistent view of the database at the current time.
+func (db *PebbleDB) NewSnapshot() (storage.KeyValueSnapshot, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call NewSnapshot on nil PebbleDB")
+	}
+	return &pebbleSnapshot{db.pdb.NewSnapshot()}, nil
+}
+
+func (s *pebbleSnapshot) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	return rawRangeQuery(s.snap, kStart, kEnd, keysOnly, out, cancel)
+}
+
+func (s *pebbleSnapshot) Release() {
+	if err := s.snap.Close(); err != nil {
+		dvid.Errorf("Unable to release pebble snapshot: %v\n", err)
+	}
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *PebbleDB) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+
+	var err error
+	key := ctx.ConstructKey(tk)
+	if !ctx.Versioned() {
+		err = db.pdb.Set(key, v, db.options.wo)
+	} else {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return fmt.Errorf("Non-versioned context that says it's versioned received in Put(): %v", ctx)
+		}
+		tombstoneKey := vctx.TombstoneKey(tk)
+		batch := db.NewBatch(vctx).(*goBatch)
+		batch.Batch.Delete(tombstoneKey, nil)
+		batch.Batch.Set(key, v, nil)
+		if err = batch.Commit(); err != nil {
+			dvid.Criticalf("Error on batch commit of Put: %v\n", err)
+			err = fmt.Errorf("Error on batch commit of Put: %v", err)
+		}
+	}
+
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	return err
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.
+func (db *PebbleDB) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut on nil PebbleDB")
+	}
+	if err := db.pdb.Set(k, v, db.options.wo); err != nil {
+		return err
+	}
+
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *PebbleDB) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+
+	var err error
+	key := ctx.ConstructKey(tk)
+	if !ctx.Versioned() {
+		err = db.pdb.Delete(key, db.options.wo)
+	} else {
+		vctx, ok := ctx.(storage.VersionedCtx)
+		if !ok {
+			return fmt.Errorf("Non-versioned context that says it's versioned received in Delete(): %v", ctx)
+		}
+		tombstoneKey := vctx.TombstoneKey(tk)
+		batch := db.NewBatch(vctx).(*goBatch)
+		batch.Batch.Delete(key, nil)
+		batch.Batch.Set(tombstoneKey, dvid.EmptyValue(), nil)
+		if err = batch.Commit(); err != nil {
+			dvid.Criticalf("Error on batch commit of Delete: %v\n", err)
+			err = fmt.Errorf("Error on batch commit of Delete: %v", err)
+		}
+	}
+
+	return err
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *PebbleDB) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete on nil PebbleDB")
+	}
+	return db.pdb.Delete(k, db.options.wo)
+}
+
+// ---- KeyValueIngestable interface ------
+
+// KeyValueIngest writes a value with given key without syncing the write, so a crash
+// may lose ingested data even if the Sync option is set for the store.
+func (db *PebbleDB) KeyValueIngest(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call KeyValueIngest on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in KeyValueIngest()")
+	}
+	batch := db.NewBatch(ctx).(*goBatch)
+	batch.Put(tk, v)
+	err := batch.Batch.Commit(pebble.NoSync)
+	batch.Batch.Close()
+	return err
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+// Current implementation simply does a batch write.
+func (db *PebbleDB) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx).(*goBatch)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on batch commit of PutRange: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.
+func (db *PebbleDB) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+
+	// Iterate over keys in range and delete each one using batch, since versioned
+	// deletes require tombstones.
+	const BATCH_SIZE = 10000
+	batch := db.NewBatch(ctx).(*goBatch)
+
+	ch := make(chan errorableKV)
+	done := make(chan struct{})
+	defer close(done)
+
+	// Run the keys-only range query in a goroutine.
+	go func() {
+		if !ctx.Versioned() {
+			db.unversionedRange(ctx, kStart, kEnd, ch, done, true)
+		} else {
+			db.versionedRange(ctx.(storage.VersionedCtx), kStart, kEnd, ch, done, true)
+		}
+	}()
+
+	// Consume the key-value pairs.
+	numKV := 0
+	for {
+		result := <-ch
+		if result.error != nil {
+			batch.Batch.Close()
+			return result.error
+		}
+		if result.KeyValue == nil {
+			break
+		}
+
+		// The key coming down channel is not index but full key, so no need to construct key using context.
+		// If versioned, write a tombstone using current version id since we don't want to delete locked ancestors.
+		// If unversioned, just delete.
+		tk, err := storage.TKeyFromKey(result.KeyValue.K)
+		if err != nil {
+			batch.Batch.Close()
+			return err
+		}
+		batch.Delete(tk)
+
+		if (numKV+1)%BATCH_SIZE == 0 {
+			if err := batch.Commit(); err != nil {
+				dvid.Criticalf("Error on batch commit of DeleteRange at key-value pair %d: %v\n", numKV, err)
+				return fmt.Errorf("Error on batch commit of DeleteRange at key-value pair %d: %v", numKV, err)
+			}
+			batch = db.NewBatch(ctx).(*goBatch)
+		}
+		numKV++
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on last batch commit of DeleteRange: %v\n", err)
+		return fmt.Errorf("Error on last batch commit of DeleteRange: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", numKV, ctx)
+	return nil
+}
+
+// DeleteAll deletes all key-value associated with a context (data instance and version).
+func (db *PebbleDB) DeleteAll(ctx storage.Context) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll on nil PebbleDB")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+
+	var err error
+	var minKey, maxKey storage.Key
+
+	vctx, versioned := ctx.(storage.VersionedCtx)
+	if versioned {
+		// Don't have to worry about tombstones.  Delete all keys from all versions for this instance id.
+		minTKey := storage.MinTKey(storage.TKeyMinClass)
+		maxTKey := storage.MaxTKey(storage.TKeyMaxClass)
+		minKey, err = vctx.MinVersionKey(minTKey)
+		if err != nil {
+			return err
+		}
+		maxKey, err = vctx.MaxVersionKey(maxTKey)
+		if err != nil {
+			return err
+		}
+	} else {
+		minKey, maxKey = ctx.KeyRange()
+	}
+
+	const BATCH_SIZE = 10000
+	batch := db.NewBatch(ctx).(*goBatch)
+
+	it, err := db.pdb.NewIter(&pebble.IterOptions{LowerBound: minKey})
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	numKV := 0
+	for valid := it.SeekGE(minKey); valid; valid = it.Next() {
+		itKey := it.Key()
+		storage.StoreKeyBytesRead <- len(itKey)
+		// Did we pass the final key?
+		if bytes.Compare(itKey, maxKey) > 0 {
+			break
+		}
+
+		batch.Batch.Delete(itKey, nil)
+		if (numKV+1)%BATCH_SIZE == 0 {
+			if err := batch.Commit(); err != nil {
+				dvid.Criticalf("Error on batch commit of DeleteAll at key-value pair %d: %v\n", numKV, err)
+				return fmt.Errorf("Error on batch commit of DeleteAll at key-value pair %d: %v", numKV, err)
+			}
+			batch = db.NewBatch(ctx).(*goBatch)
+			dvid.Debugf("Deleted %d key-value pairs in ongoing DELETE ALL for %s.\n", numKV+1, ctx)
+		}
+		numKV++
+	}
+	if err := it.Error(); err != nil {
+		batch.Batch.Close()
+		return fmt.Errorf("Error iterating during DeleteAll for %s: %v", ctx, err)
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("Error on last batch commit of DeleteAll: %v\n", err)
+		return fmt.Errorf("Error on last batch commit of DeleteAll: %v", err)
+	}
+	dvid.Debugf("Deleted %d key-value pairs via DELETE ALL for %s.\n", numKV, ctx)
+	return nil
+}
+
+// --- Batcher interface ----
+
+type goBatch struct {
+	ctx  storage.Context
+	vctx storage.VersionedCtx
+	*pebble.Batch
+	wo *pebble.WriteOptions
+}
+
+// NewBatch returns an implementation that allows batch writes
+func (db *PebbleDB) NewBatch(ctx storage.Context) storage.Batch {
+	if db == nil {
+		dvid.Criticalf("Can't call NewBatch on nil PebbleDB\n")
+		return nil
+	}
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		vctx = nil
+	}
+	return &goBatch{ctx, vctx, db.pdb.NewBatch(), db.options.wo}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.Batch.Set(tombstone, dvid.EmptyValue(), nil)
+	}
+	batch.Batch.Delete(key, nil)
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	key := batch.ctx.ConstructKey(tk)
+	if batch.vctx != nil {
+		tombstone := batch.vctx.TombstoneKey(tk) // This will now have current version
+		batch.Batch.Delete(tombstone, nil)
+	}
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+	batch.Batch.Set(key, v, nil)
+}
+
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	err := batch.Batch.Commit(batch.wo)
+	if closeErr := batch.Batch.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ---- SizeViewer interface ------
+
+func (db *PebbleDB) GetApproximateSizes(ranges []storage.KeyRange) ([]uint64, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetApproximateSizes on nil PebbleDB")
+	}
+	sizes := make([]uint64, len(ranges))
+	for i, kr := range ranges {
+		size, err := db.pdb.EstimateDiskUsage(kr.Start, kr.OpenEnd)
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = size
+	}
+	return sizes, nil
+}
+
+// ---- BlobStore interface ----
+
+// PutBlob writes unversioned data and returns a filename-friendly base64 encoding of the reference.
+func (db *PebbleDB) PutBlob(v []byte) (ref string, err error) {
+	if db == nil {
+		return "", fmt.Errorf("Can't call PutBlob on nil PebbleDB")
+	}
+	if db.options == nil {
+		return "", fmt.Errorf("Can't call PutBlob on db with nil options: %v", db)
+	}
+	h := fnv.New128()
+	if _, err = h.Write(v); err != nil {
+		return
+	}
+	contentHash := h.Sum(nil)
+	key := storage.ConstructBlobKey(contentHash)
+	err = db.pdb.Set(key, v, db.options.wo)
+
+	storage.StoreKeyBytesWritten <- len(key)
+	storage.StoreValueBytesWritten <- len(v)
+
+	b64key := base64.URLEncoding.EncodeToString(contentHash)
+	return b64key, err
+}
+
+// GetBlob returns unversioned data given a reference.
+func (db *PebbleDB) GetBlob(ref string) (v []byte, err error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetBlob on nil PebbleDB")
+	}
+	if db.options == nil {
+		return nil, fmt.Errorf("Can't call GetBlob on db with nil options: %v", db)
+	}
+	var contentHash []byte
+	if contentHash, err = base64.URLEncoding.DecodeString(ref); err != nil {
+		return
+	}
+	key := storage.ConstructBlobKey(contentHash)
+	v, err = db.get(key)
+	storage.StoreValueBytesRead <- len(v)
+	return
+}
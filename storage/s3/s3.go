@@ -0,0 +1,467 @@
+// +build s3
+
+/*
+Package s3 adds S3-compatible object store support, e.g., AWS S3 or MinIO, to DVID.
+Mandatory configuration parameters are:
+
+  - endpoint: The host and optional port of the S3 service, e.g., "minio.example.org:9000".
+  - bucket: The name of the bucket where the data is stored.  If such a bucket does
+    not exist, it is created.
+
+Optional parameters are:
+
+  - access_key: The access key ID.  Defaults to the AWS_ACCESS_KEY_ID environment variable.
+  - secret_key: The secret access key.  Defaults to the AWS_SECRET_ACCESS_KEY environment variable.
+  - secure: Use https (true by default).
+  - region: Region used when creating the bucket.
+  - prefix: A prefix for all object names, allowing many stores in one bucket.
+
+S3 has no conditional writes, so writes to the value-packed base object of a versioned key
+are serialized within this server.  Only one DVID server should write to a store at a time.
+*/
+package s3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/go/semver"
+	"github.com/janelia-flyem/go/uuid"
+
+	minio "github.com/minio/minio-go"
+)
+
+const (
+	// The maximum number of requests sent to the S3 service in parallel.
+	maxConcurrentOperations = 64
+
+	// Number of locks used to serialize updates of base objects.
+	numKeyLocks = 1024
+
+	// Number of objects fetched in parallel during range queries.
+	fetchBatchSize = 64
+)
+
+func init() {
+	ver, err := semver.Make("0.1.0")
+	if err != nil {
+		dvid.Errorf("Unable to make semver in s3: %v\n", err)
+	}
+	e := Engine{"s3", "S3-compatible object store", ver}
+	storage.RegisterEngine(e)
+}
+
+// --- Engine Implementation ------
+
+type Engine struct {
+	name   string
+	desc   string
+	semver semver.Version
+}
+
+func (e Engine) GetName() string {
+	return e.name
+}
+
+func (e Engine) GetDescription() string {
+	return e.desc
+}
+
+func (e Engine) IsDistributed() bool {
+	return true
+}
+
+func (e Engine) GetSemVer() semver.Version {
+	return e.semver
+}
+
+func (e Engine) String() string {
+	return fmt.Sprintf("%s [%s]", e.name, e.semver)
+}
+
+// NewStore returns an S3 store.  The passed Config must contain "endpoint" and "bucket" strings.
+func (e Engine) NewStore(config dvid.StoreConfig) (dvid.Store, bool, error) {
+	return newS3(config)
+}
+
+// ---- TestableEngine interface implementation -------
+
+// AddTestConfig adds this engine to be used for testing if a local S3 service, e.g., MinIO,
+// is given by the DVID_TEST_S3_ENDPOINT environment variable.  Credentials are read from
+// DVID_TEST_S3_ACCESS_KEY and DVID_TEST_S3_SECRET_KEY, which default to MinIO's defaults.
+func (e Engine) AddTestConfig(backend *storage.Backend) (storage.Alias, error) {
+	endpoint := os.Getenv("DVID_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		return "", fmt.Errorf("no test S3 service: DVID_TEST_S3_ENDPOINT not set")
+	}
+	accessKey := os.Getenv("DVID_TEST_S3_ACCESS_KEY")
+	if accessKey == "" {
+		accessKey = "minioadmin"
+	}
+	secretKey := os.Getenv("DVID_TEST_S3_SECRET_KEY")
+	if secretKey == "" {
+		secretKey = "minioadmin"
+	}
+	alias := storage.Alias("s3")
+	if backend.DefaultKVDB == "" {
+		backend.DefaultKVDB = alias
+	}
+	if backend.Metadata == "" {
+		backend.Metadata = alias
+	}
+	if backend.Stores == nil {
+		backend.Stores = make(map[storage.Alias]dvid.StoreConfig)
+	}
+	tc := map[string]interface{}{
+		"endpoint":   endpoint,
+		"bucket":     fmt.Sprintf("dvid-test-%x", uuid.NewV4().Bytes()),
+		"access_key": accessKey,
+		"secret_key": secretKey,
+		"secure":     false,
+	}
+	var c dvid.Config
+	c.SetAll(tc)
+	backend.Stores[alias] = dvid.StoreConfig{Config: c, Engine: "s3"}
+	return alias, nil
+}
+
+// Delete implements the TestableEngine interface by removing all objects with the
+// store's prefix and then the bucket if it is empty.
+func (e Engine) Delete(config dvid.StoreConfig) error {
+	db, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	if err := db.connect(); err != nil {
+		return err
+	}
+	if err := db.removeAllObjects(); err != nil {
+		return err
+	}
+	if db.prefix == "" {
+		return db.client.RemoveBucket(db.bucket)
+	}
+	return nil
+}
+
+// S3 is a store of key-value pairs as objects in an S3 bucket.  Object names are the
+// hexadecimal encoding of keys, which preserves key ordering in object listings.
+type S3 struct {
+	endpoint  string
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	secure    bool
+
+	client *minio.Client
+
+	// limits the number of concurrent requests.
+	ops chan struct{}
+
+	// serializes read-modify-write of base objects by key hash.
+	keyLocks [numKeyLocks]sync.Mutex
+}
+
+func parseConfig(config dvid.StoreConfig) (*S3, error) {
+	configString := func(param string, required bool) (string, error) {
+		value, found, err := config.GetString(param)
+		if err != nil {
+			return "", fmt.Errorf("%q setting must be a string: %v", param, err)
+		}
+		if required && (!found || value == "") {
+			return "", fmt.Errorf("%q must be specified for s3 configuration", param)
+		}
+		return value, nil
+	}
+	db := &S3{ops: make(chan struct{}, maxConcurrentOperations)}
+	var err error
+	if db.endpoint, err = configString("endpoint", true); err != nil {
+		return nil, err
+	}
+	if db.bucket, err = configString("bucket", true); err != nil {
+		return nil, err
+	}
+	if db.prefix, err = configString("prefix", false); err != nil {
+		return nil, err
+	}
+	if db.region, err = configString("region", false); err != nil {
+		return nil, err
+	}
+	if db.accessKey, err = configString("access_key", false); err != nil {
+		return nil, err
+	}
+	if db.accessKey == "" {
+		db.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if db.secretKey, err = configString("secret_key", false); err != nil {
+		return nil, err
+	}
+	if db.secretKey == "" {
+		db.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	db.secure = true
+	if v, found := config.Get("secure"); found {
+		switch secure := v.(type) {
+		case bool:
+			db.secure = secure
+		case string:
+			if db.secure, _, err = config.GetBool("secure"); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("setting for 'secure' must be a boolean: %v", v)
+		}
+	}
+	return db, nil
+}
+
+func (db *S3) connect() (err error) {
+	db.client, err = minio.New(db.endpoint, db.accessKey, db.secretKey, db.secure)
+	if err != nil {
+		return fmt.Errorf("unable to create S3 client for %s: %v", db.endpoint, err)
+	}
+	return nil
+}
+
+// newS3 connects to the S3 service, creating the bucket if necessary.
+func newS3(config dvid.StoreConfig) (*S3, bool, error) {
+	db, err := parseConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := db.connect(); err != nil {
+		return nil, false, err
+	}
+
+	exists, err := db.client.BucketExists(db.bucket)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to check if bucket %q exists: %v", db.bucket, err)
+	}
+	if !exists {
+		if err := db.client.MakeBucket(db.bucket, db.region); err != nil {
+			return nil, false, fmt.Errorf("cannot create bucket %q: %v", db.bucket, err)
+		}
+		dvid.Infof("Created new bucket %q @ %s\n", db.bucket, db.endpoint)
+	}
+
+	// Check if we already have metadata.
+	var ctx storage.MetadataContext
+	minKey, maxKey := ctx.KeyRange()
+	keys, err := db.listKeys(minKey, maxKey, 1)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to check for metadata objects: %v", err)
+	}
+	return db, len(keys) == 0, nil
+}
+
+func (db *S3) String() string {
+	return fmt.Sprintf("s3 @ %s, bucket %q, prefix %q", db.endpoint, db.bucket, db.prefix)
+}
+
+// Close closes the store.
+func (db *S3) Close() {
+	// Nothing to close.
+}
+
+// Equal returns true if this store matches the given store configuration.
+func (db *S3) Equal(config dvid.StoreConfig) bool {
+	db2, err := parseConfig(config)
+	if err != nil {
+		return false
+	}
+	return db.endpoint == db2.endpoint && db.bucket == db2.bucket && db.prefix == db2.prefix
+}
+
+// ---- object access ----
+
+func (db *S3) objectName(k storage.Key) string {
+	return db.prefix + hex.EncodeToString(k)
+}
+
+// keyFromName returns the key for an object name or nil if it isn't a key of this store.
+func (db *S3) keyFromName(name string) storage.Key {
+	if !strings.HasPrefix(name, db.prefix) {
+		return nil
+	}
+	k, err := hex.DecodeString(name[len(db.prefix):])
+	if err != nil {
+		return nil
+	}
+	return k
+}
+
+func (db *S3) lockKey(k storage.Key) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write(k)
+	return &db.keyLocks[h.Sum32()%numKeyLocks]
+}
+
+func isNotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+// getObject returns the value of a key or nil if the key isn't present.  If maxBytes is
+// positive, at most that many bytes from the start of the value are returned.
+func (db *S3) getObject(k storage.Key, maxBytes int64) ([]byte, error) {
+	db.ops <- struct{}{}
+	defer func() {
+		<-db.ops
+	}()
+	var opts minio.GetObjectOptions
+	if maxBytes > 0 {
+		if err := opts.SetRange(0, maxBytes-1); err != nil {
+			return nil, err
+		}
+	}
+	obj, err := db.client.GetObject(db.bucket, db.objectName(k), opts)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer obj.Close()
+	v, err := ioutil.ReadAll(obj)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	storage.StoreValueBytesRead <- len(v)
+	return v, nil
+}
+
+func (db *S3) objectExists(k storage.Key) (bool, error) {
+	db.ops <- struct{}{}
+	defer func() {
+		<-db.ops
+	}()
+	_, err := db.client.StatObject(db.bucket, db.objectName(k), minio.StatObjectOptions{})
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (db *S3) putObject(k storage.Key, v []byte) error {
+	db.ops <- struct{}{}
+	defer func() {
+		<-db.ops
+	}()
+	opts := minio.PutObjectOptions{ContentType: "application/octet-stream"}
+	if _, err := db.client.PutObject(db.bucket, db.objectName(k), bytes.NewReader(v), int64(len(v)), opts); err != nil {
+		return err
+	}
+	storage.StoreKeyBytesWritten <- len(k)
+	storage.StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+func (db *S3) removeObject(k storage.Key) error {
+	db.ops <- struct{}{}
+	defer func() {
+		<-db.ops
+	}()
+	return db.client.RemoveObject(db.bucket, db.objectName(k))
+}
+
+// returns the common prefix of two strings.
+func commonPrefix(s1, s2 string) string {
+	i := 0
+	for i < len(s1) && i < len(s2) && s1[i] == s2[i] {
+		i++
+	}
+	return s1[:i]
+}
+
+// listKeys returns the keys of objects within the inclusive key range in ascending order.
+// If limit is positive, at most that many keys are returned.
+func (db *S3) listKeys(minKey, maxKey storage.Key, limit int) ([]storage.Key, error) {
+	minName, maxName := db.objectName(minKey), db.objectName(maxKey)
+	done := make(chan struct{})
+	defer close(done)
+
+	var keys []storage.Key
+	for obj := range db.client.ListObjectsV2(db.bucket, commonPrefix(minName, maxName), true, done) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if obj.Key < minName {
+			continue
+		}
+		if obj.Key > maxName {
+			break
+		}
+		if k := db.keyFromName(obj.Key); k != nil {
+			keys = append(keys, k)
+			storage.StoreKeyBytesRead <- len(k)
+			if limit > 0 && len(keys) >= limit {
+				break
+			}
+		}
+	}
+	return keys, nil
+}
+
+// removeObjects removes the objects of the given keys using multi-object delete requests.
+func (db *S3) removeObjects(keys []storage.Key) error {
+	names := make(chan string)
+	errCh := db.client.RemoveObjects(db.bucket, names)
+	go func() {
+		defer close(names)
+		for _, k := range keys {
+			names <- db.objectName(k)
+		}
+	}()
+	var err error
+	for rmErr := range errCh {
+		if rmErr.Err != nil && err == nil {
+			err = fmt.Errorf("unable to remove object %q: %v", rmErr.ObjectName, rmErr.Err)
+		}
+	}
+	return err
+}
+
+// removeAllObjects removes all objects with the store's prefix.
+func (db *S3) removeAllObjects() error {
+	done := make(chan struct{})
+	defer close(done)
+	names := make(chan string)
+	errCh := db.client.RemoveObjects(db.bucket, names)
+	var listErr error
+	go func() {
+		defer close(names)
+		for obj := range db.client.ListObjectsV2(db.bucket, db.prefix, true, done) {
+			if obj.Err != nil {
+				listErr = obj.Err
+				return
+			}
+			names <- obj.Key
+		}
+	}()
+	var err error
+	for rmErr := range errCh {
+		if rmErr.Err != nil && err == nil {
+			err = fmt.Errorf("unable to remove object %q: %v", rmErr.ObjectName, rmErr.Err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return listErr
+}
@@ -0,0 +1,63 @@
+// +build s3
+
+package s3
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func TestS3Metadata(t *testing.T) {
+	eng := storage.GetEngine("s3")
+	if eng == nil {
+		t.Fatalf("Init does not register 's3' engine.\n")
+	}
+	// Make an empty S3 just to check interfaces.
+	var store dvid.Store = new(S3)
+	kvdb, ok := store.(storage.KeyValueDB)
+	if !ok {
+		t.Fatalf("S3 should implement storage.KeyValueDB interface but doesn't\n")
+	}
+	if _, ok = kvdb.(storage.OrderedKeyValueDB); !ok {
+		t.Fatalf("S3 should implement storage.OrderedKeyValueDB interface but doesn't\n")
+	}
+}
+
+func TestPackedValue(t *testing.T) {
+	p := newPackedValue(3, []byte("some data"))
+	p.setVersion(5, false)
+	p.setVersion(7, true)
+	val := p.encode()
+
+	p2, err := decodePackedValue(val)
+	if err != nil {
+		t.Fatalf("unable to decode packed value: %v\n", err)
+	}
+	if p2.base != 3 || !bytes.Equal(p2.data, []byte("some data")) {
+		t.Fatalf("bad decoded base version %d, data %q\n", p2.base, p2.data)
+	}
+	if i, tombstone := p2.find(5); i != 1 || tombstone {
+		t.Errorf("expected version 5 at index 1 without tombstone, got %d, %t\n", i, tombstone)
+	}
+	if i, tombstone := p2.find(7); i != 2 || !tombstone {
+		t.Errorf("expected version 7 at index 2 with tombstone, got %d, %t\n", i, tombstone)
+	}
+	if i, _ := p2.find(4); i != -1 {
+		t.Errorf("expected version 4 to be absent, got index %d\n", i)
+	}
+
+	// header-only reads must decode without data.
+	header, err := decodePackedValue(val[:headerSize(3)])
+	if err != nil {
+		t.Fatalf("unable to decode header: %v\n", err)
+	}
+	if len(header.versions) != 3 {
+		t.Errorf("expected 3 versions in header, got %v\n", header.versions)
+	}
+	if _, err := decodePackedValue(val[:2*vsize]); err == nil {
+		t.Errorf("expected error decoding truncated header\n")
+	}
+}
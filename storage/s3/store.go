@@ -0,0 +1,816 @@
+// +build s3
+
+package s3
+
+import (
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// ---- versioned values ------
+
+// getVersion returns the full key and value of the version of a key relevant to the
+// context's version, or nil if there is none.  If keysOnly, only the header of the base
+// object is read and no value is returned.
+func (db *S3) getVersion(vctx storage.VersionedCtx, tk storage.TKey, keysOnly bool) (*storage.KeyValue, error) {
+	var maxBytes int64
+	if keysOnly {
+		maxBytes = headerSize(vctx.NumVersions())
+	}
+	val, err := db.getObject(vctx.ConstructKeyVersion(tk, 0), maxBytes)
+	if err != nil || val == nil {
+		return nil, err
+	}
+	p, err := decodePackedValue(val)
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.relevantVersion(vctx, tk)
+	if err != nil || v == 0 {
+		return nil, err
+	}
+	kv := &storage.KeyValue{K: vctx.ConstructKeyVersion(tk, v)}
+	if keysOnly {
+		return kv, nil
+	}
+	if v == p.base {
+		kv.V = p.data
+		return kv, nil
+	}
+	if kv.V, err = db.getObject(kv.K, 0); err != nil || kv.V == nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+// putVersion stores a value for the context's version.  The base object holds the data
+// of the newest version on the master branch, and other versions are held in separate
+// objects, evicting the base version's data to its own object when necessary.
+func (db *S3) putVersion(vctx storage.VersionedCtx, tk storage.TKey, value []byte) error {
+	baseKey := vctx.ConstructKeyVersion(tk, 0)
+	mu := db.lockKey(baseKey)
+	mu.Lock()
+	defer mu.Unlock()
+
+	v := vctx.VersionID()
+	val, err := db.getObject(baseKey, 0)
+	if err != nil {
+		return err
+	}
+	if val == nil {
+		return db.putObject(baseKey, newPackedValue(v, value).encode())
+	}
+	p, err := decodePackedValue(val)
+	if err != nil {
+		return err
+	}
+	i, tombstone := p.find(v)
+	switch {
+	case p.base == v:
+		p.data = value
+	case i >= 0:
+		if err := db.putObject(vctx.ConstructKeyVersion(tk, v), value); err != nil {
+			return err
+		}
+		if !tombstone {
+			return nil // header doesn't change
+		}
+	case p.base == 0 || vctx.Head() || !vctx.MasterVersion(p.base):
+		if p.base != 0 {
+			if _, baseTombstone := p.find(p.base); !baseTombstone {
+				if err := db.putObject(vctx.ConstructKeyVersion(tk, p.base), p.data); err != nil {
+					return err
+				}
+			}
+		}
+		p.base = v
+		p.data = value
+	default:
+		if err := db.putObject(vctx.ConstructKeyVersion(tk, v), value); err != nil {
+			return err
+		}
+	}
+	p.setVersion(v, false)
+	return db.putObject(baseKey, p.encode())
+}
+
+// deleteVersion adds a tombstone for the context's version, removing any stored data
+// for that version.
+func (db *S3) deleteVersion(vctx storage.VersionedCtx, tk storage.TKey) error {
+	baseKey := vctx.ConstructKeyVersion(tk, 0)
+	mu := db.lockKey(baseKey)
+	mu.Lock()
+	defer mu.Unlock()
+
+	v := vctx.VersionID()
+	val, err := db.getObject(baseKey, 0)
+	if err != nil || val == nil {
+		return err
+	}
+	p, err := decodePackedValue(val)
+	if err != nil {
+		return err
+	}
+	i, tombstone := p.find(v)
+	if tombstone {
+		return nil
+	}
+	if p.base == v {
+		p.data = nil
+	}
+	p.setVersion(v, true)
+	if err := db.putObject(baseKey, p.encode()); err != nil {
+		return err
+	}
+	if i >= 0 && p.base != v {
+		return db.removeObject(vctx.ConstructKeyVersion(tk, v))
+	}
+	return nil
+}
+
+// ---- range queries ------
+
+// fetchInOrder retrieves the key-value pairs for each key in parallel batches and sends
+// them to f in key order.
+func fetchInOrder(keys []storage.Key, fetch func(storage.Key) ([]*storage.KeyValue, error), f func(*storage.KeyValue) error) error {
+	for beg := 0; beg < len(keys); beg += fetchBatchSize {
+		end := beg + fetchBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		results := make([][]*storage.KeyValue, end-beg)
+		errs := make([]error, end-beg)
+		var wg sync.WaitGroup
+		for i, k := range keys[beg:end] {
+			wg.Add(1)
+			go func(i int, k storage.Key) {
+				defer wg.Done()
+				results[i], errs[i] = fetch(k)
+			}(i, k)
+		}
+		wg.Wait()
+		for i, kvs := range results {
+			if errs[i] != nil {
+				return errs[i]
+			}
+			for _, kv := range kvs {
+				if err := f(kv); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fetchObject returns the key-value pair for a single object, or nothing if it's missing.
+func (db *S3) fetchObject(k storage.Key, keysOnly bool) ([]*storage.KeyValue, error) {
+	if keysOnly {
+		return []*storage.KeyValue{{K: k}}, nil
+	}
+	v, err := db.getObject(k, 0)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	return []*storage.KeyValue{{K: k, V: v}}, nil
+}
+
+// rangeQuery sends the key-value pairs for the context's version in the range of type
+// keys to f in ascending key order.
+func (db *S3) rangeQuery(ctx storage.Context, kStart, kEnd storage.TKey, keysOnly bool, f func(*storage.KeyValue) error) error {
+	if !ctx.Versioned() {
+		keys, err := db.listKeys(ctx.ConstructKey(kStart), ctx.ConstructKey(kEnd), 0)
+		if err != nil {
+			return err
+		}
+		// skip base objects and other versions written through RawPut.
+		var ctxKeys []storage.Key
+		for _, k := range keys {
+			if k.IsDataKey() {
+				if _, v, _, err := storage.DataKeyToLocalIDs(k); err != nil || v != ctx.VersionID() {
+					continue
+				}
+			}
+			ctxKeys = append(ctxKeys, k)
+		}
+		return fetchInOrder(ctxKeys, func(k storage.Key) ([]*storage.KeyValue, error) {
+			return db.fetchObject(k, keysOnly)
+		}, f)
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return fmt.Errorf("context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	keys, err := db.listKeys(vctx.ConstructKeyVersion(kStart, 0), vctx.ConstructKeyVersion(kEnd, 0), 0)
+	if err != nil {
+		return err
+	}
+	var baseKeys []storage.Key
+	for _, k := range keys {
+		if v, err := vctx.VersionFromKey(k); err == nil && v == 0 {
+			baseKeys = append(baseKeys, k)
+		}
+	}
+	return fetchInOrder(baseKeys, func(k storage.Key) ([]*storage.KeyValue, error) {
+		tk, err := storage.TKeyFromKey(k)
+		if err != nil {
+			return nil, err
+		}
+		kv, err := db.getVersion(vctx, tk, keysOnly)
+		if err != nil || kv == nil {
+			return nil, err
+		}
+		return []*storage.KeyValue{kv}, nil
+	}, f)
+}
+
+// ---- KeyValueGetter interface ------
+
+// Get returns a value given a key.
+func (db *S3) Get(ctx storage.Context, tk storage.TKey) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call Get() on nil S3")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in Get()")
+	}
+	if !ctx.Versioned() {
+		return db.getObject(ctx.ConstructKey(tk), 0)
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return nil, fmt.Errorf("Bad Get(): context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	kv, err := db.getVersion(vctx, tk, false)
+	if err != nil || kv == nil {
+		return nil, err
+	}
+	return kv.V, nil
+}
+
+// Exists returns true if the key exists.
+func (db *S3) Exists(ctx storage.Context, tk storage.TKey) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("Can't call Exists() on nil S3")
+	}
+	if ctx == nil {
+		return false, fmt.Errorf("Received nil context in Exists()")
+	}
+	if !ctx.Versioned() {
+		return db.objectExists(ctx.ConstructKey(tk))
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return false, fmt.Errorf("Bad Exists(): context is versioned but doesn't fulfill interface: %v", ctx)
+	}
+	kv, err := db.getVersion(vctx, tk, true)
+	return kv != nil, err
+}
+
+// ---- OrderedKeyValueGetter interface ------
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+func (db *S3) KeysInRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]storage.TKey, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call KeysInRange() on nil S3")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in KeysInRange()")
+	}
+	tkeys := []storage.TKey{}
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		tkeys = append(tkeys, tk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tkeys, nil
+}
+
+// SendKeysInRange sends a range of keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.   If the keys are versioned, only keys
+// in the ancestor path of the current context's version will be returned.
+// End of range is marked by a nil key.
+func (db *S3) SendKeysInRange(ctx storage.Context, kStart, kEnd storage.TKey, ch storage.KeyChan) error {
+	if db == nil {
+		return fmt.Errorf("Can't call SendKeysInRange() on nil S3")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in SendKeysInRange()")
+	}
+	err := db.rangeQuery(ctx, kStart, kEnd, true, func(kv *storage.KeyValue) error {
+		ch <- kv.K
+		return nil
+	})
+	ch <- nil
+	return err
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.  If the keys are versioned, all key-value
+// pairs for the particular version will be returned.
+func (db *S3) GetRange(ctx storage.Context, kStart, kEnd storage.TKey) ([]*storage.TKeyValue, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetRange() on nil S3")
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("Received nil context in GetRange()")
+	}
+	values := []*storage.TKeyValue{}
+	err := db.rangeQuery(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		values = append(values, &storage.TKeyValue{K: tk, V: kv.V})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  If the keys are versioned,
+// only key-value pairs for kStart's version will be transmitted.  If f returns an error, the
+// function is immediately terminated and returns an error.
+func (db *S3) ProcessRange(ctx storage.Context, kStart, kEnd storage.TKey, op *storage.ChunkOp, f storage.ChunkFunc) error {
+	if db == nil {
+		return fmt.Errorf("Can't call ProcessRange() on nil S3")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in ProcessRange()")
+	}
+	return db.rangeQuery(ctx, kStart, kEnd, false, func(kv *storage.KeyValue) error {
+		tk, err := storage.TKeyFromKey(kv.K)
+		if err != nil {
+			return err
+		}
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		chunk := &storage.Chunk{ChunkOp: op, TKeyValue: &storage.TKeyValue{K: tk, V: kv.V}}
+		return f(chunk)
+	})
+}
+
+// RawRangeQuery sends a range of full keys.  This is to be used for low-level data
+// retrieval like DVID-to-DVID communication and should not be used by data type
+// implementations if possible.  Value-packed base objects are expanded into the keys
+// and tombstones of each stored version, as would be stored by an ordered key-value store.
+// A nil is sent down the channel when the range is complete.
+func (db *S3) RawRangeQuery(kStart, kEnd storage.Key, keysOnly bool, out chan *storage.KeyValue, cancel <-chan struct{}) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawRangeQuery() on nil S3")
+	}
+	keys, err := db.listKeys(kStart, kEnd, 0)
+	if err != nil {
+		return err
+	}
+	bases := make(map[string]struct{})
+	for _, k := range keys {
+		if isBaseKey(k) {
+			bases[string(k)] = struct{}{}
+		}
+	}
+	var rawKeys []storage.Key
+	for _, k := range keys {
+		if k.IsDataKey() && !isBaseKey(k) {
+			baseKey, err := versionKey(k, 0, false)
+			if err != nil {
+				return err
+			}
+			if _, found := bases[string(baseKey)]; found {
+				continue // sent during expansion of base object
+			}
+		}
+		rawKeys = append(rawKeys, k)
+	}
+	errCancel := fmt.Errorf("cancelled")
+	err = fetchInOrder(rawKeys, func(k storage.Key) ([]*storage.KeyValue, error) {
+		if isBaseKey(k) {
+			return db.expandBase(k, keysOnly)
+		}
+		return db.fetchObject(k, keysOnly)
+	}, func(kv *storage.KeyValue) error {
+		select {
+		case out <- kv:
+			return nil
+		case <-cancel:
+			return errCancel
+		}
+	})
+	if err == errCancel {
+		return nil
+	}
+	out <- nil
+	return err
+}
+
+// isBaseKey returns true if the key is for a value-packed base object.
+func isBaseKey(k storage.Key) bool {
+	if !k.IsDataKey() {
+		return false
+	}
+	_, v, _, err := storage.DataKeyToLocalIDs(k)
+	return err == nil && v == 0
+}
+
+// expandBase returns the key-value pairs of each version held by a base object in key order.
+func (db *S3) expandBase(baseKey storage.Key, keysOnly bool) ([]*storage.KeyValue, error) {
+	val, err := db.getObject(baseKey, 0)
+	if err != nil || val == nil {
+		return nil, err
+	}
+	p, err := decodePackedValue(val)
+	if err != nil {
+		return nil, err
+	}
+	var kvs []*storage.KeyValue
+	for _, hv := range p.sortedVersions() {
+		tombstone := hv < 0
+		if tombstone {
+			hv = -hv
+		}
+		k, err := versionKey(baseKey, dvid.VersionID(hv), tombstone)
+		if err != nil {
+			return nil, err
+		}
+		kv := &storage.KeyValue{K: k}
+		switch {
+		case keysOnly:
+		case tombstone:
+			kv.V = dvid.EmptyValue()
+		case dvid.VersionID(hv) == p.base:
+			kv.V = p.data
+		default:
+			if kv.V, err = db.getObject(k, 0); err != nil {
+				return nil, err
+			}
+			if kv.V == nil {
+				continue
+			}
+		}
+		kvs = append(kvs, kv)
+	}
+	return kvs, nil
+}
+
+// ---- KeyValueSetter interface ------
+
+// Put writes a value with given key.
+func (db *S3) Put(ctx storage.Context, tk storage.TKey, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Put() on nil S3")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Put()")
+	}
+	if !ctx.Versioned() {
+		return db.putObject(ctx.ConstructKey(tk), v)
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return fmt.Errorf("Non-versioned context that says it's versioned received in Put(): %v", ctx)
+	}
+	return db.putVersion(vctx, tk, v)
+}
+
+// Delete removes a value with given key.
+func (db *S3) Delete(ctx storage.Context, tk storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call Delete() on nil S3")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in Delete()")
+	}
+	if !ctx.Versioned() {
+		return db.removeObject(ctx.ConstructKey(tk))
+	}
+	vctx, ok := ctx.(storage.VersionedCtx)
+	if !ok {
+		return fmt.Errorf("Non-versioned context that says it's versioned received in Delete(): %v", ctx)
+	}
+	return db.deleteVersion(vctx, tk)
+}
+
+// RawPut is a low-level function that puts a key-value pair using full keys.
+// This can be used in conjunction with RawRangeQuery.  Versioned data keys and
+// tombstones are added to the header of their base object.
+func (db *S3) RawPut(k storage.Key, v []byte) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawPut() on nil S3")
+	}
+	if !k.IsDataKey() || isBaseKey(k) {
+		return db.putObject(k, v)
+	}
+	baseKey, err := versionKey(k, 0, false)
+	if err != nil {
+		return err
+	}
+	mu := db.lockKey(baseKey)
+	mu.Lock()
+	defer mu.Unlock()
+
+	_, ver, _, err := storage.DataKeyToLocalIDs(k)
+	if err != nil {
+		return err
+	}
+	p := new(packedValue)
+	val, err := db.getObject(baseKey, 0)
+	if err != nil {
+		return err
+	}
+	if val != nil {
+		if p, err = decodePackedValue(val); err != nil {
+			return err
+		}
+	}
+	dataKey, err := versionKey(k, ver, false)
+	if err != nil {
+		return err
+	}
+	i, wasTombstone := p.find(ver)
+	if k.IsTombstone() {
+		if p.base == ver {
+			p.data = nil
+		}
+		p.setVersion(ver, true)
+		if err := db.putObject(baseKey, p.encode()); err != nil {
+			return err
+		}
+		if i >= 0 && !wasTombstone && p.base != ver {
+			return db.removeObject(dataKey)
+		}
+		return nil
+	}
+	if p.base == ver {
+		p.data = v
+	} else {
+		if err := db.putObject(dataKey, v); err != nil {
+			return err
+		}
+		if i >= 0 && !wasTombstone {
+			return nil // header doesn't change
+		}
+	}
+	p.setVersion(ver, false)
+	return db.putObject(baseKey, p.encode())
+}
+
+// RawDelete is a low-level function.  It deletes a key-value pair using full keys
+// without any context.  This can be used in conjunction with RawRangeQuery.
+func (db *S3) RawDelete(k storage.Key) error {
+	if db == nil {
+		return fmt.Errorf("Can't call RawDelete() on nil S3")
+	}
+	if !k.IsDataKey() || isBaseKey(k) {
+		return db.removeObject(k)
+	}
+	baseKey, err := versionKey(k, 0, false)
+	if err != nil {
+		return err
+	}
+	mu := db.lockKey(baseKey)
+	mu.Lock()
+	defer mu.Unlock()
+
+	_, ver, _, err := storage.DataKeyToLocalIDs(k)
+	if err != nil {
+		return err
+	}
+	val, err := db.getObject(baseKey, 0)
+	if err != nil {
+		return err
+	}
+	if val != nil {
+		p, err := decodePackedValue(val)
+		if err != nil {
+			return err
+		}
+		if i, tombstone := p.find(ver); i >= 0 && tombstone == k.IsTombstone() {
+			p.removeVersion(ver)
+			if len(p.versions) == 0 {
+				err = db.removeObject(baseKey)
+			} else {
+				err = db.putObject(baseKey, p.encode())
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if k.IsTombstone() {
+		return nil
+	}
+	dataKey, err := versionKey(k, ver, false)
+	if err != nil {
+		return err
+	}
+	return db.removeObject(dataKey)
+}
+
+// ---- OrderedKeyValueSetter interface ------
+
+// PutRange puts type key-value pairs that have been sorted in sequential key order.
+func (db *S3) PutRange(ctx storage.Context, kvs []storage.TKeyValue) error {
+	if db == nil {
+		return fmt.Errorf("Can't call PutRange() on nil S3")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in PutRange()")
+	}
+	batch := db.NewBatch(ctx)
+	for _, kv := range kvs {
+		batch.Put(kv.K, kv.V)
+	}
+	return batch.Commit()
+}
+
+// DeleteRange removes all key-value pairs with keys in the given range.  Versioned
+// key-value pairs are tombstoned for the context's version.
+func (db *S3) DeleteRange(ctx storage.Context, kStart, kEnd storage.TKey) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteRange() on nil S3")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteRange()")
+	}
+	tkeys, err := db.KeysInRange(ctx, kStart, kEnd)
+	if err != nil {
+		return err
+	}
+	batch := db.NewBatch(ctx)
+	for _, tk := range tkeys {
+		batch.Delete(tk)
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	dvid.Debugf("Deleted %d key-value pairs via delete range for %s.\n", len(tkeys), ctx)
+	return nil
+}
+
+// DeleteAll removes all key-value pairs for the context.  If the context is versioned,
+// all versions of the data instance are deleted.  Will not produce any tombstones.
+func (db *S3) DeleteAll(ctx storage.Context) error {
+	if db == nil {
+		return fmt.Errorf("Can't call DeleteAll() on nil S3")
+	}
+	if ctx == nil {
+		return fmt.Errorf("Received nil context in DeleteAll()")
+	}
+	var err error
+	var minKey, maxKey storage.Key
+	if vctx, versioned := ctx.(storage.VersionedCtx); versioned {
+		minKey, err = vctx.MinVersionKey(storage.MinTKey(storage.TKeyMinClass))
+		if err != nil {
+			return err
+		}
+		maxKey, err = vctx.MaxVersionKey(storage.MaxTKey(storage.TKeyMaxClass))
+		if err != nil {
+			return err
+		}
+	} else {
+		minKey, maxKey = ctx.KeyRange()
+	}
+	keys, err := db.listKeys(minKey, maxKey, 0)
+	if err != nil {
+		return err
+	}
+	if err := db.removeObjects(keys); err != nil {
+		return err
+	}
+	dvid.Debugf("Deleted %d objects via DELETE ALL for %s.\n", len(keys), ctx)
+	return nil
+}
+
+// --- Batcher interface ----
+
+type batchOp struct {
+	tk     storage.TKey
+	value  []byte
+	delete bool
+}
+
+type goBatch struct {
+	db  *S3
+	ctx storage.Context
+	ops []batchOp
+}
+
+// NewBatch returns an implementation that allows batch writes
+func (db *S3) NewBatch(ctx storage.Context) storage.Batch {
+	if ctx == nil {
+		dvid.Criticalf("Received nil context in NewBatch()")
+		return nil
+	}
+	return &goBatch{db: db, ctx: ctx}
+}
+
+// --- Batch interface ---
+
+func (batch *goBatch) Delete(tk storage.TKey) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Delete()\n")
+		return
+	}
+	batch.ops = append(batch.ops, batchOp{tk: tk, delete: true})
+}
+
+func (batch *goBatch) Put(tk storage.TKey, v []byte) {
+	if batch == nil || batch.ctx == nil {
+		dvid.Criticalf("Received nil batch or nil batch context in batch.Put()\n")
+		return
+	}
+	batch.ops = append(batch.ops, batchOp{tk: tk, value: v})
+}
+
+// Commit applies the batched operations.  Operations on different keys are sent in
+// parallel while operations on the same key are applied in order.  S3 has no multi-object
+// transactions so a failed commit may leave some operations applied.
+func (batch *goBatch) Commit() error {
+	if batch == nil {
+		return fmt.Errorf("Received nil batch in batch.Commit()\n")
+	}
+	var keyOrder []string
+	byKey := make(map[string][]batchOp)
+	for _, op := range batch.ops {
+		k := string(op.tk)
+		if _, found := byKey[k]; !found {
+			keyOrder = append(keyOrder, k)
+		}
+		byKey[k] = append(byKey[k], op)
+	}
+	batch.ops = nil
+
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for _, k := range keyOrder {
+		wg.Add(1)
+		go func(ops []batchOp) {
+			defer wg.Done()
+			for _, op := range ops {
+				var err error
+				if op.delete {
+					err = batch.db.Delete(batch.ctx, op.tk)
+				} else {
+					err = batch.db.Put(batch.ctx, op.tk, op.value)
+				}
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}(byKey[k])
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// ---- BlobStore interface ----
+
+// PutBlob writes unversioned data and returns a filename-friendly base64 encoding of the reference.
+func (db *S3) PutBlob(v []byte) (ref string, err error) {
+	if db == nil {
+		return "", fmt.Errorf("Can't call PutBlob on nil S3")
+	}
+	h := fnv.New128()
+	if _, err = h.Write(v); err != nil {
+		return
+	}
+	contentHash := h.Sum(nil)
+	if err = db.putObject(storage.ConstructBlobKey(contentHash), v); err != nil {
+		return
+	}
+	return base64.URLEncoding.EncodeToString(contentHash), nil
+}
+
+// GetBlob returns unversioned data given a reference.
+func (db *S3) GetBlob(ref string) ([]byte, error) {
+	if db == nil {
+		return nil, fmt.Errorf("Can't call GetBlob on nil S3")
+	}
+	contentHash, err := base64.URLEncoding.DecodeString(ref)
+	if err != nil {
+		return nil, err
+	}
+	return db.getObject(storage.ConstructBlobKey(contentHash), 0)
+}
@@ -0,0 +1,171 @@
+// +build s3
+
+package s3
+
+/*
+Versioned values use the same value packing as the gbucket engine.  All versions of a
+versioned key share a base object whose name is the key with version 0.  The base object
+begins with a header of little-endian 4 byte integers:
+
+	[base version] [version 1] [version 2] ... [0] [data of base version]
+
+The first integer is the version whose data is stored after the header in the base object,
+or 0 if no version's data is stored there.  It is followed by each version with a value or
+tombstone for the key, where tombstoned versions are negated, and a terminating 0.  The data
+of versions other than the base version are stored in separate objects named by the full
+key of that version.
+*/
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// size in bytes of each version in the header
+const vsize = 4
+
+// packedValue is the decoded content of a base object.
+type packedValue struct {
+	base     dvid.VersionID // version whose data is held in the base object, 0 if none
+	versions []int32        // versions with values or tombstones (negated)
+	data     []byte         // data for the base version
+}
+
+func newPackedValue(v dvid.VersionID, data []byte) *packedValue {
+	return &packedValue{
+		base:     v,
+		versions: []int32{int32(v)},
+		data:     data,
+	}
+}
+
+// decodePackedValue decodes the header of a base object.  The data is not copied.
+func decodePackedValue(val []byte) (*packedValue, error) {
+	if len(val) < 2*vsize {
+		return nil, fmt.Errorf("base value of %d bytes is too small for version header", len(val))
+	}
+	p := &packedValue{base: dvid.VersionID(binary.LittleEndian.Uint32(val[0:vsize]))}
+	for pos := vsize; pos+vsize <= len(val); pos += vsize {
+		v := int32(binary.LittleEndian.Uint32(val[pos : pos+vsize]))
+		if v == 0 {
+			p.data = val[pos+vsize:]
+			return p, nil
+		}
+		p.versions = append(p.versions, v)
+	}
+	return nil, fmt.Errorf("end of version header not found in %d byte base value", len(val))
+}
+
+// headerSize returns the maximum number of bytes in the header of a base object given
+// the number of versions in the DAG, allowing for a concurrently created version.
+func headerSize(numVersions int32) int64 {
+	return int64(numVersions+3) * vsize
+}
+
+func (p *packedValue) encode() []byte {
+	buf := make([]byte, (len(p.versions)+2)*vsize, (len(p.versions)+2)*vsize+len(p.data))
+	binary.LittleEndian.PutUint32(buf[0:vsize], uint32(p.base))
+	for i, v := range p.versions {
+		binary.LittleEndian.PutUint32(buf[(i+1)*vsize:], uint32(v))
+	}
+	return append(buf, p.data...)
+}
+
+// find returns the index of the version in the header or -1 if not present, and whether
+// the version is tombstoned.
+func (p *packedValue) find(v dvid.VersionID) (i int, tombstone bool) {
+	for i, hv := range p.versions {
+		if hv == int32(v) {
+			return i, false
+		}
+		if hv == -int32(v) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// setVersion marks a version as having a value or a tombstone, adding it to the header
+// if necessary.
+func (p *packedValue) setVersion(v dvid.VersionID, tombstone bool) {
+	hv := int32(v)
+	if tombstone {
+		hv = -hv
+	}
+	if i, _ := p.find(v); i >= 0 {
+		p.versions[i] = hv
+	} else {
+		p.versions = append(p.versions, hv)
+	}
+}
+
+// removeVersion removes a version from the header, dropping the base data if it belongs
+// to that version.
+func (p *packedValue) removeVersion(v dvid.VersionID) {
+	if p.base == v {
+		p.base = 0
+		p.data = nil
+	}
+	if i, _ := p.find(v); i >= 0 {
+		p.versions = append(p.versions[:i], p.versions[i+1:]...)
+	}
+}
+
+// sortedVersions returns the header versions in ascending version order, which is the
+// order of their full keys.
+func (p *packedValue) sortedVersions() []int32 {
+	sorted := make([]int32, len(p.versions))
+	copy(sorted, p.versions)
+	abs := func(v int32) int32 {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	sort.Slice(sorted, func(i, j int) bool { return abs(sorted[i]) < abs(sorted[j]) })
+	return sorted
+}
+
+// versionKey returns a copy of the data key with the given version and data or tombstone
+// marker.  Base objects are at the data key with version 0.
+func versionKey(k storage.Key, v dvid.VersionID, tombstone bool) (storage.Key, error) {
+	instance, _, client, err := storage.DataKeyToLocalIDs(k)
+	if err != nil {
+		return nil, err
+	}
+	vk := make(storage.Key, len(k))
+	copy(vk, k)
+	if err := storage.UpdateDataKey(vk, instance, v, client); err != nil {
+		return nil, err
+	}
+	if tombstone {
+		vk[len(vk)-1] = storage.MarkTombstone
+	} else {
+		vk[len(vk)-1] = storage.MarkData
+	}
+	return vk, nil
+}
+
+// relevantVersion returns the version whose value applies to the context's version or
+// 0 if there is no value, e.g., the closest ancestor has a tombstone.
+func (p *packedValue) relevantVersion(vctx storage.VersionedCtx, tk storage.TKey) (dvid.VersionID, error) {
+	kvs := make([]*storage.KeyValue, len(p.versions))
+	for i, hv := range p.versions {
+		var k storage.Key
+		if hv > 0 {
+			k = vctx.ConstructKeyVersion(tk, dvid.VersionID(hv))
+		} else {
+			k = vctx.TombstoneKeyVersion(tk, dvid.VersionID(-hv))
+		}
+		kvs[i] = &storage.KeyValue{K: k}
+	}
+	kv, err := vctx.VersionedKeyValue(kvs)
+	if err != nil || kv == nil {
+		return 0, err
+	}
+	return vctx.VersionFromKey(kv.K)
+}